@@ -0,0 +1,153 @@
+// Command trusttls-agent keeps the certificates a trusttls server issued
+// for this machine installed. It only fetches, installs and reloads, and
+// leaves out the CLI and the issuance code, so it is small enough for
+// embedded devices and containers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/agent"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+const usage = `trusttls-agent keeps certificates from a trusttls server installed.
+
+Usage:
+  trusttls-agent join --server <url> --join-token <token> [--ca-bundle <pem>]
+  trusttls-agent sync [--force]
+  trusttls-agent run
+
+The certificates to install and where are listed in %s.
+Set TRUSTTLS_DIR to keep the agent's files somewhere other than ~/.trusttls.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage, agent.ConfigPath(store.DefaultBaseDir()))
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "join":
+		err = join(os.Args[2:])
+	case "sync":
+		err = sync(os.Args[2:])
+	case "run":
+		err = run(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Printf(usage, agent.ConfigPath(store.DefaultBaseDir()))
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n"+usage, os.Args[1], agent.ConfigPath(store.DefaultBaseDir()))
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// join enrolls this machine with a server, like trusttls agent join.
+func join(args []string) error {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	server := fs.String("server", "", "https:// URL of the trusttls server")
+	token := fs.String("join-token", "", "One-time join token from 'trusttls agent token'")
+	caBundle := fs.String("ca-bundle", "", "PEM file with roots to trust for the server, such as its api ca.pem")
+	fs.Parse(args)
+	if *server == "" || *token == "" {
+		return fmt.Errorf("--server and --join-token are required")
+	}
+	baseDir, err := openStore()
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	id, err := apiclient.Join(baseDir, *server, *caBundle, *token, strings.ToLower(host))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🤝 Joined %s as agent %s\n", id.Server, id.Name)
+	fmt.Printf("🔐 Identity saved in: %s\n", id.Dir)
+	fmt.Printf("💡 List the certificates to install in %s, then run trusttls-agent sync\n", agent.ConfigPath(baseDir))
+	return nil
+}
+
+// sync installs every certificate that changed since the last run, once.
+func sync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	force := fs.Bool("force", false, "Install every certificate again, even when it did not change")
+	fs.Parse(args)
+	id, cfg, err := load()
+	if err != nil {
+		return err
+	}
+	return agent.Sync(store.DefaultBaseDir(), id, cfg, *force)
+}
+
+// run syncs at the config's interval until it is stopped. Failed syncs are
+// retried at the next interval, so the agent outlives a server outage.
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Parse(args)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	for {
+		id, cfg, err := load()
+		if err != nil {
+			return err
+		}
+		if err := agent.Sync(store.DefaultBaseDir(), id, cfg, false); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(cfg.Period()):
+		}
+	}
+}
+
+// load returns the identity and config of this agent.
+func load() (*apiclient.Identity, *agent.Config, error) {
+	baseDir, err := openStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := apiclient.LoadIdentity(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == nil {
+		return nil, nil, fmt.Errorf("this machine has not joined a server; run trusttls-agent join")
+	}
+	cfg, err := agent.LoadConfig(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return id, cfg, nil
+}
+
+// openStore returns the store's directory once it is in a format this
+// build can work with; see store.OpenFormat.
+func openStore() (string, error) {
+	baseDir := store.DefaultBaseDir()
+	if err := store.CheckOwner(baseDir); err != nil {
+		return "", err
+	}
+	up, err := store.OpenFormat(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if up != nil {
+		fmt.Printf("📦 Upgraded %s from store format %d to %d; the store as it was is kept in %s\n", baseDir, up.From, up.To, up.Backup)
+	}
+	return baseDir, nil
+}
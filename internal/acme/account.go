@@ -0,0 +1,136 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// accountDir is where the ACME account for email on server is kept:
+// <base>/accounts/acme/<server host>/<email>. CAs that serve one directory
+// per certificate profile from the same host (Sectigo) need an account per
+// directory, so a path other than /directory is added to the host, unless
+// an account stored under the host alone by an older version exists.
+func accountDir(baseDir, server, email string) string {
+	host, path := server, ""
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		host, path = u.Host, strings.Trim(u.Path, "/")
+	}
+	host = strings.ReplaceAll(host, ":", "_")
+	legacy := filepath.Join(baseDir, "accounts", "acme", host, email)
+	if path == "" || path == "directory" {
+		return legacy
+	}
+	dir := filepath.Join(baseDir, "accounts", "acme", host+"_"+strings.ReplaceAll(path, "/", "_"), email)
+	if _, err := os.Stat(filepath.Join(dir, "account.key")); err != nil {
+		if _, err := os.Stat(filepath.Join(legacy, "account.key")); err == nil {
+			return legacy
+		}
+	}
+	return dir
+}
+
+// HasAccount reports whether an ACME account for email on server was
+// registered before, so no External Account Binding is needed to use it.
+func HasAccount(baseDir, server, email string) bool {
+	return loadRegistration(accountDir(baseDir, server, email)) != nil
+}
+
+// ImportAccount stores key as the ACME account for email on server, which
+// another client registered at uri, so orders use it instead of registering
+// a new account. An account already stored for them is kept; ImportAccount
+// reports whether key was stored.
+func ImportAccount(baseDir, server, email string, key crypto.PrivateKey, uri string) (bool, error) {
+	dir := accountDir(baseDir, server, email)
+	if _, err := os.Stat(filepath.Join(dir, "account.key")); err == nil {
+		return false, nil
+	}
+	pemBytes, err := MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+	if err := seal.WriteFile(filepath.Join(dir, "account.key"), pemBytes, 0600); err != nil {
+		return false, err
+	}
+	reg := &registration.Resource{URI: uri, Body: acme.Account{Status: acme.StatusValid, Contact: []string{"mailto:" + email}}}
+	return true, saveRegistration(dir, reg)
+}
+
+// loadOrCreateAccountKey returns the stored account key, generating and
+// saving one on first use. The boolean reports whether the key already existed.
+func loadOrCreateAccountKey(dir, keyType string, keySize int) (crypto.PrivateKey, bool, error) {
+	path := filepath.Join(dir, "account.key")
+	if b, err := seal.ReadFile(path); err == nil {
+		key, err := certcrypto.ParsePEMPrivateKey(b)
+		return key, true, err
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	key, err := GenerateKey(keyType, keySize)
+	if err != nil {
+		return nil, false, err
+	}
+	pemBytes, err := MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, false, err
+	}
+	return key, false, seal.WriteFile(path, pemBytes, 0600)
+}
+
+func loadRegistration(dir string) *registration.Resource {
+	b, err := os.ReadFile(filepath.Join(dir, "registration.json"))
+	if err != nil {
+		return nil
+	}
+	var reg registration.Resource
+	if json.Unmarshal(b, &reg) != nil || reg.URI == "" {
+		return nil
+	}
+	return &reg
+}
+
+func saveRegistration(dir string, reg *registration.Resource) error {
+	b, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "registration.json"), b, 0600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,118 @@
+package acme
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Capabilities describes what a CA will issue, so requests it would refuse
+// are rejected with a precise message before any network call.
+type Capabilities struct {
+	CA string // name used in messages
+	// Wildcard is set when the CA issues wildcard certificates at all;
+	// WildcardHTTP when it does so without DNS-01, because names are
+	// validated in advance in the CA's portal.
+	Wildcard     bool
+	WildcardHTTP bool
+	IPAddresses  bool // issues RFC 8738 IP address certificates
+	EAB          bool // accounts must be bound with an EAB key ID and HMAC key
+	MaxNames     int  // names per certificate; 0 when not known
+	// KeySizes lists the certificate key sizes the CA accepts by key type
+	// (rsa, ecdsa); nil when it takes any key trusttls generates.
+	KeySizes map[string][]int
+}
+
+var (
+	// WebPKIKeys are the keys public CAs issue certificates for under the
+	// CA/Browser Forum rules as they apply them; P-521 is allowed there but
+	// rarely accepted.
+	WebPKIKeys = map[string][]int{KeyRSA: {2048, 3072, 4096}, KeyECDSA: {256, 384}}
+
+	letsEncryptCaps = Capabilities{
+		CA:       "Let's Encrypt",
+		Wildcard: true,
+		// Let's Encrypt only issues IP address certificates under its
+		// short-lived profile, which trusttls does not request.
+		IPAddresses: false,
+		MaxNames:    100,
+		KeySizes:    WebPKIKeys,
+	}
+	digiCertCaps = Capabilities{
+		CA:           "DigiCert ACME",
+		Wildcard:     true,
+		WildcardHTTP: true,
+		EAB:          true,
+		MaxNames:     250,
+		KeySizes:     WebPKIKeys,
+	}
+	// otherCaps is assumed for directories trusttls knows nothing about,
+	// such as step-ca; the CA has the last word.
+	otherCaps = Capabilities{
+		CA:          "the ACME server",
+		Wildcard:    true,
+		IPAddresses: true,
+	}
+)
+
+// CapabilitiesFor returns what the CA behind provider (letsencrypt, digicert
+// or a preset name) and the directory URL server can issue. An empty
+// provider is guessed from server.
+func CapabilitiesFor(provider, server string) Capabilities {
+	if p, ok := LookupPreset(provider); ok {
+		return p.Capabilities
+	}
+	if strings.EqualFold(provider, "digicert") {
+		return digiCertCaps
+	}
+	if server == "" {
+		return letsEncryptCaps
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return otherCaps
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "letsencrypt.org" || strings.HasSuffix(host, ".letsencrypt.org"):
+		return letsEncryptCaps
+	case host == "digicert.com" || strings.HasSuffix(host, ".digicert.com"):
+		return digiCertCaps
+	}
+	for _, name := range PresetNames() {
+		p := presets[name]
+		for _, dir := range p.Profiles {
+			if strings.EqualFold(strings.TrimSuffix(server, "/"), dir) {
+				return p.Capabilities
+			}
+		}
+	}
+	return otherCaps
+}
+
+// Check returns why the CA cannot issue one certificate for domains
+// validated with method ("http-01" or "dns-01"), or nil.
+func (c Capabilities) Check(domains []string, method string) error {
+	if c.MaxNames > 0 && len(domains) > c.MaxNames {
+		return fmt.Errorf("%s allows at most %d names per certificate, not %d; split them over several certificates", c.CA, c.MaxNames, len(domains))
+	}
+	for _, d := range domains {
+		switch {
+		case strings.HasPrefix(d, "*."):
+			if !c.Wildcard {
+				return fmt.Errorf("%s is a wildcard: %s does not issue wildcard certificates", d, c.CA)
+			}
+			if method != "dns-01" && !c.WildcardHTTP {
+				return fmt.Errorf("%s is a wildcard: %s only validates wildcards with DNS-01; add --dns <provider>", d, c.CA)
+			}
+		case IsIP(d):
+			if !c.IPAddresses {
+				return fmt.Errorf("%s is an IP address: %s does not issue IP address certificates; use --server with a CA that does", d, c.CA)
+			}
+			if method == "dns-01" {
+				return fmt.Errorf("%s is an IP address: IP address certificates are validated over HTTP, drop --dns", d)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxChainFetches bounds how many intermediates CompleteChain downloads for
+// one certificate.
+const maxChainFetches = 4
+
+// maxIssuerSize bounds the size of a certificate downloaded from an AIA URL.
+const maxIssuerSize = 1 << 20
+
+// ChainFetch is an intermediate CompleteChain added, and where it came from.
+type ChainFetch struct {
+	Subject string
+	URL     string
+}
+
+// CompleteChain checks that the PEM certificate certPEM and its chain
+// chainPEM lead to a root: one of roots (the system roots when nil), or a
+// self-signed certificate in the chain, as private CAs send. When an
+// issuer is missing, it is downloaded from the CA Issuers URL in the
+// Authority Information Access extension of the certificate it issued,
+// following the chain upwards. It returns the chain with the downloaded
+// intermediates appended, which ones were added, and an error when the
+// chain is still incomplete; the chain returned is then the best one found.
+func CompleteChain(certPEM, chainPEM []byte, roots *x509.CertPool) ([]byte, []ChainFetch, error) {
+	leaf, err := firstCertificate(certPEM)
+	if err != nil {
+		return chainPEM, nil, err
+	}
+	chain := parseCertificates(chainPEM)
+	if roots == nil {
+		if roots, err = x509.SystemCertPool(); err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+	}
+	var fetched []ChainFetch
+	cur := leaf
+	for {
+		if chainVerifies(leaf, chain, roots) {
+			break
+		}
+		if isSelfSigned(cur) {
+			break
+		}
+		if issuer := issuerIn(cur, chain); issuer != nil {
+			cur = issuer
+			continue
+		}
+		if len(fetched) == maxChainFetches {
+			return encodeChain(chainPEM, fetched, chain), fetched, fmt.Errorf("chain of %s still incomplete after %d downloads", leaf.Subject.CommonName, maxChainFetches)
+		}
+		issuer, url, err := fetchIssuer(cur)
+		if err != nil {
+			return encodeChain(chainPEM, fetched, chain), fetched, fmt.Errorf("chain of %s is incomplete: the issuer of %s is missing (%w)", leaf.Subject.CommonName, cur.Subject, err)
+		}
+		if isSelfSigned(issuer) {
+			// Roots are not sent; one that is not trusted here is the
+			// client's business
+			break
+		}
+		chain = append(chain, issuer)
+		fetched = append(fetched, ChainFetch{Subject: issuer.Subject.String(), URL: url})
+		cur = issuer
+	}
+	return encodeChain(chainPEM, fetched, chain), fetched, nil
+}
+
+// chainVerifies reports whether leaf leads to one of roots through chain.
+func chainVerifies(leaf *x509.Certificate, chain []*x509.Certificate, roots *x509.CertPool) bool {
+	inter := x509.NewCertPool()
+	for _, c := range chain {
+		inter.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inter, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err == nil
+}
+
+// issuerIn returns the certificate in chain that signed c, if any.
+func issuerIn(c *x509.Certificate, chain []*x509.Certificate) *x509.Certificate {
+	for _, p := range chain {
+		if !p.Equal(c) && bytes.Equal(c.RawIssuer, p.RawSubject) && c.CheckSignatureFrom(p) == nil {
+			return p
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+// fetchIssuer downloads the issuer of c from its AIA CA Issuers URLs and
+// checks that it signed c.
+func fetchIssuer(c *x509.Certificate) (*x509.Certificate, string, error) {
+	if len(c.IssuingCertificateURL) == 0 {
+		return nil, "", errors.New("it names no CA Issuers URL")
+	}
+	var errs []string
+	for _, url := range c.IssuingCertificateURL {
+		issuer, err := downloadCertificate(url)
+		if err == nil {
+			err = c.CheckSignatureFrom(issuer)
+		}
+		if err == nil {
+			return issuer, url, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return nil, "", errors.New(strings.Join(errs, "; "))
+}
+
+// downloadCertificate fetches one DER or PEM certificate from url, going
+// through the configured proxy.
+func downloadCertificate(url string) (*x509.Certificate, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported URL scheme")
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxIssuerSize))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	return x509.ParseCertificate(b)
+}
+
+// firstCertificate parses the first certificate in PEM data.
+func firstCertificate(b []byte) (*x509.Certificate, error) {
+	certs := parseCertificates(b)
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate in PEM data")
+	}
+	return certs[0], nil
+}
+
+// parseCertificates parses every certificate in PEM data, skipping blocks
+// that are not certificates or do not parse.
+func parseCertificates(b []byte) []*x509.Certificate {
+	var out []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return out
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			out = append(out, c)
+		}
+	}
+}
+
+// encodeChain returns chainPEM with the certificates in fetched, the last
+// len(fetched) of chain, appended.
+func encodeChain(chainPEM []byte, fetched []ChainFetch, chain []*x509.Certificate) []byte {
+	if len(fetched) == 0 {
+		return chainPEM
+	}
+	out := append([]byte{}, chainPEM...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	for _, c := range chain[len(chain)-len(fetched):] {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package acme
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ClockSkewEnv sets how far the system clock may be off before orders are
+// refused, e.g. TRUSTTLS_CLOCK_SKEW=10m, or turns the check off with "off".
+const ClockSkewEnv = "TRUSTTLS_CLOCK_SKEW"
+
+const (
+	// DefaultClockSkew is how far off the clock may be by default. CAs
+	// backdate certificates by about an hour, but nonces, authorizations and
+	// OCSP responses leave less room, and a clock minutes off is broken.
+	DefaultClockSkew = 5 * time.Minute
+	// clockWarn is the skew worth a warning while orders still go ahead.
+	clockWarn = 30 * time.Second
+	// ntpServer is asked when the ACME server sends no usable Date header.
+	ntpServer = "pool.ntp.org:123"
+)
+
+// ClockSkewError is returned when the system clock is too far off to order
+// certificates.
+type ClockSkewError struct {
+	Skew   time.Duration // positive when the system clock is ahead
+	Source string        // what the clock was compared with
+	Limit  time.Duration
+}
+
+func (e *ClockSkewError) Error() string {
+	direction := "ahead of"
+	if e.Skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Sprintf("the system clock is %s %s %s (more than the %s allowed). "+
+		"ACME requests and new certificates are checked against the time, so a wrong clock shows up as "+
+		"JWS verification, badNonce or \"certificate not yet valid\" errors; fix the clock, then try again:\n%s"+
+		"Set %s=off to skip this check, or e.g. %s=15m to allow more.",
+		abs(e.Skew).Round(time.Second), direction, e.Source, e.Limit, clockAdvice(), ClockSkewEnv, ClockSkewEnv)
+}
+
+// clockAdvice returns the commands that set the clock on this OS.
+func clockAdvice() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "  w32tm /resync /force              # as Administrator; start the service first with: net start w32time\n"
+	case "darwin":
+		return "  sudo sntp -sS time.apple.com\n" +
+			"  sudo systemsetup -setusingnetworktime on\n"
+	default:
+		return "  sudo timedatectl set-ntp true     # systemd: keep the clock synced\n" +
+			"  sudo chronyc makestep             # chrony: step the clock now\n" +
+			"  sudo ntpdate -u pool.ntp.org      # without chrony or systemd-timesyncd\n" +
+			"  In containers and VMs, fix the host's clock.\n"
+	}
+}
+
+// clockSkewLimit returns the allowed skew from the environment, or 0 when
+// the check is off.
+func clockSkewLimit() (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(ClockSkewEnv))
+	switch strings.ToLower(v) {
+	case "":
+		return DefaultClockSkew, nil
+	case "off", "0", "false", "no":
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s=%q: want a duration such as 10m, or off", ClockSkewEnv, v)
+	}
+	return d, nil
+}
+
+// checkClock compares the system clock with the Date header of the ACME
+// server, or with NTP when the server sends none, before anything is
+// signed. A clock too far off fails with a ClockSkewError; when neither
+// source answers the check is skipped, since the order itself will tell.
+func checkClock(client *http.Client, server string) error {
+	limit, err := clockSkewLimit()
+	if err != nil || limit == 0 {
+		return err
+	}
+	skew, source, err := MeasureClock(client, server)
+	if err != nil {
+		return nil
+	}
+	if abs(skew) > limit {
+		return &ClockSkewError{Skew: skew, Source: source, Limit: limit}
+	}
+	if abs(skew) > clockWarn {
+		fmt.Printf("⚠️  The system clock is %s off from %s; keep it synced with NTP\n", abs(skew).Round(time.Second), source)
+	}
+	return nil
+}
+
+// MeasureClock returns how far the system clock is ahead of the ACME
+// server at directory (negative when behind) and what it was compared with.
+// The server's Date header has one-second resolution, which is plenty; NTP
+// is asked when there is none.
+func MeasureClock(client *http.Client, directory string) (time.Duration, string, error) {
+	if skew, err := httpDateSkew(client, directory); err == nil {
+		host := directory
+		if u, err := url.Parse(directory); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		return skew, host, nil
+	}
+	skew, err := ntpSkew(ntpServer, 3*time.Second)
+	if err != nil {
+		return 0, "", err
+	}
+	return skew, "NTP (" + strings.TrimSuffix(ntpServer, ":123") + ")", nil
+}
+
+func httpDateSkew(client *http.Client, rawURL string) (time.Duration, error) {
+	sent := time.Now()
+	resp, err := client.Head(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("no Date header")
+	}
+	// Date is truncated to the second: compare with the middle of it
+	mid := sent.Add(received.Sub(sent) / 2)
+	return mid.Sub(date.Add(500 * time.Millisecond)), nil
+}
+
+// ntpEpoch is 1900-01-01, where NTP timestamps start.
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ntpSkew asks server for the time with a single SNTP (RFC 4330) request.
+func ntpSkew(server string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := make([]byte, 48)
+	req[0] = 0x23 // no leap warning, version 4, client mode
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	if _, err := conn.Read(resp); err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if resp[0]&0x07 != 4 || resp[1] == 0 {
+		return 0, errors.New("ntp: not a server reply")
+	}
+	ntpTime := func(b []byte) time.Time {
+		sec := binary.BigEndian.Uint32(b[:4])
+		frac := binary.BigEndian.Uint32(b[4:])
+		return ntpEpoch.Add(time.Duration(sec)*time.Second + time.Duration(uint64(frac)*uint64(time.Second)>>32))
+	}
+	serverReceived, serverSent := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	// The usual offset estimate, with the sign flipped: positive when the
+	// local clock is ahead
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	return -offset, nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,134 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// LoadCSR reads a certificate signing request in PEM or DER form and checks
+// its signature, so a damaged file is caught before an order is placed.
+func LoadCSR(path string) (*x509.CertificateRequest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	der := b
+	if block, _ := pem.Decode(b); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("%s: expected a CERTIFICATE REQUEST, found %s", path, block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%s: bad signature: %w", path, err)
+	}
+	if len(CSRNames(csr)) == 0 {
+		return nil, fmt.Errorf("%s: the request names no domains", path)
+	}
+	return csr, nil
+}
+
+// MustStapleExtension is the TLS Feature extension (RFC 7633) asking for
+// status_request: clients must refuse the certificate unless the server
+// staples an OCSP response.
+var MustStapleExtension = pkix.Extension{
+	Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24},
+	Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}, // SEQUENCE { INTEGER 5 }
+}
+
+// HasMustStaple reports whether exts carry the TLS Feature extension.
+func HasMustStaple(exts []pkix.Extension) bool {
+	for _, e := range exts {
+		if e.Id.Equal(MustStapleExtension.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRForKey returns a CSR for domains signed with the PEM private key
+// keyPEM, so a certificate can be renewed without rotating its key.
+func CSRForKey(keyPEM []byte, domains []string, mustStaple bool) (*x509.CertificateRequest, error) {
+	key, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return newCSR(key, domains, mustStaple)
+}
+
+// newCSR returns a CSR for domains signed with key. The first name is the
+// common name unless it is an IP address, which CAs refuse there; every
+// name is in the SAN extension.
+func newCSR(key crypto.PrivateKey, domains []string, mustStaple bool) (*x509.CertificateRequest, error) {
+	tpl := &x509.CertificateRequest{}
+	if !IsIP(domains[0]) {
+		tpl.Subject.CommonName = domains[0]
+	}
+	if mustStaple {
+		tpl.ExtraExtensions = []pkix.Extension{MustStapleExtension}
+	}
+	for _, d := range domains {
+		if ip := net.ParseIP(d); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, d)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(der)
+}
+
+// CSRNames returns the names a CSR asks for: its common name first, if
+// any, then the DNS and IP subject alternative names, without duplicates.
+func CSRNames(csr *x509.CertificateRequest) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(n string) {
+		n = strings.ToLower(n)
+		if n != "" && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	add(csr.Subject.CommonName)
+	for _, d := range csr.DNSNames {
+		add(d)
+	}
+	for _, ip := range csr.IPAddresses {
+		add(ip.String())
+	}
+	return out
+}
+
+// SameNames reports whether a and b list the same names in any order.
+func SameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string{}, a...)
+	y := append([]string{}, b...)
+	for i := range x {
+		x[i], y[i] = strings.ToLower(x[i]), strings.ToLower(y[i])
+	}
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, ",") == strings.Join(y, ",")
+}
@@ -0,0 +1,125 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/trustctl/trusttls/internal/resolver"
+)
+
+// Delegation is a record that hands a name's DNS-01 challenges to another
+// zone, so the TXT records are written there instead of in the main zone
+// (the "alias" or acme-dns setup). lego follows the CNAME when presenting
+// the challenge.
+type Delegation struct {
+	Domain string   // name on the certificate
+	Name   string   // _acme-challenge.<domain>., where the record goes
+	Type   string   // CNAME or NS
+	Values []string // CNAME target, or the name servers of the delegated zone
+}
+
+// String formats d as zone file lines.
+func (d Delegation) String() string {
+	var lines []string
+	for _, v := range d.Values {
+		lines = append(lines, fmt.Sprintf("%s 300 IN %s %s", d.Name, d.Type, v))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DelegationRecords returns the records that delegate the challenges of
+// domains. With nameservers set, each _acme-challenge name becomes its own
+// zone served by them; otherwise it is a CNAME to target, where a "{domain}"
+// placeholder is replaced by the domain so several names can point at
+// separate records in one zone. Names sharing one target also work, as lego
+// adds each TXT value next to the others. A wildcard shares the record of
+// its base name.
+func DelegationRecords(domains []string, target string, nameservers []string) ([]Delegation, error) {
+	if (target == "") == (len(nameservers) == 0) {
+		return nil, fmt.Errorf("give either a CNAME target or name servers to delegate to")
+	}
+	seen := map[string]bool{}
+	var out []Delegation
+	for _, d := range domains {
+		base := strings.TrimPrefix(strings.ToLower(d), "*.")
+		if IsIP(base) {
+			return nil, fmt.Errorf("%s is an IP address; DNS-01 cannot validate it", d)
+		}
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		rec := Delegation{Domain: base, Name: dns.Fqdn("_acme-challenge." + base)}
+		if len(nameservers) > 0 {
+			rec.Type = "NS"
+			for _, ns := range nameservers {
+				rec.Values = append(rec.Values, dns.Fqdn(strings.ToLower(ns)))
+			}
+			sort.Strings(rec.Values)
+		} else {
+			rec.Type = "CNAME"
+			rec.Values = []string{dns.Fqdn(strings.ReplaceAll(strings.ToLower(target), "{domain}", base))}
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// CheckDelegation confirms through r that d is in place: the CNAME points at
+// its target and the target's zone exists, or the NS records list the
+// expected servers and they answer for the delegated zone.
+func CheckDelegation(ctx context.Context, r *resolver.Resolver, d Delegation) error {
+	switch d.Type {
+	case "CNAME":
+		resp, err := r.Query(ctx, d.Name, dns.TypeCNAME)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, d.Name) {
+				got = append(got, strings.ToLower(c.Target))
+			}
+		}
+		if len(got) == 0 {
+			return fmt.Errorf("%s has no CNAME record yet", d.Name)
+		}
+		if got[0] != d.Values[0] {
+			return fmt.Errorf("%s points at %s, not %s", d.Name, got[0], d.Values[0])
+		}
+		if _, err := r.FindZone(ctx, d.Values[0]); err != nil {
+			return fmt.Errorf("%s is delegated, but its target %s does not resolve: %w", d.Name, d.Values[0], err)
+		}
+	case "NS":
+		resp, err := r.Query(ctx, d.Name, dns.TypeNS)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, rr := range append(resp.Answer, resp.Ns...) {
+			if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, d.Name) {
+				got = append(got, strings.ToLower(ns.Ns))
+			}
+		}
+		if len(got) == 0 {
+			return fmt.Errorf("%s is not delegated yet (no NS records)", d.Name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(d.Values, " ") {
+			return fmt.Errorf("%s is delegated to %s, not %s", d.Name, strings.Join(got, ", "), strings.Join(d.Values, ", "))
+		}
+		zone, err := r.FindZone(ctx, d.Name)
+		if err != nil {
+			return fmt.Errorf("%s is delegated, but its name servers do not answer for it: %w", d.Name, err)
+		}
+		if !strings.EqualFold(dns.Fqdn(zone), d.Name) {
+			return fmt.Errorf("%s is delegated, but its name servers do not serve it as a zone (SOA found at %s)", d.Name, zone)
+		}
+	default:
+		return fmt.Errorf("unknown delegation type %q", d.Type)
+	}
+	return nil
+}
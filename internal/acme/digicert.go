@@ -5,23 +5,29 @@ package acme
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/interrupt"
 )
 
 type DigiCertProvider struct {
@@ -39,6 +45,9 @@ type DigiCertOrderRequest struct {
 		CSR             string   `json:"csr,omitempty"`
 	} `json:"certificate"`
 	ValidityYears int `json:"validity_years"`
+	Product       string `json:"product,omitempty"`
+	// VerifiedContacts approve EV orders on behalf of the organization.
+	VerifiedContacts []DigiCertContact `json:"verified_contacts,omitempty"`
 }
 
 type DigiCertOrderResponse struct {
@@ -72,19 +81,97 @@ func NewDigiCertProviderImpl(config DigiCertConfig) *DigiCertProvider {
 }
 
 func (p *DigiCertProvider) ObtainCertificate(domains []string) (*certificate.Resource, error) {
+	return p.ObtainCertificateContext(context.Background(), domains)
+}
+
+// ObtainCertificateContext orders a certificate for domains, completes
+// domain control validation and waits for issuance, giving up when ctx is
+// cancelled.
+func (p *DigiCertProvider) ObtainCertificateContext(ctx context.Context, domains []string) (*certificate.Resource, error) {
 	if len(domains) == 0 {
 		return nil, fmt.Errorf("at least one domain required")
 	}
 
+	privateKey, orderID, err := p.resumeOrder(domains)
+	if err != nil {
+		return nil, err
+	}
+	if orderID == "" {
+		if privateKey, orderID, err = p.submitOrder(domains); err != nil {
+			return nil, err
+		}
+	}
+
+	// Handle DCV (Domain Control Validation)
+	if err := p.handleDCV(ctx, orderID, domains); err != nil {
+		return nil, p.keepPending(orderID, fmt.Errorf("failed to handle DCV: %w", err))
+	}
+
+	// Wait for certificate to be issued
+	cert, err := p.waitForCertificate(ctx, orderID)
+	if err != nil {
+		return nil, p.keepPending(orderID, fmt.Errorf("failed to get certificate: %w", err))
+	}
+	if p.config.StateFile != "" {
+		os.Remove(p.config.StateFile)
+	}
+
+	// Marshal private key to PEM
+	privKeyPEM, err := MarshalPrivateKeyToPEM(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate.Resource{
+		Domain:            domains[0],
+		Certificate:       []byte(cert.ServerCert),
+		PrivateKey:        privKeyPEM,
+		IssuerCertificate: []byte(cert.IntermediateCert),
+	}, nil
+}
+
+// resumeOrder returns the key and ID of the order saved in the state file
+// for the same names, or an empty ID when there is none.
+func (p *DigiCertProvider) resumeOrder(domains []string) (crypto.Signer, string, error) {
+	if p.config.StateFile == "" {
+		return nil, "", nil
+	}
+	pending, err := LoadDigiCertOrder(p.config.StateFile)
+	if err != nil || pending == nil {
+		return nil, "", err
+	}
+	if strings.Join(pending.Domains, ",") != strings.Join(domains, ",") {
+		fmt.Printf("Order %s was for %s; placing a new order\n", pending.OrderID, strings.Join(pending.Domains, ", "))
+		return nil, "", nil
+	}
+	key, err := certcrypto.ParsePEMPrivateKey([]byte(pending.KeyPEM))
+	if err != nil {
+		return nil, "", fmt.Errorf("pending order %s: %w", pending.OrderID, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, "", fmt.Errorf("pending order %s: unexpected key type", pending.OrderID)
+	}
+	fmt.Printf("Resuming DigiCert order %s submitted %s\n", pending.OrderID, pending.Submitted.Format(time.RFC1123))
+	return signer, pending.OrderID, nil
+}
+
+// submitOrder places a new order for domains and saves it to the state file.
+func (p *DigiCertProvider) submitOrder(domains []string) (crypto.Signer, string, error) {
 	// Generate private key and CSR
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	keyType, keySize, err := NormalizeKey(p.config.KeyType, p.config.KeySize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
+		return nil, "", err
 	}
+	key, err := GenerateKey(keyType, keySize)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate private key: %w", err)
+	}
+	privateKey := key.(crypto.Signer)
 
 	csr, err := p.generateCSR(domains[0], domains, privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate CSR: %w", err)
+		return nil, "", fmt.Errorf("failed to generate CSR: %w", err)
 	}
 
 	// Create order with CSR
@@ -94,46 +181,77 @@ func (p *DigiCertProvider) ObtainCertificate(domains []string) (*certificate.Res
 	orderReq.Certificate.CommonName = domains[0]
 	orderReq.Certificate.DNSNames = domains
 	orderReq.Certificate.SignatureHash = "sha256"
-	orderReq.Certificate.KeySize = 2048
+	orderReq.Certificate.KeySize = keySize
 	orderReq.Certificate.CSR = csr
 	if p.config.OrganizationID != "" {
 		orderReq.Certificate.OrganizationID = p.config.OrganizationID
 	}
+	if v := p.config.Validation; v != "" {
+		product, ok := DigiCertValidations[v]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown validation level %q (ov or ev)", v)
+		}
+		if p.config.OrganizationID == "" {
+			return nil, "", fmt.Errorf("%s orders need an organization ID", strings.ToUpper(v))
+		}
+		orderReq.Product = product
+	}
+	if p.config.Validation == "ev" {
+		if p.config.ContactID == "" {
+			return nil, "", fmt.Errorf("EV orders need a verified contact ID")
+		}
+		orderReq.VerifiedContacts = []DigiCertContact{{ID: p.config.ContactID}}
+	}
 
 	orderResp, err := p.createOrder(orderReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create order: %w", err)
+		return nil, "", fmt.Errorf("failed to create order: %w", err)
 	}
+	fmt.Printf("Submitted DigiCert order %s\n", orderResp.OrderID)
 
-	// Handle DCV (Domain Control Validation)
-	if err := p.handleDCV(orderResp.OrderID, domains[0]); err != nil {
-		return nil, fmt.Errorf("failed to handle DCV: %w", err)
+	if p.config.StateFile != "" {
+		keyPEM, err := MarshalPrivateKeyToPEM(privateKey)
+		if err != nil {
+			return nil, "", err
+		}
+		err = SaveDigiCertOrder(p.config.StateFile, DigiCertPendingOrder{
+			OrderID:    orderResp.OrderID,
+			Domains:    domains,
+			Validation: p.config.Validation,
+			Submitted:  time.Now(),
+			KeyPEM:     string(keyPEM),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("save pending order %s: %w", orderResp.OrderID, err)
+		}
 	}
+	return privateKey, orderResp.OrderID, nil
+}
 
-	// Wait for certificate to be issued
-	cert, err := p.waitForCertificate(orderResp.OrderID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get certificate: %w", err)
+// keepPending explains how to resume an order that is still open when err
+// stopped the wait, and forgets orders DigiCert has closed.
+func (p *DigiCertProvider) keepPending(orderID string, err error) error {
+	if p.config.StateFile == "" {
+		return err
 	}
-
-	// Marshal private key to PEM
-	privKeyPEM := MarshalPrivateKeyToPEM(privateKey)
-
-	return &certificate.Resource{
-		Domain:            domains[0],
-		Certificate:       []byte(cert.ServerCert),
-		PrivateKey:        privKeyPEM,
-		IssuerCertificate: []byte(cert.IntermediateCert),
-	}, nil
+	if errors.Is(err, errOrderClosed) {
+		os.Remove(p.config.StateFile)
+		return err
+	}
+	return fmt.Errorf("%w; order %s stays open, run the same command again to resume it", err, orderID)
 }
 
-func (p *DigiCertProvider) generateCSR(commonName string, dnsNames []string, privateKey *rsa.PrivateKey) (string, error) {
+func (p *DigiCertProvider) generateCSR(commonName string, dnsNames []string, privateKey crypto.Signer) (string, error) {
+	// The signature algorithm follows the key: SHA-256 with RSA, or the
+	// hash matching the ECDSA curve
 	template := x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName: commonName,
 		},
 		DNSNames:    dnsNames,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if p.config.MustStaple {
+		template.ExtraExtensions = []pkix.Extension{MustStapleExtension}
 	}
 
 	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
@@ -183,67 +301,260 @@ func (p *DigiCertProvider) createOrder(req DigiCertOrderRequest) (*DigiCertOrder
 	return &orderResp, nil
 }
 
-func (p *DigiCertProvider) handleDCV(orderID, domain string) error {
-	// Get DCV details
-	httpReq, err := http.NewRequest("GET", p.config.ServerURL+"/certificates/"+orderID+"/dcv", nil)
+// dcvFile is where DigiCert fetches the HTTP token from.
+const dcvFile = ".well-known/pki-validation/fileauth.txt"
+
+// dcvTXTLabel is prefixed to each domain for the DNS token's TXT record.
+const dcvTXTLabel = "_dnsauth."
+
+// handleDCV proves control of domains for the order: it publishes the order's
+// token in the webroot (HTTP) or as TXT records (DNS), asks DigiCert to check
+// it and polls until the check passes. The token is removed afterwards.
+func (p *DigiCertProvider) handleDCV(ctx context.Context, orderID string, domains []string) error {
+	methods, err := p.getDCV(orderID)
 	if err != nil {
 		return err
 	}
+	if allValidated(methods) {
+		// Names validated earlier for the organization need no token.
+		return nil
+	}
+
+	kind := "http"
+	if p.config.DNSPlugin != "" {
+		kind = "dns"
+	}
+	var dcv *DigiCertDCVMethod
+	for i := range methods {
+		if methods[i].Type == kind {
+			dcv = &methods[i]
+			break
+		}
+	}
+	if dcv == nil {
+		return fmt.Errorf("%s DCV method not offered for order %s", kind, orderID)
+	}
+
+	switch {
+	case kind == "dns":
+		recorder, err := dnsprovider.NewTXT(p.config.DNSPlugin, p.config.DNSCredentials)
+		if err != nil {
+			return err
+		}
+		for _, d := range dcvDomains(domains) {
+			fqdn := dcvTXTLabel + d
+			done := interrupt.OnInterrupt("DCV record "+fqdn, func() error { return recorder.RemoveTXT(fqdn, dcv.Token) })
+			defer done()
+			if err := recorder.SetTXT(fqdn, dcv.Token); err != nil {
+				return fmt.Errorf("publish DCV token for %s: %w", d, err)
+			}
+			defer recorder.RemoveTXT(fqdn, dcv.Token)
+		}
+	case p.config.Webroot != "":
+		for _, d := range domains {
+			if strings.HasPrefix(d, "*.") {
+				return fmt.Errorf("%s is a wildcard: DigiCert only validates wildcards with the DNS token; set a DNS provider", d)
+			}
+		}
+		path := filepath.Join(p.config.Webroot, dcvFile)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		done := interrupt.OnInterrupt("DCV file "+path, func() error { return os.Remove(path) })
+		defer done()
+		if err := os.WriteFile(path, []byte(dcv.Token), 0644); err != nil {
+			return fmt.Errorf("write DCV token: %w", err)
+		}
+		defer os.Remove(path)
+	default:
+		// Nothing to automate with: wait for someone to place the token.
+		fmt.Printf("DCV token: %s\n", dcv.Token)
+		fmt.Printf("Serve it at http://<domain>/%s, or publish it as a TXT record at %s<domain>\n", dcvFile, dcvTXTLabel)
+	}
+
+	return waitForOrder(ctx, orderID, p.config.OrderWait, func() error {
+		if err := p.checkDCV(orderID); err != nil {
+			return err
+		}
+		methods, err := p.getDCV(orderID)
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			if m.Type != kind {
+				continue
+			}
+			switch m.Status {
+			case "complete", "validated":
+				return nil
+			case "failed", "expired":
+				return fmt.Errorf("DigiCert %s validation %s", kind, m.Status)
+			}
+		}
+		return errOrderPending
+	})
+}
+
+// dcvDomains returns the names a DNS token must be published for: wildcards
+// are validated on their base domain, and each name once.
+func dcvDomains(domains []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, d := range domains {
+		d = strings.TrimPrefix(d, "*.")
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func allValidated(methods []DigiCertDCVMethod) bool {
+	for _, m := range methods {
+		if m.Status == "complete" || m.Status == "validated" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DigiCertProvider) getDCV(orderID string) ([]DigiCertDCVMethod, error) {
+	httpReq, err := http.NewRequest("GET", p.config.ServerURL+"/certificates/"+orderID+"/dcv", nil)
+	if err != nil {
+		return nil, err
+	}
 
 	p.signRequest(httpReq, nil)
 	resp, err := p.client.Do(httpReq)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to get DCV details: %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to get DCV details: %d", resp.StatusCode)
 	}
 
 	var dcvMethods []DigiCertDCVMethod
 	if err := json.NewDecoder(resp.Body).Decode(&dcvMethods); err != nil {
-		return err
+		return nil, err
 	}
+	return dcvMethods, nil
+}
 
-	// Find HTTP-01 DCV method
-	var httpDCV *DigiCertDCVMethod
-	for _, method := range dcvMethods {
-		if method.Type == "http" {
-			httpDCV = &method
-			break
-		}
+// checkDCV asks DigiCert to look for the token now rather than on its own
+// schedule.
+func (p *DigiCertProvider) checkDCV(orderID string) error {
+	httpReq, err := http.NewRequest("PUT", p.config.ServerURL+"/certificates/"+orderID+"/check-dcv", nil)
+	if err != nil {
+		return err
 	}
 
-	if httpDCV == nil {
-		return fmt.Errorf("HTTP DCV method not available")
+	p.signRequest(httpReq, nil)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	// For HTTP-01, we would typically place a file at .well-known/acme-challenge/
-	// Since this is DigiCert, the token might be different
-	fmt.Printf("DCV token: %s\n", httpDCV.Token)
-	fmt.Printf("Please place this token in your webroot for domain validation\n")
-
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to trigger DCV check: %d, body: %s", resp.StatusCode, string(body))
+	}
 	return nil
 }
 
-func (p *DigiCertProvider) waitForCertificate(orderID string) (*DigiCertCertificate, error) {
-	for i := 0; i < 30; i++ { // Wait up to 5 minutes
+func (p *DigiCertProvider) waitForCertificate(ctx context.Context, orderID string) (*DigiCertCertificate, error) {
+	var issued *DigiCertCertificate
+	reported := map[string]string{}
+	err := waitForOrder(ctx, orderID, p.config.OrderWait, func() error {
 		cert, err := p.getCertificate(orderID)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if cert.Status == "issued" {
-			return cert, nil
-		} else if cert.Status == "failed" {
-			return nil, fmt.Errorf("certificate issuance failed")
+		switch cert.Status {
+		case "issued":
+			issued = cert
+			return nil
+		case "failed", "rejected", "revoked":
+			return fmt.Errorf("certificate issuance %s: %w", cert.Status, errOrderClosed)
+		}
+		if p.config.Validation != "" {
+			p.reportValidations(orderID, reported)
+		}
+		return errOrderPending
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// errOrderClosed marks orders DigiCert will not issue, which cannot be
+// resumed.
+var errOrderClosed = errors.New("order closed")
+
+// reportValidations prints each validation DigiCert is still working on
+// whenever its status changes, so the operator knows who has to act.
+func (p *DigiCertProvider) reportValidations(orderID string, reported map[string]string) {
+	validations, err := p.Validations(orderID)
+	if err != nil {
+		return
+	}
+	for _, v := range validations {
+		if reported[v.Type] == v.Status {
+			continue
+		}
+		reported[v.Type] = v.Status
+		if v.Done() {
+			fmt.Printf("✅ %s validation complete\n", v.Type)
+		} else {
+			fmt.Printf("⏳ %s validation %s: %s\n", v.Type, v.Status, v.Description)
 		}
+	}
+}
+
+// Validations returns the checks DigiCert runs for the order, with their
+// status and what is needed to complete them.
+func (p *DigiCertProvider) Validations(orderID string) ([]DigiCertValidation, error) {
+	var out []DigiCertValidation
+	err := p.getJSON("/certificates/"+orderID+"/validation", &out)
+	return out, err
+}
+
+// Organizations returns the organizations of the CertCentral account.
+func (p *DigiCertProvider) Organizations() ([]DigiCertOrganization, error) {
+	var out []DigiCertOrganization
+	err := p.getJSON("/organizations", &out)
+	return out, err
+}
+
+// Contacts returns the contacts of an organization.
+func (p *DigiCertProvider) Contacts(orgID string) ([]DigiCertContact, error) {
+	var out []DigiCertContact
+	err := p.getJSON("/organizations/"+orgID+"/contacts", &out)
+	return out, err
+}
 
-		time.Sleep(10 * time.Second)
+func (p *DigiCertProvider) getJSON(path string, out interface{}) error {
+	httpReq, err := http.NewRequest("GET", p.config.ServerURL+path, nil)
+	if err != nil {
+		return err
 	}
 
-	return nil, fmt.Errorf("timeout waiting for certificate")
+	p.signRequest(httpReq, nil)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status code: %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
 func (p *DigiCertProvider) getCertificate(orderID string) (*DigiCertCertificate, error) {
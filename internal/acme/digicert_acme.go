@@ -6,8 +6,6 @@ package acme
 import (
 	"crypto"
 	"fmt"
-	"net/http"
-	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/challenge/http01"
@@ -34,12 +32,9 @@ func NewDigiCertACMEProviderImpl(opts DigiCertEABConfig) (*DigiCertACMEProvider,
 	if opts.EABKID == "" || opts.EABHMACKey == "" {
 		return nil, fmt.Errorf("EAB KID and HMAC key required")
 	}
-	if opts.KeyType == "" { opts.KeyType = "rsa" }
-	if opts.KeySize == 0 { 
-		if opts.KeyType == "rsa" { opts.KeySize = 2048 } else { opts.KeySize = 256 } 
-	}
+	if err := digiCertCaps.CheckKey(opts.KeyType, opts.KeySize); err != nil { return nil, err }
 
-	priv, err := generateKey(opts.KeyType, opts.KeySize)
+	priv, err := GenerateKey(opts.KeyType, opts.KeySize)
 	if err != nil { return nil, err }
 	
 	user := &digicertUser{ Email: opts.Email, key: priv }
@@ -47,7 +42,12 @@ func NewDigiCertACMEProviderImpl(opts DigiCertEABConfig) (*DigiCertACMEProvider,
 	config := lego.NewConfig(user)
 	config.CADirURL = opts.ServerURL
 	config.UserAgent = "trusttls/1.0"
-	config.HTTPClient = &http.Client{ Timeout: 30 * time.Second }
+	caBundle := ""
+	if opts.BaseDir != "" {
+		if caBundle, err = caBundleFor(opts.BaseDir, opts.ServerURL, opts.Email, ""); err != nil { return nil, err }
+	}
+	if config.HTTPClient, err = newHTTPClient(caBundle); err != nil { return nil, err }
+	if err := checkClock(config.HTTPClient, opts.ServerURL); err != nil { return nil, err }
 
 	client, err := lego.NewClient(config)
 	if err != nil { return nil, err }
@@ -56,14 +56,10 @@ func NewDigiCertACMEProviderImpl(opts DigiCertEABConfig) (*DigiCertACMEProvider,
 		return nil, fmt.Errorf("set http01 provider: %w", err)
 	}
 
-	eab := &registration.ExternalAccountBinding{
-		KID:     opts.EABKID,
-		HMACKey: opts.EABHMACKey,
-	}
-
-	reg, err := client.Registration.RegisterWithEAB(registration.RegisterOptions{
+	reg, err := client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
 		TermsOfServiceAgreed: true,
-		ExternalAccountBinding: eab,
+		Kid:                  opts.EABKID,
+		HmacEncoded:          opts.EABHMACKey,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to register with EAB: %w", err)
@@ -4,8 +4,8 @@
 package acme
 
 // NewDigiCertProvider creates a new DigiCert provider instance
-func NewDigiCertProvider(config DigiCertConfig) interface{} {
-	return NewDigiCertProviderImpl(config)
+func NewDigiCertProvider(config DigiCertConfig) (interface{}, error) {
+	return NewDigiCertProviderImpl(config), nil
 }
 
 // NewDigiCertACMEProvider creates a new DigiCert ACME provider instance
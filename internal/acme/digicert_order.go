@@ -0,0 +1,92 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// DigiCertValidations maps the validation levels trusttls can order to
+// CertCentral product IDs. An empty level orders the account's default
+// product.
+var DigiCertValidations = map[string]string{
+	"ov": "ssl_basic",
+	"ev": "ssl_ev_basic",
+}
+
+// DigiCertPendingOrder is a submitted CertCentral order kept on disk until
+// it is issued. OV and EV orders can wait days for DigiCert's staff, so
+// running the same command again picks the order up instead of placing a
+// new one.
+type DigiCertPendingOrder struct {
+	OrderID    string    `json:"order_id"`
+	Domains    []string  `json:"domains"`
+	Validation string    `json:"validation,omitempty"`
+	Submitted  time.Time `json:"submitted"`
+	KeyPEM     string    `json:"key_pem"` // key of the submitted CSR
+}
+
+// DigiCertOrganization is an organization in the CertCentral account and
+// the validations it already holds.
+type DigiCertOrganization struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Validations []DigiCertValidation `json:"validations"`
+}
+
+// DigiCertContact is a person DigiCert can reach about an organization's
+// orders; EV orders need one that DigiCert has verified.
+type DigiCertContact struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	JobTitle string `json:"job_title,omitempty"`
+	Verified bool   `json:"verified,omitempty"`
+}
+
+// DigiCertValidation is one check DigiCert still has to complete for an
+// order, such as organization validation or a verified-contact call.
+type DigiCertValidation struct {
+	Type        string `json:"type"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// Done reports whether DigiCert has completed v.
+func (v DigiCertValidation) Done() bool {
+	return v.Status == "complete" || v.Status == "validated"
+}
+
+// LoadDigiCertOrder returns the pending order saved at path, or nil when
+// there is none.
+func LoadDigiCertOrder(path string) (*DigiCertPendingOrder, error) {
+	b, err := seal.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var o DigiCertPendingOrder
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// SaveDigiCertOrder writes o to path, readable only by the owner since it
+// holds the private key.
+func SaveDigiCertOrder(path string, o DigiCertPendingOrder) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return seal.WriteFile(path, b, 0600)
+}
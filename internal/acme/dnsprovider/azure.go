@@ -0,0 +1,305 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+const (
+	azureManagementAPI = "https://management.azure.com"
+	azureDNSAPIVersion = "2018-05-01"
+	azureIMDS          = "http://169.254.169.254/metadata"
+	azureDefaultTTL    = 60
+)
+
+// azureProvider manages TXT records in Azure DNS. A service principal is used
+// when client_secret is configured; otherwise the VM's managed identity is
+// used through the instance metadata service (client_id selects a
+// user-assigned identity).
+type azureProvider struct {
+	tenantID       string
+	clientID       string
+	clientSecret   string
+	subscriptionID string
+	resourceGroup  string
+	zone           string
+	client         *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newAzure(c Credentials) (challenge.Provider, error) {
+	env := func(v, name string) string {
+		if v != "" {
+			return v
+		}
+		return os.Getenv(name)
+	}
+	p := &azureProvider{
+		tenantID:       env(c.Get("tenant_id", "tenant"), "AZURE_TENANT_ID"),
+		clientID:       env(c.Get("client_id", "sp_client_id"), "AZURE_CLIENT_ID"),
+		clientSecret:   env(c.Get("client_secret", "sp_client_secret"), "AZURE_CLIENT_SECRET"),
+		subscriptionID: env(c.Get("subscription_id", "subscription"), "AZURE_SUBSCRIPTION_ID"),
+		resourceGroup:  env(c.Get("resource_group"), "AZURE_RESOURCE_GROUP"),
+		zone:           c.Get("zone"),
+		client:         &http.Client{Timeout: 30 * time.Second},
+	}
+	if p.clientSecret != "" && (p.tenantID == "" || p.clientID == "") {
+		return nil, errors.New("azure: tenant_id and client_id are required with client_secret")
+	}
+	return p, nil
+}
+
+// Timeout gives Azure DNS name servers time to pick up the record.
+func (p *azureProvider) Timeout() (timeout, interval time.Duration) {
+	return 2 * time.Minute, 5 * time.Second
+}
+
+func (p *azureProvider) Present(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.update(info.EffectiveFQDN, info.Value, true)
+}
+
+func (p *azureProvider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.update(info.EffectiveFQDN, info.Value, false)
+}
+
+func (p *azureProvider) SetTXT(fqdn, value string) error {
+	return p.update(dns01.ToFqdn(fqdn), value, true)
+}
+
+func (p *azureProvider) RemoveTXT(fqdn, value string) error {
+	return p.update(dns01.ToFqdn(fqdn), value, false)
+}
+
+type azureTXTRecordSet struct {
+	Properties struct {
+		TTL        int `json:"TTL"`
+		TXTRecords []struct {
+			Value []string `json:"value"`
+		} `json:"TXTRecords"`
+	} `json:"properties"`
+}
+
+// update merges value into (or removes it from) the record set at fqdn, so
+// apex and wildcard challenges sharing a name can coexist.
+func (p *azureProvider) update(fqdn, value string, add bool) error {
+	zoneID, zoneName, err := p.findZone(fqdn)
+	if err != nil {
+		return err
+	}
+	sub, err := dns01.ExtractSubDomain(fqdn, zoneName)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+	path := zoneID + "/TXT/" + url.PathEscape(sub)
+
+	var current azureTXTRecordSet
+	status, err := p.do(http.MethodGet, path, nil, &current)
+	if err != nil && status != http.StatusNotFound {
+		return fmt.Errorf("azure: get TXT %s: %w", fqdn, err)
+	}
+	var values []string
+	for _, r := range current.Properties.TXTRecords {
+		v := strings.Join(r.Value, "")
+		if v != value {
+			values = append(values, v)
+		}
+	}
+	if add {
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		if _, err := p.do(http.MethodDelete, path, nil, nil); err != nil {
+			return fmt.Errorf("azure: delete TXT %s: %w", fqdn, err)
+		}
+		return nil
+	}
+	var next azureTXTRecordSet
+	next.Properties.TTL = azureDefaultTTL
+	for _, v := range values {
+		next.Properties.TXTRecords = append(next.Properties.TXTRecords, struct {
+			Value []string `json:"value"`
+		}{Value: []string{v}})
+	}
+	if _, err := p.do(http.MethodPut, path, next, nil); err != nil {
+		return fmt.Errorf("azure: put TXT %s: %w", fqdn, err)
+	}
+	return nil
+}
+
+// findZone returns the ARM resource ID and name of the DNS zone serving fqdn.
+// Without a configured resource group every zone in the subscription is
+// searched.
+func (p *azureProvider) findZone(fqdn string) (string, string, error) {
+	if p.subscriptionID == "" {
+		sub, err := p.imdsText("/instance/compute/subscriptionId?api-version=2021-02-01&format=text")
+		if err != nil {
+			return "", "", errors.New("azure: subscription_id not set and not running on an Azure VM")
+		}
+		p.subscriptionID = sub
+	}
+	zoneName := p.zone
+	if zoneName == "" {
+		authZone, err := dns01.FindZoneByFqdn(fqdn)
+		if err != nil {
+			return "", "", fmt.Errorf("azure: find zone for %s: %w", fqdn, err)
+		}
+		zoneName = dns01.UnFqdn(authZone)
+	}
+	if p.resourceGroup != "" {
+		return "/subscriptions/" + p.subscriptionID + "/resourceGroups/" + p.resourceGroup +
+			"/providers/Microsoft.Network/dnsZones/" + zoneName, zoneName, nil
+	}
+	var resp struct {
+		Value []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	path := "/subscriptions/" + p.subscriptionID + "/providers/Microsoft.Network/dnszones"
+	if _, err := p.do(http.MethodGet, path, nil, &resp); err != nil {
+		return "", "", fmt.Errorf("azure: list DNS zones: %w", err)
+	}
+	for _, z := range resp.Value {
+		if strings.EqualFold(z.Name, zoneName) {
+			return z.ID, zoneName, nil
+		}
+	}
+	return "", "", fmt.Errorf("azure: DNS zone %s not found in subscription %s", zoneName, p.subscriptionID)
+}
+
+// do calls the ARM API at path and returns the HTTP status alongside any error.
+func (p *azureProvider) do(method, path string, in, out interface{}) (int, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return 0, err
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, azureManagementAPI+path+"?api-version="+azureDNSAPIVersion, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			return resp.StatusCode, fmt.Errorf("HTTP %d %s: %s", resp.StatusCode, e.Error.Code, e.Error.Message)
+		}
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if out != nil && len(data) > 0 {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+func (p *azureProvider) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && time.Until(p.expires) > time.Minute {
+		return p.token, nil
+	}
+	var (
+		resp *http.Response
+		err  error
+	)
+	if p.clientSecret != "" {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {p.clientID},
+			"client_secret": {p.clientSecret},
+			"scope":         {azureManagementAPI + "/.default"},
+		}
+		resp, err = p.client.PostForm("https://login.microsoftonline.com/"+url.PathEscape(p.tenantID)+"/oauth2/v2.0/token", form)
+	} else {
+		q := url.Values{"api-version": {"2018-02-01"}, "resource": {azureManagementAPI + "/"}}
+		if p.clientID != "" {
+			q.Set("client_id", p.clientID)
+		}
+		req, _ := http.NewRequest(http.MethodGet, azureIMDS+"/identity/oauth2/token?"+q.Encode(), nil)
+		req.Header.Set("Metadata", "true")
+		resp, err = (&http.Client{Timeout: 5 * time.Second}).Do(req)
+		if err != nil {
+			err = fmt.Errorf("no client_secret configured and managed identity endpoint unreachable: %w", err)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("azure: obtain access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("azure: obtain access token: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	// expires_in is a number from Entra ID but a string from IMDS.
+	var t struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("azure: decode token: %w", err)
+	}
+	secs, _ := strconv.Atoi(t.ExpiresIn.String())
+	if secs <= 0 {
+		secs = 300
+	}
+	p.token, p.expires = t.AccessToken, time.Now().Add(time.Duration(secs)*time.Second)
+	return p.token, nil
+}
+
+func (p *azureProvider) imdsText(path string) (string, error) {
+	req, _ := http.NewRequest(http.MethodGet, azureIMDS+path, nil)
+	req.Header.Set("Metadata", "true")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %s: HTTP %d", path, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	return strings.TrimSpace(string(b)), err
+}
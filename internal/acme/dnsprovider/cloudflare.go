@@ -0,0 +1,204 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareProvider manages TXT records through the Cloudflare v4 API using
+// either a scoped API token (preferred) or the global API key and email.
+type cloudflareProvider struct {
+	token  string
+	email  string
+	apiKey string
+	client *http.Client
+
+	mu      sync.Mutex
+	records map[string]cloudflareRecord // keyed by "<fqdn> <value>"
+}
+
+type cloudflareRecord struct {
+	zoneID, recordID string
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+func newCloudflare(c Credentials) (challenge.Provider, error) {
+	p := &cloudflareProvider{
+		token:   c.Get("api_token", "cf_dns_api_token"),
+		email:   c.Get("email", "cf_api_email"),
+		apiKey:  c.Get("api_key", "cf_api_key"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		records: map[string]cloudflareRecord{},
+	}
+	if p.token == "" && (p.email == "" || p.apiKey == "") {
+		return nil, errors.New("cloudflare: api_token (or email and api_key) required")
+	}
+	return p, nil
+}
+
+// Timeout gives Cloudflare's anycast network time to publish the record.
+func (p *cloudflareProvider) Timeout() (timeout, interval time.Duration) {
+	return 2 * time.Minute, 2 * time.Second
+}
+
+func (p *cloudflareProvider) Present(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.SetTXT(info.EffectiveFQDN, info.Value)
+}
+
+func (p *cloudflareProvider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.RemoveTXT(info.EffectiveFQDN, info.Value)
+}
+
+func (p *cloudflareProvider) SetTXT(fqdn, value string) error {
+	fqdn = dns01.ToFqdn(fqdn)
+	zoneID, err := p.zoneID(fqdn)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"type":    "TXT",
+		"name":    dns01.UnFqdn(fqdn),
+		"content": value,
+		"ttl":     120,
+	}
+	var rec struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(http.MethodPost, "/zones/"+zoneID+"/dns_records", body, &rec); err != nil {
+		return fmt.Errorf("cloudflare: create TXT record: %w", err)
+	}
+	p.mu.Lock()
+	p.records[fqdn+" "+value] = cloudflareRecord{zoneID: zoneID, recordID: rec.ID}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *cloudflareProvider) RemoveTXT(fqdn, value string) error {
+	key := dns01.ToFqdn(fqdn) + " " + value
+	p.mu.Lock()
+	rec, ok := p.records[key]
+	delete(p.records, key)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := p.do(http.MethodDelete, "/zones/"+rec.zoneID+"/dns_records/"+rec.recordID, nil, nil); err != nil {
+		return fmt.Errorf("cloudflare: delete TXT record: %w", err)
+	}
+	return nil
+}
+
+// SetRecord replaces the rtype records at fqdn with values.
+func (p *cloudflareProvider) SetRecord(fqdn, rtype string, values []string) error {
+	fqdn = dns01.ToFqdn(fqdn)
+	zoneID, err := p.zoneID(fqdn)
+	if err != nil {
+		return err
+	}
+	name := dns01.UnFqdn(fqdn)
+	var existing []struct {
+		ID string `json:"id"`
+	}
+	query := "?type=" + rtype + "&name=" + url.QueryEscape(name)
+	if err := p.do(http.MethodGet, "/zones/"+zoneID+"/dns_records"+query, nil, &existing); err != nil {
+		return fmt.Errorf("cloudflare: look up %s records: %w", rtype, err)
+	}
+	for _, rec := range existing {
+		if err := p.do(http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+rec.ID, nil, nil); err != nil {
+			return fmt.Errorf("cloudflare: delete %s record: %w", rtype, err)
+		}
+	}
+	for _, v := range values {
+		body := map[string]interface{}{
+			"type":    rtype,
+			"name":    name,
+			"content": dns01.UnFqdn(v),
+			"ttl":     300,
+		}
+		if err := p.do(http.MethodPost, "/zones/"+zoneID+"/dns_records", body, nil); err != nil {
+			return fmt.Errorf("cloudflare: create %s record: %w", rtype, err)
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) zoneID(fqdn string) (string, error) {
+	zone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return "", fmt.Errorf("cloudflare: find zone for %s: %w", fqdn, err)
+	}
+	var zones []struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(http.MethodGet, "/zones?name="+url.QueryEscape(dns01.UnFqdn(zone)), nil, &zones); err != nil {
+		return "", fmt.Errorf("cloudflare: look up zone %s: %w", zone, err)
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("cloudflare: zone %s not found in this account", zone)
+	}
+	return zones[0].ID, nil
+}
+
+func (p *cloudflareProvider) do(method, path string, in, out interface{}) error {
+	body := bytes.NewReader(nil)
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	} else {
+		req.Header.Set("X-Auth-Email", p.email)
+		req.Header.Set("X-Auth-Key", p.apiKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("unexpected response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if !r.Success {
+		var msgs []string
+		for _, e := range r.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if out != nil {
+		return json.Unmarshal(r.Result, out)
+	}
+	return nil
+}
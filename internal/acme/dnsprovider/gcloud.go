@@ -0,0 +1,404 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+const (
+	gcloudDNSAPI     = "https://dns.googleapis.com/dns/v1"
+	gcloudDNSScope   = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+	gcloudMetadata   = "http://metadata.google.internal/computeMetadata/v1"
+	gcloudDefaultTTL = 60
+)
+
+// gcloudProvider manages TXT records in Google Cloud DNS. It authenticates
+// with a service-account JSON key when one is configured (or named by
+// GOOGLE_APPLICATION_CREDENTIALS) and otherwise asks the GCE/GKE metadata
+// server for a token, which covers workload identity.
+type gcloudProvider struct {
+	project string
+	zone    string
+	account *gcloudServiceAccount
+	client  *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type gcloudServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	ProjectID   string `json:"project_id"`
+	key         *rsa.PrivateKey
+}
+
+type gcloudRRSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+func newGCloud(c Credentials) (challenge.Provider, error) {
+	p := &gcloudProvider{
+		project: c.Get("project", "project_id", "gce_project"),
+		zone:    c.Get("zone", "managed_zone"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	file := c.Get("service_account_file", "credentials", "gce_service_account_file")
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if file != "" {
+		sa, err := loadGCloudServiceAccount(file)
+		if err != nil {
+			return nil, fmt.Errorf("gcloud: %w", err)
+		}
+		p.account = sa
+		if p.project == "" {
+			p.project = sa.ProjectID
+		}
+	}
+	if p.project == "" {
+		p.project = os.Getenv("GCE_PROJECT")
+	}
+	return p, nil
+}
+
+func loadGCloudServiceAccount(path string) (*gcloudServiceAccount, error) {
+	b, err := seal.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sa gcloudServiceAccount
+	if err := json.Unmarshal(b, &sa); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, fmt.Errorf("%s is not a service account key", path)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: invalid private_key", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: private_key is not RSA", path)
+	}
+	sa.key = rk
+	return &sa, nil
+}
+
+// Timeout leaves room for Cloud DNS to serve the record after the change is done.
+func (p *gcloudProvider) Timeout() (timeout, interval time.Duration) {
+	return 3 * time.Minute, 5 * time.Second
+}
+
+func (p *gcloudProvider) Present(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.update(info.EffectiveFQDN, `"`+info.Value+`"`, true)
+}
+
+func (p *gcloudProvider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.update(info.EffectiveFQDN, `"`+info.Value+`"`, false)
+}
+
+func (p *gcloudProvider) SetTXT(fqdn, value string) error {
+	return p.update(dns01.ToFqdn(fqdn), `"`+value+`"`, true)
+}
+
+func (p *gcloudProvider) RemoveTXT(fqdn, value string) error {
+	return p.update(dns01.ToFqdn(fqdn), `"`+value+`"`, false)
+}
+
+// update adds or removes value from the TXT record set at fqdn. Cloud DNS
+// replaces whole record sets, so the existing set is deleted and the merged
+// one added in a single change, which keeps apex and wildcard challenges for
+// the same name from clobbering each other.
+func (p *gcloudProvider) update(fqdn, value string, add bool) error {
+	zone, err := p.managedZone(fqdn)
+	if err != nil {
+		return err
+	}
+	existing, err := p.rrset(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	var datas []string
+	if existing != nil {
+		for _, d := range existing.RRDatas {
+			if d != value {
+				datas = append(datas, d)
+			}
+		}
+	}
+	if add {
+		datas = append(datas, value)
+	}
+
+	change := struct {
+		Additions []gcloudRRSet `json:"additions,omitempty"`
+		Deletions []gcloudRRSet `json:"deletions,omitempty"`
+	}{}
+	if existing != nil {
+		change.Deletions = []gcloudRRSet{*existing}
+	}
+	if len(datas) > 0 {
+		change.Additions = []gcloudRRSet{{Name: fqdn, Type: "TXT", TTL: gcloudDefaultTTL, RRDatas: datas}}
+	}
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil
+	}
+
+	var resp struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := p.do(http.MethodPost, p.zonePath(zone)+"/changes", change, &resp); err != nil {
+		return fmt.Errorf("gcloud: update TXT %s: %w", fqdn, err)
+	}
+	deadline := time.Now().Add(2 * time.Minute)
+	for resp.Status != "done" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gcloud: change %s still %s after 2m", resp.ID, resp.Status)
+		}
+		time.Sleep(3 * time.Second)
+		if err := p.do(http.MethodGet, p.zonePath(zone)+"/changes/"+url.PathEscape(resp.ID), nil, &resp); err != nil {
+			return fmt.Errorf("gcloud: get change %s: %w", resp.ID, err)
+		}
+	}
+	return nil
+}
+
+func (p *gcloudProvider) zonePath(zone string) string {
+	return "/projects/" + url.PathEscape(p.project) + "/managedZones/" + url.PathEscape(zone)
+}
+
+func (p *gcloudProvider) rrset(zone, fqdn string) (*gcloudRRSet, error) {
+	var resp struct {
+		RRSets []gcloudRRSet `json:"rrsets"`
+	}
+	q := url.Values{"name": {fqdn}, "type": {"TXT"}}
+	if err := p.do(http.MethodGet, p.zonePath(zone)+"/rrsets?"+q.Encode(), nil, &resp); err != nil {
+		return nil, fmt.Errorf("gcloud: list TXT %s: %w", fqdn, err)
+	}
+	if len(resp.RRSets) == 0 {
+		return nil, nil
+	}
+	return &resp.RRSets[0], nil
+}
+
+// managedZone finds the public managed zone serving fqdn unless one was
+// configured explicitly.
+func (p *gcloudProvider) managedZone(fqdn string) (string, error) {
+	if p.project == "" {
+		project, err := p.metadata("/project/project-id")
+		if err != nil {
+			return "", errors.New("gcloud: project not set and not running on GCP (set project in the credentials file)")
+		}
+		p.project = project
+	}
+	if p.zone != "" {
+		return p.zone, nil
+	}
+	authZone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return "", fmt.Errorf("gcloud: find zone for %s: %w", fqdn, err)
+	}
+	var resp struct {
+		ManagedZones []struct {
+			Name       string `json:"name"`
+			DNSName    string `json:"dnsName"`
+			Visibility string `json:"visibility"`
+		} `json:"managedZones"`
+	}
+	q := url.Values{"dnsName": {authZone}}
+	if err := p.do(http.MethodGet, "/projects/"+url.PathEscape(p.project)+"/managedZones?"+q.Encode(), nil, &resp); err != nil {
+		return "", fmt.Errorf("gcloud: list managed zones: %w", err)
+	}
+	for _, z := range resp.ManagedZones {
+		if z.Visibility == "" || z.Visibility == "public" {
+			p.zone = z.Name
+			return p.zone, nil
+		}
+	}
+	return "", fmt.Errorf("gcloud: no public managed zone for %s in project %s", authZone, p.project)
+}
+
+func (p *gcloudProvider) do(method, path string, in, out interface{}) error {
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, gcloudDNSAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, e.Error.Message)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (p *gcloudProvider) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && time.Until(p.expires) > time.Minute {
+		return p.token, nil
+	}
+	var (
+		token   string
+		expires time.Duration
+		err     error
+	)
+	if p.account != nil {
+		token, expires, err = p.serviceAccountToken()
+	} else {
+		token, expires, err = p.metadataToken()
+	}
+	if err != nil {
+		return "", fmt.Errorf("gcloud: obtain access token: %w", err)
+	}
+	p.token, p.expires = token, time.Now().Add(expires)
+	return p.token, nil
+}
+
+// serviceAccountToken exchanges a self-signed JWT for an access token.
+func (p *gcloudProvider) serviceAccountToken() (string, time.Duration, error) {
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.account.ClientEmail,
+		"scope": gcloudDNSScope,
+		"aud":   p.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signing := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.account.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signing + "." + enc.EncodeToString(sig)},
+	}
+	resp, err := p.client.PostForm(p.account.TokenURI, form)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	return decodeGCloudToken(resp)
+}
+
+// metadataToken asks the metadata server for the attached service account's
+// token; on GKE with workload identity this is the bound Google account.
+func (p *gcloudProvider) metadataToken() (string, time.Duration, error) {
+	req, _ := http.NewRequest(http.MethodGet, gcloudMetadata+"/instance/service-accounts/default/token", nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("no service_account_file configured and metadata server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeGCloudToken(resp)
+}
+
+func decodeGCloudToken(resp *http.Response) (string, time.Duration, error) {
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("token endpoint: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, err
+	}
+	if t.AccessToken == "" {
+		return "", 0, errors.New("token endpoint returned no access_token")
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
+
+func (p *gcloudProvider) metadata(path string) (string, error) {
+	req, _ := http.NewRequest(http.MethodGet, gcloudMetadata+path, nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %s: HTTP %d", path, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	return strings.TrimSpace(string(b)), err
+}
@@ -0,0 +1,405 @@
+package dnsprovider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// certbotPlugins maps certbot DNS authenticators to provider names.
+var certbotPlugins = map[string]string{
+	"dns-cloudflare": "cloudflare",
+	"dns-route53":    "route53",
+	"dns-google":     "gcloud",
+	"dns-rfc2136":    "rfc2136",
+	"dns-azure":      "azure",
+}
+
+// CertbotPlugin returns the provider of the certbot DNS authenticator
+// (dns-cloudflare, ...).
+func CertbotPlugin(authenticator string) (string, bool) {
+	p, ok := certbotPlugins[authenticator]
+	return p, ok
+}
+
+// acmeshPlugins maps acme.sh DNS API names to provider names.
+var acmeshPlugins = map[string]string{
+	"dns_cf":       "cloudflare",
+	"dns_aws":      "route53",
+	"dns_azure":    "azure",
+	"dns_nsupdate": "rfc2136",
+}
+
+// AcmeshPlugin returns the provider of the acme.sh DNS API (dns_cf, ...).
+func AcmeshPlugin(api string) (string, bool) {
+	p, ok := acmeshPlugins[api]
+	return p, ok
+}
+
+// acmeshEnv lists, per provider, the acme.sh variables each credential is
+// saved as.
+var acmeshEnv = map[string][][2]string{
+	"cloudflare": {{"api_token", "CF_Token"}, {"email", "CF_Email"}, {"api_key", "CF_Key"}},
+	"route53": {
+		{"access_key_id", "AWS_ACCESS_KEY_ID"},
+		{"secret_access_key", "AWS_SECRET_ACCESS_KEY"},
+		{"session_token", "AWS_SESSION_TOKEN"},
+	},
+	"azure": {
+		{"tenant_id", "AZUREDNS_TENANTID"},
+		{"client_id", "AZUREDNS_APPID"},
+		{"client_secret", "AZUREDNS_CLIENTSECRET"},
+		{"subscription_id", "AZUREDNS_SUBSCRIPTIONID"},
+	},
+	"rfc2136": {{"nameserver", "NSUPDATE_SERVER"}},
+}
+
+var (
+	bindKeyNameRe   = regexp.MustCompile(`key\s+"?([^"\s{]+)"?\s*\{`)
+	bindKeyAlgRe    = regexp.MustCompile(`algorithm\s+"?([^";\s]+)"?\s*;`)
+	bindKeySecretRe = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+)
+
+// ImportAcmesh collects the credentials of the acme.sh DNS API api from
+// vars, the variables acme.sh saved in account.conf and the domain's conf
+// (SAVED_CF_Token, or CF_Token in older versions).
+func ImportAcmesh(api string, vars map[string]string) (Imported, error) {
+	provider, ok := acmeshPlugins[api]
+	if !ok {
+		return Imported{}, fmt.Errorf("acme.sh's %s DNS API has no trusttls equivalent", api)
+	}
+	imp := Imported{Provider: provider, Source: "acme.sh " + api, Creds: Credentials{}}
+	get := func(name string) string {
+		if v := vars["SAVED_"+name]; v != "" {
+			return v
+		}
+		return vars[name]
+	}
+	for _, m := range acmeshEnv[provider] {
+		if v := get(m[1]); v != "" {
+			imp.Creds[m[0]] = v
+		}
+	}
+	if provider == "rfc2136" {
+		// acme.sh keeps the port apart and the TSIG key in a BIND key file
+		if port := get("NSUPDATE_SERVER_PORT"); port != "" && imp.Creds["nameserver"] != "" {
+			imp.Creds["nameserver"] = net.JoinHostPort(imp.Creds["nameserver"], port)
+		}
+		if file := get("NSUPDATE_KEY"); file != "" {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return imp, err
+			}
+			for k, re := range map[string]*regexp.Regexp{"tsig_key": bindKeyNameRe, "tsig_algorithm": bindKeyAlgRe, "tsig_secret": bindKeySecretRe} {
+				if m := re.FindSubmatch(b); m != nil {
+					imp.Creds[k] = string(m[1])
+				}
+			}
+		}
+	}
+	return imp, nil
+}
+
+var azureZoneKeyRe = regexp.MustCompile(`^zone\d+$`)
+
+// legoEnv lists, per provider, the lego environment variables each
+// credential is read from, in lego's order of preference.
+var legoEnv = map[string][][2]string{
+	"cloudflare": {
+		{"api_token", "CF_DNS_API_TOKEN"}, {"api_token", "CLOUDFLARE_DNS_API_TOKEN"},
+		{"email", "CF_API_EMAIL"}, {"email", "CLOUDFLARE_EMAIL"},
+		{"api_key", "CF_API_KEY"}, {"api_key", "CLOUDFLARE_API_KEY"},
+	},
+	"route53": {
+		{"access_key_id", "AWS_ACCESS_KEY_ID"},
+		{"secret_access_key", "AWS_SECRET_ACCESS_KEY"},
+		{"session_token", "AWS_SESSION_TOKEN"},
+		{"profile", "AWS_PROFILE"},
+		{"hosted_zone_id", "AWS_HOSTED_ZONE_ID"},
+	},
+	"gcloud": {
+		{"project", "GCE_PROJECT"},
+		{"service_account_file", "GCE_SERVICE_ACCOUNT_FILE"},
+		{"service_account_file", "GOOGLE_APPLICATION_CREDENTIALS"},
+	},
+	"azure": {
+		{"tenant_id", "AZURE_TENANT_ID"},
+		{"client_id", "AZURE_CLIENT_ID"},
+		{"client_secret", "AZURE_CLIENT_SECRET"},
+		{"subscription_id", "AZURE_SUBSCRIPTION_ID"},
+		{"resource_group", "AZURE_RESOURCE_GROUP"},
+		{"zone", "AZURE_ZONE_NAME"},
+	},
+	"rfc2136": {
+		{"nameserver", "RFC2136_NAMESERVER"},
+		{"tsig_key", "RFC2136_TSIG_KEY"},
+		{"tsig_secret", "RFC2136_TSIG_SECRET"},
+		{"tsig_algorithm", "RFC2136_TSIG_ALGORITHM"},
+	},
+	"exec": {
+		{"path", "EXEC_PATH"},
+		{"mode", "EXEC_MODE"},
+	},
+	"httpreq": {
+		{"endpoint", "HTTPREQ_ENDPOINT"},
+		{"mode", "HTTPREQ_MODE"},
+		{"username", "HTTPREQ_USERNAME"},
+		{"password", "HTTPREQ_PASSWORD"},
+	},
+}
+
+// Imported is a set of credentials found in another client's configuration.
+type Imported struct {
+	Provider string
+	Source   string // file the credentials came from, or "environment"
+	Creds    Credentials
+	// File is a credentials file to copy as it is (a service-account JSON
+	// key) instead of writing Creds.
+	File string
+}
+
+// ImportCertbot finds the DNS plugin credentials referenced by the renewal
+// configs under certbotDir (normally /etc/letsencrypt). Each credentials
+// file is reported once, even when several certificates share it.
+func ImportCertbot(certbotDir string) ([]Imported, error) {
+	paths, err := filepath.Glob(filepath.Join(certbotDir, "renewal", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no certbot renewal configs in %s", filepath.Join(certbotDir, "renewal"))
+	}
+	var out []Imported
+	seen := map[string]bool{}
+	for _, p := range paths {
+		conf, err := readKeyValues(p)
+		if err != nil {
+			return nil, err
+		}
+		provider, ok := certbotPlugins[conf["authenticator"]]
+		if !ok {
+			continue
+		}
+		file := conf[strings.ReplaceAll(conf["authenticator"], "-", "_")+"_credentials"]
+		key := provider + "\x00" + file
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if file == "" {
+			// route53 reads the AWS credential chain; nothing to copy
+			out = append(out, Imported{Provider: provider, Source: p, Creds: Credentials{}})
+			continue
+		}
+		imp, err := ImportCertbotFile(provider, file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		out = append(out, imp)
+	}
+	return out, nil
+}
+
+// ImportCertbotFile reads a certbot DNS plugin credentials file for provider
+// and converts settings whose meaning differs from trusttls'.
+func ImportCertbotFile(provider, path string) (Imported, error) {
+	imp := Imported{Provider: provider, Source: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return imp, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		imp.File = path
+		return imp, nil
+	}
+	creds, err := LoadCredentials(path)
+	if err != nil {
+		return imp, err
+	}
+	switch provider {
+	case "rfc2136":
+		// certbot keeps the port apart from the server address
+		if port := creds["port"]; port != "" && creds["server"] != "" {
+			if _, _, err := net.SplitHostPort(creds["server"]); err != nil {
+				creds["server"] = net.JoinHostPort(creds["server"], port)
+			}
+		}
+		delete(creds, "port")
+	case "azure":
+		// certbot-dns-azure maps zones to resource IDs:
+		// zone1 = example.com:/subscriptions/<id>/resourceGroups/<group>
+		var zones []string
+		for k, v := range creds {
+			if !azureZoneKeyRe.MatchString(k) {
+				continue
+			}
+			if zone, id, ok := strings.Cut(v, ":"); ok {
+				zones = append(zones, zone)
+				parts := strings.Split(strings.Trim(id, "/"), "/")
+				for i := 0; i+1 < len(parts); i += 2 {
+					switch strings.ToLower(parts[i]) {
+					case "subscriptions":
+						creds["subscription_id"] = parts[i+1]
+					case "resourcegroups":
+						creds["resource_group"] = parts[i+1]
+					}
+				}
+			}
+			delete(creds, k)
+		}
+		if len(zones) == 1 && creds["zone"] == "" {
+			creds["zone"] = zones[0]
+		}
+	}
+	imp.Creds = creds
+	return imp, nil
+}
+
+// ImportLegoEnv collects credentials set through lego's environment
+// variables, including the VAR_FILE form that names a file holding the
+// value. With provider empty, every provider with a variable set is
+// returned.
+func ImportLegoEnv(provider string, env map[string]string) ([]Imported, error) {
+	var names []string
+	if provider != "" {
+		if _, ok := legoEnv[provider]; !ok {
+			return nil, fmt.Errorf("no lego variables known for %q", provider)
+		}
+		names = []string{provider}
+	} else {
+		for n := range legoEnv {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+	}
+	var out []Imported
+	for _, name := range names {
+		creds := Credentials{}
+		for _, m := range legoEnv[name] {
+			if creds[m[0]] != "" {
+				continue
+			}
+			v, err := legoValue(env, m[1])
+			if err != nil {
+				return nil, err
+			}
+			if v != "" {
+				creds[m[0]] = v
+			}
+		}
+		if len(creds) > 0 || provider != "" {
+			out = append(out, Imported{Provider: name, Source: "environment", Creds: creds})
+		}
+	}
+	return out, nil
+}
+
+func legoValue(env map[string]string, name string) (string, error) {
+	if v := env[name]; v != "" {
+		return v, nil
+	}
+	if file := env[name+"_FILE"]; file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// ReadEnvFile parses KEY=VALUE lines as used by lego wrappers and systemd
+// EnvironmentFile=, ignoring comments and an "export " prefix.
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env := map[string]string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		env[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	return env, s.Err()
+}
+
+// Environ returns the process environment as a map.
+func Environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// SaveImported writes imp into the store as the provider's credentials file
+// and returns its path. An existing file is only replaced with overwrite.
+func SaveImported(baseDir string, imp Imported, overwrite bool) (string, error) {
+	dst := CredentialsPath(baseDir, imp.Provider)
+	if _, err := os.Stat(dst); err == nil && !overwrite {
+		return "", fmt.Errorf("%s: %w", dst, os.ErrExist)
+	}
+	if imp.File != "" {
+		return storeCredentials(dst, imp.File)
+	}
+	if _, err := New(imp.Provider, imp.Creds); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(imp.Creds))
+	for k := range imp.Creds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Imported from %s\n", imp.Source)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, imp.Creds[k])
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	if err := seal.WriteFile(dst, b.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return dst, os.Chmod(dst, 0600)
+}
+
+// readKeyValues reads "key = value" lines of a certbot renewal config,
+// ignoring section headers.
+func readKeyValues(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return out, s.Err()
+}
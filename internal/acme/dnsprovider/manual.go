@@ -0,0 +1,134 @@
+package dnsprovider
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"github.com/trustctl/trusttls/internal/resolver"
+)
+
+// manualProvider asks the operator to create each TXT record by hand, for
+// domains whose registrar has no API. It needs an interactive terminal, so
+// certificates issued with it cannot renew unattended.
+type manualProvider struct {
+	poll        bool
+	resolvers   *resolver.Resolver
+	pollTimeout time.Duration
+
+	mu    sync.Mutex
+	input *bufio.Reader
+}
+
+func newManual(c Credentials) (challenge.Provider, error) {
+	p := &manualProvider{
+		pollTimeout: 10 * time.Minute,
+		input:       bufio.NewReader(os.Stdin),
+	}
+	switch strings.ToLower(c.Get("poll", "wait")) {
+	case "", "false", "no", "0":
+	default:
+		p.poll = true
+	}
+	specs := resolver.Split(c.Get("resolvers"))
+	if len(specs) == 0 {
+		specs = resolver.Public
+	}
+	var err error
+	if p.resolvers, err = resolver.New(specs); err != nil {
+		return nil, fmt.Errorf("manual: %w", err)
+	}
+	if t := c.Get("poll_timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("manual: poll_timeout: %w", err)
+		}
+		p.pollTimeout = d
+	}
+	return p, nil
+}
+
+// Timeout leaves room for registrars that take several minutes to publish
+// a change even after the operator has saved it.
+func (p *manualProvider) Timeout() (timeout, interval time.Duration) {
+	return 15 * time.Minute, 10 * time.Second
+}
+
+func (p *manualProvider) Present(domain, token, keyAuth string) error {
+	if !interactive() {
+		return fmt.Errorf("manual DNS validation for %s needs an interactive terminal; run the command by hand or switch the certificate to a DNS provider with an API", domain)
+	}
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Printf("\n📝 Create this TXT record for %s:\n\n", domain)
+	fmt.Printf("   Name:  %s\n", dns01.UnFqdn(info.EffectiveFQDN))
+	fmt.Printf("   Type:  TXT\n")
+	fmt.Printf("   Value: %s\n", info.Value)
+	fmt.Printf("   TTL:   %d (or the lowest your DNS host allows)\n\n", dns01.DefaultTTL)
+	fmt.Printf("   Keep any existing TXT records with the same name; add this value alongside them.\n")
+	fmt.Printf("👉 Press Enter once the record is saved...")
+	if _, err := p.input.ReadString('\n'); err != nil {
+		return fmt.Errorf("manual: waiting for confirmation: %w", err)
+	}
+	if p.poll {
+		return p.waitVisible(info.EffectiveFQDN, info.Value)
+	}
+	return nil
+}
+
+// waitVisible polls the public resolvers until every one of them returns
+// value for fqdn.
+func (p *manualProvider) waitVisible(fqdn, value string) error {
+	fmt.Printf("🔎 Waiting for %s to show up on public resolvers (%s)...\n", dns01.UnFqdn(fqdn), p.resolvers)
+	deadline := time.Now().Add(p.pollTimeout)
+	for {
+		var missing []string
+		for _, u := range p.resolvers.Upstreams() {
+			if !txtVisible(u, fqdn, value) {
+				missing = append(missing, u.String())
+			}
+		}
+		if len(missing) == 0 {
+			fmt.Printf("✅ Record is visible on all resolvers\n")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("manual: TXT record for %s still not visible on %s after %s", dns01.UnFqdn(fqdn), strings.Join(missing, ", "), p.pollTimeout)
+		}
+		fmt.Printf("   not yet visible on %s, checking again in 15s\n", strings.Join(missing, ", "))
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func txtVisible(u resolver.Upstream, fqdn, value string) bool {
+	resp, err := resolver.Ask(context.Background(), u, fqdn, dns.TypeTXT)
+	if err != nil {
+		return false
+	}
+	for _, t := range resolver.TXT(resp) {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *manualProvider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	fmt.Printf("🧹 You can now remove the TXT record %s with value %s\n", dns01.UnFqdn(info.EffectiveFQDN), info.Value)
+	return nil
+}
+
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,233 @@
+// Package dnsprovider builds lego DNS-01 challenge providers by name from a
+// set of credentials, so the CLI and renewal configs can refer to them as
+// plain strings.
+package dnsprovider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/providers/dns/exec"
+	"github.com/go-acme/lego/v4/providers/dns/httpreq"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// Credentials holds provider settings keyed by lower-case name, e.g.
+// "nameserver" or "api_token".
+type Credentials map[string]string
+
+// Get returns the first non-empty value among keys.
+func (c Credentials) Get(keys ...string) string {
+	for _, k := range keys {
+		if v := c[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type factory func(Credentials) (challenge.Provider, error)
+
+var registry = map[string]factory{
+	"azure":      newAzure,
+	"cloudflare": newCloudflare,
+	"rfc2136":    newRFC2136,
+	"exec":       newExec,
+	"gcloud":     newGCloud,
+	"httpreq":    newHTTPReq,
+	"manual":     newManual,
+	"route53":    newRoute53,
+}
+
+// Names returns the registered provider names in sorted order.
+func Names() []string {
+	var out []string
+	for n := range registry {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// New returns the DNS-01 provider registered under name.
+func New(name string, creds Credentials) (challenge.Provider, error) {
+	f, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS provider %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	if creds == nil {
+		creds = Credentials{}
+	}
+	return f(creds)
+}
+
+// TXTRecorder is implemented by providers that can publish any TXT record,
+// not only ACME challenges, as validation outside ACME (DigiCert's DNS
+// token) needs.
+type TXTRecorder interface {
+	SetTXT(fqdn, value string) error
+	RemoveTXT(fqdn, value string) error
+}
+
+// NewTXT returns the provider registered under name as a TXTRecorder.
+func NewTXT(name string, creds Credentials) (TXTRecorder, error) {
+	p, err := New(name, creds)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := p.(TXTRecorder)
+	if !ok {
+		return nil, fmt.Errorf("DNS provider %s can only publish ACME challenges; use azure, cloudflare, gcloud or route53", name)
+	}
+	return r, nil
+}
+
+// RecordSetter is implemented by providers that can manage records other
+// than TXT, as setting up a challenge delegation needs.
+type RecordSetter interface {
+	// SetRecord replaces the records of type rtype at fqdn with values.
+	SetRecord(fqdn, rtype string, values []string) error
+}
+
+// NewRecordSetter returns the provider registered under name as a
+// RecordSetter.
+func NewRecordSetter(name string, creds Credentials) (RecordSetter, error) {
+	p, err := New(name, creds)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := p.(RecordSetter)
+	if !ok {
+		return nil, fmt.Errorf("DNS provider %s cannot create delegation records; use cloudflare or route53, or add them by hand", name)
+	}
+	return r, nil
+}
+
+// LoadCredentials reads a credentials file made of "key = value" lines. Blank
+// lines and lines starting with # or ; are ignored. Keys are lower-cased and
+// a leading "dns_<provider>_" prefix, as used by certbot plugins, is dropped
+// so existing certbot INI files work unchanged. A JSON file is taken to be a
+// cloud service-account key and is passed through as service_account_file.
+func LoadCredentials(path string) (Credentials, error) {
+	if path == "" {
+		return Credentials{}, nil
+	}
+	b, err := seal.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return Credentials{"service_account_file": path}, nil
+	}
+	creds := Credentials{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		creds[normalizeKey(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	return creds, s.Err()
+}
+
+// CredentialsPath returns where the shared credentials for the named
+// provider, as written by 'trusttls dns import', are kept inside the store.
+func CredentialsPath(baseDir, name string) string {
+	return filepath.Join(baseDir, "dns", strings.ToLower(name)+".ini")
+}
+
+// LineageCredentialsPath returns where the credentials one lineage was
+// issued with are kept inside the store, so lineages using different
+// accounts of the same provider do not overwrite each other's.
+func LineageCredentialsPath(baseDir, lineage, name string) string {
+	return filepath.Join(baseDir, "dns", lineage+"-"+strings.ToLower(name)+".ini")
+}
+
+// StoreCredentials copies the credentials file at src into the store as the
+// credentials of lineage, with 0600 permissions so unattended renewals do not
+// depend on the original file, and returns the stored path. A file that is
+// already in the store's dns/ directory is used where it is.
+func StoreCredentials(baseDir, lineage, name, src string) (string, error) {
+	if abs, err := filepath.Abs(src); err == nil && filepath.Dir(abs) == filepath.Join(baseDir, "dns") {
+		return abs, os.Chmod(abs, 0600)
+	}
+	return storeCredentials(LineageCredentialsPath(baseDir, lineage, name), src)
+}
+
+func storeCredentials(dst, src string) (string, error) {
+	if abs, err := filepath.Abs(src); err == nil && abs == dst {
+		return dst, os.Chmod(dst, 0600)
+	}
+	b, err := seal.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	if err := seal.WriteFile(dst, b, 0600); err != nil {
+		return "", err
+	}
+	return dst, os.Chmod(dst, 0600)
+}
+
+func normalizeKey(k string) string {
+	k = strings.ToLower(strings.TrimSpace(k))
+	if strings.HasPrefix(k, "dns_") {
+		if i := strings.Index(k[4:], "_"); i >= 0 {
+			k = k[4+i+1:]
+		}
+	}
+	return k
+}
+
+func newRFC2136(c Credentials) (challenge.Provider, error) {
+	cfg := rfc2136.NewDefaultConfig()
+	cfg.Nameserver = c.Get("nameserver", "server")
+	cfg.TSIGKey = c.Get("tsig_key", "name")
+	cfg.TSIGSecret = c.Get("tsig_secret", "secret")
+	if alg := c.Get("tsig_algorithm", "algorithm"); alg != "" {
+		cfg.TSIGAlgorithm = strings.ToLower(strings.TrimSuffix(alg, ".")) + "."
+	}
+	return rfc2136.NewDNSProviderConfig(cfg)
+}
+
+func newExec(c Credentials) (challenge.Provider, error) {
+	cfg := exec.NewDefaultConfig()
+	cfg.Program = c.Get("path", "program")
+	cfg.Mode = c.Get("mode")
+	if cfg.Program == "" {
+		return nil, fmt.Errorf("exec: path to the program is required")
+	}
+	return exec.NewDNSProviderConfig(cfg)
+}
+
+func newHTTPReq(c Credentials) (challenge.Provider, error) {
+	cfg := httpreq.NewDefaultConfig()
+	endpoint := c.Get("endpoint")
+	if endpoint == "" {
+		return nil, fmt.Errorf("httpreq: endpoint is required")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("httpreq: %w", err)
+	}
+	cfg.Endpoint = u
+	cfg.Mode = c.Get("mode")
+	cfg.Username = c.Get("username")
+	cfg.Password = c.Get("password")
+	return httpreq.NewDNSProviderConfig(cfg)
+}
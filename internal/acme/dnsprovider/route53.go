@@ -0,0 +1,473 @@
+package dnsprovider
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+const (
+	route53Endpoint = "https://route53.amazonaws.com/2013-04-01"
+	route53Region   = "us-east-1"
+	route53TTL      = 60
+	imdsEndpoint    = "http://169.254.169.254/latest"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// route53Provider manages TXT records in Route53 hosted zones. Credentials are
+// taken, in order, from the credentials file, the AWS_* environment variables,
+// the shared credentials file (~/.aws/credentials) and finally the EC2
+// instance role via IMDSv2.
+type route53Provider struct {
+	static       awsCredentials
+	profile      string
+	hostedZoneID string
+	client       *http.Client
+
+	mu     sync.Mutex
+	creds  awsCredentials
+	values map[string][]string // fqdn -> TXT values currently published by us
+}
+
+func newRoute53(c Credentials) (challenge.Provider, error) {
+	p := &route53Provider{
+		static: awsCredentials{
+			AccessKeyID:     c.Get("access_key_id", "aws_access_key_id"),
+			SecretAccessKey: c.Get("secret_access_key", "aws_secret_access_key"),
+			SessionToken:    c.Get("session_token", "aws_session_token"),
+		},
+		profile:      c.Get("profile", "aws_profile"),
+		hostedZoneID: c.Get("hosted_zone_id", "aws_hosted_zone_id"),
+		client:       &http.Client{Timeout: 30 * time.Second},
+		values:       map[string][]string{},
+	}
+	if (p.static.AccessKeyID == "") != (p.static.SecretAccessKey == "") {
+		return nil, errors.New("route53: access_key_id and secret_access_key must be set together")
+	}
+	return p, nil
+}
+
+// Timeout covers Route53 propagation after the change is already INSYNC.
+func (p *route53Provider) Timeout() (timeout, interval time.Duration) {
+	return 2 * time.Minute, 4 * time.Second
+}
+
+func (p *route53Provider) Present(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.SetTXT(info.EffectiveFQDN, info.Value)
+}
+
+func (p *route53Provider) CleanUp(domain, token, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	return p.RemoveTXT(info.EffectiveFQDN, info.Value)
+}
+
+func (p *route53Provider) SetTXT(fqdn, value string) error {
+	fqdn = dns01.ToFqdn(fqdn)
+	p.mu.Lock()
+	values := append(p.values[fqdn], value)
+	p.values[fqdn] = values
+	p.mu.Unlock()
+	return p.change(fqdn, "UPSERT", values)
+}
+
+func (p *route53Provider) RemoveTXT(fqdn, value string) error {
+	fqdn = dns01.ToFqdn(fqdn)
+	p.mu.Lock()
+	previous := p.values[fqdn]
+	var remaining []string
+	for _, v := range previous {
+		if v != value {
+			remaining = append(remaining, v)
+		}
+	}
+	p.values[fqdn] = remaining
+	p.mu.Unlock()
+	if len(remaining) > 0 {
+		return p.change(fqdn, "UPSERT", remaining)
+	}
+	// DELETE must match the record exactly as it was last written.
+	return p.change(fqdn, "DELETE", previous)
+}
+
+// change submits one record set change and waits until Route53 reports it
+// INSYNC on all of its authoritative servers.
+func (p *route53Provider) change(fqdn, action string, values []string) error {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + v + `"`
+	}
+	return p.changeRecord(fqdn, "TXT", action, quoted, route53TTL)
+}
+
+// SetRecord replaces the rtype records at fqdn with values.
+func (p *route53Provider) SetRecord(fqdn, rtype string, values []string) error {
+	return p.changeRecord(dns01.ToFqdn(fqdn), rtype, "UPSERT", values, 300)
+}
+
+func (p *route53Provider) changeRecord(fqdn, rtype, action string, values []string, ttl int) error {
+	zoneID, err := p.zoneID(fqdn)
+	if err != nil {
+		return err
+	}
+	var rr bytes.Buffer
+	for _, v := range values {
+		fmt.Fprintf(&rr, "<ResourceRecord><Value>%s</Value></ResourceRecord>", xmlEscape(v))
+	}
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+<ChangeBatch><Comment>trusttls ACME challenge</Comment><Changes><Change>
+<Action>%s</Action>
+<ResourceRecordSet><Name>%s</Name><Type>%s</Type><TTL>%d</TTL><ResourceRecords>%s</ResourceRecords></ResourceRecordSet>
+</Change></Changes></ChangeBatch>
+</ChangeResourceRecordSetsRequest>`, action, xmlEscape(fqdn), rtype, ttl, rr.String())
+
+	var resp struct {
+		ChangeInfo struct {
+			ID     string `xml:"Id"`
+			Status string `xml:"Status"`
+		} `xml:"ChangeInfo"`
+	}
+	if err := p.do(http.MethodPost, "/hostedzone/"+zoneID+"/rrset", nil, []byte(body), &resp); err != nil {
+		return fmt.Errorf("route53: %s %s %s: %w", strings.ToLower(action), rtype, fqdn, err)
+	}
+	return p.waitInsync(resp.ChangeInfo.ID, resp.ChangeInfo.Status)
+}
+
+func (p *route53Provider) waitInsync(changeID, status string) error {
+	changeID = strings.TrimPrefix(changeID, "/change/")
+	deadline := time.Now().Add(3 * time.Minute)
+	for status != "INSYNC" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("route53: change %s still %s after 3m", changeID, status)
+		}
+		time.Sleep(5 * time.Second)
+		var resp struct {
+			ChangeInfo struct {
+				Status string `xml:"Status"`
+			} `xml:"ChangeInfo"`
+		}
+		if err := p.do(http.MethodGet, "/change/"+changeID, nil, nil, &resp); err != nil {
+			return fmt.Errorf("route53: get change %s: %w", changeID, err)
+		}
+		status = resp.ChangeInfo.Status
+	}
+	return nil
+}
+
+func (p *route53Provider) zoneID(fqdn string) (string, error) {
+	if p.hostedZoneID != "" {
+		return strings.TrimPrefix(p.hostedZoneID, "/hostedzone/"), nil
+	}
+	zone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return "", fmt.Errorf("route53: find zone for %s: %w", fqdn, err)
+	}
+	var resp struct {
+		HostedZones []struct {
+			ID     string `xml:"Id"`
+			Name   string `xml:"Name"`
+			Config struct {
+				PrivateZone bool `xml:"PrivateZone"`
+			} `xml:"Config"`
+		} `xml:"HostedZones>HostedZone"`
+	}
+	q := url.Values{"dnsname": {zone}, "maxitems": {"10"}}
+	if err := p.do(http.MethodGet, "/hostedzonesbyname", q, nil, &resp); err != nil {
+		return "", fmt.Errorf("route53: list hosted zones: %w", err)
+	}
+	for _, z := range resp.HostedZones {
+		if dns01.ToFqdn(z.Name) == zone && !z.Config.PrivateZone {
+			return strings.TrimPrefix(z.ID, "/hostedzone/"), nil
+		}
+	}
+	return "", fmt.Errorf("route53: no public hosted zone found for %s", zone)
+}
+
+func (p *route53Provider) do(method, path string, query url.Values, body []byte, out interface{}) error {
+	creds, err := p.credentials()
+	if err != nil {
+		return err
+	}
+	u := route53Endpoint + path
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	signV4(req, body, creds, route53Region, "route53", time.Now().UTC())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return fmt.Errorf("HTTP %d %s: %s", resp.StatusCode, e.Code, e.Message)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if out != nil {
+		return xml.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (p *route53Provider) credentials() (awsCredentials, error) {
+	if p.static.AccessKeyID != "" {
+		return p.static, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds.AccessKeyID != "" && (p.creds.Expires.IsZero() || time.Until(p.creds.Expires) > 5*time.Minute) {
+		return p.creds, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		p.creds = awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		return p.creds, nil
+	}
+	if c, err := sharedAWSCredentials(p.profile); err == nil {
+		p.creds = c
+		return p.creds, nil
+	}
+	c, err := p.instanceRoleCredentials()
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("route53: no AWS credentials found (credentials file, environment, ~/.aws/credentials or instance role): %w", err)
+	}
+	p.creds = c
+	return p.creds, nil
+}
+
+// sharedAWSCredentials reads a profile from the shared credentials file.
+func sharedAWSCredentials(profile string) (awsCredentials, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer f.Close()
+	var c awsCredentials
+	section := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			c.AccessKeyID = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			c.SecretAccessKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			c.SessionToken = strings.TrimSpace(v)
+		}
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("profile %q not found in %s", profile, path)
+	}
+	return c, nil
+}
+
+// instanceRoleCredentials fetches temporary credentials for the EC2 instance
+// role through IMDSv2.
+func (p *route53Provider) instanceRoleCredentials() (awsCredentials, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, _ := http.NewRequest(http.MethodPut, imdsEndpoint+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	tokenBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	token := string(tokenBytes)
+
+	get := func(path string) ([]byte, error) {
+		req, _ := http.NewRequest(http.MethodGet, imdsEndpoint+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("instance metadata %s: HTTP %d", path, resp.StatusCode)
+		}
+		return io.ReadAll(resp.Body)
+	}
+	roles, err := get("/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, errors.New("no IAM role attached to this instance")
+	}
+	b, err := get("/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var r struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{AccessKeyID: r.AccessKeyID, SecretAccessKey: r.SecretAccessKey, SessionToken: r.Token, Expires: r.Expiration}, nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req.
+func signV4(req *http.Request, body []byte, c awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	if c.SessionToken != "" {
+		headers["x-amz-security-token"] = c.SessionToken
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters the way SigV4 expects: sorted by
+// key, RFC 3986 percent-encoding, spaces as %20.
+func canonicalQuery(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string{}, q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
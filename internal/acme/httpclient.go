@@ -0,0 +1,78 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// caBundleFile is the name the roots trusted for an ACME server are kept
+// under, next to the server's accounts.
+const caBundleFile = "ca-bundle.pem"
+
+// LoadCABundle returns the system roots plus the PEM certificates in path,
+// so a private ACME CA (step-ca, an in-house Boulder) can be trusted
+// without installing its root system-wide.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("CA bundle %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// newHTTPClient returns a client for talking to the ACME server, trusting
+// the roots in caBundle in addition to the system ones. Clients for the
+// same bundle share their connections.
+func newHTTPClient(caBundle string) (*http.Client, error) {
+	transport, err := sharedTransport(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: requestTimeout, Transport: transport}, nil
+}
+
+// CABundlePath returns where the extra roots trusted for server are kept in
+// the store at baseDir: next to its accounts, so every account, command and
+// renewal ordering from the server uses them.
+func CABundlePath(baseDir, server, email string) string {
+	return filepath.Join(filepath.Dir(accountDir(baseDir, server, email)), caBundleFile)
+}
+
+// caBundleFor returns the roots file to trust for server. A given file is
+// checked and copied into the store first; without one, the copy an earlier
+// run kept is used, if any. "" means the system roots only.
+func caBundleFor(baseDir, server, email, given string) (string, error) {
+	stored := CABundlePath(baseDir, server, email)
+	if given == "" {
+		if _, err := os.Stat(stored); err != nil {
+			return "", nil
+		}
+		return stored, nil
+	}
+	if _, err := LoadCABundle(given); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(given)
+	if err != nil {
+		return "", err
+	}
+	if old, err := os.ReadFile(stored); err == nil && bytes.Equal(old, b) {
+		return stored, nil
+	}
+	if err := writeFileAtomic(stored, b, 0644); err != nil {
+		return "", fmt.Errorf("keep CA bundle for %s: %w", server, err)
+	}
+	fmt.Printf("📌 Trusting the roots in %s for %s from now on (kept as %s)\n", given, server, stored)
+	return stored, nil
+}
@@ -0,0 +1,75 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/trustctl/trusttls/internal/interrupt"
+)
+
+// errInterrupted refuses new challenges once the run is being interrupted.
+var errInterrupted = errors.New("interrupted")
+
+// onInterrupt wraps a challenge provider so that what it presents is taken
+// back when the run is interrupted before lego cleans up after the
+// challenge. what names a presented challenge, e.g. "challenge file".
+func onInterrupt(p challenge.Provider, what string) challenge.Provider {
+	ip := &interruptible{Provider: p, what: what, pending: map[string]func(){}}
+	if s, ok := p.(sequential); ok {
+		return interruptibleSequential{interruptible: ip, seq: s}
+	}
+	return ip
+}
+
+type interruptible struct {
+	challenge.Provider
+	what string
+
+	mu      sync.Mutex
+	pending map[string]func() // by domain, token and key authorization
+}
+
+func (p *interruptible) Present(domain, token, keyAuth string) error {
+	if interrupt.Stopping() {
+		return errInterrupted
+	}
+	// Registered first: an interrupt while presenting takes back what was
+	// presented so far
+	done := interrupt.OnInterrupt(fmt.Sprintf("%s for %s", p.what, domain), func() error {
+		return p.Provider.CleanUp(domain, token, keyAuth)
+	})
+	p.mu.Lock()
+	p.pending[domain+"\x00"+token+"\x00"+keyAuth] = done
+	p.mu.Unlock()
+	release := interrupt.Hold()
+	defer release()
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+func (p *interruptible) CleanUp(domain, token, keyAuth string) error {
+	// Held so an interrupt now lets this cleanup finish instead of running it
+	// a second time
+	release := interrupt.Hold()
+	defer release()
+	key := domain + "\x00" + token + "\x00" + keyAuth
+	p.mu.Lock()
+	done := p.pending[key]
+	delete(p.pending, key)
+	p.mu.Unlock()
+	if done != nil {
+		done()
+	}
+	return p.Provider.CleanUp(domain, token, keyAuth)
+}
+
+type interruptibleSequential struct {
+	*interruptible
+	seq sequential
+}
+
+func (p interruptibleSequential) Sequential() time.Duration {
+	return p.seq.Sequential()
+}
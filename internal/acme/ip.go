@@ -0,0 +1,21 @@
+package acme
+
+import (
+	"fmt"
+	"net"
+)
+
+// IsIP reports whether name is an IP address identifier (RFC 8738) rather
+// than a DNS name.
+func IsIP(name string) bool { return net.ParseIP(name) != nil }
+
+// rejectIPs fails for IP address identifiers, which can only be validated
+// with HTTP-01 or TLS-ALPN-01.
+func rejectIPs(domains []string) error {
+	for _, d := range domains {
+		if IsIP(d) {
+			return fmt.Errorf("%s is an IP address; IP address certificates can only be validated with HTTP-01, not DNS-01", d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+// Key types.
+const (
+	KeyRSA   = "rsa"
+	KeyECDSA = "ecdsa"
+)
+
+const (
+	minRSABits = 2048
+	// maxRSABits stops typos such as 40960; bigger keys only slow down
+	// every handshake.
+	maxRSABits = 8192
+)
+
+// ecdsaSizes are the curve sizes GenerateKey knows: P-256, P-384 and P-521.
+var ecdsaSizes = []int{256, 384, 521}
+
+// DefaultKeySize returns the size used for keyType when none is given.
+func DefaultKeySize(keyType string) int {
+	if keyType == KeyECDSA {
+		return 256
+	}
+	return minRSABits
+}
+
+// NormalizeKey checks a key type and size from a flag, a config file or a
+// caller, and fills in the defaults: RSA when keyType is empty, and
+// DefaultKeySize when size is 0. Keys trusttls will not generate are
+// rejected rather than quietly replaced by another.
+func NormalizeKey(keyType string, size int) (string, int, error) {
+	keyType = strings.ToLower(strings.TrimSpace(keyType))
+	if keyType == "" {
+		keyType = KeyRSA
+	}
+	if size == 0 {
+		size = DefaultKeySize(keyType)
+	}
+	switch keyType {
+	case KeyRSA:
+		if size < minRSABits {
+			return keyType, size, fmt.Errorf("RSA keys must be at least %d bits, not %d", minRSABits, size)
+		}
+		if size > maxRSABits {
+			return keyType, size, fmt.Errorf("RSA keys are at most %d bits, not %d", maxRSABits, size)
+		}
+		if size%8 != 0 {
+			return keyType, size, fmt.Errorf("RSA key size %d is not a whole number of bytes; use 2048, 3072 or 4096", size)
+		}
+	case KeyECDSA:
+		if !containsInt(ecdsaSizes, size) {
+			return keyType, size, fmt.Errorf("ECDSA keys are %s bits (the P-256, P-384 and P-521 curves), not %d", joinInts(ecdsaSizes), size)
+		}
+	default:
+		return keyType, size, fmt.Errorf("key type must be rsa or ecdsa, not %q", keyType)
+	}
+	return keyType, size, nil
+}
+
+// CheckKey returns why a key of keyType and size cannot be generated, or
+// nil. Empty and zero values stand for the defaults.
+func CheckKey(keyType string, size int) error {
+	_, _, err := NormalizeKey(keyType, size)
+	return err
+}
+
+// CheckKey returns why c would refuse, or trusttls cannot generate, a
+// certificate key of keyType and size, or nil.
+func (c Capabilities) CheckKey(keyType string, size int) error {
+	keyType, size, err := NormalizeKey(keyType, size)
+	if err != nil {
+		return err
+	}
+	sizes, ok := c.KeySizes[keyType]
+	if c.KeySizes == nil || ok && containsInt(sizes, size) {
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("%s does not issue certificates for %s keys", c.CA, strings.ToUpper(keyType))
+	}
+	return fmt.Errorf("%s only accepts %s keys of %s bits, not %d", c.CA, strings.ToUpper(keyType), joinInts(sizes), size)
+}
+
+// PublicKeyParams returns the type and size of an RSA or ECDSA public key,
+// as NormalizeKey takes them. ok is false for other keys.
+func PublicKeyParams(pub crypto.PublicKey) (keyType string, size int, ok bool) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return KeyRSA, k.N.BitLen(), true
+	case *ecdsa.PublicKey:
+		return KeyECDSA, k.Curve.Params().BitSize, true
+	}
+	return "", 0, false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// joinInts formats list as "1, 2 or 3".
+func joinInts(list []int) string {
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = fmt.Sprint(n)
+	}
+	if len(s) < 2 {
+		return strings.Join(s, "")
+	}
+	return strings.Join(s[:len(s)-1], ", ") + " or " + s[len(s)-1]
+}
@@ -10,15 +10,23 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/challenge/http01"
-	"github.com/go-acme/lego/v4/lego"
+	legoresolver "github.com/go-acme/lego/v4/challenge/resolver"
 	"github.com/go-acme/lego/v4/registration"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/remotewebroot"
+	"github.com/trustctl/trusttls/internal/acme/standalone"
 	"github.com/trustctl/trusttls/internal/acme/webrootprovider"
+	"github.com/trustctl/trusttls/internal/resolver"
 )
 
 const (
@@ -29,14 +37,50 @@ const (
 type Options struct {
 	Email   string
 	Server  string
-	KeyType string // rsa|ecdsa
-	KeySize int    // rsa bits or ecdsa curve bits (256/384)
+	KeyType string // rsa|ecdsa; see NormalizeKey
+	KeySize int    // rsa bits or ecdsa curve bits (256/384/521)
 	BaseDir string
+	// Resolvers used for CAA and DNS-01 propagation pre-checks, in the
+	// forms accepted by the resolver package. Empty means TRUSTTLS_RESOLVERS,
+	// then the system resolver.
+	Resolvers []string
+	// PropagationTimeout bounds the wait for DNS-01 records to become
+	// visible. Zero means the DNS provider's own estimate.
+	PropagationTimeout time.Duration
+	// PropagationCheck is PropagationAuthoritative (the default) or
+	// PropagationAll.
+	PropagationCheck string
+	// EABKID and EABHMACKey bind a new account to an existing account at
+	// CAs that require External Account Binding. They are only used when the
+	// account is registered; later runs reuse the stored registration.
+	EABKID     string
+	EABHMACKey string
+	// CABundle is a PEM file of extra roots to trust for Server, for
+	// private ACME CAs and proxies that intercept TLS. With a BaseDir it is
+	// kept with the server's accounts, and used when CABundle is empty.
+	CABundle string
+	// CSR, when set, is submitted as is instead of a request for a newly
+	// generated key; the certificate then comes back without a private key.
+	CSR *x509.CertificateRequest
+	// MustStaple adds the OCSP Must-Staple (TLS Feature) extension to the
+	// generated CSR. A CSR passed in CSR decides for itself.
+	MustStaple bool
+	// IgnoreRateLimits places orders that the issuance log says would
+	// exceed the CA's rate limits, with a warning.
+	IgnoreRateLimits bool
 }
 
 type Manager struct {
-	client *lego.Client
-	opts   Options
+	challenges *legoresolver.SolverManager // this Manager's own: orders set their solvers on it
+	certifier  *certificate.Certifier
+	opts       Options
+	core       *api.Core   // the account's session, shared with other Managers for it
+	orders     string      // where pending orders are kept; empty when no BaseDir is configured
+	retry      *retryAfterTransport
+	account    string      // URL of the ACME account, for transcripts
+
+	resolver *resolver.Resolver // nil when no resolver is usable
+	public   *resolver.Resolver // where DNS-01 records must be visible
 }
 
 // user implements lego User interface
@@ -52,30 +96,45 @@ func (u *user) GetPrivateKey() crypto.PrivateKey        { return u.key }
 
 func NewManager(opts Options) (*Manager, error) {
 	if opts.Email == "" || opts.Server == "" { return nil, errors.New("email and server required") }
-	if opts.KeyType == "" { opts.KeyType = "rsa" }
-	if opts.KeySize == 0 { if opts.KeyType == "rsa" { opts.KeySize = 2048 } else { opts.KeySize = 256 } }
-
-	priv, err := generateKey(opts.KeyType, opts.KeySize)
+	keyType, keySize, err := NormalizeKey(opts.KeyType, opts.KeySize)
 	if err != nil { return nil, err }
-	u := &user{ Email: opts.Email, key: priv }
-
-	config := lego.NewConfig(u)
-	config.CADirURL = opts.Server
-	config.UserAgent = "trusttls/1.0"
-	config.HTTPClient = &http.Client{ Timeout: 30 * time.Second }
+	opts.KeyType, opts.KeySize = keyType, keySize
+	switch opts.PropagationCheck {
+	case "": opts.PropagationCheck = PropagationAuthoritative
+	case PropagationAuthoritative, PropagationAll:
+	default: return nil, fmt.Errorf("unknown propagation check %q (use %s or %s)", opts.PropagationCheck, PropagationAuthoritative, PropagationAll)
+	}
 
-	client, err := lego.NewClient(config)
+	if opts.BaseDir != "" {
+		if opts.CABundle, err = caBundleFor(opts.BaseDir, opts.Server, opts.Email, opts.CABundle); err != nil { return nil, err }
+	}
+	sess, err := sessionFor(opts)
 	if err != nil { return nil, err }
-
-	if err := client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "")); err != nil {
+	challenges := legoresolver.NewSolversManager(sess.core)
+	if err := challenges.SetHTTP01Provider(http01.NewProviderServer("", "")); err != nil {
 		return nil, fmt.Errorf("set http01 provider: %w", err)
 	}
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
-	if err != nil && !alreadyRegistered(err) {
-		return nil, err
+	certifier := certificate.NewCertifier(sess.core, legoresolver.NewProber(challenges), certificate.CertifierOptions{KeyType: certcrypto.RSA2048, Timeout: requestTimeout})
+	m := &Manager{ challenges: challenges, certifier: certifier, opts: opts, core: sess.core, retry: sess.retry }
+	if sess.user.Registration != nil {
+		m.account = sess.user.Registration.URI
+	}
+	if sess.dir != "" {
+		m.orders = filepath.Join(sess.dir, "orders")
+	}
+	specs := opts.Resolvers
+	if len(specs) == 0 {
+		specs = resolver.Split(os.Getenv(resolver.EnvVar))
 	}
-	u.Registration = reg
-	return &Manager{ client: client, opts: opts }, nil
+	if len(specs) > 0 {
+		if m.resolver, err = resolver.New(specs); err != nil { return nil, err }
+		m.public = m.resolver
+	} else {
+		// Without a system resolver the CAA check is skipped, not fatal.
+		m.resolver, _ = resolver.New(nil)
+		if m.public, err = resolver.New(resolver.Public); err != nil { return nil, err }
+	}
+	return m, nil
 }
 
 func alreadyRegistered(err error) bool {
@@ -89,29 +148,98 @@ func alreadyRegistered(err error) bool {
 
 // ObtainHTTP01 obtains a certificate for domains using HTTP-01 via a webroot path.
 func (m *Manager) ObtainHTTP01(domains []string, webroot string) (*certificate.Resource, error) {
-	provider := webrootprovider.New(webroot)
-	if err := m.client.Challenge.SetHTTP01Provider(provider); err != nil { return nil, err }
-	req := certificate.ObtainRequest{ Domains: domains, Bundle: true }
-	return m.client.Certificate.Obtain(req)
+	return m.obtainHTTP01(domains, onInterrupt(webrootprovider.New(webroot), "challenge file"))
 }
 
-func generateKey(kind string, size int) (crypto.PrivateKey, error) {
-	switch kind {
-	case "rsa":
-		if size < 2048 { size = 2048 }
-		return rsa.GenerateKey(rand.Reader, size)
-	case "ecdsa":
-		var curve elliptic.Curve
-		switch size {
-		case 384:
-			curve = elliptic.P384()
-		default:
-			curve = elliptic.P256()
+// ObtainHTTP01Remote obtains a certificate using HTTP-01 by uploading challenge
+// files to a webroot reachable over FTP, FTPS or SFTP.
+func (m *Manager) ObtainHTTP01Remote(domains []string, remoteURL string) (*certificate.Resource, error) {
+	provider, err := remotewebroot.New(remoteURL)
+	if err != nil { return nil, err }
+	return m.obtainHTTP01(domains, onInterrupt(provider, "uploaded challenge file"))
+}
+
+// ObtainHTTP01Standalone obtains a certificate using HTTP-01 answered by the
+// built-in server srv. When the CA rejects the challenge, the requests srv
+// received are added to the error.
+func (m *Manager) ObtainHTTP01Standalone(domains []string, srv *standalone.Server) (*certificate.Resource, error) {
+	cert, err := m.obtainHTTP01(domains, srv)
+	if err != nil && strings.Contains(err.Error(), "acme: error") {
+		return nil, fmt.Errorf("%w\n%v", err, srv.Explain())
+	}
+	return cert, err
+}
+
+func (m *Manager) obtainHTTP01(domains []string, provider challenge.Provider) (*certificate.Resource, error) {
+	for _, d := range domains {
+		if strings.HasPrefix(d, "*.") {
+			return nil, fmt.Errorf("%s is a wildcard; wildcard certificates can only be validated with DNS-01", d)
 		}
-		return ecdsa.GenerateKey(curve, rand.Reader)
-	default:
-		return nil, fmt.Errorf("unknown key type: %s", kind)
 	}
+	return m.obtain(domains, func() error {
+		if err := m.challenges.SetHTTP01Provider(provider); err != nil { return err }
+		m.challenges.Remove(challenge.DNS01)
+		return nil
+	})
+}
+
+// obtain runs an order for domains after setup has put the challenge solver
+// in place. Names the CA still holds a valid authorization for are not
+// validated again: the order's authorizations say so, and only pending ones
+// are solved. Rate limit errors say when to try again.
+func (m *Manager) obtain(domains []string, setup func() error) (*certificate.Resource, error) {
+	cert, err := m.obtainOnce(domains, setup)
+	if err != nil { return nil, m.withRetryAfter(err) }
+	return cert, nil
+}
+
+func (m *Manager) obtainOnce(domains []string, setup func() error) (*certificate.Resource, error) {
+	if err := m.checkCAA(domains); err != nil { return nil, err }
+	if err := setup(); err != nil { return nil, err }
+	return m.order(domains)
+}
+
+// ObtainDNS01 obtains a certificate for domains using DNS-01 through the named
+// DNS provider. HTTP-01 is disabled for the order so hosts without a reachable
+// port 80 can still be validated.
+func (m *Manager) ObtainDNS01(domains []string, providerName string, creds dnsprovider.Credentials) (*certificate.Resource, error) {
+	if err := rejectIPs(domains); err != nil { return nil, err }
+	return m.obtain(domains, func() error {
+		provider, err := dnsprovider.New(providerName, creds)
+		if err != nil { return err }
+		timeout := m.opts.PropagationTimeout
+		if timeout <= 0 {
+			timeout = defaultPropagationTimeout
+			if p, ok := provider.(challenge.ProviderTimeout); ok {
+				timeout, _ = p.Timeout()
+			}
+		}
+		// lego's own wait uses the provider's timeout, so report ours.
+		provider = withTimeout(onInterrupt(provider, "DNS challenge record"), timeout)
+		if err := m.challenges.SetDNS01Provider(provider, m.propagationCheck(timeout)); err != nil { return err }
+		m.challenges.Remove(challenge.HTTP01)
+		return nil
+	})
+}
+
+// Revoke asks the CA to revoke the PEM certificate certPEM, which this
+// account ordered.
+func (m *Manager) Revoke(certPEM []byte) error {
+	return m.certifier.Revoke(certPEM)
+}
+
+// GenerateKey creates an RSA or ECDSA private key of the requested size,
+// after checking it with NormalizeKey.
+func GenerateKey(kind string, size int) (crypto.PrivateKey, error) {
+	kind, size, err := NormalizeKey(kind, size)
+	if err != nil {
+		return nil, err
+	}
+	if kind == KeyRSA {
+		return rsa.GenerateKey(rand.Reader, size)
+	}
+	curve := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}[size]
+	return ecdsa.GenerateKey(curve, rand.Reader)
 }
 
 func MarshalPrivateKeyToPEM(key crypto.PrivateKey) ([]byte, error) {
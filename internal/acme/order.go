@@ -0,0 +1,314 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/resolver"
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// finalizeTimeout bounds the wait for a CA to issue a finalized order. An
+// order still processing after that is resumed by the next run.
+const finalizeTimeout = 60 * time.Second
+
+// pendingOrder is an ACME order kept on disk until its certificate has been
+// downloaded, so a run interrupted by a network failure or Ctrl-C picks the
+// order up again instead of placing a new one against the CA's rate limits.
+type pendingOrder struct {
+	URL            string            `json:"url"`
+	Domains        []string          `json:"domains"`
+	Created        time.Time         `json:"created"`
+	Expires        time.Time         `json:"expires,omitempty"`
+	Status         string            `json:"status"`
+	Authorizations map[string]string `json:"authorizations,omitempty"` // identifier -> status as last seen
+	KeyPEM         string            `json:"key_pem,omitempty"`        // key of the CSR; empty when Options.CSR is submitted
+	// Solved lists the names whose challenge this order solved, rather
+	// than relying on an earlier validation
+	Solved []string `json:"solved,omitempty"`
+}
+
+// orderPath returns where the pending order for domains is kept, or "" when
+// the manager has no store. Orders for a given CSR are kept apart from
+// orders for a generated key.
+func (m *Manager) orderPath(domains []string) string {
+	if m.orders == "" {
+		return ""
+	}
+	names := make([]string, len(domains))
+	for i, d := range domains {
+		names[i] = strings.ToLower(d)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, ",")
+	if m.opts.CSR != nil {
+		key += "\x00" + string(m.opts.CSR.Raw)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(m.orders, hex.EncodeToString(sum[:8])+".json")
+}
+
+func loadPendingOrder(path string) (*pendingOrder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := seal.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var o pendingOrder
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &o, nil
+}
+
+func (o *pendingOrder) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return seal.WriteFile(path, b, 0600)
+}
+
+// order requests a certificate for domains, resuming the order an earlier
+// run left pending for the same names when the CA still has it. Only
+// authorizations still pending are solved.
+//
+// The CSR carries the first name as common name unless it is an IP address,
+// which CAs issuing IP address certificates refuse there. A CSR given in
+// Options is sent unchanged.
+func (m *Manager) order(domains []string) (*certificate.Resource, error) {
+	if m.opts.CSR != nil {
+		domains = CSRNames(m.opts.CSR)
+	}
+	path := m.orderPath(domains)
+	o, ord, err := m.resumeOrder(path, domains)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		if o, ord, err = m.newOrder(path, domains); err != nil {
+			return nil, err
+		}
+	}
+
+	if ord.Status == acme.StatusPending {
+		if err := m.authorize(path, o, ord); err != nil {
+			return nil, m.keepPending(path, o, err)
+		}
+		if ord, err = m.core.Orders.Get(o.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	var key crypto.PrivateKey
+	csr := m.opts.CSR
+	if o.KeyPEM != "" {
+		if key, err = certcrypto.ParsePEMPrivateKey([]byte(o.KeyPEM)); err != nil {
+			return nil, fmt.Errorf("pending order %s: %w", o.URL, err)
+		}
+		if csr, err = newCSR(key, domains, m.opts.MustStaple); err != nil {
+			return nil, err
+		}
+	}
+	if ord.Status == acme.StatusReady {
+		if ord, err = m.core.Orders.UpdateForCSR(ord.Finalize, csr.Raw); err != nil {
+			return nil, m.keepPending(path, o, err)
+		}
+	}
+	if ord, err = m.awaitIssuance(o.URL, ord); err != nil {
+		return nil, m.keepPending(path, o, err)
+	}
+
+	cert, issuer, err := m.core.Certificates.Get(ord.Certificate, true)
+	if err != nil {
+		return nil, m.keepPending(path, o, err)
+	}
+	res := &certificate.Resource{
+		Domain:            domains[0],
+		CertURL:           ord.Certificate,
+		CertStableURL:     ord.Certificate,
+		Certificate:       cert,
+		IssuerCertificate: issuer,
+		PrivateKey:        []byte(o.KeyPEM),
+	}
+	if o.KeyPEM == "" {
+		res.PrivateKey = nil
+		res.CSR = certcrypto.PEMEncode(csr)
+	}
+	transcripts.Store(ord.Certificate, m.transcript(o, ord))
+	if path != "" {
+		os.Remove(path)
+	}
+	if _, tracked := RateLimitsFor(m.opts.Server); tracked && m.opts.BaseDir != "" {
+		if err := recordIssuance(m.opts.BaseDir, m.opts.Server, domains); err != nil {
+			fmt.Printf("⚠️  could not log the issuance for rate limit tracking: %v\n", err)
+		}
+	}
+	return res, nil
+}
+
+// resumeOrder returns the order saved at path when the CA still has it open,
+// with its current state. Orders the CA has closed or forgotten are dropped
+// and nil is returned.
+func (m *Manager) resumeOrder(path string, domains []string) (*pendingOrder, acme.ExtendedOrder, error) {
+	o, err := loadPendingOrder(path)
+	if err != nil || o == nil {
+		return nil, acme.ExtendedOrder{}, err
+	}
+	if !SameNames(o.Domains, domains) || (!o.Expires.IsZero() && time.Now().After(o.Expires)) {
+		os.Remove(path)
+		return nil, acme.ExtendedOrder{}, nil
+	}
+	ord, err := m.core.Orders.Get(o.URL)
+	if err != nil {
+		fmt.Printf("Order %s placed %s can no longer be resumed (%v); placing a new order\n", o.URL, o.Created.Format(time.RFC1123), err)
+		os.Remove(path)
+		return nil, acme.ExtendedOrder{}, nil
+	}
+	switch ord.Status {
+	case acme.StatusPending, acme.StatusReady, acme.StatusProcessing, acme.StatusValid:
+	default:
+		os.Remove(path)
+		return nil, acme.ExtendedOrder{}, nil
+	}
+	fmt.Printf("Resuming ACME order for %s placed %s (%s)\n", strings.Join(domains, ", "), o.Created.Format(time.RFC1123), ord.Status)
+	ord.Location = o.URL
+	return o, ord, nil
+}
+
+// newOrder places an order for domains and saves it, with the key of the
+// certificate to be, before any challenge is attempted. Orders the issuance
+// log says the CA would refuse for its rate limits are not placed.
+func (m *Manager) newOrder(path string, domains []string) (*pendingOrder, acme.ExtendedOrder, error) {
+	if err := m.checkRateLimits(domains); err != nil {
+		return nil, acme.ExtendedOrder{}, err
+	}
+	o := &pendingOrder{Domains: domains, Created: time.Now().UTC()}
+	if m.opts.CSR == nil {
+		key, err := GenerateKey(m.opts.KeyType, m.opts.KeySize)
+		if err != nil {
+			return nil, acme.ExtendedOrder{}, err
+		}
+		keyPEM, err := MarshalPrivateKeyToPEM(key)
+		if err != nil {
+			return nil, acme.ExtendedOrder{}, err
+		}
+		o.KeyPEM = string(keyPEM)
+	}
+	ord, err := m.core.Orders.New(domains)
+	if err != nil {
+		return nil, acme.ExtendedOrder{}, err
+	}
+	o.URL, o.Status = ord.Location, ord.Status
+	if t, err := time.Parse(time.RFC3339, ord.Expires); err == nil {
+		o.Expires = t
+	}
+	if err := o.save(path); err != nil {
+		return nil, acme.ExtendedOrder{}, fmt.Errorf("save pending order %s: %w", o.URL, err)
+	}
+	return o, ord, nil
+}
+
+// authorize solves the challenges of ord's pending authorizations and
+// records how far it got, so an interrupted run knows which names are done.
+func (m *Manager) authorize(path string, o *pendingOrder, ord acme.ExtendedOrder) error {
+	var authzs []acme.Authorization
+	pending := false
+	o.Authorizations = map[string]string{}
+	for _, u := range ord.Authorizations {
+		a, err := m.core.Authorizations.Get(u)
+		if err != nil {
+			return err
+		}
+		authzs = append(authzs, a)
+		o.Authorizations[a.Identifier.Value] = a.Status
+		pending = pending || a.Status != acme.StatusValid
+	}
+	o.Status = ord.Status
+	if err := o.save(path); err != nil {
+		return err
+	}
+	if !pending {
+		return nil
+	}
+	solveErr := resolver.NewProber(m.challenges).Solve(authzs)
+	var after []acme.Authorization
+	for _, u := range ord.Authorizations {
+		if a, err := m.core.Authorizations.Get(u); err == nil {
+			if o.Authorizations[a.Identifier.Value] != acme.StatusValid && a.Status == acme.StatusValid {
+				o.Solved = append(o.Solved, a.Identifier.Value)
+			}
+			o.Authorizations[a.Identifier.Value] = a.Status
+			after = append(after, a)
+		}
+	}
+	if err := o.save(path); err != nil && solveErr == nil {
+		return err
+	}
+	if solveErr != nil {
+		// lego's per-name errors do not unwrap; the authorizations say why
+		return validationProblem(after, solveErr)
+	}
+	return nil
+}
+
+// awaitIssuance polls the order at url until the CA has issued its
+// certificate.
+func (m *Manager) awaitIssuance(url string, ord acme.ExtendedOrder) (acme.ExtendedOrder, error) {
+	deadline := time.Now().Add(finalizeTimeout)
+	for {
+		switch ord.Status {
+		case acme.StatusValid:
+			return ord, nil
+		case acme.StatusInvalid:
+			if ord.Error != nil {
+				return ord, ord.Error
+			}
+			return ord, fmt.Errorf("order %s is invalid", url)
+		}
+		if time.Now().After(deadline) {
+			return ord, fmt.Errorf("order %s still %s after %s", url, ord.Status, finalizeTimeout)
+		}
+		time.Sleep(time.Second)
+		var err error
+		if ord, err = m.core.Orders.Get(url); err != nil {
+			return ord, err
+		}
+	}
+}
+
+// keepPending explains how to resume an order that err interrupted, unless
+// the CA has closed it.
+func (m *Manager) keepPending(path string, o *pendingOrder, err error) error {
+	if path == "" {
+		return err
+	}
+	if ord, gerr := m.core.Orders.Get(o.URL); gerr == nil && ord.Status == acme.StatusInvalid {
+		os.Remove(path)
+		return err
+	}
+	return fmt.Errorf("%w; the order stays open, run the same command again to resume it", err)
+}
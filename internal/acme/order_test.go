@@ -0,0 +1,248 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA is an ACME server that, like Boulder, hands a new order the valid
+// authorization an earlier order of the account left for a name instead of
+// a fresh pending one. Challenges are valid as soon as they are answered.
+type fakeCA struct {
+	*httptest.Server
+	key  *ecdsa.PrivateKey
+	root *x509.Certificate
+
+	mu     sync.Mutex
+	n      int
+	authzs map[string]*fakeAuthz // by ID
+	valid  map[string]string     // name -> ID of its valid authorization
+	orders map[string]*fakeOrder // by ID
+	certs  map[string][]byte     // by ID
+}
+
+type fakeAuthz struct {
+	id, name, status string
+}
+
+type fakeOrder struct {
+	id, status, cert string
+	names            []string
+	authzs           []string
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "fake CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &fakeCA{key: key, root: root, authzs: map[string]*fakeAuthz{}, valid: map[string]string{}, orders: map[string]*fakeOrder{}, certs: map[string][]byte{}}
+	ca.Server = httptest.NewTLSServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.Close)
+	return ca
+}
+
+func (ca *fakeCA) next() string {
+	ca.n++
+	return fmt.Sprint(ca.n)
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, code int, v any, location string) {
+	w.Header().Set("Replay-Nonce", fmt.Sprint("nonce", time.Now().UnixNano()))
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) handle(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	base := ca.URL
+	p := r.URL.Path
+	var jws struct{ Payload string }
+	body, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(body, &jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	id := p[strings.LastIndex(p, "/")+1:]
+	switch {
+	case p == "/dir":
+		ca.reply(w, 200, map[string]string{"newNonce": base + "/nonce", "newAccount": base + "/acct", "newOrder": base + "/new-order", "revokeCert": base + "/revoke", "keyChange": base + "/key-change"}, "")
+	case p == "/nonce":
+		w.Header().Set("Replay-Nonce", fmt.Sprint("nonce", time.Now().UnixNano()))
+	case p == "/acct":
+		ca.reply(w, 201, map[string]any{"status": "valid"}, base+"/acct/1")
+	case p == "/new-order":
+		var req struct{ Identifiers []map[string]string }
+		_ = json.Unmarshal(payload, &req)
+		o := &fakeOrder{id: ca.next(), status: "pending"}
+		for _, ident := range req.Identifiers {
+			name := ident["value"]
+			o.names = append(o.names, name)
+			aid, ok := ca.valid[name]
+			if !ok {
+				aid = ca.next()
+				ca.authzs[aid] = &fakeAuthz{id: aid, name: name, status: "pending"}
+			}
+			o.authzs = append(o.authzs, aid)
+		}
+		ca.orders[o.id] = o
+		ca.reply(w, 201, ca.orderJSON(o), base+"/order/"+o.id)
+	case strings.HasPrefix(p, "/order/"):
+		ca.reply(w, 200, ca.orderJSON(ca.orders[id]), "")
+	case strings.HasPrefix(p, "/authz/"):
+		a := ca.authzs[id]
+		ca.reply(w, 200, map[string]any{"status": a.status, "expires": time.Now().Add(time.Hour).Format(time.RFC3339),
+			"identifier": map[string]string{"type": "dns", "value": a.name}, "challenges": []any{ca.challengeJSON(a)}}, "")
+	case strings.HasPrefix(p, "/challenge/"):
+		a := ca.authzs[id]
+		a.status = "valid"
+		ca.valid[a.name] = a.id
+		w.Header().Set("Link", "<"+base+"/authz/"+a.id+">;rel=\"up\"")
+		ca.reply(w, 200, ca.challengeJSON(a), "")
+	case strings.HasPrefix(p, "/finalize/"):
+		o := ca.orders[id]
+		var req struct{ CSR string }
+		_ = json.Unmarshal(payload, &req)
+		raw, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(raw)
+		if err != nil {
+			ca.reply(w, 400, map[string]string{"type": "urn:ietf:params:acme:error:badCSR", "detail": err.Error()}, "")
+			return
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: csr.DNSNames[0]}, DNSNames: csr.DNSNames,
+			NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.root, csr.PublicKey, ca.key)
+		if err != nil {
+			ca.reply(w, 500, map[string]string{"type": "urn:ietf:params:acme:error:serverInternal", "detail": err.Error()}, "")
+			return
+		}
+		cid := ca.next()
+		ca.certs[cid] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})...)
+		o.status, o.cert = "valid", base+"/cert/"+cid
+		ca.reply(w, 200, ca.orderJSON(o), base+"/order/"+o.id)
+	case strings.HasPrefix(p, "/cert/"):
+		w.Header().Set("Replay-Nonce", fmt.Sprint("nonce", time.Now().UnixNano()))
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.certs[id])
+	default:
+		ca.reply(w, 404, map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": "no " + p}, "")
+	}
+}
+
+func (ca *fakeCA) orderJSON(o *fakeOrder) map[string]any {
+	if o.status == "pending" {
+		ready := true
+		for _, aid := range o.authzs {
+			ready = ready && ca.authzs[aid].status == "valid"
+		}
+		if ready {
+			o.status = "ready"
+		}
+	}
+	var idents []map[string]string
+	var urls []string
+	for i, name := range o.names {
+		idents = append(idents, map[string]string{"type": "dns", "value": name})
+		urls = append(urls, ca.URL+"/authz/"+o.authzs[i])
+	}
+	m := map[string]any{"status": o.status, "expires": time.Now().Add(time.Hour).Format(time.RFC3339), "identifiers": idents,
+		"authorizations": urls, "finalize": ca.URL + "/finalize/" + o.id}
+	if o.cert != "" {
+		m["certificate"] = o.cert
+	}
+	return m
+}
+
+func (ca *fakeCA) challengeJSON(a *fakeAuthz) map[string]any {
+	return map[string]any{"type": "http-01", "url": ca.URL + "/challenge/" + a.id, "token": "token" + a.id, "status": a.status}
+}
+
+// presenter is an HTTP-01 provider recording the names it was asked to
+// answer a challenge for.
+type presenter struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (p *presenter) Present(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names = append(p.names, domain)
+	return nil
+}
+
+func (p *presenter) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func (p *presenter) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := p.names
+	p.names = nil
+	sort.Strings(names)
+	return names
+}
+
+// TestValidAuthorizationNotPresented orders certificates for names the
+// account already validated and checks that only the names without a valid
+// authorization get their challenge presented again. Which authorizations
+// are still valid is the CA's to say; it says so in each new order.
+func TestValidAuthorizationNotPresented(t *testing.T) {
+	ca := newFakeCA(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(Options{Email: "admin@example.com", Server: ca.URL + "/dir", CABundle: bundle, BaseDir: filepath.Join(dir, "store")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &presenter{}
+	for _, step := range []struct {
+		names, presented []string
+	}{
+		{names: []string{"a.example.com"}, presented: []string{"a.example.com"}},
+		{names: []string{"a.example.com"}},
+		{names: []string{"a.example.com", "b.example.com"}, presented: []string{"b.example.com"}},
+		{names: []string{"b.example.com", "a.example.com"}},
+	} {
+		if _, err := m.obtainHTTP01(step.names, p); err != nil {
+			t.Fatalf("%v: %v", step.names, err)
+		}
+		if got := p.take(); strings.Join(got, ",") != strings.Join(step.presented, ",") {
+			t.Fatalf("%v: presented challenges for %v, want %v", step.names, got, step.presented)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// OrderWait controls how a long-running CA order is awaited. Polling starts at
+// Initial and doubles up to Max until Deadline passes. When WebhookListen is
+// set, an HTTP listener is started for the duration of the wait and any
+// matching notification (e.g. a CertCentral order status webhook) triggers an
+// immediate poll; the notification itself is never trusted as proof of
+// issuance.
+type OrderWait struct {
+	Initial       time.Duration
+	Max           time.Duration
+	Deadline      time.Duration
+	WebhookListen string
+	WebhookPath   string
+}
+
+const (
+	defaultOrderPollInitial = 5 * time.Second
+	defaultOrderPollMax     = 5 * time.Minute
+	defaultOrderDeadline    = 30 * time.Minute
+	defaultOrderWebhookPath = "/digicert/webhook"
+)
+
+var errOrderPending = errors.New("order pending")
+
+func (w OrderWait) withDefaults() OrderWait {
+	if w.Initial <= 0 {
+		w.Initial = defaultOrderPollInitial
+	}
+	if w.Max <= 0 {
+		w.Max = defaultOrderPollMax
+	}
+	if w.Deadline <= 0 {
+		w.Deadline = defaultOrderDeadline
+	}
+	if w.WebhookPath == "" {
+		w.WebhookPath = defaultOrderWebhookPath
+	}
+	return w
+}
+
+// waitForOrder calls poll until it returns nil or a non-pending error, the
+// deadline passes or ctx is cancelled. poll returns errOrderPending while the
+// order is not done.
+func waitForOrder(ctx context.Context, orderID string, w OrderWait, poll func() error) error {
+	w = w.withDefaults()
+
+	wake := make(chan struct{}, 1)
+	if w.WebhookListen != "" {
+		stop, err := listenOrderWebhook(w.WebhookListen, w.WebhookPath, orderID, wake)
+		if err != nil {
+			return fmt.Errorf("start order webhook listener: %w", err)
+		}
+		defer stop()
+	}
+
+	deadline := time.Now().Add(w.Deadline)
+	delay := w.Initial
+	for {
+		err := poll()
+		if !errors.Is(err, errOrderPending) {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("order %s not completed after %s", orderID, w.Deadline)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("order %s: %w", orderID, ctx.Err())
+		}
+		if delay *= 2; delay > w.Max {
+			delay = w.Max
+		}
+	}
+}
+
+// listenOrderWebhook serves path on addr and signals wake whenever a POST
+// mentions orderID (or carries no order ID at all).
+func listenOrderWebhook(addr, path, orderID string, wake chan<- struct{}) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body)
+		id, ok := body["order_id"]
+		if !ok || fmt.Sprint(id) == orderID {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,273 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/log"
+	"github.com/miekg/dns"
+	"github.com/trustctl/trusttls/internal/resolver"
+)
+
+// caaIdentifiers returns the issuer domain names the CA behind an ACME
+// directory accepts in CAA records, or nil when the CA is not known.
+func caaIdentifiers(server string) []string {
+	s := strings.ToLower(server)
+	switch {
+	case strings.Contains(s, "letsencrypt.org"):
+		return []string{"letsencrypt.org"}
+	case strings.Contains(s, "digicert.com"):
+		return []string{"digicert.com", "www.digicert.com"}
+	case strings.Contains(s, "sectigo.com"):
+		return []string{"sectigo.com", "comodoca.com"}
+	}
+	return nil
+}
+
+// checkCAA fails when a CAA record set that applies to one of the domains
+// names issuers and none of them is the ordering CA, so the order is not
+// placed only to be refused. Lookup failures are not treated as a refusal;
+// the CA makes the authoritative check anyway.
+func (m *Manager) checkCAA(domains []string) error {
+	ids := caaIdentifiers(m.opts.Server)
+	if m.resolver == nil || len(ids) == 0 {
+		return nil
+	}
+	for _, d := range domains {
+		if IsIP(d) {
+			// CAA lives in DNS and has no say over IP addresses
+			continue
+		}
+		issuers, at, ok := m.caaIssuers(d)
+		if !ok || issuers == nil {
+			continue
+		}
+		if !permits(issuers, ids) {
+			tag := "issue"
+			if strings.HasPrefix(d, "*.") {
+				tag = "issuewild"
+			}
+			return &Problem{Code: CodeCAA, Identifier: d, Err: fmt.Errorf("CAA records at %s do not allow %s to issue for %s (allowed: %s); add a CAA record such as: %s CAA 0 %s %q",
+				at, ids[0], d, describeIssuers(issuers), at, tag, ids[0])}
+		}
+	}
+	return nil
+}
+
+// caaIssuers walks from domain towards the root (RFC 8659 section 3) and
+// returns the issuers allowed by the first CAA record set that has issue or
+// issuewild properties. issuers is nil when no record set restricts
+// issuance; ok is false when a lookup failed.
+func (m *Manager) caaIssuers(domain string) (issuers []string, at string, ok bool) {
+	wildcard := strings.HasPrefix(domain, "*.")
+	name := strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+	for name != "" {
+		recs, err := m.resolver.LookupCAA(context.Background(), name)
+		if err != nil {
+			return nil, "", false
+		}
+		var issue, issuewild []string
+		var hasIssue, hasIssuewild bool
+		for _, r := range recs {
+			switch strings.ToLower(r.Tag) {
+			case "issue":
+				hasIssue = true
+				issue = append(issue, caaIssuer(r.Value))
+			case "issuewild":
+				hasIssuewild = true
+				issuewild = append(issuewild, caaIssuer(r.Value))
+			}
+		}
+		if wildcard && hasIssuewild {
+			return nonNil(issuewild), name, true
+		}
+		if hasIssue {
+			return nonNil(issue), name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return nil, "", true
+}
+
+// caaIssuer returns the issuer domain of a property value such as
+// "letsencrypt.org; validationmethods=dns-01", or "" for ";" (no issuer).
+func caaIssuer(value string) string {
+	v, _, _ := strings.Cut(value, ";")
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func permits(issuers, ids []string) bool {
+	for _, is := range issuers {
+		for _, id := range ids {
+			if is == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describeIssuers(issuers []string) string {
+	var named []string
+	for _, is := range issuers {
+		if is != "" {
+			named = append(named, is)
+		}
+	}
+	if len(named) == 0 {
+		return "none"
+	}
+	return strings.Join(named, ", ")
+}
+
+// defaultPropagationTimeout applies when neither the user nor the DNS
+// provider says how long record changes take to show up.
+const defaultPropagationTimeout = 2 * time.Minute
+
+// Propagation check modes.
+const (
+	// PropagationAuthoritative asks only the zone's nameservers, so answers
+	// cached by recursive resolvers (including negative answers from before
+	// the record existed) cannot hold up or fail the check.
+	PropagationAuthoritative = "authoritative"
+	// PropagationAll also requires the record on the public resolvers.
+	PropagationAll = "all"
+)
+
+// propagationCheck replaces lego's DNS-01 pre-check: the CA is only told to
+// validate once the TXT record answers on each of the zone's authoritative
+// nameservers (and on the public resolvers with PropagationAll).
+func (m *Manager) propagationCheck(timeout time.Duration) dns01.ChallengeOption {
+	return dns01.WrapPreCheck(func(domain, fqdn, value string, _ dns01.PreCheckFunc) (bool, error) {
+		if err := m.waitForTXT(domain, fqdn, value, timeout); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// waitForTXT polls until value is published at fqdn everywhere it is checked,
+// backing off from 2s to 30s between rounds. Nameservers are found through
+// the public resolvers, not the system resolver, so an internal view of the
+// zone does not get in the way.
+func (m *Manager) waitForTXT(domain, fqdn, value string, timeout time.Duration) error {
+	ctx := context.Background()
+	name := m.public.FollowCNAME(ctx, fqdn)
+	var upstreams []resolver.Upstream
+	if m.opts.PropagationCheck == PropagationAll {
+		upstreams = append(upstreams, m.public.Upstreams()...)
+	}
+	auth, err := m.nameservers(ctx, name)
+	if err != nil {
+		// Outbound DNS to the nameservers may be blocked; public resolvers
+		// are the next best thing.
+		log.Warnf("[%s] %v; checking public resolvers instead", domain, err)
+		if m.opts.PropagationCheck != PropagationAll {
+			upstreams = append(upstreams, m.public.Upstreams()...)
+		}
+	}
+	upstreams = append(upstreams, auth...)
+
+	deadline := time.Now().Add(timeout)
+	delay := 2 * time.Second
+	for {
+		var missing []string
+		for _, u := range upstreams {
+			resp, err := resolver.Ask(ctx, u, name, dns.TypeTXT)
+			if err != nil || !contains(resolver.TXT(resp), value) {
+				missing = append(missing, u.String())
+			}
+		}
+		if len(missing) == 0 {
+			log.Infof("[%s] TXT record %s is visible on all checked servers (%d)", domain, dns01.UnFqdn(name), len(upstreams))
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("TXT record %s still not visible on %s after %s; raise --propagation-timeout if your DNS host is slow",
+				dns01.UnFqdn(name), strings.Join(missing, ", "), timeout)
+		}
+		log.Infof("[%s] TXT record %s not yet visible on %s, checking again in %s", domain, dns01.UnFqdn(name), strings.Join(missing, ", "), delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// nameservers returns upstreams for the authoritative servers of the zone
+// holding name that answer queries. Servers that cannot be reached at all are
+// left out so a firewalled secondary does not stall the check.
+func (m *Manager) nameservers(ctx context.Context, name string) ([]resolver.Upstream, error) {
+	zone, err := m.public.FindZone(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not find the zone of %s: %w", dns01.UnFqdn(name), err)
+	}
+	all, err := m.public.Authoritative(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up the nameservers of %s: %w", dns01.UnFqdn(zone), err)
+	}
+	var reachable []resolver.Upstream
+	for _, u := range all {
+		if _, err := resolver.Ask(ctx, u, zone, dns.TypeSOA); err == nil {
+			reachable = append(reachable, u)
+		}
+	}
+	if len(reachable) == 0 {
+		return nil, fmt.Errorf("none of the nameservers of %s answered", dns01.UnFqdn(zone))
+	}
+	return reachable, nil
+}
+
+// withTimeout overrides the propagation timeout a DNS provider reports,
+// keeping lego's sequential mode for providers that ask for it.
+func withTimeout(p challenge.Provider, timeout time.Duration) challenge.Provider {
+	t := timedProvider{Provider: p, timeout: timeout}
+	if s, ok := p.(sequential); ok {
+		return timedSequentialProvider{timedProvider: t, seq: s}
+	}
+	return t
+}
+
+type sequential interface {
+	Sequential() time.Duration
+}
+
+type timedProvider struct {
+	challenge.Provider
+	timeout time.Duration
+}
+
+func (p timedProvider) Timeout() (timeout, interval time.Duration) {
+	return p.timeout, 2 * time.Second
+}
+
+type timedSequentialProvider struct {
+	timedProvider
+	seq sequential
+}
+
+func (p timedSequentialProvider) Sequential() time.Duration { return p.seq.Sequential() }
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package acme
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset describes a commercial ACME CA whose directory URL depends on the
+// certificate profile ordered, so users can pick "sectigo" and "ov" instead
+// of looking up the URL.
+type Preset struct {
+	Name           string
+	Description    string
+	Profiles       map[string]string // profile name -> directory URL
+	DefaultProfile string
+	Capabilities
+}
+
+var presets = map[string]Preset{
+	"sectigo": {
+		Name:        "sectigo",
+		Description: "Sectigo Certificate Manager",
+		Profiles: map[string]string{
+			"dv": "https://acme.sectigo.com/v2/DV",
+			"ov": "https://acme.sectigo.com/v2/OV",
+			"ev": "https://acme.sectigo.com/v2/EV",
+		},
+		DefaultProfile: "ov",
+		Capabilities:   Capabilities{CA: "Sectigo", Wildcard: true, EAB: true, MaxNames: 100, KeySizes: WebPKIKeys},
+	},
+	"incommon": {
+		Name:        "incommon",
+		Description: "InCommon Certificate Service",
+		Profiles: map[string]string{
+			"ov":     "https://acme.sectigo.com/v2/InCommonRSAOV",
+			"ecc-ov": "https://acme.sectigo.com/v2/InCommonECCOV",
+			"ev":     "https://acme.sectigo.com/v2/InCommonRSAEV",
+		},
+		DefaultProfile: "ov",
+		Capabilities:   Capabilities{CA: "InCommon", Wildcard: true, EAB: true, MaxNames: 100, KeySizes: WebPKIKeys},
+	},
+}
+
+// LookupPreset returns the preset called name.
+func LookupPreset(name string) (Preset, bool) {
+	p, ok := presets[strings.ToLower(name)]
+	return p, ok
+}
+
+// PresetNames returns the names of all presets, sorted.
+func PresetNames() []string {
+	var out []string
+	for n := range presets {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ProfileNames returns the profiles of p, sorted.
+func (p Preset) ProfileNames() []string {
+	var out []string
+	for n := range p.Profiles {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Directory returns the directory URL for profile, or for the default
+// profile when profile is empty.
+func (p Preset) Directory(profile string) (string, error) {
+	if profile == "" {
+		profile = p.DefaultProfile
+	}
+	u, ok := p.Profiles[strings.ToLower(profile)]
+	if !ok {
+		return "", fmt.Errorf("%s has no profile %q (available: %s)", p.Name, profile, strings.Join(p.ProfileNames(), ", "))
+	}
+	return u, nil
+}
@@ -0,0 +1,167 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+// Problem codes of the failures trusttls knows a remedy for. Other ACME
+// problems keep the name of their RFC 8555 type, such as "badNonce".
+const (
+	CodeRateLimited  = "rateLimited"
+	CodeUnauthorized = "unauthorized"
+	CodeDNS          = "dns"
+	CodeConnection   = "connection"
+	CodeCAA          = "caa"
+)
+
+// acmeErrorNS prefixes the problem types of RFC 8555 section 6.7.
+const acmeErrorNS = "urn:ietf:params:acme:error:"
+
+// Problem is a classified order failure: an RFC 7807 problem document from
+// the CA, or a failure trusttls detected itself before asking the CA.
+type Problem struct {
+	Code       string    `json:"code"`
+	Type       string    `json:"type,omitempty"` // the problem document's type URN
+	Detail     string    `json:"detail,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`  // name the problem is about, when the CA said
+	RetryAfter time.Time `json:"retry_after,omitempty"` // when a rate limit lets the account try again, when known
+	Err        error     `json:"-"`                     // the error as returned, kept for its message
+}
+
+func (p *Problem) Error() string {
+	if p.Err != nil {
+		return p.Err.Error()
+	}
+	return p.Detail
+}
+
+func (p *Problem) Unwrap() error { return p.Err }
+
+// Help returns what to do about p, as bullet lines, or "" when trusttls
+// knows no better than the CA's message.
+func (p *Problem) Help() string {
+	name := p.Identifier
+	if name == "" {
+		name = "<domain>"
+	}
+	switch p.Code {
+	case CodeRateLimited:
+		if !p.RetryAfter.IsZero() {
+			return fmt.Sprintf("• The CA accepts orders for these names again after %s; renewals wait until then\n"+
+				"• Wait for it to pass instead of retrying: every failed attempt counts too\n"+
+				"• Rehearse against a staging server (install --staging, get-cert --test-mode); Let's Encrypt's limits are at https://letsencrypt.org/docs/rate-limits/", p.RetryAfter.Local().Format(time.RFC1123))
+		}
+		return "• The CA limits how many certificates and failed validations an account may have; the message says which limit and until when\n" +
+			"• Wait for it to pass instead of retrying: every failed attempt counts too\n" +
+			"• Rehearse against a staging server (install --staging, get-cert --test-mode); Let's Encrypt's limits are at https://letsencrypt.org/docs/rate-limits/"
+	case CodeUnauthorized:
+		return fmt.Sprintf("• The CA reached %s but did not get the challenge answer it expected\n"+
+			"• For HTTP-01, check http://%s/.well-known/acme-challenge/ is served from the webroot, without redirects to another host or an auth prompt\n"+
+			"• Make sure every A and AAAA record of %s points at this server\n"+
+			"• For DNS-01, check the _acme-challenge.%s TXT record holds the new value and no stale one", name, name, name, name)
+	case CodeDNS:
+		return fmt.Sprintf("• The CA could not look up %s: check it resolves publicly, e.g. dig +short %s @1.1.1.1\n"+
+			"• Check the zone's nameservers answer and DNSSEC, if signed, validates\n"+
+			"• New records can take a while to propagate; try again once they show up", name, name)
+	case CodeConnection:
+		return fmt.Sprintf("• Allow port 80 (HTTP-01) from the internet to %s in firewalls and security groups\n"+
+			"• Check %s resolves to this server's public address, IPv6 included\n"+
+			"• Check this machine reaches the CA, e.g. curl -I <server URL>; a proxy may be needed\n"+
+			"• Servers that cannot be reached can validate with --dns <provider> instead", name, name)
+	case CodeCAA:
+		zone := strings.TrimPrefix(name, "*.")
+		return fmt.Sprintf("• The CAA records of %s do not allow this CA: check them with dig CAA %s\n"+
+			"• Add the CA's domain, e.g. %s. CAA 0 issue \"letsencrypt.org\", or remove the restriction", name, zone, zone)
+	}
+	return ""
+}
+
+// AsProblem classifies err: problem documents from the CA, including the
+// reasons of failed challenges, and network errors reaching it. ok is false
+// for anything else.
+func AsProblem(err error) (p *Problem, ok bool) {
+	if err == nil {
+		return nil, false
+	}
+	if errors.As(err, &p) {
+		return p, true
+	}
+	var pd *acme.ProblemDetails
+	if errors.As(err, &pd) {
+		return problemFrom(pd, err), true
+	}
+	var nonce *acme.NonceError
+	if errors.As(err, &nonce) && nonce.ProblemDetails != nil {
+		return problemFrom(nonce.ProblemDetails, err), true
+	}
+	// Failures relayed by other components, such as the API client, that
+	// carry a code they were classified as elsewhere
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) && coded.ErrorCode() != "" {
+		return &Problem{Code: coded.ErrorCode(), Detail: err.Error(), Err: err}, true
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return &Problem{Code: CodeConnection, Detail: err.Error(), Err: err}, true
+	}
+	return nil, false
+}
+
+// ErrorCode returns the Problem code of err, or "" when it is not one.
+func ErrorCode(err error) string {
+	if p, ok := AsProblem(err); ok {
+		return p.Code
+	}
+	return ""
+}
+
+// problemFrom classifies the problem document pd, which err carries. A
+// document with subproblems is classified by the first one, which names
+// the identifier at fault.
+func problemFrom(pd *acme.ProblemDetails, err error) *Problem {
+	p := &Problem{Type: pd.Type, Detail: pd.Detail, Status: pd.HTTPStatus, Err: err}
+	if len(pd.SubProblems) > 0 {
+		sub := pd.SubProblems[0]
+		p.Type, p.Detail, p.Identifier = sub.Type, sub.Detail, sub.Identifier.Value
+	}
+	p.Code = strings.TrimPrefix(p.Type, acmeErrorNS)
+	switch p.Code {
+	case "tls":
+		// The CA could not complete the TLS handshake of a validation
+		p.Code = CodeConnection
+	case "incorrectResponse":
+		p.Code = CodeUnauthorized
+	case "":
+		p.Code = "acme"
+	}
+	return p
+}
+
+// validationProblem returns the CA's reason for the first authorization in
+// authzs that failed, carrying solveErr, or solveErr when none says why.
+func validationProblem(authzs []acme.Authorization, solveErr error) error {
+	for _, a := range authzs {
+		if a.Status != acme.StatusInvalid {
+			continue
+		}
+		for _, ch := range a.Challenges {
+			if ch.Error != nil {
+				p := problemFrom(ch.Error, solveErr)
+				if p.Identifier == "" {
+					p.Identifier = a.Identifier.Value
+				}
+				return p
+			}
+		}
+	}
+	return solveErr
+}
@@ -0,0 +1,47 @@
+package acme
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFunc picks the proxy for each request; by default the one named by
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+var proxyFunc = http.ProxyFromEnvironment
+
+// SetProxy sends HTTP and HTTPS requests through the proxy at proxyURL
+// instead of the one the environment names; hosts in NO_PROXY are still
+// reached directly. It changes http.DefaultTransport, so it covers the ACME
+// and DigiCert clients as well as DNS provider APIs, and must be called
+// before any client is set up.
+func SetProxy(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("proxy %q: want a URL such as http://proxy.example.com:3128", proxyURL)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("proxy %q: scheme must be http, https or socks5", proxyURL)
+	}
+	cfg := httpproxy.FromEnvironment()
+	cfg.HTTPProxy, cfg.HTTPSProxy = proxyURL, proxyURL
+	fn := cfg.ProxyFunc()
+	proxyFunc = func(r *http.Request) (*url.URL, error) { return fn(r.URL) }
+	http.DefaultTransport.(*http.Transport).Proxy = proxyFunc
+	return nil
+}
+
+// ProxyFor returns the proxy requests to target go through, or nil when
+// they go directly.
+func ProxyFor(target string) (*url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return proxyFunc(req)
+}
@@ -0,0 +1,365 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// RateLimits are the issuance limits of a CA that trusttls tracks itself,
+// so an order that would hit one is stopped before it counts against the
+// account as a failure.
+type RateLimits struct {
+	PerDomain int           // new certificates per registered domain
+	Duplicate int           // certificates for exactly the same names
+	Window    time.Duration // period both are counted over
+}
+
+// letsEncryptLimits are Let's Encrypt's production limits; renewals, for
+// names certified before, are exempt from the per-domain one.
+var letsEncryptLimits = RateLimits{PerDomain: 50, Duplicate: 5, Window: 7 * 24 * time.Hour}
+
+// issuanceMemory is how long issuances are remembered to tell renewals from
+// new certificates: a little more than the lifetime of a Let's Encrypt
+// certificate.
+const issuanceMemory = 100 * 24 * time.Hour
+
+// RateLimitsFor returns the limits tracked for the ACME directory server.
+// ok is false for CAs whose limits are not tracked, staging included.
+func RateLimitsFor(server string) (RateLimits, bool) {
+	if strings.TrimSuffix(server, "/") == LetsEncryptProd {
+		return letsEncryptLimits, true
+	}
+	return RateLimits{}, false
+}
+
+// Issuance is a certificate issued by an ACME server, as kept in the
+// issuance log.
+type Issuance struct {
+	Server string    `json:"server"`
+	Names  []string  `json:"names"` // lower case, sorted
+	Issued time.Time `json:"issued"`
+}
+
+var issuanceMu sync.Mutex
+
+// IssuancesPath returns where the issuance log of the store at baseDir is
+// kept.
+func IssuancesPath(baseDir string) string {
+	return filepath.Join(baseDir, "issuances.json")
+}
+
+// LoadIssuances returns the issuances logged in the store at baseDir,
+// oldest first.
+func LoadIssuances(baseDir string) ([]Issuance, error) {
+	b, err := os.ReadFile(IssuancesPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Issuance
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", IssuancesPath(baseDir), err)
+	}
+	return out, nil
+}
+
+// recordIssuance adds a certificate for domains issued now to the log,
+// dropping entries too old to matter.
+func recordIssuance(baseDir, server string, domains []string) error {
+	issuanceMu.Lock()
+	defer issuanceMu.Unlock()
+	log, err := LoadIssuances(baseDir)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var kept []Issuance
+	for _, i := range log {
+		if now.Sub(i.Issued) < issuanceMemory {
+			kept = append(kept, i)
+		}
+	}
+	kept = append(kept, Issuance{Server: server, Names: normalizedNames(domains), Issued: now})
+	b, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(IssuancesPath(baseDir), b, 0600)
+}
+
+// RegisteredDomain returns the domain name is registered under, such as
+// example.co.uk for www.example.co.uk, which rate limits are counted by.
+// IP addresses and names without a public suffix are returned as they are.
+func RegisteredDomain(name string) string {
+	name = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(name), "*."), ".")
+	if net.ParseIP(name) != nil {
+		return name
+	}
+	if d, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return d
+	}
+	return name
+}
+
+// RateLimitUsage is how much of each tracked limit an order would use.
+type RateLimitUsage struct {
+	Limits RateLimits
+	// Duplicates counts the certificates for the same names in the window;
+	// DuplicatesFree is when the oldest of them stops counting.
+	Duplicates     int
+	DuplicatesFree time.Time
+	// Renewal is set when the names were certified before, which exempts
+	// the order from the per-domain limit.
+	Renewal bool
+	// Domains counts the certificates per registered domain of the order in
+	// the window; DomainsFree holds when the oldest of them stops counting.
+	Domains     map[string]int
+	DomainsFree map[string]time.Time
+}
+
+// Usage returns how an order from server for domains at now stands against
+// limits, going by log.
+func Usage(log []Issuance, server string, domains []string, limits RateLimits, now time.Time) RateLimitUsage {
+	u := RateLimitUsage{Limits: limits, Domains: map[string]int{}, DomainsFree: map[string]time.Time{}}
+	names := normalizedNames(domains)
+	registered := map[string]bool{}
+	for _, n := range names {
+		registered[RegisteredDomain(n)] = true
+	}
+	for _, i := range log {
+		if strings.TrimSuffix(i.Server, "/") != strings.TrimSuffix(server, "/") {
+			continue
+		}
+		same := SameNames(i.Names, names)
+		u.Renewal = u.Renewal || same
+		if now.Sub(i.Issued) >= limits.Window {
+			continue
+		}
+		free := i.Issued.Add(limits.Window)
+		if same {
+			u.Duplicates++
+			if u.DuplicatesFree.IsZero() || free.Before(u.DuplicatesFree) {
+				u.DuplicatesFree = free
+			}
+		}
+		counted := map[string]bool{}
+		for _, n := range i.Names {
+			d := RegisteredDomain(n)
+			if !registered[d] || counted[d] {
+				continue
+			}
+			counted[d] = true
+			u.Domains[d]++
+			if f, ok := u.DomainsFree[d]; !ok || free.Before(f) {
+				u.DomainsFree[d] = free
+			}
+		}
+	}
+	return u
+}
+
+// Exceeded returns the limit one more certificate would exceed, as a
+// rateLimited Problem saying until when, or nil.
+func (u RateLimitUsage) Exceeded(domains []string) *Problem {
+	days := int(u.Limits.Window.Hours() / 24)
+	if u.Duplicates >= u.Limits.Duplicate {
+		return &Problem{
+			Code:       CodeRateLimited,
+			Detail:     fmt.Sprintf("%d certificates for exactly %s were issued in the last %d days, the most Let's Encrypt allows; retry after %s", u.Duplicates, strings.Join(domains, ", "), days, u.DuplicatesFree.UTC().Format(time.RFC1123)),
+			Identifier: domains[0],
+			RetryAfter: u.DuplicatesFree,
+		}
+	}
+	if u.Renewal {
+		return nil
+	}
+	for _, d := range sortedKeys(u.Domains) {
+		if u.Domains[d] >= u.Limits.PerDomain {
+			return &Problem{
+				Code:       CodeRateLimited,
+				Detail:     fmt.Sprintf("%d certificates for names under %s were issued in the last %d days, the most Let's Encrypt allows for new names; retry after %s", u.Domains[d], d, days, u.DomainsFree[d].UTC().Format(time.RFC1123)),
+				Identifier: d,
+				RetryAfter: u.DomainsFree[d],
+			}
+		}
+	}
+	return nil
+}
+
+// Near returns warnings for limits this order leaves little room under.
+func (u RateLimitUsage) Near() []string {
+	var out []string
+	days := int(u.Limits.Window.Hours() / 24)
+	if n := u.Duplicates + 1; n <= u.Limits.Duplicate && n >= u.Limits.Duplicate-u.Limits.Duplicate/10 {
+		out = append(out, fmt.Sprintf("this is certificate %d of %d allowed for the same names within %d days", n, u.Limits.Duplicate, days))
+	}
+	if u.Renewal {
+		return out
+	}
+	for _, d := range sortedKeys(u.Domains) {
+		if n := u.Domains[d] + 1; n <= u.Limits.PerDomain && n >= u.Limits.PerDomain-u.Limits.PerDomain/10 {
+			out = append(out, fmt.Sprintf("this is certificate %d of %d allowed for %s within %d days", n, u.Limits.PerDomain, d, days))
+		}
+	}
+	return out
+}
+
+// checkRateLimits stops a new order for domains that the issuance log says
+// would exceed the CA's limits, unless the manager is told to ignore them.
+func (m *Manager) checkRateLimits(domains []string) error {
+	limits, ok := RateLimitsFor(m.opts.Server)
+	if !ok || m.opts.BaseDir == "" {
+		return nil
+	}
+	issuanceMu.Lock()
+	log, err := LoadIssuances(m.opts.BaseDir)
+	issuanceMu.Unlock()
+	if err != nil {
+		return err
+	}
+	u := Usage(log, m.opts.Server, domains, limits, time.Now())
+	for _, w := range u.Near() {
+		fmt.Printf("⚠️  Let's Encrypt rate limit: %s\n", w)
+	}
+	p := u.Exceeded(domains)
+	if p == nil {
+		return nil
+	}
+	if m.opts.IgnoreRateLimits {
+		fmt.Printf("⚠️  %s; ordering anyway\n", p.Detail)
+		return nil
+	}
+	p.Detail += " (--force, or --ignore-rate-limits for renew, orders anyway; the CA has the last word)"
+	return p
+}
+
+// normalizedNames returns domains in lower case, sorted, as the issuance
+// log keeps them.
+func normalizedNames(domains []string) []string {
+	out := make([]string, len(domains))
+	for i, d := range domains {
+		out[i] = strings.ToLower(d)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedKeys(m map[string]int) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// retryAfterTransport remembers the last Retry-After a CA sent with a 429
+// or 503 response. lego's errors do not carry response headers.
+type retryAfterTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (t *retryAfterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if at, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			t.mu.Lock()
+			t.last = at
+			t.mu.Unlock()
+		}
+	}
+	return resp, err
+}
+
+func (t *retryAfterTransport) lastRetryAfter() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return now.Add(time.Duration(s) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// retryAfterDetail matches the time Boulder names in rateLimited problems,
+// e.g. "retry after 2024-05-01 12:00:00 UTC".
+var retryAfterDetail = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2})`)
+
+// withRetryAfter notes on a rateLimited problem in err when the CA lets the
+// account try again: from the Retry-After header of its response, or else
+// from the problem's detail.
+func (m *Manager) withRetryAfter(err error) error {
+	var p *Problem
+	if !errors.As(err, &p) {
+		cp, ok := AsProblem(err)
+		if !ok || cp.Code != CodeRateLimited {
+			return err
+		}
+		p, err = cp, cp
+	}
+	if p.Code != CodeRateLimited || !p.RetryAfter.IsZero() {
+		return err
+	}
+	if m.retry != nil {
+		if at := m.retry.lastRetryAfter(); at.After(time.Now()) {
+			p.RetryAfter = at
+			return err
+		}
+	}
+	p.RetryAfter = retryAfterIn(p.Detail)
+	return err
+}
+
+// retryAfterIn returns the time detail says to retry after, or zero.
+func retryAfterIn(detail string) time.Time {
+	sm := retryAfterDetail.FindStringSubmatch(detail)
+	if sm == nil {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", strings.Replace(sm[1], "T", " ", 1))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// RetryAfter returns when the CA allows another attempt after the rate
+// limit err reports, or zero when err is no rate limit or does not say.
+func RetryAfter(err error) time.Time {
+	p, ok := AsProblem(err)
+	if !ok || p.Code != CodeRateLimited {
+		return time.Time{}
+	}
+	if !p.RetryAfter.IsZero() {
+		return p.RetryAfter
+	}
+	return retryAfterIn(p.Detail)
+}
@@ -0,0 +1,183 @@
+package remotewebroot
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ftpTimeout = 30 * time.Second
+
+// ftpConn is a minimal FTP client covering what challenge uploads need:
+// login, explicit TLS, passive transfers, STOR, MKD and DELE.
+type ftpConn struct {
+	host   string
+	tc     *textproto.Conn
+	tlsCfg *tls.Config
+}
+
+func dialFTP(u *url.URL, password string, explicitTLS bool) (*ftpConn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	raw, err := net.DialTimeout("tcp", addr, ftpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = raw.SetDeadline(time.Now().Add(5 * ftpTimeout))
+	c := &ftpConn{host: u.Hostname(), tc: textproto.NewConn(raw)}
+	if _, _, err := c.tc.ReadResponse(220); err != nil {
+		c.tc.Close()
+		return nil, err
+	}
+	if explicitTLS {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			c.tc.Close()
+			return nil, err
+		}
+		c.tlsCfg = &tls.Config{ServerName: c.host, ClientSessionCache: tls.NewLRUClientSessionCache(4)}
+		tlsConn := tls.Client(raw, c.tlsCfg)
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		c.tc = textproto.NewConn(tlsConn)
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	code, _, err := c.send("USER %s", u.User.Username())
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if code == 331 {
+		if _, err := c.cmd(230, "PASS %s", password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("login failed: %w", err)
+		}
+	} else if code != 230 {
+		c.Close()
+		return nil, fmt.Errorf("login failed: unexpected reply %d", code)
+	}
+	if _, err := c.cmd(200, "TYPE I"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ftpConn) send(format string, args ...interface{}) (int, string, error) {
+	id, err := c.tc.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.tc.StartResponse(id)
+	defer c.tc.EndResponse(id)
+	return c.tc.ReadResponse(0)
+}
+
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (string, error) {
+	code, msg, err := c.send(format, args...)
+	if err != nil {
+		return "", err
+	}
+	if code != expect {
+		return "", fmt.Errorf("%s: %d %s", strings.Fields(format)[0], code, msg)
+	}
+	return msg, nil
+}
+
+// dataConn opens a passive data connection, preferring EPSV.
+func (c *ftpConn) dataConn() (net.Conn, error) {
+	var addr string
+	if msg, err := c.cmd(229, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end <= start+4 {
+			return nil, fmt.Errorf("malformed EPSV reply: %s", msg)
+		}
+		addr = net.JoinHostPort(c.host, msg[start+4:end])
+	} else {
+		msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+		if start < 0 || end <= start {
+			return nil, fmt.Errorf("malformed PASV reply: %s", msg)
+		}
+		parts := strings.Split(msg[start+1:end], ",")
+		if len(parts) != 6 {
+			return nil, fmt.Errorf("malformed PASV reply: %s", msg)
+		}
+		p1, _ := strconv.Atoi(parts[4])
+		p2, _ := strconv.Atoi(parts[5])
+		// Use the control connection host; servers behind NAT often advertise private addresses.
+		addr = net.JoinHostPort(c.host, strconv.Itoa(p1*256+p2))
+	}
+	conn, err := net.DialTimeout("tcp", addr, ftpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsCfg != nil {
+		return tls.Client(conn, c.tlsCfg), nil
+	}
+	return conn, nil
+}
+
+func (c *ftpConn) MkdirAll(dir string) error {
+	for _, d := range splitDirs(dir) {
+		// 550 usually means the directory already exists; the upload will fail later if not.
+		_, _, _ = c.send("MKD %s", d)
+	}
+	return nil
+}
+
+func (c *ftpConn) Put(file string, data []byte) error {
+	dc, err := c.dataConn()
+	if err != nil {
+		return err
+	}
+	code, msg, err := c.send("STOR %s", file)
+	if err != nil {
+		dc.Close()
+		return err
+	}
+	if code != 125 && code != 150 {
+		dc.Close()
+		return fmt.Errorf("STOR: %d %s", code, msg)
+	}
+	if _, err := io.Copy(dc, bytes.NewReader(data)); err != nil {
+		dc.Close()
+		return err
+	}
+	if err := dc.Close(); err != nil {
+		return err
+	}
+	_, _, err = c.tc.ReadResponse(226)
+	return err
+}
+
+func (c *ftpConn) Remove(file string) error {
+	_, err := c.cmd(250, "DELE %s", file)
+	return err
+}
+
+func (c *ftpConn) Close() error {
+	_, _ = c.tc.Cmd("QUIT")
+	return c.tc.Close()
+}
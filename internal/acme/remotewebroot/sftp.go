@@ -0,0 +1,287 @@
+package remotewebroot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP protocol version 3 packet types and flags (draft-ietf-secsh-filexfer-02).
+const (
+	fxpInit    = 1
+	fxpVersion = 2
+	fxpOpen    = 3
+	fxpClose   = 4
+	fxpWrite   = 6
+	fxpRemove  = 13
+	fxpMkdir   = 14
+	fxpStatus  = 101
+	fxpHandle  = 102
+
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfTrunc = 0x10
+
+	fxOK = 0
+)
+
+// sftpConn speaks the small subset of SFTP v3 needed to upload and delete
+// challenge files over an SSH "sftp" subsystem channel.
+type sftpConn struct {
+	client *ssh.Client
+	sess   *ssh.Session
+	w      io.WriteCloser
+	r      io.Reader
+	nextID uint32
+}
+
+func dialSFTP(u *url.URL, password string) (*sftpConn, error) {
+	q := u.Query()
+	home, _ := os.UserHomeDir()
+
+	khPath := q.Get("known_hosts")
+	if khPath == "" {
+		khPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(khPath)
+	if err != nil {
+		return nil, fmt.Errorf("load known hosts %s: %w", khPath, err)
+	}
+
+	var auth []ssh.AuthMethod
+	if keyPath := q.Get("key"); keyPath != "" {
+		pemBytes, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(password))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no password or ssh key given for sftp")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sess, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	w, err := sess.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		client.Close()
+		return nil, err
+	}
+	c := &sftpConn{client: client, sess: sess, w: w, r: r}
+	if err := c.init(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *sftpConn) init() error {
+	if err := c.writePacket(fxpInit, u32(3)); err != nil {
+		return err
+	}
+	typ, _, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if typ != fxpVersion {
+		return fmt.Errorf("sftp: unexpected packet %d during init", typ)
+	}
+	return nil
+}
+
+func (c *sftpConn) writePacket(typ byte, payload ...[]byte) error {
+	n := 1
+	for _, p := range payload {
+		n += len(p)
+	}
+	buf := make([]byte, 0, 4+n)
+	buf = append(buf, u32(uint32(n))...)
+	buf = append(buf, typ)
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+	_, err := c.w.Write(buf)
+	return err
+}
+
+func (c *sftpConn) readPacket() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	if length < 1 || length > 256*1024 {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], body, nil
+}
+
+// request sends a packet with a fresh request id and returns the reply type
+// and the reply body after the id.
+func (c *sftpConn) request(typ byte, payload ...[]byte) (byte, []byte, error) {
+	c.nextID++
+	id := c.nextID
+	if err := c.writePacket(typ, append([][]byte{u32(id)}, payload...)...); err != nil {
+		return 0, nil, err
+	}
+	rtyp, body, err := c.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) < 4 || binary.BigEndian.Uint32(body[:4]) != id {
+		return 0, nil, errors.New("sftp: mismatched reply id")
+	}
+	return rtyp, body[4:], nil
+}
+
+func statusError(op string, typ byte, body []byte) error {
+	if typ != fxpStatus {
+		return fmt.Errorf("sftp %s: unexpected packet %d", op, typ)
+	}
+	if len(body) < 4 {
+		return fmt.Errorf("sftp %s: short status", op)
+	}
+	code := binary.BigEndian.Uint32(body[:4])
+	if code == fxOK {
+		return nil
+	}
+	msg := ""
+	if len(body) >= 8 {
+		l := binary.BigEndian.Uint32(body[4:8])
+		if int(8+l) <= len(body) {
+			msg = string(body[8 : 8+l])
+		}
+	}
+	return fmt.Errorf("sftp %s: status %d %s", op, code, msg)
+}
+
+func (c *sftpConn) MkdirAll(dir string) error {
+	for _, d := range splitDirs(dir) {
+		// Failures are expected for directories that already exist.
+		_, _, err := c.request(fxpMkdir, str(d), u32(0))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *sftpConn) Put(file string, data []byte) error {
+	typ, body, err := c.request(fxpOpen, str(file), u32(fxfWrite|fxfCreat|fxfTrunc), u32(0))
+	if err != nil {
+		return err
+	}
+	if typ != fxpHandle {
+		return statusError("open "+file, typ, body)
+	}
+	if len(body) < 4 {
+		return errors.New("sftp: short handle")
+	}
+	hl := binary.BigEndian.Uint32(body[:4])
+	if int(4+hl) > len(body) {
+		return errors.New("sftp: short handle")
+	}
+	handle := body[4 : 4+hl]
+
+	var offset uint64
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 32*1024 {
+			chunk = chunk[:32*1024]
+		}
+		typ, body, err := c.request(fxpWrite, str(string(handle)), u64(offset), str(string(chunk)))
+		if err != nil {
+			return err
+		}
+		if err := statusError("write "+file, typ, body); err != nil {
+			return err
+		}
+		offset += uint64(len(chunk))
+		data = data[len(chunk):]
+	}
+	typ, body, err = c.request(fxpClose, str(string(handle)))
+	if err != nil {
+		return err
+	}
+	return statusError("close "+file, typ, body)
+}
+
+func (c *sftpConn) Remove(file string) error {
+	typ, body, err := c.request(fxpRemove, str(file))
+	if err != nil {
+		return err
+	}
+	return statusError("remove "+file, typ, body)
+}
+
+func (c *sftpConn) Close() error {
+	if c.sess != nil {
+		c.sess.Close()
+	}
+	return c.client.Close()
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func str(s string) []byte {
+	return append(u32(uint32(len(s))), s...)
+}
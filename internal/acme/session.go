@@ -0,0 +1,203 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// Timeouts of the connections to ACME servers. A request as a whole is
+// bounded by requestTimeout; the others make a dead CA fail fast instead of
+// using all of it.
+const (
+	requestTimeout   = 30 * time.Second
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+	idleTimeout      = 90 * time.Second
+	idlePerHost      = 8
+)
+
+// userAgent is sent with every request to ACME servers.
+const userAgent = "trusttls/1.0"
+
+// session is an ACME account at one server, set up once per process and
+// shared by every Manager for it. Orders then reuse its connections and the
+// nonces the CA handed out, and the directory is fetched and the account
+// registered or looked up only once, however many certificates a run
+// renews.
+type session struct {
+	user  *user
+	http  *http.Client
+	retry *retryAfterTransport
+	core  *api.Core
+	dir   string // the account's directory in the store; empty without one
+	known bool   // the account key existed before this process
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*sessionEntry{}
+
+	transportsMu sync.Mutex
+	transports   = map[string]*http.Transport{}
+)
+
+// sessionEntry makes concurrent Managers for the same account wait for the
+// first one to set it up, instead of each creating a key or registering.
+type sessionEntry struct {
+	once sync.Once
+	s    *session
+	err  error
+}
+
+// sessionFor returns the session of the account opts describes, setting it
+// up on first use. A failed setup is not kept, so the next Manager tries
+// again.
+func sessionFor(opts Options) (*session, error) {
+	key := strings.Join([]string{opts.Server, opts.Email, opts.BaseDir, bundleKey(opts.CABundle)}, "\x00")
+	sessionsMu.Lock()
+	e := sessions[key]
+	if e == nil {
+		e = &sessionEntry{}
+		sessions[key] = e
+	}
+	sessionsMu.Unlock()
+	e.once.Do(func() { e.s, e.err = newSession(opts) })
+	if e.err != nil {
+		sessionsMu.Lock()
+		if sessions[key] == e {
+			delete(sessions, key)
+		}
+		sessionsMu.Unlock()
+	}
+	return e.s, e.err
+}
+
+func newSession(opts Options) (*session, error) {
+	// The account is persisted per server and email so the CA can reuse
+	// authorizations across runs.
+	var (
+		priv crypto.PrivateKey
+		err  error
+	)
+	s := &session{}
+	if opts.BaseDir != "" {
+		s.dir = accountDir(opts.BaseDir, opts.Server, opts.Email)
+		priv, s.known, err = loadOrCreateAccountKey(s.dir, opts.KeyType, opts.KeySize)
+	} else {
+		priv, err = GenerateKey(opts.KeyType, opts.KeySize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.user = &user{Email: opts.Email, key: priv}
+
+	if s.http, err = newHTTPClient(opts.CABundle); err != nil {
+		return nil, err
+	}
+	s.retry = &retryAfterTransport{base: s.http.Transport}
+	s.http.Transport = s.retry
+	// A wrong clock fails later with errors that do not mention the time
+	if err := checkClock(s.http, opts.Server); err != nil {
+		return nil, err
+	}
+
+	if s.known {
+		s.user.Registration = loadRegistration(s.dir)
+	}
+	if s.user.Registration == nil {
+		if err := s.register(opts); err != nil {
+			return nil, err
+		}
+	}
+	var kid string
+	if s.user.Registration != nil {
+		kid = s.user.Registration.URI
+	}
+	if s.core, err = api.New(s.http, userAgent, opts.Server, kid, priv); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// register creates the account at the CA, or finds the one its key already
+// has, and keeps the registration in the store.
+func (s *session) register(opts Options) error {
+	core, err := api.New(s.http, userAgent, opts.Server, "", s.user.key)
+	if err != nil {
+		return err
+	}
+	registrar := registration.NewRegistrar(core, s.user)
+	var reg *registration.Resource
+	if opts.EABKID != "" {
+		reg, err = registrar.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+			TermsOfServiceAgreed: true,
+			Kid:                  opts.EABKID,
+			HmacEncoded:          opts.EABHMACKey,
+		})
+	} else {
+		reg, err = registrar.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	}
+	if err != nil && alreadyRegistered(err) {
+		reg, err = registrar.ResolveAccountByKey()
+	}
+	if err != nil && !alreadyRegistered(err) {
+		return err
+	}
+	s.user.Registration = reg
+	if reg != nil && s.dir != "" {
+		return saveRegistration(s.dir, reg)
+	}
+	return nil
+}
+
+// sharedTransport returns the transport for ACME servers trusted through
+// caBundle ("" for the system roots only), one per bundle for the whole
+// process so connections to a CA are kept open and reused.
+func sharedTransport(caBundle string) (*http.Transport, error) {
+	key := bundleKey(caBundle)
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if t := transports[key]; t != nil {
+		return t, nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// Looked up per request, so --proxy applies whenever it is set
+	t.Proxy = func(r *http.Request) (*url.URL, error) { return proxyFunc(r) }
+	t.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = handshakeTimeout
+	t.ResponseHeaderTimeout = requestTimeout
+	t.IdleConnTimeout = idleTimeout
+	t.MaxIdleConnsPerHost = idlePerHost
+	if caBundle != "" {
+		pool, err := LoadCABundle(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	transports[key] = t
+	return t, nil
+}
+
+// bundleKey identifies the roots in caBundle by file and contents, so a
+// bundle replaced while trusttls serve runs gets new connections.
+func bundleKey(caBundle string) string {
+	if caBundle == "" {
+		return ""
+	}
+	b, _ := os.ReadFile(caBundle)
+	sum := sha256.Sum256(b)
+	return caBundle + "@" + hex.EncodeToString(sum[:])
+}
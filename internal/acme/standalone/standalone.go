@@ -0,0 +1,165 @@
+// Package standalone answers HTTP-01 challenges from a built-in web server
+// for hosts that have no web server of their own on port 80. Every request it
+// receives while a challenge is pending is recorded, so a failed validation
+// can be traced to the CA never reaching the host, reaching the wrong path or
+// being redirected by something in between.
+package standalone
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const challengePrefix = "/.well-known/acme-challenge/"
+
+// Request is one HTTP request received while challenges were served.
+type Request struct {
+	Time         time.Time `json:"time"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	UserAgent    string    `json:"user_agent"`
+	Method       string    `json:"method"`
+	Host         string    `json:"host"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+}
+
+func (r Request) String() string {
+	from := r.RemoteAddr
+	if r.ForwardedFor != "" {
+		from += " (for " + r.ForwardedFor + ")"
+	}
+	return fmt.Sprintf("%s %s %s%s → %d from %s %q", r.Time.Format("15:04:05"), r.Method, r.Host, r.Path, r.Status, from, r.UserAgent)
+}
+
+// Server is a lego HTTP-01 provider that listens on Address while at least
+// one challenge is pending.
+type Server struct {
+	Address   string
+	OnRequest func(Request) // called for every request, e.g. to log it
+
+	mu       sync.Mutex
+	tokens   map[string]string
+	srv      *http.Server
+	done     chan struct{}
+	requests []Request
+}
+
+// New returns a server listening on address, such as ":80".
+func New(address string) *Server {
+	return &Server{Address: address, tokens: map[string]string{}}
+}
+
+// Present starts the listener if needed and serves keyAuth for token.
+func (s *Server) Present(domain, token, keyAuth string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = keyAuth
+	if s.srv != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		delete(s.tokens, token)
+		return fmt.Errorf("standalone: listen on %s: %w", s.Address, err)
+	}
+	s.srv = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	s.done = make(chan struct{})
+	go func(srv *http.Server, done chan struct{}) {
+		_ = srv.Serve(ln)
+		close(done)
+	}(s.srv, s.done)
+	return nil
+}
+
+// CleanUp stops serving token and stops the listener once none are left.
+func (s *Server) CleanUp(domain, token, keyAuth string) error {
+	s.mu.Lock()
+	delete(s.tokens, token)
+	if len(s.tokens) > 0 || s.srv == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	srv, done := s.srv, s.done
+	s.srv = nil
+	s.mu.Unlock()
+	err := srv.Close()
+	<-done
+	return err
+}
+
+// ServeHTTP answers challenge requests and records every request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusNotFound
+	var body string
+	if strings.HasPrefix(r.URL.Path, challengePrefix) && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		s.mu.Lock()
+		keyAuth, ok := s.tokens[strings.TrimPrefix(r.URL.Path, challengePrefix)]
+		s.mu.Unlock()
+		if ok {
+			status, body = http.StatusOK, keyAuth
+		}
+	}
+	req := Request{
+		Time:         time.Now(),
+		RemoteAddr:   r.RemoteAddr,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		UserAgent:    r.UserAgent(),
+		Method:       r.Method,
+		Host:         r.Host,
+		Path:         r.URL.RequestURI(),
+		Status:       status,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.RemoteAddr = host
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	onRequest := s.OnRequest
+	s.mu.Unlock()
+	if onRequest != nil {
+		onRequest(req)
+	}
+
+	if status != http.StatusOK {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(body))
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Explain turns the recorded requests into a hint for a failed validation.
+func (s *Server) Explain() error {
+	reqs := s.Requests()
+	if len(reqs) == 0 {
+		return fmt.Errorf("no HTTP requests reached %s while the challenge was served: check that the port is open in the firewall and forwarded to this host, and that DNS points here", s.Address)
+	}
+	var served, missed int
+	var lines []string
+	for _, r := range reqs {
+		if r.Status == http.StatusOK {
+			served++
+		} else {
+			missed++
+		}
+		lines = append(lines, "  "+r.String())
+	}
+	msg := fmt.Sprintf("%d request(s) reached %s (%d answered, %d not found):\n%s", len(reqs), s.Address, served, missed, strings.Join(lines, "\n"))
+	if served == 0 {
+		msg += "\nnone asked for a pending token: a proxy or load balancer in front of this host may be rewriting or redirecting the path"
+	}
+	return errors.New(msg)
+}
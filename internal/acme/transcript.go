@@ -0,0 +1,77 @@
+package acme
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// Transcript is what an audit needs to know about how an ACME order was
+// fulfilled. It holds nothing secret: no keys, challenge tokens or key
+// authorizations.
+type Transcript struct {
+	CA             string // directory URL
+	Account        string // account URL
+	OrderURL       string
+	Authorizations []AuthorizationRecord // one per name, in the order's order
+}
+
+// AuthorizationRecord is how one name of an order was validated.
+type AuthorizationRecord struct {
+	Identifier string
+	Challenge  string    // type of the challenge the CA accepted, e.g. http-01
+	Validated  time.Time // when the CA validated it, when it says
+	// Reused is set when the name was validated for an earlier order and
+	// no challenge was solved for this one
+	Reused bool
+}
+
+// transcripts holds the transcript of each certificate issued by this
+// process, by certificate URL, until it is stored.
+var transcripts sync.Map
+
+// TranscriptFor returns the transcript of the order cert was issued for, or
+// nil when it was not ordered over ACME by this process.
+func TranscriptFor(cert *certificate.Resource) *Transcript {
+	if t, ok := transcripts.Load(cert.CertURL); ok {
+		return t.(*Transcript)
+	}
+	return nil
+}
+
+// OrderURL returns the URL of the ACME order cert was issued for, or ""
+// when it was not ordered by this process.
+func OrderURL(cert *certificate.Resource) string {
+	if t := TranscriptFor(cert); t != nil {
+		return t.OrderURL
+	}
+	return ""
+}
+
+// transcript describes the valid order o, reading the final state of its
+// authorizations from the CA. An authorization that cannot be read is left
+// out.
+func (m *Manager) transcript(o *pendingOrder, ord acme.ExtendedOrder) *Transcript {
+	t := &Transcript{CA: m.opts.Server, Account: m.account, OrderURL: o.URL}
+	solved := map[string]bool{}
+	for _, name := range o.Solved {
+		solved[name] = true
+	}
+	for _, u := range ord.Authorizations {
+		a, err := m.core.Authorizations.Get(u)
+		if err != nil {
+			continue
+		}
+		r := AuthorizationRecord{Identifier: a.Identifier.Value, Reused: !solved[a.Identifier.Value]}
+		for _, ch := range a.Challenges {
+			if ch.Status == acme.StatusValid {
+				r.Challenge, r.Validated = ch.Type, ch.Validated
+				break
+			}
+		}
+		t.Authorizations = append(t.Authorizations, r)
+	}
+	return t
+}
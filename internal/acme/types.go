@@ -1,5 +1,7 @@
 package acme
 
+import "github.com/trustctl/trusttls/internal/acme/dnsprovider"
+
 // DigiCertConfig holds configuration for DigiCert API integration
 type DigiCertConfig struct {
 	ServerURL       string
@@ -8,6 +10,27 @@ type DigiCertConfig struct {
 	APIKey          string
 	AccountID       string
 	OrganizationID  string
+	OrderWait       OrderWait
+
+	// Validation is "ov" or "ev" (see DigiCertValidations); EV orders name
+	// the verified contact who approves them in ContactID.
+	Validation      string
+	ContactID       string
+	// StateFile keeps a submitted order until it is issued, so an
+	// interrupted wait resumes the order instead of placing another.
+	StateFile       string
+	// MustStaple adds the OCSP Must-Staple extension to the order's CSR.
+	MustStaple      bool
+	// KeyType and KeySize choose the certificate's key; see NormalizeKey.
+	KeyType         string
+	KeySize         int
+
+	// Domain control validation: the HTTP token is written under Webroot,
+	// or with DNSPlugin set the DNS token is published as a TXT record.
+	// With neither, the token is printed for someone to place by hand.
+	Webroot         string
+	DNSPlugin       string
+	DNSCredentials  dnsprovider.Credentials
 }
 
 // DigiCertEABConfig holds configuration for DigiCert ACME with External Account Binding
@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // Provider implements lego's HTTP-01 challenge provider by writing files into a webroot.
 // It creates files at <webroot>/.well-known/acme-challenge/<token> with the key authorization content.
+// Directories it had to create are removed again with the last challenge file.
  type Provider struct {
 	Root string
+
+	mu      sync.Mutex
+	created []string // deepest first
 }
 
 func New(root string) *Provider { return &Provider{Root: root} }
@@ -17,6 +22,11 @@ func New(root string) *Provider { return &Provider{Root: root} }
 func (p *Provider) Present(domain, token, keyAuth string) error {
 	if p.Root == "" { return fmt.Errorf("webroot is empty") }
 	dir := filepath.Join(p.Root, ".well-known", "acme-challenge")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for d := dir; d != filepath.Clean(p.Root) && !dirExists(d); d = filepath.Dir(d) {
+		p.created = append(p.created, d)
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil { return err }
 	path := filepath.Join(dir, token)
 	return os.WriteFile(path, []byte(keyAuth), 0644)
@@ -26,5 +36,16 @@ func (p *Provider) CleanUp(domain, token, keyAuth string) error {
 	dir := filepath.Join(p.Root, ".well-known", "acme-challenge")
 	path := filepath.Join(dir, token)
 	_ = os.Remove(path)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Fails while other challenge files are still there
+	for len(p.created) > 0 && os.Remove(p.created[0]) == nil {
+		p.created = p.created[1:]
+	}
 	return nil
 }
+
+func dirExists(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
@@ -0,0 +1,114 @@
+// Package agent keeps the certificates a trusttls server issued for this
+// machine installed: it fetches them with the machine's agent identity,
+// stores them like trusttls does and hands them to the web servers and
+// files configured for them. It is what trusttls-agent runs, and leaves
+// the issuance code out so that binary stays small.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultInterval is how often trusttls-agent run checks for new
+// certificates when the config does not say.
+const DefaultInterval = time.Hour
+
+// Config lists the certificates this agent installs and where.
+type Config struct {
+	Interval     string   `yaml:"interval,omitempty"` // between checks in trusttls-agent run, e.g. "1h"
+	Certificates []Target `yaml:"certificates"`
+}
+
+// Target is one certificate of the server and everything it is installed
+// to. A target with none of them is only kept in the store's live/.
+type Target struct {
+	Name    string         `yaml:"name"`              // lineage name on the server
+	Nginx   bool           `yaml:"nginx,omitempty"`   // write an SSL server block on first install, reload after
+	Apache  bool           `yaml:"apache,omitempty"`  // the same for an Apache vhost
+	HAProxy *HAProxyTarget `yaml:"haproxy,omitempty"` // swap into a running HAProxy
+	Files   *FileTarget    `yaml:"files,omitempty"`   // copies for other services
+	Reload  string         `yaml:"reload,omitempty"`  // shell command run after every change
+}
+
+// HAProxyTarget names HAProxy's runtime API socket and the combined PEM on
+// its crt line, as in renewal configs.
+type HAProxyTarget struct {
+	Socket   string `yaml:"socket"`
+	CertFile string `yaml:"cert_file"`
+}
+
+// FileTarget names where copies of the certificate files go; empty ones
+// are skipped.
+type FileTarget struct {
+	Cert      string `yaml:"cert,omitempty"`
+	Key       string `yaml:"key,omitempty"`
+	Chain     string `yaml:"chain,omitempty"`
+	Fullchain string `yaml:"fullchain,omitempty"`
+}
+
+// ConfigPath is where the agent config of the store in baseDir is kept,
+// next to the agent identity.
+func ConfigPath(baseDir string) string {
+	return filepath.Join(apiclient.IdentityDir(baseDir), "agent.yaml")
+}
+
+// LoadConfig reads and checks the agent config of the store in baseDir.
+func LoadConfig(baseDir string) (*Config, error) {
+	path := ConfigPath(baseDir)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no agent config at %s: list the certificates to install there", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := c.interval(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, t := range c.Certificates {
+		// Wildcard certificates are named after their domain
+		if err := store.ValidCertName(strings.TrimPrefix(t.Name, "*.")); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%s: certificate %s is listed twice", path, t.Name)
+		}
+		seen[t.Name] = true
+		if h := t.HAProxy; h != nil && h.CertFile == "" {
+			return nil, fmt.Errorf("%s: haproxy of %s needs cert_file", path, t.Name)
+		}
+	}
+	return &c, nil
+}
+
+// interval returns how long trusttls-agent run waits between checks.
+func (c *Config) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return DefaultInterval, nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("interval must be a duration of at least 1m, not %q", c.Interval)
+	}
+	return d, nil
+}
+
+// Period returns how long trusttls-agent run waits between checks.
+func (c *Config) Period() time.Duration {
+	d, _ := c.interval()
+	return d
+}
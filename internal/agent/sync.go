@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/haproxy"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Sync brings every certificate of cfg up to date: it fetches each from
+// the server of id and, when it changed or force is set, stores it in
+// baseDir and installs it to its targets. Certificates that fail do not
+// stop the others; each failure is reported as it happens.
+func Sync(baseDir string, id *apiclient.Identity, cfg *Config, force bool) error {
+	if rotated, err := id.RotateIfDue(); err != nil {
+		fmt.Printf("⚠️  Agent certificate not rotated: %v\n", err)
+	} else if rotated {
+		fmt.Printf("🔄 Rotated the agent certificate of %s\n", id.Name)
+	}
+	c, err := id.Client()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, t := range cfg.Certificates {
+		if err := syncOne(baseDir, c, t, force); err != nil {
+			fmt.Printf("❌ %s: %v\n", t.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d certificates could not be installed", failed, len(cfg.Certificates))
+	}
+	return nil
+}
+
+func syncOne(baseDir string, c *apiclient.Client, t Target, force bool) error {
+	b, err := c.Certificate(t.Name)
+	if err != nil {
+		return err
+	}
+	current, _ := store.Open(baseDir).Load(t.Name)
+	if !force && bytes.Equal(current.Cert, []byte(b.Certificate)) {
+		fmt.Printf("✅ %s is up to date (expires %s)\n", t.Name, b.NotAfter.Format("2006-01-02"))
+		return nil
+	}
+	if _, err := store.SaveCertificate(baseDir, t.Name, []byte(b.Certificate), []byte(b.Chain), []byte(b.PrivateKey)); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	fmt.Printf("📥 %s fetched (expires %s)\n", t.Name, b.NotAfter.Format("2006-01-02"))
+	return install(baseDir, t, b)
+}
+
+// install hands the certificate of t, just saved in baseDir, to each of
+// t's targets, and runs its reload command once all of them have it.
+func install(baseDir string, t Target, b apiclient.Bundle) error {
+	certPath, keyPath, chainPath, fullchainPath := store.LoadCertPaths(baseDir, t.Name)
+	domain := t.Name
+	var aliases []string
+	if len(b.Domains) > 0 {
+		domain, aliases = b.Domains[0], b.Domains[1:]
+	}
+	if t.Nginx {
+		if err := installWebServer(nginx.NewInstaller(baseDir, true), nginx.ConfigFile(domain), nginx.Reload, t.Name, domain, aliases); err != nil {
+			return fmt.Errorf("nginx: %w", err)
+		}
+		fmt.Printf("🌐 %s installed for Nginx\n", t.Name)
+	}
+	if t.Apache {
+		if err := installWebServer(apache.NewInstaller(baseDir, true), apache.ConfigFile(domain), apache.Reload, t.Name, domain, aliases); err != nil {
+			return fmt.Errorf("apache: %w", err)
+		}
+		fmt.Printf("🌐 %s installed for Apache\n", t.Name)
+	}
+	if h := t.HAProxy; h != nil {
+		fullchain, err := os.ReadFile(fullchainPath)
+		if err != nil {
+			return err
+		}
+		pem := haproxy.Bundle(fullchain, []byte(b.PrivateKey))
+		if err := haproxy.WriteBundle(h.CertFile, pem); err != nil {
+			return fmt.Errorf("haproxy: write %s: %w", h.CertFile, err)
+		}
+		if h.Socket != "" {
+			if err := haproxy.Update(h.Socket, h.CertFile, pem); err != nil {
+				return fmt.Errorf("haproxy: %w", err)
+			}
+		}
+		fmt.Printf("🌐 %s installed for HAProxy in %s\n", t.Name, h.CertFile)
+	}
+	if f := t.Files; f != nil {
+		for _, cp := range []struct{ from, to string }{
+			{certPath, f.Cert}, {keyPath, f.Key}, {chainPath, f.Chain}, {fullchainPath, f.Fullchain},
+		} {
+			if cp.to == "" {
+				continue
+			}
+			if err := copyFile(cp.from, cp.to); err != nil {
+				return err
+			}
+			fmt.Printf("📄 %s copied to %s\n", t.Name, cp.to)
+		}
+	}
+	if strings.TrimSpace(t.Reload) != "" {
+		if err := reload(t.Reload, baseDir, t.Name); err != nil {
+			return err
+		}
+		fmt.Printf("🔄 %s: ran %s\n", t.Name, t.Reload)
+	}
+	return nil
+}
+
+// webInstaller is what the Nginx and Apache installers have in common.
+type webInstaller interface {
+	Install(certName, domain string, aliases ...string) error
+}
+
+// installWebServer writes the SSL site of domain the first time, pointing
+// at the store's live/ files, and only reloads the server later: the site
+// keeps pointing at the same files.
+func installWebServer(i webInstaller, configFile string, reload func(), certName, domain string, aliases []string) error {
+	if _, err := os.Stat(configFile); err == nil {
+		reload()
+		return nil
+	}
+	return i.Install(certName, domain, aliases...)
+}
+
+// copyFile replaces to with the contents of from in one step. Keys keep
+// their owner-only mode.
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if filepath.Base(from) == "privkey.pem" {
+		perm = 0600
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	tmp := to + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// reload runs command through the shell, with the paths of the
+// certificate name in the environment as for renewal hooks.
+func reload(command, baseDir, name string) error {
+	certPath, keyPath, chainPath, fullchainPath := store.LoadCertPaths(baseDir, name)
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, flag, command)
+	cmd.Env = append(os.Environ(),
+		"TRUSTTLS_CERT_NAME="+name,
+		"TRUSTTLS_CERT="+certPath,
+		"TRUSTTLS_KEY="+keyPath,
+		"TRUSTTLS_CHAIN="+chainPath,
+		"TRUSTTLS_FULLCHAIN="+fullchainPath,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reload command failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/ca"
+)
+
+// AgentLifetime is how long an agent's client certificate is valid. Agents
+// rotate it once two thirds of that have passed.
+const AgentLifetime = 30 * 24 * time.Hour
+
+// agentName is what agents may be called: a host name, which also ends up
+// in their certificate.
+var agentName = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+
+// Agent is a machine enrolled with the server. Only the certificate with
+// Serial, or the one it replaced, is accepted for it, so removing the agent
+// locks it out even before its certificate expires.
+type Agent struct {
+	Name           string    `json:"name"`
+	Joined         time.Time `json:"joined"`
+	Rotated        time.Time `json:"rotated"`
+	Serial         string    `json:"serial"`
+	PreviousSerial string    `json:"previous_serial,omitempty"` // kept in case the agent missed the rotation's answer
+	NotAfter       time.Time `json:"not_after"`
+}
+
+// joinToken is a one-time join token as stored on the server; the file is
+// named after the token's hash, so the store never holds the token itself.
+type joinToken struct {
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+func agentsDir(baseDir string) string {
+	return filepath.Join(baseDir, "agents")
+}
+
+func tokenPath(baseDir, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return filepath.Join(agentsDir(baseDir), "tokens", hex.EncodeToString(sum[:])+".json")
+}
+
+// CreateJoinToken returns a token that enrolls one agent called name with
+// the server of the store in baseDir until ttl has passed.
+func CreateJoinToken(baseDir, name string, ttl time.Duration) (string, error) {
+	if !agentName.MatchString(name) {
+		return "", fmt.Errorf("agent name %q must be a lowercase host name", name)
+	}
+	if name == Admin {
+		return "", fmt.Errorf("agent name %q is reserved for the server's token", name)
+	}
+	if ttl <= 0 {
+		return "", errors.New("token lifetime must be positive")
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	path := tokenPath(baseDir, token)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(joinToken{Name: name, Expires: time.Now().Add(ttl).UTC()})
+	if err != nil {
+		return "", err
+	}
+	return token, os.WriteFile(path, data, 0600)
+}
+
+// redeemJoinToken returns the agent name token was created for and
+// invalidates it.
+func redeemJoinToken(baseDir, token string) (string, error) {
+	path := tokenPath(baseDir, token)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.New("unknown or already used join token")
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	var t joinToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return "", err
+	}
+	if time.Now().After(t.Expires) {
+		return "", errors.New("join token expired")
+	}
+	return t.Name, nil
+}
+
+// ListAgents returns the agents enrolled with the store in baseDir, sorted
+// by name.
+func ListAgents(baseDir string) ([]Agent, error) {
+	paths, err := filepath.Glob(filepath.Join(agentsDir(baseDir), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Agent
+	for _, p := range paths {
+		a, err := loadAgent(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// RemoveAgent unenrolls the agent called name; its certificates stop being
+// accepted right away.
+func RemoveAgent(baseDir, name string) error {
+	if !agentName.MatchString(name) {
+		return fmt.Errorf("no agent called %s", name)
+	}
+	err := os.Remove(agentPath(baseDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no agent called %s", name)
+	}
+	return err
+}
+
+func agentPath(baseDir, name string) string {
+	return filepath.Join(agentsDir(baseDir), name+".json")
+}
+
+func loadAgent(path string) (Agent, error) {
+	var a Agent
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return a, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+func saveAgent(baseDir string, a Agent) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(agentsDir(baseDir), 0700); err != nil {
+		return err
+	}
+	tmp := agentPath(baseDir, a.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, agentPath(baseDir, a.Name))
+}
+
+// TLSConfig returns the server's TLS settings for cert. Clients may present
+// an agent certificate, which authorized then accepts in place of the
+// token.
+func (s *Server) TLSConfig(cert tls.Certificate) (*tls.Config, error) {
+	authority, err := s.agentCA()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(authority.Cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (s *Server) agentCA() (*ca.Authority, error) {
+	s.agentMu.Lock()
+	defer s.agentMu.Unlock()
+	if s.agents == nil {
+		authority, err := ca.LoadOrCreate(s.BaseDir, ca.AgentCA)
+		if err != nil {
+			return nil, fmt.Errorf("load %s CA: %w", ca.AgentCA, err)
+		}
+		s.agents = authority
+	}
+	return s.agents, nil
+}
+
+// agent returns the enrolled agent r's client certificate belongs to, or
+// an error when r has none or the agent no longer accepts it.
+func (s *Server) agent(r *http.Request) (Agent, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Agent{}, errors.New("no agent certificate")
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	name := leaf.Subject.CommonName
+	if !agentName.MatchString(name) || name == Admin {
+		return Agent{}, fmt.Errorf("bad agent name %q", name)
+	}
+	a, err := loadAgent(agentPath(s.BaseDir, name))
+	if err != nil {
+		return Agent{}, fmt.Errorf("agent %s is not enrolled", name)
+	}
+	serial := leaf.SerialNumber.Text(16)
+	if serial != a.Serial && serial != a.PreviousSerial {
+		return Agent{}, fmt.Errorf("agent %s presented a replaced certificate", name)
+	}
+	return a, nil
+}
+
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	var req apiclient.JoinRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	csr, err := parseCSR(req.CSR)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.agentMu.Lock()
+	name, err := redeemJoinToken(s.BaseDir, req.Token)
+	s.agentMu.Unlock()
+	if err != nil {
+		s.log("agent join from %s refused: %v", r.RemoteAddr, err)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	s.issueIdentity(w, r, Agent{Name: name, Joined: time.Now().UTC()}, csr)
+}
+
+func (s *Server) handleRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	a, err := s.agent(r)
+	if err != nil {
+		s.log("%s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var req apiclient.RotateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	csr, err := parseCSR(req.CSR)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.issueIdentity(w, r, a, csr)
+}
+
+// issueIdentity signs a client certificate for a's key in csr, records it
+// as a's current certificate and sends it back.
+func (s *Server) issueIdentity(w http.ResponseWriter, r *http.Request, a Agent, csr *x509.CertificateRequest) {
+	authority, err := s.agentCA()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	leaf, err := authority.SignClient(a.Name, csr.PublicKey, AgentLifetime)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rotated := a.Serial != ""
+	a.PreviousSerial, a.Serial = a.Serial, leaf.SerialNumber.Text(16)
+	a.NotAfter = leaf.NotAfter.UTC()
+	a.Rotated = time.Now().UTC()
+	s.agentMu.Lock()
+	err = saveAgent(s.BaseDir, a)
+	s.agentMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if rotated {
+		s.log("rotated the certificate of agent %s (%s)", a.Name, r.RemoteAddr)
+	} else {
+		s.log("agent %s joined from %s", a.Name, r.RemoteAddr)
+	}
+	writeJSON(w, http.StatusOK, apiclient.IdentityResponse{
+		Name:        a.Name,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})),
+		CA:          string(authority.CertPEM),
+	})
+}
+
+func parseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || !strings.Contains(block.Type, "CERTIFICATE REQUEST") {
+		return nil, errors.New("csr: no PEM certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("csr: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("csr signature: %w", err)
+	}
+	return csr, nil
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/jobs"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Admin is the actor recorded for requests made with the server's token.
+const Admin = "admin"
+
+// caller names who sent r, as authorized found: the agent for agent
+// certificates, Admin for the token. It is empty for requests that did not
+// pass authorized, so nothing falls back to Admin.
+func (s *Server) caller(r *http.Request) string {
+	who, _ := r.Context().Value(callerKey{}).(string)
+	return who
+}
+
+// handleRequests lists requests on GET and takes new ones on POST. Agents
+// only see their own.
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	who := s.caller(r)
+	switch r.Method {
+	case http.MethodGet:
+		all, err := approval.List(s.BaseDir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		out := []approval.Request{}
+		for _, req := range all {
+			if who == Admin || req.Tenant == who {
+				out = append(out, req)
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		var in apiclient.CertificateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&in); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		s.submit(w, who, in)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+	}
+}
+
+func (s *Server) submit(w http.ResponseWriter, who string, in apiclient.CertificateRequest) {
+	req := approval.Request{Tenant: who, CertName: in.CertName, Reason: in.Reason}
+	for _, d := range in.Domains {
+		req.Domains = append(req.Domains, strings.ToLower(strings.TrimSpace(d)))
+	}
+	if err := checkRequest(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	policy, err := approval.Load(s.BaseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if who != Admin {
+		req.Unapproved = policy.Tenant(who).Unapproved(req.Domains)
+	}
+	if len(req.Unapproved) > 0 && !policy.Queue {
+		why := fmt.Sprintf("%s may not have %s; ask an admin to add them to approval.yaml", who, strings.Join(req.Unapproved, ", "))
+		if err := approval.Refuse(s.BaseDir, who, req, why); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.log("refused a certificate for %s to %s", strings.Join(req.Domains, ", "), who)
+		writeError(w, http.StatusForbidden, errors.New(why))
+		return
+	}
+	req, err = approval.Submit(s.BaseDir, who, req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if req.Status == approval.Pending {
+		s.log("queued request %s from %s for %s (needs approval for %s)", req.ID, who, strings.Join(req.Domains, ", "), strings.Join(req.Unapproved, ", "))
+		writeJSON(w, http.StatusAccepted, req)
+		return
+	}
+	s.order(w, who, req)
+}
+
+// handleRequest shows one request on GET; admins decide on it with POST
+// to /v1/requests/<id>/approve or /deny.
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	who := s.caller(r)
+	id, verb, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/requests/"), "/")
+	req, err := approval.Get(s.BaseDir, id)
+	if err != nil || (who != Admin && req.Tenant != who) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no request %s", id))
+		return
+	}
+	switch {
+	case verb == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, req)
+		return
+	case verb != "approve" && verb != "deny":
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	case r.Method != http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	case who != Admin:
+		writeError(w, http.StatusForbidden, errors.New("only the server's token can approve or deny requests"))
+		return
+	}
+	var d apiclient.Decision
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&d); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	req, err = approval.Decide(s.BaseDir, id, Admin, verb == "approve", d.Note)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if req.Status == approval.Denied {
+		s.log("request %s from %s denied", req.ID, req.Tenant)
+		writeJSON(w, http.StatusOK, req)
+		return
+	}
+	s.log("request %s from %s approved", req.ID, req.Tenant)
+	s.order(w, Admin, req)
+}
+
+// order places the order for the approved request req and answers with
+// its outcome.
+func (s *Server) order(w http.ResponseWriter, who string, req approval.Request) {
+	s.renewMu.Lock()
+	req, err := PlaceOrder(s.BaseDir, who, req)
+	s.renewMu.Unlock()
+	if err != nil {
+		s.log("order for request %s failed: %v", req.ID, err)
+		if req.Status == approval.Failed {
+			writeJSON(w, http.StatusBadGateway, req)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.log("issued %s for %s (request %s)", req.Lineage(), req.Tenant, req.ID)
+	writeJSON(w, http.StatusCreated, req)
+}
+
+// PlaceOrder orders the certificate of the approved request req with the
+// settings of the policy's template and records the outcome as actor's.
+// The certificate gets a renewal config of its own, so it is renewed like
+// any other. The order is a queued job, run at once; if the process dies
+// before it finishes, the next jobs run resumes it. A failed order leaves
+// req failed and is returned as error.
+func PlaceOrder(baseDir, actor string, req approval.Request) (approval.Request, error) {
+	c, err := orderConfig(baseDir, req)
+	if err == nil {
+		err = renewal.Save(c)
+	}
+	if err != nil {
+		req.ErrorCode = acme.ErrorCode(err)
+		req, ferr := approval.Finish(baseDir, actor, req, err)
+		if ferr != nil {
+			return req, ferr
+		}
+		return req, err
+	}
+	q := jobs.NewQueue(baseDir)
+	j, err := q.EnqueueOrder(c.Lineage(), c.Server, req.ID, actor)
+	if err != nil {
+		return req, err
+	}
+	orderErr := runJob(q, baseDir, j.ID)
+	if done, err := approval.Get(baseDir, req.ID); err == nil {
+		req = done
+	}
+	return req, orderErr
+}
+
+// orderConfig returns the renewal config for req: the template's issuance
+// settings for req's names. An existing certificate of the same name is
+// only reissued when it covers the same names, so a tenant cannot replace
+// someone else's certificate or its hooks.
+func orderConfig(baseDir string, req approval.Request) (renewal.Config, error) {
+	existing, err := renewal.Load(req.Lineage())
+	if err == nil {
+		if !sameNames(existing.Names(), req.Domains) {
+			return existing, fmt.Errorf("a certificate named %s already covers %s; ask for a different cert_name", req.Lineage(), strings.Join(existing.Names(), ", "))
+		}
+		return existing, nil
+	}
+	if !os.IsNotExist(err) {
+		return existing, err
+	}
+	policy, err := approval.Load(baseDir)
+	if err != nil {
+		return renewal.Config{}, err
+	}
+	if policy.Template == "" {
+		return renewal.Config{}, fmt.Errorf("no template in %s: name the renewal config whose settings new certificates copy", approval.ConfigPath(baseDir))
+	}
+	t, err := renewal.Load(policy.Template)
+	if err != nil {
+		return renewal.Config{}, fmt.Errorf("template %s: %w", policy.Template, err)
+	}
+	c := renewal.Config{
+		Domain:             req.Domains[0],
+		CertName:           req.CertName,
+		BaseDir:            baseDir,
+		Email:              t.Email,
+		Server:             t.Server,
+		CABundle:           t.CABundle,
+		MustStaple:         t.MustStaple,
+		Method:             t.Method,
+		Standalone:         t.Standalone,
+		DNSPlugin:          t.DNSPlugin,
+		DNSCredentials:     t.DNSCredentials,
+		KeyType:            t.KeyType,
+		KeySize:            t.KeySize,
+		Provider:           t.Provider,
+		Lifetime:           t.Lifetime,
+		CA:                 t.CA,
+		Resolvers:          t.Resolvers,
+		PropagationTimeout: t.PropagationTimeout,
+		PropagationCheck:   t.PropagationCheck,
+		Validation:         t.Validation,
+		OrganizationID:     t.OrganizationID,
+		ContactID:          t.ContactID,
+	}
+	if len(req.Domains) > 1 {
+		c.Domains = req.Domains
+	}
+	return c, nil
+}
+
+// checkRequest rejects requests that could never be ordered.
+func checkRequest(req approval.Request) error {
+	if len(req.Domains) == 0 {
+		return errors.New("no domains requested")
+	}
+	seen := map[string]bool{}
+	for _, d := range req.Domains {
+		if err := approval.ValidName(d); err != nil {
+			return err
+		}
+		if seen[d] {
+			return fmt.Errorf("%s is requested twice", d)
+		}
+		seen[d] = true
+	}
+	if req.CertName != "" {
+		return store.ValidCertName(req.CertName)
+	}
+	return nil
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, n := range a {
+		found := false
+		for _, m := range b {
+			found = found || strings.EqualFold(n, m)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/jobs"
+	"github.com/trustctl/trusttls/internal/renewal"
+)
+
+// JobHandler returns the handler running queued jobs against the store in
+// baseDir: it renews the job's lineage and, for a job ordering an approval
+// request, records the outcome on the request once the job succeeds or
+// gives up.
+func JobHandler(baseDir string, verbose bool) func(*jobs.Job) error {
+	return func(j *jobs.Job) error {
+		c, err := renewal.Load(j.Domain)
+		if err != nil {
+			err = fmt.Errorf("load renewal config: %w", err)
+		} else {
+			err = renewal.Renew(c, verbose)
+		}
+		if j.Request == "" || (err != nil && j.Attempts < j.MaxAttempts) {
+			return err
+		}
+		req, gerr := approval.Get(baseDir, j.Request)
+		if gerr != nil {
+			return gerr
+		}
+		req.ErrorCode = acme.ErrorCode(err)
+		if _, ferr := approval.Finish(baseDir, j.Actor, req, err); ferr != nil && err == nil {
+			return ferr
+		}
+		return err
+	}
+}
+
+// runJob runs the queued job id now and returns what its handler returned,
+// or why it could not run.
+func runJob(q *jobs.Queue, baseDir, id string) error {
+	handle := JobHandler(baseDir, false)
+	var herr error
+	if _, err := q.RunJob(id, func(j *jobs.Job) error {
+		herr = handle(j)
+		return herr
+	}); err != nil {
+		return err
+	}
+	return herr
+}
+
+// RunJobs processes the queued jobs that are due, as trusttls jobs run
+// does, one renewal at a time with the API's. serve calls it every minute,
+// which retries failed renewals and resumes orders a restart interrupted.
+func (s *Server) RunJobs() (int, error) {
+	s.renewMu.Lock()
+	defer s.renewMu.Unlock()
+	return s.jobQueue().RunDue(JobHandler(s.BaseDir, false))
+}
+
+// jobQueue returns the server's job queue, one for its lifetime so orders
+// to the same CA stay spaced out across requests.
+func (s *Server) jobQueue() *jobs.Queue {
+	s.queueOnce.Do(func() { s.queue = jobs.NewQueue(s.BaseDir) })
+	return s.queue
+}
@@ -0,0 +1,249 @@
+// Package api serves a trusttls store over HTTPS, so the CLI on another
+// machine can list and renew its certificates with --remote, and agents can
+// ask for new ones, subject to the approval policy, and fetch them to install
+// (trusttls-agent). Clients authenticate with the server's token or, once
+// enrolled as agents, with a client certificate from the server's agent CA.
+// The client side is package apiclient.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/jobs"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Server answers API requests against the store in BaseDir. Every request
+// must carry Token as a bearer token or come from an enrolled agent; with
+// Token empty only agents are let in.
+type Server struct {
+	BaseDir string
+	Token   string
+
+	// Log receives one line per request.
+	Log func(string)
+
+	// renewMu runs one renewal at a time, as a cron job would.
+	renewMu sync.Mutex
+
+	// Renewals and orders go through the job queue, so they survive a
+	// restart
+	queueOnce sync.Once
+	queue     *jobs.Queue
+
+	// agentMu guards the agent CA and the agent registry.
+	agentMu sync.Mutex
+	agents  *ca.Authority
+}
+
+// Handler returns the HTTP handler for the API.
+func (s *Server) Handler() (http.Handler, error) {
+	if _, err := s.agentCA(); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/certificates", s.authorized(s.handleCertificates))
+	mux.HandleFunc("/v1/certificates/", s.authorized(s.handleCertificate))
+	mux.HandleFunc("/v1/renew", s.authorized(s.handleRenew))
+	mux.HandleFunc("/v1/requests", s.authorized(s.handleRequests))
+	mux.HandleFunc("/v1/requests/", s.authorized(s.handleRequest))
+	mux.HandleFunc("/v1/agents/join", s.handleJoin)
+	mux.HandleFunc("/v1/agents/rotate", s.handleRotate)
+	return mux, nil
+}
+
+// callerKey is the request context key authorized records the caller under.
+type callerKey struct{}
+
+// authorized lets r through to next once it carries the token or an
+// enrolled agent's certificate, and records which for caller. What agents
+// may do beyond that is up to each handler.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			a, err := s.agent(r)
+			if err != nil {
+				s.log("%s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, a.Name)))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			s.log("%s %s from %s: bad token", r.Method, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong API token or agent certificate"))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, Admin)))
+	}
+}
+
+// handleCertificates lists every lineage in the store, which is for the
+// token only; agents list their own through their requests.
+func (s *Server) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	if s.caller(r) != Admin {
+		writeError(w, http.StatusForbidden, errors.New("only the server's token can list certificates"))
+		return
+	}
+	lineages, err := store.ListLineages(s.BaseDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if lineages == nil {
+		lineages = []store.Lineage{}
+	}
+	writeJSON(w, http.StatusOK, lineages)
+}
+
+// handleCertificate sends the current certificate, chain and key of one
+// lineage, for agents to install. Agents only get the lineages of their own
+// issued requests.
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v1/certificates/")
+	who := s.caller(r)
+	if who != Admin && !s.issuedTo(who, name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no certificate %s", name))
+		return
+	}
+	l, err := store.LoadLineage(s.BaseDir, name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no certificate %s", name))
+		return
+	}
+	b, err := store.Open(s.BaseDir).Load(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(b.Key) == 0 {
+		writeError(w, http.StatusConflict, fmt.Errorf("the private key of %s is not kept in the server's store", name))
+		return
+	}
+	s.log("sent %s to %s", name, who)
+	writeJSON(w, http.StatusOK, apiclient.Bundle{
+		Name:        l.Name,
+		Domains:     l.Names,
+		Certificate: string(b.Cert),
+		Chain:       string(b.Chain),
+		PrivateKey:  string(b.Key),
+		NotAfter:    l.NotAfter,
+	})
+}
+
+// issuedTo reports whether the lineage name was issued for a request of
+// the agent who.
+func (s *Server) issuedTo(who, name string) bool {
+	reqs, err := approval.List(s.BaseDir)
+	if err != nil {
+		return false
+	}
+	for _, req := range reqs {
+		if req.Tenant == who && req.Status == approval.Issued && req.Lineage() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRenew renews one certificate, or all due ones, for the token only.
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	if s.caller(r) != Admin {
+		writeError(w, http.StatusForbidden, errors.New("only the server's token can renew certificates"))
+		return
+	}
+	var req apiclient.RenewRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	s.renewMu.Lock()
+	defer s.renewMu.Unlock()
+	var cfgs []renewal.Config
+	if req.Domain == "" {
+		var err error
+		if cfgs, err = renewal.DueConfigs(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.log("renewing all due certificates for %s", r.RemoteAddr)
+	} else {
+		c, err := renewal.Find(req.Domain)
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no renewal config for %s", req.Domain))
+			return
+		}
+		cfgs = []renewal.Config{c}
+		s.log("renewing %s for %s", req.Domain, r.RemoteAddr)
+	}
+	// Queued first, so a renewal that fails or is cut short by a restart
+	// is retried by the queue
+	q := s.jobQueue()
+	var ids []string
+	for _, c := range cfgs {
+		j, err := q.Enqueue(c.Lineage(), c.Server)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ids = append(ids, j.ID)
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := runJob(q, s.BaseDir, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		s.log("renewal failed: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, jobs.ErrBusy) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) log(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log(fmt.Sprintf(format, args...))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiclient.ErrorBody{Error: err.Error(), Code: acme.ErrorCode(err)})
+}
@@ -0,0 +1,187 @@
+// Package apiclient is the client side of the trusttls API (see package
+// api): the token and agent clients and the messages both sides exchange.
+// It stays clear of the issuance code so trusttls-agent can be built small.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Timeouts of client calls. Renewals wait for the CA and DNS propagation,
+// so they get much longer than lookups.
+const (
+	lookupTimeout = 30 * time.Second
+	renewTimeout  = 30 * time.Minute
+)
+
+// Client talks to a trusttls API server, with the server's token or, for
+// clients from Identity.Client, an agent certificate.
+type Client struct {
+	URL   string
+	Token string
+	HTTP  *http.Client
+}
+
+// NewClient returns a client for the server at rawURL. caBundle, when set,
+// is a PEM file of extra roots to trust, such as the server's internal CA.
+func NewClient(rawURL, token, caBundle string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("--remote must be an https:// URL, not %q", rawURL)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("--remote needs --token (or TRUSTTLS_TOKEN)")
+	}
+	httpClient := &http.Client{}
+	if caBundle != "" {
+		pool, err := loadCABundle(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		httpClient.Transport = transport
+	}
+	return &Client{URL: strings.TrimSuffix(u.String(), "/"), Token: token, HTTP: httpClient}, nil
+}
+
+// Certificates returns every lineage in the server's store.
+func (c *Client) Certificates() ([]store.Lineage, error) {
+	var out []store.Lineage
+	err := c.do(http.MethodGet, "/v1/certificates", nil, &out, lookupTimeout)
+	return out, err
+}
+
+// Certificate returns the current certificate of the lineage name with its
+// chain and private key. Agents only get the certificates they asked for.
+func (c *Client) Certificate(name string) (Bundle, error) {
+	var out Bundle
+	err := c.do(http.MethodGet, "/v1/certificates/"+url.PathEscape(name), nil, &out, lookupTimeout)
+	return out, err
+}
+
+// Renew renews domain on the server, or every due certificate when domain
+// is empty, and waits for it to finish.
+func (c *Client) Renew(domain string) error {
+	return c.do(http.MethodPost, "/v1/renew", RenewRequest{Domain: domain}, nil, renewTimeout)
+}
+
+// RequestCertificate asks the server for a certificate. The request comes
+// back issued, or pending when it waits for an admin's approval.
+func (c *Client) RequestCertificate(in CertificateRequest) (approval.Request, error) {
+	var out approval.Request
+	err := c.do(http.MethodPost, "/v1/requests", in, &out, renewTimeout)
+	return out, err
+}
+
+// Requests returns the certificate requests the server holds: all of them
+// for the token, an agent's own for an agent.
+func (c *Client) Requests() ([]approval.Request, error) {
+	var out []approval.Request
+	err := c.do(http.MethodGet, "/v1/requests", nil, &out, lookupTimeout)
+	return out, err
+}
+
+// Request returns the certificate request id.
+func (c *Client) Request(id string) (approval.Request, error) {
+	var out approval.Request
+	err := c.do(http.MethodGet, "/v1/requests/"+url.PathEscape(id), nil, &out, lookupTimeout)
+	return out, err
+}
+
+// Decide approves the pending request id, which places its order, or
+// denies it.
+func (c *Client) Decide(id string, approve bool, note string) (approval.Request, error) {
+	verb := "deny"
+	if approve {
+		verb = "approve"
+	}
+	var out approval.Request
+	err := c.do(http.MethodPost, "/v1/requests/"+url.PathEscape(id)+"/"+verb, Decision{Note: note}, &out, renewTimeout)
+	return out, err
+}
+
+func (c *Client) do(method, path string, in, out interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("remote %s: %w", c.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e ErrorBody
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return &Error{URL: c.URL, Status: resp.StatusCode, Message: e.Error, Code: e.Code}
+		}
+		return fmt.Errorf("remote %s: %s", c.URL, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Error is a request the server refused, with its message and, for failed
+// orders, the problem code the CLI gives advice for.
+type Error struct {
+	URL     string
+	Status  int
+	Message string
+	Code    string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("remote %s: %s", e.URL, e.Message) }
+
+// ErrorCode returns the acme.Problem code the server classified the failure
+// as, or "".
+func (e *Error) ErrorCode() string { return e.Code }
+
+// loadCABundle returns the system roots plus the PEM certificates in path,
+// like acme.LoadCABundle, which this package cannot import.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("CA bundle %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
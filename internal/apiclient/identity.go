@@ -0,0 +1,227 @@
+package apiclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Identity is this machine's enrollment as an agent of a trusttls server:
+// the server's URL and the client certificate it issued, kept under
+// <baseDir>/agent/.
+type Identity struct {
+	Server   string `json:"server"`
+	Name     string `json:"name"`
+	CABundle string `json:"ca_bundle,omitempty"` // roots trusted for Server, copied into Dir
+
+	Dir string `json:"-"`
+}
+
+const (
+	identityFile = "identity.json"
+	identityPEM  = "identity.pem" // certificate, agent CA and key in one file, replaced in one step
+	serverRoots  = "server-ca.pem"
+)
+
+// IdentityDir is where the agent identity of the store in baseDir is kept.
+func IdentityDir(baseDir string) string {
+	return filepath.Join(baseDir, "agent")
+}
+
+// LoadIdentity returns the agent identity of the store in baseDir, or nil
+// when this machine has not joined a server.
+func LoadIdentity(baseDir string) (*Identity, error) {
+	dir := IdentityDir(baseDir)
+	data, err := os.ReadFile(filepath.Join(dir, identityFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var id Identity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("%s: %w", identityFile, err)
+	}
+	id.Dir = dir
+	return &id, nil
+}
+
+// Join enrolls this machine as agent name with the server at rawURL using
+// a one-time join token, and saves the identity in baseDir. caBundle, when
+// set, is a PEM file of extra roots to trust for the server.
+func Join(baseDir, rawURL, caBundle, token, name string) (*Identity, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("server must be an https:// URL, not %q", rawURL)
+	}
+	id := &Identity{Server: strings.TrimSuffix(u.String(), "/"), Name: name, Dir: IdentityDir(baseDir)}
+	if err := os.MkdirAll(id.Dir, 0700); err != nil {
+		return nil, err
+	}
+	if caBundle != "" {
+		if _, err := loadCABundle(caBundle); err != nil {
+			return nil, err
+		}
+		roots, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(filepath.Join(id.Dir, serverRoots), roots, 0644); err != nil {
+			return nil, err
+		}
+		id.CABundle = filepath.Join(id.Dir, serverRoots)
+	}
+	c, err := id.client(nil)
+	if err != nil {
+		return nil, err
+	}
+	key, csrPEM, err := newAgentKey(name)
+	if err != nil {
+		return nil, err
+	}
+	var resp IdentityResponse
+	if err := c.do(http.MethodPost, "/v1/agents/join", JoinRequest{Token: token, CSR: csrPEM}, &resp, lookupTimeout); err != nil {
+		return nil, err
+	}
+	id.Name = resp.Name
+	if err := id.save(key, resp); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return id, writeFileAtomic(filepath.Join(id.Dir, identityFile), data, 0600)
+}
+
+// Certificate returns the agent's current client certificate.
+func (id *Identity) Certificate() (*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(id.Dir, identityPEM))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", identityPEM)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// RotateAt returns when the agent's certificate is due for rotation: once
+// two thirds of its lifetime have passed.
+func (id *Identity) RotateAt() (time.Time, error) {
+	leaf, err := id.Certificate()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return leaf.NotAfter.Add(-leaf.NotAfter.Sub(leaf.NotBefore) / 3), nil
+}
+
+// Rotate replaces the agent's key and certificate, authenticating with the
+// current ones. The old certificate stays valid on the server until the
+// next rotation, so a lost answer does not lock the agent out.
+func (id *Identity) Rotate() error {
+	c, err := id.Client()
+	if err != nil {
+		return err
+	}
+	key, csrPEM, err := newAgentKey(id.Name)
+	if err != nil {
+		return err
+	}
+	var resp IdentityResponse
+	if err := c.do(http.MethodPost, "/v1/agents/rotate", RotateRequest{CSR: csrPEM}, &resp, lookupTimeout); err != nil {
+		return err
+	}
+	return id.save(key, resp)
+}
+
+// RotateIfDue rotates the agent's certificate once its rotation time has
+// come, and reports whether it did.
+func (id *Identity) RotateIfDue() (bool, error) {
+	rotateAt, err := id.RotateAt()
+	if err != nil {
+		return false, err
+	}
+	if time.Now().Before(rotateAt) {
+		return false, nil
+	}
+	return true, id.Rotate()
+}
+
+// Client returns an API client for the agent's server that authenticates
+// with the agent certificate.
+func (id *Identity) Client() (*Client, error) {
+	path := filepath.Join(id.Dir, identityPEM)
+	pair, err := tls.LoadX509KeyPair(path, path)
+	if err != nil {
+		return nil, fmt.Errorf("load agent certificate: %w", err)
+	}
+	return id.client(&pair)
+}
+
+func (id *Identity) client(cert *tls.Certificate) (*Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if id.CABundle != "" {
+		pool, err := loadCABundle(id.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{URL: id.Server, HTTP: &http.Client{Transport: transport}}, nil
+}
+
+// save replaces the agent's certificate and key with resp's certificate
+// and key.
+func (id *Identity) save(key *ecdsa.PrivateKey, resp IdentityResponse) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if _, err := tls.X509KeyPair([]byte(resp.Certificate), keyPEM); err != nil {
+		return fmt.Errorf("server returned a certificate that does not match the key: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(id.Dir, identityPEM), []byte(resp.Certificate+resp.CA+string(keyPEM)), 0600)
+}
+
+// newAgentKey generates a P-256 key for agent name and a CSR for it.
+func newAgentKey(name string) (*ecdsa.PrivateKey, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
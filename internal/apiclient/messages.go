@@ -0,0 +1,60 @@
+package apiclient
+
+import "time"
+
+// RenewRequest asks for a renewal. An empty Domain renews everything that
+// is due, like trusttls renew; a domain is reissued regardless of expiry.
+type RenewRequest struct {
+	Domain string `json:"domain,omitempty"`
+}
+
+// ErrorBody is the JSON body of every failed request.
+type ErrorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"` // see acme.Problem
+}
+
+// CertificateRequest asks the server for a certificate covering Domains,
+// the first being the primary name.
+type CertificateRequest struct {
+	Domains  []string `json:"domains"`
+	CertName string   `json:"cert_name,omitempty"`
+	Reason   string   `json:"reason,omitempty"` // shown to the admin deciding on it
+}
+
+// Decision approves or denies a queued request.
+type Decision struct {
+	Note string `json:"note,omitempty"`
+}
+
+// JoinRequest enrolls an agent with a join token and the CSR of the key it
+// generated for itself.
+type JoinRequest struct {
+	Token string `json:"token"`
+	CSR   string `json:"csr"`
+}
+
+// RotateRequest asks for a new certificate for an enrolled agent, which
+// authenticates with its current one.
+type RotateRequest struct {
+	CSR string `json:"csr"`
+}
+
+// IdentityResponse carries an agent's new client certificate and the CA
+// that issued it.
+type IdentityResponse struct {
+	Name        string `json:"name"`
+	Certificate string `json:"certificate"`
+	CA          string `json:"ca"`
+}
+
+// Bundle is the current certificate of a lineage with everything needed
+// to serve it, as PEM.
+type Bundle struct {
+	Name        string    `json:"name"`
+	Domains     []string  `json:"domains"`
+	Certificate string    `json:"certificate"`
+	Chain       string    `json:"chain"`
+	PrivateKey  string    `json:"private_key"`
+	NotAfter    time.Time `json:"not_after"`
+}
@@ -0,0 +1,388 @@
+// Package approval keeps the approval queue of a trusttls server. Agents
+// (see trusttls agent) ask the server for certificates; names on an agent's
+// pre-approved list are ordered right away, and with the queue turned on
+// anything else waits until an admin approves or denies it. Every step is
+// appended to an audit log.
+package approval
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"gopkg.in/yaml.v3"
+)
+
+// Statuses of a request.
+const (
+	Pending  = "pending"  // waiting for an admin
+	Approved = "approved" // approved, order not finished yet
+	Denied   = "denied"
+	Issued   = "issued"
+	Failed   = "failed" // approved, but the order failed
+)
+
+// Audit actions.
+const (
+	ActionRequested   = "requested"
+	ActionRefused     = "refused" // outside the tenant's list with the queue off
+	ActionApproved    = "approved"
+	ActionPreapproved = "preapproved"
+	ActionDenied      = "denied"
+	ActionIssued      = "issued"
+	ActionFailed      = "failed"
+)
+
+// Tenant is an agent allowed to ask for certificates, with the names it may
+// have without asking an admin.
+type Tenant struct {
+	Name    string   `yaml:"name"`    // agent name, as in trusttls agent list
+	Domains []string `yaml:"domains"` // "example.com", or "*.example.com" for every name below example.com
+}
+
+// Config is the approval policy of a server, kept in approval.yaml.
+type Config struct {
+	Template string   `yaml:"template"`        // renewal config whose CA, account, challenge and key settings new certificates copy
+	Queue    bool     `yaml:"queue,omitempty"` // hold requests for other names for an admin instead of refusing them
+	Tenants  []Tenant `yaml:"tenants"`
+}
+
+// ConfigPath returns where the approval policy of baseDir is kept.
+func ConfigPath(baseDir string) string {
+	return confdir.Path(baseDir, "approval.yaml")
+}
+
+// Load reads the approval policy. A missing file means no tenants and no
+// queue, so agents cannot ask for certificates.
+func Load(baseDir string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	return c, nil
+}
+
+// Validate checks the policy's tenants and their names.
+func (c Config) Validate() error {
+	seen := map[string]bool{}
+	for _, t := range c.Tenants {
+		if t.Name == "" {
+			return errors.New("tenant name is required")
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tenant %s is listed twice", t.Name)
+		}
+		seen[t.Name] = true
+		for _, d := range t.Domains {
+			if err := ValidName(d); err != nil {
+				return fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Tenant returns the tenant called name, or one with no names.
+func (c Config) Tenant(name string) Tenant {
+	for _, t := range c.Tenants {
+		if t.Name == name {
+			return t
+		}
+	}
+	return Tenant{Name: name}
+}
+
+// Covers reports whether name is on t's pre-approved list.
+func (t Tenant) Covers(name string) bool {
+	name = strings.ToLower(name)
+	for _, d := range t.Domains {
+		d = strings.ToLower(d)
+		if d == name {
+			return true
+		}
+		if strings.HasPrefix(d, "*.") && strings.HasSuffix(name, d[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unapproved returns the names in domains t may not have without an admin.
+func (t Tenant) Unapproved(domains []string) []string {
+	var out []string
+	for _, d := range domains {
+		if !t.Covers(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+var hostName = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidName checks a name asked for or pre-approved: a lowercase host name,
+// optionally a wildcard.
+func ValidName(name string) error {
+	if len(name) > 253 || !hostName.MatchString(name) {
+		return fmt.Errorf("%q is not a lowercase host name", name)
+	}
+	return nil
+}
+
+// Request is a tenant's request for a certificate and what became of it.
+type Request struct {
+	ID         string    `json:"id"`
+	Tenant     string    `json:"tenant"`
+	Domains    []string  `json:"domains"`
+	CertName   string    `json:"cert_name,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Unapproved []string  `json:"unapproved,omitempty"` // names outside the tenant's list
+	Status     string    `json:"status"`
+	Created    time.Time `json:"created"`
+	DecidedBy  string    `json:"decided_by,omitempty"`
+	Decided    time.Time `json:"decided,omitempty"`
+	Note       string    `json:"note,omitempty"`       // the admin's comment
+	Error      string    `json:"error,omitempty"`      // why the order failed
+	ErrorCode  string    `json:"error_code,omitempty"` // its problem code, such as rateLimited
+}
+
+// Lineage returns the name the requested certificate is kept under.
+func (r Request) Lineage() string {
+	if r.CertName != "" {
+		return r.CertName
+	}
+	return r.Domains[0]
+}
+
+// Event is one line of the audit log.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"` // tenant, admin or local user who acted
+	Action  string    `json:"action"`
+	ID      string    `json:"id,omitempty"`
+	Tenant  string    `json:"tenant"`
+	Domains []string  `json:"domains"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// mu serializes changes to the queue and the audit log from the server's
+// handlers.
+var mu sync.Mutex
+
+func dir(baseDir string) string {
+	return filepath.Join(baseDir, "approvals")
+}
+
+// AuditPath returns where the audit log of baseDir is kept.
+func AuditPath(baseDir string) string {
+	return filepath.Join(dir(baseDir), "audit.log")
+}
+
+func requestPath(baseDir, id string) string {
+	return filepath.Join(dir(baseDir), id+".json")
+}
+
+var requestID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Submit records r as a new request with a fresh ID and status, and audits
+// it as actor's. A request with no unapproved names is approved at once.
+func Submit(baseDir, actor string, r Request) (Request, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return r, err
+	}
+	r.ID = hex.EncodeToString(b)
+	r.Created = time.Now().UTC()
+	r.Status = Pending
+	mu.Lock()
+	defer mu.Unlock()
+	if err := save(baseDir, r); err != nil {
+		return r, err
+	}
+	detail := r.Reason
+	if len(r.Unapproved) > 0 {
+		detail = strings.TrimPrefix(detail+"; needs approval for "+strings.Join(r.Unapproved, ", "), "; ")
+	}
+	if err := audit(baseDir, actor, ActionRequested, r, detail); err != nil {
+		return r, err
+	}
+	if len(r.Unapproved) > 0 {
+		return r, nil
+	}
+	r.Status, r.DecidedBy, r.Decided = Approved, actor, r.Created
+	if err := save(baseDir, r); err != nil {
+		return r, err
+	}
+	return r, audit(baseDir, actor, ActionPreapproved, r, "")
+}
+
+// Refuse audits a request turned away without being queued.
+func Refuse(baseDir, actor string, r Request, why string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return audit(baseDir, actor, ActionRefused, r, why)
+}
+
+// Decide approves or denies the pending request id on behalf of actor.
+func Decide(baseDir, id, actor string, approve bool, note string) (Request, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	r, err := Get(baseDir, id)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != Pending {
+		return r, fmt.Errorf("request %s is %s, not pending", id, r.Status)
+	}
+	action := ActionDenied
+	r.Status = Denied
+	if approve {
+		r.Status, action = Approved, ActionApproved
+	}
+	r.DecidedBy, r.Decided, r.Note = actor, time.Now().UTC(), note
+	if err := save(baseDir, r); err != nil {
+		return r, err
+	}
+	return r, audit(baseDir, actor, action, r, note)
+}
+
+// Finish records the outcome of the order for the approved request r. The
+// caller sets r.ErrorCode for a failed order.
+func Finish(baseDir, actor string, r Request, orderErr error) (Request, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if orderErr == nil {
+		r.ErrorCode = ""
+	}
+	r.Status, r.Error = Issued, ""
+	action, detail := ActionIssued, ""
+	if orderErr != nil {
+		r.Status, r.Error = Failed, orderErr.Error()
+		action, detail = ActionFailed, orderErr.Error()
+	}
+	if err := save(baseDir, r); err != nil {
+		return r, err
+	}
+	return r, audit(baseDir, actor, action, r, detail)
+}
+
+// Get returns the request id.
+func Get(baseDir, id string) (Request, error) {
+	var r Request
+	if !requestID.MatchString(id) {
+		return r, fmt.Errorf("no request %s", id)
+	}
+	b, err := os.ReadFile(requestPath(baseDir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, fmt.Errorf("no request %s", id)
+	}
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%s: %w", requestPath(baseDir, id), err)
+	}
+	return r, nil
+}
+
+// List returns every request, oldest first.
+func List(baseDir string) ([]Request, error) {
+	paths, err := filepath.Glob(filepath.Join(dir(baseDir), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Request
+	for _, p := range paths {
+		r, err := Get(baseDir, strings.TrimSuffix(filepath.Base(p), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, nil
+}
+
+// ReadAudit returns the audit log, oldest first.
+func ReadAudit(baseDir string) ([]Event, error) {
+	f, err := os.Open(AuditPath(baseDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Event
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", AuditPath(baseDir), n, err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+func save(baseDir string, r Request) error {
+	if err := os.MkdirAll(dir(baseDir), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := requestPath(baseDir, r.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, requestPath(baseDir, r.ID))
+}
+
+// audit appends one event to the log. The log is only ever appended to, so
+// it can be shipped or made append-only with chattr +a.
+func audit(baseDir, actor, action string, r Request, detail string) error {
+	if err := os.MkdirAll(dir(baseDir), 0700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(Event{
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		ID:      r.ID,
+		Tenant:  r.Tenant,
+		Domains: r.Domains,
+		Detail:  detail,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(AuditPath(baseDir), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,272 @@
+// Package ca implements a small internal certificate authority kept in the
+// store. It signs certificates locally without involving an ACME server.
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/seal"
+)
+
+// EdgeCA is the CA used for short-lived edge certificates.
+const EdgeCA = "edge"
+
+// APICA issues the certificate of the API server (trusttls serve).
+const APICA = "api"
+
+// DevCA issues certificates for local development (trusttls dev-cert).
+const DevCA = "dev"
+
+// AgentCA issues the client certificates agents present to trusttls serve.
+const AgentCA = "agents"
+
+const (
+	certFile = "ca.pem"
+	keyFile  = "ca-key.pem"
+
+	rootLifetime = 10 * 365 * 24 * time.Hour
+)
+
+// Authority is a root CA whose key and certificate live under
+// <baseDir>/ca/<name>/.
+type Authority struct {
+	Name    string
+	Dir     string
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     crypto.Signer
+}
+
+// Dir returns the directory holding the CA named name.
+func Dir(baseDir, name string) string {
+	return filepath.Join(baseDir, "ca", name)
+}
+
+// LoadOrCreate loads the named CA from the store, creating a new ECDSA P-256
+// root if none exists yet.
+func LoadOrCreate(baseDir, name string) (*Authority, error) {
+	a, err := Load(baseDir, name)
+	if err == nil {
+		return a, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return create(baseDir, name)
+}
+
+// Load reads an existing CA from the store.
+func Load(baseDir, name string) (*Authority, error) {
+	dir := Dir(baseDir, name)
+	certPEM, err := os.ReadFile(filepath.Join(dir, certFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := seal.ReadFile(filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no pem block", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return &Authority{Name: name, Dir: dir, Cert: cert, CertPEM: certPEM, key: key}, nil
+}
+
+func create(baseDir, name string) (*Authority, error) {
+	dir := Dir(baseDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	priv, err := acme.GenerateKey("ecdsa", 256)
+	if err != nil {
+		return nil, err
+	}
+	signer := priv.(crypto.Signer)
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "TrustTLS " + name + " CA", Organization: []string{"TrustTLS"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(rootLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          keyID(signer.Public()),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := acme.MarshalPrivateKeyToPEM(priv)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := seal.WriteFile(filepath.Join(dir, keyFile), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, certFile), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return &Authority{Name: name, Dir: dir, Cert: cert, CertPEM: certPEM, key: signer}, nil
+}
+
+// LeafRequest describes a certificate to sign with the CA.
+type LeafRequest struct {
+	Domains  []string
+	Lifetime time.Duration
+	KeyType  string
+	KeySize  int
+	// ClientAuth adds the client authentication extended key usage.
+	ClientAuth bool
+	// MustStaple adds the OCSP Must-Staple (TLS Feature) extension.
+	MustStaple bool
+}
+
+// Issue creates a new key pair and signs a leaf certificate for req.Domains.
+// Entries that parse as IP addresses are added as IP SANs.
+func (a *Authority) Issue(req LeafRequest) (*certificate.Resource, error) {
+	if len(req.Domains) == 0 {
+		return nil, errors.New("at least one domain required")
+	}
+	if req.Lifetime <= 0 {
+		return nil, errors.New("lifetime must be positive")
+	}
+	if req.KeyType == "" {
+		req.KeyType = acme.KeyECDSA
+	}
+	priv, err := acme.GenerateKey(req.KeyType, req.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	pub := priv.(crypto.Signer).Public()
+	var exts []pkix.Extension
+	if req.MustStaple {
+		exts = append(exts, acme.MustStapleExtension)
+	}
+	der, err := a.sign(req.Domains, pub, req.Lifetime, req.ClientAuth, exts)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := acme.MarshalPrivateKeyToPEM(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate.Resource{
+		Domain:            req.Domains[0],
+		Certificate:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		IssuerCertificate: a.CertPEM,
+		PrivateKey:        keyPEM,
+	}, nil
+}
+
+// SignClient signs a certificate naming name for the key pub that is valid
+// for client authentication, such as the identity of an agent.
+func (a *Authority) SignClient(name string, pub crypto.PublicKey, lifetime time.Duration) (*x509.Certificate, error) {
+	if lifetime <= 0 {
+		return nil, errors.New("lifetime must be positive")
+	}
+	der, err := a.sign([]string{name}, pub, lifetime, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func (a *Authority) sign(names []string, pub crypto.PublicKey, lifetime time.Duration, clientAuth bool, exts []pkix.Extension) ([]byte, error) {
+	now := time.Now()
+	notAfter := now.Add(lifetime)
+	if notAfter.After(a.Cert.NotAfter) {
+		notAfter = a.Cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    randomSerial(),
+		Subject:         pkix.Name{CommonName: names[0]},
+		NotBefore:       now.Add(-5 * time.Minute),
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		AuthorityKeyId:  a.Cert.SubjectKeyId,
+		ExtraExtensions: exts,
+	}
+	if clientAuth {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	return x509.CreateCertificate(rand.Reader, tmpl, a.Cert, pub, a.key)
+}
+
+// ParsePrivateKey decodes a PEM encoded PKCS#1, SEC 1 or PKCS#8 private key.
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return s, nil
+}
+
+func randomSerial() *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return n
+}
+
+func keyID(pub crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(der)
+	return sum[:20]
+}
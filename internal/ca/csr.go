@@ -0,0 +1,142 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
+)
+
+// DevicesCA is the CA used for certificates enrolled over SCEP and EST.
+const DevicesCA = "devices"
+
+const (
+	raCertFile = "scep-ra.pem"
+	raKeyFile  = "scep-ra-key.pem"
+
+	raLifetime = 2 * 365 * 24 * time.Hour
+)
+
+// SignCSR signs a certificate for the key in csr after checking the request's
+// signature. Subject and subject alternative names are copied from the
+// request; the certificate is valid for both server and client auth, which
+// covers what appliances typically use it for (TLS, 802.1X, VPN).
+func (a *Authority) SignCSR(csr *x509.CertificateRequest, lifetime time.Duration) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("csr signature: %w", err)
+	}
+	if lifetime <= 0 {
+		return nil, errors.New("lifetime must be positive")
+	}
+	now := time.Now()
+	notAfter := now.Add(lifetime)
+	if notAfter.After(a.Cert.NotAfter) {
+		notAfter = a.Cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   randomSerial(),
+		RawSubject:     csr.RawSubject, // keep the device's exact encoding
+		NotBefore:      now.Add(-5 * time.Minute),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		AuthorityKeyId: a.Cert.SubjectKeyId,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}
+	// Keep the one extension a CSR may ask for that changes how clients
+	// treat the certificate: OCSP Must-Staple.
+	if acme.HasMustStaple(csr.Extensions) {
+		tmpl.ExtraExtensions = []pkix.Extension{acme.MustStapleExtension}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.Cert, csr.PublicKey, a.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// SCEPRA returns the registration authority certificate and key that decrypt
+// and sign SCEP messages on behalf of the CA. SCEP relies on RSA key
+// transport, so the RA carries an RSA key even though the root is ECDSA. It is
+// created on first use and replaced when it is within 30 days of expiry.
+func (a *Authority) SCEPRA() (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPath := filepath.Join(a.Dir, raCertFile)
+	keyPath := filepath.Join(a.Dir, raKeyFile)
+	if certPEM, err := os.ReadFile(certPath); err == nil {
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			return nil, nil, fmt.Errorf("%s: no pem block", raCertFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := ParsePrivateKey(keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", raKeyFile, err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: not an RSA key", raKeyFile)
+		}
+		if time.Until(cert.NotAfter) > 30*24*time.Hour {
+			return cert, rsaKey, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	priv, err := acme.GenerateKey("rsa", 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	rsaKey := priv.(*rsa.PrivateKey)
+	now := time.Now()
+	notAfter := now.Add(raLifetime)
+	if notAfter.After(a.Cert.NotAfter) {
+		notAfter = a.Cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   randomSerial(),
+		Subject:        pkix.Name{CommonName: "TrustTLS " + a.Name + " SCEP RA", Organization: []string{"TrustTLS"}},
+		NotBefore:      now.Add(-time.Hour),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+		AuthorityKeyId: a.Cert.SubjectKeyId,
+		SubjectKeyId:   keyID(rsaKey.Public()),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.Cert, rsaKey.Public(), a.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := acme.MarshalPrivateKeyToPEM(rsaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, err
+	}
+	return cert, rsaKey, nil
+}
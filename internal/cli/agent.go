@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/api"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Enroll machines with a trusttls server and manage their identities",
+	Long: `
+Agents talk to a trusttls server (trusttls serve) with their own client
+certificate instead of the shared API token. On the server, create a
+one-time join token for each machine; on the machine, join with it. The
+agent generates its key locally and the server's agent CA signs it, so the
+token is worthless once used.
+
+Agent certificates are valid for 30 days and rotated once two thirds of that
+have passed: automatically whenever the agent uses --remote, or with
+'trusttls agent rotate' from a timer. Removing an agent on the server locks
+it out at once.
+
+Example:
+  # on the server
+  trusttls agent token --name web1.example.com
+  trusttls agent list
+  trusttls agent remove web1.example.com
+
+  # on the agent
+  trusttls agent join --server https://trusttls.internal:8443 \
+    --join-token 3f9c... --ca-bundle api-ca.pem
+  trusttls certificates --remote https://trusttls.internal:8443
+  trusttls agent rotate
+`,
+}
+
+var agentTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Create a one-time join token for an agent (run on the server)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		if name == "" {
+			return fmt.Errorf("--name is required")
+		}
+		token, err := api.CreateJoinToken(store.DefaultBaseDir(), strings.ToLower(name), ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🎟️  Join token for %s (valid %s, once):\n", name, ttl)
+		fmt.Println(token)
+		fmt.Printf("💡 On the agent: trusttls agent join --server https://<this host>:8443 --join-token %s\n", token)
+		return nil
+	},
+}
+
+var agentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the agents enrolled with this server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		agents, err := api.ListAgents(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if len(agents) == 0 {
+			fmt.Println("📭 No agents enrolled")
+			return nil
+		}
+		for _, a := range agents {
+			mark := "✅"
+			if time.Now().After(a.NotAfter) {
+				mark = "❌"
+			}
+			fmt.Printf("%s %-30s joined %s, certificate valid until %s\n", mark, a.Name,
+				a.Joined.Format("2006-01-02"), a.NotAfter.Format("2006-01-02"))
+		}
+		return nil
+	},
+}
+
+var agentRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Unenroll an agent; its certificate stops working at once",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := api.RemoveAgent(store.DefaultBaseDir(), args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("🗑️  Agent %s removed\n", args[0])
+		return nil
+	},
+}
+
+var agentJoinCmd = &cobra.Command{
+	Use:   "join",
+	Short: "Enroll this machine with a trusttls server using a join token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server, _ := cmd.Flags().GetString("server")
+		token, _ := cmd.Flags().GetString("join-token")
+		caBundle, _ := cmd.Flags().GetString("ca-bundle")
+		if server == "" || token == "" {
+			return fmt.Errorf("--server and --join-token are required")
+		}
+		host, _ := os.Hostname()
+		id, err := apiclient.Join(store.DefaultBaseDir(), server, caBundle, token, strings.ToLower(host))
+		if err != nil {
+			return err
+		}
+		rotateAt, err := id.RotateAt()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🤝 Joined %s as agent %s\n", id.Server, id.Name)
+		fmt.Printf("🔐 Identity saved in: %s\n", id.Dir)
+		fmt.Printf("🔄 Rotates after: %s\n", rotateAt.Format("2006-01-02"))
+		fmt.Printf("💡 Use --remote %s without --token from now on\n", id.Server)
+		return nil
+	},
+}
+
+var agentRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate this agent's certificate when it is due",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		id, err := apiclient.LoadIdentity(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if id == nil {
+			return fmt.Errorf("this machine has not joined a server; run trusttls agent join")
+		}
+		if force {
+			err = id.Rotate()
+		} else {
+			err = rotateIfDue(id)
+		}
+		if err != nil {
+			return err
+		}
+		rotateAt, err := id.RotateAt()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Agent certificate of %s is current; next rotation after %s\n", id.Name, rotateAt.Format("2006-01-02"))
+		return nil
+	},
+}
+
+// rotateIfDue rotates the certificate of id once its rotation time has
+// come.
+func rotateIfDue(id *apiclient.Identity) error {
+	rotated, err := id.RotateIfDue()
+	if err != nil {
+		return err
+	}
+	if rotated {
+		fmt.Fprintf(os.Stderr, "🔄 Rotated the agent certificate of %s\n", id.Name)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentTokenCmd, agentListCmd, agentRemoveCmd, agentJoinCmd, agentRotateCmd)
+
+	agentTokenCmd.Flags().String("name", "", "Host name of the agent; it ends up in the agent's certificate")
+	agentTokenCmd.Flags().Duration("ttl", 24*time.Hour, "How long the token can be used")
+
+	agentJoinCmd.Flags().String("server", "", "https:// URL of the trusttls server")
+	agentJoinCmd.Flags().String("join-token", "", "One-time join token from 'trusttls agent token'")
+	agentJoinCmd.Flags().String("ca-bundle", "", "PEM file with roots to trust for the server, such as its api ca.pem")
+
+	agentRotateCmd.Flags().Bool("force", false, "Rotate even when the certificate is not due")
+}
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/api"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "Ask a trusttls server for certificates and approve agents' requests",
+	Long: `
+Agents enrolled with a trusttls server (see trusttls agent) can ask it for
+certificates. approval.yaml in the server's store lists the names each agent
+may have without asking anyone; the server orders those right away, with
+the settings of the renewal config named as template.
+
+Anything else is refused, or with queue: true held until an admin approves
+or denies it, on the server or with --remote and the server's token.
+Approving places the order. Every request, decision and order is appended
+to approvals/audit.log.
+
+  # ~/.trusttls/approval.yaml on the server
+  template: wildcard.example.com   # a dns-01 certificate to copy settings from
+  queue: true
+  tenants:
+    - name: web1.example.com
+      domains: [web1.example.com, "*.shop.example.com"]
+
+Example:
+  # on the agent
+  trusttls approvals request --remote https://trusttls.internal:8443 \
+    --domain www.shop.example.com --domain api.example.com --reason "new API"
+
+  # on the server, or with --remote and --token
+  trusttls approvals list
+  trusttls approvals approve 3f9c0a1b2c3d4e5f --note "OK per ticket 42"
+  trusttls approvals deny 3f9c0a1b2c3d4e5f --note "use api.shop.example.com"
+  trusttls approvals audit
+`,
+}
+
+var approvalsRequestCmd = &cobra.Command{
+	Use:         "request",
+	Short:       "Ask the --remote server for a certificate",
+	Annotations: remoteCapable(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domains, _ := cmd.Flags().GetStringSlice("domain")
+		certName, _ := cmd.Flags().GetString("cert-name")
+		reason, _ := cmd.Flags().GetString("reason")
+		if remote == nil {
+			return fmt.Errorf("--remote is required: requests go to a trusttls server")
+		}
+		if len(domains) == 0 {
+			return fmt.Errorf("--domain is required")
+		}
+		r, err := remote.RequestCertificate(apiclient.CertificateRequest{Domains: domains, CertName: certName, Reason: reason})
+		if err != nil {
+			return err
+		}
+		if r.Status == approval.Pending {
+			fmt.Printf("⏳ Request %s is waiting for approval of %s\n", r.ID, strings.Join(r.Unapproved, ", "))
+			fmt.Printf("💡 Check on it with: trusttls approvals list --remote %s\n", remote.URL)
+			return nil
+		}
+		fmt.Printf("✅ Certificate %s issued for %s (request %s)\n", r.Lineage(), strings.Join(r.Domains, ", "), r.ID)
+		return nil
+	},
+}
+
+var approvalsListCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "List certificate requests, pending first",
+	Annotations: remoteCapable(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		var reqs []approval.Request
+		var err error
+		if remote != nil {
+			reqs, err = remote.Requests()
+		} else {
+			reqs, err = approval.List(store.DefaultBaseDir())
+		}
+		if err != nil {
+			return err
+		}
+		shown := []approval.Request{}
+		for _, r := range reqs {
+			if r.Status == approval.Pending {
+				shown = append(shown, r)
+			}
+		}
+		if all {
+			for _, r := range reqs {
+				if r.Status != approval.Pending {
+					shown = append(shown, r)
+				}
+			}
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(shown)
+		}
+		if len(shown) == 0 {
+			if all {
+				fmt.Println("📭 No certificate requests")
+			} else {
+				fmt.Println("📭 No requests waiting for approval (--all shows decided ones)")
+			}
+			return nil
+		}
+		for _, r := range shown {
+			printRequest(r)
+		}
+		return nil
+	},
+}
+
+func decideCmd(approve bool) *cobra.Command {
+	use, short := "deny <id>", "Deny a pending certificate request"
+	if approve {
+		use, short = "approve <id>", "Approve a pending certificate request and place its order"
+	}
+	return &cobra.Command{
+		Use:         use,
+		Short:       short,
+		Args:        cobra.ExactArgs(1),
+		Annotations: remoteCapable(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			note, _ := cmd.Flags().GetString("note")
+			if !approve && note == "" {
+				return fmt.Errorf("--note is required: tell the requester why")
+			}
+			var r approval.Request
+			var err error
+			if remote != nil {
+				r, err = remote.Decide(args[0], approve, note)
+			} else {
+				r, err = decideLocally(args[0], approve, note)
+			}
+			if err != nil {
+				return err
+			}
+			if r.Status == approval.Denied {
+				fmt.Printf("🚫 Request %s from %s denied\n", r.ID, r.Tenant)
+				return nil
+			}
+			fmt.Printf("✅ Request %s approved; certificate %s issued for %s\n", r.ID, r.Lineage(), strings.Join(r.Domains, ", "))
+			return nil
+		},
+	}
+}
+
+var (
+	approvalsApproveCmd = decideCmd(true)
+	approvalsDenyCmd    = decideCmd(false)
+)
+
+// decideLocally decides on request id in the local store, as the user
+// running trusttls, and places the order when it is approved.
+func decideLocally(id string, approve bool, note string) (approval.Request, error) {
+	base := store.DefaultBaseDir()
+	actor := localActor()
+	r, err := approval.Decide(base, id, actor, approve, note)
+	if err != nil || !approve {
+		return r, err
+	}
+	fmt.Printf("📝 Ordering %s for %s...\n", strings.Join(r.Domains, ", "), r.Tenant)
+	return api.PlaceOrder(base, actor, r)
+}
+
+// localActor names the user deciding on the server itself for the audit
+// log, looking through sudo.
+func localActor() string {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+	}
+	return "local:" + name
+}
+
+var approvalsAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the audit log of certificate requests",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, _ := cmd.Flags().GetString("id")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		events, err := approval.ReadAudit(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		shown := []approval.Event{}
+		for _, e := range events {
+			if id == "" || e.ID == id {
+				shown = append(shown, e)
+			}
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(shown)
+		}
+		if len(shown) == 0 {
+			fmt.Println("📭 Nothing audited yet")
+			return nil
+		}
+		for _, e := range shown {
+			line := fmt.Sprintf("%s  %-11s %-18s by %s: %s for %s", e.Time.Local().Format("2006-01-02 15:04:05"),
+				e.Action, e.ID, e.Actor, strings.Join(e.Domains, ", "), e.Tenant)
+			if e.Detail != "" {
+				line += " (" + e.Detail + ")"
+			}
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+func printRequest(r approval.Request) {
+	icon := map[string]string{
+		approval.Pending:  "⏳",
+		approval.Approved: "👍",
+		approval.Denied:   "🚫",
+		approval.Issued:   "✅",
+		approval.Failed:   "❌",
+	}[r.Status]
+	fmt.Printf("%s %s  %-8s %s asked %s for %s\n", icon, r.ID, r.Status, r.Tenant,
+		r.Created.Local().Format("2006-01-02 15:04"), strings.Join(r.Domains, ", "))
+	if r.CertName != "" {
+		fmt.Printf("      certificate name: %s\n", r.CertName)
+	}
+	if r.Reason != "" {
+		fmt.Printf("      reason: %s\n", r.Reason)
+	}
+	if len(r.Unapproved) > 0 {
+		fmt.Printf("      not pre-approved: %s\n", strings.Join(r.Unapproved, ", "))
+	}
+	if r.DecidedBy != "" && r.DecidedBy != r.Tenant {
+		verdict := "approved"
+		if r.Status == approval.Denied {
+			verdict = "denied"
+		}
+		line := fmt.Sprintf("      %s by %s on %s", verdict, r.DecidedBy, r.Decided.Local().Format("2006-01-02 15:04"))
+		if r.Note != "" {
+			line += ": " + r.Note
+		}
+		fmt.Println(line)
+	}
+	if r.Error != "" {
+		fmt.Printf("      order failed: %s\n", r.Error)
+		if r.ErrorCode != "" {
+			fmt.Printf("      error code: %s\n", r.ErrorCode)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(approvalsCmd)
+	approvalsCmd.AddCommand(approvalsRequestCmd, approvalsListCmd, approvalsApproveCmd, approvalsDenyCmd, approvalsAuditCmd)
+	approvalsRequestCmd.Flags().StringSlice("domain", nil, "Names on the certificate; repeat or comma-separate, primary first")
+	approvalsRequestCmd.Flags().String("cert-name", "", "Keep the certificate under this name instead of its primary domain")
+	approvalsRequestCmd.Flags().String("reason", "", "Why the certificate is needed, for the admin approving it")
+	approvalsListCmd.Flags().Bool("all", false, "Also list approved, denied, issued and failed requests")
+	approvalsListCmd.Flags().Bool("json", false, "Print the requests as JSON")
+	approvalsApproveCmd.Flags().String("note", "", "Comment recorded with the decision")
+	approvalsDenyCmd.Flags().String("note", "", "Why the request is denied (required)")
+	approvalsAuditCmd.Flags().String("id", "", "Only show events of this request")
+	approvalsAuditCmd.Flags().Bool("json", false, "Print the events as JSON")
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var certificatesCmd = &cobra.Command{
+	Use:   "certificates",
+	Short: "List the certificates managed by TrustTLS",
+	Long: `
+List every certificate kept in the store with the names it covers and when it
+expires.
+
+With --host only certificates that cover the host name are shown, best match
+first: a certificate named after the host, then a wildcard certificate, then
+one that lists the host among its other names.
+
+Example:
+  trusttls certificates
+  trusttls certificates --host www.example.com
+  trusttls certificates --remote https://trusttls.internal:8443 --token s3cret
+`,
+	Annotations: remoteCapable(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host, _ := cmd.Flags().GetString("host")
+		lineages, err := listLineages(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if host != "" {
+			best, _, err := store.BestLineage(lineages, host)
+			if err != nil {
+				return err
+			}
+			var matching []store.Lineage
+			matching = append(matching, best)
+			for _, l := range lineages {
+				if l.Name != best.Name && l.Covers(host) != store.NoMatch {
+					matching = append(matching, l)
+				}
+			}
+			lineages = matching
+		}
+		if len(lineages) == 0 {
+			fmt.Println("ℹ️  No certificates found")
+			return nil
+		}
+		for i, l := range lineages {
+			fmt.Printf("📜 %s", l.Name)
+			if host != "" {
+				fmt.Printf(" (%s match", l.Covers(host))
+				if i == 0 {
+					fmt.Printf(", used for %s", host)
+				}
+				fmt.Printf(")")
+			}
+			fmt.Println()
+			fmt.Printf("   Names:   %s\n", strings.Join(l.Names, ", "))
+			fmt.Printf("   Expires: %s\n", describeExpiry(l.NotAfter))
+			if l.Provider != "" {
+				fmt.Printf("   CA:      %s\n", l.Provider)
+			}
+			if r := l.LastRenewal; r != nil {
+				if r.Error != "" {
+					fmt.Printf("   Renewal: ❌ failed %s: %s\n", r.Time.Local().Format("2006-01-02 15:04"), r.Error)
+				} else {
+					fmt.Printf("   Renewal: ✅ %s\n", r.Time.Local().Format("2006-01-02 15:04"))
+				}
+			}
+			fmt.Printf("   Path:    %s\n", l.Dir)
+		}
+		return nil
+	},
+}
+
+func describeExpiry(t time.Time) string {
+	return describeExpiryAt(t, time.Now())
+}
+
+func init() {
+	rootCmd.AddCommand(certificatesCmd)
+	certificatesCmd.Flags().String("host", "", "Only show certificates covering this host name")
+}
@@ -1,14 +1,26 @@
 package cli
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/go-acme/lego/v4/certificate"
 	"github.com/spf13/cobra"
 	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/remotewebroot"
+	"github.com/trustctl/trusttls/internal/acme/standalone"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/keysink"
 	"github.com/trustctl/trusttls/internal/osutil"
-	"github.com/trustctl/trusttls/internal/plugins/apache"
-	"github.com/trustctl/trusttls/internal/plugins/nginx"
 	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/resolver"
 	"github.com/trustctl/trusttls/internal/store"
 )
 
@@ -29,24 +41,125 @@ Perfect for:
 
 Example:
   trusttls get-cert --domain example.com --email admin@example.com
+  trusttls get-cert --domain example.com,www.example.com --domain shop.example.com \
+    --email admin@example.com
+  trusttls get-cert --domain example.com --email admin@example.com \
+    --dns rfc2136 --dns-credentials /etc/trusttls/rfc2136.ini
+  trusttls get-cert --domain example.com --email admin@example.com \
+    --dns manual --dns-wait
+  trusttls get-cert --domain '*.example.com' --email admin@example.com \
+    --dns cloudflare
+  trusttls get-cert --domain example.com --email admin@example.com --standalone --verbose
+  trusttls get-cert --domain example.com --email admin@example.com \
+    --remote-webroot sftp://user@example.com/var/www/html?key=/home/me/.ssh/id_ed25519
+  trusttls get-cert --domain example.com --email admin@example.com --standalone \
+    --haproxy-socket /run/haproxy/admin.sock --haproxy-cert /etc/haproxy/certs/example.com.pem
+  trusttls get-cert --csr example.com.csr --email admin@example.com --dns cloudflare
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		domain, _ := cmd.Flags().GetString("domain")
-		if domain == "" { domain, _ = cmd.Flags().GetString("website") }
+		domainFlags, _ := cmd.Flags().GetStringSlice("domain")
+		if len(domainFlags) == 0 { domainFlags, _ = cmd.Flags().GetStringSlice("website") }
+		domains := parseDomains(domainFlags)
+		var domain string
+		if len(domains) > 0 { domain = domains[0] }
 		email, _ := cmd.Flags().GetString("email")
 		if email == "" { email, _ = cmd.Flags().GetString("contact") }
-		keyType, _ := cmd.Flags().GetString("key-type")
-		keySize, _ := cmd.Flags().GetInt("key-size")
+		keyType, keySize, err := keyFlags(cmd)
+		if err != nil {
+			return err
+		}
 		testMode, _ := cmd.Flags().GetBool("test-mode")
 		server, _ := cmd.Flags().GetString("server")
 		webroot, _ := cmd.Flags().GetString("webroot")
 		if webroot == "" { webroot, _ = cmd.Flags().GetString("web-root") }
+		remoteWebroot, _ := cmd.Flags().GetString("remote-webroot")
+		keySink, _ := cmd.Flags().GetString("key-sink")
+		dnsPlugin, _ := cmd.Flags().GetString("dns")
+		dnsCredentials, _ := cmd.Flags().GetString("dns-credentials")
+		dnsWait, _ := cmd.Flags().GetBool("dns-wait")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+		propagationTimeout, _ := cmd.Flags().GetDuration("propagation-timeout")
+		propagationCheck, _ := cmd.Flags().GetString("propagation-check")
+		caBundle, _ := cmd.Flags().GetString("ca-bundle")
+		if caBundle == "" { caBundle, _ = cmd.Flags().GetString("acme-ca-cert") }
+		deployHook, _ := cmd.Flags().GetString("deploy-hook")
+		postHook, _ := cmd.Flags().GetString("post-hook")
+		soak, _ := cmd.Flags().GetDuration("soak")
+		renewBeforeDays, _ := cmd.Flags().GetInt("renew-before-days")
+		if renewBeforeDays < 0 {
+			return fmt.Errorf("--renew-before-days must not be negative")
+		}
+		placeholder, err := placeholderFlag(cmd)
+		if err != nil {
+			return err
+		}
+		access, err := accessFlags(cmd)
+		if err != nil {
+			return err
+		}
+		haproxySocket, _ := cmd.Flags().GetString("haproxy-socket")
+		haproxyCert, _ := cmd.Flags().GetString("haproxy-cert")
+		standaloneMode, _ := cmd.Flags().GetBool("standalone")
+		challenges, _ := cmd.Flags().GetStringSlice("challenges")
+		if err := renewal.CheckChallenges(challenges); err != nil {
+			return fmt.Errorf("--challenges: %w", err)
+		}
+		standaloneAddress, err := standaloneListen(cmd, standaloneMode)
+		if err != nil {
+			return err
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		jsonEvents, _ := cmd.Flags().GetBool("json-events")
+		csrPath, _ := cmd.Flags().GetString("csr")
+		provider, _ := cmd.Flags().GetString("provider")
+		lifetime, _ := cmd.Flags().GetDuration("lifetime")
+		reuseKey, _ := cmd.Flags().GetBool("reuse-key")
+		mustStaple, _ := cmd.Flags().GetBool("must-staple")
+		expand, _ := cmd.Flags().GetBool("expand")
+		certName, _ := cmd.Flags().GetString("cert-name")
+		force, _ := cmd.Flags().GetBool("force")
+		provider = strings.ToLower(provider)
+		if err := issuer.Check(provider); err != nil {
+			return err
+		}
+
+		var csr *x509.CertificateRequest
+		if csrPath != "" {
+			var err error
+			if csr, err = acme.LoadCSR(csrPath); err != nil {
+				return err
+			}
+			names := acme.CSRNames(csr)
+			if len(domains) > 0 && !acme.SameNames(domains, names) {
+				return fmt.Errorf("--domain %s does not match the CSR, which asks for %s", strings.Join(domains, ","), strings.Join(names, ","))
+			}
+			domains, domain = names, names[0]
+			if keySink != "" {
+				return fmt.Errorf("--csr cannot be used with --key-sink: trusttls never sees the private key")
+			}
+			if placeholder != "" {
+				return fmt.Errorf("--placeholder cannot be used with --csr: the web server's key is not in the store")
+			}
+			csrPath, _ = filepath.Abs(csrPath)
+		}
 		
 		if domain == "" || email == "" {
 			return fmt.Errorf("website domain and email address are required")
 		}
+		if certName != "" {
+			if err := store.ValidCertName(certName); err != nil {
+				return err
+			}
+		}
+		if csr == nil {
+			var err error
+			if certName, domains, err = expandNames(store.DefaultBaseDir(), certName, domains, expand, stdinIsTerminal()); err != nil {
+				return err
+			}
+			domain = domains[0]
+		}
 		
-		if server == "" {
+		if server == "" && provider == "letsencrypt" {
 			if testMode {
 				server = acme.LetsEncryptStaging
 			} else {
@@ -54,84 +167,404 @@ Example:
 			}
 		}
 		
-		if webroot == "" {
-			wr := detectWebroot(domain)
+		method := "http-01"
+		if dnsPlugin != "" {
+			method = "dns-01"
+		}
+		validates := issuer.Validates(provider)
+		if !validates {
+			if dnsPlugin != "" || remoteWebroot != "" || standaloneMode || webroot != "" {
+				return fmt.Errorf("%s does not validate names; drop --webroot, --remote-webroot, --standalone and --dns", provider)
+			}
+			if provider == "internal" && lifetime <= 0 {
+				return fmt.Errorf("--lifetime is required with the internal CA, e.g. --lifetime 720h")
+			}
+			method = provider
+		}
+		if keySink != "" {
+			if _, err := keysink.Parse(keySink); err != nil {
+				return err
+			}
+			if placeholder != "" {
+				return fmt.Errorf("--placeholder cannot be used with --key-sink: the web server's key is not in the store")
+			}
+		}
+		if reuseKey {
+			switch {
+			case keySink != "":
+				return fmt.Errorf("--reuse-key cannot be used with --key-sink: the key is not kept in the store")
+			case csr != nil:
+				return fmt.Errorf("--reuse-key cannot be used with --csr, which keeps the same key anyway")
+			case provider == "digicert":
+				return fmt.Errorf("--reuse-key cannot be used with DigiCert CertCentral, which always generates a new key")
+			}
+		}
+		if mustStaple {
+			switch {
+			case csr != nil:
+				return fmt.Errorf("--must-staple cannot be used with --csr: add the extension when you generate the CSR")
+			case provider == "vault":
+				return fmt.Errorf("--must-staple cannot be used with Vault, whose PKI roles cannot add the extension")
+			}
+		}
+		if err := resolver.Validate(resolvers); err != nil {
+			return err
+		}
+		var haproxyCfg *renewal.HAProxyConfig
+		if haproxySocket != "" || haproxyCert != "" {
+			if haproxySocket == "" || haproxyCert == "" {
+				return fmt.Errorf("--haproxy-socket and --haproxy-cert must be used together")
+			}
+			if keySink != "" {
+				return fmt.Errorf("--haproxy-socket cannot be used with --key-sink: HAProxy needs the private key")
+			}
+			if csr != nil {
+				return fmt.Errorf("--haproxy-socket cannot be used with --csr: HAProxy needs the private key")
+			}
+			haproxyCfg = &renewal.HAProxyConfig{Socket: haproxySocket, CertFile: haproxyCert}
+		}
+		if caBundle != "" {
+			if _, err := acme.LoadCABundle(caBundle); err != nil {
+				return err
+			}
+			caBundle, _ = filepath.Abs(caBundle)
+		}
+
+		var dnsCreds dnsprovider.Credentials
+		if dnsPlugin != "" {
+			if dnsCredentials == "" {
+				if p := dnsprovider.CredentialsPath(store.DefaultBaseDir(), dnsPlugin); osutil.FileExists(p) { dnsCredentials = p }
+			}
+			creds, err := dnsprovider.LoadCredentials(dnsCredentials)
+			if err != nil {
+				return fmt.Errorf("load DNS credentials: %w", err)
+			}
+			if dnsWait {
+				creds["poll"] = "true"
+			}
+			if len(resolvers) > 0 && creds["resolvers"] == "" {
+				creds["resolvers"] = strings.Join(resolvers, ",")
+			}
+			if _, err := dnsprovider.New(dnsPlugin, creds); err != nil {
+				return err
+			}
+			if dnsCredentials != "" {
+				// Keep a private copy in the store so renewals keep working unattended
+				if dnsCredentials, err = dnsprovider.StoreCredentials(store.DefaultBaseDir(), store.LineageName(renewal.Config{Domain: domain, CertName: certName}.Lineage()), dnsPlugin, dnsCredentials); err != nil {
+					return fmt.Errorf("store DNS credentials: %w", err)
+				}
+			}
+			dnsCreds = creds
+		} else if remoteWebroot != "" {
+			if _, err := remotewebroot.New(remoteWebroot); err != nil {
+				return err
+			}
+		} else if standaloneMode {
+			if webroot != "" {
+				return fmt.Errorf("--standalone answers challenges itself; drop --webroot")
+			}
+		} else if webroot == "" && validates {
+			wr := renewal.DetectWebroot(domain)
 			if wr == "" {
 				return fmt.Errorf("website folder not found for %s; please specify --webroot or ensure Apache/Nginx is configured", domain)
 			}
 			webroot = wr
 		}
 
+		for _, ch := range challenges {
+			switch {
+			case !validates || provider == "digicert":
+				return fmt.Errorf("--challenges is for ACME CAs; %s validates names its own way", provider)
+			case ch == renewal.ChallengeDNS && dnsPlugin == "":
+				return fmt.Errorf("--challenges lists dns-01: name the DNS provider with --dns")
+			case ch == renewal.ChallengeHTTP && dnsPlugin != "" && webroot == "" && remoteWebroot == "" && !standaloneMode:
+				// Without --dns the webroot was found above already
+				if webroot = renewal.DetectWebroot(domain); webroot == "" {
+					return fmt.Errorf("--challenges lists http-01: give --webroot, --remote-webroot or --standalone")
+				}
+			}
+		}
+
 		storeDir := store.DefaultBaseDir()
-		m, err := acme.NewManager(acme.Options{
-			Email:    email,
-			Server:   server,
-			KeyType:  keyType,
-			KeySize:  keySize,
-			BaseDir:  storeDir,
+		// A key already in the store for these names is kept; the first
+		// certificate gets a new one like any other
+		orderCSR := csr
+		var keyPEM []byte
+		if reuseKey {
+			var err error
+			if orderCSR, keyPEM, err = renewal.ReusedKey(renewal.Config{Domain: domain, Domains: domains, CertName: certName, BaseDir: storeDir, MustStaple: mustStaple}); err != nil {
+				return err
+			}
+		}
+		iss, err := issuer.New(provider, issuer.Settings{
+			BaseDir:            storeDir,
+			Email:              email,
+			Server:             server,
+			CABundle:           caBundle,
+			KeyType:            keyType,
+			KeySize:            keySize,
+			CSR:                orderCSR,
+			MustStaple:         mustStaple,
+			Resolvers:          resolvers,
+			PropagationTimeout: propagationTimeout,
+			PropagationCheck:   propagationCheck,
+			Lifetime:           lifetime,
+			IgnoreRateLimits:   force,
 		})
 		if err != nil {
 			return err
 		}
-		cert, err := m.ObtainHTTP01([]string{domain}, webroot)
-		if err != nil {
-			return err
+		var listen string
+		if standaloneMode {
+			listen = standaloneAddress
+		}
+		tries := []string{method}
+		if validates && provider != "digicert" {
+			if tries, err = renewal.Challenges(renewal.Config{Domain: domain, Domains: domains, Method: method, Challenges: challenges,
+				Webroot: webroot, RemoteWebroot: remoteWebroot, Standalone: listen, DNSPlugin: dnsPlugin, Provider: provider, BaseDir: store.DefaultBaseDir()}); err != nil {
+				return err
+			}
+		}
+		var capErr error
+		usable := tries[:0]
+		for _, ch := range tries {
+			if err := iss.Capabilities().Check(domains, ch); err != nil {
+				if capErr == nil {
+					capErr = err
+				}
+				continue
+			}
+			usable = append(usable, ch)
+		}
+		if len(usable) == 0 {
+			return capErr
+		}
+		tries = usable
+		req := issuer.Request{
+			Domains:        domains,
+			Webroot:        webroot,
+			RemoteWebroot:  remoteWebroot,
+			DNSPlugin:      dnsPlugin,
+			DNSCredentials: dnsCreds,
 		}
-		path, err := store.SaveCertificate(storeDir, domain, cert)
+		if standaloneMode {
+			srv := standalone.New(standaloneAddress)
+			srv.OnRequest = func(r standalone.Request) {
+				if jsonEvents {
+					_ = json.NewEncoder(os.Stderr).Encode(struct {
+						Event string `json:"event"`
+						standalone.Request
+					}{"http01_request", r})
+				}
+				if verbose {
+					fmt.Printf("📥 %s\n", r)
+				}
+			}
+			announceStandalone(standaloneAddress)
+			req.Standalone = srv
+		}
+		var cert *certificate.Resource
+		for i, ch := range tries {
+			try := req
+			switch ch {
+			case renewal.ChallengeDNS:
+				try.Webroot, try.RemoteWebroot, try.Standalone = "", "", nil
+			case renewal.ChallengeHTTP:
+				try.DNSPlugin, try.DNSCredentials = "", nil
+			}
+			if cert, err = iss.Order(cmd.Context(), try); err == nil {
+				method = ch
+				break
+			}
+			if i+1 == len(tries) || !renewal.FallBack(err) {
+				showProblemHelp(err)
+				return err
+			}
+			fmt.Printf("⚠️  %s validation failed, trying %s: %v\n", ch, tries[i+1], err)
+		}
+		if keyPEM != nil {
+			cert.PrivateKey = keyPEM
+			fmt.Printf("🔑 Kept the existing private key\n")
+		}
+		renewalCfg := renewal.Config{
+			Domain:         domain,
+			Domains:        sanList(domains),
+			CertName:       certName,
+			Email:          email,
+			Server:         server,
+			Method:         method,
+			Challenges:     challenges,
+			Webroot:        webroot,
+			RemoteWebroot:  remoteWebroot,
+			Standalone:     listen,
+			DNSPlugin:      dnsPlugin,
+			DNSCredentials: dnsCredentials,
+			DNSWait:        dnsWait,
+			KeyType:        keyType,
+			KeySize:        keySize,
+			Targets:        []string{},
+			BaseDir:        storeDir,
+			KeySink:        keySink,
+			DeployHook:     deployHook,
+			PostHook:       postHook,
+			Resolvers:      resolvers,
+			PropagationTimeout: durationString(propagationTimeout),
+			PropagationCheck: propagationCheck,
+			CABundle:       caBundle,
+			Soak:           durationString(soak),
+			RenewBeforeDays: renewBeforeDays,
+			Placeholder:    placeholder,
+			CertOwner:      access.CertOwner,
+			CertGroup:      access.CertGroup,
+			KeyMode:        access.KeyMode,
+			HAProxy:        haproxyCfg,
+			CSR:            csrPath,
+			Provider:       provider,
+			ReuseKey:       reuseKey,
+			MustStaple:     mustStaple,
+			Lifetime:       durationString(lifetime),
+		}
+		// The password of a remote webroot goes into the store, not the
+		// renewal config
+		if err := renewal.SaveRemotePassword(&renewalCfg); err != nil {
+			return fmt.Errorf("store remote webroot password: %w", err)
+		}
+		// Copies declared for an earlier certificate under this name are
+		// kept, and written for this one too
+		if old, err := renewal.Load(renewalCfg.Lineage()); err == nil {
+			renewalCfg.Deploy = old.Deploy
+		}
+		path, err := renewal.StoreCertificate(renewalCfg, cert)
 		if err != nil {
 			return err
 		}
 		fmt.Printf("🎉 SSL certificate successfully obtained!\n")
 		fmt.Printf("📁 Certificate saved to: %s\n", path)
-		fmt.Printf("🌐 Domain: %s\n", domain)
+		if err := renewal.DeployCopies(renewalCfg, false); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		} else {
+			for _, d := range renewalCfg.Deploy {
+				fmt.Printf("📋 Copied %s to %s\n", d.Src, d.Dst)
+			}
+		}
+		if haproxyCfg != nil {
+			if err := renewal.DeployHAProxy(renewalCfg); err != nil {
+				fmt.Printf("⚠️  HAProxy not updated: %v\n", err)
+			} else {
+				fmt.Printf("⚖️  Loaded into HAProxy as %s (no reload)\n", haproxyCert)
+			}
+		}
+		if keySink != "" {
+			fmt.Printf("🔑 Private key delivered to: %s (not stored locally)\n", keySink)
+		}
+		if mustStaple {
+			fmt.Printf("📌 OCSP Must-Staple is set: make sure your web server staples OCSP responses\n")
+		}
+		if csr != nil {
+			fmt.Printf("🔑 Issued for your CSR; the private key stays wherever you generated it\n")
+		}
+		fmt.Printf("🌐 Domain: %s\n", strings.Join(domains, ", "))
 		fmt.Printf("📧 Email: %s\n", email)
+		if remoteWebroot != "" {
+			fmt.Printf("📡 Validated through: %s\n", remotewebroot.Redacted(remoteWebroot))
+		}
 		fmt.Printf("💡 Next steps:\n")
 		fmt.Printf("   • Install the certificate files on your web server\n")
-		fmt.Printf("   • Set up automatic renewal with: trusttls renew\n")
+		if dnsPlugin == "manual" {
+			fmt.Printf("   • Manual DNS cannot renew unattended: run 'trusttls renew' yourself before it expires\n")
+		} else {
+			fmt.Printf("   • Set up automatic renewal with: trusttls renew\n")
+		}
 		fmt.Printf("   • Test your SSL setup at: https://www.ssllabs.com/ssltest/\n")
 
 		// Save renewal configuration
-		_ = renewal.Save(renewal.Config{
-			Domain:  domain,
-			Email:   email,
-			Server:  server,
-			Method:  "http-01",
-			Webroot: webroot,
-			KeyType: keyType,
-			KeySize: keySize,
-			Targets: []string{},
-			BaseDir: storeDir,
-		})
+		_ = renewal.Save(renewalCfg)
 		return nil
 	},
 }
 
-func detectWebroot(domain string) string {
-	if p := apache.DetectWebroot(domain); p != "" {
-		return p
-	}
-	if p := nginx.DetectWebroot(domain); p != "" {
-		return p
-	}
-	if osutil.IsMac() {
-		c := []string{"/Library/WebServer/Documents", "/usr/local/var/www"}
-		for _, p := range c {
-			if osutil.DirExists(p) { return p }
-		}
-	}
-	return ""
-}
-
 func init() {
 	rootCmd.AddCommand(certonlyCmd)
-	certonlyCmd.Flags().String("domain", "", "Your website domain name (e.g., example.com)")
-	certonlyCmd.Flags().String("website", "", "Your website domain name (same as --domain)")
+	certonlyCmd.Flags().StringSlice("domain", nil, "Your website domain name (e.g., example.com); repeat or comma-separate for one certificate covering several names")
+	certonlyCmd.Flags().StringSlice("website", nil, "Your website domain name (same as --domain)")
 	certonlyCmd.Flags().String("email", "", "Your email address for certificate notifications")
 	certonlyCmd.Flags().String("contact", "", "Your email address (same as --email)")
 	certonlyCmd.Flags().String("key-type", "rsa", "Encryption key type: rsa (recommended) or ecdsa")
-	certonlyCmd.Flags().Int("key-size", 2048, "Key strength: 2048 or 4096 for RSA, 256 or 384 for ECDSA")
+	certonlyCmd.Flags().Int("key-size", 2048, "Key strength: 2048, 3072 or 4096 for RSA, 256, 384 or 521 for ECDSA")
 	certonlyCmd.Flags().Bool("test-mode", false, "Use test environment (won't issue real certificates)")
-	certonlyCmd.Flags().String("server", "", "Custom certificate provider URL")
+	certonlyCmd.Flags().String("provider", "letsencrypt", fmt.Sprintf("Certificate provider: %s", strings.Join(issuer.Names(), ", ")))
+	certonlyCmd.Flags().Bool("reuse-key", false, "Keep the private key across renewals instead of generating a new one (key pinning, DANE/TLSA)")
+	certonlyCmd.Flags().String("cert-name", "", "Name to keep the certificate under (live/<name>/) instead of its first domain")
+	certonlyCmd.Flags().Bool("expand", false, "When another certificate already carries some of the names, reissue it with the new names added")
+	certonlyCmd.Flags().Bool("must-staple", false, "Add the OCSP Must-Staple extension; clients then refuse the certificate without a stapled OCSP response")
+	certonlyCmd.Flags().Duration("lifetime", 0, "Certificate lifetime for the internal CA (required) and Vault (default: the role's ttl)")
+	certonlyCmd.Flags().String("server", "", "Custom certificate provider URL (the role's issue path, e.g. pki/issue/web, for Vault)")
+	certonlyCmd.Flags().String("ca-bundle", "", "PEM file with extra root certificates to trust for the ACME server (private CA, TLS-intercepting proxy); kept for every later order from it")
+	certonlyCmd.Flags().String("acme-ca-cert", "", "PEM file with extra root certificates to trust for the ACME server (same as --ca-bundle)")
+	certonlyCmd.Flags().String("csr", "", "Submit this CSR (PEM or DER) instead of generating a key; only the certificate and chain are saved")
 	certonlyCmd.Flags().String("webroot", "", "Website folder for validation (e.g., /var/www/html)")
 	certonlyCmd.Flags().String("web-root", "", "Website folder for validation (same as --webroot)")
+	certonlyCmd.Flags().String("dns", "", "Validate with DNS-01 using this DNS provider instead of a webroot")
+	certonlyCmd.Flags().String("dns-credentials", "", "Credentials file for the DNS provider (key = value lines)")
+	certonlyCmd.Flags().StringSlice("resolver", nil, "DNS resolver for CAA and DNS-01 pre-checks: IP, tls://host or https:// DoH URL (repeatable)")
+	certonlyCmd.Flags().Duration("propagation-timeout", 0, "How long to wait for DNS-01 records to show up on public resolvers and nameservers (default: provider estimate)")
+	certonlyCmd.Flags().String("propagation-check", "", "Where DNS-01 records must be visible before validation: authoritative (default) or all (also public resolvers)")
+	certonlyCmd.Flags().Bool("dns-wait", false, "With --dns manual, wait until public resolvers see each TXT record before validating")
+	certonlyCmd.Flags().String("remote-webroot", "", "Upload challenge files over ftp://, ftps:// or sftp:// (password via URL, kept sealed in the store, or TRUSTTLS_REMOTE_PASSWORD)")
+	certonlyCmd.Flags().String("key-sink", "", "Deliver the private key only to file:/path, k8s:<namespace>/<secret> or vault:<path>")
+	certonlyCmd.Flags().String("haproxy-socket", "", "HAProxy runtime API (stats socket path or host:port) to swap renewed certificates in without a reload")
+	certonlyCmd.Flags().String("haproxy-cert", "", "Certificate file HAProxy loads (crt line); written as fullchain plus key")
+	certonlyCmd.Flags().String("deploy-hook", "", "Shell command to run after each successful renewal (e.g. 'systemctl reload haproxy')")
+	certonlyCmd.Flags().String("post-hook", "", "Shell command to run after every renewal attempt")
+	certonlyCmd.Flags().Int("renew-before-days", 0, "Renew this many days before expiry instead of config.yaml's renew_before_days, or 30")
+	certonlyCmd.Flags().Duration("soak", 0, "After each renewal keep the previous certificate in live/<domain>/previous/ until the new one has been served this long (e.g. 72h)")
+	certonlyCmd.Flags().String("placeholder", "", placeholderUsage)
+	addAccessFlags(certonlyCmd)
+	certonlyCmd.Flags().Bool("force", false, forceUsage)
+	certonlyCmd.Flags().Bool("standalone", false, "Answer HTTP-01 challenges from a built-in web server on port 80 (no web server needed)")
+	certonlyCmd.Flags().StringSlice("challenges", nil, "Challenge types to try in order, falling back to the next when one fails, e.g. dns-01,http-01 (needs --dns and a webroot or --standalone); kept for renewals")
+	certonlyCmd.Flags().Int("http-port", standalonePort, httpPortUsage)
+	certonlyCmd.Flags().String("http-address", "", httpAddressUsage)
+	certonlyCmd.Flags().Bool("verbose", false, "Show every HTTP request the built-in server receives")
+	certonlyCmd.Flags().Bool("json-events", false, "With --standalone, write each validation request to stderr as a JSON line")
+}
+
+// standalonePort is where the built-in HTTP-01 server listens by default:
+// the port CAs connect to.
+const standalonePort = 80
+
+const (
+	httpPortUsage    = "Port the built-in HTTP-01 server listens on, e.g. 8080 when a NAT or load balancer forwards port 80 to it"
+	httpAddressUsage = "IP address the built-in HTTP-01 server listens on (default: all addresses)"
+)
+
+// standaloneListen returns the listen address of the built-in HTTP-01
+// server from --http-address and --http-port of cmd. They are refused
+// unless the server is used.
+func standaloneListen(cmd *cobra.Command, used bool) (string, error) {
+	port, _ := cmd.Flags().GetInt("http-port")
+	address, _ := cmd.Flags().GetString("http-address")
+	if !used && (cmd.Flags().Changed("http-port") || cmd.Flags().Changed("http-address")) {
+		return "", fmt.Errorf("--http-port and --http-address are for the built-in server; add --standalone")
+	}
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("--http-port %d: must be between 1 and 65535", port)
+	}
+	if address != "" {
+		ip := net.ParseIP(strings.Trim(address, "[]"))
+		if ip == nil {
+			return "", fmt.Errorf("--http-address %s: must be an IP address of this host", address)
+		}
+		address = ip.String()
+	}
+	return net.JoinHostPort(address, strconv.Itoa(port)), nil
+}
+
+// announceStandalone says where the built-in server answers challenges,
+// and that the CA still connects to port 80 when that is not it.
+func announceStandalone(address string) {
+	fmt.Printf("👂 Answering HTTP-01 challenges on %s\n", address)
+	if _, port, _ := net.SplitHostPort(address); port != strconv.Itoa(standalonePort) {
+		fmt.Printf("💡 The CA connects to port %d: forward it to port %s here\n", standalonePort, port)
+	}
 }
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Monitoring plugin exit codes.
+const (
+	stateOK       = 0
+	stateWarning  = 1
+	stateCritical = 2
+	stateUnknown  = 3
+)
+
+var stateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+var checkExpiryCmd = &cobra.Command{
+	Use:   "check-expiry",
+	Short: "Check every managed certificate for upcoming expiry",
+	Long: `
+Check all certificates in the store in one go and exit with the status of
+the one closest to expiry:
+
+  0  all certificates have more than --warning days left
+  1  at least one has --warning days or fewer left
+  2  at least one has --critical days or fewer left, or has expired
+  3  the store could not be read or holds no certificates
+
+Renewals start 30 days before expiry unless renew_before_days says
+otherwise, so with the defaults a warning means renewal has been failing for
+over a week.
+
+With --nagios the output follows the monitoring plugin conventions used by
+Nagios, Icinga and Zabbix: one status line with perfdata (days left per
+certificate), then one line per certificate.
+
+Examples:
+  trusttls check-expiry
+  trusttls check-expiry --nagios --warning 14 --critical 5
+  trusttls check-expiry --remote https://trusttls.internal:8443 --token s3cret
+`,
+	Annotations: remoteCapable(),
+	Run: func(cmd *cobra.Command, args []string) {
+		nagios, _ := cmd.Flags().GetBool("nagios")
+		warn, _ := cmd.Flags().GetInt("warning")
+		crit, _ := cmd.Flags().GetInt("critical")
+		os.Exit(checkExpiry(store.DefaultBaseDir(), warn, crit, nagios, time.Now()))
+	},
+}
+
+// checkExpiry prints the expiry report and returns the exit code.
+func checkExpiry(baseDir string, warn, crit int, nagios bool, now time.Time) int {
+	unknown := func(format string, a ...interface{}) int {
+		msg := fmt.Sprintf(format, a...)
+		if nagios {
+			fmt.Printf("CERTS UNKNOWN - %s\n", msg)
+		} else {
+			fmt.Printf("❓ %s\n", msg)
+		}
+		return stateUnknown
+	}
+	if warn < 0 || crit < 0 || crit > warn {
+		return unknown("--critical (%d) must not be larger than --warning (%d)", crit, warn)
+	}
+	lineages, err := listLineages(baseDir)
+	if err != nil {
+		return unknown("cannot read %s: %v", storeLocation(baseDir), err)
+	}
+	if len(lineages) == 0 {
+		return unknown("no certificates found in %s", storeLocation(baseDir))
+	}
+	sort.SliceStable(lineages, func(i, j int) bool { return lineages[i].NotAfter.Before(lineages[j].NotAfter) })
+
+	worst := stateOK
+	counts := make([]int, 3)
+	states := make([]int, len(lineages))
+	for i, l := range lineages {
+		left := l.NotAfter.Sub(now)
+		s := stateOK
+		if left <= time.Duration(crit)*24*time.Hour {
+			s = stateCritical
+		} else if left <= time.Duration(warn)*24*time.Hour {
+			s = stateWarning
+		}
+		states[i] = s
+		counts[s]++
+		if s > worst {
+			worst = s
+		}
+	}
+
+	if !nagios {
+		icons := []string{"✅", "⚠️ ", "❌"}
+		for i, l := range lineages {
+			fmt.Printf("%s %s: %s\n", icons[states[i]], l.Name, describeExpiryAt(l.NotAfter, now))
+			if remote != nil {
+				continue
+			}
+			if p, _ := renewal.LoadPlaceholder(baseDir, l.Name); p != nil {
+				fmt.Printf("   🚨 web servers use a self-signed placeholder since %s\n", p.Since.Local().Format("2006-01-02 15:04"))
+			}
+			if h, _ := renewal.LoadHold(baseDir, l.Name); h != nil {
+				fmt.Printf("   ⏳ rate limited: renewal waits until %s\n", h.Until.Local().Format("2006-01-02 15:04"))
+			}
+		}
+		return worst
+	}
+
+	var summary string
+	switch worst {
+	case stateOK:
+		first := lineages[0]
+		summary = fmt.Sprintf("%d certificates valid, next expiry %s in %d days", len(lineages), first.Name, daysLeft(first.NotAfter, now))
+	default:
+		var bad []string
+		for i, l := range lineages {
+			if states[i] == stateOK {
+				continue
+			}
+			if l.NotAfter.After(now) {
+				bad = append(bad, fmt.Sprintf("%s expires in %d days", l.Name, daysLeft(l.NotAfter, now)))
+			} else {
+				bad = append(bad, fmt.Sprintf("%s EXPIRED", l.Name))
+			}
+		}
+		summary = fmt.Sprintf("%d critical, %d warning, %d ok: %s", counts[stateCritical], counts[stateWarning], counts[stateOK], strings.Join(bad, ", "))
+	}
+	var perf []string
+	for _, l := range lineages {
+		perf = append(perf, fmt.Sprintf("'%s'=%d;%d;%d;0", perfLabel(l.Name), daysLeft(l.NotAfter, now), warn, crit))
+	}
+	fmt.Printf("CERTS %s - %s | %s\n", stateNames[worst], summary, strings.Join(perf, " "))
+	for i, l := range lineages {
+		fmt.Printf("%s: %s expires %s\n", stateNames[states[i]], l.Name, l.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return worst
+}
+
+// daysLeft rounds down, so a certificate expiring in 6.9 days counts as 6
+// and an expired one goes negative.
+func daysLeft(t, now time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+// perfLabel makes a lineage name safe for a quoted perfdata label.
+func perfLabel(name string) string {
+	return strings.NewReplacer("'", "_", "=", "_").Replace(name)
+}
+
+func describeExpiryAt(t, now time.Time) string {
+	if !t.After(now) {
+		return fmt.Sprintf("%s (EXPIRED)", t.Format("2006-01-02 15:04 MST"))
+	}
+	return fmt.Sprintf("%s (%d days left)", t.Format("2006-01-02 15:04 MST"), daysLeft(t, now))
+}
+
+func init() {
+	rootCmd.AddCommand(checkExpiryCmd)
+	checkExpiryCmd.Flags().Bool("nagios", false, "Print Nagios/Icinga plugin output with perfdata")
+	checkExpiryCmd.Flags().Int("warning", 21, "Warn when a certificate has this many days or fewer left")
+	checkExpiryCmd.Flags().Int("critical", 7, "Critical when a certificate has this many days or fewer left")
+}
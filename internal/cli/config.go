@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check TrustTLS settings",
+	Long: `
+Example:
+  trusttls config lint          # Check renewal settings before renew runs
+`,
+}
+
+var configLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Find problems in renewal settings before renewal does",
+	Long: `
+Check every renewal config in ~/.trusttls/renewal, the stored DNS provider
+credentials, the CA accounts, the replication peers, the notification
+channels and ~/.trusttls/config.yaml, so mistakes show up now instead of in
+the middle of the night when renew runs.
+
+Checked:
+• YAML syntax and unknown or misspelt keys
+• Required settings and allowed values (method, provider, key type, ...)
+• Web roots, hook programs and credentials files exist
+• DNS credentials are private and complete for their provider
+• DigiCert accounts referenced by email are set up
+• Apache or Nginx is installed for each target
+
+Exits with status 1 when any error is found. Warnings alone do not fail.
+
+Example:
+  trusttls config lint
+  trusttls config lint && trusttls renew
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		files, problems, err := renewal.LintAll(base)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			fmt.Printf("📭 No settings found in %s\n", store.ConfigDir())
+			return nil
+		}
+		byFile := map[string][]renewal.Problem{}
+		for _, p := range problems {
+			byFile[p.File] = append(byFile[p.File], p)
+		}
+		errs, warnings := 0, 0
+		for _, f := range files {
+			rel := f
+			for _, dir := range []string{store.ConfigDir(), base} {
+				if r, err := filepath.Rel(dir, f); err == nil && !strings.HasPrefix(r, "..") {
+					rel = r
+					break
+				}
+			}
+			if len(byFile[f]) == 0 {
+				fmt.Printf("✅ %s\n", rel)
+				continue
+			}
+			fmt.Printf("📄 %s\n", rel)
+			for _, p := range byFile[f] {
+				if p.Warning {
+					warnings++
+					fmt.Printf("   ⚠️  %s\n", p)
+				} else {
+					errs++
+					fmt.Printf("   ❌ %s\n", p)
+				}
+			}
+		}
+		fmt.Printf("\n%d file(s) checked: %d error(s), %d warning(s)\n", len(files), errs, warnings)
+		if errs > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configLintCmd)
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <domain>",
+	Short: "Remove a certificate from the store and stop renewing it",
+	Long: `
+Delete a managed certificate: its files in live/ and archive/, its renewal
+config and the state kept for it. Before anything is removed, delete lists
+what will go and the Apache or Nginx configs that still load the
+certificate's files; those configs are not changed and will fail to load
+until they are pointed elsewhere. Copies delivered to HAProxy, key sinks,
+replication peers or by deploy hooks stay where they are.
+
+<domain> is the certificate's name or its primary domain. With --revoke the
+CA is asked to revoke the certificate first, and nothing is deleted if that
+fails.
+
+Without --yes delete asks before removing anything, and refuses when there
+is no terminal to ask on.
+
+Example:
+  trusttls delete example.com
+  trusttls delete example.com --revoke
+  trusttls delete old.example.com --yes
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		revoke, _ := cmd.Flags().GetBool("revoke")
+		assumeYes, _ := cmd.Flags().GetBool("yes")
+		base := store.DefaultBaseDir()
+		c, err := renewal.Find(args[0])
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			// A lineage without a renewal config, e.g. from an import
+			l, lerr := store.LoadLineage(base, args[0])
+			if lerr != nil {
+				return fmt.Errorf("no certificate named %s", args[0])
+			}
+			c = renewal.Config{Domain: l.Name, BaseDir: base}
+		}
+		files := renewal.LineageFiles(c)
+		if len(files) == 0 {
+			return fmt.Errorf("no certificate named %s", args[0])
+		}
+
+		fmt.Printf("🗑️  Deleting %s removes:\n", c.Lineage())
+		for _, f := range files {
+			fmt.Printf("   %s\n", f)
+		}
+		if refs := renewal.ReferringConfigs(c); len(refs) > 0 {
+			fmt.Printf("⚠️  These web server configs still load its files and will break:\n")
+			for _, f := range refs {
+				fmt.Printf("   %s\n", f)
+			}
+		}
+		if revoke {
+			fmt.Printf("🚫 The certificate is revoked with the CA first\n")
+		}
+		if !assumeYes {
+			if !stdinIsTerminal() {
+				return fmt.Errorf("not deleting %s without confirmation; pass --yes", c.Lineage())
+			}
+			if !NewUI(false).AskYesNo(fmt.Sprintf("Delete %s?", c.Lineage())) {
+				fmt.Println("ℹ️  Nothing deleted")
+				return nil
+			}
+		}
+
+		if revoke {
+			b, err := store.Open(c.BaseDir).Load(c.Lineage())
+			if err != nil {
+				return err
+			}
+			certPEM := b.Cert
+			iss, err := renewal.Issuer(c)
+			if err != nil {
+				return err
+			}
+			if err := iss.Revoke(cmd.Context(), certPEM); err != nil {
+				return fmt.Errorf("revoke %s: %w; nothing deleted", c.Lineage(), err)
+			}
+			fmt.Printf("🚫 Certificate for %s revoked by %s\n", c.Lineage(), iss.Capabilities().CA)
+		}
+		removed, err := renewal.Delete(c)
+		for _, f := range removed {
+			fmt.Printf("🧹 Removed %s\n", f)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s deleted\n", c.Lineage())
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().Bool("revoke", false, "Revoke the certificate with the CA before deleting it")
+	deleteCmd.Flags().Bool("yes", false, "Delete without asking")
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/plugins/truststore"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// devCertLifetime stays under the 825 days Apple platforms accept for
+// certificates from a locally trusted root.
+const devCertLifetime = 825 * 24 * time.Hour
+
+// devCANickname names the dev CA in trust stores.
+const devCANickname = "TrustTLS dev CA"
+
+var devCertCmd = &cobra.Command{
+	Use:   "dev-cert",
+	Short: "Issue a locally trusted certificate for development",
+	Long: `
+Issue a certificate for localhost or a development name such as
+myapp.local from a root CA kept in the store. No ACME server or public DNS
+is involved, so it works for names no public CA will certify.
+
+--install-ca adds the dev CA to the system trust store and to the NSS
+databases of Firefox (and Chrome on Linux, when NSS's certutil is installed),
+so browsers, curl and language runtimes accept the certificates without
+warnings. This needs root or sudo once. --uninstall-ca removes it again.
+
+The dev CA only exists on this machine; keep ca-key.pem private, anyone with
+it can impersonate any site to the browsers that trust it.
+
+Example:
+  trusttls dev-cert --domain myapp.local --install-ca
+  trusttls dev-cert --domain localhost,127.0.0.1,::1
+  trusttls dev-cert --uninstall-ca
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domains, _ := cmd.Flags().GetStringSlice("domain")
+		installCA, _ := cmd.Flags().GetBool("install-ca")
+		uninstallCA, _ := cmd.Flags().GetBool("uninstall-ca")
+		keyType, keySize, err := keyFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		storeDir := store.DefaultBaseDir()
+		if uninstallCA {
+			caFile := filepath.Join(ca.Dir(storeDir, ca.DevCA), "ca.pem")
+			removed, err := truststore.Uninstall(devCANickname, caFile)
+			for _, where := range removed {
+				fmt.Printf("🗑️  Removed the dev CA from: %s\n", where)
+			}
+			if len(removed) == 0 && err == nil {
+				fmt.Println("ℹ️  The dev CA was not installed in any trust store")
+			}
+			return err
+		}
+		if len(domains) == 0 {
+			return fmt.Errorf("--domain is required")
+		}
+
+		authority, err := ca.LoadOrCreate(storeDir, ca.DevCA)
+		if err != nil {
+			return fmt.Errorf("load %s CA: %w", ca.DevCA, err)
+		}
+		caFile := filepath.Join(authority.Dir, "ca.pem")
+		if installCA {
+			installed, err := truststore.Install(devCANickname, caFile)
+			for _, where := range installed {
+				fmt.Printf("🔐 Dev CA trusted in: %s\n", where)
+			}
+			if err != nil {
+				fmt.Printf("⚠️  Not every trust store was updated: %v\n", err)
+			}
+		}
+
+		cert, err := authority.Issue(ca.LeafRequest{
+			Domains:  domains,
+			Lifetime: devCertLifetime,
+			KeyType:  keyType,
+			KeySize:  keySize,
+		})
+		if err != nil {
+			return err
+		}
+		cfg := renewal.Config{
+			Domain:   domains[0],
+			Method:   "internal",
+			Provider: "internal",
+			CA:       ca.DevCA,
+			Lifetime: devCertLifetime.String(),
+			KeyType:  keyType,
+			KeySize:  keySize,
+			Targets:  []string{},
+			BaseDir:  storeDir,
+		}
+		if len(domains) > 1 {
+			cfg.Domains = domains
+		}
+		path, err := renewal.StoreCertificate(cfg, cert)
+		if err != nil {
+			return err
+		}
+		if err := renewal.Save(cfg); err != nil {
+			return err
+		}
+
+		fmt.Printf("🎉 Development certificate issued for %s\n", strings.Join(domains, ", "))
+		fmt.Printf("📁 Certificate saved to: %s\n", path)
+		fmt.Printf("⏰ Valid until: %s\n", time.Now().Add(devCertLifetime).Format("2006-01-02"))
+		fmt.Printf("🏛️  Dev CA certificate: %s\n", caFile)
+		if !installCA {
+			fmt.Printf("💡 Run with --install-ca once so browsers trust it\n")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(devCertCmd)
+	devCertCmd.Flags().StringSlice("domain", nil, "Names and IP addresses for the certificate; repeat or comma-separate")
+	devCertCmd.Flags().Bool("install-ca", false, "Trust the dev CA in the system and browser trust stores")
+	devCertCmd.Flags().Bool("uninstall-ca", false, "Remove the dev CA from the trust stores and exit")
+	devCertCmd.Flags().String("key-type", "ecdsa", "Key algorithm: rsa or ecdsa")
+	devCertCmd.Flags().Int("key-size", 256, "Key size for rsa (2048-8192) or curve bits (256/384/521) for ecdsa")
+}
@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var digicertCmd = &cobra.Command{
+	Use:   "digicert",
+	Short: "Order OV and EV certificates through DigiCert CertCentral",
+	Long: `
+Organization (OV) and extended (EV) validation certificates name your
+organization, so DigiCert's staff check the organization and, for EV, call a
+verified contact before issuing. That can take days.
+
+Look up the organization and contact IDs of your CertCentral account, then
+place the order. trusttls keeps the submitted order in the store while it
+waits and prints which validations DigiCert is still working on; if the wait
+is interrupted, running the same order command again resumes the order
+instead of placing a new one.
+
+The DigiCert account is read from ~/.trusttls/accounts/digicert/<email>/.
+
+Example:
+  trusttls digicert orgs --email admin@example.com
+  trusttls digicert contacts --email admin@example.com --org-id 112233
+  trusttls digicert order --domain example.com --email admin@example.com \
+    --validation ev --org-id 112233 --contact-id 445566 --webroot /var/www/html
+  trusttls digicert status --domain example.com
+`,
+}
+
+var digicertOrgsCmd = &cobra.Command{
+	Use:   "orgs",
+	Short: "List the organizations of the DigiCert account",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		email, _ := cmd.Flags().GetString("email")
+		p, err := digicertProvider(renewal.Config{Email: email, BaseDir: store.DefaultBaseDir()})
+		if err != nil {
+			return err
+		}
+		lister, ok := p.(interface {
+			Organizations() ([]acme.DigiCertOrganization, error)
+		})
+		if !ok {
+			return fmt.Errorf("DigiCert provider interface not available")
+		}
+		orgs, err := lister.Organizations()
+		if err != nil {
+			return err
+		}
+		if len(orgs) == 0 {
+			fmt.Println("📭 No organizations in this DigiCert account")
+			return nil
+		}
+		for _, o := range orgs {
+			fmt.Printf("🏢 %-10s %s\n", o.ID, o.Name)
+			for _, v := range o.Validations {
+				mark := "⏳"
+				if v.Done() {
+					mark = "✅"
+				}
+				fmt.Printf("     %s %s: %s\n", mark, v.Type, v.Status)
+			}
+		}
+		return nil
+	},
+}
+
+var digicertContactsCmd = &cobra.Command{
+	Use:   "contacts",
+	Short: "List the contacts of a DigiCert organization",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		email, _ := cmd.Flags().GetString("email")
+		orgID, _ := cmd.Flags().GetString("org-id")
+		if orgID == "" {
+			return fmt.Errorf("--org-id is required (see trusttls digicert orgs)")
+		}
+		p, err := digicertProvider(renewal.Config{Email: email, BaseDir: store.DefaultBaseDir()})
+		if err != nil {
+			return err
+		}
+		lister, ok := p.(interface {
+			Contacts(string) ([]acme.DigiCertContact, error)
+		})
+		if !ok {
+			return fmt.Errorf("DigiCert provider interface not available")
+		}
+		contacts, err := lister.Contacts(orgID)
+		if err != nil {
+			return err
+		}
+		if len(contacts) == 0 {
+			fmt.Printf("📭 Organization %s has no contacts\n", orgID)
+			return nil
+		}
+		for _, c := range contacts {
+			verified := ""
+			if c.Verified {
+				verified = " (verified, can approve EV)"
+			}
+			fmt.Printf("👤 %-10s %s <%s> %s%s\n", c.ID, c.Name, c.Email, c.JobTitle, verified)
+		}
+		return nil
+	},
+}
+
+var digicertOrderCmd = &cobra.Command{
+	Use:   "order",
+	Short: "Order an OV or EV certificate, or resume a pending order",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domains, _ := cmd.Flags().GetStringSlice("domain")
+		email, _ := cmd.Flags().GetString("email")
+		validation, _ := cmd.Flags().GetString("validation")
+		orgID, _ := cmd.Flags().GetString("org-id")
+		contactID, _ := cmd.Flags().GetString("contact-id")
+		webroot, _ := cmd.Flags().GetString("webroot")
+		dnsPlugin, _ := cmd.Flags().GetString("dns")
+		dnsCredentials, _ := cmd.Flags().GetString("dns-credentials")
+		mustStaple, _ := cmd.Flags().GetBool("must-staple")
+		if len(domains) == 0 || email == "" {
+			return fmt.Errorf("--domain and --email are required")
+		}
+		keyType, keySize, err := keyFlags(cmd)
+		if err != nil {
+			return err
+		}
+		validation = strings.ToLower(validation)
+
+		c := renewal.Config{
+			Domain:         domains[0],
+			Email:          email,
+			Method:         "digicert",
+			Webroot:        webroot,
+			DNSPlugin:      dnsPlugin,
+			DNSCredentials: dnsCredentials,
+			KeyType:        keyType,
+			KeySize:        keySize,
+			BaseDir:        store.DefaultBaseDir(),
+			Provider:       "digicert",
+			Validation:     validation,
+			OrganizationID: orgID,
+			ContactID:      contactID,
+			MustStaple:     mustStaple,
+		}
+		if len(domains) > 1 {
+			c.Domains = domains
+		}
+		if _, ok := acme.DigiCertValidations[validation]; !ok {
+			return fmt.Errorf("--validation must be ov or ev, not %q", validation)
+		}
+		if orgID == "" {
+			return fmt.Errorf("--org-id is required for %s certificates (see trusttls digicert orgs)", validationName(validation))
+		}
+		if validation == "ev" && contactID == "" {
+			return fmt.Errorf("--contact-id is required for EV certificates (see trusttls digicert contacts)")
+		}
+
+		p, err := digicertProvider(c)
+		if err != nil {
+			return err
+		}
+		obtainer, ok := p.(interface {
+			ObtainCertificate([]string) (*certificate.Resource, error)
+		})
+		if !ok {
+			return fmt.Errorf("DigiCert provider interface not available")
+		}
+		fmt.Printf("🏢 Ordering %s certificate for %s\n", validationName(validation), strings.Join(c.Names(), ", "))
+		cert, err := obtainer.ObtainCertificate(c.Names())
+		if err != nil {
+			return err
+		}
+		path, err := renewal.StoreCertificate(c, cert)
+		if err != nil {
+			return err
+		}
+		if err := renewal.Save(c); err != nil {
+			return err
+		}
+		fmt.Printf("🎉 Certificate issued and saved to: %s\n", path)
+		fmt.Printf("🔄 Renewals reuse the organization and validation settings: trusttls renew\n")
+		return nil
+	},
+}
+
+var digicertStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show a pending DigiCert order and what it still needs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		email, _ := cmd.Flags().GetString("email")
+		if domain == "" {
+			return fmt.Errorf("--domain is required")
+		}
+		c, err := renewal.Load(domain)
+		if err != nil {
+			c = renewal.Config{Domain: domain, Email: email, BaseDir: store.DefaultBaseDir()}
+		} else if email != "" {
+			c.Email = email
+		}
+		pending, err := acme.LoadDigiCertOrder(renewal.DigiCertOrderFile(c))
+		if err != nil {
+			return err
+		}
+		if pending == nil {
+			fmt.Printf("✅ No pending DigiCert order for %s\n", domain)
+			return nil
+		}
+		fmt.Printf("📝 Order %s (%s) for %s\n", pending.OrderID, validationName(pending.Validation), strings.Join(pending.Domains, ", "))
+		fmt.Printf("   Submitted %s (%s ago)\n", pending.Submitted.Format(time.RFC1123), time.Since(pending.Submitted).Round(time.Minute))
+
+		p, err := digicertProvider(c)
+		if err != nil {
+			return err
+		}
+		lister, ok := p.(interface {
+			Validations(string) ([]acme.DigiCertValidation, error)
+		})
+		if !ok {
+			return fmt.Errorf("DigiCert provider interface not available")
+		}
+		validations, err := lister.Validations(pending.OrderID)
+		if err != nil {
+			return err
+		}
+		for _, v := range validations {
+			if v.Done() {
+				fmt.Printf("   ✅ %s\n", v.Type)
+			} else {
+				fmt.Printf("   ⏳ %s (%s): %s\n", v.Type, v.Status, v.Description)
+			}
+		}
+		fmt.Printf("💡 Run 'trusttls digicert order' with the same names to keep waiting for it\n")
+		return nil
+	},
+}
+
+// digicertProvider returns the CertCentral client for c's account.
+func digicertProvider(c renewal.Config) (interface{}, error) {
+	if c.Email == "" {
+		return nil, fmt.Errorf("--email is required to find the DigiCert account")
+	}
+	cfg, err := renewal.DigiCertConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return acme.NewDigiCertProvider(*cfg)
+}
+
+// validationName describes a DigiCert validation level for messages.
+func validationName(v string) string {
+	if v == "" {
+		return "DV"
+	}
+	return strings.ToUpper(v)
+}
+
+func init() {
+	rootCmd.AddCommand(digicertCmd)
+	digicertCmd.AddCommand(digicertOrgsCmd, digicertContactsCmd, digicertOrderCmd, digicertStatusCmd)
+	digicertCmd.PersistentFlags().String("email", "", "Email of the DigiCert account")
+
+	digicertContactsCmd.Flags().String("org-id", "", "DigiCert organization ID")
+
+	digicertOrderCmd.Flags().StringSlice("domain", nil, "Names on the certificate; repeat or comma-separate, primary first")
+	digicertOrderCmd.Flags().String("validation", "ov", "Validation level: ov or ev")
+	digicertOrderCmd.Flags().String("org-id", "", "DigiCert organization named on the certificate")
+	digicertOrderCmd.Flags().String("contact-id", "", "Verified contact who approves EV orders")
+	digicertOrderCmd.Flags().String("webroot", "", "Webroot for HTTP domain control validation")
+	digicertOrderCmd.Flags().String("dns", "", "DNS provider for domain control validation (azure, cloudflare, gcloud, route53)")
+	digicertOrderCmd.Flags().String("dns-credentials", "", "Credentials file for --dns")
+	digicertOrderCmd.Flags().Bool("must-staple", false, "Add the OCSP Must-Staple extension to the order's CSR")
+	digicertOrderCmd.Flags().String("key-type", "rsa", "Key algorithm: rsa or ecdsa")
+	digicertOrderCmd.Flags().Int("key-size", 2048, "Key size for rsa (2048, 3072 or 4096) or curve bits (256/384) for ecdsa")
+
+	digicertStatusCmd.Flags().String("domain", "", "Primary name of the pending order")
+}
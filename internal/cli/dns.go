@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/resolver"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Manage DNS provider credentials",
+	Long: `
+DNS provider credentials are kept in ~/.trusttls/dns/<provider>.ini and used
+by --dns when no --dns-credentials file is given.
+
+Example:
+  trusttls dns import --from certbot      # Reuse certbot DNS plugin settings
+  trusttls dns import --from lego         # Reuse lego environment variables
+`,
+}
+
+var dnsImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import DNS provider credentials from certbot or lego",
+	Long: `
+Copy DNS provider credentials from another ACME client into the TrustTLS
+store so wildcard and DNS-01 certificates keep renewing after a migration.
+
+certbot: the renewal configs in --certbot-dir are read to find each DNS
+plugin's credentials file (dns-cloudflare, dns-route53, dns-google,
+dns-rfc2136, dns-azure). Use --credentials with --provider to import a
+single plugin INI file instead.
+
+lego: credentials are read from lego's environment variables, such as
+CF_DNS_API_TOKEN, AWS_ACCESS_KEY_ID, GCE_PROJECT, AZURE_CLIENT_SECRET or
+RFC2136_TSIG_SECRET, including the VAR_FILE variants. Use --env-file to
+read them from a file instead of the current environment.
+
+Existing TrustTLS credentials are kept unless --force is given.
+
+Example:
+  sudo trusttls dns import --from certbot
+  trusttls dns import --from certbot --provider cloudflare --credentials ~/.secrets/cloudflare.ini
+  trusttls dns import --from lego --provider rfc2136 --env-file /etc/lego/env
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		certbotDir, _ := cmd.Flags().GetString("certbot-dir")
+		provider, _ := cmd.Flags().GetString("provider")
+		credentials, _ := cmd.Flags().GetString("credentials")
+		envFile, _ := cmd.Flags().GetString("env-file")
+		force, _ := cmd.Flags().GetBool("force")
+		provider = strings.ToLower(provider)
+
+		var found []dnsprovider.Imported
+		switch from {
+		case "certbot":
+			if credentials != "" {
+				if provider == "" {
+					return fmt.Errorf("--provider is required with --credentials")
+				}
+				imp, err := dnsprovider.ImportCertbotFile(provider, credentials)
+				if err != nil {
+					return err
+				}
+				found = append(found, imp)
+				break
+			}
+			all, err := dnsprovider.ImportCertbot(certbotDir)
+			if err != nil {
+				return err
+			}
+			for _, imp := range all {
+				if provider == "" || imp.Provider == provider {
+					found = append(found, imp)
+				}
+			}
+		case "lego":
+			env := dnsprovider.Environ()
+			if envFile != "" {
+				var err error
+				if env, err = dnsprovider.ReadEnvFile(envFile); err != nil {
+					return err
+				}
+			}
+			var err error
+			if found, err = dnsprovider.ImportLegoEnv(provider, env); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("--from must be certbot or lego")
+		}
+		if len(found) == 0 {
+			fmt.Printf("📭 No DNS provider credentials found in %s settings\n", from)
+			return nil
+		}
+
+		sort.SliceStable(found, func(i, j int) bool { return found[i].Provider < found[j].Provider })
+		imported, failed := 0, 0
+		for _, imp := range found {
+			if imp.File == "" && len(imp.Creds) == 0 {
+				if imp.Provider == "route53" {
+					fmt.Printf("ℹ️  route53 (%s): no credentials file; AWS credentials are read from the environment, ~/.aws or the instance role as before\n", imp.Source)
+				} else {
+					fmt.Printf("ℹ️  %s: no settings found in %s\n", imp.Provider, imp.Source)
+				}
+				continue
+			}
+			path, err := dnsprovider.SaveImported(store.DefaultBaseDir(), imp, force)
+			if errors.Is(err, os.ErrExist) {
+				fmt.Printf("⏭️  %s: already stored in %s; use --force to replace it\n", imp.Provider, dnsprovider.CredentialsPath(store.DefaultBaseDir(), imp.Provider))
+				continue
+			}
+			if err != nil {
+				fmt.Printf("⚠️  %s (%s): %v\n", imp.Provider, imp.Source, err)
+				failed++
+				continue
+			}
+			fmt.Printf("✅ %s: imported from %s to %s\n", imp.Provider, imp.Source, path)
+			imported++
+		}
+		if imported > 0 {
+			fmt.Printf("\n💡 Use them with: trusttls get-cert --domain '*.example.com' --dns <provider>\n")
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d provider(s) not imported", failed)
+		}
+		return nil
+	},
+}
+
+var dnsDelegateCmd = &cobra.Command{
+	Use:   "delegate",
+	Short: "Delegate _acme-challenge records to another zone and check they resolve",
+	Long: `
+Print the records that hand a domain's DNS-01 challenges to another zone,
+optionally create them, and check from public resolvers that the delegation
+works before any certificate is requested.
+
+Delegating lets trusttls hold credentials for a small challenge zone (or an
+acme-dns server) instead of the main zone:
+
+  --target   _acme-challenge.<domain> becomes a CNAME to this name. Use
+             {domain} in it for one record per name, e.g.
+             {domain}.acme.example.net, or the fulldomain of an acme-dns
+             account. Issue with --dns set to the provider of the target zone.
+  --ns       _acme-challenge.<domain> becomes its own zone served by these
+             name servers (for an rfc2136 server you run, say).
+
+--apply creates the records in the main zone through --dns (cloudflare or
+route53). Without it they are printed for you to add. --wait keeps checking
+until the delegation resolves or the time is up.
+
+Example:
+  trusttls dns delegate --domain example.com,'*.example.com' --target '{domain}.acme.example.net'
+  trusttls dns delegate --domain example.com --ns ns1.acme.example.net --ns ns2.acme.example.net
+  trusttls dns delegate --domain example.com --target example.com.acme.example.net \
+    --apply --dns cloudflare --wait 5m
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domains, _ := cmd.Flags().GetStringSlice("domain")
+		target, _ := cmd.Flags().GetString("target")
+		nameservers, _ := cmd.Flags().GetStringSlice("ns")
+		apply, _ := cmd.Flags().GetBool("apply")
+		dnsPlugin, _ := cmd.Flags().GetString("dns")
+		dnsCredentials, _ := cmd.Flags().GetString("dns-credentials")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+		wait, _ := cmd.Flags().GetDuration("wait")
+		if len(domains) == 0 {
+			return fmt.Errorf("--domain is required")
+		}
+		records, err := acme.DelegationRecords(domains, target, nameservers)
+		if err != nil {
+			return fmt.Errorf("%w (--target or --ns)", err)
+		}
+
+		if apply {
+			if dnsPlugin == "" {
+				return fmt.Errorf("--apply needs --dns to create the records")
+			}
+			if dnsCredentials == "" {
+				if p := dnsprovider.CredentialsPath(store.DefaultBaseDir(), dnsPlugin); osutil.FileExists(p) {
+					dnsCredentials = p
+				}
+			}
+			creds, err := dnsprovider.LoadCredentials(dnsCredentials)
+			if err != nil {
+				return err
+			}
+			setter, err := dnsprovider.NewRecordSetter(dnsPlugin, creds)
+			if err != nil {
+				return err
+			}
+			for _, rec := range records {
+				if err := setter.SetRecord(rec.Name, rec.Type, rec.Values); err != nil {
+					return err
+				}
+				fmt.Printf("✅ Created %s %s -> %s\n", rec.Name, rec.Type, strings.Join(rec.Values, ", "))
+			}
+		} else {
+			fmt.Println("📋 Add these records to the zone of each domain:")
+			fmt.Println()
+			for _, rec := range records {
+				fmt.Println(rec)
+			}
+			fmt.Println()
+		}
+
+		if len(resolvers) == 0 {
+			resolvers = resolver.Public
+		}
+		r, err := resolver.New(resolvers)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(wait)
+		pending := records
+		for {
+			var failed []acme.Delegation
+			for _, rec := range pending {
+				if err := acme.CheckDelegation(cmd.Context(), r, rec); err != nil {
+					if !time.Now().Before(deadline) {
+						fmt.Printf("⏳ %s: %v\n", rec.Domain, err)
+					}
+					failed = append(failed, rec)
+					continue
+				}
+				fmt.Printf("✅ %s: challenges delegated to %s\n", rec.Domain, strings.Join(rec.Values, ", "))
+			}
+			pending = failed
+			if len(pending) == 0 {
+				break
+			}
+			if !time.Now().Before(deadline) {
+				return fmt.Errorf("%d delegation(s) not resolving yet from %s; check again with --wait once the records are in place", len(pending), r)
+			}
+			time.Sleep(10 * time.Second)
+		}
+		fmt.Printf("\n💡 Issue with --dns set to the provider of the delegated zone, e.g.\n")
+		fmt.Printf("   trusttls get-cert --domain %s --dns <provider>\n", domains[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsImportCmd, dnsDelegateCmd)
+	dnsDelegateCmd.Flags().StringSlice("domain", nil, "Names whose challenges to delegate; repeat or comma-separate")
+	dnsDelegateCmd.Flags().String("target", "", "CNAME target for _acme-challenge.<domain>; {domain} is replaced by each name")
+	dnsDelegateCmd.Flags().StringSlice("ns", nil, "Name servers of a delegated _acme-challenge zone (instead of --target)")
+	dnsDelegateCmd.Flags().Bool("apply", false, "Create the records through --dns instead of printing them")
+	dnsDelegateCmd.Flags().String("dns", "", "DNS provider of the main zone for --apply (cloudflare or route53)")
+	dnsDelegateCmd.Flags().String("dns-credentials", "", "Credentials file for --dns")
+	dnsDelegateCmd.Flags().StringSlice("resolver", nil, "Resolvers to check the delegation with (default: public resolvers)")
+	dnsDelegateCmd.Flags().Duration("wait", 0, "Keep checking this long for the delegation to resolve, e.g. 5m")
+	dnsImportCmd.Flags().String("from", "", "Client to import from: certbot|lego")
+	dnsImportCmd.Flags().String("certbot-dir", "/etc/letsencrypt", "certbot configuration directory")
+	dnsImportCmd.Flags().String("provider", "", "Only import this DNS provider")
+	dnsImportCmd.Flags().String("credentials", "", "certbot DNS plugin credentials file to import (with --provider)")
+	dnsImportCmd.Flags().String("env-file", "", "Read lego variables from this KEY=VALUE file instead of the environment")
+	dnsImportCmd.Flags().Bool("force", false, "Replace credentials already stored in TrustTLS")
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/keysink"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// maxEdgeLifetime mirrors the 7 day cap that RFC 9345 places on delegated credentials.
+const maxEdgeLifetime = 7 * 24 * time.Hour
+
+var edgeCertCmd = &cobra.Command{
+	Use:   "edge-cert",
+	Short: "Issue a short-lived certificate for an edge server from the internal CA",
+	Long: `
+Issue a short-lived certificate signed by the internal edge CA kept in the
+store. Edge nodes never hold a long-lived key: the central server reissues the
+certificate well before it expires and pushes it out again.
+
+The certificate is renewed by 'trusttls renew' once two thirds of its
+lifetime has passed. Clients must trust the edge CA certificate printed below.
+
+Example:
+  trusttls edge-cert --domain edge1.example.com --lifetime 24h
+  trusttls edge-cert --domain edge1.example.com --key-sink k8s:edge/edge1-tls
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		lifetime, _ := cmd.Flags().GetDuration("lifetime")
+		keyType, keySize, err := keyFlags(cmd)
+		if err != nil {
+			return err
+		}
+		keySink, _ := cmd.Flags().GetString("key-sink")
+
+		if domain == "" {
+			return fmt.Errorf("--domain is required")
+		}
+		if lifetime <= 0 || lifetime > maxEdgeLifetime {
+			return fmt.Errorf("lifetime must be between 1s and %s", maxEdgeLifetime)
+		}
+		if keySink != "" {
+			if _, err := keysink.Parse(keySink); err != nil {
+				return err
+			}
+		}
+
+		storeDir := store.DefaultBaseDir()
+		authority, err := ca.LoadOrCreate(storeDir, ca.EdgeCA)
+		if err != nil {
+			return fmt.Errorf("load edge CA: %w", err)
+		}
+		cert, err := authority.Issue(ca.LeafRequest{
+			Domains:  []string{domain},
+			Lifetime: lifetime,
+			KeyType:  keyType,
+			KeySize:  keySize,
+		})
+		if err != nil {
+			return err
+		}
+		cfg := renewal.Config{
+			Domain:   domain,
+			Method:   "internal",
+			Provider: "internal",
+			Lifetime: lifetime.String(),
+			KeyType:  keyType,
+			KeySize:  keySize,
+			Targets:  []string{},
+			BaseDir:  storeDir,
+			KeySink:  keySink,
+		}
+		path, err := renewal.StoreCertificate(cfg, cert)
+		if err != nil {
+			return err
+		}
+		if err := renewal.Save(cfg); err != nil {
+			return err
+		}
+
+		fmt.Printf("🎉 Short-lived certificate issued for %s\n", domain)
+		fmt.Printf("📁 Certificate saved to: %s\n", path)
+		fmt.Printf("⏰ Valid for: %s\n", lifetime)
+		fmt.Printf("🏛️  Edge CA certificate: %s\n", filepath.Join(authority.Dir, "ca.pem"))
+		fmt.Printf("💡 Run 'trusttls renew' at least every %s to keep it fresh\n", (lifetime / 3).Round(time.Minute))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(edgeCertCmd)
+	edgeCertCmd.Flags().String("domain", "", "Edge server host name")
+	edgeCertCmd.Flags().Duration("lifetime", 24*time.Hour, "Certificate lifetime (max 168h)")
+	edgeCertCmd.Flags().String("key-type", "ecdsa", "Key algorithm: rsa or ecdsa")
+	edgeCertCmd.Flags().Int("key-size", 256, "Key size for rsa (2048-8192) or curve bits (256/384/521) for ecdsa")
+	edgeCertCmd.Flags().String("key-sink", "", "Deliver the private key only to file:/path, k8s:<namespace>/<secret> or vault:<path>")
+}
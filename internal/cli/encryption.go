@@ -0,0 +1,274 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/seal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var encryptionCmd = &cobra.Command{
+	Use:   "encryption",
+	Short: "Encrypt the account keys and credentials in the store",
+	Long: `
+Encrypt the secrets in ~/.trusttls: ACME account keys and credentials,
+pending orders, internal CA keys and DNS provider credentials. Each file is
+encrypted with a data key of its own, and the data key is stored in the file
+wrapped by a master key:
+
+  passphrase                   from TRUSTTLS_PASSPHRASE, TRUSTTLS_PASSPHRASE_FILE or the
+                               systemd credential trusttls-passphrase, or typed in
+  keyfile[:path]               a random key in a file kept off the store, created
+                               when missing; without a path, the systemd credential
+                               trusttls-keyfile
+  keyring[:name]               a random key created in the OS keyring
+                               (Secret Service with secret-tool, or the macOS Keychain)
+  aws-kms:<key-id>             an AWS KMS key, used through the aws CLI
+  vault-transit:[mount/]<key>  a Vault transit key, used through the vault CLI
+
+Rotating the master key only rewraps the data keys; the files themselves are
+not re-encrypted. Certificates and keys in live/ stay readable by web
+servers; use a --key-sink to keep certificate keys off disk.
+
+With a passphrase or a key file kept elsewhere, a stolen copy of the store
+does not give away the ACME accounts: their keys cannot be opened without
+it. When no passphrase is set and trusttls runs at a terminal, it asks for
+one when a sealed file is first needed.
+
+Example:
+  trusttls encryption enable --master keyring
+  trusttls encryption enable --master passphrase
+  trusttls encryption enable --master keyfile:/media/usb/trusttls.key
+  trusttls encryption status
+  trusttls encryption rotate --master aws-kms:alias/trusttls
+  TRUSTTLS_NEW_PASSPHRASE=... trusttls encryption rotate --master passphrase
+  trusttls encryption disable
+`,
+}
+
+var encryptionEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Encrypt the secrets in the store with a master key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("master")
+		base := store.DefaultBaseDir()
+		cfg, err := seal.Load(base)
+		if err != nil {
+			return err
+		}
+		m, err := seal.MasterFor(spec)
+		if err != nil {
+			return err
+		}
+		// Enabling again with the same master key seals files left plain
+		if cfg.Master != "" && cfg.Master != m.String() {
+			return fmt.Errorf("encryption is already on with %s; use trusttls encryption rotate to change the master key", cfg.Master)
+		}
+		if m.String() == "passphrase" && !seal.PassphraseSet() && seal.CanPrompt() {
+			pass, err := seal.ReadNewPassphrase()
+			if err != nil {
+				return err
+			}
+			m = seal.Passphrase(pass)
+		}
+		if err := createKeyfile(base, m); err != nil {
+			return err
+		}
+		if err := seal.Check(m); err != nil {
+			return err
+		}
+		n, err := convertSecrets(base, m)
+		if err != nil {
+			return err
+		}
+		if err := seal.Save(base, seal.Config{Master: m.String()}); err != nil {
+			return err
+		}
+		fmt.Printf("🔒 Encryption on with %s: %d file(s) sealed\n", m, n)
+		printMasterHint(m)
+		return nil
+	},
+}
+
+var encryptionRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rewrap every data key with a new master key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, _ := cmd.Flags().GetString("master")
+		base := store.DefaultBaseDir()
+		cfg, err := seal.Load(base)
+		if err != nil {
+			return err
+		}
+		if cfg.Master == "" {
+			return fmt.Errorf("encryption is off; turn it on with trusttls encryption enable")
+		}
+		m, err := seal.MasterFor(spec)
+		if err != nil {
+			return err
+		}
+		if m.String() == "passphrase" && os.Getenv(seal.NewPassphraseEnv) != "" {
+			m = seal.Passphrase(os.Getenv(seal.NewPassphraseEnv))
+		} else if m.String() == "passphrase" && seal.CanPrompt() && (cfg.Master == "passphrase" || !seal.PassphraseSet()) {
+			pass, err := seal.ReadNewPassphrase()
+			if err != nil {
+				return err
+			}
+			m = seal.Passphrase(pass)
+		} else if m.String() == cfg.Master {
+			if cfg.Master == "passphrase" {
+				return fmt.Errorf("set %s to the new passphrase, and %s to the current one", seal.NewPassphraseEnv, seal.PassphraseEnv)
+			}
+			return fmt.Errorf("%s is the master key already", cfg.Master)
+		}
+		if err := createKeyfile(base, m); err != nil {
+			return err
+		}
+		if err := seal.Check(m); err != nil {
+			return err
+		}
+		n, err := convertSecrets(base, m)
+		if err != nil {
+			return err
+		}
+		if err := seal.Save(base, seal.Config{Master: m.String()}); err != nil {
+			return err
+		}
+		fmt.Printf("🔑 Master key rotated from %s to %s: %d data key(s) rewrapped\n", cfg.Master, m, n)
+		switch {
+		case cfg.Master == "passphrase" && m.String() == "passphrase":
+			fmt.Printf("💡 Set %s, %s or the systemd credential %s to the new passphrase from now on\n", seal.PassphraseEnv, seal.PassphraseFileEnv, seal.PassphraseCredential)
+		case m.String() == "passphrase" || seal.KeyfilePath(m) != "":
+			printMasterHint(m)
+		default:
+			fmt.Printf("💡 Keep %s until no backup sealed with it is needed\n", cfg.Master)
+		}
+		return nil
+	},
+}
+
+var encryptionDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Decrypt the secrets in the store and stop encrypting",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		n, err := convertSecrets(base, nil)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(seal.ConfigPath(base)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Printf("🔓 Encryption off: %d file(s) decrypted\n", n)
+		return nil
+	},
+}
+
+var encryptionStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the master key and which secrets are encrypted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		cfg, err := seal.Load(base)
+		if err != nil {
+			return err
+		}
+		if cfg.Master == "" {
+			fmt.Println("🔓 Encryption: off")
+		} else {
+			fmt.Printf("🔒 Encryption: on, master key %s\n", cfg.Master)
+		}
+		files, err := store.SecretFiles(base)
+		if err != nil {
+			return err
+		}
+		plain := 0
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, f)
+			if err != nil {
+				rel = f
+			}
+			switch master := seal.MasterOf(b); {
+			case master == "":
+				plain++
+				if cfg.Master != "" {
+					fmt.Printf("   ⚠️  %s is not encrypted\n", rel)
+				}
+			case master != cfg.Master:
+				fmt.Printf("   ⚠️  %s is sealed with %s\n", rel, master)
+			}
+		}
+		fmt.Printf("%d secret file(s), %d encrypted\n", len(files), len(files)-plain)
+		if cfg.Master != "" && plain > 0 {
+			fmt.Printf("💡 Encrypt the rest with: trusttls encryption enable --master %s\n", cfg.Master)
+		}
+		return nil
+	},
+}
+
+// createKeyfile creates the key file of m when it is missing. A key file
+// inside the store is refused: a copy of the store would carry the key that
+// opens it.
+func createKeyfile(base string, m seal.Master) error {
+	path := seal.KeyfilePath(m)
+	if path == "" {
+		return nil
+	}
+	if rel, err := filepath.Rel(base, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("the key file %s is inside the store; keep it elsewhere, or anyone with a copy of the store can open it", path)
+	}
+	created, err := seal.CreateKeyfile(m)
+	if created {
+		fmt.Printf("🔑 Created the key file %s\n", path)
+	}
+	return err
+}
+
+// printMasterHint says what unattended renewals need to open the store
+// sealed with m.
+func printMasterHint(m seal.Master) {
+	switch {
+	case m.String() == "passphrase":
+		fmt.Printf("💡 Renewals need %s, %s or the systemd credential %s from now on\n", seal.PassphraseEnv, seal.PassphraseFileEnv, seal.PassphraseCredential)
+	case seal.KeyfilePath(m) != "":
+		fmt.Printf("💡 Renewals need %s from now on; keep a copy of it apart from your backups of the store\n", seal.KeyfilePath(m))
+	}
+}
+
+// convertSecrets seals the secret files of base with m, rewrapping those
+// sealed with another master key, or decrypts them when m is nil. It returns
+// how many files changed.
+func convertSecrets(base string, m seal.Master) (int, error) {
+	files, err := store.SecretFiles(base)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range files {
+		changed, err := seal.Convert(f, m)
+		if err != nil {
+			return n, err
+		}
+		if changed {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func init() {
+	rootCmd.AddCommand(encryptionCmd)
+	encryptionCmd.AddCommand(encryptionEnableCmd, encryptionStatusCmd, encryptionRotateCmd, encryptionDisableCmd)
+	encryptionEnableCmd.Flags().String("master", "", "Master key: passphrase, keyfile[:path], keyring[:name], aws-kms:<key-id> or vault-transit:[mount/]<key>")
+	encryptionRotateCmd.Flags().String("master", "", "New master key, as for enable")
+	encryptionEnableCmd.MarkFlagRequired("master")
+	encryptionRotateCmd.MarkFlagRequired("master")
+}
@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/enroll"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var enrollServerCmd = &cobra.Command{
+	Use:   "enroll-server",
+	Short: "Serve SCEP and EST so appliances can get certificates from the internal CA",
+	Long: `
+Run a certificate enrollment service backed by an internal CA kept in the
+store. Printers, network gear and MDM-managed devices that only speak SCEP or
+EST can then get certificates from the same tool that manages your web
+certificates.
+
+SCEP (RFC 8894) is served at /scep (and /cgi-bin/pkiclient.exe). Devices
+authenticate with the challenge password in their certificate request, or by
+signing a renewal with the certificate this CA issued for the same subject
+and names.
+
+EST (RFC 7030) is served under /.well-known/est/ over HTTPS. simpleenroll
+requires HTTP basic auth; simplereenroll requires the client certificate being
+renewed, and the request must keep its subject and names.
+
+Example:
+  trusttls enroll-server --hostname pki.example.com --scep-challenge s3cret
+  trusttls enroll-server --hostname pki.example.com --est-user est --http-listen :8080
+
+Secrets can be passed as TRUSTTLS_SCEP_CHALLENGE and TRUSTTLS_EST_PASSWORD
+instead of flags.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listen, _ := cmd.Flags().GetString("listen")
+		httpListen, _ := cmd.Flags().GetString("http-listen")
+		hostname, _ := cmd.Flags().GetString("hostname")
+		caName, _ := cmd.Flags().GetString("ca")
+		lifetime, _ := cmd.Flags().GetDuration("lifetime")
+		enableSCEP, _ := cmd.Flags().GetBool("scep")
+		enableEST, _ := cmd.Flags().GetBool("est")
+		challenge, _ := cmd.Flags().GetString("scep-challenge")
+		estUser, _ := cmd.Flags().GetString("est-user")
+		estPassword, _ := cmd.Flags().GetString("est-password")
+		if challenge == "" {
+			challenge = os.Getenv("TRUSTTLS_SCEP_CHALLENGE")
+		}
+		if estPassword == "" {
+			estPassword = os.Getenv("TRUSTTLS_EST_PASSWORD")
+		}
+
+		if hostname == "" {
+			return fmt.Errorf("--hostname is required (used for the server's TLS certificate)")
+		}
+		if !enableSCEP && !enableEST {
+			return fmt.Errorf("nothing to serve: both --scep and --est are disabled")
+		}
+		if enableSCEP && challenge == "" {
+			return fmt.Errorf("SCEP needs --scep-challenge (or TRUSTTLS_SCEP_CHALLENGE); use --scep=false to serve EST only")
+		}
+		if estUser != "" && estPassword == "" {
+			return fmt.Errorf("--est-user needs --est-password (or TRUSTTLS_EST_PASSWORD)")
+		}
+		if lifetime <= 0 {
+			return fmt.Errorf("--lifetime must be positive")
+		}
+
+		storeDir := store.DefaultBaseDir()
+		authority, err := ca.LoadOrCreate(storeDir, caName)
+		if err != nil {
+			return fmt.Errorf("load %s CA: %w", caName, err)
+		}
+		srv := &enroll.Server{
+			Issuer:        enroll.AuthorityIssuer{Authority: authority, Lifetime: lifetime},
+			SCEPChallenge: challenge,
+			ESTUser:       estUser,
+			ESTPassword:   estPassword,
+			EnableSCEP:    enableSCEP,
+			EnableEST:     enableEST,
+			Log:           func(line string) { log.Println(line) },
+		}
+		if enableSCEP {
+			if srv.RACert, srv.RAKey, err = authority.SCEPRA(); err != nil {
+				return fmt.Errorf("load SCEP RA: %w", err)
+			}
+		}
+		handler, err := srv.Handler()
+		if err != nil {
+			return err
+		}
+
+		// The server's own certificate comes from the same CA, so devices
+		// that trust it for enrollment also trust the endpoint.
+		leaf, err := authority.Issue(ca.LeafRequest{Domains: []string{hostname}, Lifetime: 90 * 24 * time.Hour})
+		if err != nil {
+			return err
+		}
+		tlsCert, err := tls.X509KeyPair(append(leaf.Certificate, leaf.IssuerCertificate...), leaf.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🏛️  CA certificate: %s\n", filepath.Join(authority.Dir, "ca.pem"))
+		if enableSCEP {
+			fmt.Printf("📟 SCEP: https://%s%s/scep\n", hostname, portSuffix(listen))
+			if httpListen != "" {
+				fmt.Printf("📟 SCEP (plain HTTP): http://%s%s/scep\n", hostname, portSuffix(httpListen))
+			}
+		}
+		if enableEST {
+			fmt.Printf("🔐 EST:  https://%s%s/.well-known/est/\n", hostname, portSuffix(listen))
+		}
+		fmt.Printf("⏳ Certificates are valid for %s\n", lifetime)
+
+		errc := make(chan error, 2)
+		if httpListen != "" && enableSCEP {
+			// Many SCEP clients only speak plain HTTP; SCEP messages are
+			// signed and encrypted, so EST is never offered on this listener.
+			plain := &enroll.Server{
+				Issuer:        srv.Issuer,
+				RACert:        srv.RACert,
+				RAKey:         srv.RAKey,
+				SCEPChallenge: srv.SCEPChallenge,
+				EnableSCEP:    true,
+				Log:           srv.Log,
+			}
+			plainHandler, err := plain.Handler()
+			if err != nil {
+				return err
+			}
+			go func() {
+				errc <- (&http.Server{Addr: httpListen, Handler: plainHandler, ReadHeaderTimeout: 10 * time.Second}).ListenAndServe()
+			}()
+		}
+		go func() {
+			hs := &http.Server{Addr: listen, Handler: handler, TLSConfig: srv.TLSConfig(tlsCert), ReadHeaderTimeout: 10 * time.Second}
+			errc <- hs.ListenAndServeTLS("", "")
+		}()
+		return <-errc
+	},
+}
+
+func portSuffix(addr string) string {
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			if p := addr[i+1:]; p != "" && p != "443" && p != "80" {
+				return ":" + p
+			}
+			break
+		}
+	}
+	return ""
+}
+
+func init() {
+	rootCmd.AddCommand(enrollServerCmd)
+	enrollServerCmd.Flags().String("listen", ":8443", "HTTPS listen address for SCEP and EST")
+	enrollServerCmd.Flags().String("http-listen", "", "Optional plain HTTP listen address for SCEP clients that cannot use HTTPS")
+	enrollServerCmd.Flags().String("hostname", "", "Host name devices use to reach this server")
+	enrollServerCmd.Flags().String("ca", ca.DevicesCA, "Internal CA to issue from (created on first use)")
+	enrollServerCmd.Flags().Duration("lifetime", 365*24*time.Hour, "Validity of issued device certificates")
+	enrollServerCmd.Flags().Bool("scep", true, "Serve SCEP")
+	enrollServerCmd.Flags().Bool("est", true, "Serve EST")
+	enrollServerCmd.Flags().String("scep-challenge", "", "Challenge password devices must include in SCEP requests")
+	enrollServerCmd.Flags().String("est-user", "", "User name for EST basic auth")
+	enrollServerCmd.Flags().String("est-password", "", "Password for EST basic auth")
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// expandNames checks domains against the certificates already in the store,
+// so adding a name to a site replaces its certificate instead of creating a
+// second one that covers some of the same names. When another certificate
+// carries one of domains, the names to order become its names, primary
+// first, followed by the new ones, and its lineage name is returned for the
+// certificate to be stored under. With expand that happens right away;
+// interactively the user is asked, and otherwise it is an error.
+//
+// certName is the --cert-name the user chose, if any. Naming a lineage
+// means overlapping certificates are wanted, so only that lineage is
+// checked. The returned name is empty when the lineage is named after the
+// primary domain.
+func expandNames(storeDir, certName string, domains []string, expand, interactive bool) (string, []string, error) {
+	all, err := store.ListLineages(storeDir)
+	if err != nil {
+		return "", nil, err
+	}
+	name := certName
+	if name == "" {
+		name = domains[0]
+	}
+	candidates := store.Overlapping(all, domains)
+	for _, l := range all {
+		// A lineage reusing the name is replaced even when it shares no names
+		if strings.EqualFold(l.Name, name) && len(store.Overlapping([]store.Lineage{l}, domains)) == 0 {
+			candidates = append(candidates, l)
+		}
+	}
+	var others []store.Lineage
+	for _, l := range candidates {
+		if !strings.EqualFold(l.Name, name) {
+			if certName == "" {
+				others = append(others, l)
+			}
+			continue
+		}
+		// Same lineage: the new certificate replaces it anyway
+		existing := lineageNames(l)
+		added := newNames(existing, domains)
+		if dropped := newNames(domains, existing); len(dropped) > 0 {
+			if expand {
+				domains = append(append([]string{}, existing...), added...)
+			} else {
+				fmt.Printf("⚠️  The new certificate for %s leaves out %s; pass --expand to keep them\n", l.Name, strings.Join(dropped, ", "))
+			}
+		}
+		if len(added) > 0 {
+			fmt.Printf("➕ Adding %s to the certificate for %s\n", strings.Join(added, ", "), l.Name)
+		}
+	}
+	switch len(others) {
+	case 0:
+		return certName, domains, nil
+	case 1:
+	default:
+		var names []string
+		for _, l := range others {
+			names = append(names, l.Name)
+		}
+		return "", nil, fmt.Errorf("%s share names with several certificates (%s); add the names to one of them with --cert-name <its name> --expand, or pick a new --cert-name to keep them apart",
+			strings.Join(domains, ", "), strings.Join(names, ", "))
+	}
+
+	l := others[0]
+	existing := lineageNames(l)
+	added := newNames(existing, domains)
+	merged := append(append([]string{}, existing...), added...)
+	if !expand {
+		question := fmt.Sprintf("%s already has a certificate covering %s. Expand it to %s instead of issuing a second one?",
+			l.Name, strings.Join(shared(existing, domains), ", "), strings.Join(merged, ", "))
+		if !interactive {
+			return "", nil, fmt.Errorf("the certificate for %s already covers %s; pass --expand to reissue it for %s, or --cert-name to keep a separate certificate",
+				l.Name, strings.Join(shared(existing, domains), ", "), strings.Join(merged, ", "))
+		}
+		if !NewUI(false).AskYesNo(question) {
+			fmt.Printf("ℹ️  Issuing a separate certificate for %s\n", strings.Join(domains, ", "))
+			return certName, domains, nil
+		}
+	}
+	if len(added) == 0 {
+		fmt.Printf("♻️  Reissuing the certificate for %s, which already covers %s\n", l.Name, strings.Join(domains, ", "))
+	} else {
+		fmt.Printf("➕ Expanding the certificate for %s with %s\n", l.Name, strings.Join(added, ", "))
+	}
+	if strings.EqualFold(l.Name, merged[0]) {
+		return "", merged, nil
+	}
+	return l.Name, merged, nil
+}
+
+// lineageNames returns the names of l, primary first, in the order of its
+// renewal config when it has one.
+func lineageNames(l store.Lineage) []string {
+	if c, err := renewal.Load(l.Name); err == nil {
+		return c.Names()
+	}
+	if !containsFold(l.Names, l.Name) {
+		return l.Names // a named lineage
+	}
+	names := []string{l.Name}
+	for _, n := range l.Names {
+		if !strings.EqualFold(n, l.Name) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// newNames returns the names in want that have is missing.
+func newNames(have, want []string) []string {
+	var out []string
+	for _, n := range want {
+		if !containsFold(have, n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// shared returns the names in want that have carries too.
+func shared(have, want []string) []string {
+	var out []string
+	for _, n := range want {
+		if containsFold(have, n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/export"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write a certificate in another format, such as PKCS#12 or DER",
+	Long: `
+Write a managed certificate in a format for consumers that cannot use the
+files in ~/.trusttls/live/:
+
+  p12      PKCS#12 (.p12/.pfx) bundle of key, certificate and chain, the
+           format Java keystores, Windows and many appliances import
+  der      directory with cert.der, chain.der (chain2.der, ... for longer
+           chains) and privkey.der (PKCS#8)
+  pem      directory with plain copies of cert.pem, chain.pem, fullchain.pem
+           and privkey.pem, named like certbot's, for consumers that cannot
+           follow the symlinks in live/ or need their own copy
+  haproxy  one PEM file with the full chain and the key, as HAProxy's crt
+           loads it
+
+A p12 bundle is protected by --password, --password-file or
+TRUSTTLS_EXPORT_PASSWORD. It uses AES-256; --legacy writes the 3DES bundle
+Java before 8u301 and Windows Server 2016 need. der and pem leave the key out
+when the store does not hold it (--csr, key sinks).
+
+With --on-renewal the export is also added to the certificate's renewal
+config and written again after every renewal, so each lineage can have the
+formats its consumers need. A password given directly is then kept in
+~/.trusttls/exports/, sealed when encryption is on.
+
+Example:
+  trusttls export --domain example.com --format p12 --password-file /etc/tomcat/p12.pass
+  trusttls export --domain example.com --out /etc/tomcat/example.p12 --on-renewal --password-file /etc/tomcat/p12.pass
+  TRUSTTLS_EXPORT_PASSWORD=changeit trusttls export --domain example.com --legacy
+  trusttls export --domain example.com --format der --out /opt/appliance/certs
+  trusttls export --domain example.com --format haproxy --out /etc/haproxy/certs/example.pem --on-renewal
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		format, _ := cmd.Flags().GetString("format")
+		out, _ := cmd.Flags().GetString("out")
+		password, _ := cmd.Flags().GetString("password")
+		passwordFile, _ := cmd.Flags().GetString("password-file")
+		legacy, _ := cmd.Flags().GetBool("legacy")
+		onRenewal, _ := cmd.Flags().GetBool("on-renewal")
+		if domain == "" {
+			return fmt.Errorf("--domain is required")
+		}
+		if err := export.CheckFormat(format); err != nil {
+			return err
+		}
+		c, err := renewal.Find(domain)
+		if err != nil {
+			return fmt.Errorf("no renewal config for %s: %w", domain, err)
+		}
+		if out == "" {
+			out = export.DefaultPath(store.LineageName(c.Lineage()), format)
+		}
+		if out, err = filepath.Abs(out); err != nil {
+			return err
+		}
+		if password == "" {
+			password = os.Getenv("TRUSTTLS_EXPORT_PASSWORD")
+		}
+		switch {
+		case !export.NeedsPassword(format):
+			if passwordFile != "" || legacy {
+				return fmt.Errorf("--password-file and --legacy apply to p12 exports only")
+			}
+			password = ""
+		case password != "" && passwordFile != "":
+			return fmt.Errorf("use either --password or --password-file")
+		case passwordFile != "":
+			if password, err = renewal.ReadExportPassword(passwordFile); err != nil {
+				return err
+			}
+		case password == "":
+			return fmt.Errorf("a %s bundle needs --password, --password-file or TRUSTTLS_EXPORT_PASSWORD", format)
+		}
+
+		e := renewal.ExportConfig{Format: format, Path: out, Legacy: legacy}
+		if err := renewal.Export(c, e, password); err != nil {
+			return err
+		}
+		fmt.Printf("📦 Wrote %s as %s to %s\n", c.Lineage(), format, out)
+		if !onRenewal {
+			return nil
+		}
+
+		switch {
+		case !export.NeedsPassword(format):
+		case passwordFile != "":
+			e.PasswordFile, _ = filepath.Abs(passwordFile)
+		default:
+			if e.PasswordFile, err = renewal.SaveExportPassword(c, out, password); err != nil {
+				return err
+			}
+		}
+		kept := c.Exports[:0]
+		for _, x := range c.Exports {
+			if x.Path != out {
+				kept = append(kept, x)
+			}
+		}
+		c.Exports = append(kept, e)
+		if err := renewal.Save(c); err != nil {
+			return err
+		}
+		fmt.Printf("🔁 Renewals of %s write it again\n", c.Lineage())
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("domain", "", "Certificate to export")
+	exportCmd.Flags().String("format", export.FormatP12, "Export format: "+strings.Join(export.Formats, ", "))
+	exportCmd.Flags().String("out", "", "File to write, or directory for der and pem (default: named after the domain, in the current directory)")
+	exportCmd.Flags().String("password", "", "Password protecting a p12 bundle (or TRUSTTLS_EXPORT_PASSWORD)")
+	exportCmd.Flags().String("password-file", "", "File holding the password protecting a p12 bundle")
+	exportCmd.Flags().Bool("legacy", false, "Use the 3DES/SHA-1 encryption older Java and Windows versions need")
+	exportCmd.Flags().Bool("on-renewal", false, "Also write the export after every renewal")
+}
@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/importer"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Bring certificates from another ACME client under TrustTLS",
+	Long: `
+Copy certificates, keys and renewal settings from another client into the
+TrustTLS store, so switching does not mean reissuing every certificate.
+
+Example:
+  sudo trusttls import certbot
+  trusttls import acmesh
+  sudo trusttls import cert --domain shop.example.com --cert shop.crt --key shop.key
+`,
+}
+
+var importCertbotCmd = &cobra.Command{
+	Use:   "certbot",
+	Short: "Import certbot's certificates and renewal settings",
+	Long: `
+Import the lineages certbot keeps in --path (/etc/letsencrypt):
+
+• Every version in archive/ becomes a version in the store, and the one
+  certbot's live/ links to stays current, so 'trusttls rollback' can go back
+  to earlier ones
+• renewal/<name>.conf becomes a renewal config: CA, key type and size,
+  webroot, standalone, nginx or apache validation, DNS plugin (with its
+  credentials, as 'trusttls dns import' would), deploy and post hooks,
+  must-staple and reuse-key
+• The ACME account each certificate was issued with is reused, so rate
+  limits and CAA accounturi records keep applying to the same account
+• Apache and Nginx configs using certbot's live/ directory are pointed at
+  the store and reloaded, unless --keep-paths is given
+
+Certificates already in the store are skipped. Settings without a trusttls
+equivalent, such as pre_hook, are reported. certbot keeps renewing its own
+copies until its timer or cron job is disabled.
+
+Example:
+  sudo trusttls import certbot
+  sudo trusttls import certbot --cert-name example.com
+  trusttls import certbot --path ~/letsencrypt/config --email admin@example.com --keep-paths
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("path")
+		name, _ := cmd.Flags().GetString("cert-name")
+		email, _ := cmd.Flags().GetString("email")
+		keepPaths, _ := cmd.Flags().GetBool("keep-paths")
+		found, err := importer.Certbot(dir, name, email)
+		if err != nil {
+			return err
+		}
+		if err := importLineages(found, keepPaths); err != nil {
+			return err
+		}
+		fmt.Printf("\n💡 Stop certbot renewing them too: sudo systemctl disable --now certbot.timer (or remove its cron job)\n")
+		return nil
+	},
+}
+
+var importAcmeshCmd = &cobra.Command{
+	Use:   "acmesh",
+	Short: "Import acme.sh's certificates and renewal settings",
+	Long: `
+Import the certificates acme.sh keeps in --path (~/.acme.sh):
+
+• Each domain directory (and its _ecc twin) becomes a lineage in the store
+• <domain>.conf becomes a renewal config: CA, key length, webroot,
+  standalone, nginx or apache validation, DNS API (with the credentials
+  acme.sh saved in account.conf), renew and post hooks, OCSP must-staple
+• install-cert destinations (--cert-file, --key-file, --ca-file,
+  --fullchain-file) become deploy copies, rewritten after every renewal,
+  followed by the --reloadcmd
+• The ACME account acme.sh registered is reused
+
+Certificates already in the store are skipped. Settings without a trusttls
+equivalent, such as the pre-hook, are reported. acme.sh keeps renewing its
+own copies until its cron job is removed.
+
+Example:
+  trusttls import acmesh
+  sudo trusttls import acmesh --path /root/.acme.sh --domain example.com
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("path")
+		domain, _ := cmd.Flags().GetString("domain")
+		email, _ := cmd.Flags().GetString("email")
+		found, err := importer.Acmesh(dir, domain, email)
+		if err != nil {
+			return err
+		}
+		if err := importLineages(found, false); err != nil {
+			return err
+		}
+		fmt.Printf("\n💡 Stop acme.sh renewing them too: acme.sh --uninstall-cronjob\n")
+		return nil
+	},
+}
+
+var importCertCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage a certificate issued outside ACME",
+	Long: `
+Bring a certificate bought from a CA or issued by hand into the store, so
+it is listed, watched by check-expiry and notifications, installed and
+copied to daemons like the ones trusttls issues. trusttls cannot renew it:
+once it is due, every renew run fails for it with a reminder, until its
+replacement is imported the same way. Importing again for a certificate
+imported before stores the new one as its next version and runs its
+deploy copies and hooks, as a renewal would.
+
+--cert may hold the chain after the certificate; otherwise pass --chain.
+
+Example:
+  sudo trusttls import cert --domain shop.example.com --cert shop.crt --key shop.key --chain bundle.crt
+  sudo trusttls import cert --domain shop.example.com --cert shop.crt --key shop.key --target nginx
+  sudo trusttls import cert --cert-name intranet --cert intranet-fullchain.pem --key intranet.key --deploy-hook 'systemctl reload haproxy'
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		certName, _ := cmd.Flags().GetString("cert-name")
+		certPath, _ := cmd.Flags().GetString("cert")
+		keyPath, _ := cmd.Flags().GetString("key")
+		chainPath, _ := cmd.Flags().GetString("chain")
+		target, _ := cmd.Flags().GetString("target")
+		assumeYes, _ := cmd.Flags().GetBool("yes")
+		deployHook, _ := cmd.Flags().GetString("deploy-hook")
+		postHook, _ := cmd.Flags().GetString("post-hook")
+		if certPath == "" || keyPath == "" {
+			return fmt.Errorf("--cert and --key are required")
+		}
+		if certName != "" {
+			if err := store.ValidCertName(certName); err != nil {
+				return err
+			}
+		}
+		access, err := accessFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		// A certificate imported before gets the new one as its next version
+		var existing renewal.Config
+		switch {
+		case certName != "":
+			existing, err = renewal.Load(certName)
+		case domain != "":
+			existing, err = renewal.Find(domain)
+		default:
+			err = os.ErrNotExist
+		}
+		if err == nil {
+			if domain == "" {
+				domain = existing.Domain
+			}
+			l, err := importer.Cert(domain, existing.Lineage(), certPath, keyPath, chainPath)
+			if err != nil {
+				return err
+			}
+			c, err := importer.Replace(existing, l)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("✅ %s: replaced with the certificate from %s\n", c.Lineage(), certPath)
+			for _, n := range l.Notes {
+				fmt.Printf("   ⚠️  %s\n", n)
+			}
+			return renewal.RunHooks(c, true)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		l, err := importer.Cert(domain, certName, certPath, keyPath, chainPath)
+		if err != nil {
+			return err
+		}
+		l.Config.DeployHook, l.Config.PostHook = deployHook, postHook
+		l.Config.CertOwner, l.Config.CertGroup, l.Config.KeyMode = access.CertOwner, access.CertGroup, access.KeyMode
+		if target != "" {
+			l.Config.Targets = []string{target}
+		}
+		base := store.DefaultBaseDir()
+		if err := importer.Save(base, l); err != nil {
+			return err
+		}
+		if err := renewal.ApplyAccess(l.Config); err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s: imported from %s\n", l.Name, certPath)
+		for _, n := range l.Notes {
+			fmt.Printf("   ⚠️  %s\n", n)
+		}
+		if target != "" {
+			if err := installImported(l.Config, target, assumeYes); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("\n💡 trusttls cannot renew it: once it is due, import its replacement the same way\n")
+		return nil
+	},
+}
+
+// installImported writes the SSL vhost of the imported certificate c for
+// target (apache or nginx).
+func installImported(c renewal.Config, target string, assumeYes bool) error {
+	var installer Installer
+	switch target {
+	case "apache":
+		if !apache.Available() {
+			return fmt.Errorf("apache web server not found")
+		}
+		installer = apache.NewInstaller(c.BaseDir, assumeYes)
+	case "nginx":
+		if !nginx.Available() {
+			return fmt.Errorf("nginx web server not found")
+		}
+		installer = nginx.NewInstaller(c.BaseDir, assumeYes)
+	default:
+		return fmt.Errorf("unknown target %q: use apache or nginx", target)
+	}
+	names := c.Names()
+	if err := installer.Install(c.Lineage(), c.Domain, names[1:]...); err != nil {
+		return fmt.Errorf("install certificate: %w", err)
+	}
+	_ = renewal.RecordDeployment(c.BaseDir, c.Lineage(), renewal.Deployment{
+		Kind: target, Target: installer.ConfigFile(c.Domain), Detail: "SSL site", Source: renewal.SourceInstall,
+	})
+	fmt.Printf("🔒 Installed in %s\n", installer.ConfigFile(c.Domain))
+	return nil
+}
+
+// importLineages saves lineages read by an importer and reports each one.
+// Web server configs using their old files are switched to the store
+// unless keepPaths is set.
+func importLineages(found []*importer.Lineage, keepPaths bool) error {
+	base := store.DefaultBaseDir()
+	imported, failed := 0, 0
+	for _, l := range found {
+		err := importer.Save(base, l)
+		if errors.Is(err, importer.ErrExists) {
+			fmt.Printf("⏭️  %s: already managed by TrustTLS\n", l.Name)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("⚠️  %s (%s): %v\n", l.Name, l.Source, err)
+			failed++
+			continue
+		}
+		imported++
+		fmt.Printf("✅ %s: imported %d version(s) from %s\n", l.Name, len(l.Versions), l.Source)
+		if l.Account != nil {
+			fmt.Printf("   🔑 ACME account %s (%s)\n", l.Account.URI, l.Account.Email)
+		}
+		if l.DNS != nil {
+			fmt.Printf("   🌐 %s credentials in %s\n", l.DNS.Provider, l.Config.DNSCredentials)
+		}
+		for _, n := range l.Notes {
+			fmt.Printf("   ⚠️  %s\n", n)
+		}
+		if l.Paths != "" && !keepPaths {
+			renewal.SwitchCertificatePaths(l.Paths, filepath.Join(base, "live", store.LineageName(l.Name)))
+		}
+	}
+	if imported > 0 {
+		fmt.Printf("\n📋 Check the renewal configs with: trusttls config lint\n")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d certificate(s) not imported", failed)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importCertbotCmd)
+	importCertbotCmd.Flags().String("path", importer.DefaultCertbotDir, "certbot's configuration directory")
+	importCertbotCmd.Flags().String("cert-name", "", "Import only the certificate certbot keeps under this name")
+	importCertbotCmd.Flags().String("email", "", "Email of ACME accounts registered without one")
+	importCertbotCmd.Flags().Bool("keep-paths", false, "Leave Apache and Nginx configs pointing at certbot's files")
+	importCmd.AddCommand(importAcmeshCmd)
+	importAcmeshCmd.Flags().String("path", importer.DefaultAcmeshDir(), "acme.sh's home directory")
+	importAcmeshCmd.Flags().String("domain", "", "Import only this domain's certificate")
+	importAcmeshCmd.Flags().String("email", "", "Email of ACME accounts registered without one")
+	importCmd.AddCommand(importCertCmd)
+	importCertCmd.Flags().String("domain", "", "Primary name of the certificate (default: its first name)")
+	importCertCmd.Flags().String("cert-name", "", "Name to keep the certificate under (live/<name>/) instead of its domain")
+	importCertCmd.Flags().String("cert", "", "PEM certificate, optionally followed by its chain")
+	importCertCmd.Flags().String("key", "", "PEM private key of the certificate")
+	importCertCmd.Flags().String("chain", "", "PEM intermediate certificates, when --cert holds only the certificate")
+	importCertCmd.Flags().String("target", "", "Install the certificate in apache or nginx")
+	importCertCmd.Flags().Bool("yes", false, "Assume yes when prompting to modify vhost files")
+	importCertCmd.Flags().String("deploy-hook", "", "Shell command to run after each replacement is imported (e.g. 'systemctl reload haproxy')")
+	importCertCmd.Flags().String("post-hook", "", "Shell command to run after the deploy hook when a replacement is imported")
+	addAccessFlags(importCertCmd)
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info <host>[:port]",
+	Short: "Show which managed certificate covers a host and what the server presents",
+	Long: `
+Find the managed certificate that covers a host name and, when a port is
+given, connect to the server to compare what it actually presents.
+
+The host does not need to match a certificate's folder name: wildcard
+certificates and certificates listing the host among other names are found
+too.
+
+Example:
+  trusttls info www.example.com
+  trusttls info www.example.com:443
+  trusttls info mail.example.com:993
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host, port := args[0], ""
+		if h, p, err := net.SplitHostPort(args[0]); err == nil {
+			host, port = h, p
+		}
+
+		lineage, match, lookupErr := store.FindLineage(store.DefaultBaseDir(), host)
+		if lookupErr != nil && port == "" {
+			return lookupErr
+		}
+		if lookupErr != nil {
+			fmt.Printf("ℹ️  %v\n", lookupErr)
+		} else {
+			fmt.Printf("📜 Managed certificate: %s (%s match)\n", lineage.Name, match)
+			fmt.Printf("   Names:   %s\n", strings.Join(lineage.Names, ", "))
+			fmt.Printf("   Expires: %s\n", describeExpiry(lineage.NotAfter))
+			fmt.Printf("   Files:   %s\n", lineage.Dir)
+		}
+		if port == "" {
+			return nil
+		}
+
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		chain, err := renewal.ServedChain(host, port, timeout)
+		if err != nil {
+			return fmt.Errorf("connect to %s: %w", net.JoinHostPort(host, port), err)
+		}
+		served := chain[0]
+		inter := x509.NewCertPool()
+		for _, c := range chain[1:] {
+			inter.AddCert(c)
+		}
+		_, verifyErr := served.Verify(x509.VerifyOptions{DNSName: host, Intermediates: inter})
+		fmt.Printf("\n🌐 Served by %s:\n", net.JoinHostPort(host, port))
+		fmt.Printf("   Subject: %s\n", served.Subject.CommonName)
+		fmt.Printf("   Names:   %s\n", strings.Join(served.DNSNames, ", "))
+		fmt.Printf("   Issuer:  %s\n", served.Issuer.String())
+		fmt.Printf("   Expires: %s\n", describeExpiry(served.NotAfter))
+		if verifyErr != nil {
+			fmt.Printf("   ⚠️  Not trusted: %v\n", verifyErr)
+		} else {
+			fmt.Printf("   ✅ Trusted chain for %s\n", host)
+		}
+		if lookupErr == nil {
+			if fmt.Sprintf("%x", served.SerialNumber) == lineage.Serial {
+				fmt.Printf("\n✅ The server presents the current managed certificate\n")
+			} else {
+				fmt.Printf("\n⚠️  The server presents a different certificate than %s\n", lineage.Dir)
+				fmt.Printf("💡 Reload the web server, or check it points at %s\n", lineage.Fullchain())
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().Duration("timeout", 10*time.Second, "Connection timeout")
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:     "inspect <domain>",
+	Aliases: []string{"describe"},
+	Short:   "Show the details of a stored certificate and check its key",
+	Long: `
+Read the certificate kept for a domain and show what is in it: the names it
+covers, serial number, SHA-256 and SHA-1 fingerprints, validity, key and
+signature algorithm, where to check its revocation (OCSP and CRL), the chain
+stored with it and the paths of its files. The private key in the store is
+checked against the certificate, so a key left over from another issuance
+shows up before a web server refuses to start with it.
+
+The domain can be a certificate name or any host name a stored certificate
+covers, as with info.
+
+--history shows how each version of the certificate was obtained instead,
+for audits: when it was stored, the issuer, the ACME CA, account and order,
+the challenge each name was validated with and when, and the serial that
+resulted. Keys, challenge tokens and credentials are never recorded.
+
+Example:
+  trusttls inspect example.com
+  trusttls inspect www.example.com
+  trusttls inspect example.com --json
+  trusttls inspect example.com --history
+  trusttls inspect example.com --history --json
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		history, _ := cmd.Flags().GetBool("history")
+		base := store.DefaultBaseDir()
+		name := args[0]
+		if _, err := store.LoadLineage(base, name); err != nil {
+			l, match, ferr := store.FindLineage(base, name)
+			if ferr != nil {
+				return ferr
+			}
+			name = l.Name
+			if !asJSON {
+				fmt.Printf("ℹ️  %s is covered by %s (%s match)\n\n", args[0], l.Name, match)
+			}
+		}
+		if history {
+			return printHistory(base, name, asJSON)
+		}
+		d, err := store.Inspect(base, name)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(d)
+		}
+		printDetails(d)
+		if d.KeyMatches != nil && !*d.KeyMatches {
+			return fmt.Errorf("the private key in %s does not belong to the certificate", d.Files.PrivKey)
+		}
+		return nil
+	},
+}
+
+func printDetails(d *store.Details) {
+	fmt.Printf("📜 %s\n", d.Name)
+	fmt.Printf("   Subject:    %s\n", d.Subject)
+	fmt.Printf("   Names:      %s\n", strings.Join(d.Names, ", "))
+	fmt.Printf("   Issuer:     %s\n", d.Issuer)
+	fmt.Printf("   Serial:     %s\n", d.Serial)
+	fmt.Printf("   Valid:      %s to %s\n", d.NotBefore.Local().Format(time.RFC1123), d.NotAfter.Local().Format(time.RFC1123))
+	fmt.Printf("   Expires:    %s\n", describeExpiry(d.NotAfter))
+	fmt.Printf("   Key:        %s\n", d.KeyAlgorithm)
+	fmt.Printf("   Signature:  %s\n", d.SignatureAlgorithm)
+	if d.MustStaple {
+		fmt.Printf("   Must-Staple: yes\n")
+	}
+	fmt.Printf("   SHA-256:    %s\n", d.SHA256)
+	fmt.Printf("   SHA-1:      %s\n", d.SHA1)
+	if d.OrderURL != "" {
+		fmt.Printf("   Order:      %s\n", d.OrderURL)
+	}
+
+	fmt.Printf("\n🔎 Revocation\n")
+	printURLs("OCSP", d.OCSP)
+	printURLs("CRL", d.CRL)
+	printURLs("CA issuers", d.CAIssuers)
+
+	fmt.Printf("\n🔗 Chain\n")
+	if len(d.Chain) == 0 {
+		fmt.Printf("   (none stored)\n")
+	}
+	for _, c := range d.Chain {
+		fmt.Printf("   %s (expires %s)\n", c.Subject, c.NotAfter.Local().Format("2006-01-02"))
+	}
+
+	fmt.Printf("\n📁 Files")
+	if d.Version > 0 {
+		fmt.Printf(" (version %d)", d.Version)
+	}
+	fmt.Println()
+	for _, f := range []struct{ label, path string }{
+		{"cert", d.Files.Cert},
+		{"chain", d.Files.Chain},
+		{"fullchain", d.Files.Fullchain},
+		{"privkey", d.Files.PrivKey},
+	} {
+		if f.path != "" {
+			fmt.Printf("   %-10s %s\n", f.label+":", f.path)
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case d.KeyError != "":
+		fmt.Printf("⚠️  Private key: %s\n", d.KeyError)
+	case d.KeyMatches == nil:
+		fmt.Printf("ℹ️  No private key in the store (ordered with --csr or --key-sink)\n")
+	case *d.KeyMatches:
+		fmt.Printf("✅ The private key matches the certificate\n")
+	default:
+		fmt.Printf("❌ The private key does not match the certificate\n")
+	}
+}
+
+func printHistory(base, name string, asJSON bool) error {
+	issuances, err := store.History(base, name)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if issuances == nil {
+			issuances = []store.Issuance{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(issuances)
+	}
+	if len(issuances) == 0 {
+		fmt.Printf("ℹ️  No issuance history recorded for %s; it is kept for certificates stored from now on\n", name)
+		return nil
+	}
+	fmt.Printf("📜 Issuance history of %s\n", name)
+	for _, i := range issuances {
+		fmt.Printf("\n   Version %d, stored %s\n", i.Version, i.Time.Local().Format(time.RFC1123))
+		if i.Provider != "" {
+			fmt.Printf("   Issuer:     %s\n", i.Provider)
+		}
+		if i.CA != "" {
+			fmt.Printf("   CA:         %s\n", i.CA)
+		}
+		if i.Account != "" {
+			fmt.Printf("   Account:    %s\n", i.Account)
+		}
+		if i.OrderURL != "" {
+			fmt.Printf("   Order:      %s\n", i.OrderURL)
+		}
+		if i.Source != "" {
+			fmt.Printf("   Imported:   %s\n", i.Source)
+		}
+		for _, v := range i.Validations {
+			switch {
+			case v.Reused:
+				fmt.Printf("   Validated:  %s (earlier validation reused)\n", v.Name)
+			case v.Validated == nil:
+				fmt.Printf("   Validated:  %s via %s\n", v.Name, v.Challenge)
+			default:
+				fmt.Printf("   Validated:  %s via %s at %s\n", v.Name, v.Challenge, v.Validated.Local().Format(time.RFC1123))
+			}
+		}
+		fmt.Printf("   Serial:     %s\n", i.Serial)
+		if !i.NotAfter.IsZero() {
+			fmt.Printf("   Expires:    %s\n", i.NotAfter.Local().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+func printURLs(label string, urls []string) {
+	if len(urls) == 0 {
+		fmt.Printf("   %-11s (none)\n", label+":")
+		return
+	}
+	for _, u := range urls {
+		fmt.Printf("   %-11s %s\n", label+":", u)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().Bool("json", false, "Print the details as JSON")
+	inspectCmd.Flags().Bool("history", false, "Show how each version was issued instead")
+}
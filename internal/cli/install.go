@@ -4,15 +4,21 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
-	"github.com/go-acme/lego/v4/certificate"
 	"github.com/spf13/cobra"
 	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/osutil"
 	"github.com/trustctl/trusttls/internal/plugins/apache"
 	"github.com/trustctl/trusttls/internal/plugins/nginx"
 	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/resolver"
 	"github.com/trustctl/trusttls/internal/store"
 )
 
@@ -32,6 +38,10 @@ Perfect for beginners and quick SSL setup!
 
 Example:
   trusttls setup --domain example.com --email admin@example.com
+  trusttls setup --domain '*.example.com' --email admin@example.com --dns cloudflare
+  trusttls setup --domain example.com,www.example.com --email admin@example.com
+  trusttls setup --domain 203.0.113.10 --email admin@example.com --web-server nginx \
+    --server https://acme.example.net/directory
 
 Supported web servers:
 • Apache 2.4+
@@ -40,12 +50,23 @@ Supported web servers:
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Pass verbose flag (you might want to get this from command line flag)
 		verbose, _ := cmd.Flags().GetBool("verbose")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		// With --json only the summary goes to stdout; progress output is
+		// moved to stderr so the result can be piped straight into jq.
+		stdout := os.Stdout
+		if asJSON {
+			os.Stdout = os.Stderr
+			defer func() { os.Stdout = stdout }()
+		}
 		ui := NewUI(verbose)
 		
-		domain, _ := cmd.Flags().GetString("domain")
+		domainFlags, _ := cmd.Flags().GetStringSlice("domain")
+		domains := parseDomains(domainFlags)
+		var domain string
+		if len(domains) > 0 { domain = domains[0] }
 		email, _ := cmd.Flags().GetString("email")
-		keyType, _ := cmd.Flags().GetString("key-type")
-		keySize, _ := cmd.Flags().GetInt("key-size")
+		keyType, keySize, err := keyFlags(cmd)
+		if err != nil { return err }
 		staging, _ := cmd.Flags().GetBool("staging")
 		server, _ := cmd.Flags().GetString("server")
 		target, _ := cmd.Flags().GetString("target")
@@ -63,6 +84,46 @@ Supported web servers:
 		digicertSecret, _ := cmd.Flags().GetString("digicert-secret")
 		accountID, _ := cmd.Flags().GetString("account-id")
 		orgID, _ := cmd.Flags().GetString("org-id")
+		profile, _ := cmd.Flags().GetString("profile")
+		eabKID, _ := cmd.Flags().GetString("eab-kid")
+		eabHMACKey, _ := cmd.Flags().GetString("eab-hmac-key")
+		
+		// DNS-01 validation flags
+		dnsPlugin, _ := cmd.Flags().GetString("dns")
+		dnsCredentials, _ := cmd.Flags().GetString("dns-credentials")
+		dnsWait, _ := cmd.Flags().GetBool("dns-wait")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+		propagationTimeout, _ := cmd.Flags().GetDuration("propagation-timeout")
+		propagationCheck, _ := cmd.Flags().GetString("propagation-check")
+		caBundle, _ := cmd.Flags().GetString("ca-bundle")
+		if caBundle == "" { caBundle, _ = cmd.Flags().GetString("acme-ca-cert") }
+		soak, _ := cmd.Flags().GetDuration("soak")
+		renewBeforeDays, _ := cmd.Flags().GetInt("renew-before-days")
+		if renewBeforeDays < 0 {
+			return fmt.Errorf("--renew-before-days must not be negative")
+		}
+		placeholder, err := placeholderFlag(cmd)
+		if err != nil {
+			return err
+		}
+		access, err := accessFlags(cmd)
+		if err != nil {
+			return err
+		}
+		webroot, _ := cmd.Flags().GetString("webroot")
+		mustStaple, _ := cmd.Flags().GetBool("must-staple")
+		expand, _ := cmd.Flags().GetBool("expand")
+		certName, _ := cmd.Flags().GetString("cert-name")
+		force, _ := cmd.Flags().GetBool("force")
+		if err := resolver.Validate(resolvers); err != nil {
+			return err
+		}
+		if caBundle != "" {
+			if _, err := acme.LoadCABundle(caBundle); err != nil {
+				return err
+			}
+			caBundle, _ = filepath.Abs(caBundle)
+		}
 		
 		if domain == "" || email == "" {
 			ui.PrintError("Domain and email are required")
@@ -70,17 +131,63 @@ Supported web servers:
 		}
 		
 		ui.PrintHeader("🔐 TrustTLS - Smart SSL Certificate Manager")
-		ui.PrintInfo(fmt.Sprintf("🌐 Target Domain: %s", domain))
+		ui.PrintInfo(fmt.Sprintf("🌐 Target Domain: %s", strings.Join(domains, ", ")))
 		ui.PrintInfo(fmt.Sprintf("📧 Contact Email: %s", email))
 		
+		// Determine provider and set defaults
+		if provider == "" {
+			if certProvider != "" {
+				provider = certProvider
+			} else if digicertKey != "" || digicertSecret != "" {
+				provider = "digicert"
+				ui.PrintInfo("Auto-detected DigiCert provider from credentials")
+			} else {
+				provider = "letsencrypt"
+				ui.PrintInfo("Using Let's Encrypt (free certificates)")
+			}
+		}
+		
+		preset, isPreset := acme.LookupPreset(provider)
+		if provider != "letsencrypt" && provider != "digicert" && !isPreset {
+			ui.ShowErrorWithHelp(fmt.Errorf("unknown certificate provider: %s", provider),
+				fmt.Sprintf("• Use letsencrypt, digicert or one of the ACME presets: %s", strings.Join(acme.PresetNames(), ", ")))
+			return fmt.Errorf("unknown certificate provider: %s", provider)
+		}
+		
 		// Pre-flight system checks
 		ui.PrintStepWithTime(1, 6, "🔍 Running system health checks", 10*time.Second)
 		
 		// Validate domain format
-		if !isValidDomain(domain) {
-			ui.ShowErrorWithHelp(fmt.Errorf("invalid domain format: %s", domain), 
-				"• Domain should be like example.com or sub.example.com\n• Use only letters, numbers, dots, and hyphens\n• Domain cannot start or end with a hyphen")
-			return fmt.Errorf("invalid domain format: %s", domain)
+		for _, d := range domains {
+			if acme.IsIP(d) {
+				continue
+			}
+			if !isValidDomain(d) {
+				ui.ShowErrorWithHelp(fmt.Errorf("invalid domain format: %s", d), 
+					"• Domain should be like example.com, sub.example.com, *.example.com or an IP address\n• Use only letters, numbers, dots, and hyphens\n• Domain cannot start or end with a hyphen")
+				return fmt.Errorf("invalid domain format: %s", d)
+			}
+		}
+		if certName != "" {
+			if err := store.ValidCertName(certName); err != nil {
+				return err
+			}
+		}
+		certName, expanded, err := expandNames(store.DefaultBaseDir(), certName, domains, expand, !assumeYes && stdinIsTerminal())
+		if err != nil {
+			return err
+		}
+		domains, domain = expanded, expanded[0]
+		method := "http-01"
+		if dnsPlugin != "" { method = "dns-01" }
+		if err := acme.CapabilitiesFor(provider, server).Check(domains, method); err != nil {
+			ui.ShowErrorWithHelp(err,
+				"• Wildcards need --dns <provider> (or --dns manual) unless your CA validates names in its portal\n• IP addresses need a CA that issues IP certificates, set with --server\n• Split long name lists over several certificates")
+			return err
+		}
+		if err := acme.CapabilitiesFor(provider, server).CheckKey(keyType, keySize); err != nil {
+			ui.ShowErrorWithHelp(err, "• --key-type rsa --key-size 2048 (the default) or --key-type ecdsa --key-size 256 work with every CA")
+			return err
 		}
 		ui.PrintProgress("Domain format validation")
 		ui.CompleteProgress()
@@ -94,10 +201,40 @@ Supported web servers:
 		ui.PrintProgress("Email format validation")
 		ui.CompleteProgress()
 		
+		// Validate DNS provider settings before any network call
+		var dnsCreds dnsprovider.Credentials
+		if dnsPlugin != "" {
+			ui.PrintProgress("DNS provider configuration")
+			if dnsCredentials == "" {
+				if p := dnsprovider.CredentialsPath(store.DefaultBaseDir(), dnsPlugin); osutil.FileExists(p) { dnsCredentials = p }
+			}
+			creds, err := dnsprovider.LoadCredentials(dnsCredentials)
+			if err == nil && dnsWait {
+				creds["poll"] = "true"
+			}
+			if err == nil && len(resolvers) > 0 && creds["resolvers"] == "" {
+				creds["resolvers"] = strings.Join(resolvers, ",")
+			}
+			if err == nil {
+				_, err = dnsprovider.New(dnsPlugin, creds)
+			}
+			if err == nil && dnsCredentials != "" {
+				// Keep a private copy in the store so renewals keep working unattended
+				dnsCredentials, err = dnsprovider.StoreCredentials(store.DefaultBaseDir(), store.LineageName(renewal.Config{Domain: domain, CertName: certName}.Lineage()), dnsPlugin, dnsCredentials)
+			}
+			if err != nil {
+				ui.ShowErrorWithHelp(fmt.Errorf("DNS provider setup failed: %w", err),
+					fmt.Sprintf("• Available DNS providers: %s\n• Check the --dns-credentials file exists and is readable\n• Use one 'key = value' setting per line", strings.Join(dnsprovider.Names(), ", ")))
+				return fmt.Errorf("DNS provider setup failed: %w", err)
+			}
+			dnsCreds = creds
+			ui.CompleteProgress()
+		}
+		
 		// Check network connectivity
 		ui.PrintProgress("Network connectivity test")
 		if err := checkNetworkConnectivity(); err != nil {
-			ui.PrintWarning("Network connectivity issues detected - this may affect certificate provisioning")
+			ui.PrintWarning(fmt.Sprintf("Network connectivity issues detected (%v) - this may affect certificate provisioning", err))
 		} else {
 			ui.CompleteProgress()
 		}
@@ -111,25 +248,15 @@ Supported web servers:
 		// Certificate provider selection
 		ui.PrintStepWithTime(2, 6, "🏢 Selecting certificate provider", 5*time.Second)
 		
-		// Determine provider and set defaults
-		if provider == "" {
-			if certProvider != "" {
-				provider = certProvider
-			} else if digicertKey != "" || digicertSecret != "" {
-				provider = "digicert"
-				ui.PrintInfo("Auto-detected DigiCert provider from credentials")
-			} else {
-				provider = "letsencrypt"
-				ui.PrintInfo("Using Let's Encrypt (free certificates)")
-			}
-		}
-		
 		ui.ShowProviderInfo(provider)
 		
-		var cert *certificate.Resource
-		// Remove unused err variable declaration here
-		
+		// DigiCert is ordered over ACME with its EAB credentials; every other
+		// provider is an issuer of the same name
+		issuerName := provider
+		caName := "Let's Encrypt"
 		if provider == "digicert" {
+			issuerName = "digicert-acme"
+			caName = "DigiCert"
 			ui.PrintStepWithTime(3, 6, "🔐 Configuring DigiCert ACME provider", 15*time.Second)
 			
 			// Validate DigiCert requirements
@@ -151,46 +278,26 @@ Supported web servers:
 					"• Check file permissions in ~/.trusttls/\n• Ensure sufficient disk space\n• Verify credentials are correctly formatted")
 				return fmt.Errorf("failed to secure DigiCert credentials: %w", err)
 			}
+			eabKID, eabHMACKey = digicertKey, digicertSecret
 			ui.CompleteProgress()
-			
-			// Initialize DigiCert ACME client
-			ui.PrintStepWithTime(4, 6, "🚀 Getting certificate from DigiCert", 30*time.Second)
-			ui.PrintProgress("Connecting to DigiCert with credentials...")
-			
-			digiCertConfig, err := accountManager.GetDigiCertACMEConfig(email)
-			if err != nil {
-				ui.ShowErrorWithHelp(fmt.Errorf("failed to get DigiCert configuration: %w", err),
-					"• Verify credentials were saved correctly\n• Check account folder permissions\n• Ensure email address matches saved account")
-				return fmt.Errorf("failed to get DigiCert configuration: %w", err)
-			}
-			
-			digiCertProviderInterface, err := acme.NewDigiCertACMEProvider(*digiCertConfig)
-			if err != nil {
-				ui.ShowErrorWithHelp(fmt.Errorf("failed to connect to DigiCert: %w", err),
-					"• Verify DigiCert server URL is accessible\n• Check credentials are valid\n• Ensure network connectivity to DigiCert servers")
-				return fmt.Errorf("failed to connect to DigiCert: %w", err)
-			}
-			
-			digiCertProvider, ok := digiCertProviderInterface.(interface{ ObtainCertificate([]string) (*certificate.Resource, error) })
-			if !ok {
-				return fmt.Errorf("DigiCert ACME provider interface not available")
-			}
-			
-			ui.PrintProgress("Requesting certificate from DigiCert...")
-			cert, err = digiCertProvider.ObtainCertificate([]string{domain})
-			if err != nil {
-				ui.ShowErrorWithHelp(fmt.Errorf("certificate request failed: %w", err),
-					"• Verify domain ownership and DNS setup\n• Check that domain points to this server\n• Ensure web server is accessible for validation\n• Verify DigiCert account has enough permissions")
-				return fmt.Errorf("certificate request failed: %w", err)
-			}
-			ui.CompleteProgress()
-			
 		} else {
-			// Let's Encrypt flow
-			ui.PrintStepWithTime(3, 6, "🌱 Configuring Let's Encrypt provider", 10*time.Second)
+			if isPreset {
+				caName = preset.Description
+				ui.PrintStepWithTime(3, 6, "🏛️  Configuring "+caName, 10*time.Second)
+			} else {
+				ui.PrintStepWithTime(3, 6, "🌱 Configuring Let's Encrypt provider", 10*time.Second)
+			}
 			
 			if server == "" {
-				if staging { 
+				if isPreset {
+					u, err := preset.Directory(profile)
+					if err != nil {
+						ui.ShowErrorWithHelp(err, "• Pick one of the listed profiles with --profile\n• Or pass the directory URL from your CA admin with --server")
+						return err
+					}
+					server = u
+					ui.PrintInfo(fmt.Sprintf("Using %s directory %s", caName, server))
+				} else if staging { 
 					server = acme.LetsEncryptStaging 
 					ui.PrintInfo("Using Let's Encrypt testing environment (no rate limits)")
 				} else { 
@@ -199,91 +306,61 @@ Supported web servers:
 				}
 			}
 			
+			if acme.CapabilitiesFor(provider, server).EAB && (eabKID == "" || eabHMACKey == "") && !acme.HasAccount(storeDir, server, email) {
+				ui.ShowErrorWithHelp(fmt.Errorf("%s needs External Account Binding credentials", caName),
+					"• Create ACME credentials (key ID and HMAC key) in your CA's admin portal\n• Pass them with --eab-kid and --eab-hmac-key\n• They are only needed once: renewals reuse the registered account")
+				return fmt.Errorf("--eab-kid and --eab-hmac-key are required to register with %s", caName)
+			}
+			
 			// Register Let's Encrypt account
-			ui.PrintProgress("Registering Let's Encrypt account...")
+			ui.PrintProgress(fmt.Sprintf("Registering %s account...", caName))
 			if err := accountManager.SaveLetsEncryptAccount(email, server); err != nil {
 				ui.ShowErrorWithHelp(fmt.Errorf("failed to register Let's Encrypt account: %w", err),
 					"• Check network connectivity to Let's Encrypt\n• Verify email address format\n• Ensure account storage directory is writable")
 				return fmt.Errorf("failed to register Let's Encrypt account: %w", err)
 			}
 			ui.CompleteProgress()
-			
-			ui.PrintStepWithTime(4, 6, "🔧 Initializing ACME client", 5*time.Second)
-			ui.PrintProgress("Setting up secure ACME connection...")
-			m, err := acme.NewManager(acme.Options{ 
-				Email:   email, 
-				Server:  server, 
-				KeyType: keyType, 
-				KeySize: keySize, 
-				BaseDir: storeDir,
-			})
-			if err != nil { 
-				ui.ShowErrorWithHelp(fmt.Errorf("ACME client initialization failed: %w", err),
-					"• Check Let's Encrypt server URL is accessible\n• Verify key type and size are supported\n• Ensure sufficient storage space for account keys")
-				return err 
-			}
-			ui.CompleteProgress()
-			
-			// Detect web server (simple English flags)
-			ui.PrintStepWithTime(5, 6, "🌐 Setting up web server", 10*time.Second)
-			var installer Installer
-			var chosen string
-			
-			// Handle simple web server flags
-			if webServer != "" {
-				if webServer == "apache" {
-					if !apache.Available() { 
-						ui.PrintError("Apache web server not found")
-						return fmt.Errorf("apache web server not found") 
-					}
-					installer = apache.NewInstaller(storeDir, assumeYes); chosen = "apache"
-					ui.PrintInfo("Using Apache web server")
-				} else if webServer == "nginx" {
-					if !nginx.Available() { 
-						ui.PrintError("Nginx web server not found")
-						return fmt.Errorf("nginx web server not found") 
-					}
-					installer = nginx.NewInstaller(storeDir, assumeYes); chosen = "nginx"
-					ui.PrintInfo("Using Nginx web server")
-				} else {
-					ui.ShowErrorWithHelp(fmt.Errorf("unknown web server: %s", webServer),
-						"• Use 'apache' for Apache web server\n• Use 'nginx' for Nginx web server\n• Or leave empty for auto-detection")
-					return fmt.Errorf("unknown web server: %s", webServer)
-				}
-			} else if apacheFlag != "" {
-				if !apache.Available() { 
-					ui.PrintError("Apache web server not found")
-					return fmt.Errorf("apache web server not found") 
-				}
-				installer = apache.NewInstaller(storeDir, assumeYes); chosen = "apache"
-				ui.PrintInfo("Using Apache web server")
-			} else if nginxFlag != "" {
-				if !nginx.Available() { 
-					ui.PrintError("Nginx web server not found")
-					return fmt.Errorf("nginx web server not found") 
-				}
-				installer = nginx.NewInstaller(storeDir, assumeYes); chosen = "nginx"
-				ui.PrintInfo("Using Nginx web server")
-			} else if target == "" {
-				// Auto-detect web servers
-				if apache.Available() { 
-					installer = apache.NewInstaller(storeDir, assumeYes); 
-					chosen = "apache" 
-					ui.PrintInfo("Found Apache web server")
-				}
-				if installer == nil && nginx.Available() { 
-					installer = nginx.NewInstaller(storeDir, assumeYes); 
-					chosen = "nginx" 
-					ui.PrintInfo("Found Nginx web server")
-				}
-			} else if target == "apache" {
+		}
+		
+		ui.PrintStepWithTime(4, 6, "🔧 Initializing ACME client", 5*time.Second)
+		ui.PrintProgress(fmt.Sprintf("Setting up secure ACME connection to %s...", caName))
+		iss, err := issuer.New(issuerName, issuer.Settings{ 
+			BaseDir: storeDir,
+			Email:   email, 
+			Server:  server, 
+			CABundle: caBundle,
+			EABKID: eabKID,
+			EABHMACKey: eabHMACKey,
+			KeyType: keyType, 
+			KeySize: keySize, 
+			MustStaple: mustStaple,
+			Resolvers: resolvers,
+			PropagationTimeout: propagationTimeout,
+			PropagationCheck: propagationCheck,
+			IgnoreRateLimits: force,
+		})
+		if err != nil { 
+			ui.ShowErrorWithHelp(fmt.Errorf("ACME client initialization failed: %w", err),
+				"• Check the CA's server URL is accessible\n• Check the account credentials are valid\n• Verify key type and size are supported")
+			return err 
+		}
+		ui.CompleteProgress()
+		
+		// Detect web server (simple English flags)
+		ui.PrintStepWithTime(5, 6, "🌐 Setting up web server", 10*time.Second)
+		var installer Installer
+		var chosen string
+		
+		// Handle simple web server flags
+		if webServer != "" {
+			if webServer == "apache" {
 				if !apache.Available() { 
 					ui.PrintError("Apache web server not found")
 					return fmt.Errorf("apache web server not found") 
 				}
 				installer = apache.NewInstaller(storeDir, assumeYes); chosen = "apache"
 				ui.PrintInfo("Using Apache web server")
-			} else if target == "nginx" {
+			} else if webServer == "nginx" {
 				if !nginx.Available() { 
 					ui.PrintError("Nginx web server not found")
 					return fmt.Errorf("nginx web server not found") 
@@ -291,128 +368,66 @@ Supported web servers:
 				installer = nginx.NewInstaller(storeDir, assumeYes); chosen = "nginx"
 				ui.PrintInfo("Using Nginx web server")
 			} else {
-				ui.ShowErrorWithHelp(fmt.Errorf("unknown target: %s", target),
+				ui.ShowErrorWithHelp(fmt.Errorf("unknown web server: %s", webServer),
 					"• Use 'apache' for Apache web server\n• Use 'nginx' for Nginx web server\n• Or leave empty for auto-detection")
-				return fmt.Errorf("unknown target: %s", target)
-			}
-			if installer == nil {
-				ui.PrintError("No supported web server detected")
-				return fmt.Errorf("no supported web server detected; specify --target=apache|nginx")
-			}
-
-			// Check SSL status
-			ui.PrintStep(4, 5, "Checking SSL status")
-			ui.ShowSSLStatus(domain, installer.IsSSLEnabled(domain))
-			
-			// Detect vhost and ask for confirmation
-			configPath, webserver := installer.DetectVhost(domain)
-			if configPath == "" {
-				ui.PrintWarning("No existing virtual host found, will create default configuration")
-				configPath = fmt.Sprintf("/etc/%s/sites-available/%s-ssl.conf", webserver, domain)
-			}
-			
-			if !assumeYes {
-				// Just show confirmation, don't try to use return value
-				ui.ShowVhostConfirmation(domain, configPath, webserver)
-				if !ui.AskYesNo("Proceed with this configuration?") {
-					ui.PrintInfo("Installation cancelled by user")
-					return nil
-				}
-			}
-
-			// Obtain certificate
-			ui.PrintProgress("Obtaining certificate from Let's Encrypt...")
-			wr := installer.Webroot(domain)
-			if wr == "" { 
-				ui.PrintError(fmt.Sprintf("Could not detect webroot for %s", domain))
-				return fmt.Errorf("could not detect webroot for %s", domain) 
-			}
-			
-			cert, err = m.ObtainHTTP01([]string{domain}, wr)
-			if err != nil { 
-				ui.PrintError(fmt.Sprintf("Failed to obtain certificate: %v", err))
-				return err 
+				return fmt.Errorf("unknown web server: %s", webServer)
 			}
-			ui.CompleteProgress()
-			
-			// Install certificate
-			ui.PrintStep(5, 5, "Installing certificate")
-			ui.PrintProgress("Installing SSL certificate...")
-			if _, err := store.SaveCertificate(storeDir, domain, cert); err != nil { 
-				ui.PrintError(fmt.Sprintf("Failed to save certificate: %v", err))
-				return err 
+		} else if apacheFlag != "" {
+			if !apache.Available() { 
+				ui.PrintError("Apache web server not found")
+				return fmt.Errorf("apache web server not found") 
 			}
-			if err := installer.Install(domain); err != nil { 
-				ui.PrintError(fmt.Sprintf("Failed to install certificate: %v", err))
-				return err 
+			installer = apache.NewInstaller(storeDir, assumeYes); chosen = "apache"
+			ui.PrintInfo("Using Apache web server")
+		} else if nginxFlag != "" {
+			if !nginx.Available() { 
+				ui.PrintError("Nginx web server not found")
+				return fmt.Errorf("nginx web server not found") 
 			}
-			ui.CompleteProgress()
-
-			// Save renewal configuration
-			_ = renewal.Save(renewal.Config{
-				Domain:  domain,
-				Email:   email,
-				Server:  server,
-				Method:  "http-01",
-				Webroot: wr,
-				KeyType: keyType,
-				KeySize: keySize,
-				Targets: []string{chosen},
-				BaseDir: storeDir,
-			})
-			
-			ui.PrintSuccess(fmt.Sprintf("SSL certificate successfully installed for %s", domain))
-			return nil
-		}
-		
-		// For DigiCert, handle installation
-		ui.PrintStep(3, 5, "Detecting web server configuration")
-		var installer Installer
-		var chosen string
-		if target == "" {
+			installer = nginx.NewInstaller(storeDir, assumeYes); chosen = "nginx"
+			ui.PrintInfo("Using Nginx web server")
+		} else if target == "" {
+			// Auto-detect web servers
 			if apache.Available() { 
 				installer = apache.NewInstaller(storeDir, assumeYes); 
 				chosen = "apache" 
-				ui.PrintInfo("Detected Apache web server")
+				ui.PrintInfo("Found Apache web server")
 			}
 			if installer == nil && nginx.Available() { 
 				installer = nginx.NewInstaller(storeDir, assumeYes); 
 				chosen = "nginx" 
-				ui.PrintInfo("Detected Nginx web server")
+				ui.PrintInfo("Found Nginx web server")
 			}
 		} else if target == "apache" {
 			if !apache.Available() { 
-				ui.PrintError("Apache not detected")
-				return fmt.Errorf("apache not detected") 
+				ui.PrintError("Apache web server not found")
+				return fmt.Errorf("apache web server not found") 
 			}
 			installer = apache.NewInstaller(storeDir, assumeYes); chosen = "apache"
 			ui.PrintInfo("Using Apache web server")
 		} else if target == "nginx" {
 			if !nginx.Available() { 
-				ui.PrintError("Nginx not detected")
-				return fmt.Errorf("nginx not detected") 
+				ui.PrintError("Nginx web server not found")
+				return fmt.Errorf("nginx web server not found") 
 			}
 			installer = nginx.NewInstaller(storeDir, assumeYes); chosen = "nginx"
 			ui.PrintInfo("Using Nginx web server")
 		} else {
-			ui.PrintError(fmt.Sprintf("Unknown target: %s", target))
+			ui.ShowErrorWithHelp(fmt.Errorf("unknown target: %s", target),
+				"• Use 'apache' for Apache web server\n• Use 'nginx' for Nginx web server\n• Or leave empty for auto-detection")
 			return fmt.Errorf("unknown target: %s", target)
 		}
 		if installer == nil {
 			ui.PrintError("No supported web server detected")
 			return fmt.Errorf("no supported web server detected; specify --target=apache|nginx")
 		}
-		
+
 		// Check SSL status
 		ui.PrintStep(4, 5, "Checking SSL status")
 		ui.ShowSSLStatus(domain, installer.IsSSLEnabled(domain))
 		
 		// Detect vhost and ask for confirmation
-		configPath, webserver := installer.DetectVhost(domain)
-		if configPath == "" {
-			ui.PrintWarning("No existing virtual host found, will create default configuration")
-			configPath = fmt.Sprintf("/etc/%s/sites-available/%s-ssl.conf", webserver, domain)
-		}
+		configPath, webserver, newSite := planVhost(ui, installer, domain, &webroot)
 		
 		if !assumeYes {
 			// Just show confirmation, don't try to use return value
@@ -422,57 +437,172 @@ Supported web servers:
 				return nil
 			}
 		}
+		if newSite {
+			if err := createSite(ui, installer, domains, webroot); err != nil { return err }
+		}
+
+		// Obtain certificate
+		ui.PrintProgress(fmt.Sprintf("Obtaining certificate from %s...", caName))
+		req := issuer.Request{Domains: domains, DNSPlugin: dnsPlugin, DNSCredentials: dnsCreds}
+		if dnsPlugin == "" {
+			req.Webroot = webroot
+			if req.Webroot == "" { req.Webroot = installer.Webroot(domain) }
+			if req.Webroot == "" { req.Webroot = askWebroot(ui, installer, domain, assumeYes) }
+			if req.Webroot == "" { 
+				ui.PrintError(fmt.Sprintf("Could not detect webroot for %s", domain))
+				return fmt.Errorf("could not detect webroot for %s; pass --webroot", domain) 
+			}
+		}
+		cert, err := iss.Order(cmd.Context(), req)
+		if err != nil { 
+			ui.ShowErrorWithHelp(fmt.Errorf("certificate request failed: %w", err),
+				"• Verify domain ownership and DNS setup\n• Check that domain points to this server\n• Ensure web server is accessible for validation")
+			return err 
+		}
+		ui.CompleteProgress()
 		
 		// Install certificate
 		ui.PrintStep(5, 5, "Installing certificate")
-		ui.PrintProgress("Installing DigiCert certificate...")
-		if _, err := store.SaveCertificate(storeDir, domain, cert); err != nil { 
+		ui.PrintProgress("Installing SSL certificate...")
+		lineage := domain
+		if certName != "" { lineage = certName }
+		renewal.CompleteChain(renewal.Config{Domain: domain, CertName: certName, CABundle: caBundle}, cert)
+		if _, err := store.SaveBundle(storeDir, lineage, store.Bundle{Cert: cert.Certificate, Chain: cert.IssuerCertificate, Key: cert.PrivateKey, OrderURL: acme.OrderURL(cert)}); err != nil { 
 			ui.PrintError(fmt.Sprintf("Failed to save certificate: %v", err))
 			return err 
 		}
-		if err := installer.Install(domain); err != nil { 
+		renewal.RecordIssuance(renewal.Config{Domain: domain, CertName: certName, BaseDir: storeDir, Provider: issuerName}, cert)
+		access.Domain, access.CertName, access.BaseDir = domain, certName, storeDir
+		if err := renewal.ApplyAccess(access); err != nil {
+			ui.PrintError(fmt.Sprintf("Failed to set the owner and mode of the certificate files: %v", err))
+			return err
+		}
+		if err := installer.Install(lineage, domain, domains[1:]...); err != nil { 
 			ui.PrintError(fmt.Sprintf("Failed to install certificate: %v", err))
 			return err 
 		}
 		ui.CompleteProgress()
-
-		// Save renewal configuration for DigiCert
-		_ = renewal.Save(renewal.Config{
-			Domain:  domain,
-			Email:   email,
-			Server:  server,
-			Method:  "digicert",
-			KeyType: keyType,
-			KeySize: keySize,
-			Targets: []string{chosen},
-			BaseDir: storeDir,
+		_ = renewal.RecordDeployment(storeDir, lineage, renewal.Deployment{
+			Kind: chosen, Target: installer.ConfigFile(domain), Detail: "SSL site", Source: renewal.SourceInstall,
 		})
+
+		// Save renewal configuration
+		renewalCfg := renewal.Config{
+			Domain:         domain,
+			Domains:        sanList(domains),
+			CertName:       certName,
+			Email:          email,
+			Server:         server,
+			Method:         method,
+			Webroot:        req.Webroot,
+			DNSPlugin:      dnsPlugin,
+			DNSCredentials: dnsCredentials,
+			DNSWait:        dnsWait,
+			KeyType:        keyType,
+			KeySize:        keySize,
+			Targets:        []string{chosen},
+			BaseDir:        storeDir,
+			Provider:       issuerName,
+			Resolvers:      resolvers,
+			PropagationTimeout: durationString(propagationTimeout),
+			PropagationCheck: propagationCheck,
+			CABundle:       caBundle,
+			Soak:           durationString(soak),
+			RenewBeforeDays: renewBeforeDays,
+			Placeholder:    placeholder,
+			CertOwner:      access.CertOwner,
+			CertGroup:      access.CertGroup,
+			KeyMode:        access.KeyMode,
+			MustStaple:     mustStaple,
+		}
+		_ = renewal.Save(renewalCfg)
 		
-		ui.PrintSuccess(fmt.Sprintf("DigiCert SSL certificate successfully installed for %s", domain))
-		return nil
+		ui.PrintSuccess(fmt.Sprintf("SSL certificate from %s successfully installed for %s", caName, domain))
+		if mustStaple {
+			ui.PrintWarning("OCSP Must-Staple is set: turn on stapling (nginx: ssl_stapling on; Apache: SSLUseStapling on) or browsers will refuse the certificate")
+		}
+		if dnsPlugin == "manual" {
+			ui.PrintWarning("Manual DNS cannot renew unattended: run 'trusttls renew' yourself before the certificate expires")
+		}
+		return finishInstall(ui, buildInstallSummary(renewalCfg, provider, chosen, configPath), asJSON, stdout)
 	},
 }
 
 type Installer interface {
 	Webroot(domain string) string
-	Install(domain string) error
+	WebrootCandidates(domain string) []string // roots to offer when Webroot finds none
+	Install(certName, domain string, aliases ...string) error // certName: lineage in the store; aliases: other names on the certificate
 	IsSSLEnabled(domain string) bool
 	DetectVhost(domain string) (string, string) // returns config path and webserver type
+	SiteFile(domain string) string              // port 80 vhost CreateSite writes
+	ConfigFile(domain string) string            // SSL vhost Install writes
+	CreateSite(domain, webroot string, aliases ...string) error
+}
+
+// planVhost finds the vhost for domain. When there is none, a new port 80
+// site serving *webroot (default /var/www/<domain>) is planned so the
+// certificate can be validated before the SSL vhost is written; wildcards
+// only get the SSL vhost, since no single site stands for them.
+func planVhost(ui *UI, installer Installer, domain string, webroot *string) (configPath, webserver string, newSite bool) {
+	configPath, webserver = installer.DetectVhost(domain)
+	if configPath != "" {
+		return configPath, webserver, false
+	}
+	if strings.HasPrefix(domain, "*.") {
+		ui.PrintWarning("No existing virtual host found, will create an SSL-only configuration")
+		return fmt.Sprintf("/etc/%s/sites-available/%s-ssl.conf", webserver, domain), webserver, false
+	}
+	if *webroot == "" {
+		*webroot = filepath.Join("/var/www", store.LineageName(domain))
+	}
+	ui.PrintWarning(fmt.Sprintf("No existing virtual host found, will create a new site for %s serving %s", domain, *webroot))
+	return installer.SiteFile(domain), webserver, true
+}
+
+// askWebroot lets the user pick the webroot from the candidates found in the
+// vhost configs when none could be resolved on its own, e.g. because the root
+// is set from a variable. It returns "" when there is nothing to pick or no
+// one to ask.
+func askWebroot(ui *UI, installer Installer, domain string, assumeYes bool) string {
+	candidates := installer.WebrootCandidates(domain)
+	if len(candidates) == 0 {
+		return ""
+	}
+	if assumeYes || !isTerminal() {
+		ui.PrintWarning(fmt.Sprintf("Webroot for %s could be any of: %s", domain, strings.Join(candidates, ", ")))
+		return ""
+	}
+	return candidates[ui.AskChoice(fmt.Sprintf("Which folder does %s serve files from?", domain), candidates)]
+}
+
+// createSite writes the port 80 site planned by planVhost and reloads the
+// web server.
+func createSite(ui *UI, installer Installer, domains []string, webroot string) error {
+	ui.PrintProgress("Creating HTTP site...")
+	if err := installer.CreateSite(domains[0], webroot, domains[1:]...); err != nil {
+		ui.PrintError(fmt.Sprintf("Failed to create site: %v", err))
+		return err
+	}
+	ui.CompleteProgress()
+	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(installCmd)
-	installCmd.Flags().String("domain", "", "Domain to issue certificate for")
+	installCmd.Flags().StringSlice("domain", nil, "Domain to issue certificate for; repeat or comma-separate for one certificate covering several names")
 	installCmd.Flags().String("email", "", "Account email")
 	installCmd.Flags().String("key-type", "rsa", "Key algorithm: rsa or ecdsa")
-	installCmd.Flags().Int("key-size", 2048, "Key size for rsa or curve bits (256/384) for ecdsa")
+	installCmd.Flags().Int("key-size", 2048, "Key size for rsa (2048-8192) or curve bits (256/384/521) for ecdsa")
 	installCmd.Flags().Bool("staging", false, "Use Let's Encrypt staging CA")
 	installCmd.Flags().String("server", "", "ACME directory URL; overrides --staging")
+	installCmd.Flags().String("ca-bundle", "", "PEM file with extra root certificates to trust for the ACME server (private CA, TLS-intercepting proxy); kept for every later order from it")
+	installCmd.Flags().String("acme-ca-cert", "", "PEM file with extra root certificates to trust for the ACME server (same as --ca-bundle)")
 	installCmd.Flags().String("target", "", "Install target: apache or nginx; auto-detect if empty")
 	installCmd.Flags().Bool("yes", false, "Assume yes when prompting to modify vhost files")
 	
 	// Add verbose flag
 	installCmd.Flags().Bool("verbose", false, "Show verbose output")
+	installCmd.Flags().Bool("json", false, "Print the install summary as JSON on stdout")
 	
 	// Web server choice flags (simple English)
 	installCmd.Flags().String("web-server", "", "Web server type: apache or nginx")
@@ -480,12 +610,107 @@ func init() {
 	installCmd.Flags().String("nginx", "", "Use Nginx web server")
 	
 	// Certificate provider flags (simple English)
-	installCmd.Flags().String("provider", "", "Certificate provider: letsencrypt or digicert")
-	installCmd.Flags().String("cert-provider", "", "Certificate provider: letsencrypt or digicert")
+	installCmd.Flags().String("provider", "", "Certificate provider: letsencrypt, digicert, sectigo or incommon")
+	installCmd.Flags().String("cert-provider", "", "Certificate provider: letsencrypt, digicert, sectigo or incommon")
+	installCmd.Flags().String("profile", "", "Certificate profile of a sectigo or incommon provider, e.g. dv, ov, ev (default ov)")
+	installCmd.Flags().String("eab-kid", "", "External Account Binding key ID, for CAs that require it")
+	installCmd.Flags().String("eab-hmac-key", "", "External Account Binding HMAC key (base64url)")
 	installCmd.Flags().String("digicert-key", "", "DigiCert key ID")
 	installCmd.Flags().String("digicert-secret", "", "DigiCert secret key")
 	installCmd.Flags().String("account-id", "", "DigiCert account ID")
 	installCmd.Flags().String("org-id", "", "DigiCert organization ID")
+	
+	// DNS-01 validation flags
+	installCmd.Flags().String("dns", "", "Validate with DNS-01 using this DNS provider instead of the webroot")
+	installCmd.Flags().String("dns-credentials", "", "Credentials file for the DNS provider (key = value lines)")
+	installCmd.Flags().StringSlice("resolver", nil, "DNS resolver for CAA and DNS-01 pre-checks: IP, tls://host or https:// DoH URL (repeatable)")
+	installCmd.Flags().Duration("propagation-timeout", 0, "How long to wait for DNS-01 records to show up on public resolvers and nameservers (default: provider estimate)")
+	installCmd.Flags().String("propagation-check", "", "Where DNS-01 records must be visible before validation: authoritative (default) or all (also public resolvers)")
+	installCmd.Flags().String("webroot", "", "Website folder for validation; created with a new site when the domain has no vhost (default /var/www/<domain>)")
+	installCmd.Flags().Int("renew-before-days", 0, "Renew this many days before expiry instead of config.yaml's renew_before_days, or 30")
+	installCmd.Flags().Duration("soak", 0, "After each renewal keep the previous certificate in live/<domain>/previous/ until the new one has been served this long (e.g. 72h)")
+	installCmd.Flags().String("placeholder", "", placeholderUsage)
+	addAccessFlags(installCmd)
+	installCmd.Flags().String("cert-name", "", "Name to keep the certificate under (live/<name>/) instead of its first domain")
+	installCmd.Flags().Bool("expand", false, "When another certificate already carries some of the names, reissue it with the new names added")
+	installCmd.Flags().Bool("must-staple", false, "Add the OCSP Must-Staple extension; the web server must then staple OCSP responses")
+	installCmd.Flags().Bool("force", false, forceUsage)
+	installCmd.Flags().Bool("dns-wait", false, "With --dns manual, wait until public resolvers see each TXT record before validating")
+}
+
+// parseDomains flattens repeated and comma-separated --domain values into a
+// list of names, primary first, without duplicates.
+func parseDomains(values []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+			if ip := net.ParseIP(strings.Trim(d, "[]")); ip != nil { d = ip.String() }
+			if d == "" || seen[d] { continue }
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+const placeholderUsage = "When renewal fails this close to expiry (e.g. 48h, or \"expired\"), switch the web server to a self-signed placeholder and alert until it succeeds"
+
+// forceUsage describes --force, which overrides the rate limit tracking of
+// the commands that order certificates.
+const forceUsage = "Order even when recent issuances logged in the store say a Let's Encrypt rate limit would be exceeded"
+
+// placeholderFlag returns the checked --placeholder of cmd.
+func placeholderFlag(cmd *cobra.Command) (string, error) {
+	v, _ := cmd.Flags().GetString("placeholder")
+	if _, _, err := renewal.PlaceholderWindow(renewal.Config{Placeholder: v}); err != nil {
+		return "", fmt.Errorf("--%w", err)
+	}
+	return v, nil
+}
+
+// accessFlags returns the checked --cert-owner, --cert-group and --key-mode
+// of cmd, as the renewal config fields they are saved in.
+func accessFlags(cmd *cobra.Command) (renewal.Config, error) {
+	var c renewal.Config
+	c.CertOwner, _ = cmd.Flags().GetString("cert-owner")
+	c.CertGroup, _ = cmd.Flags().GetString("cert-group")
+	c.KeyMode, _ = cmd.Flags().GetString("key-mode")
+	if _, err := renewal.KeyAccess(c); err != nil {
+		return c, fmt.Errorf("--%s", strings.Replace(err.Error(), "_", "-", 1))
+	}
+	return c, nil
+}
+
+// addAccessFlags registers the flags read by accessFlags.
+func addAccessFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cert-owner", "", "Give the certificate files to this user after every renewal, e.g. www-data")
+	cmd.Flags().String("cert-group", "", "Give the certificate files to this group after every renewal, e.g. nginx")
+	cmd.Flags().String("key-mode", "", "Octal mode of the private key, e.g. 0640 to let --cert-group read it (default 0600)")
+}
+
+// keyFlags returns the --key-type and --key-size of cmd, checked with
+// acme.NormalizeKey. --key-type alone gets that type's default size rather
+// than the flag default of the other type.
+func keyFlags(cmd *cobra.Command) (string, int, error) {
+	keyType, _ := cmd.Flags().GetString("key-type")
+	keySize, _ := cmd.Flags().GetInt("key-size")
+	if cmd.Flags().Changed("key-type") && !cmd.Flags().Changed("key-size") {
+		keySize = 0
+	}
+	keyType, keySize, err := acme.NormalizeKey(keyType, keySize)
+	if err != nil {
+		return keyType, keySize, fmt.Errorf("--key-type %s --key-size %d: %w", keyType, keySize, err)
+	}
+	return keyType, keySize, nil
+}
+
+// sanList returns the names to record in a renewal config: nil for a
+// single-name certificate, whose Domain says it all.
+func sanList(domains []string) []string {
+	if len(domains) < 2 { return nil }
+	return domains
 }
 
 // Validation functions
@@ -493,6 +718,13 @@ func isValidDomain(domain string) bool {
 	if len(domain) == 0 || len(domain) > 253 {
 		return false
 	}
+	// A wildcard covers one label of a domain with at least two labels
+	if strings.HasPrefix(domain, "*.") {
+		domain = domain[2:]
+		if !strings.Contains(domain, ".") {
+			return false
+		}
+	}
 	
 	domainRegex := regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?)*$`)
 	return domainRegex.MatchString(domain)
@@ -509,17 +741,29 @@ func isValidEmail(email string) bool {
 
 func checkNetworkConnectivity() error {
 	client := &http.Client{Timeout: 5 * time.Second}
+	directory := "https://acme-v02.api.letsencrypt.org/directory"
 	
-	// Try to connect to Google's DNS (basic connectivity test)
-	conn, err := net.DialTimeout("tcp", "8.8.8.8:53", 3*time.Second)
+	// Behind a proxy names are resolved by the proxy, and direct
+	// connections are usually blocked
+	proxy, err := acme.ProxyFor(directory)
 	if err != nil {
-		return fmt.Errorf("cannot connect to DNS servers: %w", err)
+		return fmt.Errorf("invalid proxy settings: %w", err)
+	}
+	if proxy == nil {
+		// Try to connect to Google's DNS (basic connectivity test)
+		conn, err := net.DialTimeout("tcp", "8.8.8.8:53", 3*time.Second)
+		if err != nil {
+			return fmt.Errorf("cannot connect to DNS servers: %w", err)
+		}
+		conn.Close()
 	}
-	conn.Close()
 	
 	// Try to reach Let's Encrypt
-	resp, err := client.Get("https://acme-v02.api.letsencrypt.org/directory")
+	resp, err := client.Get(directory)
 	if err != nil {
+		if proxy != nil {
+			return fmt.Errorf("cannot reach Let's Encrypt servers through proxy %s: %w", proxy.Redacted(), err)
+		}
 		return fmt.Errorf("cannot reach Let's Encrypt servers: %w", err)
 	}
 	resp.Body.Close()
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/api"
+	"github.com/trustctl/trusttls/internal/jobs"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Inspect and manage queued certificate jobs",
+	Long: `
+Queued jobs are issuance requests that survive restarts. Failed jobs are
+retried automatically with increasing delays, and orders to the same CA are
+spaced out to stay within rate limits.
+
+Example:
+  trusttls renew --queue            # Queue all due renewals
+  trusttls jobs run                 # Process jobs that are due
+  trusttls jobs run --workers 8 --ca-workers 4  # ...several at once
+  trusttls jobs list                # Show all jobs
+  trusttls jobs retry <id>          # Retry a failed job now
+  trusttls jobs cancel <id>         # Stop a pending job
+`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List queued jobs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		list, err := jobs.NewQueue(store.DefaultBaseDir()).List()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Println("📭 No jobs in the queue")
+			return nil
+		}
+		fmt.Printf("%-30s %-30s %-10s %-8s %s\n", "ID", "DOMAIN", "STATE", "TRIES", "NEXT ATTEMPT / ERROR")
+		for _, j := range list {
+			detail := j.NextAttempt.Format("2006-01-02 15:04:05")
+			if j.LastError != "" {
+				detail = j.LastError
+			}
+			if j.State == jobs.StateRunning && j.Owner != "" {
+				detail = "run by " + j.Owner
+			}
+			fmt.Printf("%-30s %-30s %-10s %d/%-6d %s\n", j.ID, j.Domain, j.State, j.Attempts, j.MaxAttempts, detail)
+		}
+		return nil
+	},
+}
+
+var jobsRetryCmd = &cobra.Command{
+	Use:   "retry <id>",
+	Short: "Retry a failed or cancelled job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		j, err := jobs.NewQueue(store.DefaultBaseDir()).Retry(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🔁 Job %s for %s queued for retry\n", j.ID, j.Domain)
+		return nil
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a pending job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		j, err := jobs.NewQueue(store.DefaultBaseDir()).Cancel(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🛑 Job %s for %s cancelled\n", j.ID, j.Domain)
+		return nil
+	},
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Process all jobs that are due",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		q := jobs.NewQueue(store.DefaultBaseDir())
+		q.Workers, _ = cmd.Flags().GetInt("workers")
+		q.CAWorkers, _ = cmd.Flags().GetInt("ca-workers")
+		if q.Workers < 1 || q.CAWorkers < 1 {
+			return fmt.Errorf("--workers and --ca-workers need at least 1")
+		}
+		n, err := q.RunDue(api.JobHandler(store.DefaultBaseDir(), verbose))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Processed %d job(s)\n", n)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd, jobsRetryCmd, jobsCancelCmd, jobsRunCmd)
+	jobsRunCmd.Flags().Bool("verbose", false, "Verbose output")
+	jobsRunCmd.Flags().Int("workers", 1, "Jobs running at once")
+	jobsRunCmd.Flags().Int("ca-workers", 1, "Jobs running at once against the same CA server")
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var migrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store",
+	Short: "Move all TrustTLS state to another directory",
+	Long: `
+Move certificates, keys, accounts and renewal settings to a new base
+directory, for example when a setup made as a normal user becomes a system
+service.
+
+The move:
+• Renames the directory (or copies and removes it across file systems)
+• Rewrites paths to the old directory in renewal settings and in Apache and
+  Nginx configs, then reloads the web servers
+• Upgrades files left by older TrustTLS versions
+• Resets permissions to 0700 for folders and 0600 for files
+
+TrustTLS looks for its state in ~/.trusttls of the user running it. Use
+--link to leave a symlink at the old location so that keeps working.
+
+Example:
+  sudo trusttls migrate-store --from /home/alice/.trusttls --to /var/lib/trusttls
+  trusttls migrate-store --to /srv/trusttls --link
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		link, _ := cmd.Flags().GetBool("link")
+		if from == "" {
+			from = store.DefaultBaseDir()
+		}
+		if to == "" {
+			return fmt.Errorf("--to is required")
+		}
+		from, _ = filepath.Abs(from)
+		to, _ = filepath.Abs(to)
+
+		res, err := store.Migrate(from, to)
+		if err != nil {
+			return err
+		}
+		if res.Moved {
+			fmt.Printf("📦 Moved %s to %s\n", from, to)
+		} else {
+			fmt.Printf("📦 Copied %s to %s and removed the original\n", from, to)
+		}
+		for _, p := range res.Renamed {
+			fmt.Printf("🔧 Upgraded layout: %s\n", p)
+		}
+		for _, p := range res.Rewritten {
+			fmt.Printf("✏️  Updated paths in %s\n", p)
+		}
+
+		renewal.SwitchCertificatePaths(from, to)
+
+		if link {
+			if err := os.Symlink(to, from); err != nil {
+				return fmt.Errorf("link %s to %s: %w", from, to, err)
+			}
+			fmt.Printf("🔗 %s now points to %s\n", from, to)
+		} else if from == store.DefaultBaseDir() && from == store.ConfigDir() {
+			fmt.Printf("💡 TrustTLS reads %s for this user; run it as a user whose store is %s, or re-run with --link\n", from, to)
+		}
+		fmt.Printf("✅ Store migrated\n")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateStoreCmd)
+	migrateStoreCmd.Flags().String("from", "", "Current store directory (default: ~/.trusttls)")
+	migrateStoreCmd.Flags().String("to", "", "New store directory; must not exist or be empty")
+	migrateStoreCmd.Flags().Bool("link", false, "Leave a symlink at the old location pointing to the new one")
+}
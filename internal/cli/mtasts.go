@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/mtasts"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/resolver"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var mtastsCmd = &cobra.Command{
+	Use:   "mta-sts",
+	Short: "Publish an MTA-STS policy and its mta-sts.<domain> certificate",
+	Long: `
+Set up MTA-STS for a mail domain.
+
+MTA-STS tells other mail servers to only deliver to your MX hosts over
+verified TLS. It needs three things, and this command handles the first two:
+
+• The policy file at https://mta-sts.<domain>/.well-known/mta-sts.txt
+• A trusted certificate for mta-sts.<domain>
+• A TXT record _mta-sts.<domain> announcing the policy id
+
+The web server for mta-sts.<domain> must serve --webroot over HTTP (for
+validation) and HTTPS (for the policy) with the certificate from the store.
+Without --mx the policy lists the domain's current MX records and follows
+them on every renewal; when the policy changes it gets a new id and
+'trusttls renew' tells you which TXT record to publish.
+
+Start in testing mode and switch to enforce once TLS reports look clean.
+
+Examples:
+  trusttls mta-sts --domain example.com --email admin@example.com \
+    --webroot /var/www/mta-sts
+  trusttls mta-sts --domain example.com --email admin@example.com \
+    --webroot /var/www/mta-sts --mode enforce --mx mx1.example.com,mx2.example.com
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		email, _ := cmd.Flags().GetString("email")
+		webroot, _ := cmd.Flags().GetString("webroot")
+		mode, _ := cmd.Flags().GetString("mode")
+		mx, _ := cmd.Flags().GetStringSlice("mx")
+		maxAge, _ := cmd.Flags().GetInt("max-age")
+		testMode, _ := cmd.Flags().GetBool("test-mode")
+		server, _ := cmd.Flags().GetString("server")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolver")
+		deployHook, _ := cmd.Flags().GetString("deploy-hook")
+
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if domain == "" || email == "" || webroot == "" {
+			return fmt.Errorf("--domain, --email and --webroot are required")
+		}
+		if err := resolver.Validate(resolvers); err != nil {
+			return err
+		}
+		for i := range mx {
+			mx[i] = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(mx[i])), ".")
+		}
+		if server == "" {
+			if testMode {
+				server = acme.LetsEncryptStaging
+			} else {
+				server = acme.LetsEncryptProd
+			}
+		}
+
+		host := mtasts.PolicyHost(domain)
+		storeDir := store.DefaultBaseDir()
+		renewalCfg := renewal.Config{
+			Domain:     host,
+			Email:      email,
+			Server:     server,
+			Method:     "http-01",
+			Webroot:    webroot,
+			KeyType:    "ecdsa",
+			KeySize:    256,
+			Targets:    []string{},
+			BaseDir:    storeDir,
+			DeployHook: deployHook,
+			Resolvers:  resolvers,
+			MTASTS: &renewal.MTASTSConfig{
+				Domain:  domain,
+				Webroot: webroot,
+				Mode:    mode,
+				MX:      mx,
+				MaxAge:  maxAge,
+			},
+		}
+		// Keep the published id when re-running with an unchanged policy.
+		if prev, err := renewal.Load(host); err == nil && prev.MTASTS != nil {
+			renewalCfg.MTASTS.PolicyID = prev.MTASTS.PolicyID
+		}
+
+		// The policy goes in first so the certificate is never served
+		// without it.
+		renewalCfg, changed, err := renewal.PublishMTASTS(renewalCfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("📄 Policy written to %s\n", mtasts.PolicyPath(webroot))
+
+		m, err := acme.NewManager(acme.Options{
+			Email:     email,
+			Server:    server,
+			KeyType:   renewalCfg.KeyType,
+			KeySize:   renewalCfg.KeySize,
+			BaseDir:   storeDir,
+			Resolvers: resolvers,
+		})
+		if err != nil {
+			return err
+		}
+		cert, err := m.ObtainHTTP01([]string{host}, webroot)
+		if err != nil {
+			return err
+		}
+		path, err := renewal.StoreCertificate(renewalCfg, cert)
+		if err != nil {
+			return err
+		}
+		if err := renewal.Save(renewalCfg); err != nil {
+			return err
+		}
+
+		fmt.Printf("🎉 MTA-STS certificate obtained for %s\n", host)
+		fmt.Printf("📁 Certificate saved to: %s\n", path)
+		if changed {
+			fmt.Printf("🆔 Policy id: %s\n", renewalCfg.MTASTS.PolicyID)
+		}
+		fmt.Printf("💡 Next steps:\n")
+		fmt.Printf("   • Serve https://%s/.well-known/mta-sts.txt from %s with this certificate\n", host, webroot)
+		if hint := renewal.CheckMTASTSRecord(renewalCfg); hint != "" {
+			fmt.Printf("   • %s\n", hint)
+		} else {
+			fmt.Printf("   • %s TXT %q is already published\n", mtasts.TXTName(domain), mtasts.TXTValue(renewalCfg.MTASTS.PolicyID))
+		}
+		fmt.Printf("   • Renewals also refresh the policy: trusttls renew\n")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mtastsCmd)
+	mtastsCmd.Flags().String("domain", "", "Mail domain the policy covers (e.g., example.com)")
+	mtastsCmd.Flags().String("email", "", "Your email address for certificate notifications")
+	mtastsCmd.Flags().String("webroot", "", "Website folder served as mta-sts.<domain>")
+	mtastsCmd.Flags().String("mode", mtasts.ModeTesting, "Policy mode: testing, enforce or none")
+	mtastsCmd.Flags().StringSlice("mx", nil, "MX hosts to allow (default: the domain's MX records, followed on renewal)")
+	mtastsCmd.Flags().Int("max-age", mtasts.DefaultMaxAge, "How long senders cache the policy, in seconds")
+	mtastsCmd.Flags().Bool("test-mode", false, "Use test environment (won't issue real certificates)")
+	mtastsCmd.Flags().String("server", "", "Custom certificate provider URL")
+	mtastsCmd.Flags().StringSlice("resolver", nil, "DNS resolver for MX and TXT lookups: IP, tls://host or https:// DoH URL (repeatable)")
+	mtastsCmd.Flags().String("deploy-hook", "", "Shell command to run after each successful renewal (e.g. 'systemctl reload nginx')")
+}
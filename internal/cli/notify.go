@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/notify"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Send renewal results to webhooks, email or commands",
+	Long: `
+Tell people about renewals. By default each renewal and each failure is sent
+as it happens. With a digest, 'trusttls renew' collects them instead and
+sends one summary a day or a week: the certificates renewed, those expiring
+soon, and renewals that keep failing with how long they have been failing.
+
+Channels are kept in ~/.trusttls/notify.yaml:
+• webhook: POSTs {"text": ...}, which Slack, Mattermost and Teams accept
+• email: mailed through the local sendmail
+• command: a shell command reading the message on stdin, with the subject
+  in TRUSTTLS_SUBJECT
+
+Example:
+  trusttls notify add --name ops --webhook https://hooks.slack.com/services/T0/B0/XXXX
+  trusttls notify add --name oncall --email oncall@example.com
+  trusttls notify digest --every weekly --expiring-days 21
+  trusttls notify digest                # Preview the next digest
+  trusttls notify digest --send         # Send it now
+  trusttls notify test
+  trusttls notify digest --every off    # Back to one message per event
+`,
+}
+
+var notifyAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add or update a notification channel",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var ch notify.Channel
+		ch.Name, _ = cmd.Flags().GetString("name")
+		ch.Webhook, _ = cmd.Flags().GetString("webhook")
+		ch.Email, _ = cmd.Flags().GetString("email")
+		ch.Command, _ = cmd.Flags().GetString("command")
+		if err := ch.Validate(); err != nil {
+			return err
+		}
+		base := store.DefaultBaseDir()
+		cfg, err := notify.Load(base)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i := range cfg.Channels {
+			if cfg.Channels[i].Name == ch.Name {
+				cfg.Channels[i] = ch
+				replaced = true
+			}
+		}
+		if !replaced {
+			cfg.Channels = append(cfg.Channels, ch)
+		}
+		if err := notify.Save(base, cfg); err != nil {
+			return err
+		}
+		if replaced {
+			fmt.Printf("✅ Updated channel %s\n", ch.Name)
+		} else {
+			fmt.Printf("✅ Added channel %s\n", ch.Name)
+		}
+		fmt.Printf("💡 Run 'trusttls notify test' to check it\n")
+		return nil
+	},
+}
+
+var notifyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List notification channels and the digest setting",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		cfg, err := notify.Load(base)
+		if err != nil {
+			return err
+		}
+		if len(cfg.Channels) == 0 {
+			fmt.Println("📭 No notification channels configured")
+			return nil
+		}
+		for _, ch := range cfg.Channels {
+			fmt.Printf("📣 %-16s %-8s %s\n", ch.Name, ch.Kind(), ch.Target())
+		}
+		return printDigestSetting(base, cfg)
+	},
+}
+
+var notifyRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a notification channel",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		cfg, err := notify.Load(base)
+		if err != nil {
+			return err
+		}
+		kept := cfg.Channels[:0]
+		for _, ch := range cfg.Channels {
+			if ch.Name != args[0] {
+				kept = append(kept, ch)
+			}
+		}
+		if len(kept) == len(cfg.Channels) {
+			return fmt.Errorf("no channel named %s", args[0])
+		}
+		cfg.Channels = kept
+		if err := notify.Save(base, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("🗑️  Removed channel %s\n", args[0])
+		return nil
+	},
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test message over every channel",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := notify.Load(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if len(cfg.Channels) == 0 {
+			return fmt.Errorf("no notification channels configured; add one with trusttls notify add")
+		}
+		failed := 0
+		for _, ch := range cfg.Channels {
+			if err := ch.Send("trusttls: test notification", "Notifications from trusttls reach this channel.\n"); err != nil {
+				fmt.Printf("❌ %s: %v\n", ch.Name, err)
+				failed++
+				continue
+			}
+			fmt.Printf("✅ %s\n", ch.Name)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d channel(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+var notifyDigestCmd = &cobra.Command{
+	Use:   "digest",
+	Short: "Set up, preview or send the renewal digest",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		cfg, err := notify.Load(base)
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("every") || cmd.Flags().Changed("expiring-days") {
+			every, _ := cmd.Flags().GetString("every")
+			if cmd.Flags().Changed("every") {
+				cfg.Digest = strings.ToLower(every)
+				if cfg.Digest == "off" {
+					cfg.Digest = ""
+				}
+			}
+			if cmd.Flags().Changed("expiring-days") {
+				cfg.ExpiringDays, _ = cmd.Flags().GetInt("expiring-days")
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if err := notify.Save(base, cfg); err != nil {
+				return err
+			}
+			return printDigestSetting(base, cfg)
+		}
+		if send, _ := cmd.Flags().GetBool("send"); send {
+			if len(cfg.Channels) == 0 {
+				return fmt.Errorf("no notification channels configured; add one with trusttls notify add")
+			}
+			if _, err := notify.SendDigest(base, time.Now(), true); err != nil {
+				return err
+			}
+			fmt.Printf("📨 Digest sent to %d channel(s)\n", len(cfg.Channels))
+			return nil
+		}
+		d, err := notify.BuildDigest(base, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Println(d.Subject())
+		fmt.Println()
+		fmt.Print(d)
+		return nil
+	},
+}
+
+// printDigestSetting describes how notifications of the store in base are
+// grouped.
+func printDigestSetting(base string, cfg notify.Config) error {
+	next, enabled, err := notify.DigestDue(base)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		fmt.Println("📨 Each renewal and failure is sent as it happens")
+		return nil
+	}
+	days := cfg.ExpiringDays
+	if days <= 0 {
+		days = notify.DefaultExpiringDays
+	}
+	when := "with the next 'trusttls renew'"
+	if time.Now().Before(next) {
+		when = "with the first 'trusttls renew' after " + next.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("📨 Sending a %s digest listing certificates expiring within %d days; the next one goes out %s\n", cfg.Digest, days, when)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyAddCmd, notifyListCmd, notifyRemoveCmd, notifyTestCmd, notifyDigestCmd)
+	notifyAddCmd.Flags().String("name", "", "Name of the channel")
+	notifyAddCmd.Flags().String("webhook", "", "URL to POST messages to as JSON")
+	notifyAddCmd.Flags().String("email", "", "Address to mail through sendmail")
+	notifyAddCmd.Flags().String("command", "", "Shell command receiving each message on stdin")
+	notifyDigestCmd.Flags().String("every", "", "Send a digest daily or weekly instead of each event, or off")
+	notifyDigestCmd.Flags().Int("expiring-days", notify.DefaultExpiringDays, "List certificates expiring within this many days")
+	notifyDigestCmd.Flags().Bool("send", false, "Send the digest now instead of previewing it")
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// readOnlyAnnotation marks commands that only look: they may run in
+// read-only mode.
+const readOnlyAnnotation = "trusttls/read-only"
+
+// readOnlyCommands is every command allowed in read-only mode. Anything not
+// listed is refused, so a new command has to be added here deliberately.
+var readOnlyCommands = []*cobra.Command{
+	certificatesCmd,
+	checkExpiryCmd,
+	infoCmd,
+	inspectCmd,
+	whereCmd,
+	configLintCmd,
+	notifyListCmd,
+	notifyDigestCmd, // previews only; see checkReadOnlyFlags
+	replicateListCmd,
+	jobsListCmd,
+	rolloverStatusCmd,
+	agentListCmd,
+	approvalsListCmd,
+	approvalsAuditCmd,
+	digicertOrgsCmd,
+	digicertContactsCmd,
+	digicertStatusCmd,
+	generateSudoersCmd,
+	encryptionStatusCmd,
+}
+
+// mutatingFlags lists flags that make an otherwise read-only command change
+// something.
+var mutatingFlags = map[*cobra.Command][]string{
+	notifyDigestCmd: {"every", "expiring-days", "send"},
+}
+
+// enforceReadOnly turns read-only mode on when --read-only, TRUSTTLS_READ_ONLY
+// or read_only in config.yaml asks for it, and then refuses commands that
+// would write files, order certificates or reload services.
+func enforceReadOnly(cmd *cobra.Command) error {
+	flag, _ := cmd.Flags().GetBool("read-only")
+	configured, err := readonly.Configured(store.DefaultBaseDir())
+	if err != nil {
+		return err
+	}
+	if !flag && !configured {
+		return nil
+	}
+	readonly.Enable()
+	if !cmd.Runnable() || cmd.Name() == "help" || cmd.Name() == "completion" {
+		return nil
+	}
+	cmd.SilenceUsage = true
+	if cmd.Annotations[readOnlyAnnotation] == "" {
+		return fmt.Errorf("%s can write files, order certificates or reload services: %w", cmd.CommandPath(), readonly.ErrReadOnly)
+	}
+	for _, name := range mutatingFlags[cmd] {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("%s --%s: %w", cmd.CommandPath(), name, readonly.ErrReadOnly)
+		}
+	}
+	if !machineOutput(os.Args[1:]) {
+		fmt.Fprintln(os.Stderr, "🔍 Read-only mode: nothing will be written, ordered or reloaded")
+	}
+	return nil
+}
+
+func init() {
+	for _, c := range readOnlyCommands {
+		if c.Annotations == nil {
+			c.Annotations = map[string]string{}
+		}
+		c.Annotations[readOnlyAnnotation] = "yes"
+	}
+	rootCmd.PersistentFlags().Bool("read-only", false, "Only inspect and report: refuse writing files, ordering certificates and reloading services (also TRUSTTLS_READ_ONLY=1 or read_only: true in ~/.trusttls/config.yaml)")
+}
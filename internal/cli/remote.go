@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/apiclient"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// remoteAnnotation marks commands that can act on a remote server (see
+// trusttls serve) instead of the local store.
+const remoteAnnotation = "trusttls/remote"
+
+// remote is the API client when --remote is given, nil otherwise.
+var remote *apiclient.Client
+
+// connectRemote sets up remote from the global flags, and refuses commands
+// that cannot run remotely rather than letting them change the local host.
+func connectRemote(cmd *cobra.Command, args []string) error {
+	url, _ := cmd.Flags().GetString("remote")
+	if url == "" {
+		return nil
+	}
+	if cmd.Annotations[remoteAnnotation] == "" {
+		return fmt.Errorf("%s cannot run against --remote; use it on the server itself", cmd.CommandPath())
+	}
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("TRUSTTLS_TOKEN")
+	}
+	if token == "" {
+		// An agent enrolled with this server uses its certificate instead
+		id, err := apiclient.LoadIdentity(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if id != nil && strings.TrimSuffix(url, "/") == id.Server {
+			if err := rotateIfDue(id); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  Agent certificate not rotated: %v\n", err)
+			}
+			c, err := id.Client()
+			if err != nil {
+				return err
+			}
+			remote = c
+			return nil
+		}
+	}
+	caBundle, _ := cmd.Flags().GetString("remote-ca-bundle")
+	c, err := apiclient.NewClient(url, token, caBundle)
+	if err != nil {
+		return err
+	}
+	remote = c
+	return nil
+}
+
+// remoteCapable returns the annotations of a command that supports --remote.
+func remoteCapable() map[string]string {
+	return map[string]string{remoteAnnotation: "yes"}
+}
+
+// listLineages returns the lineages of the remote server with --remote, and
+// of the store in baseDir otherwise.
+func listLineages(baseDir string) ([]store.Lineage, error) {
+	if remote != nil {
+		return remote.Certificates()
+	}
+	return store.ListLineages(baseDir)
+}
+
+// storeLocation names where listLineages reads from, for messages.
+func storeLocation(baseDir string) string {
+	if remote != nil {
+		return remote.URL
+	}
+	return baseDir
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("remote", "", "Act on the trusttls server at this https:// URL instead of the local store")
+	rootCmd.PersistentFlags().String("token", "", "API token for --remote and serve (or TRUSTTLS_TOKEN)")
+	rootCmd.PersistentFlags().String("remote-ca-bundle", "", "PEM file with extra roots to trust for --remote, such as the server's ca.pem")
+}
@@ -2,9 +2,15 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/trustctl/trusttls/internal/jobs"
 	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
 )
 
 var renewCmd = &cobra.Command{
@@ -15,7 +21,8 @@ Check and renew SSL certificates that are due for renewal.
 
 This command:
 • Checks all your installed certificates
-• Renews certificates expiring within 30 days
+• Renews certificates expiring within 30 days, or their renew_before_days
+  (all of them with --force)
 • Automatically installs renewed certificates
 • Updates your web server configuration
 
@@ -24,13 +31,97 @@ Perfect for automated maintenance and cron jobs!
 Example:
   trusttls renew                    # Renew all due certificates
   trusttls renew --verbose          # Show detailed progress
+  trusttls renew --domain example.com  # Reissue one certificate now
+  trusttls renew --cert-name shop   # ...by the name it was issued with
+  trusttls renew --queue            # Queue due renewals for 'trusttls jobs run'
+  trusttls renew --run-hooks example.com  # Test deploy copies and hooks without reissuing
+  trusttls renew --fix-webroot      # Update moved webroots without asking
+  trusttls renew --force            # Renew every certificate now, due or not
+  trusttls renew --ignore-rate-limits  # Also renew certificates waiting out a rate limit
+  trusttls renew --dry-run          # Rehearse every renewal against staging without changing anything
+  trusttls renew --ca-workers letsencrypt=8 --workers 10  # Renew a large fleet faster
+  trusttls renew --remote https://trusttls.internal:8443 --token s3cret
+  trusttls renew --remote https://trusttls.internal:8443 --token s3cret --domain example.com
 
 Set up automatic renewal:
   Add to crontab: 0 2 * * * /usr/local/bin/trusttls renew
 `,
+	Annotations: remoteCapable(),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		verbose, _ := cmd.Flags().GetBool("verbose")
-		if err := renewal.RunAll(verbose); err != nil {
+		if remote != nil {
+			return renewRemote(cmd)
+		}
+		queue, _ := cmd.Flags().GetBool("queue")
+		hooksFor, _ := cmd.Flags().GetString("run-hooks")
+		renewal.ForceRenewal, _ = cmd.Flags().GetBool("force")
+		renewal.IgnoreRateLimits, _ = cmd.Flags().GetBool("ignore-rate-limits")
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			if queue || hooksFor != "" {
+				return fmt.Errorf("--dry-run cannot be used with --queue or --run-hooks")
+			}
+			domain, _ := cmd.Flags().GetString("domain")
+			if domain == "" {
+				domain, _ = cmd.Flags().GetString("cert-name")
+			}
+			return renewDryRun(domain, verbose)
+		}
+		if hooksFor != "" {
+			cfg, err := renewal.Find(hooksFor)
+			if err != nil {
+				return fmt.Errorf("no renewal config for %s: %w", hooksFor, err)
+			}
+			if cfg.DeployHook == "" && cfg.PostHook == "" && len(cfg.Deploy) == 0 {
+				fmt.Printf("ℹ️  No hooks or deploy copies configured for %s\n", hooksFor)
+				return nil
+			}
+			if err := renewal.RunHooks(cfg, true); err != nil {
+				return err
+			}
+			fmt.Printf("✅ Hooks for %s ran successfully (certificate not reissued)\n", hooksFor)
+			return nil
+		}
+		if queue {
+			cfgs, err := renewal.DueConfigs()
+			if err != nil {
+				return err
+			}
+			q := jobs.NewQueue(store.DefaultBaseDir())
+			for _, c := range cfgs {
+				j, err := q.Enqueue(c.Lineage(), c.Server)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("📥 Queued %s (job %s)\n", c.Lineage(), j.ID)
+			}
+			return nil
+		}
+		fixWebroot, _ := cmd.Flags().GetBool("fix-webroot")
+		if err := setWorkers(cmd); err != nil {
+			return err
+		}
+		var skipped []string
+		renewal.ConfirmWebrootRepair = webrootRepairConfirmer(fixWebroot, &skipped)
+		run := func() error { return renewal.RunAll(verbose) }
+		domain, _ := cmd.Flags().GetString("domain")
+		if domain == "" {
+			domain, _ = cmd.Flags().GetString("cert-name")
+		}
+		if domain != "" {
+			cfg, err := renewal.Find(domain)
+			if err != nil {
+				return fmt.Errorf("no renewal config for %s: %w", domain, err)
+			}
+			run = func() error { return renewal.Renew(cfg, verbose) }
+		}
+		if err := run(); err != nil {
+			showProblemHelp(err)
+			for _, s := range skipped {
+				fmt.Printf("💡 %s\n", s)
+			}
+			if len(skipped) > 0 {
+				fmt.Println("💡 Run 'trusttls renew --fix-webroot' to update these renewal configs.")
+			}
 			return err
 		}
 		fmt.Println("🎉 SSL certificate renewal completed!")
@@ -39,7 +130,151 @@ Set up automatic renewal:
 	},
 }
 
+// renewRemote asks the --remote server to renew, which runs with the
+// server's own configs; local-only flags are refused.
+func renewRemote(cmd *cobra.Command) error {
+	for _, name := range []string{"queue", "run-hooks", "fix-webroot", "force", "ignore-rate-limits", "dry-run", "workers", "ca-workers"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s cannot be used with --remote", name)
+		}
+	}
+	domain, _ := cmd.Flags().GetString("domain")
+	if domain == "" {
+		domain, _ = cmd.Flags().GetString("cert-name")
+	}
+	if domain == "" {
+		fmt.Printf("🛰️  Renewing due certificates on %s...\n", remote.URL)
+	} else {
+		fmt.Printf("🛰️  Renewing %s on %s...\n", domain, remote.URL)
+	}
+	if err := remote.Renew(domain); err != nil {
+		return err
+	}
+	fmt.Println("🎉 SSL certificate renewal completed!")
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(renewCmd)
+	renewCmd.Flags().String("domain", "", "Reissue only this certificate, now, instead of renewing what is due")
+	renewCmd.Flags().String("cert-name", "", "Same as --domain, for certificates kept under a --cert-name")
 	renewCmd.Flags().Bool("verbose", false, "Verbose output")
+	renewCmd.Flags().Bool("queue", false, "Queue due renewals as jobs instead of renewing now")
+	renewCmd.Flags().String("run-hooks", "", "Write the deploy copies and run the deploy and post hooks for this domain without reissuing")
+	renewCmd.Flags().Bool("force", false, "Renew every certificate, not only those due; also --force-renewal (rate limit holds still apply unless --ignore-rate-limits is given too)")
+	renewCmd.Flags().Bool("ignore-rate-limits", false, "Renew certificates waiting for a CA rate limit to pass, and order even when the issuance log says a Let's Encrypt rate limit would be exceeded")
+	// certbot's name for --force
+	renewCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "force-renewal" {
+			name = "force"
+		}
+		return pflag.NormalizedName(name)
+	})
+	renewCmd.Flags().Bool("dry-run", false, "Order test certificates from the CA's staging environment, solving the challenges, without storing them, running hooks or touching web servers")
+	renewCmd.Flags().Bool("fix-webroot", false, "When HTTP-01 fails because the webroot moved, switch to the newly detected webroot without asking")
+	renewCmd.Flags().Int("workers", 0, "Most orders running at once across all CAs (0: only the per-CA caps)")
+	renewCmd.Flags().StringToInt("ca-workers", nil, "Orders running at once per CA, e.g. letsencrypt=8,digicert=2 (defaults: letsencrypt=2, digicert=1, internal=4, others 1)")
+}
+
+// renewDryRun rehearses the renewal of domain's certificate, or of every
+// certificate, against staging and reports what renewing would have done.
+func renewDryRun(domain string, verbose bool) error {
+	var cfgs []renewal.Config
+	var errs []string
+	if domain != "" {
+		cfg, err := renewal.Find(domain)
+		if err != nil {
+			return fmt.Errorf("no renewal config for %s: %w", domain, err)
+		}
+		cfgs = []renewal.Config{cfg}
+	} else {
+		var err error
+		if cfgs, errs, err = renewal.Configs(); err != nil {
+			return err
+		}
+	}
+	fmt.Println("🧪 Dry run: test certificates come from staging; nothing is stored, no hooks run and web servers are left alone")
+	for _, e := range errs {
+		fmt.Printf("❌ %s\n", e)
+	}
+	failed, skipped := len(errs), 0
+	for _, c := range cfgs {
+		fmt.Println()
+		r, err := renewal.DryRun(c, verbose)
+		if err != nil {
+			failed++
+			fmt.Printf("❌ %s: %v\n", c.Lineage(), err)
+			showProblemHelp(err)
+			continue
+		}
+		if r.Skipped != "" {
+			skipped++
+			fmt.Printf("⏭️  %s: not rehearsed, %s\n", r.Lineage, r.Skipped)
+			continue
+		}
+		fmt.Printf("✅ %s: test certificate %s issued by %s\n", r.Lineage, r.Serial, r.Server)
+		fmt.Printf("   Names:    %s\n", strings.Join(r.Names, ", "))
+		fmt.Printf("   Expires:  %s\n", r.NotAfter.Local().Format("2006-01-02"))
+		if r.Due {
+			fmt.Printf("   Renewal:  due now; 'trusttls renew' would renew it\n")
+		} else {
+			fmt.Printf("   Renewal:  not due until %s\n", r.RenewAt.Local().Format("2006-01-02"))
+		}
+		for _, a := range r.Actions {
+			fmt.Printf("   Then:     %s\n", a)
+		}
+	}
+	fmt.Println()
+	if failed > 0 {
+		return fmt.Errorf("dry run failed for %d of %d certificate(s)", failed, len(cfgs)+len(errs))
+	}
+	if skipped > 0 {
+		fmt.Printf("💡 %d certificate(s) from CAs without a staging environment were not rehearsed; check their settings with 'trusttls config lint'\n", skipped)
+	}
+	if rehearsed := len(cfgs) - skipped; rehearsed > 0 {
+		fmt.Printf("🎉 Dry run passed for %d certificate(s)\n", rehearsed)
+	}
+	return nil
+}
+
+// setWorkers applies --workers and --ca-workers to renewal runs.
+func setWorkers(cmd *cobra.Command) error {
+	workers, _ := cmd.Flags().GetInt("workers")
+	caWorkers, _ := cmd.Flags().GetStringToInt("ca-workers")
+	if workers < 0 {
+		return fmt.Errorf("--workers must not be negative")
+	}
+	for ca, n := range caWorkers {
+		if n < 1 {
+			return fmt.Errorf("--ca-workers %s=%d: need at least 1", ca, n)
+		}
+	}
+	renewal.Workers, renewal.CAWorkers = workers, caWorkers
+	return nil
+}
+
+// webrootRepairConfirmer decides how renewals react to a moved webroot: apply
+// the new one with auto, ask when run from a terminal, and otherwise leave the
+// config alone and note the suggestion in skipped.
+func webrootRepairConfirmer(auto bool, skipped *[]string) func(domain, oldRoot, newRoot string) bool {
+	var mu sync.Mutex
+	interactive := stdinIsTerminal()
+	return func(domain, oldRoot, newRoot string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if auto {
+			fmt.Printf("🔧 Webroot for %s moved: %s -> %s\n", domain, oldRoot, newRoot)
+			return true
+		}
+		if interactive {
+			return NewUI(false).AskYesNo(fmt.Sprintf("Webroot for %s seems to have moved from %s to %s. Update the renewal config and retry?", domain, oldRoot, newRoot))
+		}
+		*skipped = append(*skipped, fmt.Sprintf("Webroot for %s seems to have moved: %s -> %s", domain, oldRoot, newRoot))
+		return false
+	}
+}
+
+func stdinIsTerminal() bool {
+	st, err := os.Stdin.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme/remotewebroot"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/replicate"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var replicateCmd = &cobra.Command{
+	Use:   "replicate",
+	Short: "Copy issued certificates to peer hosts",
+	Long: `
+Run TrustTLS on one primary host and let it copy every renewed certificate to
+the other members of a load-balanced cluster over SSH. After each renewal the
+certificate, chain, full chain and key are written to <dir>/<domain>/ on each
+peer and the peer's web server is reloaded.
+
+Peers are kept in ~/.trusttls/replication.yaml. The host key of each peer must
+already be in ~/.ssh/known_hosts (or the file given with known_hosts=).
+
+Example:
+  trusttls replicate add --name web2 --url 'ssh://deploy@web2.example.com?key=/root/.ssh/id_ed25519' \
+    --dir /etc/ssl/trusttls --target nginx
+  trusttls replicate list
+  trusttls replicate push                       # Copy all certificates now
+  trusttls replicate push --domain example.com --peer web2
+  trusttls replicate remove web2
+`,
+}
+
+var replicateAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add or update a peer",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var p replicate.Peer
+		p.Name, _ = cmd.Flags().GetString("name")
+		p.URL, _ = cmd.Flags().GetString("url")
+		p.Dir, _ = cmd.Flags().GetString("dir")
+		p.Target, _ = cmd.Flags().GetString("target")
+		p.Reload, _ = cmd.Flags().GetString("reload")
+		p.Domains, _ = cmd.Flags().GetStringSlice("domain")
+		p.NoKey, _ = cmd.Flags().GetBool("no-key")
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		base := store.DefaultBaseDir()
+		cfg, err := replicate.Load(base)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i := range cfg.Peers {
+			if cfg.Peers[i].Name == p.Name {
+				cfg.Peers[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			cfg.Peers = append(cfg.Peers, p)
+		}
+		if err := replicate.Save(base, cfg); err != nil {
+			return err
+		}
+		if replaced {
+			fmt.Printf("✅ Updated peer %s\n", p.Name)
+		} else {
+			fmt.Printf("✅ Added peer %s\n", p.Name)
+		}
+		fmt.Printf("💡 Run 'trusttls replicate push --peer %s' to copy the current certificates now\n", p.Name)
+		return nil
+	},
+}
+
+var replicateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List peers",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := replicate.Load(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if len(cfg.Peers) == 0 {
+			fmt.Println("📭 No peers configured")
+			return nil
+		}
+		for _, p := range cfg.Peers {
+			domains := "all certificates"
+			if len(p.Domains) > 0 {
+				domains = strings.Join(p.Domains, ", ")
+			}
+			fmt.Printf("🖥️  %s\n", p.Name)
+			fmt.Printf("   URL:     %s\n", remotewebroot.Redacted(p.URL))
+			fmt.Printf("   Dir:     %s\n", p.Dir)
+			fmt.Printf("   Sends:   %s\n", domains)
+			if p.NoKey {
+				fmt.Printf("   Key:     not sent\n")
+			}
+			if c := p.ReloadCommand(); c != "" {
+				fmt.Printf("   Reload:  %s\n", c)
+			}
+		}
+		return nil
+	},
+}
+
+var replicateRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a peer",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := store.DefaultBaseDir()
+		cfg, err := replicate.Load(base)
+		if err != nil {
+			return err
+		}
+		kept := cfg.Peers[:0]
+		for _, p := range cfg.Peers {
+			if p.Name != args[0] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == len(cfg.Peers) {
+			return fmt.Errorf("no peer named %s", args[0])
+		}
+		cfg.Peers = kept
+		if err := replicate.Save(base, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("🗑️  Removed peer %s (files already copied to it are left in place)\n", args[0])
+		return nil
+	},
+}
+
+var replicatePushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Copy certificates to peers now",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		peer, _ := cmd.Flags().GetString("peer")
+		base := store.DefaultBaseDir()
+		cfg, err := replicate.Load(base)
+		if err != nil {
+			return err
+		}
+		domains := []string{domain}
+		if domain == "" {
+			lineages, err := store.ListLineages(base)
+			if err != nil {
+				return err
+			}
+			domains = domains[:0]
+			for _, l := range lineages {
+				domains = append(domains, l.Name)
+			}
+		}
+		failed := 0
+		for _, p := range cfg.Peers {
+			if peer != "" && p.Name != peer {
+				continue
+			}
+			for _, d := range domains {
+				if !p.Wants(d) {
+					continue
+				}
+				err := replicate.PushTo(p, base, d)
+				rec := renewal.Deployment{Kind: "replica", Target: p.Name, Source: renewal.SourceRenewal}
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				_ = renewal.RecordDeployment(base, d, rec)
+				if err != nil {
+					fmt.Printf("❌ %s → %s: %v\n", d, p.Name, err)
+					failed++
+					continue
+				}
+				fmt.Printf("✅ %s → %s\n", d, p.Name)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d copies failed", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replicateCmd)
+	replicateCmd.AddCommand(replicateAddCmd, replicateListCmd, replicateRemoveCmd, replicatePushCmd)
+	replicateAddCmd.Flags().String("name", "", "Name of the peer")
+	replicateAddCmd.Flags().String("url", "", "ssh://user@host[:port][?key=<private key>&known_hosts=<file>]")
+	replicateAddCmd.Flags().String("dir", "/etc/ssl/trusttls", "Directory on the peer; each certificate goes to <dir>/<domain>/")
+	replicateAddCmd.Flags().String("target", "", "Web server on the peer (apache|nginx) to reload after an update")
+	replicateAddCmd.Flags().String("reload", "", "Command run on the peer after an update (overrides --target)")
+	replicateAddCmd.Flags().StringSlice("domain", nil, "Only send these certificates (repeatable; default: all)")
+	replicateAddCmd.Flags().Bool("no-key", false, "Do not send private keys to this peer")
+	replicatePushCmd.Flags().String("domain", "", "Only push this certificate")
+	replicatePushCmd.Flags().String("peer", "", "Only push to this peer")
+}
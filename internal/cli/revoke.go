@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a certificate with the CA that issued it",
+	Long: `
+Ask the CA that issued a managed certificate to revoke it, for example after
+its private key leaked. The CA and account are taken from the certificate's
+renewal config, so revoke works the same for every provider that supports
+it; DigiCert CertCentral and the internal CA do not.
+
+The certificate stays in the store and keeps renewing; run
+'trusttls renew --domain <name>' afterwards to replace it right away.
+
+Example:
+  trusttls revoke --domain example.com
+  trusttls revoke --domain example.com && trusttls renew --domain example.com
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		if domain == "" {
+			return fmt.Errorf("--domain is required")
+		}
+		c, err := renewal.Find(domain)
+		if err != nil {
+			return fmt.Errorf("no renewal config for %s: %w", domain, err)
+		}
+		b, err := store.Open(c.BaseDir).Load(c.Lineage())
+		if err != nil {
+			return err
+		}
+		certPEM := b.Cert
+		iss, err := renewal.Issuer(c)
+		if err != nil {
+			return err
+		}
+		if err := iss.Revoke(cmd.Context(), certPEM); err != nil {
+			return err
+		}
+		fmt.Printf("🚫 Certificate for %s revoked by %s\n", domain, iss.Capabilities().CA)
+		fmt.Printf("💡 Replace it now with: trusttls renew --domain %s\n", domain)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(revokeCmd)
+	revokeCmd.Flags().String("domain", "", "Primary name of the certificate to revoke")
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// archiveTimeLayout is how older versions named their timestamped archive
+// folders; --to still accepts it.
+const archiveTimeLayout = "20060102-150405"
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Serve an earlier archived certificate again",
+	Long: `
+Every certificate issued is kept in ~/.trusttls/archive/<domain>/ as a
+numbered version. rollback points live/ back at one of them and delivers it
+like a renewal does: Apache and Nginx are reloaded when their configs load
+the certificate, HAProxy gets it over its runtime API and the deploy hook
+runs. Use it when a renewal brought a broken chain or a certificate clients
+reject.
+
+--to takes a version number from --list, or a time such as 20240801-120000
+to go back to the newest certificate issued before it. Without --to the
+version before the current one is used. Nothing is deleted: roll forward
+again the same way, or let the next renewal replace it.
+
+Example:
+  trusttls rollback --domain example.com --list
+  trusttls rollback --domain example.com
+  trusttls rollback --domain example.com --to 3
+  trusttls rollback --domain example.com --to 20240801-120000
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		to, _ := cmd.Flags().GetString("to")
+		list, _ := cmd.Flags().GetBool("list")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		if domain == "" {
+			return fmt.Errorf("--domain is required")
+		}
+		c, err := renewal.Find(domain)
+		if err != nil {
+			return fmt.Errorf("no renewal config for %s: %w", domain, err)
+		}
+		versions, err := store.ListVersions(c.BaseDir, c.Lineage())
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("%s has no archived certificates", c.Lineage())
+		}
+		if list {
+			printVersions(versions)
+			return nil
+		}
+		n, err := rollbackTarget(c, versions, to)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v.N == n && v.Current {
+				fmt.Printf("ℹ️  %s already uses version %d\n", c.Lineage(), n)
+				return nil
+			}
+		}
+		if err := renewal.Rollback(c, n, verbose); err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v.N == n {
+				fmt.Printf("⏪ %s now uses version %d (serial %s, expires %s)\n", c.Lineage(), n, v.Serial, describeExpiry(v.NotAfter))
+				fmt.Printf("💡 Renewal replaces it from %s; fix what broke the newer certificate before then\n", renewal.RenewAt(c, v.NotAfter).Local().Format("2006-01-02"))
+			}
+		}
+		return nil
+	},
+}
+
+// rollbackTarget returns the version to to names, or the one before the
+// current version when to is empty.
+func rollbackTarget(c renewal.Config, versions []store.Version, to string) (int, error) {
+	if to == "" {
+		prev := 0
+		for _, v := range versions {
+			if v.Current {
+				break
+			}
+			prev = v.N
+		}
+		if prev == 0 {
+			return 0, fmt.Errorf("%s has no version before the current one", c.Lineage())
+		}
+		return prev, nil
+	}
+	if n, err := strconv.Atoi(to); err == nil {
+		return n, nil
+	}
+	t, err := time.ParseInLocation(archiveTimeLayout, to, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("--to %q: use a version number or a time such as 20240801-120000", to)
+	}
+	return store.VersionAt(c.BaseDir, c.Lineage(), t)
+}
+
+func printVersions(versions []store.Version) {
+	for _, v := range versions {
+		mark := "  "
+		if v.Current {
+			mark = "👉"
+		}
+		key := ""
+		if !v.HasKey {
+			key = " (no key)"
+		}
+		fmt.Printf("%s %3d  issued %s  expires %s  serial %s%s\n", mark, v.N,
+			v.NotBefore.Local().Format("2006-01-02 15:04"), v.NotAfter.Local().Format("2006-01-02 15:04"), v.Serial, key)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().String("domain", "", "Certificate to roll back")
+	rollbackCmd.Flags().String("to", "", "Version number or time (20240801-120000) to go back to (default: the version before the current one)")
+	rollbackCmd.Flags().Bool("list", false, "List the archived versions and exit")
+	rollbackCmd.Flags().Bool("verbose", false, "Verbose output")
+}
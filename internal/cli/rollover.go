@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var rolloverCmd = &cobra.Command{
+	Use:   "rollover",
+	Short: "Keep the previous certificate installed after a renewal",
+	Long: `
+With a soak period set (--soak on setup or get-cert, or soak: in the
+renewal config), each renewal keeps the certificate it replaces in
+~/.trusttls/live/<domain>/previous/. renew checks that port 443 serves the
+new certificate and removes previous/ once it has been served for the whole
+soak period.
+
+Until then, revert switches the Apache and Nginx configs to previous/ and
+reloads the web server, without waiting for a new certificate; resume
+switches them back.
+
+Example:
+  trusttls setup --domain example.com --email admin@example.com --soak 72h
+  trusttls rollover status
+  trusttls rollover revert --domain example.com
+  trusttls rollover resume --domain example.com
+  trusttls rollover prune --domain example.com   # Drop previous/ now
+`,
+}
+
+var rolloverStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show rollovers in progress",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rollovers, err := renewal.ListRollovers(store.DefaultBaseDir())
+		if err != nil {
+			return err
+		}
+		if len(rollovers) == 0 {
+			fmt.Println("📭 No rollovers in progress")
+			return nil
+		}
+		for _, r := range rollovers {
+			soak := "not set"
+			if c, err := renewal.Load(r.Domain); err == nil && c.Soak != "" {
+				soak = c.Soak
+			}
+			fmt.Printf("🔁 %s\n", r.Domain)
+			fmt.Printf("   Versions: %d → %d (renewed %s)\n", r.Previous, r.Current, r.Started.Format(time.RFC3339))
+			fmt.Printf("   Soak:     %s\n", soak)
+			switch {
+			case r.Reverted:
+				fmt.Printf("   State:    ⏪ reverted, web server uses %s\n", store.PreviousDir(store.DefaultBaseDir(), r.Domain))
+			case r.ServedSince.IsZero():
+				fmt.Printf("   State:    ⏳ new certificate not seen on port 443 yet\n")
+			default:
+				fmt.Printf("   State:    ✅ new certificate served since %s\n", r.ServedSince.Format(time.RFC3339))
+			}
+		}
+		return nil
+	},
+}
+
+var rolloverRevertCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Serve the previous certificate again",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		base := store.DefaultBaseDir()
+		r, err := rolloverFor(base, domain)
+		if err != nil {
+			return err
+		}
+		if r.Reverted {
+			fmt.Printf("ℹ️  %s already uses its previous certificate\n", domain)
+			return nil
+		}
+		live := filepath.Dir(store.PreviousDir(base, domain))
+		switchCertificatePaths(live, store.PreviousDir(base, domain))
+		r.Reverted = true
+		r.ServedSince = time.Time{}
+		if err := renewal.SaveRollover(base, r); err != nil {
+			return err
+		}
+		fmt.Printf("⏪ %s reverted to certificate version %d\n", domain, r.Previous)
+		fmt.Printf("💡 Other services can use the files in %s\n", store.PreviousDir(base, domain))
+		fmt.Printf("💡 Run 'trusttls rollover resume --domain %s' to go back to the new certificate\n", domain)
+		return nil
+	},
+}
+
+var rolloverResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Serve the current certificate after a revert",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		base := store.DefaultBaseDir()
+		r, err := rolloverFor(base, domain)
+		if err != nil {
+			return err
+		}
+		if !r.Reverted {
+			fmt.Printf("ℹ️  %s already uses its current certificate\n", domain)
+			return nil
+		}
+		switchCertificatePaths(store.PreviousDir(base, domain), filepath.Dir(store.PreviousDir(base, domain)))
+		r.Reverted = false
+		if err := renewal.SaveRollover(base, r); err != nil {
+			return err
+		}
+		fmt.Printf("⏩ %s uses certificate version %d again; the soak period restarts once it is served\n", domain, r.Current)
+		return nil
+	},
+}
+
+var rolloverPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the previous certificate without waiting for the soak period",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		base := store.DefaultBaseDir()
+		r, err := rolloverFor(base, domain)
+		if err != nil {
+			return err
+		}
+		if r.Reverted {
+			return fmt.Errorf("%s is reverted to its previous certificate; run 'trusttls rollover resume --domain %s' first", domain, domain)
+		}
+		if err := renewal.FinishRollover(base, domain); err != nil {
+			return err
+		}
+		fmt.Printf("🧹 Removed the previous certificate of %s (archived versions are kept)\n", domain)
+		return nil
+	},
+}
+
+func rolloverFor(base, domain string) (*renewal.Rollover, error) {
+	if domain == "" {
+		return nil, fmt.Errorf("--domain is required")
+	}
+	r, err := renewal.LoadRollover(base, domain)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("no rollover in progress for %s", domain)
+	}
+	return r, nil
+}
+
+// switchCertificatePaths points Apache and Nginx configs that use the files
+// in from at to instead and reloads the servers whose configs changed.
+func switchCertificatePaths(from, to string) {
+	if !renewal.SwitchCertificatePaths(from, to) {
+		fmt.Printf("ℹ️  No Apache or Nginx config uses %s\n", from)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(rolloverCmd)
+	rolloverCmd.AddCommand(rolloverStatusCmd, rolloverRevertCmd, rolloverResumeCmd, rolloverPruneCmd)
+	for _, c := range []*cobra.Command{rolloverRevertCmd, rolloverResumeCmd, rolloverPruneCmd} {
+		c.Flags().String("domain", "", "Certificate to act on")
+	}
+}
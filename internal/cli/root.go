@@ -1,10 +1,17 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/seal"
+	"github.com/trustctl/trusttls/internal/store"
 )
 
 var rootCmd = &cobra.Command{
@@ -27,7 +34,7 @@ Supports Let's Encrypt (free) and DigiCert (commercial) providers.
 }
 
 func Execute() {
-	if len(os.Args) > 1 && os.Args[1] != "--help" && os.Args[1] != "-h" {
+	if len(os.Args) > 1 && os.Args[1] != "--help" && os.Args[1] != "-h" && !machineOutput(os.Args[1:]) {
 		fmt.Println(`
 ╔══════════════════════════════════════════════════════════════╗
 ║                    🔒 TrustTLS v1.0                          ║
@@ -37,7 +44,129 @@ func Execute() {
 	}
 	
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		if jsonOutput(os.Args[1:]) {
+			// Scripts get the error, and its code when the CA reported a
+			// known problem, on stdout where they expect the result
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(struct {
+				Error string `json:"error"`
+				Code  string `json:"code,omitempty"`
+			}{err.Error(), acme.ErrorCode(err)})
+		} else {
+			fmt.Println(err)
+		}
 		os.Exit(1)
 	}
 }
+
+// preRun runs before every command: read-only mode is settled first, so a
+// refused command never reaches a remote server either.
+func preRun(cmd *cobra.Command, args []string) error {
+	if err := useConfigDir(cmd); err != nil {
+		return err
+	}
+	if err := store.CheckOwner(store.DefaultBaseDir()); err != nil {
+		return err
+	}
+	if dir := store.ConfigDir(); dir != store.DefaultBaseDir() {
+		// Renewal configs name hooks run as this user
+		if err := store.CheckOwner(dir); err != nil {
+			return err
+		}
+	}
+	if err := enforceReadOnly(cmd); err != nil {
+		return err
+	}
+	if err := checkStoreFormat(); err != nil {
+		return err
+	}
+	if cmd != serveCmd {
+		// Ctrl-C during an order takes back its challenge files and DNS
+		// records; serve shuts down on its own
+		interrupt.Watch()
+	}
+	if proxy, _ := cmd.Flags().GetString("proxy"); proxy != "" {
+		if err := acme.SetProxy(proxy); err != nil {
+			return err
+		}
+	}
+	if err := seal.Use(store.DefaultBaseDir()); err != nil {
+		return err
+	}
+	return connectRemote(cmd, args)
+}
+
+// useConfigDir points the store at --config-dir when given. TRUSTTLS_DIR is
+// set to it too, so hooks and trusttls commands they run use the same store.
+// state_dir in its config.yaml can move certificates, accounts and keys
+// elsewhere; renewal configs and settings stay in the directory.
+func useConfigDir(cmd *cobra.Command) error {
+	dir, _ := cmd.Flags().GetString("config-dir")
+	if dir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	store.SetBaseDir(dir)
+	return os.Setenv("TRUSTTLS_DIR", dir)
+}
+
+// checkStoreFormat refuses a store written by a newer trusttls and
+// upgrades one written by an older one, so switching versions never
+// corrupts it. Read-only commands only warn: they change nothing.
+func checkStoreFormat() error {
+	base := store.DefaultBaseDir()
+	if readonly.Enabled() {
+		found, _, err := store.StoredFormat(base)
+		if err != nil {
+			return err
+		}
+		if found > store.Format {
+			fmt.Fprintf(os.Stderr, "⚠️  %v\n", &store.FormatError{Dir: base, Found: found})
+		} else if found < store.Format {
+			fmt.Fprintf(os.Stderr, "⚠️  %s uses store format %d; the next command run without --read-only upgrades it to %d\n", base, found, store.Format)
+		}
+		return nil
+	}
+	up, err := store.OpenFormat(base)
+	if err != nil {
+		return err
+	}
+	if up != nil {
+		fmt.Fprintf(os.Stderr, "📦 Upgraded %s from store format %d to %d; the store as it was is kept in %s\n", base, up.From, up.To, up.Backup)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentPreRunE = preRun
+	rootCmd.PersistentFlags().String("config-dir", "", "Keep certificates, accounts and renewal configs in this directory instead of ~/.trusttls (or TRUSTTLS_DIR); state_dir in its config.yaml moves all but the settings")
+	rootCmd.PersistentFlags().String("proxy", "", "Send requests to CAs, DNS providers and --remote through this http://, https:// or socks5:// proxy (default: HTTPS_PROXY, HTTP_PROXY, except NO_PROXY hosts)")
+}
+
+// jsonOutput reports whether the arguments ask for JSON output.
+func jsonOutput(args []string) bool {
+	for _, a := range args {
+		if a == "--json" || a == "--json=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// machineOutput reports whether the arguments ask for output that other
+// programs parse, which the banner would break.
+func machineOutput(args []string) bool {
+	if len(args) > 0 && args[0] == "generate-sudoers" {
+		return true
+	}
+	for _, a := range args {
+		if a == "--nagios" || a == "--nagios=true" {
+			return true
+		}
+	}
+	return jsonOutput(args)
+}
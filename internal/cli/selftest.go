@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/notify"
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/pebble"
+	"github.com/trustctl/trusttls/internal/selftest"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var selfTestCmd = &cobra.Command{
+	Use:   "self-test",
+	Short: "Issue, install, renew and revoke a test certificate end to end",
+	Long: `
+Run the whole path a certificate takes through trusttls against an ACME CA:
+register an account, order a certificate, answer its challenge, store it,
+install it to a scratch directory and check that certificate, key and chain
+belong together, then renew it the same way and revoke both. Everything
+happens in a store of its own that is removed afterwards (keep it with
+--keep); your certificates, renewal configs and web servers are not touched.
+
+With --pebble, trusttls starts Pebble, the ACME test server from Let's
+Encrypt, on the loopback interface and orders from it. Pebble accepts the
+challenges without reaching out, so the test needs no DNS, no open port 80
+and no network at all. Install Pebble with:
+  go install github.com/letsencrypt/pebble/v2/cmd/pebble@latest
+
+Without --pebble, give the ACME server with --server, or --staging for
+Let's Encrypt's staging environment, and a --domain set aside for the test.
+Its challenge is answered through --webroot, --dns or, without either, on
+port 80 of this host (or --http-port, when port 80 is forwarded there). --acme-ca-cert trusts a CA the system does not, such
+as a Pebble you run yourself.
+
+With --every the test becomes a scheduled check for cron: it only runs once
+that long has passed since the last run (or a day after a failed one), and
+a failure is sent to the notify channels, so a broken pipeline is noticed
+long before a real certificate is due.
+
+Example:
+  trusttls self-test --pebble
+  trusttls self-test --pebble --pebble-bin ~/go/bin/pebble --keep
+  trusttls self-test --server https://localhost:14000/dir --acme-ca-cert pebble.minica.pem \
+    --domain selftest.example.com --email admin@example.com
+  trusttls self-test --staging --domain selftest.example.com --email admin@example.com \
+    --webroot /var/www/html --every 168h
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		usePebble, _ := cmd.Flags().GetBool("pebble")
+		pebbleBin, _ := cmd.Flags().GetString("pebble-bin")
+		server, _ := cmd.Flags().GetString("server")
+		staging, _ := cmd.Flags().GetBool("staging")
+		caCert, _ := cmd.Flags().GetString("acme-ca-cert")
+		domain, _ := cmd.Flags().GetString("domain")
+		email, _ := cmd.Flags().GetString("email")
+		webroot, _ := cmd.Flags().GetString("webroot")
+		dnsPlugin, _ := cmd.Flags().GetString("dns")
+		dnsCredentials, _ := cmd.Flags().GetString("dns-credentials")
+		every, _ := cmd.Flags().GetDuration("every")
+		keep, _ := cmd.Flags().GetBool("keep")
+
+		if staging {
+			if server != "" {
+				return fmt.Errorf("--staging and --server both name the ACME server; give one")
+			}
+			server = acme.LetsEncryptStaging
+		}
+		if usePebble && (server != "" || caCert != "") {
+			return fmt.Errorf("--pebble runs its own server; drop --server, --staging and --acme-ca-cert")
+		}
+		if !usePebble && (server == "" || domain == "" || email == "") {
+			return fmt.Errorf("give --pebble, or --server (or --staging), --domain and --email")
+		}
+		if webroot != "" && dnsPlugin != "" {
+			return fmt.Errorf("--webroot and --dns are two ways to validate; give one")
+		}
+		if every < 0 {
+			return fmt.Errorf("--every must be positive")
+		}
+		standaloneAddress, err := standaloneListen(cmd, webroot == "" && dnsPlugin == "")
+		if err != nil {
+			return err
+		}
+
+		base := store.DefaultBaseDir()
+		var st *selftest.State
+		if every > 0 {
+			var err error
+			if st, err = selftest.LoadState(base); err != nil {
+				return err
+			}
+			if next := st.Next(every); time.Now().Before(next) {
+				fmt.Printf("⏭️  Self-test not due until %s\n", next.Local().Format(time.RFC1123))
+				return nil
+			}
+		}
+
+		dir, err := os.MkdirTemp("", "trusttls-selftest-")
+		if err != nil {
+			return err
+		}
+		if keep {
+			defer fmt.Printf("🔍 Store and installed files kept in: %s\n", dir)
+		} else {
+			defer os.RemoveAll(dir)
+		}
+		opts := selftest.Options{
+			Server:     server,
+			CABundle:   caCert,
+			Email:      email,
+			Domain:     domain,
+			Webroot:    webroot,
+			DNSPlugin:  dnsPlugin,
+			Standalone: standaloneAddress,
+			Dir:        dir,
+		}
+		if usePebble {
+			srv, err := pebble.Start(pebbleBin, filepath.Join(dir, "pebble"))
+			if err != nil {
+				return err
+			}
+			defer srv.Stop()
+			fmt.Printf("🧪 Pebble is running at %s\n", srv.Directory)
+			if opts.Roots, err = srv.Roots(); err != nil {
+				return err
+			}
+			opts.Server, opts.CABundle = srv.Directory, srv.CACert
+			if webroot == "" && dnsPlugin == "" && !cmd.Flags().Changed("http-port") && !cmd.Flags().Changed("http-address") {
+				// Nothing reaches the challenge server; any free port will do
+				opts.Standalone = "127.0.0.1:0"
+			}
+			if opts.Domain == "" {
+				opts.Domain = "selftest.trusttls.test"
+			}
+			if opts.Email == "" {
+				opts.Email = "selftest@trusttls.test"
+			}
+		} else {
+			if caCert != "" {
+				if _, err := acme.LoadCABundle(caCert); err != nil {
+					return err
+				}
+				opts.CABundle, _ = filepath.Abs(caCert)
+			}
+			if webroot == "" && dnsPlugin == "" {
+				announceStandalone(standaloneAddress)
+			}
+		}
+		if dnsPlugin != "" {
+			if dnsCredentials == "" {
+				if p := dnsprovider.CredentialsPath(base, dnsPlugin); osutil.FileExists(p) {
+					dnsCredentials = p
+				}
+			}
+			if opts.DNSCredentials, err = dnsprovider.LoadCredentials(dnsCredentials); err != nil {
+				return fmt.Errorf("load DNS credentials: %w", err)
+			}
+			if _, err := dnsprovider.New(dnsPlugin, opts.DNSCredentials); err != nil {
+				return err
+			}
+		}
+
+		res, err := selftest.Run(cmd.Context(), opts)
+		if st != nil {
+			recordSelfTest(base, st, opts, err)
+		}
+		if err != nil {
+			showProblemHelp(err)
+			return fmt.Errorf("self-test failed: %w", err)
+		}
+		fmt.Printf("🎉 Self-test passed in %s: %s issued, installed, renewed and revoked\n", res.Took.Round(time.Millisecond), opts.Domain)
+		return nil
+	},
+}
+
+// recordSelfTest keeps the outcome of a scheduled self-test for the next
+// run, and alerts the notify channels when the pipeline broke or works
+// again.
+func recordSelfTest(base string, st *selftest.State, opts selftest.Options, runErr error) {
+	failing := st.Error != ""
+	now := time.Now().UTC()
+	st.LastRun, st.Error = now, ""
+	if runErr != nil {
+		st.Error = runErr.Error()
+	} else {
+		st.LastSuccess = &now
+	}
+	if err := selftest.SaveState(base, st); err != nil {
+		fmt.Printf("⚠️  Self-test result not recorded: %v\n", err)
+	}
+	host, _ := os.Hostname()
+	var subject, body string
+	switch {
+	case runErr != nil:
+		subject = fmt.Sprintf("trusttls: self-test failed on %s", host)
+		body = fmt.Sprintf("Issuing a test certificate for %s from %s failed:\n\n%v\n\n"+
+			"Renewals on %s that take the same path are likely to fail too. Fix the problem, then check with:\n\n"+
+			"  trusttls self-test --server %s --domain %s --email %s\n", opts.Domain, opts.Server, runErr, host, opts.Server, opts.Domain, opts.Email)
+	case failing:
+		subject = fmt.Sprintf("trusttls: self-test passes again on %s", host)
+		body = fmt.Sprintf("A test certificate for %s was issued, installed, renewed and revoked through %s again.\n", opts.Domain, opts.Server)
+	default:
+		return
+	}
+	if err := notify.Alert(base, subject, body); err != nil {
+		fmt.Printf("⚠️  Self-test alert not sent: %v\n", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(selfTestCmd)
+	selfTestCmd.Flags().Bool("pebble", false, "Start Pebble on the loopback interface and test against it, without network or open ports")
+	selfTestCmd.Flags().String("pebble-bin", "", "Pebble binary to run (default: pebble in PATH)")
+	selfTestCmd.Flags().String("server", "", "ACME directory URL to test against")
+	selfTestCmd.Flags().Bool("staging", false, "Test against Let's Encrypt's staging environment")
+	selfTestCmd.Flags().String("acme-ca-cert", "", "PEM file with root certificates to trust for the ACME server, e.g. Pebble's pebble.minica.pem")
+	selfTestCmd.Flags().String("domain", "", "Name set aside for the test certificate (default with --pebble: selftest.trusttls.test)")
+	selfTestCmd.Flags().String("email", "", "Email address for the test account")
+	selfTestCmd.Flags().String("webroot", "", "Website folder serving --domain, to validate through instead of port 80")
+	selfTestCmd.Flags().Int("http-port", standalonePort, httpPortUsage)
+	selfTestCmd.Flags().String("http-address", "", httpAddressUsage)
+	selfTestCmd.Flags().String("dns", "", "Validate --domain with DNS-01 using this DNS provider")
+	selfTestCmd.Flags().String("dns-credentials", "", "Credentials file for the DNS provider (default: the one kept in the store)")
+	selfTestCmd.Flags().Duration("every", 0, "Run only when this long has passed since the last run, and alert the notify channels on failure (e.g. 168h from a daily cron job)")
+	selfTestCmd.Flags().Bool("keep", false, "Keep the test's store and installed files for a look afterwards")
+}
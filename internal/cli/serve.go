@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/api"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/store"
+	"github.com/trustctl/trusttls/internal/systemd"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the store over HTTPS so other machines can drive it with --remote",
+	Long: `
+Run the trusttls API server. The CLI on another machine (an operator's
+laptop, say) can then list and renew this host's certificates by adding
+--remote and --token to certificates, check-expiry and renew.
+
+Every request must carry the token or come from an enrolled agent, which
+authenticates with a client certificate from the server's agent CA (see
+trusttls agent). Without --token only agents are let in. The server's own
+certificate comes from an internal CA kept in the store; give clients its
+ca.pem with --remote-ca-bundle.
+
+Renewals and orders started through the API are queued jobs (see trusttls
+jobs), so serve retries failed renewals with backoff and resumes orders a
+restart interrupted; it runs due jobs every minute.
+
+Under systemd, serve takes its sockets from a socket unit instead of
+--listen and, with Type=notify, reports when it is ready, answers the
+watchdog and shuts down cleanly on SIGTERM. See the README for units that
+run it as a dynamic user.
+
+Example:
+  TRUSTTLS_TOKEN=s3cret trusttls serve --hostname trusttls.internal
+  trusttls certificates --remote https://trusttls.internal:8443 \
+    --token s3cret --remote-ca-bundle api-ca.pem
+  trusttls serve --hostname trusttls.internal   # agents only
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listen, _ := cmd.Flags().GetString("listen")
+		hostname, _ := cmd.Flags().GetString("hostname")
+		token, _ := cmd.Flags().GetString("token")
+		if token == "" {
+			token = os.Getenv("TRUSTTLS_TOKEN")
+		}
+		if hostname == "" {
+			return fmt.Errorf("--hostname is required (used for the server's TLS certificate)")
+		}
+
+		storeDir := store.DefaultBaseDir()
+		srv := &api.Server{
+			BaseDir: storeDir,
+			Token:   token,
+			Log:     func(line string) { log.Println(line) },
+		}
+		handler, err := srv.Handler()
+		if err != nil {
+			return err
+		}
+		authority, err := ca.LoadOrCreate(storeDir, ca.APICA)
+		if err != nil {
+			return fmt.Errorf("load %s CA: %w", ca.APICA, err)
+		}
+		leaf, err := authority.Issue(ca.LeafRequest{Domains: []string{hostname}, Lifetime: 90 * 24 * time.Hour})
+		if err != nil {
+			return err
+		}
+		tlsCert, err := tls.X509KeyPair(append(leaf.Certificate, leaf.IssuerCertificate...), leaf.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		tlsConfig, err := srv.TLSConfig(tlsCert)
+		if err != nil {
+			return err
+		}
+
+		listeners, err := systemd.Listeners()
+		if err != nil {
+			return err
+		}
+		if len(listeners) == 0 {
+			l, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+			listeners = []net.Listener{l}
+		} else {
+			fmt.Printf("🔌 Using %d socket(s) from systemd; --listen is ignored\n", len(listeners))
+		}
+
+		apiURL := fmt.Sprintf("https://%s%s/v1/", hostname, portSuffix(listeners[0].Addr().String()))
+		fmt.Printf("🏛️  CA certificate: %s\n", filepath.Join(authority.Dir, "ca.pem"))
+		fmt.Printf("🛰️  API: %s\n", apiURL)
+		if token == "" {
+			fmt.Printf("🤖 No --token: only enrolled agents can connect (trusttls agent token)\n")
+		}
+		hs := &http.Server{
+			Handler:           handler,
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		errs := make(chan error, len(listeners))
+		for _, l := range listeners {
+			go func(l net.Listener) { errs <- hs.ServeTLS(l, "", "") }(l)
+		}
+		go func() {
+			for {
+				if n, err := srv.RunJobs(); err != nil {
+					log.Printf("queued jobs: %v", err)
+				} else if n > 0 {
+					log.Printf("processed %d queued job(s)", n)
+				}
+				time.Sleep(time.Minute)
+			}
+		}()
+		notifySystemd("READY=1\nSTATUS=Serving " + apiURL)
+		if interval := systemd.WatchdogInterval(); interval > 0 {
+			go func() {
+				for range time.Tick(interval) {
+					notifySystemd("WATCHDOG=1")
+				}
+			}()
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		select {
+		case err := <-errs:
+			return err
+		case sig := <-stop:
+			log.Printf("%s: shutting down", sig)
+		}
+		notifySystemd("STOPPING=1")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return hs.Shutdown(ctx)
+	},
+}
+
+// notifySystemd passes state to systemd when serve runs as a Type=notify
+// service; a failure only costs the status, so it is logged and ignored.
+func notifySystemd(state string) {
+	if err := systemd.Notify(state); err != nil {
+		log.Println(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("listen", ":8443", "HTTPS listen address for the API")
+	serveCmd.Flags().String("hostname", "", "Host name clients use to reach this server")
+}
@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var generateSudoersCmd = &cobra.Command{
+	Use:   "generate-sudoers",
+	Short: "Print a minimal sudoers entry for running TrustTLS without root",
+	Long: `
+Run TrustTLS as an ordinary user and give it root only for the steps that
+need it. With TRUSTTLS_SUDO=1 set, TrustTLS writes web server configs and
+reloads the server through "sudo -n"; this command prints the sudoers entry
+allowing exactly those commands, with exact paths, for the certificates in
+the store (or those given with --domain).
+
+Deploy hooks that start with "sudo" are included as written.
+
+Re-run it after adding certificates: each certificate has its own config
+file.
+
+Example:
+  trusttls generate-sudoers --user trusttls > trusttls.sudoers
+  sudo visudo -cf trusttls.sudoers && sudo install -m 0440 trusttls.sudoers /etc/sudoers.d/trusttls
+  TRUSTTLS_SUDO=1 trusttls renew
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user, _ := cmd.Flags().GetString("user")
+		webServer, _ := cmd.Flags().GetString("web-server")
+		domains, _ := cmd.Flags().GetStringSlice("domain")
+		if user == "" {
+			user = os.Getenv("USER")
+		}
+		if user == "" || user == "root" {
+			return fmt.Errorf("--user must name the unprivileged account that runs trusttls")
+		}
+		if len(domains) == 0 {
+			lineages, err := store.ListLineages(store.DefaultBaseDir())
+			if err != nil {
+				return err
+			}
+			for _, l := range lineages {
+				domains = append(domains, l.Name)
+			}
+		}
+
+		var servers []string
+		switch webServer {
+		case "auto":
+			if osutil.CommandExists("apache2") || osutil.CommandExists("apachectl") || osutil.CommandExists("httpd") {
+				servers = append(servers, "apache")
+			}
+			if osutil.CommandExists("nginx") {
+				servers = append(servers, "nginx")
+			}
+		case "apache", "nginx":
+			servers = []string{webServer}
+		case "none":
+		default:
+			return fmt.Errorf("--web-server must be auto, apache, nginx or none")
+		}
+
+		var write, reload, hooks []string
+		for _, s := range servers {
+			var cmds [][]string
+			switch s {
+			case "apache":
+				cmds = apache.ReloadCommands()
+				for _, d := range domains {
+					conf := apache.ConfigFile(d)
+					write = append(write, sudoersCommand(osutil.PrivilegedCommand("tee", conf)))
+					write = append(write, sudoersCommand(osutil.PrivilegedCommand("mkdir", "-p", filepath.Dir(conf))))
+					if link := apache.EnabledLink(d); link != "" {
+						write = append(write, sudoersCommand(osutil.PrivilegedCommand("ln", "-sf", conf, link)))
+					}
+				}
+			case "nginx":
+				cmds = nginx.ReloadCommands()
+				for _, d := range domains {
+					conf := nginx.ConfigFile(d)
+					write = append(write, sudoersCommand(osutil.PrivilegedCommand("tee", conf)))
+					write = append(write, sudoersCommand(osutil.PrivilegedCommand("mkdir", "-p", filepath.Dir(conf))))
+				}
+			}
+			for _, c := range cmds {
+				reload = append(reload, sudoersCommand(c))
+			}
+		}
+		for _, d := range domains {
+			cfg, err := renewal.Load(d)
+			if err != nil {
+				continue
+			}
+			for _, h := range []string{cfg.DeployHook, cfg.PostHook} {
+				if c, ok := sudoHook(h); ok {
+					hooks = append(hooks, sudoersCommand(c))
+				}
+			}
+		}
+
+		fmt.Printf("# sudoers entry for running TrustTLS as %s (TRUSTTLS_SUDO=1)\n", user)
+		fmt.Printf("# Generated by trusttls generate-sudoers for: %s\n", strings.Join(domains, ", "))
+		fmt.Printf("# Check with visudo -cf before installing into /etc/sudoers.d/\n")
+		var aliases []string
+		for _, a := range []struct {
+			name string
+			cmds []string
+		}{
+			{"TRUSTTLS_CONFIG", write},
+			{"TRUSTTLS_RELOAD", reload},
+			{"TRUSTTLS_HOOKS", hooks},
+		} {
+			cmds := uniqueSorted(a.cmds)
+			if len(cmds) == 0 {
+				continue
+			}
+			fmt.Printf("Cmnd_Alias %s = \\\n    %s\n", a.name, strings.Join(cmds, ", \\\n    "))
+			aliases = append(aliases, a.name)
+		}
+		if len(aliases) == 0 {
+			fmt.Printf("# Nothing needs root: no web server and no sudo hooks found\n")
+			return nil
+		}
+		fmt.Printf("%s ALL=(root) NOPASSWD: %s\n", user, strings.Join(aliases, ", "))
+		return nil
+	},
+}
+
+// sudoHook returns the command a "sudo ..." hook runs, when the hook is a
+// single plain command that a sudoers entry can match exactly.
+func sudoHook(hook string) ([]string, bool) {
+	fields := strings.Fields(hook)
+	if len(fields) < 2 || fields[0] != "sudo" || strings.ContainsAny(hook, "|&;<>$`()") {
+		return nil, false
+	}
+	args := fields[1:]
+	if args[0] == "-n" {
+		args = args[1:]
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return nil, false
+	}
+	return osutil.PrivilegedCommand(args[0], args[1:]...), true
+}
+
+// sudoersCommand formats argv for a Cmnd_Alias, escaping the characters
+// sudoers treats specially.
+func sudoersCommand(argv []string) string {
+	r := strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`, `=`, `\=`)
+	out := make([]string, len(argv))
+	for i, a := range argv {
+		out[i] = r.Replace(a)
+	}
+	return strings.Join(out, " ")
+}
+
+func uniqueSorted(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	rootCmd.AddCommand(generateSudoersCmd)
+	generateSudoersCmd.Flags().String("user", "", "Account that runs trusttls (default: current user)")
+	generateSudoersCmd.Flags().String("web-server", "auto", "Web server to allow: auto|apache|nginx|none")
+	generateSudoersCmd.Flags().StringSlice("domain", nil, "Certificates to cover (repeatable; default: all in the store)")
+}
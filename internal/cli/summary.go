@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// installSummary describes a finished setup run, including commands the user
+// can paste to check the result themselves.
+type installSummary struct {
+	Domain       string    `json:"domain"`
+	CertName     string    `json:"cert_name"`
+	Names        []string  `json:"names,omitempty"`
+	Provider     string    `json:"provider"`
+	WebServer    string    `json:"web_server"`
+	VhostConfig  string    `json:"vhost_config,omitempty"`
+	Certificate  string    `json:"certificate"`
+	Chain        string    `json:"chain"`
+	Fullchain    string    `json:"fullchain"`
+	PrivateKey   string    `json:"private_key"`
+	Expires      time.Time `json:"expires,omitempty"`
+	NextRenewal  time.Time `json:"next_renewal,omitempty"`
+	Verification []string  `json:"verification_commands"`
+}
+
+func buildInstallSummary(cfg renewal.Config, provider, webServer, vhostConfig string) installSummary {
+	certPath, keyPath, chainPath, fullchainPath := store.LoadCertPaths(cfg.BaseDir, cfg.Lineage())
+	s := installSummary{
+		Domain:      cfg.Domain,
+		CertName:    cfg.Lineage(),
+		Names:       cfg.Domains,
+		Provider:    provider,
+		WebServer:   webServer,
+		VhostConfig: vhostConfig,
+		Certificate: certPath,
+		Chain:       chainPath,
+		Fullchain:   fullchainPath,
+		PrivateKey:  keyPath,
+	}
+	if l, err := store.LoadLineage(cfg.BaseDir, cfg.Lineage()); err == nil {
+		s.Expires = l.NotAfter
+		s.NextRenewal = renewal.RenewAt(cfg, l.NotAfter)
+	}
+
+	s.Verification = []string{
+		fmt.Sprintf("curl -vI https://%s", cfg.Domain),
+		fmt.Sprintf("openssl s_client -connect %s:443 -servername %s </dev/null 2>/dev/null | openssl x509 -noout -subject -issuer -dates", cfg.Domain, cfg.Domain),
+		fmt.Sprintf("openssl x509 -in %s -noout -subject -enddate", certPath),
+		fmt.Sprintf("openssl verify -untrusted %s %s", chainPath, certPath),
+	}
+	switch webServer {
+	case "apache":
+		s.Verification = append(s.Verification, fmt.Sprintf("apachectl -S 2>&1 | grep %s", cfg.Domain))
+	case "nginx":
+		s.Verification = append(s.Verification, fmt.Sprintf("nginx -T 2>/dev/null | grep -n %s", fullchainPath))
+	}
+	return s
+}
+
+// finishInstall prints the install summary, as JSON to out when asJSON is set.
+func finishInstall(ui *UI, s installSummary, asJSON bool, out io.Writer) error {
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	ui.ShowInstallationSummary(s.Domain, s.Provider, s.WebServer, s.Fullchain)
+	ui.ShowVerificationCommands(s.Verification, s.NextRenewal)
+	return nil
+}
+
+// durationString formats d for a renewal config, leaving zero unset.
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
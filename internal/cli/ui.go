@@ -2,10 +2,14 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
 )
 
 type UI struct {
@@ -183,7 +187,11 @@ func (ui *UI) AskYesNo(question string) bool {
 		}
 		
 		var response string
-		fmt.Scanln(&response)
+		if _, err := fmt.Scanln(&response); errors.Is(err, io.EOF) {
+			// Nobody to answer: take it as no rather than asking forever
+			fmt.Println()
+			return false
+		}
 		
 		response = strings.ToLower(strings.TrimSpace(response))
 		switch response {
@@ -305,6 +313,8 @@ func (ui *UI) ShowProviderInfo(provider string) {
 			fmt.Printf("Provider: \033[1;35mDigiCert ACME\033[0m (Commercial)\n")
 		case "letsencrypt":
 			fmt.Printf("Provider: \033[1;32mLet's Encrypt\033[0m (Free)\n")
+		case "sectigo", "incommon":
+			fmt.Printf("Provider: \033[1;35m%s\033[0m (Commercial, ACME with EAB)\n", providerTitle(provider))
 		default:
 			fmt.Printf("Provider: \033[1m%s\033[0m\n", provider)
 		}
@@ -315,12 +325,20 @@ func (ui *UI) ShowProviderInfo(provider string) {
 			fmt.Printf("Provider: DigiCert ACME (Commercial)\n")
 		case "letsencrypt":
 			fmt.Printf("Provider: Let's Encrypt (Free)\n")
+		case "sectigo", "incommon":
+			fmt.Printf("Provider: %s (Commercial, ACME with EAB)\n", providerTitle(provider))
 		default:
 			fmt.Printf("Provider: %s\n", provider)
 		}
 	}
 }
 
+// providerTitle names an ACME preset for display.
+func providerTitle(provider string) string {
+	p, _ := acme.LookupPreset(provider)
+	return p.Description
+}
+
 func (ui *UI) ShowValidationResults(domain string, passed bool, details string) {
 	if ui.colors {
 		fmt.Printf("\n\033[1;33m🔍 Domain Validation\033[0m\n")
@@ -371,10 +389,37 @@ func (ui *UI) ShowInstallationSummary(domain, provider, serverType string, certP
 	}
 }
 
+func (ui *UI) ShowVerificationCommands(commands []string, nextRenewal time.Time) {
+	if ui.colors {
+		fmt.Printf("\n\033[1;33m🔍 Verify it yourself:\033[0m\n")
+	} else {
+		fmt.Printf("\n🔍 Verify it yourself:\n")
+	}
+	for _, c := range commands {
+		fmt.Printf("  %s\n", c)
+	}
+	if !nextRenewal.IsZero() {
+		fmt.Printf("\n📅 Next renewal: %s (run by: trusttls renew)\n", nextRenewal.Local().Format("2006-01-02 15:04 MST"))
+	}
+}
+
+// ShowErrorWithHelp explains err with helpText. Problems the CA reported,
+// such as rate limits or failed validations, come with their own help,
+// which replaces helpText, and their error code.
 func (ui *UI) ShowErrorWithHelp(err error, helpText string) {
+	code := ""
+	if p, ok := acme.AsProblem(err); ok {
+		code = p.Code
+		if h := p.Help(); h != "" {
+			helpText = h
+		}
+	}
 	if ui.colors {
 		fmt.Printf("\n\033[1;31m💥 Something went wrong!\033[0m\n")
 		fmt.Printf("\033[1;31mError:\033[0m %s\n", err.Error())
+		if code != "" {
+			fmt.Printf("\033[90mError code: %s\033[0m\n", code)
+		}
 		if helpText != "" {
 			fmt.Printf("\n\033[1;33m💡 How to fix this:\033[0m\n")
 			fmt.Printf("%s\n", helpText)
@@ -383,6 +428,9 @@ func (ui *UI) ShowErrorWithHelp(err error, helpText string) {
 	} else {
 		fmt.Printf("\n💥 Something went wrong!\n")
 		fmt.Printf("Error: %s\n", err.Error())
+		if code != "" {
+			fmt.Printf("Error code: %s\n", code)
+		}
 		if helpText != "" {
 			fmt.Printf("\n💡 How to fix this:\n")
 			fmt.Printf("%s\n", helpText)
@@ -391,6 +439,16 @@ func (ui *UI) ShowErrorWithHelp(err error, helpText string) {
 	}
 }
 
+// showProblemHelp prints what to do about err when it is a problem the
+// CA reported that trusttls knows a remedy for.
+func showProblemHelp(err error) {
+	p, ok := acme.AsProblem(err)
+	if !ok || p.Help() == "" {
+		return
+	}
+	fmt.Printf("\n💡 How to fix this (%s):\n%s\n\n", p.Code, p.Help())
+}
+
 func isTerminal() bool {
 	stat, _ := os.Stdout.Stat()
 	return (stat.Mode() & os.ModeCharDevice) != 0
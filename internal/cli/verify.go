@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var verifyStoreCmd = &cobra.Command{
+	Use:   "verify-store",
+	Short: "Check stored certificates and keys against their checksums",
+	Long: `
+Check every certificate, chain and key in archive/ against the SHA-256
+checksums recorded when it was stored, in archive/<name>/SHA256SUMS.
+
+It finds files that were edited, truncated, emptied or removed since, and
+live/ links replaced by plain files or pointing outside the lineage's
+archive. Renewals, rollbacks and renew --run-hooks make the same check on the
+version they deliver and refuse to go on when it fails.
+
+Files stored by TrustTLS versions before checksums were recorded are
+reported as unrecorded; once you have checked them, --record takes their
+checksums as they are now.
+
+Example:
+  trusttls verify-store
+  trusttls verify-store --domain example.com
+  trusttls verify-store --record
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		record, _ := cmd.Flags().GetBool("record")
+		base := store.DefaultBaseDir()
+
+		var domains []string
+		if domain != "" {
+			if _, err := os.Stat(filepath.Join(base, "archive", store.LineageName(domain))); err != nil {
+				return fmt.Errorf("no stored certificate for %s", domain)
+			}
+			domains = []string{domain}
+		} else {
+			entries, err := os.ReadDir(filepath.Join(base, "archive"))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, e := range entries {
+				if e.IsDir() {
+					domains = append(domains, store.LineageDomain(e.Name()))
+				}
+			}
+			sort.Strings(domains)
+		}
+		if len(domains) == 0 {
+			fmt.Println("No certificates stored")
+			return nil
+		}
+
+		failed, unrecorded := 0, 0
+		for _, d := range domains {
+			if record {
+				n, err := store.RecordChecksums(base, d)
+				if err != nil {
+					return fmt.Errorf("record checksums of %s: %w", d, err)
+				}
+				if n > 0 {
+					fmt.Printf("📝 %s: recorded the checksums of %d file(s)\n", d, n)
+				}
+			}
+			problems, err := store.VerifyLineage(base, d)
+			if err != nil {
+				return fmt.Errorf("verify %s: %w", d, err)
+			}
+			bad, missing := 0, 0
+			for _, p := range problems {
+				if p.Unrecorded {
+					missing++
+					continue
+				}
+				bad++
+				fmt.Printf("❌ %s: %s\n", p.Path, p.Problem)
+			}
+			switch {
+			case bad > 0:
+				failed++
+			case missing > 0:
+				unrecorded++
+				fmt.Printf("⚠️  %s: %d file(s) without a recorded checksum\n", d, missing)
+			default:
+				fmt.Printf("✅ %s\n", d)
+			}
+		}
+		if unrecorded > 0 {
+			fmt.Println("💡 Check the unrecorded files, then run 'trusttls verify-store --record' to take their checksums")
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d lineage(s) failed the integrity check; restore the files from a backup, roll back or reissue", failed, len(domains))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyStoreCmd)
+	verifyStoreCmd.Flags().String("domain", "", "Only check this certificate")
+	verifyStoreCmd.Flags().Bool("record", false, "Record checksums for files that have none yet before checking")
+}
@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+var whereCmd = &cobra.Command{
+	Use:   "where",
+	Short: "Show where each certificate is deployed",
+	Long: `
+Answer "if this certificate is bad, what breaks?". For each certificate this
+lists the web server sites setup wrote, the HAProxy cert files, key sinks and
+replication peers renewals delivered to, deploy hooks, places hooks reported
+with 'trusttls where record', and Apache or Nginx configs found referring to
+the certificate's files. Each entry shows when it last received the
+certificate and whether the latest delivery failed.
+
+--domain may be the certificate's name or any name it covers, including one
+matched by a wildcard certificate.
+
+A deploy hook that copies the certificate somewhere trusttls cannot see can
+report it, so the next incident finds it too:
+  trusttls where record --domain "$TRUSTTLS_CERT_NAME" --kind postfix --target /etc/postfix/main.cf
+
+Example:
+  trusttls where --domain example.com
+  trusttls where                        # Every certificate
+  trusttls where --domain example.com --json
+  trusttls where forget --domain example.com --target /etc/postfix/main.cf
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		base := store.DefaultBaseDir()
+		var lineages []store.Lineage
+		if domain != "" {
+			l, err := store.LoadLineage(base, domain)
+			if err != nil {
+				if l, _, err = store.FindLineage(base, domain); err != nil {
+					return err
+				}
+			}
+			lineages = []store.Lineage{l}
+		} else {
+			var err error
+			if lineages, err = store.ListLineages(base); err != nil {
+				return err
+			}
+		}
+		report := map[string][]renewal.Deployment{}
+		for _, l := range lineages {
+			ds, err := renewal.Where(lineageConfig(base, l.Name))
+			if err != nil {
+				return err
+			}
+			if ds == nil {
+				ds = []renewal.Deployment{}
+			}
+			report[l.Name] = ds
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		if len(lineages) == 0 {
+			fmt.Println("ℹ️  No certificates found")
+			return nil
+		}
+		for i, l := range lineages {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("📜 %s (%s)\n", l.Name, strings.Join(l.Names, ", "))
+			fmt.Printf("   Expires: %s\n", describeExpiry(l.NotAfter))
+			printDeployments(report[l.Name])
+		}
+		return nil
+	},
+}
+
+var whereRecordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record a place a certificate is deployed to",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		var d renewal.Deployment
+		d.Kind, _ = cmd.Flags().GetString("kind")
+		d.Target, _ = cmd.Flags().GetString("target")
+		d.Detail, _ = cmd.Flags().GetString("detail")
+		d.Source = renewal.SourceHook
+		if domain == "" || d.Kind == "" || d.Target == "" {
+			return fmt.Errorf("--domain, --kind and --target are required")
+		}
+		base := store.DefaultBaseDir()
+		l, err := store.LoadLineage(base, domain)
+		if err != nil {
+			return fmt.Errorf("no certificate named %s: %w", domain, err)
+		}
+		if err := renewal.RecordDeployment(base, l.Name, d); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Recorded %s at %s for %s\n", d.Kind, d.Target, l.Name)
+		return nil
+	},
+}
+
+var whereForgetCmd = &cobra.Command{
+	Use:   "forget",
+	Short: "Drop a recorded deployment that no longer exists",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain, _ := cmd.Flags().GetString("domain")
+		kind, _ := cmd.Flags().GetString("kind")
+		target, _ := cmd.Flags().GetString("target")
+		if domain == "" || target == "" {
+			return fmt.Errorf("--domain and --target are required")
+		}
+		found, err := renewal.ForgetDeployment(store.DefaultBaseDir(), domain, kind, target)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no deployment of %s recorded at %s", domain, target)
+		}
+		fmt.Printf("🗑️  Forgot %s for %s\n", target, domain)
+		return nil
+	},
+}
+
+// lineageConfig returns the renewal config of the lineage name, or a bare
+// one for certificates that are not renewed by trusttls.
+func lineageConfig(base, name string) renewal.Config {
+	if c, err := renewal.Load(name); err == nil {
+		return c
+	}
+	return renewal.Config{Domain: name, BaseDir: base}
+}
+
+func printDeployments(ds []renewal.Deployment) {
+	if len(ds) == 0 {
+		fmt.Println("   ℹ️  No known deployments; the files may be used by hand")
+		return
+	}
+	for _, d := range ds {
+		icon := "✅"
+		switch {
+		case d.Error != "":
+			icon = "❌"
+		case d.LastSeen.IsZero():
+			icon = "❔"
+		}
+		line := fmt.Sprintf("   %s %-8s %s", icon, d.Kind, d.Target)
+		if d.Detail != "" {
+			line += " (" + d.Detail + ")"
+		}
+		fmt.Println(line)
+		switch {
+		case d.Error != "":
+			fmt.Printf("        last delivery failed: %s\n", d.Error)
+			if !d.LastSeen.IsZero() {
+				fmt.Printf("        last success %s, %s\n", d.LastSeen.Local().Format("2006-01-02 15:04"), sourceDescription(d.Source))
+			}
+		case d.LastSeen.IsZero():
+			fmt.Printf("        %s\n", sourceDescription(d.Source))
+		default:
+			fmt.Printf("        delivered %s, %s\n", d.LastSeen.Local().Format("2006-01-02 15:04"), sourceDescription(d.Source))
+		}
+	}
+}
+
+func sourceDescription(source string) string {
+	switch source {
+	case renewal.SourceInstall:
+		return "written by setup"
+	case renewal.SourceRenewal:
+		return "by issuance or renewal"
+	case renewal.SourceHook:
+		return "reported by a hook"
+	case renewal.SourceConfig:
+		return "configured, nothing delivered yet"
+	case renewal.SourceScan:
+		return "found referring to the certificate's files"
+	}
+	return source
+}
+
+func init() {
+	rootCmd.AddCommand(whereCmd)
+	whereCmd.AddCommand(whereRecordCmd, whereForgetCmd)
+	whereCmd.Flags().String("domain", "", "Certificate to look up, by any name it covers (default: all)")
+	whereCmd.Flags().Bool("json", false, "Print the deployments as JSON")
+	whereRecordCmd.Flags().String("domain", "", "Certificate name, e.g. $TRUSTTLS_CERT_NAME in a hook")
+	whereRecordCmd.Flags().String("kind", "", "What uses the certificate, e.g. postfix, dovecot or k8s-secret")
+	whereRecordCmd.Flags().String("target", "", "Where it went: a file, host or resource name")
+	whereRecordCmd.Flags().String("detail", "", "Anything worth knowing during an incident, e.g. the service to restart")
+	whereForgetCmd.Flags().String("domain", "", "Certificate name")
+	whereForgetCmd.Flags().String("kind", "", "Only forget deployments of this kind")
+	whereForgetCmd.Flags().String("target", "", "Target to forget")
+}
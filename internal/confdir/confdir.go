@@ -0,0 +1,67 @@
+// Package confdir keeps the settings of a store apart from its state when
+// config.yaml asks for it. With state_dir set, renewal configs and the
+// *.yaml settings stay in the directory --config-dir or TRUSTTLS_DIR names,
+// e.g. /etc/trusttls, while certificates, accounts, DNS credentials, locks
+// and queues go to state_dir, e.g. /var/lib/trusttls.
+package confdir
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	mu     sync.Mutex
+	states = map[string]string{} // settings directory -> state directory
+	config = map[string]string{} // state directory -> settings directory
+)
+
+// StateDir returns where the store whose settings are in dir keeps its
+// state: state_dir from dir/config.yaml, relative to dir, else dir itself.
+// A config.yaml that cannot be read counts as setting none; the commands
+// reading its other options report why.
+func StateDir(dir string) string {
+	dir = filepath.Clean(dir)
+	mu.Lock()
+	defer mu.Unlock()
+	if state, ok := states[dir]; ok {
+		return state
+	}
+	state := dir
+	var s struct {
+		StateDir string `yaml:"state_dir"`
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "config.yaml")); err == nil && yaml.Unmarshal(b, &s) == nil && s.StateDir != "" {
+		state = s.StateDir
+		if !filepath.IsAbs(state) {
+			state = filepath.Join(dir, state)
+		}
+		state = filepath.Clean(state)
+	}
+	states[dir] = state
+	if state != dir {
+		config[state] = dir
+	}
+	return state
+}
+
+// Of returns the directory holding the settings of the store whose state
+// is in baseDir: the directory whose config.yaml points at baseDir, else
+// baseDir itself.
+func Of(baseDir string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if dir, ok := config[filepath.Clean(baseDir)]; ok {
+		return dir
+	}
+	return baseDir
+}
+
+// Path returns where the settings file name of the store in baseDir is
+// kept.
+func Path(baseDir, name string) string {
+	return filepath.Join(Of(baseDir), name)
+}
@@ -0,0 +1,487 @@
+package enroll
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// This file holds the small subset of CMS (RFC 5652) needed by SCEP and EST:
+// certs-only SignedData, SignedData with signed attributes, and EnvelopedData
+// with RSA key transport.
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// contentInfo.Content is the [0] EXPLICIT wrapper; its Bytes hold the inner
+// DER element both when parsing and when marshalling.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerial           issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerial        issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+func explicit0(inner []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner}
+}
+
+func wrapContentInfo(oid asn1.ObjectIdentifier, inner []byte) ([]byte, error) {
+	return asn1.Marshal(contentInfo{ContentType: oid, Content: explicit0(inner)})
+}
+
+// octets returns the value of an OCTET STRING, joining the segments of a
+// constructed (BER) one.
+func octets(rv asn1.RawValue) ([]byte, error) {
+	if !rv.IsCompound {
+		return rv.Bytes, nil
+	}
+	var out []byte
+	rest := rv.Bytes
+	for len(rest) > 0 {
+		var seg asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &seg); err != nil {
+			return nil, err
+		}
+		b, err := octets(seg)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// degenerateCerts encodes certs as a certs-only SignedData ContentInfo, the
+// format both SCEP and EST use to hand out certificates.
+func degenerateCerts(certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      []signerInfo{},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return wrapContentInfo(oidSignedData, inner)
+}
+
+// signedMessage is a parsed and verified SignedData.
+type signedMessage struct {
+	Content []byte
+	Signer  *x509.Certificate
+	Certs   []*x509.Certificate
+	Digest  asn1.ObjectIdentifier
+	attrs   map[string][]byte // attribute OID -> DER of the first value
+}
+
+func (m *signedMessage) attrString(oid asn1.ObjectIdentifier) string {
+	var s string
+	if b, ok := m.attrs[oid.String()]; ok {
+		_, _ = asn1.Unmarshal(b, &s)
+	}
+	return s
+}
+
+func (m *signedMessage) attrOctets(oid asn1.ObjectIdentifier) []byte {
+	var b []byte
+	if raw, ok := m.attrs[oid.String()]; ok {
+		_, _ = asn1.Unmarshal(raw, &b)
+	}
+	return b
+}
+
+// parseSignedData parses a SignedData ContentInfo with one signer and checks
+// the signer's signature over the signed attributes and content digest.
+func parseSignedData(der []byte) (*signedMessage, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("content info: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("expected signedData, got %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("signed data: %w", err)
+	}
+	var content []byte
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		var encap asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &encap); err != nil {
+			return nil, fmt.Errorf("encapsulated content: %w", err)
+		}
+		var err error
+		if content, err = octets(encap); err != nil {
+			return nil, err
+		}
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("certificates: %w", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer, got %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	var signer *x509.Certificate
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 && bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) {
+			signer = c
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("signer certificate not included")
+	}
+	if len(si.AuthenticatedAttributes.Bytes) == 0 {
+		return nil, errors.New("signed attributes missing")
+	}
+
+	m := &signedMessage{Content: content, Signer: signer, Certs: certs, Digest: si.DigestAlgorithm.Algorithm, attrs: map[string][]byte{}}
+	rest := si.AuthenticatedAttributes.Bytes
+	for len(rest) > 0 {
+		var a attribute
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+			return nil, fmt.Errorf("signed attributes: %w", err)
+		}
+		var v asn1.RawValue
+		if _, err := asn1.Unmarshal(a.Values.Bytes, &v); err == nil {
+			m.attrs[a.Type.String()] = v.FullBytes
+		}
+	}
+
+	hash, err := hashFor(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(m.attrOctets(oidAttrMessageDigest), h.Sum(nil)) {
+		return nil, errors.New("message digest mismatch")
+	}
+	// The signature covers the attributes re-tagged as a universal SET.
+	signed := append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+	signed[0] = 0x31
+	h = hash.New()
+	h.Write(signed)
+	if err := verify(signer.PublicKey, hash, h.Sum(nil), si.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	return m, nil
+}
+
+func hashFor(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+// verify checks a raw CMS signature; SHA-1 is accepted because many SCEP
+// clients still sign with it.
+func verify(pub crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("ecdsa verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signer key %T", pub)
+}
+
+// signAttributes builds a SignedData ContentInfo over content, signed by cert
+// and key with SHA-256, carrying extra signed attributes.
+func signAttributes(content []byte, cert *x509.Certificate, key *rsa.PrivateKey, extra []attribute) ([]byte, error) {
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+	contentTypeAttr, err := newAttribute(oidAttrContentType, oidData)
+	if err != nil {
+		return nil, err
+	}
+	digestAttr, err := newAttribute(oidAttrMessageDigest, digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	attrs := append([]attribute{contentTypeAttr, digestAttr}, extra...)
+	set, err := asn1.Marshal(struct {
+		A []attribute `asn1:"set"`
+	}{attrs})
+	if err != nil {
+		return nil, err
+	}
+	// Unwrap the helper SEQUENCE to get the DER SET OF attributes.
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(set, &outer); err != nil {
+		return nil, err
+	}
+	signedAttrs := outer.Bytes
+	h := crypto.SHA256.New()
+	h.Write(signedAttrs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	var setBody asn1.RawValue
+	if _, err := asn1.Unmarshal(signedAttrs, &setBody); err != nil {
+		return nil, err
+	}
+
+	var encap asn1.RawValue
+	if content != nil {
+		inner, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		encap = explicit0(inner)
+	}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		ContentInfo:      contentInfo{ContentType: oidData, Content: encap},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:                   1,
+			IssuerAndSerial:           issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: setBody.Bytes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           sig,
+		}},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return wrapContentInfo(oidSignedData, inner)
+}
+
+func newAttribute(oid asn1.ObjectIdentifier, value interface{}) (attribute, error) {
+	b, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: oid, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: b}}, nil
+}
+
+func printableAttribute(oid asn1.ObjectIdentifier, s string) (attribute, error) {
+	b, err := asn1.MarshalWithParams(s, "printable")
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: oid, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: b}}, nil
+}
+
+// decryptEnveloped opens an EnvelopedData ContentInfo addressed to cert and
+// returns the plaintext together with the content encryption algorithm so a
+// reply can use the same one.
+func decryptEnveloped(der []byte, cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, asn1.ObjectIdentifier, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, fmt.Errorf("content info: %w", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, nil, fmt.Errorf("expected envelopedData, got %v", ci.ContentType)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, nil, fmt.Errorf("enveloped data: %w", err)
+	}
+	var ri *recipientInfo
+	for i := range ed.RecipientInfos {
+		if ed.RecipientInfos[i].IssuerAndSerial.Serial.Cmp(cert.SerialNumber) == 0 {
+			ri = &ed.RecipientInfos[i]
+		}
+	}
+	if ri == nil {
+		return nil, nil, errors.New("message is not encrypted for this server")
+	}
+	cek, err := rsa.DecryptPKCS1v15(rand.Reader, key, ri.EncryptedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypt content key: %w", err)
+	}
+	eci := ed.EncryptedContentInfo
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, fmt.Errorf("content encryption iv: %w", err)
+	}
+	ciphertext, err := octets(eci.EncryptedContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := newBlockCipher(eci.ContentEncryptionAlgorithm.Algorithm, cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, nil, errors.New("malformed encrypted content")
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || pad > len(plain) {
+		return nil, nil, errors.New("bad padding")
+	}
+	return plain[:len(plain)-pad], eci.ContentEncryptionAlgorithm.Algorithm, nil
+}
+
+// encryptEnveloped encrypts content for the RSA key in recipient using alg.
+func encryptEnveloped(content []byte, recipient *x509.Certificate, alg asn1.ObjectIdentifier) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("recipient key is not RSA")
+	}
+	keyLen := 16
+	switch {
+	case alg.Equal(oidAES256CBC):
+		keyLen = 32
+	case alg.Equal(oidDESEDE3CBC):
+		keyLen = 24
+	case alg.Equal(oidAES128CBC):
+	default:
+		alg = oidAES128CBC
+	}
+	cek := make([]byte, keyLen)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+	block, err := newBlockCipher(alg, cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	pad := block.BlockSize() - len(content)%block.BlockSize()
+	plain := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plain)
+
+	encKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, cek)
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	ed := envelopedData{
+		Version: 0,
+		RecipientInfos: []recipientInfo{{
+			Version:                0,
+			IssuerAndSerial:        issuerAndSerial{Issuer: asn1.RawValue{FullBytes: recipient.RawIssuer}, Serial: recipient.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: alg, Parameters: asn1.RawValue{FullBytes: ivDER}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	}
+	inner, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, err
+	}
+	return wrapContentInfo(oidEnvelopedData, inner)
+}
+
+func newBlockCipher(alg asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+	switch {
+	case alg.Equal(oidAES128CBC), alg.Equal(oidAES256CBC):
+		return aes.NewCipher(key)
+	case alg.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher(key)
+	}
+	return nil, fmt.Errorf("unsupported content encryption algorithm %v", alg)
+}
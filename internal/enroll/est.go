@@ -0,0 +1,124 @@
+package enroll
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const estPrefix = "/.well-known/est/"
+
+// handleEST serves the EST (RFC 7030) cacerts, simpleenroll and
+// simplereenroll operations. An optional CA label segment is accepted and
+// ignored.
+func (s *Server) handleEST(w http.ResponseWriter, r *http.Request) {
+	op := strings.Trim(strings.TrimPrefix(r.URL.Path, estPrefix), "/")
+	if i := strings.LastIndex(op, "/"); i >= 0 {
+		op = op[i+1:]
+	}
+	switch op {
+	case "cacerts":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		der, err := degenerateCerts(s.Issuer.CACert())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeBase64(w, "application/pkcs7-mime", der)
+	case "simpleenroll", "simplereenroll":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.estEnroll(w, r, op == "simplereenroll")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) estEnroll(w http.ResponseWriter, r *http.Request, reenroll bool) {
+	if r.TLS == nil {
+		http.Error(w, "EST requires TLS", http.StatusForbidden)
+		return
+	}
+	// A client certificate only authenticates the renewal of itself; a new
+	// enrollment needs the basic auth credentials.
+	var clientCert *x509.Certificate
+	if len(r.TLS.VerifiedChains) > 0 {
+		clientCert = r.TLS.VerifiedChains[0][0]
+	}
+	if reenroll && clientCert == nil || !reenroll && !s.estBasicAuth(r) {
+		if !reenroll {
+			w.Header().Set("WWW-Authenticate", `Basic realm="trusttls-est"`)
+		}
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "read request", http.StatusBadRequest)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		http.Error(w, "request is not base64 encoded", http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, "malformed certificate request", http.StatusBadRequest)
+		return
+	}
+	// A re-enrollment must keep the subject and names of the certificate
+	// being renewed.
+	if reenroll && !sameIdentity(clientCert, csr) {
+		http.Error(w, "subject or names do not match the current certificate", http.StatusForbidden)
+		return
+	}
+	cert, err := s.Issuer.SignCSR(csr)
+	if err != nil {
+		s.logf("est: sign %q: %v", csr.Subject.CommonName, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.logf("est: issued %q (serial %x, expires %s)", cert.Subject.CommonName, cert.SerialNumber, cert.NotAfter.Format(time.RFC3339))
+	p7, err := degenerateCerts(cert)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBase64(w, "application/pkcs7-mime; smime-type=certs-only", p7)
+}
+
+func (s *Server) estBasicAuth(r *http.Request) bool {
+	if s.ESTUser == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.ESTUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.ESTPassword)) == 1
+	return userOK && passOK
+}
+
+func writeBase64(w http.ResponseWriter, contentType string, der []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	enc := base64.StdEncoding.EncodeToString(der)
+	for len(enc) > 64 {
+		io.WriteString(w, enc[:64]+"\r\n")
+		enc = enc[64:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
@@ -0,0 +1,207 @@
+package enroll
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SCEP (RFC 8894) message attributes live under the VeriSign arc.
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+const (
+	scepCertRep    = "3"
+	scepRenewalReq = "17"
+	scepPKCSReq    = "19"
+
+	scepSuccess = "0"
+	scepFailure = "2"
+
+	scepBadMessageCheck = "1"
+	scepBadRequest      = "2"
+)
+
+const scepCaps = "Renewal\nSHA-1\nSHA-256\nSHA-512\nAES\nDES3\nSCEPStandard\nPOSTPKIOperation\n"
+
+func (s *Server) handleSCEP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("operation") {
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, scepCaps)
+	case "GetCACert":
+		der, err := degenerateCerts(s.RACert, s.Issuer.CACert())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+		w.Write(der)
+	case "PKIOperation":
+		var msg []byte
+		var err error
+		if r.Method == http.MethodPost {
+			msg, err = io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		} else {
+			// Some clients do not escape '+' in the query string.
+			m := strings.ReplaceAll(r.URL.Query().Get("message"), " ", "+")
+			msg, err = base64.StdEncoding.DecodeString(m)
+		}
+		if err != nil {
+			http.Error(w, "malformed message", http.StatusBadRequest)
+			return
+		}
+		resp, err := s.pkiOperation(msg)
+		if err != nil {
+			s.logf("scep: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pki-message")
+		w.Write(resp)
+	default:
+		http.Error(w, "unknown operation", http.StatusBadRequest)
+	}
+}
+
+// pkiOperation handles one PKIOperation message and returns the CertRep.
+// Errors are returned only when the request is too broken to answer with a
+// signed failure response.
+func (s *Server) pkiOperation(msg []byte) ([]byte, error) {
+	req, err := parseSignedData(msg)
+	if err != nil {
+		return nil, err
+	}
+	msgType := req.attrString(oidSCEPMessageType)
+	if msgType != scepPKCSReq && msgType != scepRenewalReq {
+		// Issuance is synchronous, so there is never anything to poll for.
+		return s.certRep(req, scepFailure, scepBadRequest, nil)
+	}
+	csrDER, alg, err := decryptEnveloped(req.Content, s.RACert, s.RAKey)
+	if err != nil {
+		s.logf("scep: %v", err)
+		return s.certRep(req, scepFailure, scepBadMessageCheck, nil)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		s.logf("scep: parse csr: %v", err)
+		return s.certRep(req, scepFailure, scepBadRequest, nil)
+	}
+	if !s.scepAuthorized(msgType, req.Signer, csr) {
+		s.logf("scep: rejected request for %q: bad challenge password or renewal of another certificate", csr.Subject.CommonName)
+		return s.certRep(req, scepFailure, scepBadRequest, nil)
+	}
+	cert, err := s.Issuer.SignCSR(csr)
+	if err != nil {
+		s.logf("scep: sign %q: %v", csr.Subject.CommonName, err)
+		return s.certRep(req, scepFailure, scepBadRequest, nil)
+	}
+	s.logf("scep: issued %q (serial %x, expires %s)", cert.Subject.CommonName, cert.SerialNumber, cert.NotAfter.Format(time.RFC3339))
+	certs, err := degenerateCerts(cert)
+	if err != nil {
+		return nil, err
+	}
+	env, err := encryptEnveloped(certs, req.Signer, alg)
+	if err != nil {
+		return nil, err
+	}
+	return s.certRep(req, scepSuccess, "", env)
+}
+
+// scepAuthorized accepts a request carrying the configured challenge
+// password, or a RenewalReq signed with a certificate this CA issued earlier
+// for exactly the identity being requested. Holding some certificate from
+// the CA does not allow enrolling a new one.
+func (s *Server) scepAuthorized(msgType string, signer *x509.Certificate, csr *x509.CertificateRequest) bool {
+	if msgType == scepRenewalReq && s.issuedByCA(signer) && sameIdentity(signer, csr) {
+		return true
+	}
+	if s.SCEPChallenge == "" {
+		return false
+	}
+	got := challengePassword(csr)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(s.SCEPChallenge)) == 1
+}
+
+func (s *Server) issuedByCA(cert *x509.Certificate) bool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Issuer.CACert())
+	_, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err == nil
+}
+
+func (s *Server) certRep(req *signedMessage, status, failInfo string, content []byte) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	var attrs []attribute
+	add := func(a attribute, err error) error {
+		if err == nil {
+			attrs = append(attrs, a)
+		}
+		return err
+	}
+	if err := errors.Join(
+		add(printableAttribute(oidSCEPMessageType, scepCertRep)),
+		add(printableAttribute(oidSCEPPKIStatus, status)),
+		add(newAttribute(oidSCEPRecipientNonce, req.attrOctets(oidSCEPSenderNonce))),
+		add(newAttribute(oidSCEPSenderNonce, nonce)),
+	); err != nil {
+		return nil, err
+	}
+	// The transaction ID is echoed exactly as the client encoded it.
+	if raw, ok := req.attrs[oidSCEPTransactionID.String()]; ok {
+		attrs = append(attrs, attribute{Type: oidSCEPTransactionID, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: raw}})
+	}
+	if failInfo != "" {
+		if err := add(printableAttribute(oidSCEPFailInfo, failInfo)); err != nil {
+			return nil, err
+		}
+	}
+	return signAttributes(content, s.RACert, s.RAKey, attrs)
+}
+
+// challengePassword returns the PKCS#9 challengePassword attribute of csr,
+// which crypto/x509 does not expose.
+func challengePassword(csr *x509.CertificateRequest) string {
+	var tbs struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []attribute `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return ""
+	}
+	for _, a := range tbs.Attributes {
+		if !a.Type.Equal(oidChallengePassword) {
+			continue
+		}
+		var pw string
+		if _, err := asn1.Unmarshal(a.Values.Bytes, &pw); err == nil {
+			return pw
+		}
+	}
+	return ""
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,142 @@
+// Package enroll serves SCEP and EST so appliances that cannot speak ACME
+// (printers, network gear, MDM-managed devices) can get certificates from a
+// CA managed by trusttls.
+package enroll
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/ca"
+)
+
+// Issuer signs certificate requests received over SCEP or EST. The internal
+// CA implements it through AuthorityIssuer; a provider that forwards requests
+// to another CA can be plugged in the same way.
+type Issuer interface {
+	CACert() *x509.Certificate
+	SignCSR(csr *x509.CertificateRequest) (*x509.Certificate, error)
+}
+
+// AuthorityIssuer issues certificates from an internal CA with a fixed lifetime.
+type AuthorityIssuer struct {
+	Authority *ca.Authority
+	Lifetime  time.Duration
+}
+
+func (a AuthorityIssuer) CACert() *x509.Certificate { return a.Authority.Cert }
+
+func (a AuthorityIssuer) SignCSR(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	return a.Authority.SignCSR(csr, a.Lifetime)
+}
+
+// Server routes SCEP and EST requests to an Issuer.
+type Server struct {
+	Issuer Issuer
+
+	// SCEP registration authority; required when SCEP is enabled.
+	RACert *x509.Certificate
+	RAKey  *rsa.PrivateKey
+	// SCEPChallenge is the challenge password devices must put in their CSR.
+	SCEPChallenge string
+
+	// ESTUser and ESTPassword enable HTTP basic auth for simpleenroll;
+	// without them only simplereenroll, authenticated by the certificate
+	// being renewed, is available.
+	ESTUser     string
+	ESTPassword string
+
+	EnableSCEP bool
+	EnableEST  bool
+
+	// Log receives one line per issued or rejected request.
+	Log func(string)
+}
+
+// Handler returns the HTTP handler for the enabled protocols. SCEP is served
+// at /scep and the customary /cgi-bin/pkiclient.exe; EST under
+// /.well-known/est/.
+func (s *Server) Handler() (http.Handler, error) {
+	if s.Issuer == nil {
+		return nil, errors.New("enroll: no issuer configured")
+	}
+	mux := http.NewServeMux()
+	if s.EnableSCEP {
+		if s.RACert == nil || s.RAKey == nil {
+			return nil, errors.New("enroll: SCEP needs an RA certificate and key")
+		}
+		mux.HandleFunc("/scep", s.handleSCEP)
+		mux.HandleFunc("/scep/pkiclient.exe", s.handleSCEP)
+		mux.HandleFunc("/cgi-bin/pkiclient.exe", s.handleSCEP)
+	}
+	if s.EnableEST {
+		mux.HandleFunc(estPrefix, s.handleEST)
+	}
+	return mux, nil
+}
+
+// TLSConfig returns a server TLS configuration using cert that also asks for
+// (but does not require) client certificates issued by the CA, which EST uses
+// for re-enrollment.
+func (s *Server) TLSConfig(cert tls.Certificate) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Issuer.CACert())
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// sameIdentity reports whether csr asks for exactly the subject and subject
+// alternative names of cert, so that a certificate can renew itself but not
+// vouch for a different or wider one.
+func sameIdentity(cert *x509.Certificate, csr *x509.CertificateRequest) bool {
+	if !bytes.Equal(cert.RawSubject, csr.RawSubject) {
+		return false
+	}
+	return sameNames(cert.DNSNames, csr.DNSNames) &&
+		sameNames(cert.EmailAddresses, csr.EmailAddresses) &&
+		sameNames(ipStrings(cert.IPAddresses), ipStrings(csr.IPAddresses)) &&
+		sameNames(uriStrings(cert.URIs), uriStrings(csr.URIs))
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, len(ips))
+	for i, ip := range ips {
+		out[i] = ip.String()
+	}
+	return out
+}
+
+func uriStrings(uris []*url.URL) []string {
+	out := make([]string, len(uris))
+	for i, u := range uris {
+		out[i] = u.String()
+	}
+	return out
+}
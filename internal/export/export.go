@@ -0,0 +1,285 @@
+// Package export converts a certificate from the store into the formats
+// consumers that cannot use live/ load: PKCS#12 bundles for Java, Windows
+// and appliances, DER files, plain PEM copies and HAProxy's combined PEM.
+package export
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/plugins/haproxy"
+	"github.com/trustctl/trusttls/internal/readonly"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// Formats that can be exported.
+const (
+	FormatP12     = "p12"     // PKCS#12: key, certificate and chain in one password-protected file
+	FormatDER     = "der"     // directory of DER files: cert.der, chain.der (chain2.der, ...) and privkey.der
+	FormatPEM     = "pem"     // directory of plain PEM files named like certbot's: cert.pem, chain.pem, fullchain.pem, privkey.pem
+	FormatHAProxy = "haproxy" // one PEM file with the key and the full chain, as HAProxy's crt loads
+)
+
+// Formats lists every export format.
+var Formats = []string{FormatP12, FormatDER, FormatPEM, FormatHAProxy}
+
+// CheckFormat returns why format cannot be exported.
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown export format %q (use %s)", format, strings.Join(Formats, ", "))
+}
+
+// IsDir reports whether format writes a directory of files rather than a
+// single file.
+func IsDir(format string) bool {
+	return format == FormatDER || format == FormatPEM
+}
+
+// NeedsPassword reports whether format is protected by a password.
+func NeedsPassword(format string) bool {
+	return format == FormatP12
+}
+
+// NeedsKey reports whether format cannot be written without the private
+// key. The directory formats leave the key file out instead.
+func NeedsKey(format string) bool {
+	return !IsDir(format)
+}
+
+// DefaultPath returns the file or directory name an export of the lineage
+// name in format is written to when none is given.
+func DefaultPath(name, format string) string {
+	switch format {
+	case FormatHAProxy:
+		return name + ".haproxy.pem"
+	case FormatDER, FormatPEM:
+		return name + "-" + format
+	}
+	return name + "." + format
+}
+
+// Material is the PEM data of a certificate to export. Key is empty when
+// the store does not hold it (--csr, key sinks).
+type Material struct {
+	Cert  []byte
+	Chain []byte
+	Key   []byte
+}
+
+// Build returns the files format consists of, by name. A single-file format
+// has one entry named "".
+func Build(format string, m Material, password string, legacy bool) (map[string][]byte, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+	if NeedsKey(format) && len(m.Key) == 0 {
+		return nil, fmt.Errorf("a %s export needs the private key, which is not in the store", format)
+	}
+	switch format {
+	case FormatP12:
+		b, err := PKCS12(m.Cert, m.Chain, m.Key, password, legacy)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{"": b}, nil
+	case FormatHAProxy:
+		return map[string][]byte{"": haproxy.Bundle(fullchain(m), m.Key)}, nil
+	case FormatPEM:
+		files := map[string][]byte{"cert.pem": m.Cert, "chain.pem": m.Chain, "fullchain.pem": fullchain(m)}
+		if len(m.Key) > 0 {
+			files["privkey.pem"] = m.Key
+		}
+		return files, nil
+	}
+	return DER(m)
+}
+
+// DER returns the certificate as cert.der, the chain one certificate per
+// file as chain.der, chain2.der and so on, and the key as PKCS#8
+// privkey.der when there is one.
+func DER(m Material) (map[string][]byte, error) {
+	certs, err := parseCerts(m.Cert)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{"cert.der": certs[0].Raw}
+	if len(m.Chain) > 0 {
+		chain, err := parseCerts(m.Chain)
+		if err != nil {
+			return nil, err
+		}
+		for i, c := range chain {
+			name := "chain.der"
+			if i > 0 {
+				name = fmt.Sprintf("chain%d.der", i+1)
+			}
+			files[name] = c.Raw
+		}
+	}
+	if len(m.Key) > 0 {
+		key, err := parseKey(m.Key)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		files["privkey.der"] = der
+	}
+	return files, nil
+}
+
+// PKCS12 returns a PKCS#12 bundle of the key, certificate and chain,
+// encrypted with password. It uses AES-256 and PBKDF2, or with legacy the
+// 3DES and SHA-1 that Java before 8u301, Windows Server 2016 and older
+// appliances still require.
+func PKCS12(certPEM, chainPEM, keyPEM []byte, password string, legacy bool) ([]byte, error) {
+	certs, err := parseCerts(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := parseCerts(chainPEM)
+	if err != nil && len(chainPEM) > 0 {
+		return nil, err
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	enc := pkcs12.Modern
+	if legacy {
+		enc = pkcs12.Legacy
+	}
+	return enc.WithRand(rand.Reader).Encode(key, certs[0], chain, password)
+}
+
+// Write writes files as built for format to path: the file itself, or the
+// files of a directory format inside path, which is created as needed.
+// Files holding the key are only readable by their owner; certificates are
+// readable by all, as consumers of split files often run as other users.
+func Write(path, format string, files map[string][]byte) error {
+	if !IsDir(format) {
+		return WriteFile(path, files[""])
+	}
+	if err := readonly.Check("create " + path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		perm := os.FileMode(0644)
+		if strings.HasPrefix(name, "privkey.") {
+			perm = 0600
+		}
+		if err := writeFile(filepath.Join(path, name), files[name], perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fullchain(m Material) []byte {
+	var b bytes.Buffer
+	b.Write(bytes.TrimSpace(m.Cert))
+	b.WriteString("\n")
+	if chain := bytes.TrimSpace(m.Chain); len(chain) > 0 {
+		b.Write(chain)
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+// WriteFile replaces path with data atomically. Exports carry the private
+// key, so the file is only readable by its owner.
+func WriteFile(path string, data []byte) error {
+	return writeFile(path, data, 0600)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := readonly.Check("write " + path); err != nil {
+		return err
+	}
+	defer interrupt.Hold()()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".trusttls-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func parseCerts(b []byte) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no certificate in PEM data")
+	}
+	return out, nil
+}
+
+func parseKey(b []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM private key")
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("unreadable private key")
+}
@@ -0,0 +1,299 @@
+package importer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/renewal"
+)
+
+// acme.sh wraps hook commands it saves in base64 between these markers.
+const (
+	acmeshB64Start = "__ACME_BASE64__START_"
+	acmeshB64End   = "__ACME_BASE64__END_"
+)
+
+// acmeshInstalls maps the install-cert destinations acme.sh saves to the
+// deploy copy source each one takes.
+var acmeshInstalls = [][2]string{
+	{"Le_RealCertPath", "cert"},
+	{"Le_RealCACertPath", "chain"},
+	{"Le_RealFullChainPath", "fullchain"},
+	{"Le_RealKeyPath", "privkey"},
+}
+
+// DefaultAcmeshDir returns where acme.sh keeps its configuration for the
+// current user.
+func DefaultAcmeshDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".acme.sh"
+	}
+	return filepath.Join(home, ".acme.sh")
+}
+
+// Acmesh reads the certificates acme.sh keeps in dir (normally ~/.acme.sh):
+// the certificate and key of each domain, its renewal settings, the ACME
+// account it was issued with and the DNS API credentials acme.sh saved.
+// install-cert destinations and the reload command become deploy copies.
+// With name only that domain is read. email is used for accounts
+// registered without a contact address.
+func Acmesh(dir, name, email string) ([]*Lineage, error) {
+	account, err := dnsprovider.ReadEnvFile(filepath.Join(dir, "account.conf"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	domains := map[string]bool{}
+	for _, e := range entries {
+		domain := strings.TrimSuffix(e.Name(), "_ecc")
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), domain+".conf")); err != nil {
+			continue
+		}
+		if name != "" && name != domain && name != e.Name() {
+			continue
+		}
+		dirs = append(dirs, e.Name())
+		if !strings.HasSuffix(e.Name(), "_ecc") {
+			domains[domain] = true
+		}
+	}
+	if len(dirs) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("acme.sh has no certificate for %s in %s", name, dir)
+		}
+		return nil, fmt.Errorf("no acme.sh certificates in %s", dir)
+	}
+	sort.Strings(dirs)
+	var out []*Lineage
+	for _, d := range dirs {
+		// An ECDSA certificate for a domain that also has an RSA one keeps
+		// acme.sh's directory name so both fit in the store
+		lineage := strings.TrimSuffix(d, "_ecc")
+		if d != lineage && domains[lineage] {
+			lineage = d
+		}
+		l, err := acmeshLineage(dir, d, lineage, account, email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(dir, d), err)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func acmeshLineage(dir, sub, name string, account map[string]string, email string) (*Lineage, error) {
+	domainDir := filepath.Join(dir, sub)
+	domain := strings.TrimSuffix(sub, "_ecc")
+	confPath := filepath.Join(domainDir, domain+".conf")
+	conf, err := dnsprovider.ReadEnvFile(confPath)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lineage{Name: name, Source: domainDir}
+	var v Version
+	for file, dst := range map[string]*[]byte{domain + ".cer": &v.Cert, "ca.cer": &v.Chain, domain + ".key": &v.Key} {
+		if *dst, err = os.ReadFile(filepath.Join(domainDir, file)); err != nil {
+			return nil, err
+		}
+	}
+	l.Versions = []Version{v}
+	cert, err := parseCert(v.Cert)
+	if err != nil {
+		return nil, err
+	}
+	domains := names(cert)
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("the certificate names no domain")
+	}
+	c := configFor(name, domains)
+	c.Server = conf["Le_API"]
+	c.MustStaple = conf["Le_OCSP_Staple"] == "1"
+	c.PostHook = acmeshHook(conf["Le_PostHook"])
+	c.DeployHook = acmeshHook(conf["Le_RenewHook"])
+	if pre := acmeshHook(conf["Le_PreHook"]); pre != "" {
+		l.Notes = append(l.Notes, fmt.Sprintf("pre-hook %q has no trusttls equivalent; run it from a deploy hook or your scheduler", pre))
+	}
+	keyLength := conf["Le_Keylength"]
+	if keyLength == "" && sub != domain {
+		keyLength = "ec-256"
+	}
+	c.KeyType, c.KeySize = "rsa", 2048
+	if size, ok := strings.CutPrefix(keyLength, "ec-"); ok {
+		c.KeyType, c.KeySize = "ecdsa", 256
+		if n, err := strconv.Atoi(size); err == nil {
+			c.KeySize = n
+		}
+	} else if n, err := strconv.Atoi(keyLength); err == nil {
+		c.KeySize = n
+	}
+	if c.KeyType, c.KeySize, err = acme.NormalizeKey(c.KeyType, c.KeySize); err != nil {
+		return nil, err
+	}
+
+	// How names are validated: Le_Webroot holds one mode per name, the
+	// first of which is used for all of them
+	modes := strings.Split(conf["Le_Webroot"], ",")
+	mode := modes[0]
+	for _, m := range modes[1:] {
+		if m != mode {
+			l.Notes = append(l.Notes, fmt.Sprintf("acme.sh validated names in different ways (%s); trusttls uses %s for all of them", conf["Le_Webroot"], mode))
+			break
+		}
+	}
+	switch {
+	case mode == "no" || mode == "":
+		c.Method, c.Standalone = "http-01", ":80"
+		if port := conf["Le_HTTPPort"]; port != "" {
+			c.Standalone = ":" + port
+		}
+	case mode == "alpn":
+		c.Method, c.Standalone = "http-01", ":80"
+		l.Notes = append(l.Notes, "acme.sh validated it with TLS-ALPN on port 443; trusttls listens on port 80 instead")
+	case mode == "nginx" || mode == "apache":
+		c.Method = "http-01"
+		if mode == "nginx" {
+			c.Webroot = nginx.DetectWebroot(domains[0])
+		} else {
+			c.Webroot = apache.DetectWebroot(domains[0])
+		}
+		if c.Webroot == "" {
+			l.Notes = append(l.Notes, fmt.Sprintf("no webroot found for %s in the %s config; set webroot in the renewal config", domains[0], mode))
+		}
+	case mode == "dns":
+		c.Method, c.DNSPlugin = "dns-01", "manual"
+		l.Notes = append(l.Notes, "acme.sh validated it by hand (--dns); 'trusttls renew' will ask for the DNS records")
+	case strings.HasPrefix(mode, "dns_"):
+		vars := map[string]string{}
+		for k, v := range account {
+			vars[k] = v
+		}
+		for k, v := range conf {
+			vars[k] = v
+		}
+		imp, err := dnsprovider.ImportAcmesh(mode, vars)
+		if err != nil {
+			return nil, err
+		}
+		c.Method, c.DNSPlugin = "dns-01", imp.Provider
+		// route53 without saved keys reads the AWS credential chain
+		if len(imp.Creds) > 0 {
+			l.DNS = &imp
+		}
+	case filepath.IsAbs(mode):
+		c.Method, c.Webroot = "http-01", mode
+	default:
+		return nil, fmt.Errorf("acme.sh validation mode %q has no trusttls equivalent", mode)
+	}
+
+	// install-cert destinations are kept up to date with deploy copies,
+	// followed by the reload command
+	reload := acmeshHook(conf["Le_ReloadCmd"])
+	for _, in := range acmeshInstalls {
+		dst := conf[in[0]]
+		if dst == "" {
+			continue
+		}
+		d := renewal.DeployCopy{Src: in[1], Dst: dst, Reload: reload}
+		if st, err := os.Stat(dst); err == nil {
+			d.Mode = fmt.Sprintf("%04o", st.Mode().Perm())
+		}
+		c.Deploy = append(c.Deploy, d)
+	}
+	if reload != "" && len(c.Deploy) == 0 {
+		if c.DeployHook != "" {
+			reload = c.DeployHook + " && " + reload
+		}
+		c.DeployHook = reload
+	}
+
+	if a, err := acmeshAccount(dir, c.Server, account); err != nil {
+		return nil, err
+	} else if a != nil {
+		if a.Email == "" {
+			a.Email = email
+		}
+		if a.Email == "" {
+			l.Notes = append(l.Notes, fmt.Sprintf("ACME account %s has no email address; pass --email to keep using it, or a new account is registered", a.URI))
+			a = nil
+		} else {
+			c.Email = a.Email
+		}
+		l.Account = a
+	}
+	if c.Email == "" {
+		c.Email = email
+	}
+	l.Config = c
+	return l, nil
+}
+
+// acmeshAccount reads the ACME account acme.sh registered on server, or
+// returns nil when it has none there.
+func acmeshAccount(dir, server string, account map[string]string) (*Account, error) {
+	if server == "" {
+		server = acme.LetsEncryptProd
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	// Older versions keep accounts per host only
+	caDir := filepath.Join(dir, "ca", u.Hostname(), filepath.FromSlash(strings.Trim(u.Path, "/")))
+	if _, err := os.Stat(filepath.Join(caDir, "account.key")); err != nil {
+		caDir = filepath.Join(dir, "ca", u.Hostname())
+	}
+	b, err := os.ReadFile(filepath.Join(caDir, "account.key"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := certcrypto.ParsePEMPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(caDir, "account.key"), err)
+	}
+	ca, err := dnsprovider.ReadEnvFile(filepath.Join(caDir, "ca.conf"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if ca["ACCOUNT_URL"] == "" {
+		return nil, nil
+	}
+	a := &Account{Server: server, Key: key, URI: ca["ACCOUNT_URL"], Email: ca["CA_EMAIL"]}
+	if a.Email == "" {
+		a.Email = account["ACCOUNT_EMAIL"]
+	}
+	return a, nil
+}
+
+// acmeshHook returns a hook command as acme.sh saved it, decoding the
+// base64 form newer versions use.
+func acmeshHook(v string) string {
+	if !strings.HasPrefix(v, acmeshB64Start) || !strings.HasSuffix(v, acmeshB64End) {
+		return v
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(v, acmeshB64Start), acmeshB64End))
+	if err != nil {
+		return v
+	}
+	return string(b)
+}
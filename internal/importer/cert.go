@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Cert reads a certificate issued outside ACME from certPath with its key
+// from keyPath, for a lineage trusttls monitors, installs and deploys but
+// does not renew. The chain is read from chainPath, or from the
+// certificates following the first one in certPath when chainPath is
+// empty. domain must be one of the certificate's names and is made its
+// primary name; empty means the certificate's own first name. The lineage
+// is kept as name, or as that domain.
+func Cert(domain, name, certPath, keyPath, chainPath string) (*Lineage, error) {
+	b, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	leafPEM, rest := splitLeaf(b)
+	if leafPEM == nil {
+		return nil, fmt.Errorf("no certificate in %s", certPath)
+	}
+	chain := rest
+	if chainPath != "" {
+		if chain, err = os.ReadFile(chainPath); err != nil {
+			return nil, err
+		}
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tls.X509KeyPair(leafPEM, key); err != nil {
+		return nil, fmt.Errorf("%s does not hold the key of %s: %w", keyPath, certPath, err)
+	}
+	cert, err := parseCert(leafPEM)
+	if err != nil {
+		return nil, err
+	}
+	domains := names(cert)
+	if domain == "" {
+		if len(domains) == 0 {
+			return nil, fmt.Errorf("the certificate names no domain; pass --domain")
+		}
+		domain = domains[0]
+	} else if cert.VerifyHostname(domain) != nil {
+		return nil, fmt.Errorf("the certificate in %s is not valid for %s (it is for %v)", certPath, domain, domains)
+	}
+	ordered := []string{domain}
+	for _, d := range domains {
+		if d != domain {
+			ordered = append(ordered, d)
+		}
+	}
+	if name == "" {
+		name = domain
+	}
+
+	l := &Lineage{Name: name, Source: certPath, Versions: []Version{{Cert: leafPEM, Chain: chain, Key: key}}}
+	chain, fetched, err := acme.CompleteChain(leafPEM, chain, nil)
+	for _, f := range fetched {
+		l.Notes = append(l.Notes, fmt.Sprintf("added the missing intermediate %s from %s", f.Subject, f.URL))
+	}
+	if err != nil {
+		l.Notes = append(l.Notes, fmt.Sprintf("%v: clients that do not already have the issuing CA's intermediates will not trust it; pass --chain", err))
+	}
+	l.Versions[0].Chain = chain
+	if time.Now().After(cert.NotAfter) {
+		l.Notes = append(l.Notes, fmt.Sprintf("it expired on %s", cert.NotAfter.UTC().Format("2006-01-02")))
+	}
+	c := configFor(name, ordered)
+	c.Provider = "external"
+	if keyType, size, ok := acme.PublicKeyParams(cert.PublicKey); ok {
+		c.KeyType, c.KeySize = keyType, size
+	}
+	l.Config = c
+	return l, nil
+}
+
+// Replace stores the certificate of l, read by Cert, as the newest version
+// of the imported lineage c and records it as c's renewal, keeping c's
+// settings and taking on the other names of the new certificate. l must be
+// read for c.Domain.
+func Replace(c renewal.Config, l *Lineage) (renewal.Config, error) {
+	if renewal.IssuerName(c) != "external" {
+		return c, fmt.Errorf("%s is renewed from %s by trusttls; delete it first to manage an imported certificate under that name", c.Lineage(), renewal.IssuerName(c))
+	}
+	v := l.Versions[l.Current]
+	if _, err := store.SaveCertificate(c.BaseDir, c.Lineage(), v.Cert, v.Chain, v.Key); err != nil {
+		return c, err
+	}
+	if err := store.RecordIssuance(c.BaseDir, c.Lineage(), store.Issuance{Provider: "external", Source: l.Source}); err != nil {
+		return c, err
+	}
+	c.Domains = l.Config.Domains
+	c.KeyType, c.KeySize = l.Config.KeyType, l.Config.KeySize
+	if err := renewal.Save(c); err != nil {
+		return c, err
+	}
+	store.NoteRenewal(c.BaseDir, c.Lineage(), "external", nil)
+	return c, renewal.ApplyAccess(c)
+}
+
+// splitLeaf returns the first certificate in b as PEM, and the
+// certificates after it.
+func splitLeaf(b []byte) (leaf, rest []byte) {
+	for {
+		block, r := pem.Decode(b)
+		if block == nil {
+			return nil, nil
+		}
+		if block.Type == "CERTIFICATE" {
+			return pem.EncodeToMemory(block), bytes.TrimLeft(r, "\r\n")
+		}
+		b = r
+	}
+}
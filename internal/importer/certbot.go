@@ -0,0 +1,285 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+)
+
+// DefaultCertbotDir is where certbot keeps its configuration as root.
+const DefaultCertbotDir = "/etc/letsencrypt"
+
+var certbotVersionRe = regexp.MustCompile(`^cert(\d+)\.pem$`)
+
+// certbotCurves maps certbot's elliptic_curve to ECDSA key sizes.
+var certbotCurves = map[string]int{"secp256r1": 256, "secp384r1": 384, "secp521r1": 521}
+
+// Certbot reads the lineages certbot keeps in dir (normally
+// /etc/letsencrypt): every archived version, the renewal settings in
+// renewal/<name>.conf and the ACME account each was issued with. With name
+// only that lineage is read. email is used for accounts registered without
+// a contact address.
+func Certbot(dir, name, email string) ([]*Lineage, error) {
+	pattern := "*.conf"
+	if name != "" {
+		pattern = name + ".conf"
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "renewal", pattern))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("certbot has no certificate named %s in %s", name, dir)
+		}
+		return nil, fmt.Errorf("no certbot renewal configs in %s", filepath.Join(dir, "renewal"))
+	}
+	sort.Strings(paths)
+	var out []*Lineage
+	for _, p := range paths {
+		l, err := certbotLineage(dir, p, email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func certbotLineage(dir, confPath, email string) (*Lineage, error) {
+	conf, err := readConf(confPath)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(confPath), ".conf")
+	top, params := conf[""], conf["renewalparams"]
+	archive := top["archive_dir"]
+	if archive == "" {
+		archive = filepath.Join(dir, "archive", name)
+	}
+	live := filepath.Dir(top["cert"])
+	if top["cert"] == "" {
+		live = filepath.Join(dir, "live", name)
+	}
+	l := &Lineage{Name: name, Source: confPath, Paths: live}
+
+	// Every archived version, and which one live/ links to
+	entries, err := os.ReadDir(archive)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, e := range entries {
+		if m := certbotVersionRe.FindStringSubmatch(e.Name()); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", archive)
+	}
+	sort.Ints(numbers)
+	current := numbers[len(numbers)-1]
+	if target, err := os.Readlink(filepath.Join(live, "cert.pem")); err == nil {
+		if m := certbotVersionRe.FindStringSubmatch(filepath.Base(target)); m != nil {
+			current, _ = strconv.Atoi(m[1])
+		}
+	}
+	for _, n := range numbers {
+		var v Version
+		for kind, dst := range map[string]*[]byte{"cert": &v.Cert, "chain": &v.Chain, "privkey": &v.Key} {
+			if *dst, err = os.ReadFile(filepath.Join(archive, fmt.Sprintf("%s%d.pem", kind, n))); err != nil {
+				return nil, err
+			}
+		}
+		if n == current {
+			l.Current = len(l.Versions)
+		}
+		l.Versions = append(l.Versions, v)
+	}
+	cert, err := parseCert(l.Versions[l.Current].Cert)
+	if err != nil {
+		return nil, err
+	}
+	domains := names(cert)
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("the certificate names no domain")
+	}
+	c := configFor(name, domains)
+	c.Server = params["server"]
+	c.MustStaple = params["must_staple"] == "True"
+	c.ReuseKey = params["reuse_key"] == "True"
+	c.DeployHook = params["renew_hook"]
+	c.PostHook = params["post_hook"]
+	if params["pre_hook"] != "" {
+		l.Notes = append(l.Notes, fmt.Sprintf("pre_hook %q has no trusttls equivalent; run it from a deploy hook or your scheduler", params["pre_hook"]))
+	}
+	c.KeyType, c.KeySize = "rsa", 2048
+	if params["key_type"] == "ecdsa" {
+		c.KeyType, c.KeySize = "ecdsa", certbotCurves[params["elliptic_curve"]]
+	} else if size, err := strconv.Atoi(params["rsa_key_size"]); err == nil {
+		c.KeySize = size
+	}
+	if c.KeyType, c.KeySize, err = acme.NormalizeKey(c.KeyType, c.KeySize); err != nil {
+		return nil, err
+	}
+
+	// How names are validated
+	auth := params["authenticator"]
+	switch {
+	case auth == "webroot":
+		c.Method = "http-01"
+		c.Webroot = conf["webroot_map"][domains[0]]
+		if c.Webroot == "" {
+			c.Webroot = strings.Split(params["webroot_path"], ",")[0]
+		}
+	case auth == "standalone":
+		c.Method, c.Standalone = "http-01", ":80"
+		if port := params["http01_port"]; port != "" {
+			c.Standalone = ":" + port
+		}
+	case auth == "nginx" || auth == "apache":
+		c.Method = "http-01"
+		if auth == "nginx" {
+			c.Webroot = nginx.DetectWebroot(domains[0])
+		} else {
+			c.Webroot = apache.DetectWebroot(domains[0])
+		}
+		if c.Webroot == "" {
+			l.Notes = append(l.Notes, fmt.Sprintf("no webroot found for %s in the %s config; set webroot in the renewal config", domains[0], auth))
+		}
+	case strings.HasPrefix(auth, "dns-"):
+		provider, ok := dnsprovider.CertbotPlugin(auth)
+		if !ok {
+			return nil, fmt.Errorf("certbot's %s plugin has no trusttls equivalent", auth)
+		}
+		c.Method, c.DNSPlugin = "dns-01", provider
+		// route53 has no credentials file: it reads the AWS credential chain
+		if file := params[strings.ReplaceAll(auth, "-", "_")+"_credentials"]; file != "" {
+			imp, err := dnsprovider.ImportCertbotFile(provider, file)
+			if err != nil {
+				return nil, err
+			}
+			l.DNS = &imp
+		}
+	case auth == "manual":
+		c.Method, c.DNSPlugin = "dns-01", "manual"
+		l.Notes = append(l.Notes, "certbot validated it by hand (manual); 'trusttls renew' will ask for the DNS records")
+	default:
+		return nil, fmt.Errorf("certbot authenticator %q has no trusttls equivalent", auth)
+	}
+	switch params["installer"] {
+	case "nginx", "apache":
+		c.Targets = []string{params["installer"]}
+	}
+
+	if id := params["account"]; id != "" && c.Server != "" {
+		a, err := certbotAccount(dir, c.Server, id)
+		if err != nil {
+			return nil, err
+		}
+		if a.Email == "" {
+			a.Email = email
+		}
+		if a.Email == "" {
+			l.Notes = append(l.Notes, fmt.Sprintf("ACME account %s has no email address; pass --email to keep using it, or a new account is registered", a.URI))
+			a = nil
+		} else {
+			c.Email = a.Email
+		}
+		l.Account = a
+	}
+	if c.Email == "" {
+		c.Email = email
+	}
+	l.Config = c
+	return l, nil
+}
+
+// certbotAccount reads the ACME account id certbot registered on server.
+func certbotAccount(dir, server, id string) (*Account, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	accountDir := filepath.Join(dir, "accounts", u.Host, filepath.FromSlash(strings.Trim(u.Path, "/")), id)
+	b, err := os.ReadFile(filepath.Join(accountDir, "private_key.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(accountDir, "private_key.json"), err)
+	}
+	a := &Account{Server: server, Key: jwk.Key}
+	b, err = os.ReadFile(filepath.Join(accountDir, "regr.json"))
+	if err != nil {
+		return nil, err
+	}
+	var regr struct {
+		URI  string `json:"uri"`
+		Body struct {
+			Contact []string `json:"contact"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(b, &regr); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(accountDir, "regr.json"), err)
+	}
+	a.URI = regr.URI
+	for _, contact := range regr.Body.Contact {
+		if strings.HasPrefix(contact, "mailto:") {
+			a.Email = strings.TrimPrefix(contact, "mailto:")
+			break
+		}
+	}
+	return a, nil
+}
+
+// readConf reads a certbot renewal config by section: "" for the keys
+// before the first [section], then "renewalparams", "webroot_map", ...
+func readConf(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := map[string]map[string]string{"": {}}
+	section := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[] ")
+			if out[section] == nil {
+				out[section] = map[string]string{}
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if v == "None" {
+			v = ""
+		}
+		out[section][strings.TrimSpace(k)] = v
+	}
+	return out, s.Err()
+}
@@ -0,0 +1,155 @@
+// Package importer brings certificates managed by other ACME clients, or by
+// hand, into the trusttls store together with the renewal configs that keep
+// them renewing, so switching to trusttls does not mean reissuing them.
+package importer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/renewal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Lineage is a certificate found elsewhere, with its history and the
+// renewal config that takes over from the client it came from.
+type Lineage struct {
+	Name     string         // lineage name in the store
+	Source   string         // file or directory it was read from
+	Versions []Version      // oldest first
+	Current  int            // index in Versions of the version in use
+	Config   renewal.Config // Config.BaseDir is set by Save
+	Account  *Account       // ACME account it was issued with, if known
+	DNS      *dnsprovider.Imported
+	// Paths is the directory of the files web server configs point at,
+	// to be switched to the store's live/ directory
+	Paths string
+	// Notes are settings that could not be carried over
+	Notes []string
+}
+
+// Version is one certificate of a lineage, as PEM.
+type Version struct {
+	Cert, Chain, Key []byte
+}
+
+// Account is an ACME account registered by another client.
+type Account struct {
+	Server, Email, URI string
+	Key                crypto.PrivateKey
+}
+
+// ErrExists is returned by Save for a lineage the store already has.
+var ErrExists = errors.New("already in the store")
+
+// Save stores every version of l in baseDir, makes the one in use current,
+// imports its ACME account and DNS credentials, and writes its renewal
+// config. A lineage the store already has is left alone.
+func Save(baseDir string, l *Lineage) error {
+	if _, err := os.Stat(filepath.Join(baseDir, "live", store.LineageName(l.Name))); err == nil {
+		return fmt.Errorf("%s: %w", l.Name, ErrExists)
+	}
+	if _, err := renewal.Load(l.Name); err == nil {
+		return fmt.Errorf("%s: renewal config %w", l.Name, ErrExists)
+	}
+	var numbers []int
+	for _, v := range l.Versions {
+		if _, err := store.SaveCertificate(baseDir, l.Name, v.Cert, v.Chain, v.Key); err != nil {
+			return err
+		}
+		if err := store.RecordIssuance(baseDir, l.Name, store.Issuance{Provider: renewal.IssuerName(l.Config), Source: l.Source}); err != nil {
+			return err
+		}
+		n, err := store.CurrentVersion(baseDir, l.Name)
+		if err != nil {
+			return err
+		}
+		numbers = append(numbers, n)
+	}
+	if l.Current != len(l.Versions)-1 {
+		if err := store.Activate(baseDir, l.Name, numbers[l.Current]); err != nil {
+			return err
+		}
+	}
+	if a := l.Account; a != nil && a.Email != "" {
+		if _, err := acme.ImportAccount(baseDir, a.Server, a.Email, a.Key, a.URI); err != nil {
+			return fmt.Errorf("import ACME account %s: %w", a.URI, err)
+		}
+	}
+	if l.DNS != nil {
+		path, err := dnsprovider.SaveImported(baseDir, *l.DNS, false)
+		if errors.Is(err, os.ErrExist) {
+			path, err = dnsprovider.CredentialsPath(baseDir, l.DNS.Provider), nil
+		}
+		if err != nil {
+			return fmt.Errorf("import %s credentials: %w", l.DNS.Provider, err)
+		}
+		l.Config.DNSCredentials = path
+	}
+	l.Config.BaseDir = baseDir
+	return renewal.Save(l.Config)
+}
+
+// names returns the names a certificate is for, the one in its subject
+// first when it is among them.
+func names(cert *x509.Certificate) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(n string) {
+		if n != "" && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, n := range sans {
+		if n == cert.Subject.CommonName {
+			add(n)
+		}
+	}
+	for _, n := range sans {
+		add(n)
+	}
+	if len(out) == 0 && net.ParseIP(cert.Subject.CommonName) == nil {
+		add(cert.Subject.CommonName)
+	}
+	return out
+}
+
+// parseCert returns the first certificate in PEM data.
+func parseCert(b []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("no certificate in PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// configFor returns a renewal config for a certificate for names kept as
+// name.
+func configFor(name string, domains []string) renewal.Config {
+	c := renewal.Config{Domain: domains[0], Targets: []string{}, Provider: "letsencrypt"}
+	if len(domains) > 1 {
+		c.Domains = domains
+	}
+	if name != store.LineageName(domains[0]) {
+		c.CertName = name
+	}
+	return c
+}
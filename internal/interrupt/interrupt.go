@@ -0,0 +1,138 @@
+// Package interrupt lets a run stopped with Ctrl-C or SIGTERM leave the
+// system as it found it. Work that leaves traces until it is finished, such
+// as challenge files and DNS records, registers how to take them back with
+// OnInterrupt; writes that must not be cut short run under Hold. When a
+// signal arrives, the writes in progress are finished, the registered
+// cleanups run, newest first, and only then does the process exit.
+package interrupt
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// holdTimeout bounds the wait for writes in progress, so a write stuck on a
+// dead mount or a sudo prompt does not keep the cleanups from running.
+const holdTimeout = 30 * time.Second
+
+var (
+	mu       sync.Mutex
+	next     int
+	cleanups = map[int]cleanup{}
+	stopping bool
+
+	// writes is read-locked by each Hold and locked for good by the handler
+	writes sync.RWMutex
+
+	watchOnce sync.Once
+)
+
+type cleanup struct {
+	what string
+	fn   func() error
+}
+
+// OnInterrupt registers fn to undo what is described by what should the
+// process be interrupted before the returned function is called. Call that
+// once the work is undone or no longer needs undoing; it drops fn without
+// running it. Register before making the change, so an interrupt arriving
+// while it is made still undoes it: fn must cope with a change that was only
+// partly made.
+func OnInterrupt(what string, fn func() error) (done func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := next
+	next++
+	cleanups[id] = cleanup{what: what, fn: fn}
+	return func() {
+		mu.Lock()
+		delete(cleanups, id)
+		mu.Unlock()
+	}
+}
+
+// Hold keeps an interrupt from ending the process until the returned
+// function is called, for a write that would leave a half-written file. Once
+// an interrupt is handled Hold does not return, so no new write starts. It
+// must not be called from a cleanup.
+func Hold() (release func()) {
+	writes.RLock()
+	return writes.RUnlock
+}
+
+// Stopping reports whether an interrupt is being handled. Work that would
+// need cleaning up should not start.
+func Stopping() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return stopping
+}
+
+// Watch makes SIGINT and SIGTERM clean up before the process exits. Commands
+// that handle these signals themselves, like serve, do not call it.
+func Watch() {
+	watchOnce.Do(func() {
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			go func() {
+				<-sigs
+				fmt.Fprintln(os.Stderr, "⏹️  Interrupted again: exiting without finishing the cleanup")
+				os.Exit(exitCode(sig))
+			}()
+			fmt.Fprintf(os.Stderr, "\n⏹️  Interrupted (%s): cleaning up before exiting; interrupt again to exit now\n", sig)
+			stop()
+			os.Exit(exitCode(sig))
+		}()
+	})
+}
+
+// stop waits for the writes in progress and runs the registered cleanups.
+func stop() {
+	mu.Lock()
+	stopping = true
+	mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		writes.Lock()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(holdTimeout):
+		fmt.Fprintf(os.Stderr, "⚠️  A write did not finish within %s; cleaning up anyway\n", holdTimeout)
+	}
+
+	mu.Lock()
+	var ids []int
+	for id := range cleanups {
+		ids = append(ids, id)
+	}
+	pending := cleanups
+	cleanups = map[int]cleanup{}
+	mu.Unlock()
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	for _, id := range ids {
+		c := pending[id]
+		if err := c.fn(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Could not remove %s: %v\n", c.what, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "🧹 Removed %s\n", c.what)
+	}
+}
+
+// exitCode is the status a shell reports for a process killed by sig.
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
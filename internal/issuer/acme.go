@@ -0,0 +1,136 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+func init() {
+	Register("letsencrypt", newACME)
+	Register("step-ca", newACME)
+	Register("digicert-acme", newACME)
+	for _, name := range acme.PresetNames() {
+		Register(name, newACME)
+	}
+}
+
+// acmeIssuer orders from an ACME server: Let's Encrypt, a preset CA,
+// DigiCert ACME or a private server such as step-ca.
+type acmeIssuer struct {
+	name string
+	m    *acme.Manager
+	caps acme.Capabilities
+}
+
+// DigiCertACMEAccount returns the ACME server and external account binding
+// of the DigiCert ACME account of email saved in baseDir.
+func DigiCertACMEAccount(baseDir, email string) (*acme.DigiCertEABConfig, error) {
+	creds, err := store.NewAccountManager(baseDir).LoadAccount(email, "digicert")
+	if err != nil {
+		return nil, err
+	}
+
+	if creds.Provider != "digicert" {
+		return nil, fmt.Errorf("account is not a DigiCert account")
+	}
+
+	return &acme.DigiCertEABConfig{
+		ServerURL:  creds.Server,
+		EABKID:     creds.EABKID,
+		EABHMACKey: creds.EABHMACKey,
+		Email:      creds.Email,
+		BaseDir:    baseDir,
+	}, nil
+}
+
+func newACME(name string, s Settings) (Issuer, error) {
+	capsProvider := name
+	switch name {
+	case "letsencrypt":
+		// get-cert --server points this at other directories too
+		capsProvider = ""
+		if s.Server == "" {
+			s.Server = acme.LetsEncryptProd
+		}
+	case "step-ca":
+		capsProvider = ""
+		if s.Server == "" {
+			return nil, errors.New("step-ca needs the server's ACME directory URL (server, --server)")
+		}
+	case "digicert-acme":
+		capsProvider = "digicert"
+		if s.EABKID == "" {
+			cfg, err := DigiCertACMEAccount(s.BaseDir, s.Email)
+			if err != nil {
+				return nil, fmt.Errorf("load DigiCert ACME account: %w", err)
+			}
+			s.EABKID, s.EABHMACKey = cfg.EABKID, cfg.EABHMACKey
+			if s.Server == "" {
+				s.Server = cfg.ServerURL
+			}
+		}
+	default:
+		if s.Server == "" {
+			preset, _ := acme.LookupPreset(name)
+			u, err := preset.Directory("")
+			if err != nil {
+				return nil, err
+			}
+			s.Server = u
+		}
+	}
+	caps := acme.CapabilitiesFor(capsProvider, s.Server)
+	if err := checkKey(caps, s); err != nil {
+		return nil, err
+	}
+	m, err := acme.NewManager(acme.Options{
+		Email:              s.Email,
+		Server:             s.Server,
+		KeyType:            s.KeyType,
+		KeySize:            s.KeySize,
+		BaseDir:            s.BaseDir,
+		Resolvers:          s.Resolvers,
+		PropagationTimeout: s.PropagationTimeout,
+		PropagationCheck:   s.PropagationCheck,
+		EABKID:             s.EABKID,
+		EABHMACKey:         s.EABHMACKey,
+		CABundle:           s.CABundle,
+		CSR:                s.CSR,
+		MustStaple:         s.MustStaple,
+		IgnoreRateLimits:   s.IgnoreRateLimits,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &acmeIssuer{name: name, m: m, caps: caps}, nil
+}
+
+func (a *acmeIssuer) Capabilities() acme.Capabilities { return a.caps }
+
+func (a *acmeIssuer) Order(ctx context.Context, req Request) (*certificate.Resource, error) {
+	switch {
+	case req.DNSPlugin != "":
+		return a.m.ObtainDNS01(req.Domains, req.DNSPlugin, req.DNSCredentials)
+	case req.RemoteWebroot != "":
+		return a.m.ObtainHTTP01Remote(req.Domains, req.RemoteWebroot)
+	case req.Standalone != nil:
+		return a.m.ObtainHTTP01Standalone(req.Domains, req.Standalone)
+	case req.Webroot != "":
+		return a.m.ObtainHTTP01(req.Domains, req.Webroot)
+	}
+	return nil, errors.New("no way to validate the names: give a webroot, a standalone server or a DNS provider")
+}
+
+// Renew places a new order; ACME has no separate renewal request.
+func (a *acmeIssuer) Renew(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return a.Order(ctx, req)
+}
+
+func (a *acmeIssuer) Revoke(ctx context.Context, certPEM []byte) error {
+	return a.m.Revoke(certPEM)
+}
@@ -0,0 +1,125 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+func init() {
+	Register("digicert", newDigiCert)
+}
+
+// digicertIssuer orders through the CertCentral REST API, which also
+// covers OV and EV certificates. It needs a build with -tags digicert.
+type digicertIssuer struct {
+	s Settings
+}
+
+func newDigiCert(name string, s Settings) (Issuer, error) {
+	if s.Email == "" {
+		return nil, errors.New("DigiCert orders need the email of the DigiCert account")
+	}
+	return &digicertIssuer{s: s}, nil
+}
+
+// DigiCertAccount returns the CertCentral settings of the DigiCert account
+// of email saved in baseDir.
+func DigiCertAccount(baseDir, email string) (*acme.DigiCertConfig, error) {
+	creds, err := store.NewAccountManager(baseDir).LoadAccount(email, "digicert")
+	if err != nil {
+		return nil, err
+	}
+
+	if creds.Provider != "digicert" {
+		return nil, fmt.Errorf("account is not a DigiCert account")
+	}
+
+	wait := acme.OrderWait{
+		WebhookListen: creds.WebhookListen,
+		WebhookPath:   creds.WebhookPath,
+	}
+	if creds.OrderTimeout != "" {
+		d, err := time.ParseDuration(creds.OrderTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid order_timeout %q: %w", creds.OrderTimeout, err)
+		}
+		wait.Deadline = d
+	}
+
+	return &acme.DigiCertConfig{
+		ServerURL:      creds.Server,
+		HMACID:         creds.HMACID,
+		HMACKey:        creds.HMACKey,
+		APIKey:         creds.APIKey,
+		AccountID:      creds.AccountID,
+		OrganizationID: creds.OrganizationID,
+		OrderWait:      wait,
+	}, nil
+}
+
+// DigiCertConfig returns the CertCentral settings for ordering req: the
+// account of s.Email with s's validation level and req's domain control
+// validation settings.
+func DigiCertConfig(s Settings, req Request) (*acme.DigiCertConfig, error) {
+	cfg, err := DigiCertAccount(s.BaseDir, s.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DigiCert credentials: %w", err)
+	}
+	// Domain control validation uses the same webroot or DNS provider
+	// settings as ACME orders
+	cfg.Webroot = req.Webroot
+	if req.DNSPlugin != "" {
+		cfg.DNSPlugin = req.DNSPlugin
+		cfg.DNSCredentials = req.DNSCredentials
+	}
+	if s.OrganizationID != "" {
+		cfg.OrganizationID = s.OrganizationID
+	}
+	cfg.Validation = s.Validation
+	cfg.ContactID = s.ContactID
+	cfg.StateFile = s.StateFile
+	cfg.MustStaple = s.MustStaple
+	cfg.KeyType, cfg.KeySize = s.KeyType, s.KeySize
+	return cfg, nil
+}
+
+func (d *digicertIssuer) Capabilities() acme.Capabilities {
+	return acme.Capabilities{CA: "DigiCert CertCentral", Wildcard: true, WildcardHTTP: true, MaxNames: 250, KeySizes: acme.WebPKIKeys}
+}
+
+func (d *digicertIssuer) Order(ctx context.Context, req Request) (*certificate.Resource, error) {
+	if d.s.CSR != nil {
+		return nil, errors.New("DigiCert CertCentral orders generate their own key; drop the CSR")
+	}
+	cfg, err := DigiCertConfig(d.s, req)
+	if err != nil {
+		return nil, err
+	}
+	p, err := acme.NewDigiCertProvider(*cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DigiCert provider: %w", err)
+	}
+	obtainer, ok := p.(interface {
+		ObtainCertificateContext(context.Context, []string) (*certificate.Resource, error)
+	})
+	if !ok {
+		return nil, errors.New("DigiCert provider interface not available")
+	}
+	return obtainer.ObtainCertificateContext(ctx, req.Domains)
+}
+
+// Renew places a new order, or resumes the one a previous run left
+// pending.
+func (d *digicertIssuer) Renew(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return d.Order(ctx, req)
+}
+
+func (d *digicertIssuer) Revoke(ctx context.Context, certPEM []byte) error {
+	return errors.New("revoking DigiCert CertCentral certificates is not supported; revoke it in CertCentral")
+}
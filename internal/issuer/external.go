@@ -0,0 +1,40 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+)
+
+func init() {
+	Register("external", newExternal)
+	unvalidated["external"] = true
+}
+
+// externalIssuer stands for the CA of a certificate brought in with
+// 'trusttls import cert'. trusttls cannot order from it, so renewing fails
+// with what to do instead; the failure is what warns about the expiry.
+type externalIssuer struct{}
+
+func newExternal(name string, s Settings) (Issuer, error) {
+	return externalIssuer{}, nil
+}
+
+func (externalIssuer) Capabilities() acme.Capabilities {
+	return acme.Capabilities{CA: "the CA it was imported from", Wildcard: true, WildcardHTTP: true, IPAddresses: true}
+}
+
+func (externalIssuer) Order(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return nil, fmt.Errorf("%s was imported from another CA, which trusttls cannot order from: get its replacement there and run 'trusttls import cert --domain %s --cert <file> --key <file>'", req.Domains[0], req.Domains[0])
+}
+
+func (e externalIssuer) Renew(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return e.Order(ctx, req)
+}
+
+func (externalIssuer) Revoke(ctx context.Context, certPEM []byte) error {
+	return errors.New("imported certificates are revoked through the CA that issued them")
+}
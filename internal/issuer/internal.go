@@ -0,0 +1,77 @@
+package issuer
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/ca"
+)
+
+func init() {
+	Register("internal", newInternal)
+	unvalidated["internal"] = true
+	keyTypes["internal"] = acme.KeyECDSA
+}
+
+// internalIssuer signs with one of the CAs kept in the store (edge or
+// dev). Names are not validated: the CA trusts whoever runs trusttls.
+type internalIssuer struct {
+	authority *ca.Authority
+	s         Settings
+}
+
+func newInternal(name string, s Settings) (Issuer, error) {
+	if s.Lifetime <= 0 {
+		return nil, errors.New("the internal CA needs a certificate lifetime")
+	}
+	authorityName := s.CA
+	if authorityName == "" {
+		authorityName = ca.EdgeCA
+	}
+	authority, err := ca.LoadOrCreate(s.BaseDir, authorityName)
+	if err != nil {
+		return nil, fmt.Errorf("load %s CA: %w", authorityName, err)
+	}
+	return &internalIssuer{authority: authority, s: s}, nil
+}
+
+func (i *internalIssuer) Capabilities() acme.Capabilities {
+	return acme.Capabilities{CA: "the " + i.authority.Name + " CA", Wildcard: true, WildcardHTTP: true, IPAddresses: true}
+}
+
+func (i *internalIssuer) Order(ctx context.Context, req Request) (*certificate.Resource, error) {
+	if i.s.CSR == nil {
+		return i.authority.Issue(ca.LeafRequest{
+			Domains:  req.Domains,
+			Lifetime: i.s.Lifetime,
+			KeyType:  i.s.KeyType,
+			KeySize:  i.s.KeySize,
+
+			MustStaple: i.s.MustStaple,
+		})
+	}
+	leaf, err := i.authority.SignCSR(i.s.CSR, i.s.Lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate.Resource{
+		Domain:            req.Domains[0],
+		Certificate:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}),
+		IssuerCertificate: i.authority.CertPEM,
+		CSR:               pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: i.s.CSR.Raw}),
+	}, nil
+}
+
+func (i *internalIssuer) Renew(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return i.Order(ctx, req)
+}
+
+// Revoke is not supported: internal certificates are short-lived and the
+// CA publishes no revocation list.
+func (i *internalIssuer) Revoke(ctx context.Context, certPEM []byte) error {
+	return errors.New("the internal CA does not revoke certificates; they expire after their short lifetime")
+}
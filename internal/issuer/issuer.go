@@ -0,0 +1,193 @@
+// Package issuer puts every certificate authority trusttls can order from
+// behind one interface. The CLI and renewal look issuers up by the provider
+// name kept in renewal configs, so adding a CA is a new file here rather
+// than another branch in each command.
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/standalone"
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// Issuer orders certificates from one CA.
+type Issuer interface {
+	// Capabilities describes what the CA will issue, for checks before an
+	// order is placed.
+	Capabilities() acme.Capabilities
+	// Order issues a new certificate for req.
+	Order(ctx context.Context, req Request) (*certificate.Resource, error)
+	// Renew issues the replacement of a certificate ordered with the same
+	// settings and request.
+	Renew(ctx context.Context, req Request) (*certificate.Resource, error)
+	// Revoke asks the CA to revoke the PEM certificate certPEM.
+	Revoke(ctx context.Context, certPEM []byte) error
+}
+
+// Settings configure an issuer: the account and CA to use and what to ask
+// for. Fields an issuer has no use for are ignored.
+type Settings struct {
+	BaseDir string
+	Email   string
+	// Server is the ACME directory URL, or the Vault PKI issue endpoint.
+	// Empty means the issuer's default, where it has one.
+	Server   string
+	CABundle string // extra roots to trust for Server
+	// EABKID and EABHMACKey bind a new ACME account; DigiCert ACME reads
+	// them from the stored account when empty.
+	EABKID     string
+	EABHMACKey string
+
+	KeyType string
+	KeySize int
+	// CSR is signed as is instead of a request for a new key.
+	CSR *x509.CertificateRequest
+	// MustStaple asks for the OCSP Must-Staple (TLS Feature) extension on
+	// certificates for new keys; a CSR carries its own extensions.
+	MustStaple bool
+	// IgnoreRateLimits orders even when the issuance log says a rate limit
+	// of the CA would be exceeded.
+	IgnoreRateLimits bool
+
+	Resolvers          []string
+	PropagationTimeout time.Duration
+	PropagationCheck   string
+
+	// CA and Lifetime are for the internal CA (and Vault's ttl).
+	CA       string
+	Lifetime time.Duration
+
+	// DigiCert CertCentral OV/EV ordering; see acme.DigiCertConfig.
+	Validation     string
+	OrganizationID string
+	ContactID      string
+	StateFile      string
+}
+
+// Request is one certificate to issue and how to prove control of its
+// names, for CAs that validate: set one of the webroot fields, Standalone
+// or DNSPlugin.
+type Request struct {
+	Domains []string
+
+	Webroot        string
+	RemoteWebroot  string
+	Standalone     *standalone.Server
+	DNSPlugin      string
+	DNSCredentials dnsprovider.Credentials
+}
+
+// Method is the ACME challenge type req validates with.
+func (r Request) Method() string {
+	if r.DNSPlugin != "" {
+		return "dns-01"
+	}
+	return "http-01"
+}
+
+// Factory builds the issuer registered as name.
+type Factory func(name string, s Settings) (Issuer, error)
+
+var (
+	factories = map[string]Factory{}
+	// unvalidated issuers sign whatever they are asked for, so requests
+	// to them need no webroot or DNS provider.
+	unvalidated = map[string]bool{}
+	// keyTypes holds the key type of issuers that do not default to RSA
+	// when the settings name none.
+	keyTypes = map[string]string{}
+)
+
+// Register makes an issuer available under name.
+func Register(name string, f Factory) {
+	factories[name] = f
+}
+
+// New returns the issuer registered as name; an empty name is Let's
+// Encrypt, as in renewal configs written before providers were recorded.
+func New(name string, s Settings) (Issuer, error) {
+	if name == "" {
+		name = "letsencrypt"
+	}
+	if err := Check(name); err != nil {
+		return nil, err
+	}
+	if err := readonly.Check("order certificates from " + name); err != nil {
+		return nil, err
+	}
+	if s.KeyType == "" {
+		s.KeyType = DefaultKeyType(name)
+	}
+	// Keys no CA could take are refused before the issuer contacts anyone
+	if err := checkKey(acme.Capabilities{}, s); err != nil {
+		return nil, err
+	}
+	iss, err := factories[strings.ToLower(name)](strings.ToLower(name), s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKey(iss.Capabilities(), s); err != nil {
+		return nil, err
+	}
+	return iss, nil
+}
+
+// checkKey returns why caps would refuse the certificate key of s: the one
+// to generate, or the CSR's.
+func checkKey(caps acme.Capabilities, s Settings) error {
+	if s.CSR == nil {
+		return caps.CheckKey(s.KeyType, s.KeySize)
+	}
+	keyType, size, ok := acme.PublicKeyParams(s.CSR.PublicKey)
+	if !ok {
+		return nil // the CA decides on key types trusttls does not generate
+	}
+	if err := caps.CheckKey(keyType, size); err != nil {
+		return fmt.Errorf("the CSR's key: %w", err)
+	}
+	return nil
+}
+
+// Check returns an error naming the available issuers unless name is
+// registered.
+func Check(name string) error {
+	if _, ok := factories[strings.ToLower(name)]; !ok {
+		return fmt.Errorf("unknown certificate provider %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return nil
+}
+
+// DefaultKeyType returns the key type the issuer registered as name
+// generates when the settings name none: ECDSA for the internal CA and
+// Vault, RSA otherwise.
+func DefaultKeyType(name string) string {
+	if t, ok := keyTypes[strings.ToLower(name)]; ok {
+		return t
+	}
+	return acme.KeyRSA
+}
+
+// Validates reports whether the issuer registered as name proves control
+// of the names before issuing.
+func Validates(name string) bool {
+	return !unvalidated[strings.ToLower(name)]
+}
+
+// Names returns the registered issuer names, sorted.
+func Names() []string {
+	var out []string
+	for n := range factories {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
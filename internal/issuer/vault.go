@@ -0,0 +1,160 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/osutil"
+)
+
+func init() {
+	Register("vault", newVault)
+	unvalidated["vault"] = true
+	keyTypes["vault"] = acme.KeyECDSA
+}
+
+// vaultIssuer issues from a Vault PKI secrets engine with the vault CLI,
+// which picks up VAULT_ADDR and VAULT_TOKEN from the environment like the
+// vault: key sink. Settings.Server is the role's issue path, e.g.
+// "pki/issue/web"; Vault's role decides which names it allows.
+type vaultIssuer struct {
+	path string
+	s    Settings
+}
+
+func newVault(name string, s Settings) (Issuer, error) {
+	path := strings.Trim(s.Server, "/")
+	if !strings.Contains(path, "/issue/") {
+		return nil, fmt.Errorf("vault needs the PKI role's issue path as server, e.g. pki/issue/web, not %q", s.Server)
+	}
+	if s.MustStaple {
+		return nil, errors.New("vault PKI roles cannot add the OCSP Must-Staple extension")
+	}
+	if !osutil.CommandExists("vault") {
+		return nil, errors.New("vault CLI not found on PATH")
+	}
+	return &vaultIssuer{path: path, s: s}, nil
+}
+
+func (v *vaultIssuer) Capabilities() acme.Capabilities {
+	return acme.Capabilities{CA: "Vault (" + v.path + ")", Wildcard: true, WildcardHTTP: true, IPAddresses: true, KeySizes: vaultKeys}
+}
+
+// vaultKeys are the key_bits Vault PKI roles accept for the key types
+// trusttls asks for.
+var vaultKeys = map[string][]int{acme.KeyRSA: {2048, 3072, 4096, 8192}, acme.KeyECDSA: {256, 384, 521}}
+
+// vaultCertificate is the data Vault returns from issue and sign.
+type vaultCertificate struct {
+	Certificate string   `json:"certificate"`
+	IssuingCA   string   `json:"issuing_ca"`
+	CAChain     []string `json:"ca_chain"`
+	PrivateKey  string   `json:"private_key"`
+}
+
+func (v *vaultIssuer) Order(ctx context.Context, req Request) (*certificate.Resource, error) {
+	path := v.path
+	var dnsNames, ips []string
+	for _, d := range req.Domains {
+		if acme.IsIP(d) {
+			ips = append(ips, d)
+		} else {
+			dnsNames = append(dnsNames, d)
+		}
+	}
+	args := []string{"common_name=" + req.Domains[0]}
+	if len(dnsNames) > 0 {
+		args = append(args, "alt_names="+strings.Join(dnsNames, ","))
+	}
+	if len(ips) > 0 {
+		args = append(args, "ip_sans="+strings.Join(ips, ","))
+	}
+	if v.s.Lifetime > 0 {
+		args = append(args, "ttl="+v.s.Lifetime.String())
+	}
+	var csrPEM []byte
+	if v.s.CSR != nil {
+		path = strings.Replace(path, "/issue/", "/sign/", 1)
+		csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: v.s.CSR.Raw})
+		args = append(args, "csr="+string(csrPEM))
+	} else {
+		keyType, keyBits, err := acme.NormalizeKey(v.s.KeyType, v.s.KeySize)
+		if err != nil {
+			return nil, err
+		}
+		if keyType == acme.KeyECDSA {
+			keyType = "ec"
+		}
+		args = append(args, "key_type="+keyType, fmt.Sprintf("key_bits=%d", keyBits))
+	}
+	var resp struct {
+		Data vaultCertificate `json:"data"`
+	}
+	if err := v.write(ctx, path, args, &resp); err != nil {
+		return nil, err
+	}
+	chain := resp.Data.CAChain
+	if len(chain) == 0 && resp.Data.IssuingCA != "" {
+		chain = []string{resp.Data.IssuingCA}
+	}
+	return &certificate.Resource{
+		Domain:            req.Domains[0],
+		Certificate:       []byte(strings.TrimSpace(resp.Data.Certificate) + "\n"),
+		IssuerCertificate: []byte(strings.TrimSpace(strings.Join(chain, "\n")) + "\n"),
+		PrivateKey:        []byte(resp.Data.PrivateKey),
+		CSR:               csrPEM,
+	}, nil
+}
+
+func (v *vaultIssuer) Renew(ctx context.Context, req Request) (*certificate.Resource, error) {
+	return v.Order(ctx, req)
+}
+
+// Revoke revokes certPEM by serial number through the PKI mount it was
+// issued from.
+func (v *vaultIssuer) Revoke(ctx context.Context, certPEM []byte) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.New("no PEM certificate to revoke")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	var serial []string
+	for _, b := range leaf.SerialNumber.Bytes() {
+		serial = append(serial, fmt.Sprintf("%02x", b))
+	}
+	mount := v.path[:strings.Index(v.path, "/issue/")]
+	return v.write(ctx, mount+"/revoke", []string{"serial_number=" + strings.Join(serial, ":")}, nil)
+}
+
+// write runs "vault write" on path and decodes its JSON answer into out.
+func (v *vaultIssuer) write(ctx context.Context, path string, args []string, out interface{}) error {
+	cmd := exec.CommandContext(ctx, "vault", append([]string{"write", "-format=json", path}, args...)...)
+	if v.s.CABundle != "" {
+		cmd.Env = append(cmd.Environ(), "VAULT_CACERT="+v.s.CABundle)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("vault write %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("vault write %s: %w", path, err)
+	}
+	return nil
+}
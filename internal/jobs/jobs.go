@@ -0,0 +1,420 @@
+// Package jobs is the persistent queue of issuance requests behind trusttls
+// jobs and serve. It keeps the queue as plain files, one JSON file and one
+// flock per job, rather than in a SQLite database: the rest of the store is
+// plain files too, and the binary needs no database driver or cgo.
+//
+// What the files give up is a database's transactions. The lock on a job
+// is the only thing held across processes; enqueueing checks for a queued
+// job of the same lineage under an in-process mutex, and the spacing of
+// orders to a CA is kept in memory. Several servers sharing a store, over
+// NFS say, therefore
+//
+//   - run a job once only where the file system carries flock between
+//     machines, as NFSv4 does; where locks stay on the machine that took
+//     them, a job running on one server looks abandoned to another, which
+//     runs it again
+//   - can both queue the same renewal when they enqueue it at the same
+//     moment, and then order it twice
+//   - each space their own orders to a CA, so the CA sees them closer
+//     together than PerCAInterval
+//
+// Letting one server run the queue while the others only enqueue avoids the
+// first and the last.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Job states.
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+const (
+	defaultMaxAttempts = 5
+	baseBackoff        = time.Minute
+	maxBackoff         = 6 * time.Hour
+)
+
+// Job is a single persisted issuance request.
+type Job struct {
+	ID          string    `json:"id"`
+	Domain      string    `json:"domain"`
+	Server      string    `json:"server"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Owner names the process running the job, as pid@host, while it is
+	// running
+	Owner string `json:"owner,omitempty"`
+	// Request is the approval request the job orders, finished as Actor's
+	// once the job succeeds or gives up
+	Request string `json:"request,omitempty"`
+	Actor   string `json:"actor,omitempty"`
+}
+
+// ErrBusy is returned for a job another process is running.
+var ErrBusy = errors.New("another process is running it")
+
+// Queue stores jobs as one JSON file per job under <baseDir>/jobs so that
+// pending work survives restarts. A job is claimed by taking the lock on
+// its <id>.lock file, held until its attempt is recorded, so two processes
+// sharing the queue never run the same job. The kernel releases the lock of
+// a process that dies, which is how a job left running is told from one
+// that still is.
+type Queue struct {
+	dir string
+	// PerCAInterval is the minimum time between two orders sent to the same CA.
+	PerCAInterval time.Duration
+	// Workers is how many jobs RunDue runs at once.
+	Workers int
+	// CAWorkers is how many of them may be orders to the same CA.
+	CAWorkers int
+
+	mu        sync.Mutex // guards lastStart and serializes enqueueing
+	lastStart map[string]time.Time
+}
+
+func NewQueue(baseDir string) *Queue {
+	return &Queue{
+		dir:           filepath.Join(baseDir, "jobs"),
+		PerCAInterval: 10 * time.Second,
+		Workers:       1,
+		CAWorkers:     1,
+		lastStart:     map[string]time.Time{},
+	}
+}
+
+func (q *Queue) path(id string) string { return filepath.Join(q.dir, id+".json") }
+
+func (q *Queue) lockPath(id string) string { return filepath.Join(q.dir, id+".lock") }
+
+// claim takes the lock of the job id and returns the job as saved, read
+// again under the lock, with the function releasing it.
+func (q *Queue) claim(id string) (*Job, func(), error) {
+	if _, err := q.Get(id); err != nil {
+		return nil, nil, err
+	}
+	release, err := store.TryLockFile(q.lockPath(id))
+	if errors.Is(err, store.ErrLocked) {
+		return nil, nil, fmt.Errorf("job %s: %w", id, ErrBusy)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	j, err := q.Get(id)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if j.State == StateRunning {
+		// Its owner died before recording the attempt, which does
+		// not count
+		j.State = StatePending
+		j.Owner = ""
+		if j.Attempts > 0 {
+			j.Attempts--
+		}
+	}
+	return j, release, nil
+}
+
+func (q *Queue) save(j *Job) error {
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return err
+	}
+	j.UpdatedAt = time.Now()
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path(j.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(j.ID))
+}
+
+// Get loads a job by id.
+func (q *Queue) Get(id string) (*Job, error) {
+	b, err := os.ReadFile(q.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("job %s not found", id)
+		}
+		return nil, err
+	}
+	var j Job
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Enqueue adds a pending issuance job for domain against the given CA server.
+// An existing pending job for the same domain is returned instead of a duplicate.
+func (q *Queue) Enqueue(domain, server string) (*Job, error) {
+	return q.enqueue(&Job{Domain: domain, Server: server, MaxAttempts: defaultMaxAttempts})
+}
+
+// EnqueueOrder adds a job ordering the certificate of the approved request
+// id, whose renewal config is named lineage. It is tried once, as the
+// requester asked for one order; a crash before its outcome is recorded
+// does not count as the try.
+func (q *Queue) EnqueueOrder(lineage, server, id, actor string) (*Job, error) {
+	return q.enqueue(&Job{Domain: lineage, Server: server, MaxAttempts: 1, Request: id, Actor: actor})
+}
+
+func (q *Queue) enqueue(j *Job) (*Job, error) {
+	if j.Domain == "" {
+		return nil, errors.New("domain required")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	existing, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range existing {
+		if e.Domain == j.Domain && e.Request == j.Request && (e.State == StatePending || e.State == StateRunning) {
+			return e, nil
+		}
+	}
+	now := time.Now()
+	j.ID, j.State, j.NextAttempt, j.CreatedAt = newID(), StatePending, now, now
+	return j, q.save(j)
+}
+
+// List returns all jobs ordered by creation time.
+func (q *Queue) List() ([]*Job, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []*Job
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		j, err := q.Get(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		out = append(out, j)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].CreatedAt.Before(out[b].CreatedAt) })
+	return out, nil
+}
+
+// Retry puts a failed or cancelled job back into the pending state.
+func (q *Queue) Retry(id string) (*Job, error) {
+	j, release, err := q.claim(id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if j.State == StateSucceeded {
+		return nil, fmt.Errorf("job %s is %s and cannot be retried", id, j.State)
+	}
+	j.State = StatePending
+	j.Attempts = 0
+	j.NextAttempt = time.Now()
+	return j, q.save(j)
+}
+
+// Cancel stops a pending job from being processed.
+func (q *Queue) Cancel(id string) (*Job, error) {
+	j, release, err := q.claim(id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if j.State != StatePending && j.State != StateFailed {
+		return nil, fmt.Errorf("job %s is %s and cannot be cancelled", id, j.State)
+	}
+	j.State = StateCancelled
+	return j, q.save(j)
+}
+
+// RunDue processes every pending job whose next attempt is due, calling handle
+// for each one. Up to Workers jobs run at once, at most CAWorkers of them
+// against the same CA. Failed attempts are rescheduled with exponential
+// backoff until MaxAttempts is reached. Jobs another process is running
+// are skipped; jobs left running by a process that died are pending again.
+func (q *Queue) RunDue(handle func(*Job) error) (processed int, err error) {
+	list, err := q.List()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		mu      sync.Mutex // guards processed and err
+		wg      sync.WaitGroup
+		work    = make(chan *Job)
+		caSlots = map[string]chan struct{}{}
+	)
+	for _, j := range list {
+		if key := caKey(j.Server); caSlots[key] == nil {
+			caSlots[key] = make(chan struct{}, max(q.CAWorkers, 1))
+		}
+	}
+	for i := 0; i < max(q.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				ca := caSlots[caKey(j.Server)]
+				ca <- struct{}{}
+				ran, serr := q.run(j.ID, true, handle)
+				<-ca
+				mu.Lock()
+				if ran {
+					processed++
+				}
+				if serr != nil && err == nil {
+					err = serr
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range list {
+		if j.State != StateRunning && (j.State != StatePending || time.Now().Before(j.NextAttempt)) {
+			continue
+		}
+		mu.Lock()
+		stop := err != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		work <- j
+	}
+	close(work)
+	wg.Wait()
+	return processed, err
+}
+
+// RunJob makes one attempt at the pending job id now, whether or not it is
+// due, and returns it with the outcome recorded. It fails with ErrBusy
+// while another process runs the job.
+func (q *Queue) RunJob(id string, handle func(*Job) error) (*Job, error) {
+	ran, err := q.run(id, false, handle)
+	if err != nil {
+		return nil, err
+	}
+	j, gerr := q.Get(id)
+	if gerr != nil {
+		return nil, gerr
+	}
+	if !ran {
+		return j, fmt.Errorf("job %s is %s", id, j.State)
+	}
+	return j, nil
+}
+
+// run claims the job id and makes one attempt at it, recording its
+// outcome. ran is false when the job was no longer pending, or not yet due
+// when onlyDue is set, or another process holds it.
+func (q *Queue) run(id string, onlyDue bool, handle func(*Job) error) (ran bool, err error) {
+	j, release, err := q.claim(id)
+	if errors.Is(err, ErrBusy) && onlyDue {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if j.State != StatePending || (onlyDue && time.Now().Before(j.NextAttempt)) {
+		return false, nil
+	}
+	q.waitForCA(j.Server)
+	j.State = StateRunning
+	j.Owner = owner()
+	j.Attempts++
+	if err := q.save(j); err != nil {
+		return false, err
+	}
+	if herr := handle(j); herr != nil {
+		j.LastError = herr.Error()
+		if j.Attempts >= j.MaxAttempts {
+			j.State = StateFailed
+		} else {
+			j.State = StatePending
+			j.NextAttempt = time.Now().Add(backoff(j.Attempts))
+		}
+	} else {
+		j.LastError = ""
+		j.State = StateSucceeded
+	}
+	j.Owner = ""
+	return true, q.save(j)
+}
+
+// owner names this process for Job.Owner.
+func owner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%d@%s", os.Getpid(), host)
+}
+
+// waitForCA blocks until PerCAInterval has passed since the last order sent to
+// the same CA host.
+func (q *Queue) waitForCA(server string) {
+	key := caKey(server)
+	q.mu.Lock()
+	start := time.Now()
+	if last, ok := q.lastStart[key]; ok && last.Add(q.PerCAInterval).After(start) {
+		start = last.Add(q.PerCAInterval)
+	}
+	q.lastStart[key] = start
+	q.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+func caKey(server string) string {
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return server
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102150405"), hex.EncodeToString(b))
+}
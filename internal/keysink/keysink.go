@@ -0,0 +1,115 @@
+// Package keysink delivers private keys straight to the service that uses
+// them, for lineages whose key material must never be kept on the issuing host.
+package keysink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/osutil"
+)
+
+// Sink receives a freshly issued certificate together with its private key.
+type Sink interface {
+	Write(domain string, cert *certificate.Resource) error
+	String() string
+}
+
+// Parse turns a sink specification into a Sink. Supported forms:
+//
+//	file:/etc/ssl/private/example.com.key
+//	k8s:<namespace>/<secret-name>
+//	vault:<kv-path>
+func Parse(spec string) (Sink, error) {
+	kind, target, ok := strings.Cut(spec, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("invalid key sink %q: expected file:, k8s: or vault: prefix", spec)
+	}
+	switch kind {
+	case "file":
+		if !filepath.IsAbs(target) {
+			return nil, fmt.Errorf("key sink file path must be absolute: %s", target)
+		}
+		return fileSink{path: target}, nil
+	case "k8s":
+		ns, name, ok := strings.Cut(target, "/")
+		if !ok || ns == "" || name == "" {
+			return nil, fmt.Errorf("invalid k8s key sink %q: expected k8s:<namespace>/<secret>", spec)
+		}
+		return k8sSink{namespace: ns, name: name}, nil
+	case "vault":
+		return vaultSink{path: target}, nil
+	default:
+		return nil, fmt.Errorf("unknown key sink type: %s", kind)
+	}
+}
+
+type fileSink struct{ path string }
+
+func (s fileSink) String() string { return "file:" + s.path }
+
+func (s fileSink) Write(domain string, cert *certificate.Resource) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, cert.PrivateKey, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+type k8sSink struct{ namespace, name string }
+
+func (s k8sSink) String() string { return "k8s:" + s.namespace + "/" + s.name }
+
+// Write applies a kubernetes.io/tls secret through kubectl. The manifest is
+// passed on stdin so the key never touches the local filesystem.
+func (s k8sSink) Write(domain string, cert *certificate.Resource) error {
+	if !osutil.CommandExists("kubectl") {
+		return fmt.Errorf("kubectl not found on PATH")
+	}
+	full := append(append([]byte{}, cert.Certificate...), cert.IssuerCertificate...)
+	manifest := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "kubernetes.io/tls",
+		"metadata": map[string]interface{}{
+			"name":      s.name,
+			"namespace": s.namespace,
+			"labels":    map[string]string{"app.kubernetes.io/managed-by": "trusttls"},
+		},
+		"data": map[string]string{
+			"tls.crt": base64.StdEncoding.EncodeToString(full),
+			"tls.key": base64.StdEncoding.EncodeToString(cert.PrivateKey),
+		},
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return osutil.RunWithInput(b, "kubectl", "apply", "-f", "-")
+}
+
+type vaultSink struct{ path string }
+
+func (s vaultSink) String() string { return "vault:" + s.path }
+
+// Write stores the key and chain in a Vault KV path using the vault CLI, which
+// picks up VAULT_ADDR and VAULT_TOKEN from the environment.
+func (s vaultSink) Write(domain string, cert *certificate.Resource) error {
+	if !osutil.CommandExists("vault") {
+		return fmt.Errorf("vault CLI not found on PATH")
+	}
+	full := append(append([]byte{}, cert.Certificate...), cert.IssuerCertificate...)
+	return osutil.RunWithInput(cert.PrivateKey, "vault", "kv", "put", s.path,
+		"domain="+domain,
+		"certificate="+string(full),
+		"private_key=-",
+	)
+}
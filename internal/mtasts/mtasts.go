@@ -0,0 +1,139 @@
+// Package mtasts publishes MTA-STS (RFC 8461) policies: the policy file
+// served at https://mta-sts.<domain>/.well-known/mta-sts.txt and the
+// _mta-sts.<domain> TXT record whose id tells senders to refetch it.
+package mtasts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/trustctl/trusttls/internal/resolver"
+)
+
+// Policy modes.
+const (
+	ModeEnforce = "enforce"
+	ModeTesting = "testing"
+	ModeNone    = "none"
+)
+
+// DefaultMaxAge is one week, the commonly recommended policy lifetime.
+const DefaultMaxAge = 604800
+
+// Policy is the content of an MTA-STS policy file.
+type Policy struct {
+	Mode   string
+	MX     []string
+	MaxAge int
+}
+
+// Validate checks the policy against RFC 8461 section 3.2.
+func (p Policy) Validate() error {
+	switch p.Mode {
+	case ModeEnforce, ModeTesting, ModeNone:
+	default:
+		return fmt.Errorf("mta-sts: mode must be enforce, testing or none, not %q", p.Mode)
+	}
+	if p.Mode != ModeNone && len(p.MX) == 0 {
+		return fmt.Errorf("mta-sts: at least one mx host is required")
+	}
+	if p.MaxAge <= 0 || p.MaxAge > 31557600 {
+		return fmt.Errorf("mta-sts: max_age must be between 1 and 31557600 seconds")
+	}
+	return nil
+}
+
+// Render returns the policy file, with CRLF line endings as the RFC requires.
+func (p Policy) Render() string {
+	var b strings.Builder
+	b.WriteString("version: STSv1\r\n")
+	fmt.Fprintf(&b, "mode: %s\r\n", p.Mode)
+	for _, mx := range p.MX {
+		fmt.Fprintf(&b, "mx: %s\r\n", mx)
+	}
+	fmt.Fprintf(&b, "max_age: %d\r\n", p.MaxAge)
+	return b.String()
+}
+
+// PolicyHost is the host name the policy must be served from.
+func PolicyHost(domain string) string { return "mta-sts." + domain }
+
+// PolicyPath is where the policy file lives inside the web root serving
+// PolicyHost.
+func PolicyPath(webroot string) string {
+	return filepath.Join(webroot, ".well-known", "mta-sts.txt")
+}
+
+// WritePolicy writes p into webroot and reports whether the file changed.
+func WritePolicy(webroot string, p Policy) (bool, error) {
+	path := PolicyPath(webroot)
+	content := p.Render()
+	if old, err := os.ReadFile(path); err == nil && string(old) == content {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// NewID returns a policy id for a policy published at t. Ids only need to
+// change when the policy does; a timestamp keeps them increasing and readable.
+func NewID(t time.Time) string { return t.UTC().Format("20060102150405") }
+
+// TXTName and TXTValue describe the DNS record announcing the policy.
+func TXTName(domain string) string { return "_mta-sts." + domain }
+func TXTValue(id string) string    { return "v=STSv1; id=" + id }
+
+// LookupMX returns the MX hosts of domain, sorted, without trailing dots.
+func LookupMX(ctx context.Context, r *resolver.Resolver, domain string) ([]string, error) {
+	resp, err := r.Query(ctx, domain, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, rr := range resp.Answer {
+		if mx, ok := rr.(*dns.MX); ok && mx.Mx != "." {
+			out = append(out, strings.ToLower(strings.TrimSuffix(mx.Mx, ".")))
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s has no MX records", domain)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PublishedID returns the policy id currently published for domain, or ""
+// when there is no valid record.
+func PublishedID(ctx context.Context, r *resolver.Resolver, domain string) (string, error) {
+	txts, err := r.LookupTXT(ctx, TXTName(domain))
+	if err != nil {
+		return "", err
+	}
+	for _, t := range txts {
+		if !strings.HasPrefix(t, "v=STSv1") {
+			continue
+		}
+		for _, field := range strings.Split(t, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(field), "="); ok && k == "id" {
+				return strings.TrimSpace(v), nil
+			}
+		}
+	}
+	return "", nil
+}
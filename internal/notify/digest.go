@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Digest summarizes what happened since the previous digest.
+type Digest struct {
+	Since    time.Time // zero before the first digest
+	Until    time.Time
+	Renewed  []Event
+	Failed   []Event   // failures since the previous digest
+	Failing  []Failure // certificates whose renewals are still failing, oldest first
+	Expiring []store.Lineage
+}
+
+// BuildDigest collects the digest of the store in baseDir as of now.
+func BuildDigest(baseDir string, now time.Time) (*Digest, error) {
+	cfg, err := Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return buildDigest(baseDir, cfg, now)
+}
+
+func buildDigest(baseDir string, cfg Config, now time.Time) (*Digest, error) {
+	st, err := loadState(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	events, err := pendingEvents(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	d := &Digest{Since: st.LastDigest, Until: now}
+	for _, e := range events {
+		if e.Kind == Failed {
+			d.Failed = append(d.Failed, e)
+		} else {
+			d.Renewed = append(d.Renewed, e)
+		}
+	}
+	for _, f := range st.Failures {
+		d.Failing = append(d.Failing, *f)
+	}
+	sort.Slice(d.Failing, func(i, j int) bool { return d.Failing[i].First.Before(d.Failing[j].First) })
+	lineages, err := store.ListLineages(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lineages {
+		if l.NotAfter.Before(now.Add(cfg.expiringWithin())) {
+			d.Expiring = append(d.Expiring, l)
+		}
+	}
+	sort.SliceStable(d.Expiring, func(i, j int) bool { return d.Expiring[i].NotAfter.Before(d.Expiring[j].NotAfter) })
+	return d, nil
+}
+
+// Subject is the one-line summary of d.
+func (d *Digest) Subject() string {
+	parts := []string{fmt.Sprintf("%d renewed", len(d.Renewed))}
+	if len(d.Failing) > 0 {
+		parts = append(parts, fmt.Sprintf("%d failing", len(d.Failing)))
+	}
+	if len(d.Expiring) > 0 {
+		parts = append(parts, fmt.Sprintf("%d expiring soon", len(d.Expiring)))
+	}
+	return "trusttls digest: " + strings.Join(parts, ", ")
+}
+
+// String renders d as the plain text message sent to channels.
+func (d *Digest) String() string {
+	var b strings.Builder
+	if d.Since.IsZero() {
+		fmt.Fprintf(&b, "Certificate report up to %s\n", d.Until.Format("2006-01-02 15:04 MST"))
+	} else {
+		fmt.Fprintf(&b, "Certificate report from %s to %s\n", d.Since.Local().Format("2006-01-02 15:04"), d.Until.Format("2006-01-02 15:04 MST"))
+	}
+
+	fmt.Fprintf(&b, "\nRenewed (%d):\n", len(d.Renewed))
+	if len(d.Renewed) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, e := range d.Renewed {
+		fmt.Fprintf(&b, "  %s  %s\n", e.Time.Local().Format("2006-01-02 15:04"), e.Domain)
+	}
+
+	if len(d.Failing) > 0 {
+		fmt.Fprintf(&b, "\nStill failing (%d):\n", len(d.Failing))
+		for _, f := range d.Failing {
+			fmt.Fprintf(&b, "  %s: %d attempt(s) since %s, last: %s\n", f.Domain, f.Attempts, f.First.Local().Format("2006-01-02 15:04"), f.Message)
+		}
+	}
+	if recovered := d.recovered(); len(recovered) > 0 {
+		fmt.Fprintf(&b, "\nFailed, then renewed: %s\n", strings.Join(recovered, ", "))
+	}
+
+	fmt.Fprintf(&b, "\nExpiring soon (%d):\n", len(d.Expiring))
+	if len(d.Expiring) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, l := range d.Expiring {
+		days := int(l.NotAfter.Sub(d.Until).Hours() / 24)
+		if days < 0 {
+			fmt.Fprintf(&b, "  %s  expired %s\n", l.Name, l.NotAfter.Local().Format("2006-01-02"))
+		} else {
+			fmt.Fprintf(&b, "  %s  expires %s (%d days)\n", l.Name, l.NotAfter.Local().Format("2006-01-02"), days)
+		}
+	}
+	return b.String()
+}
+
+// recovered returns the names that failed during the period but are no
+// longer failing.
+func (d *Digest) recovered() []string {
+	failing := map[string]bool{}
+	for _, f := range d.Failing {
+		failing[f.Domain] = true
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, e := range d.Failed {
+		if !failing[e.Domain] && !seen[e.Domain] {
+			seen[e.Domain] = true
+			out = append(out, e.Domain)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// DigestDue reports when the next digest of the store in baseDir is due,
+// and whether digests are enabled at all.
+func DigestDue(baseDir string) (time.Time, bool, error) {
+	cfg, err := Load(baseDir)
+	if err != nil || cfg.Period() == 0 {
+		return time.Time{}, false, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	st, err := loadState(baseDir)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return st.LastDigest.Add(cfg.Period()), true, nil
+}
+
+// SendDigest sends the digest of the store in baseDir over every channel
+// when a digest period has passed since the last one, or right away with
+// force, and starts the next period. It reports whether it sent one.
+func SendDigest(baseDir string, now time.Time, force bool) (bool, error) {
+	cfg, err := Load(baseDir)
+	if err != nil {
+		return false, err
+	}
+	if len(cfg.Channels) == 0 || (cfg.Period() == 0 && !force) {
+		return false, nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	st, err := loadState(baseDir)
+	if err != nil {
+		return false, err
+	}
+	if !force && now.Before(st.LastDigest.Add(cfg.Period())) {
+		return false, nil
+	}
+	d, err := buildDigest(baseDir, cfg, now)
+	if err != nil {
+		return false, err
+	}
+	if err := broadcast(cfg, d.Subject(), d.String()); err != nil {
+		// Keep the events for the next attempt
+		return false, err
+	}
+	if err := os.Remove(eventsPath(baseDir)); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	st.LastDigest = now.UTC()
+	return true, saveState(baseDir, st)
+}
@@ -0,0 +1,416 @@
+// Package notify tells people about renewals: each renewal and failure as
+// it happens, or a daily or weekly digest of renewals, certificates close to
+// expiry and failures that keep coming back. Channels are webhooks, shell
+// commands and email through the local sendmail.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"gopkg.in/yaml.v3"
+)
+
+// Digest periods.
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// DefaultExpiringDays is how close to expiry a certificate has to be to be
+// listed in a digest when the config does not say.
+const DefaultExpiringDays = 14
+
+// Channel is one place notifications go. Exactly one of Webhook, Command
+// and Email is set.
+type Channel struct {
+	Name    string `yaml:"name"`
+	Webhook string `yaml:"webhook,omitempty"` // https:// URL receiving {"text": ...}, as Slack, Mattermost and Teams accept
+	Command string `yaml:"command,omitempty"` // shell command reading the message on stdin, subject in TRUSTTLS_SUBJECT
+	Email   string `yaml:"email,omitempty"`   // address mailed through sendmail
+}
+
+// Config lists the channels of this host and how often to send.
+type Config struct {
+	Channels []Channel `yaml:"channels"`
+	// Digest is daily or weekly to collect events into one summary; empty
+	// sends each event on its own.
+	Digest       string `yaml:"digest,omitempty"`
+	ExpiringDays int    `yaml:"expiring_days,omitempty"` // digests list certificates expiring within this many days (default 14)
+}
+
+// Period returns how long one digest covers, or 0 when events are sent on
+// their own.
+func (c Config) Period() time.Duration {
+	switch c.Digest {
+	case Daily:
+		return 24 * time.Hour
+	case Weekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+func (c Config) expiringWithin() time.Duration {
+	days := c.ExpiringDays
+	if days <= 0 {
+		days = DefaultExpiringDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ConfigPath returns where the notification settings of baseDir are kept.
+func ConfigPath(baseDir string) string {
+	return confdir.Path(baseDir, "notify.yaml")
+}
+
+func stateDir(baseDir string) string {
+	return filepath.Join(baseDir, "notify")
+}
+
+// Load reads the notification settings. A missing file means none.
+func Load(baseDir string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	return c, nil
+}
+
+// Save writes the notification settings with 0600 permissions; webhook URLs
+// often carry their secret.
+func Save(baseDir string, c Config) error {
+	b, err := yaml.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(confdir.Of(baseDir), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(ConfigPath(baseDir), b, 0600)
+}
+
+// Validate checks the settings and every channel.
+func (c Config) Validate() error {
+	if c.Digest != "" && c.Period() == 0 {
+		return fmt.Errorf("digest must be daily or weekly, not %q", c.Digest)
+	}
+	if c.ExpiringDays < 0 {
+		return errors.New("expiring_days must not be negative")
+	}
+	var errs []error
+	for _, ch := range c.Channels {
+		if err := ch.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that ch can be used.
+func (ch Channel) Validate() error {
+	if ch.Name == "" {
+		return errors.New("channel name is required")
+	}
+	set := 0
+	for _, v := range []string{ch.Webhook, ch.Command, ch.Email} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("channel %s: set exactly one of webhook, command and email", ch.Name)
+	}
+	switch {
+	case ch.Webhook != "":
+		u, err := url.Parse(ch.Webhook)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("channel %s: webhook must be an http(s):// URL", ch.Name)
+		}
+	case ch.Email != "":
+		if _, err := mail.ParseAddress(ch.Email); err != nil {
+			return fmt.Errorf("channel %s: invalid email address %q", ch.Name, ch.Email)
+		}
+	}
+	return nil
+}
+
+// Kind returns what sort of channel ch is, for listings.
+func (ch Channel) Kind() string {
+	switch {
+	case ch.Webhook != "":
+		return "webhook"
+	case ch.Email != "":
+		return "email"
+	}
+	return "command"
+}
+
+// Target describes where ch delivers to, for listings. Webhook URLs are cut
+// to their host: the path is usually the secret.
+func (ch Channel) Target() string {
+	switch {
+	case ch.Webhook != "":
+		u, err := url.Parse(ch.Webhook)
+		if err != nil {
+			return "(invalid URL)"
+		}
+		return u.Scheme + "://" + u.Host + "/…"
+	case ch.Email != "":
+		return ch.Email
+	}
+	return ch.Command
+}
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// Send delivers one message over ch.
+func (ch Channel) Send(subject, body string) error {
+	switch {
+	case ch.Webhook != "":
+		payload, err := json.Marshal(map[string]string{
+			"text":    subject + "\n\n" + body,
+			"subject": subject,
+			"body":    body,
+		})
+		if err != nil {
+			return err
+		}
+		resp, err := webhookClient.Post(ch.Webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("channel %s: webhook answered %s", ch.Name, resp.Status)
+		}
+		return nil
+	case ch.Email != "":
+		msg := fmt.Sprintf("To: %s\nSubject: %s\nContent-Type: text/plain; charset=utf-8\n\n%s", ch.Email, subject, body)
+		return ch.run(exec.Command("sendmail", "-t"), msg)
+	default:
+		cmd := exec.Command("/bin/sh", "-c", ch.Command)
+		cmd.Env = append(os.Environ(), "TRUSTTLS_SUBJECT="+subject)
+		return ch.run(cmd, body)
+	}
+}
+
+func (ch Channel) run(cmd *exec.Cmd, input string) error {
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("channel %s: %w: %s", ch.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// broadcast sends one message over every channel of c, trying them all.
+func broadcast(c Config, subject, body string) error {
+	var errs []error
+	for _, ch := range c.Channels {
+		if err := ch.Send(subject, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Alert sends subject and body over every channel right away, in digest
+// mode too: it is for problems someone has to act on now.
+func Alert(baseDir, subject, body string) error {
+	cfg, err := Load(baseDir)
+	if err != nil {
+		return err
+	}
+	return broadcast(cfg, subject, body)
+}
+
+// Event kinds.
+const (
+	Renewed = "renewed"
+	Failed  = "failed"
+)
+
+// Event is one renewal outcome.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"` // renewed|failed
+	Domain  string    `json:"domain"`
+	Message string    `json:"message,omitempty"` // the error of a failure
+}
+
+// Failure is a certificate whose renewals have been failing since First.
+type Failure struct {
+	Domain   string    `json:"domain"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Attempts int       `json:"attempts"`
+	Message  string    `json:"message"` // the latest error
+}
+
+// state is what notify remembers between runs.
+type state struct {
+	LastDigest time.Time           `json:"last_digest,omitempty"`
+	Failures   map[string]*Failure `json:"failures,omitempty"`
+}
+
+// mu serializes Record and SendDigest, which renewal workers call side by
+// side.
+var mu sync.Mutex
+
+func loadState(baseDir string) (*state, error) {
+	st := &state{}
+	b, err := os.ReadFile(filepath.Join(stateDir(baseDir), "state.json"))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("notify state: %w", err)
+	}
+	return st, nil
+}
+
+func saveState(baseDir string, st *state) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir(baseDir), 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir(baseDir), "state.json")
+	if err := os.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Record notes a renewal outcome. Without channels it only keeps track of
+// failing certificates; in digest mode the event waits for the next digest,
+// otherwise it is sent right away.
+func Record(baseDir string, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	cfg, err := Load(baseDir)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	st, err := loadState(baseDir)
+	if err != nil {
+		return err
+	}
+	var streak *Failure
+	if e.Kind == Failed {
+		streak = st.Failures[e.Domain]
+		if streak == nil {
+			streak = &Failure{Domain: e.Domain, First: e.Time}
+		}
+		streak.Last, streak.Message = e.Time, e.Message
+		streak.Attempts++
+		if st.Failures == nil {
+			st.Failures = map[string]*Failure{}
+		}
+		st.Failures[e.Domain] = streak
+	} else {
+		delete(st.Failures, e.Domain)
+	}
+	if err := saveState(baseDir, st); err != nil {
+		return err
+	}
+	if len(cfg.Channels) == 0 {
+		return nil
+	}
+	if cfg.Period() > 0 {
+		return appendEvent(baseDir, e)
+	}
+	return broadcast(cfg, eventSubject(e), eventBody(e, streak))
+}
+
+func eventSubject(e Event) string {
+	if e.Kind == Failed {
+		return fmt.Sprintf("trusttls: renewal of %s failed", e.Domain)
+	}
+	return fmt.Sprintf("trusttls: renewed %s", e.Domain)
+}
+
+func eventBody(e Event, streak *Failure) string {
+	if e.Kind != Failed {
+		return fmt.Sprintf("The certificate for %s was renewed at %s.\n", e.Domain, e.Time.Format(time.RFC3339))
+	}
+	body := fmt.Sprintf("Renewing %s failed at %s:\n\n%s\n", e.Domain, e.Time.Format(time.RFC3339), e.Message)
+	if streak != nil && streak.Attempts > 1 {
+		body += fmt.Sprintf("\nThis is failure %d in a row, the first at %s.\n", streak.Attempts, streak.First.Format(time.RFC3339))
+	}
+	return body
+}
+
+func eventsPath(baseDir string) string {
+	return filepath.Join(stateDir(baseDir), "events.jsonl")
+}
+
+func appendEvent(baseDir string, e Event) error {
+	if err := os.MkdirAll(stateDir(baseDir), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(eventsPath(baseDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pendingEvents returns the events waiting for the next digest.
+func pendingEvents(baseDir string) ([]Event, error) {
+	b, err := os.ReadFile(eventsPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Event
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue // a line cut short by a crash
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
@@ -1,9 +1,12 @@
 package osutil
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 )
 
 func IsMac() bool    { return runtime.GOOS == "darwin" }
@@ -24,6 +27,32 @@ func Run(name string, args ...string) error {
 	return cmd.Run()
 }
 
+// RunWithInput runs a command with input fed to its stdin. Combined output is
+// included in the returned error to help diagnose failures.
+func RunWithInput(input []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// OutputWithInput runs a command with input fed to its stdin and returns
+// what it printed on stdout. Stderr is included in the returned error.
+func OutputWithInput(input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
 // CommandExists reports whether a command is available on PATH.
 func CommandExists(name string) bool {
     _, err := exec.LookPath(name)
@@ -0,0 +1,76 @@
+package osutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// SudoEnv turns on privilege separation: when set and TrustTLS is not running
+// as root, the few steps that need root (writing web server configs and
+// reloading the server) are run through "sudo -n" with exact arguments, so a
+// sudoers entry from `trusttls generate-sudoers` can allow just those.
+const SudoEnv = "TRUSTTLS_SUDO"
+
+// Unprivileged reports whether privileged steps go through sudo.
+func Unprivileged() bool {
+	return os.Geteuid() != 0 && os.Getenv(SudoEnv) != ""
+}
+
+// PrivilegedCommand returns the argv used for a privileged step, with the
+// program resolved to an absolute path as sudoers requires. It does not
+// include the sudo prefix.
+func PrivilegedCommand(name string, args ...string) []string {
+	if !filepath.IsAbs(name) {
+		if p, err := exec.LookPath(name); err == nil {
+			name = p
+		}
+	}
+	return append([]string{name}, args...)
+}
+
+// RunPrivileged runs a command that needs root, through sudo when
+// Unprivileged.
+func RunPrivileged(name string, args ...string) error {
+	if err := readonly.Check("run " + name); err != nil {
+		return err
+	}
+	argv := PrivilegedCommand(name, args...)
+	if Unprivileged() {
+		return Run("sudo", append([]string{"-n"}, argv...)...)
+	}
+	return Run(argv[0], argv[1:]...)
+}
+
+// WriteFilePrivileged writes a file in a root-owned location. Through sudo
+// the file is written with tee, keeping its existing mode or the umask
+// default.
+func WriteFilePrivileged(path string, data []byte, perm os.FileMode) error {
+	if err := readonly.Check("write " + path); err != nil {
+		return err
+	}
+	// A web server must not be left with half a config
+	defer interrupt.Hold()()
+	if !Unprivileged() {
+		return os.WriteFile(path, data, perm)
+	}
+	argv := PrivilegedCommand("tee", path)
+	return RunWithInput(data, "sudo", append([]string{"-n"}, argv...)...)
+}
+
+// MkdirAllPrivileged creates a directory in a root-owned location.
+func MkdirAllPrivileged(dir string, perm os.FileMode) error {
+	if err := readonly.Check("create " + dir); err != nil {
+		return err
+	}
+	if !Unprivileged() {
+		return os.MkdirAll(dir, perm)
+	}
+	if DirExists(dir) {
+		return nil
+	}
+	return RunPrivileged("mkdir", "-p", dir)
+}
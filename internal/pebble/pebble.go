@@ -0,0 +1,262 @@
+// Package pebble runs Pebble, the small ACME server Let's Encrypt publishes
+// for testing clients, as a throwaway CA on the loopback interface. It lets
+// the whole path from order to installed certificate run without a public
+// CA, DNS or an open port 80: challenges are accepted without being checked
+// and every run starts from a fresh root.
+package pebble
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Binary is the name Pebble is looked up under in PATH.
+const Binary = "pebble"
+
+// startTimeout bounds the wait for Pebble to answer on its directory.
+const startTimeout = 15 * time.Second
+
+// Server is a running Pebble.
+type Server struct {
+	// Directory is the ACME directory URL to order from.
+	Directory string
+	// CACert is a PEM file with the certificate Pebble serves HTTPS with,
+	// to trust for Directory (get-cert --acme-ca-cert).
+	CACert string
+	// Log is the file Pebble's output goes to.
+	Log string
+
+	management string
+	client     *http.Client
+	cmd        *exec.Cmd
+	exited     chan error
+}
+
+// Start runs the Pebble at binary (looked up in PATH when empty) with its
+// config, TLS certificate and log in dir, and returns once it answers.
+func Start(binary, dir string) (*Server, error) {
+	if binary == "" {
+		binary = Binary
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found: install it with 'go install github.com/letsencrypt/pebble/v2/cmd/pebble@latest' or name it with --pebble-bin", binary)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		CACert: filepath.Join(dir, "pebble-ca.pem"),
+		Log:    filepath.Join(dir, "pebble.log"),
+		exited: make(chan error, 1),
+	}
+	keyPath := filepath.Join(dir, "pebble-key.pem")
+	if err := writeTLSCert(s.CACert, keyPath); err != nil {
+		return nil, fmt.Errorf("pebble TLS certificate: %w", err)
+	}
+	ports, err := freePorts(2)
+	if err != nil {
+		return nil, err
+	}
+	listen := fmt.Sprintf("127.0.0.1:%d", ports[0])
+	manage := fmt.Sprintf("127.0.0.1:%d", ports[1])
+	s.Directory = "https://" + listen + "/dir"
+	s.management = "https://" + manage
+
+	cfg := map[string]any{
+		"pebble": map[string]any{
+			"listenAddress":                  listen,
+			"managementListenAddress":        manage,
+			"certificate":                    s.CACert,
+			"privateKey":                     keyPath,
+			"httpPort":                       5002,
+			"tlsPort":                        5001,
+			"ocspResponderURL":               "",
+			"externalAccountBindingRequired": false,
+		},
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	cfgPath := filepath.Join(dir, "pebble-config.json")
+	if err := os.WriteFile(cfgPath, b, 0600); err != nil {
+		return nil, err
+	}
+	log, err := os.Create(s.Log)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	s.cmd = exec.Command(path, "-config", cfgPath)
+	s.cmd.Stdout, s.cmd.Stderr = log, log
+	s.cmd.Env = append(os.Environ(),
+		// Nothing outside this machine can reach the names ordered, so
+		// challenges are accepted as soon as they are answered
+		"PEBBLE_VA_ALWAYS_VALID=1",
+		"PEBBLE_VA_NOSLEEP=1",
+		"PEBBLE_WFE_NONCEREJECT=0",
+	)
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", path, err)
+	}
+	go func() { s.exited <- s.cmd.Wait() }()
+
+	pool := x509.NewCertPool()
+	caPEM, _ := os.ReadFile(s.CACert)
+	pool.AppendCertsFromPEM(caPEM)
+	s.client = &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	if err := s.wait(); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// wait polls the directory until Pebble answers, gives up or exits.
+func (s *Server) wait() error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		resp, err := s.client.Get(s.Directory)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case werr := <-s.exited:
+			s.exited <- werr
+			return fmt.Errorf("pebble exited: %v%s", werr, s.logTail())
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("pebble did not answer on %s within %s%s", s.Directory, startTimeout, s.logTail())
+		}
+	}
+}
+
+// Roots returns the PEM root certificate the certificates Pebble issues
+// chain to. Pebble makes a new one every time it starts.
+func (s *Server) Roots() ([]byte, error) {
+	resp, err := s.client.Get(s.management + "/roots/0")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pebble roots: %s", resp.Status)
+	}
+	if !bytes.Contains(b, []byte("-----BEGIN CERTIFICATE-----")) {
+		return nil, errors.New("pebble roots: no PEM certificate in the response")
+	}
+	return b, nil
+}
+
+// Stop ends Pebble and waits for it to exit.
+func (s *Server) Stop() {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return
+	}
+	_ = s.cmd.Process.Kill()
+	select {
+	case <-s.exited:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// logTail returns the end of Pebble's log, to explain why it failed.
+func (s *Server) logTail() string {
+	b, err := os.ReadFile(s.Log)
+	if err != nil || len(bytes.TrimSpace(b)) == 0 {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	return ":\n  " + strings.Join(lines, "\n  ")
+}
+
+// freePorts returns n loopback ports nothing listens on.
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	var lns []net.Listener
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// writeTLSCert writes a self-signed certificate for 127.0.0.1 and
+// localhost, which clients trust directly, and its key.
+func writeTLSCert(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "trusttls pebble"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
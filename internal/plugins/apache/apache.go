@@ -14,6 +14,7 @@ import (
 
 var (
 	serverNameRe   = regexp.MustCompile(`(?i)^\s*ServerName\s+(.+)$`)
+	serverAliasRe  = regexp.MustCompile(`(?i)^\s*ServerAlias\s+(.+)$`)
 	documentRootRe = regexp.MustCompile(`(?i)^\s*DocumentRoot\s+(.+)$`)
 	sslEngineRe    = regexp.MustCompile(`(?i)^\s*SSLEngine\s+(.+)$`)
 	sslCertRe      = regexp.MustCompile(`(?i)^\s*SSLCertificateFile\s+(.+)$`)
@@ -51,9 +52,7 @@ func scanVhostsForSSL(dir, domain string) bool {
 		var sslEnabled bool
 		for s.Scan() {
 			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				if strings.EqualFold(m[1], domain) { seenDomain = true }
-			}
+			if servesDomain(line, domain) { seenDomain = true }
 			if m := sslEngineRe.FindStringSubmatch(line); len(m) == 2 {
 				if strings.EqualFold(strings.TrimSpace(m[1]), "on") { sslEnabled = true }
 			}
@@ -72,6 +71,59 @@ func DetectWebroot(domain string) string {
 	return ""
 }
 
+// WebrootCandidates returns every DocumentRoot in the vhost files for
+// domain, for the user to pick from when DetectWebroot finds none usable.
+func WebrootCandidates(domain string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, dir := range candidateConfDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() { continue }
+			f, err := os.Open(filepath.Join(dir, e.Name()))
+			if err != nil { continue }
+			s := bufio.NewScanner(f)
+			var serves bool
+			var roots []string
+			for s.Scan() {
+				line := strings.TrimSpace(s.Text())
+				if servesDomain(line, domain) { serves = true }
+				if m := documentRootRe.FindStringSubmatch(line); len(m) == 2 {
+					roots = append(roots, strings.Trim(m[1], `"`))
+				}
+			}
+			_ = f.Close()
+			for _, r := range roots {
+				if serves && !seen[r] {
+					seen[r] = true
+					out = append(out, r)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// vhostNames returns the host names a ServerName or ServerAlias line declares.
+func vhostNames(line string) []string {
+	if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
+		return strings.Fields(m[1])[:1]
+	}
+	if m := serverAliasRe.FindStringSubmatch(line); len(m) == 2 {
+		return strings.Fields(m[1])
+	}
+	return nil
+}
+
+// servesDomain reports whether line names a host the certificate for domain
+// (possibly a wildcard) is meant for.
+func servesDomain(line, domain string) bool {
+	for _, n := range vhostNames(line) {
+		if store.ServesName(domain, n) { return true }
+	}
+	return false
+}
+
 func candidateConfDirs() []string {
 	c := []string{
 		"/etc/apache2/sites-enabled",
@@ -97,9 +149,7 @@ func scanVhostsForDomain(dir, domain string) string {
 		var docroot string
 		for s.Scan() {
 			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				if strings.EqualFold(m[1], domain) { seen = true }
-			}
+			if servesDomain(line, domain) { seen = true }
 			if m := documentRootRe.FindStringSubmatch(line); len(m) == 2 {
 				docroot = strings.Trim(m[1], `"`)
 			}
@@ -121,6 +171,8 @@ func NewInstaller(storeDir string, assumeYes bool) *installer {
 
 func (i *installer) Webroot(domain string) string { return DetectWebroot(domain) }
 
+func (i *installer) WebrootCandidates(domain string) []string { return WebrootCandidates(domain) }
+
 func (i *installer) IsSSLEnabled(domain string) bool { return DetectSSLMode(domain) }
 
 func (i *installer) DetectVhost(domain string) (string, string) {
@@ -142,11 +194,9 @@ func findVhostForDomain(dir, domain string) string {
 		s := bufio.NewScanner(f)
 		for s.Scan() {
 			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				if strings.EqualFold(m[1], domain) { 
-					_ = f.Close()
-					return path 
-				}
+			if servesDomain(line, domain) {
+				_ = f.Close()
+				return path
 			}
 		}
 		_ = f.Close()
@@ -154,30 +204,105 @@ func findVhostForDomain(dir, domain string) string {
 	return ""
 }
 
-func (i *installer) Install(domain string) error {
+// Install writes the SSL site for domain using the certificate kept under
+// certName in the store.
+func (i *installer) Install(certName, domain string, aliases ...string) error {
 	if !i.assumeYes {
 		return fmt.Errorf("confirmation required: re-run with --yes to write Apache SSL vhost for %s", domain)
 	}
-	cert, key, _, full := store.LoadCertPaths(i.storeDir, domain)
-	conf := sslVhostConf(domain, cert, key, full)
-	outDir := apacheVhostOutDir()
-	if err := os.MkdirAll(outDir, 0755); err != nil { return err }
-	out := filepath.Join(outDir, domain+"-le-ssl.conf")
-	if err := os.WriteFile(out, []byte(conf), 0644); err != nil { return err }
-	// Enable site if Debian-style
-	if strings.Contains(outDir, "sites-available") {
-		link := filepath.Join(filepath.Dir(outDir), "sites-enabled", filepath.Base(out))
-		_ = os.MkdirAll(filepath.Dir(link), 0755)
-		_ = os.Symlink(out, link)
-	}
-	// Try to reload gracefully
-	_ = osutil.Run("apache2ctl", "graceful")
-	_ = osutil.Run("apachectl", "graceful")
-	_ = osutil.Run("service", "apache2", "reload")
-	_ = osutil.Run("service", "httpd", "reload")
+	cert, key, _, full := store.LoadCertPaths(i.storeDir, certName)
+	serverName := domain
+	if strings.HasPrefix(domain, "*.") {
+		// Apache only accepts wildcards in ServerAlias
+		serverName = i.wildcardServerName(domain)
+		aliases = append([]string{domain}, aliases...)
+	}
+	conf := sslVhostConf(serverName, aliases, DetectWebroot(domain), cert, key, full)
+	if err := writeVhost(ConfigFile(domain), conf); err != nil { return err }
+	Reload()
+	return nil
+}
+
+// CreateSite writes a port 80 vhost serving webroot for a domain that has
+// none yet, creating webroot, so the certificate can be validated and the
+// SSL vhost written by Install has a document root.
+func (i *installer) CreateSite(domain, webroot string, aliases ...string) error {
+	if !i.assumeYes {
+		return fmt.Errorf("confirmation required: re-run with --yes to create an Apache site for %s", domain)
+	}
+	if err := osutil.MkdirAllPrivileged(webroot, 0755); err != nil { return err }
+	if err := writeVhost(SiteFile(domain), httpVhostConf(domain, aliases, webroot)); err != nil { return err }
+	Reload()
+	return nil
+}
+
+func (i *installer) SiteFile(domain string) string { return SiteFile(domain) }
+
+func (i *installer) ConfigFile(domain string) string { return ConfigFile(domain) }
+
+// writeVhost writes a vhost file and enables it on Debian-style layouts.
+func writeVhost(out, conf string) error {
+	if err := osutil.MkdirAllPrivileged(filepath.Dir(out), 0755); err != nil { return err }
+	if err := osutil.WriteFilePrivileged(out, []byte(conf), 0644); err != nil { return err }
+	if link := enabledLink(out); link != "" {
+		if osutil.Unprivileged() {
+			_ = osutil.RunPrivileged("ln", "-sf", out, link)
+		} else {
+			_ = os.MkdirAll(filepath.Dir(link), 0755)
+			_ = os.Symlink(out, link)
+		}
+	}
 	return nil
 }
 
+// ConfigFile returns the SSL vhost file Install writes for domain.
+func ConfigFile(domain string) string {
+	return filepath.Join(apacheVhostOutDir(), store.LineageName(domain)+"-le-ssl.conf")
+}
+
+// SiteFile returns the port 80 vhost file CreateSite writes for domain.
+func SiteFile(domain string) string {
+	return filepath.Join(apacheVhostOutDir(), store.LineageName(domain)+".conf")
+}
+
+// EnabledLink returns the sites-enabled link that enables ConfigFile on
+// Debian-style layouts, or "" where every file in the directory is loaded.
+func EnabledLink(domain string) string {
+	return enabledLink(ConfigFile(domain))
+}
+
+func enabledLink(out string) string {
+	if !strings.Contains(out, "sites-available") { return "" }
+	return filepath.Join(filepath.Dir(filepath.Dir(out)), "sites-enabled", filepath.Base(out))
+}
+
+// ConfigDirs returns the directories searched for virtual hosts.
+func ConfigDirs() []string { return candidateConfDirs() }
+
+// Reload asks Apache to gracefully pick up configuration changes.
+func Reload() {
+	for _, c := range ReloadCommands() {
+		_ = osutil.RunPrivileged(c[0], c[1:]...)
+	}
+}
+
+// ReloadCommands returns the commands Reload runs, with programs resolved to
+// absolute paths. Commands missing on this host are left out.
+func ReloadCommands() [][]string {
+	var out [][]string
+	for _, c := range [][]string{
+		{"apache2ctl", "graceful"},
+		{"apachectl", "graceful"},
+		{"service", "apache2", "reload"},
+		{"service", "httpd", "reload"},
+	} {
+		if osutil.CommandExists(c[0]) {
+			out = append(out, osutil.PrivilegedCommand(c[0], c[1:]...))
+		}
+	}
+	return out
+}
+
 func apacheVhostOutDir() string {
 	c := []string{
 		"/etc/apache2/sites-available",
@@ -190,17 +315,65 @@ func apacheVhostOutDir() string {
 	return "/etc/apache2/sites-available"
 }
 
-func sslVhostConf(domain, cert, key, fullchain string) string {
+func sslVhostConf(domain string, aliases []string, docroot, cert, key, fullchain string) string {
+	names := "ServerName " + domain
+	if len(aliases) > 0 {
+		names += "\n    ServerAlias " + strings.Join(aliases, " ")
+	}
+	root := "# DocumentRoot picked from port 80 vhost"
+	if docroot != "" {
+		root = "DocumentRoot " + docroot
+	}
 	return fmt.Sprintf(`<IfModule mod_ssl.c>
 <VirtualHost *:443>
-    ServerName %s
+    %s
+    %s
     SSLEngine on
     SSLCertificateFile %s
     SSLCertificateKeyFile %s
     SSLCertificateChainFile %s
     # Optional: redirect from HTTP handled elsewhere
-    # DocumentRoot picked from port 80 vhost
 </VirtualHost>
 </IfModule>
-`, domain, cert, key, fullchain)
+`, names, root, cert, key, fullchain)
+}
+
+// httpVhostConf is the port 80 vhost CreateSite writes for a new site.
+func httpVhostConf(domain string, aliases []string, webroot string) string {
+	names := "ServerName " + domain
+	if len(aliases) > 0 {
+		names += "\n    ServerAlias " + strings.Join(aliases, " ")
+	}
+	return fmt.Sprintf(`<VirtualHost *:80>
+    %s
+    DocumentRoot %s
+    <Directory %s>
+        Require all granted
+    </Directory>
+</VirtualHost>
+`, names, webroot, webroot)
+}
+
+// wildcardServerName picks a concrete ServerName for a wildcard vhost, since
+// Apache only accepts wildcards in ServerAlias: the first ServerName covered
+// by the wildcard in an existing vhost, else www under the wildcard.
+func (i *installer) wildcardServerName(domain string) string {
+	for _, dir := range candidateConfDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() { continue }
+			f, err := os.Open(filepath.Join(dir, e.Name()))
+			if err != nil { continue }
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				m := serverNameRe.FindStringSubmatch(strings.TrimSpace(s.Text()))
+				if len(m) == 2 && !strings.Contains(m[1], "*") && store.ServesName(domain, strings.Fields(m[1])[0]) {
+					_ = f.Close()
+					return strings.Fields(m[1])[0]
+				}
+			}
+			_ = f.Close()
+		}
+	}
+	return "www." + strings.TrimPrefix(domain, "*.")
 }
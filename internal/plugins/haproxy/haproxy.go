@@ -0,0 +1,107 @@
+// Package haproxy swaps certificates in a running HAProxy through its
+// runtime API, so renewals take effect without a reload and without
+// dropping connections.
+package haproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// timeout bounds each runtime API command.
+const timeout = 10 * time.Second
+
+// Bundle returns the PEM HAProxy loads for a crt line: the full chain
+// followed by the private key.
+func Bundle(fullchain, key []byte) []byte {
+	var b bytes.Buffer
+	b.Write(bytes.TrimSpace(fullchain))
+	b.WriteString("\n")
+	b.Write(bytes.TrimSpace(key))
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// WriteBundle replaces certFile with pem atomically, so a later restart
+// loads the same certificate the runtime API was given.
+func WriteBundle(certFile string, pem []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(certFile), ".trusttls-haproxy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(pem); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), certFile)
+}
+
+// Update loads pem into the running HAProxy for certFile, the path exactly
+// as it appears on a crt line (or in a crt-list), and commits it. socket is
+// the path of a "stats socket ... level admin" or its host:port.
+func Update(socket, certFile string, pem []byte) error {
+	// The payload ends at the first empty line.
+	out, err := command(socket, fmt.Sprintf("set ssl cert %s <<\n%s\n\n", certFile, bytes.TrimSpace(pem)))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Transaction created") && !strings.Contains(out, "Transaction updated") {
+		return fmt.Errorf("haproxy: set ssl cert %s: %s", certFile, strings.TrimSpace(out))
+	}
+	out, err = command(socket, "commit ssl cert "+certFile+"\n")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Success!") {
+		command(socket, "abort ssl cert "+certFile+"\n")
+		return fmt.Errorf("haproxy: commit ssl cert %s: %s", certFile, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// Ping checks that socket accepts runtime API commands.
+func Ping(socket string) error {
+	_, err := command(socket, "show info\n")
+	return err
+}
+
+// command sends one command on a new connection and returns everything
+// HAProxy answers before it closes the connection.
+func command(socket, cmd string) (string, error) {
+	network, addr := "unix", strings.TrimPrefix(socket, "unix:")
+	if !strings.HasPrefix(addr, "/") {
+		network, addr = "tcp", strings.TrimPrefix(socket, "tcp:")
+	}
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return "", fmt.Errorf("haproxy runtime API %s: %w", socket, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		return "", fmt.Errorf("haproxy runtime API %s: %w", socket, err)
+	}
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("haproxy runtime API %s: %w", socket, err)
+	}
+	return string(out), nil
+}
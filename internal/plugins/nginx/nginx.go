@@ -3,6 +3,7 @@ package nginx
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -15,7 +16,8 @@ import (
 var (
 	serverNameRe = regexp.MustCompile(`(?i)^\s*server_name\s+([^;]+);`)
 	rootRe       = regexp.MustCompile(`(?i)^\s*root\s+([^;]+);`)
-	sslListenRe  = regexp.MustCompile(`(?i)^\s*listen\s+(\d+)\s+ssl;`)
+	sslListenRe  = regexp.MustCompile(`(?i)^\s*listen\s+[^;]*\bssl\b`)
+	listenRe     = regexp.MustCompile(`(?i)^\s*listen\s+([^;]+);`)
 	sslCertRe    = regexp.MustCompile(`(?i)^\s*ssl_certificate\s+([^;]+);`)
 )
 
@@ -46,11 +48,7 @@ func scanServersForSSL(dir, domain string) bool {
 		var sslEnabled bool
 		for s.Scan() {
 			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				for _, n := range strings.Fields(m[1]) {
-					if strings.EqualFold(n, domain) { seenDomain = true }
-				}
-			}
+			if servesDomain(line, domain) { seenDomain = true }
 			if sslListenRe.MatchString(line) || sslCertRe.MatchString(line) {
 				sslEnabled = true
 			}
@@ -62,13 +60,56 @@ func scanServersForSSL(dir, domain string) bool {
 }
 
 func DetectWebroot(domain string) string {
+	maps := globalMaps()
 	for _, dir := range candidateConfDirs() {
-		root := scanServersForDomain(dir, domain)
+		root, _ := scanServersForDomain(dir, domain, maps)
 		if root != "" { return root }
 	}
 	return ""
 }
 
+// WebrootCandidates returns the roots the server blocks for domain might
+// use when DetectWebroot cannot settle on one, existing directories first.
+func WebrootCandidates(domain string) []string {
+	maps := globalMaps()
+	var out []string
+	for _, dir := range candidateConfDirs() {
+		root, candidates := scanServersForDomain(dir, domain, maps)
+		if root != "" { out = append(out, root) }
+		out = append(out, candidates...)
+	}
+	return existingFirst(out)
+}
+
+// servesDomain reports whether line ties its server block to domain: a
+// server_name the certificate covers or, for an IP address, a listen on
+// that address.
+func servesDomain(line, domain string) bool {
+	if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
+		for _, n := range strings.Fields(m[1]) {
+			if store.ServesName(domain, n) { return true }
+		}
+	}
+	if ip := net.ParseIP(domain); ip != nil {
+		return ip.Equal(net.ParseIP(listenAddress(line)))
+	}
+	return false
+}
+
+// listenAddress returns the address a listen directive binds to, or "" when
+// it only names a port.
+func listenAddress(line string) string {
+	m := listenRe.FindStringSubmatch(line)
+	if len(m) != 2 { return "" }
+	addr := strings.Fields(m[1])[0]
+	if strings.HasPrefix(addr, "[") {
+		if i := strings.Index(addr, "]"); i > 0 { return addr[1:i] }
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil { return host }
+	return addr
+}
+
 func candidateConfDirs() []string {
 	return []string{
 		"/etc/nginx/sites-enabled",
@@ -78,31 +119,33 @@ func candidateConfDirs() []string {
 	}
 }
 
-func scanServersForDomain(dir, domain string) string {
+// scanServersForDomain returns the root of the first file in dir with a
+// server block for domain, following includes and resolving variables. When
+// the root depends on a variable it cannot resolve, it returns "" and the
+// possible roots instead.
+func scanServersForDomain(dir, domain string, maps map[string]nginxMap) (string, []string) {
 	entries, _ := os.ReadDir(dir)
+	var candidates []string
 	for _, e := range entries {
 		if e.IsDir() { continue }
-		path := filepath.Join(dir, e.Name())
-		f, err := os.Open(path)
-		if err != nil { continue }
-		s := bufio.NewScanner(f)
 		var seen bool
 		var webroot string
-		for s.Scan() {
-			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				for _, n := range strings.Fields(m[1]) {
-					if strings.EqualFold(n, domain) { seen = true }
-				}
+		vars := map[string]string{}
+		for _, line := range configLines(filepath.Join(dir, e.Name())) {
+			if servesDomain(line, domain) { seen = true }
+			if m := setRe.FindStringSubmatch(line); len(m) == 3 {
+				vars[m[1]] = unquote(m[2])
 			}
 			if m := rootRe.FindStringSubmatch(line); len(m) == 2 {
-				webroot = strings.Trim(m[1], `"`)
+				webroot = unquote(m[1])
 			}
 		}
-		_ = f.Close()
-		if seen && webroot != "" { return webroot }
+		if !seen || webroot == "" { continue }
+		root, options := resolveRoot(webroot, domain, vars, maps)
+		if root != "" { return root, nil }
+		candidates = append(candidates, options...)
 	}
-	return ""
+	return "", candidates
 }
 
 type installer struct {
@@ -116,6 +159,8 @@ func NewInstaller(storeDir string, assumeYes bool) *installer {
 
 func (i *installer) Webroot(domain string) string { return DetectWebroot(domain) }
 
+func (i *installer) WebrootCandidates(domain string) []string { return WebrootCandidates(domain) }
+
 func (i *installer) IsSSLEnabled(domain string) bool { return DetectSSLMode(domain) }
 
 func (i *installer) DetectVhost(domain string) (string, string) {
@@ -137,13 +182,9 @@ func findServerForDomain(dir, domain string) string {
 		s := bufio.NewScanner(f)
 		for s.Scan() {
 			line := strings.TrimSpace(s.Text())
-			if m := serverNameRe.FindStringSubmatch(line); len(m) == 2 {
-				for _, n := range strings.Fields(m[1]) {
-					if strings.EqualFold(n, domain) { 
-						_ = f.Close()
-						return path 
-					}
-				}
+			if servesDomain(line, domain) {
+				_ = f.Close()
+				return path
 			}
 		}
 		_ = f.Close()
@@ -151,21 +192,91 @@ func findServerForDomain(dir, domain string) string {
 	return ""
 }
 
-func (i *installer) Install(domain string) error {
+// Install writes the SSL site for domain using the certificate kept under
+// certName in the store.
+func (i *installer) Install(certName, domain string, aliases ...string) error {
 	if !i.assumeYes {
 		return fmt.Errorf("confirmation required: re-run with --yes to write Nginx SSL server for %s", domain)
 	}
-	cert, key, _, full := store.LoadCertPaths(i.storeDir, domain)
-	conf := sslServerConf(domain, cert, key, full)
-	outDir := nginxServerOutDir()
-	if err := os.MkdirAll(outDir, 0755); err != nil { return err }
-	out := filepath.Join(outDir, domain+"-le-ssl.conf")
-	if err := os.WriteFile(out, []byte(conf), 0644); err != nil { return err }
-	_ = osutil.Run("nginx", "-s", "reload")
-	_ = osutil.Run("service", "nginx", "reload")
+	cert, key, _, full := store.LoadCertPaths(i.storeDir, certName)
+	conf := sslServerConf(serverNames(domain, aliases), listenOn(domain, "443")+" ssl", DetectWebroot(domain), cert, key, full)
+	if err := writeServer(ConfigFile(domain), conf); err != nil { return err }
+	Reload()
+	return nil
+}
+
+// CreateSite writes a port 80 server block serving webroot for a domain that
+// has none yet, creating webroot, so the certificate can be validated and the
+// SSL server written by Install has a root.
+func (i *installer) CreateSite(domain, webroot string, aliases ...string) error {
+	if !i.assumeYes {
+		return fmt.Errorf("confirmation required: re-run with --yes to create an Nginx site for %s", domain)
+	}
+	if err := osutil.MkdirAllPrivileged(webroot, 0755); err != nil { return err }
+	conf := httpServerConf(serverNames(domain, aliases), listenOn(domain, "80"), webroot)
+	if err := writeServer(SiteFile(domain), conf); err != nil { return err }
+	Reload()
 	return nil
 }
 
+func (i *installer) SiteFile(domain string) string { return SiteFile(domain) }
+
+func (i *installer) ConfigFile(domain string) string { return ConfigFile(domain) }
+
+func serverNames(domain string, aliases []string) string {
+	return strings.Join(append([]string{domain}, aliases...), " ")
+}
+
+// listenOn returns the listen address for port: the address itself for an
+// IP address certificate, so the block answers on that IP.
+func listenOn(domain, port string) string {
+	if ip := net.ParseIP(domain); ip != nil {
+		return net.JoinHostPort(ip.String(), port)
+	}
+	return port
+}
+
+func writeServer(out, conf string) error {
+	if err := osutil.MkdirAllPrivileged(filepath.Dir(out), 0755); err != nil { return err }
+	return osutil.WriteFilePrivileged(out, []byte(conf), 0644)
+}
+
+// ConfigFile returns the server block file Install writes for domain.
+func ConfigFile(domain string) string {
+	return filepath.Join(nginxServerOutDir(), store.LineageName(domain)+"-le-ssl.conf")
+}
+
+// SiteFile returns the port 80 server block file CreateSite writes for
+// domain.
+func SiteFile(domain string) string {
+	return filepath.Join(nginxServerOutDir(), store.LineageName(domain)+".conf")
+}
+
+// ConfigDirs returns the directories searched for server blocks.
+func ConfigDirs() []string { return candidateConfDirs() }
+
+// Reload asks Nginx to pick up configuration changes.
+func Reload() {
+	for _, c := range ReloadCommands() {
+		_ = osutil.RunPrivileged(c[0], c[1:]...)
+	}
+}
+
+// ReloadCommands returns the commands Reload runs, with programs resolved to
+// absolute paths. Commands missing on this host are left out.
+func ReloadCommands() [][]string {
+	var out [][]string
+	for _, c := range [][]string{
+		{"nginx", "-s", "reload"},
+		{"service", "nginx", "reload"},
+	} {
+		if osutil.CommandExists(c[0]) {
+			out = append(out, osutil.PrivilegedCommand(c[0], c[1:]...))
+		}
+	}
+	return out
+}
+
 func nginxServerOutDir() string {
 	c := []string{
 		"/etc/nginx/conf.d",
@@ -176,13 +287,30 @@ func nginxServerOutDir() string {
 	return "/etc/nginx/conf.d"
 }
 
-func sslServerConf(domain, cert, key, fullchain string) string {
+// sslServerConf writes a server block for names, a space-separated
+// server_name list, listening on listen. root is left out when empty.
+func sslServerConf(names, listen, root, cert, key, fullchain string) string {
+	rootLine := ""
+	if root != "" {
+		rootLine = fmt.Sprintf("    root %s;\n", root)
+	}
 	return fmt.Sprintf(`server {
-    listen 443 ssl;
+    listen %s;
     server_name %s;
-    ssl_certificate %s;
+%s    ssl_certificate %s;
     ssl_certificate_key %s;
     ssl_trusted_certificate %s;
 }
-`, domain, fullchain, key, fullchain)
+`, listen, names, rootLine, fullchain, key, fullchain)
+}
+
+// httpServerConf is the port 80 server block CreateSite writes for a new
+// site.
+func httpServerConf(names, listen, root string) string {
+	return fmt.Sprintf(`server {
+    listen %s;
+    server_name %s;
+    root %s;
+}
+`, listen, names, root)
 }
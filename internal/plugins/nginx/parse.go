@@ -0,0 +1,219 @@
+package nginx
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Web roots are often not literal: "root $docroot;" with the variable set
+// in the server block or chosen by a map in nginx.conf, or the root kept in
+// an included snippet. The helpers below expand includes and resolve simple
+// set and map definitions; anything more dynamic is left to the user.
+
+var (
+	includeRe = regexp.MustCompile(`(?i)^\s*include\s+([^;]+);`)
+	setRe     = regexp.MustCompile(`(?i)^\s*set\s+\$(\w+)\s+([^;]+);`)
+	mapRe     = regexp.MustCompile(`(?i)^\s*map\s+(\S+)\s+\$(\w+)\s*\{`)
+	mapLineRe = regexp.MustCompile(`^\s*(\S+)\s+([^;]+);`)
+	varRe     = regexp.MustCompile(`\$\{?(\w+)\}?`)
+)
+
+// mainConf is the top-level config; relative include paths start from its
+// directory.
+const mainConf = "/etc/nginx/nginx.conf"
+
+// maxIncludeDepth stops include loops.
+const maxIncludeDepth = 8
+
+// configLines returns the lines of path with every include replaced by the
+// lines of the files it names.
+func configLines(path string) []string {
+	return appendConfigLines(nil, path, 0)
+}
+
+func appendConfigLines(out []string, path string, depth int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return out
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if m := includeRe.FindStringSubmatch(line); len(m) == 2 && depth < maxIncludeDepth {
+			for _, inc := range includePaths(m[1]) {
+				out = appendConfigLines(out, inc, depth+1)
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func includePaths(pattern string) []string {
+	pattern = strings.Trim(strings.TrimSpace(pattern), `"'`)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(mainConf), pattern)
+	}
+	matches, _ := filepath.Glob(pattern)
+	return matches
+}
+
+// nginxMap is a map block: the variable it reads and its entries.
+type nginxMap struct {
+	source  string
+	entries [][2]string // key, value in file order; "default" included
+}
+
+// lookup returns the value the map yields for requests to domain.
+func (m nginxMap) lookup(domain string) (string, bool) {
+	switch m.source {
+	case "$host", "$http_host", "$server_name", "$ssl_server_name":
+	default:
+		return m.value("default")
+	}
+	for _, e := range m.entries {
+		key := e[0]
+		switch {
+		case key == "default" || key == "hostnames" || key == "volatile":
+			continue
+		case strings.HasPrefix(key, "~"):
+			re, err := regexp.Compile(strings.TrimPrefix(strings.TrimPrefix(key, "~*"), "~"))
+			if err == nil && re.MatchString(domain) {
+				return e[1], true
+			}
+		case strings.HasPrefix(key, "."):
+			if domain == key[1:] || strings.HasSuffix(domain, key) {
+				return e[1], true
+			}
+		case strings.EqualFold(key, domain) || store.ServesName(key, domain):
+			return e[1], true
+		}
+	}
+	return m.value("default")
+}
+
+func (m nginxMap) value(key string) (string, bool) {
+	for _, e := range m.entries {
+		if e[0] == key {
+			return e[1], true
+		}
+	}
+	return "", false
+}
+
+// parseMaps collects the map blocks in lines, keyed by the variable they set.
+func parseMaps(lines []string, into map[string]nginxMap) {
+	var cur *nginxMap
+	var name string
+	for _, line := range lines {
+		if cur != nil {
+			if strings.HasPrefix(line, "}") {
+				into[name] = *cur
+				cur = nil
+				continue
+			}
+			if m := mapLineRe.FindStringSubmatch(line); len(m) == 3 {
+				cur.entries = append(cur.entries, [2]string{unquote(m[1]), unquote(m[2])})
+			}
+			continue
+		}
+		if m := mapRe.FindStringSubmatch(line); len(m) == 3 {
+			cur, name = &nginxMap{source: m[1]}, m[2]
+		}
+	}
+}
+
+// globalMaps returns the maps defined in nginx.conf and everything it
+// includes, plus those in the vhost directories.
+func globalMaps() map[string]nginxMap {
+	maps := map[string]nginxMap{}
+	parseMaps(configLines(mainConf), maps)
+	for _, dir := range candidateConfDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if !e.IsDir() {
+				parseMaps(configLines(filepath.Join(dir, e.Name())), maps)
+			}
+		}
+	}
+	return maps
+}
+
+// resolveRoot substitutes the variables in root. When a map cannot be
+// resolved from the host name alone it returns "" and the roots each of the
+// map's values would give.
+func resolveRoot(root, domain string, vars map[string]string, maps map[string]nginxMap) (string, []string) {
+	for i := 0; i < maxIncludeDepth && strings.Contains(root, "$"); i++ {
+		next := varRe.ReplaceAllStringFunc(root, func(ref string) string {
+			name := varRe.FindStringSubmatch(ref)[1]
+			switch name {
+			case "host", "http_host", "server_name", "ssl_server_name":
+				return domain
+			}
+			if v, ok := vars[name]; ok {
+				return v
+			}
+			if m, ok := maps[name]; ok {
+				if v, ok := m.lookup(domain); ok {
+					return v
+				}
+			}
+			return ref
+		})
+		if next == root {
+			break
+		}
+		root = next
+	}
+	if !strings.Contains(root, "$") {
+		return root, nil
+	}
+	for _, ref := range varRe.FindAllStringSubmatch(root, -1) {
+		m, ok := maps[ref[1]]
+		if !ok {
+			continue
+		}
+		var out []string
+		for _, e := range m.entries {
+			if strings.Contains(e[1], ref[0]) {
+				continue
+			}
+			if r, _ := resolveRoot(strings.Replace(root, ref[0], e[1], 1), domain, vars, maps); r != "" {
+				out = append(out, r)
+			}
+		}
+		return "", out
+	}
+	return "", nil
+}
+
+func unquote(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `"'`)
+}
+
+// existingFirst orders roots so directories that exist come first and drops
+// duplicates.
+func existingFirst(roots []string) []string {
+	seen := map[string]bool{}
+	var found, missing []string
+	for _, r := range roots {
+		if r == "" || seen[r] {
+			continue
+		}
+		seen[r] = true
+		if osutil.DirExists(r) {
+			found = append(found, r)
+		} else {
+			missing = append(missing, r)
+		}
+	}
+	return append(found, missing...)
+}
@@ -0,0 +1,186 @@
+// Package truststore adds a local root CA to the trust stores of the
+// operating system and of NSS-based browsers (Firefox, and Chrome on Linux),
+// so certificates it issues for development are accepted without warnings.
+package truststore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/osutil"
+)
+
+// systemAnchor is a Linux trust anchor directory and the command that
+// rebuilds the system bundle from it.
+type systemAnchor struct {
+	dir    string
+	update []string
+}
+
+var linuxAnchors = []systemAnchor{
+	{"/usr/local/share/ca-certificates", []string{"update-ca-certificates"}},           // Debian, Ubuntu, Alpine
+	{"/etc/pki/ca-trust/source/anchors", []string{"update-ca-trust", "extract"}},       // RHEL, Fedora
+	{"/etc/ca-certificates/trust-source/anchors", []string{"trust", "extract-compat"}}, // Arch
+}
+
+// Install trusts the CA certificate in caFile under nickname in every store
+// found on this machine, and returns the stores it was added to. A store
+// that cannot be updated is reported in the error without stopping the
+// others.
+func Install(nickname, caFile string) ([]string, error) {
+	var done []string
+	var errs []error
+	if where, err := installSystem(nickname, caFile); err != nil {
+		errs = append(errs, err)
+	} else if where != "" {
+		done = append(done, where)
+	}
+	for _, db := range nssDatabases() {
+		if err := osutil.RunWithInput(nil, "certutil", "-A", "-d", db, "-t", "C,,", "-n", nickname, "-i", caFile); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		done = append(done, "NSS "+strings.TrimPrefix(db, "sql:"))
+	}
+	return done, errors.Join(errs...)
+}
+
+// Uninstall removes what Install added and returns the stores it was
+// removed from.
+func Uninstall(nickname, caFile string) ([]string, error) {
+	var done []string
+	var errs []error
+	if where, err := uninstallSystem(nickname, caFile); err != nil {
+		errs = append(errs, err)
+	} else if where != "" {
+		done = append(done, where)
+	}
+	for _, db := range nssDatabases() {
+		if osutil.Run("certutil", "-L", "-d", db, "-n", nickname) != nil {
+			continue
+		}
+		if err := osutil.RunWithInput(nil, "certutil", "-D", "-d", db, "-n", nickname); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		done = append(done, "NSS "+strings.TrimPrefix(db, "sql:"))
+	}
+	return done, errors.Join(errs...)
+}
+
+func installSystem(nickname, caFile string) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		a, ok := linuxAnchor()
+		if !ok {
+			return "", errors.New("no system trust store found (update-ca-certificates, update-ca-trust or trust)")
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return "", err
+		}
+		dst := filepath.Join(a.dir, anchorName(nickname))
+		if err := osutil.WriteFilePrivileged(dst, pem, 0644); err != nil {
+			return "", fmt.Errorf("write %s: %w", dst, err)
+		}
+		if err := osutil.RunPrivileged(a.update[0], a.update[1:]...); err != nil {
+			return "", fmt.Errorf("%s: %w", a.update[0], err)
+		}
+		return "system (" + dst + ")", nil
+	case "darwin":
+		err := osutil.RunPrivileged("security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", caFile)
+		if err != nil {
+			return "", fmt.Errorf("security add-trusted-cert: %w", err)
+		}
+		return "macOS System keychain", nil
+	case "windows":
+		if err := osutil.Run("certutil", "-addstore", "-f", "ROOT", caFile); err != nil {
+			return "", fmt.Errorf("certutil -addstore: %w", err)
+		}
+		return "Windows ROOT store", nil
+	}
+	return "", fmt.Errorf("installing into the %s trust store is not supported", runtime.GOOS)
+}
+
+func uninstallSystem(nickname, caFile string) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		a, ok := linuxAnchor()
+		if !ok {
+			return "", nil
+		}
+		dst := filepath.Join(a.dir, anchorName(nickname))
+		if !osutil.FileExists(dst) {
+			return "", nil
+		}
+		if err := osutil.RunPrivileged("rm", "-f", dst); err != nil {
+			return "", fmt.Errorf("remove %s: %w", dst, err)
+		}
+		if err := osutil.RunPrivileged(a.update[0], a.update[1:]...); err != nil {
+			return "", fmt.Errorf("%s: %w", a.update[0], err)
+		}
+		return "system (" + dst + ")", nil
+	case "darwin":
+		if err := osutil.RunPrivileged("security", "remove-trusted-cert", "-d", caFile); err != nil {
+			return "", fmt.Errorf("security remove-trusted-cert: %w", err)
+		}
+		return "macOS System keychain", nil
+	case "windows":
+		if err := osutil.Run("certutil", "-delstore", "ROOT", nickname); err != nil {
+			return "", fmt.Errorf("certutil -delstore: %w", err)
+		}
+		return "Windows ROOT store", nil
+	}
+	return "", nil
+}
+
+func linuxAnchor() (systemAnchor, bool) {
+	for _, a := range linuxAnchors {
+		if osutil.DirExists(a.dir) && osutil.CommandExists(a.update[0]) {
+			return a, true
+		}
+	}
+	return systemAnchor{}, false
+}
+
+// anchorName is the file name of the CA in a Linux anchor directory;
+// update-ca-certificates only picks up .crt files.
+func anchorName(nickname string) string {
+	return strings.ToLower(strings.ReplaceAll(nickname, " ", "-")) + ".crt"
+}
+
+// nssDatabases returns the NSS databases of the current user: Chrome's and
+// Chromium's shared one on Linux and every Firefox profile. They are only
+// returned when NSS's certutil is installed to update them.
+func nssDatabases() []string {
+	if runtime.GOOS == "windows" || !osutil.CommandExists("certutil") {
+		return nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	if runtime.GOOS == "linux" {
+		dirs = append(dirs, filepath.Join(home, ".pki", "nssdb"))
+	}
+	for _, pattern := range []string{
+		filepath.Join(home, ".mozilla", "firefox", "*"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "*"),
+		filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles", "*"),
+	} {
+		profiles, _ := filepath.Glob(pattern)
+		dirs = append(dirs, profiles...)
+	}
+	var out []string
+	for _, d := range dirs {
+		if osutil.FileExists(filepath.Join(d, "cert9.db")) {
+			out = append(out, "sql:"+d)
+		}
+	}
+	return out
+}
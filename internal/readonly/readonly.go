@@ -0,0 +1,80 @@
+// Package readonly implements read-only mode, for exploring trusttls on
+// production hosts during audits: certificates can be listed, inspected,
+// verified and reported on, while writing files, ordering or revoking
+// certificates and reloading services are refused.
+//
+// The CLI refuses whole commands that change anything; the checks in the
+// store, the issuers and the privileged helpers are a second line for code
+// paths a command did not expect.
+package readonly
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"gopkg.in/yaml.v3"
+)
+
+// Env turns read-only mode on when set to a true value, e.g.
+// TRUSTTLS_READ_ONLY=1.
+const Env = "TRUSTTLS_READ_ONLY"
+
+// ErrReadOnly is returned for anything refused in read-only mode.
+var ErrReadOnly = errors.New("refused in read-only mode")
+
+var enabled atomic.Bool
+
+// Enable turns read-only mode on for the rest of the process. There is no
+// way back: a host configured read-only stays so.
+func Enable() { enabled.Store(true) }
+
+// Enabled reports whether read-only mode is on.
+func Enabled() bool { return enabled.Load() }
+
+// Check returns an error naming action when read-only mode is on.
+func Check(action string) error {
+	if !Enabled() {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", action, ErrReadOnly)
+}
+
+// Settings are the host-wide options kept in <baseDir>/config.yaml.
+type Settings struct {
+	ReadOnly bool `yaml:"read_only,omitempty"` // never write, order or reload on this host
+}
+
+// ConfigPath returns where the host-wide options of baseDir are kept.
+func ConfigPath(baseDir string) string {
+	return confdir.Path(baseDir, "config.yaml")
+}
+
+// Configured reports whether read-only mode is turned on for baseDir by
+// config.yaml or by the environment. A missing config.yaml means no.
+func Configured(baseDir string) (bool, error) {
+	if v := os.Getenv(Env); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("%s=%q: want true or false", Env, v)
+		}
+		if on {
+			return true, nil
+		}
+	}
+	b, err := os.ReadFile(ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var s Settings
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return false, fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	return s.ReadOnly, nil
+}
@@ -0,0 +1,86 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// KeyAccess returns the store.Access of c's cert_owner, cert_group and
+// key_mode.
+func KeyAccess(c Config) (store.Access, error) {
+	a := store.Access{UID: -1, GID: -1}
+	var err error
+	if c.CertOwner != "" {
+		if a.UID, err = lookupOwner(c.CertOwner); err != nil {
+			return a, fmt.Errorf("cert_owner: %w", err)
+		}
+	}
+	if c.CertGroup != "" {
+		if a.GID, err = lookupGroup(c.CertGroup); err != nil {
+			return a, fmt.Errorf("cert_group: %w", err)
+		}
+	}
+	if c.KeyMode != "" {
+		if a.KeyMode, err = ParseKeyMode(c.KeyMode); err != nil {
+			return a, fmt.Errorf("key_mode: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// lookupOwner returns the ID of the user name, which may be numeric.
+func lookupOwner(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, fmt.Errorf("no user %s on this system", name)
+		}
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroup returns the ID of the group name, which may be numeric.
+func lookupGroup(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if g, err = user.LookupGroupId(name); err != nil {
+			return 0, fmt.Errorf("no group %s on this system", name)
+		}
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// ParseKeyMode parses an octal private key mode such as 0640. The owner
+// must be able to read the key, and nobody else to write or execute it.
+func ParseKeyMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode such as 0640", s)
+	}
+	if m&0400 == 0 || m&0133 != 0 {
+		return 0, fmt.Errorf("%s must let the owner read the key and nobody else write or execute it", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// ApplyAccess gives the files of c's certificate the owner, group and key
+// mode its renewal config asks for.
+func ApplyAccess(c Config) error {
+	a, err := KeyAccess(c)
+	if err != nil {
+		return err
+	}
+	return store.SetAccess(c.BaseDir, c.Lineage(), a)
+}
+
+// applyAccess runs ApplyAccess after files were added to c's lineage. A
+// failure is reported but does not undo the renewal.
+func applyAccess(c Config) {
+	if err := ApplyAccess(c); err != nil {
+		fmt.Printf("⚠️  could not set the owner and mode of %s's files: %v\n", c.Lineage(), err)
+	}
+}
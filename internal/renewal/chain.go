@@ -0,0 +1,29 @@
+package renewal
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+)
+
+// CompleteChain fills in intermediates missing from the chain of cert
+// before it is stored and installed, so clients that do not fetch them
+// themselves still trust it. c supplies the lineage name and the extra
+// roots of a private CA. A chain that stays incomplete is kept as it is,
+// with a warning.
+func CompleteChain(c Config, cert *certificate.Resource) {
+	var roots *x509.CertPool
+	if c.CABundle != "" {
+		roots, _ = acme.LoadCABundle(c.CABundle)
+	}
+	chain, fetched, err := acme.CompleteChain(cert.Certificate, cert.IssuerCertificate, roots)
+	for _, f := range fetched {
+		fmt.Printf("🔗 %s: added the missing intermediate %s from %s\n", c.Lineage(), f.Subject, f.URL)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  %s: %v\n", c.Lineage(), err)
+	}
+	cert.IssuerCertificate = chain
+}
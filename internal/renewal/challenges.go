@@ -0,0 +1,151 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"gopkg.in/yaml.v3"
+)
+
+// Challenge types that can be listed in a challenge preference.
+const (
+	ChallengeHTTP = "http-01"
+	ChallengeDNS  = "dns-01"
+)
+
+// hostChallenges is the host-wide challenge preference kept under
+// "challenges" in config.yaml, next to read_only: an ordered list of
+// challenge types per domain pattern.
+type hostChallenges struct {
+	Challenges map[string][]string `yaml:"challenges,omitempty"`
+}
+
+// LoadChallengePreferences returns the challenge preferences in baseDir's
+// config.yaml by domain pattern: a name such as example.com, "*.example.com"
+// for every name under example.com, or "*" for all names. A missing file
+// means none.
+func LoadChallengePreferences(baseDir string) (map[string][]string, error) {
+	b, err := os.ReadFile(readonly.ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h hostChallenges
+	if err := yaml.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("%s: %w", readonly.ConfigPath(baseDir), err)
+	}
+	return h.Challenges, nil
+}
+
+// CheckChallenges returns why list is not a usable challenge preference.
+func CheckChallenges(list []string) error {
+	seen := map[string]bool{}
+	for _, ch := range list {
+		switch ch {
+		case ChallengeHTTP, ChallengeDNS:
+		default:
+			return fmt.Errorf("unknown challenge type %q (use %s or %s)", ch, ChallengeHTTP, ChallengeDNS)
+		}
+		if seen[ch] {
+			return fmt.Errorf("%s is listed twice", ch)
+		}
+		seen[ch] = true
+	}
+	return nil
+}
+
+// MatchChallenges returns the preference in prefs for the first of names
+// that has one, and the pattern it came from. Of the patterns matching a
+// name the most specific wins: the name itself, then the longest
+// "*.<domain>", then "*".
+func MatchChallenges(prefs map[string][]string, names []string) ([]string, string) {
+	var patterns []string
+	for p := range prefs {
+		patterns = append(patterns, p)
+	}
+	// Longest first; "*" sorts last
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if list, ok := prefs[name]; ok {
+			return list, name
+		}
+		for _, p := range patterns {
+			lp := strings.ToLower(p)
+			if lp == "*" || (strings.HasPrefix(lp, "*.") && strings.HasSuffix(name, lp[1:])) {
+				return prefs[p], p
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Challenges returns the challenge types to try for c, most preferred
+// first: its own challenges, else the host-wide preference for its names,
+// else just its method. Types c has no settings for are left out, and
+// issuers that do not use ACME challenges get nil.
+func Challenges(c Config) ([]string, error) {
+	if !issuer.Validates(IssuerName(c)) || IssuerName(c) == "digicert" {
+		return nil, nil
+	}
+	list := c.Challenges
+	if len(list) == 0 {
+		prefs, err := LoadChallengePreferences(c.BaseDir)
+		if err != nil {
+			return nil, err
+		}
+		list, _ = MatchChallenges(prefs, c.Names())
+	}
+	var out []string
+	for _, ch := range list {
+		if _, ok := WithChallenge(c, ch); ok {
+			out = append(out, ch)
+		}
+	}
+	if len(out) == 0 {
+		return []string{c.Method}, nil
+	}
+	return out, nil
+}
+
+// WithChallenge returns c set up to validate with the challenge type ch
+// alone, and whether c has what ch needs: a DNS provider for dns-01, and a
+// webroot, remote webroot or built-in server for http-01 unless it is c's
+// own method anyway.
+func WithChallenge(c Config, ch string) (Config, bool) {
+	switch ch {
+	case ChallengeDNS:
+		if c.DNSPlugin == "" {
+			return c, false
+		}
+		c.Webroot, c.RemoteWebroot, c.Standalone = "", "", ""
+	case ChallengeHTTP:
+		if c.Method != ChallengeHTTP && c.Webroot == "" && c.RemoteWebroot == "" && c.Standalone == "" {
+			return c, false
+		}
+		c.DNSPlugin, c.DNSCredentials = "", ""
+	default:
+		return c, false
+	}
+	c.Method = ch
+	return c, true
+}
+
+// FallBack reports whether an order that failed with err may be retried
+// with the next challenge type. A rate limit is not: the CA would refuse
+// that order just the same.
+func FallBack(err error) bool {
+	return err != nil && acme.ErrorCode(err) != acme.CodeRateLimited
+}
@@ -0,0 +1,77 @@
+package renewal
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// LineageFiles returns what the store keeps for the certificate of c and
+// that exist: its renewal config, its live/ and archive/ directories and the
+// state renewals track for it (deployments, rate limit holds, pending
+// DigiCert orders, placeholders, rollovers, DNS credentials, and export and
+// remote webroot passwords).
+func LineageFiles(c Config) []string {
+	name := store.LineageName(c.Lineage())
+	candidates := []string{
+		// The renewal config goes first: a delete that fails halfway leaves
+		// nothing that renews into a missing lineage
+		configPath(c.Lineage()),
+		filepath.Join(c.BaseDir, "live", name),
+		filepath.Join(c.BaseDir, "archive", name),
+		deploymentsPath(c.BaseDir, c.Lineage()),
+		holdPath(c.BaseDir, c.Lineage()),
+		DigiCertOrderFile(c),
+		placeholderPath(c.BaseDir, c.Lineage()),
+		rolloverPath(c.BaseDir, c.Lineage()),
+		filepath.Dir(ExportPasswordFile(c, "")),
+		RemotePasswordFile(c),
+	}
+	if c.DNSPlugin != "" {
+		candidates = append(candidates, dnsprovider.LineageCredentialsPath(c.BaseDir, name, c.DNSPlugin))
+	}
+	var out []string
+	for _, p := range candidates {
+		if _, err := os.Lstat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ReferringConfigs returns the Apache and Nginx configs that load files of
+// the certificate of c; they break once the certificate is deleted.
+func ReferringConfigs(c Config) []string {
+	lineageDir := filepath.Join(c.BaseDir, "live", store.LineageName(c.Lineage()))
+	return append(referringConfigs(apache.ConfigDirs(), lineageDir), referringConfigs(nginx.ConfigDirs(), lineageDir)...)
+}
+
+// Delete removes everything LineageFiles lists for c, so the certificate is
+// neither served from the store nor renewed again. Web server configs and
+// copies delivered elsewhere are left alone.
+func Delete(c Config) ([]string, error) {
+	if err := readonly.Check("delete certificate"); err != nil {
+		return nil, err
+	}
+	unlock, err := store.LockLineage(c.BaseDir, c.Lineage())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer interrupt.Hold()()
+	var removed []string
+	for _, p := range LineageFiles(c) {
+		if err := os.RemoveAll(p); err != nil {
+			return removed, err
+		}
+		removed = append(removed, p)
+	}
+	store.DropIndex(c.BaseDir, c.Lineage())
+	return removed, nil
+}
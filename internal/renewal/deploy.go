@@ -0,0 +1,192 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/plugins/haproxy"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// DeployCopy copies one of the certificate's files to where a daemon reads
+// it, after every issuance and renewal.
+type DeployCopy struct {
+	Src    string `yaml:"src"`              // cert|chain|fullchain|privkey, or bundle: full chain and key in one file, as HAProxy loads it
+	Dst    string `yaml:"dst"`              // absolute path of the copy
+	Mode   string `yaml:"mode,omitempty"`   // octal, e.g. "0640"; default 0600 when the copy holds the key, else 0644
+	Owner  string `yaml:"owner,omitempty"`  // user given the copy
+	Group  string `yaml:"group,omitempty"`  // group given the copy
+	Reload string `yaml:"reload,omitempty"` // shell command run once the copies are written, e.g. "systemctl reload haproxy"
+}
+
+// DeploySources lists what a deploy copy can take.
+var DeploySources = []string{"cert", "chain", "fullchain", "privkey", "bundle"}
+
+// holdsKey reports whether the copy contains the private key.
+func (d DeployCopy) holdsKey() bool {
+	return d.Src == "privkey" || d.Src == "bundle"
+}
+
+// perm returns the mode of the copy.
+func (d DeployCopy) perm() (os.FileMode, error) {
+	if d.Mode == "" {
+		if d.holdsKey() {
+			return 0600, nil
+		}
+		return 0644, nil
+	}
+	m, err := strconv.ParseUint(d.Mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode such as 0640", d.Mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// copyProblem is what is wrong with a field of a deploy copy.
+type copyProblem struct {
+	field string
+	err   error
+}
+
+// checkCopy returns what is wrong with d.
+func checkCopy(d DeployCopy) []copyProblem {
+	var out []copyProblem
+	if !contains(DeploySources, d.Src) {
+		out = append(out, copyProblem{"src", fmt.Errorf("must be one of %s, not %q", strings.Join(DeploySources, ", "), d.Src)})
+	}
+	if d.Dst == "" {
+		out = append(out, copyProblem{"dst", fmt.Errorf("is required")})
+	} else if !filepath.IsAbs(d.Dst) {
+		out = append(out, copyProblem{"dst", fmt.Errorf("must be an absolute path, not %s", d.Dst)})
+	}
+	if _, err := d.perm(); err != nil {
+		out = append(out, copyProblem{"mode", err})
+	}
+	if d.Owner != "" {
+		if _, err := lookupOwner(d.Owner); err != nil {
+			out = append(out, copyProblem{"owner", err})
+		}
+	}
+	if d.Group != "" {
+		if _, err := lookupGroup(d.Group); err != nil {
+			out = append(out, copyProblem{"group", err})
+		}
+	}
+	return out
+}
+
+// DeployCopies writes the deploy copies of c's renewal config from the
+// certificate in the store, records each for 'trusttls where', and then
+// runs their reload commands, each distinct one once.
+func DeployCopies(c Config, verbose bool) error {
+	if len(c.Deploy) == 0 {
+		return nil
+	}
+	files := map[string][]byte{}
+	certPath, keyPath, chainPath, fullchainPath := store.LoadCertPaths(c.BaseDir, c.Lineage())
+	for kind, p := range map[string]string{"cert": certPath, "chain": chainPath, "fullchain": fullchainPath, "privkey": keyPath} {
+		b, err := os.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			files[kind] = b
+		}
+	}
+	if files["privkey"] != nil {
+		files["bundle"] = haproxy.Bundle(files["fullchain"], files["privkey"])
+	}
+	var reloads []string
+	for _, d := range c.Deploy {
+		err := deployCopy(d, files)
+		noteDeployment(c, copyDeployment(d), err)
+		if err != nil {
+			return fmt.Errorf("copy %s of %s to %s: %w", d.Src, c.Lineage(), d.Dst, err)
+		}
+		if verbose {
+			fmt.Printf("copied %s of %s to %s\n", d.Src, c.Lineage(), d.Dst)
+		}
+		if r := strings.TrimSpace(d.Reload); r != "" && !contains(reloads, r) {
+			reloads = append(reloads, r)
+		}
+	}
+	for _, r := range reloads {
+		if err := runHook("reload", r, c, verbose); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deployCopy(d DeployCopy, files map[string][]byte) error {
+	if problems := checkCopy(d); len(problems) > 0 {
+		return fmt.Errorf("%s %w", problems[0].field, problems[0].err)
+	}
+	data, ok := files[d.Src]
+	if !ok {
+		return fmt.Errorf("the store has no %s for this certificate", d.Src)
+	}
+	perm, _ := d.perm()
+	uid, gid := -1, -1
+	if d.Owner != "" {
+		uid, _ = lookupOwner(d.Owner)
+	}
+	if d.Group != "" {
+		gid, _ = lookupGroup(d.Group)
+	}
+	return writeCopy(d.Dst, data, perm, uid, gid)
+}
+
+// writeCopy replaces path with data, so a daemon reading it sees either
+// the old or the new file.
+func writeCopy(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	if err := readonly.Check("write " + path); err != nil {
+		return err
+	}
+	defer interrupt.Hold()()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".trusttls-copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := tmp.Chown(uid, gid); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func copyDeployment(d DeployCopy) Deployment {
+	return Deployment{Kind: "copy", Target: d.Dst, Detail: d.Src}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package renewal
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/replicate"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// DryRunResult is what rehearsing the renewal of a certificate found.
+type DryRunResult struct {
+	Lineage string
+	// Skipped says why no order was placed, for CAs without a staging
+	// environment; empty when one was
+	Skipped string
+	Server  string // ACME directory the test order went to
+	// Due is set when a renew run would renew the certificate now;
+	// RenewAt is when it becomes due otherwise
+	Due     bool
+	RenewAt time.Time
+	// Serial, Names and NotAfter describe the test certificate
+	Serial   string
+	Names    []string
+	NotAfter time.Time
+	// Actions are what a real renewal would have done after storing it
+	Actions []string
+}
+
+// stagingServer returns the ACME directory to rehearse c's renewal
+// against: Let's Encrypt's staging environment for Let's Encrypt, and a
+// private or staging server itself, where test orders cost nothing. CAs
+// that issue real certificates on every order are not rehearsed.
+func stagingServer(c Config) (string, error) {
+	switch IssuerName(c) {
+	case "letsencrypt":
+		switch {
+		case c.Server == "" || c.Server == acme.LetsEncryptProd:
+			return acme.LetsEncryptStaging, nil
+		case c.Server == acme.LetsEncryptStaging || c.CABundle != "":
+			return c.Server, nil
+		}
+		return "", fmt.Errorf("%s has no staging environment known to trusttls", c.Server)
+	case "step-ca":
+		return c.Server, nil
+	}
+	return "", fmt.Errorf("%s has no staging environment", IssuerName(c))
+}
+
+// DryRun rehearses the renewal of c: it orders a test certificate for c's
+// names from a staging environment, solving the challenges as a renewal
+// would, and reports what a renewal would then have done. The stored
+// certificate, renewal config and web server configs are left alone, and no
+// hooks run. Only the staging account is kept in the store, so the next dry
+// run does not register another one.
+func DryRun(c Config, verbose bool) (*DryRunResult, error) {
+	if err := ownStore(c); err != nil {
+		return nil, err
+	}
+	r := &DryRunResult{Lineage: c.Lineage(), Due: true, Actions: plannedActions(c)}
+	if l, err := store.LoadLineage(c.BaseDir, c.Lineage()); err == nil {
+		r.RenewAt = RenewAt(c, l.NotAfter)
+		r.Due = !time.Now().Before(r.RenewAt)
+	}
+	server, err := stagingServer(c)
+	if err != nil {
+		r.Skipped = err.Error()
+		return r, nil
+	}
+	c.Server, r.Server = server, server
+	err = renewOne(c, verbose, func(c Config, cert *certificate.Resource) error {
+		block, _ := pem.Decode(cert.Certificate)
+		if block == nil {
+			return errors.New("the staging server returned no certificate")
+		}
+		x, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parse test certificate: %w", err)
+		}
+		r.Serial, r.Names, r.NotAfter = fmt.Sprintf("%x", x.SerialNumber), x.DNSNames, x.NotAfter
+		return nil
+	})
+	var werr *WebrootError
+	if errors.As(err, &werr) {
+		if detected := DetectWebroot(c.Domain); detected != "" && detected != c.Webroot {
+			err = fmt.Errorf("%w; the web server now serves %s from %s, which 'trusttls renew' would offer to switch to", err, c.Domain, detected)
+		}
+	}
+	return r, err
+}
+
+// plannedActions lists what renewing c does once the new certificate is
+// stored, in the order Renew does it.
+func plannedActions(c Config) []string {
+	var out []string
+	if c.Soak != "" {
+		out = append(out, fmt.Sprintf("keep the previous certificate in live/%s/previous/ for %s", c.Lineage(), c.Soak))
+	}
+	if c.MTASTS != nil {
+		out = append(out, "refresh the MTA-STS policy")
+	}
+	if peers, err := replicate.Load(c.BaseDir); err == nil {
+		for _, p := range peers.Peers {
+			if p.Wants(c.Lineage()) {
+				out = append(out, "copy to replication peer "+p.Name)
+			}
+		}
+	}
+	if c.HAProxy != nil {
+		out = append(out, fmt.Sprintf("load %s into HAProxy at %s", c.HAProxy.CertFile, c.HAProxy.Socket))
+	}
+	for _, e := range c.Exports {
+		out = append(out, fmt.Sprintf("export %s to %s", e.Format, e.Path))
+	}
+	var reloads []string
+	for _, d := range c.Deploy {
+		out = append(out, fmt.Sprintf("copy %s to %s", d.Src, d.Dst))
+		if r := strings.TrimSpace(d.Reload); r != "" && !contains(reloads, r) {
+			reloads = append(reloads, r)
+		}
+	}
+	for _, r := range reloads {
+		out = append(out, "run "+r)
+	}
+	if c.DeployHook != "" {
+		out = append(out, "run the deploy hook: "+c.DeployHook)
+	}
+	if c.PostHook != "" {
+		out = append(out, "run the post hook: "+c.PostHook)
+	}
+	if len(c.Targets) > 0 {
+		out = append(out, "serve it from "+strings.Join(c.Targets, " and ")+" through live/"+c.Lineage()+"/")
+	}
+	return out
+}
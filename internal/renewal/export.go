@@ -0,0 +1,97 @@
+package renewal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/export"
+	"github.com/trustctl/trusttls/internal/seal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// ExportConfig writes the certificate in another format after every
+// renewal, for consumers that cannot read the PEM files in live/.
+type ExportConfig struct {
+	Format       string `yaml:"format"`                  // p12|der|pem|haproxy
+	Path         string `yaml:"path"`                    // file to write, or directory for der and pem
+	PasswordFile string `yaml:"password_file,omitempty"` // p12 only: file holding the bundle's password; may be sealed
+	Legacy       bool   `yaml:"legacy,omitempty"`        // p12 only: 3DES/SHA-1 for Java before 8u301 and Windows Server 2016
+}
+
+// ExportPasswordFile returns where the password of c's export to path is
+// kept when it was given on the command line.
+func ExportPasswordFile(c Config, path string) string {
+	return filepath.Join(c.BaseDir, "exports", store.LineageName(c.Lineage()), filepath.Base(path)+".password")
+}
+
+// SaveExportPassword keeps password for c's export to path in the store,
+// sealed when encryption is on, and returns the file it is in.
+func SaveExportPassword(c Config, path, password string) (string, error) {
+	p := ExportPasswordFile(c, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return "", err
+	}
+	return p, seal.WriteFile(p, []byte(password), 0600)
+}
+
+// ReadExportPassword reads the password in path. A trailing newline, as
+// left by editors and echo, is not part of it.
+func ReadExportPassword(path string) (string, error) {
+	b, err := seal.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(b, "\r\n")), nil
+}
+
+// Export writes the current certificate of c as e says, with the password
+// given, and records the delivery for 'trusttls where'.
+func Export(c Config, e ExportConfig, password string) error {
+	err := exportCertificate(c, e, password)
+	noteDeployment(c, exportDeployment(e), err)
+	return err
+}
+
+func exportCertificate(c Config, e ExportConfig, password string) error {
+	b, err := store.Open(c.BaseDir).Load(c.Lineage())
+	if err != nil {
+		return err
+	}
+	m := export.Material{Cert: b.Cert, Chain: b.Chain, Key: b.Key}
+	files, err := export.Build(e.Format, m, password, e.Legacy)
+	if err != nil {
+		return fmt.Errorf("%s export of %s: %w", e.Format, c.Lineage(), err)
+	}
+	if err := export.Write(e.Path, e.Format, files); err != nil {
+		return fmt.Errorf("write %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// exportAll runs the exports of c's renewal config.
+func exportAll(c Config, verbose bool) error {
+	for _, e := range c.Exports {
+		var password string
+		if export.NeedsPassword(e.Format) {
+			var err error
+			if password, err = ReadExportPassword(e.PasswordFile); err != nil {
+				err = fmt.Errorf("%s export to %s: password: %w", e.Format, e.Path, err)
+				noteDeployment(c, exportDeployment(e), err)
+				return err
+			}
+		}
+		if err := Export(c, e, password); err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("exported %s as %s to %s\n", c.Lineage(), e.Format, e.Path)
+		}
+	}
+	return nil
+}
+
+func exportDeployment(e ExportConfig) Deployment {
+	return Deployment{Kind: "export", Target: e.Path, Detail: e.Format}
+}
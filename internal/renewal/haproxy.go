@@ -0,0 +1,47 @@
+package renewal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/trustctl/trusttls/internal/plugins/haproxy"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// HAProxyConfig swaps renewed certificates into a running HAProxy over its
+// runtime API instead of reloading it.
+type HAProxyConfig struct {
+	Socket   string `yaml:"socket"`    // "stats socket ... level admin" path, or host:port
+	CertFile string `yaml:"cert_file"` // combined PEM as named on HAProxy's crt line
+}
+
+// DeployHAProxy writes the current certificate and key of c to its HAProxy
+// cert file and loads them into the running HAProxy, recording the outcome
+// for 'trusttls where'.
+func DeployHAProxy(c Config) error {
+	err := deployHAProxy(c)
+	noteDeployment(c, haproxyDeployment(c), err)
+	return err
+}
+
+func deployHAProxy(c Config) error {
+	h := c.HAProxy
+	if c.KeySink != "" {
+		return errors.New("haproxy needs the private key, which key_sink keeps out of the store")
+	}
+	if c.CSR != "" {
+		return errors.New("haproxy needs the private key, which stays with the CSR's owner")
+	}
+	b, err := store.Open(c.BaseDir).Load(c.Lineage())
+	if err != nil {
+		return err
+	}
+	if len(b.Key) == 0 {
+		return fmt.Errorf("%s has no private key in the store", c.Lineage())
+	}
+	pem := haproxy.Bundle(b.Fullchain(), b.Key)
+	if err := haproxy.WriteBundle(h.CertFile, pem); err != nil {
+		return fmt.Errorf("write %s: %w", h.CertFile, err)
+	}
+	return haproxy.Update(h.Socket, h.CertFile, pem)
+}
@@ -0,0 +1,30 @@
+package renewal
+
+import (
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// RecordIssuance adds how cert, just stored for c, was obtained to the
+// lineage's history, shown by 'trusttls inspect --history'. Failing to is
+// only reported: the certificate is in place by then.
+func RecordIssuance(c Config, cert *certificate.Resource) {
+	i := store.Issuance{Provider: IssuerName(c)}
+	if t := acme.TranscriptFor(cert); t != nil {
+		i.CA, i.Account, i.OrderURL = t.CA, t.Account, t.OrderURL
+		for _, a := range t.Authorizations {
+			v := store.Validation{Name: a.Identifier, Challenge: a.Challenge, Reused: a.Reused}
+			if !a.Validated.IsZero() {
+				validated := a.Validated
+				v.Validated = &validated
+			}
+			i.Validations = append(i.Validations, v)
+		}
+	}
+	if err := store.RecordIssuance(c.BaseDir, c.Lineage(), i); err != nil {
+		fmt.Printf("⚠️  %s: could not record the issuance in its history: %v\n", c.Lineage(), err)
+	}
+}
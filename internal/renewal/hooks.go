@@ -0,0 +1,69 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// hookEnv returns the environment passed to hooks. The variable names match
+// certbot's so existing deploy scripts can be reused.
+func hookEnv(c Config) []string {
+	lineage := filepath.Join(c.BaseDir, "live", store.LineageName(c.Lineage()))
+	certPath, keyPath, chainPath, fullchainPath := store.LoadCertPaths(c.BaseDir, c.Lineage())
+	return append(os.Environ(),
+		"RENEWED_DOMAINS="+c.Domain,
+		"RENEWED_LINEAGE="+lineage,
+		"TRUSTTLS_DOMAIN="+c.Domain,
+		"TRUSTTLS_CERT_NAME="+c.Lineage(),
+		"TRUSTTLS_CERT="+certPath,
+		"TRUSTTLS_KEY="+keyPath,
+		"TRUSTTLS_CHAIN="+chainPath,
+		"TRUSTTLS_FULLCHAIN="+fullchainPath,
+		"TRUSTTLS_DIR="+confdir.Of(c.BaseDir),
+	)
+}
+
+func runHook(kind, command string, c Config, verbose bool) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	if err := readonly.Check(kind + " hook for " + c.Lineage()); err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = hookEnv(c)
+	out, err := cmd.CombinedOutput()
+	if verbose && len(out) > 0 {
+		fmt.Printf("%s hook for %s:\n%s", kind, c.Lineage(), out)
+	}
+	if kind == "deploy" {
+		noteDeployment(c, hookDeployment(c), err)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook for %s failed: %w: %s", kind, c.Lineage(), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RunHooks writes the deploy copies and runs the deploy hook and then the
+// post hook configured for c against the certificate currently in the
+// store, without reissuing it.
+func RunHooks(c Config, verbose bool) error {
+	if err := store.VerifyCurrent(c.BaseDir, c.Lineage()); err != nil {
+		return err
+	}
+	if err := DeployCopies(c, verbose); err != nil {
+		return err
+	}
+	if err := runHook("deploy", c.DeployHook, c, verbose); err != nil {
+		return err
+	}
+	return runHook("post", c.PostHook, c, verbose)
+}
@@ -0,0 +1,663 @@
+package renewal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/approval"
+	"github.com/trustctl/trusttls/internal/ca"
+	"github.com/trustctl/trusttls/internal/confdir"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/remotewebroot"
+	"github.com/trustctl/trusttls/internal/export"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/keysink"
+	"github.com/trustctl/trusttls/internal/mtasts"
+	"github.com/trustctl/trusttls/internal/notify"
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/readonly"
+	"github.com/trustctl/trusttls/internal/plugins/haproxy"
+	"github.com/trustctl/trusttls/internal/replicate"
+	"github.com/trustctl/trusttls/internal/seal"
+	"github.com/trustctl/trusttls/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one finding of Lint. Errors will make the next renewal fail;
+// warnings point at settings that are probably not what was meant.
+type Problem struct {
+	File    string
+	Field   string
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// targetBinaries are the programs that show a web server target is
+// installed on this host.
+var targetBinaries = map[string][]string{
+	"apache": {"apache2", "apachectl", "httpd"},
+	"nginx":  {"nginx"},
+}
+
+// LintAll checks every renewal config plus the DNS credentials, accounts and
+// replication peers kept in baseDir, and returns the files checked and the problems found.
+func LintAll(baseDir string) ([]string, []Problem, error) {
+	var files []string
+	var problems []Problem
+	paths, err := filepath.Glob(confdir.Path(baseDir, filepath.Join("renewal", "*")))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range paths {
+		switch filepath.Ext(p) {
+		case ".yaml":
+			files = append(files, p)
+			problems = append(problems, LintFile(p)...)
+		case ".yml":
+			files = append(files, p)
+			problems = append(problems, Problem{File: p, Message: "ignored by renew: renewal configs must end in .yaml"})
+		}
+	}
+	creds, _ := filepath.Glob(filepath.Join(baseDir, "dns", "*.ini"))
+	for _, p := range creds {
+		files = append(files, p)
+		problems = append(problems, lintCredentials(p, strings.TrimSuffix(filepath.Base(p), ".ini"))...)
+	}
+	accounts, _ := filepath.Glob(filepath.Join(baseDir, "accounts", "*", "*", "credentials.json"))
+	for _, p := range accounts {
+		files = append(files, p)
+		problems = append(problems, lintAccount(p)...)
+	}
+	if p := replicate.ConfigPath(baseDir); osutil.FileExists(p) {
+		files = append(files, p)
+		if cfg, err := replicate.Load(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		} else {
+			for _, peer := range cfg.Peers {
+				if err := peer.Validate(); err != nil {
+					problems = append(problems, Problem{File: p, Message: err.Error()})
+				}
+			}
+		}
+	}
+	if p := notify.ConfigPath(baseDir); osutil.FileExists(p) {
+		files = append(files, p)
+		if cfg, err := notify.Load(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		} else {
+			if err := cfg.Validate(); err != nil {
+				problems = append(problems, Problem{File: p, Message: err.Error()})
+			}
+			for _, ch := range cfg.Channels {
+				if ch.Email != "" && !osutil.CommandExists("sendmail") {
+					problems = append(problems, Problem{File: p, Message: fmt.Sprintf("channel %s: sendmail not found on PATH", ch.Name)})
+				}
+			}
+		}
+	}
+	if p := approval.ConfigPath(baseDir); osutil.FileExists(p) {
+		files = append(files, p)
+		if cfg, err := approval.Load(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		} else {
+			if err := cfg.Validate(); err != nil {
+				problems = append(problems, Problem{File: p, Message: err.Error()})
+			}
+			if cfg.Template == "" {
+				problems = append(problems, Problem{File: p, Message: "no template: approved requests cannot be ordered"})
+			} else if !osutil.FileExists(confdir.Path(baseDir, filepath.Join("renewal", store.LineageName(cfg.Template)+".yaml"))) {
+				problems = append(problems, Problem{File: p, Message: fmt.Sprintf("template %s: no such renewal config", cfg.Template)})
+			}
+		}
+	}
+	if p := readonly.ConfigPath(baseDir); osutil.FileExists(p) {
+		files = append(files, p)
+		if _, err := readonly.Configured(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		}
+		if _, err := LoadRenewBeforeDays(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Field: "renew_before_days", Message: err.Error()})
+		}
+		if prefs, err := LoadChallengePreferences(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		} else {
+			var patterns []string
+			for pattern := range prefs {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			for _, pattern := range patterns {
+				list := prefs[pattern]
+				if pattern != "*" && (strings.Contains(strings.TrimPrefix(pattern, "*."), "*") || !strings.Contains(pattern, ".")) {
+					problems = append(problems, Problem{File: p, Field: "challenges", Message: fmt.Sprintf("%q: use a name, *.<domain> or *", pattern)})
+				}
+				if err := CheckChallenges(list); err != nil {
+					problems = append(problems, Problem{File: p, Field: "challenges", Message: fmt.Sprintf("%s: %v", pattern, err)})
+				}
+			}
+		}
+	}
+	if p := seal.ConfigPath(baseDir); osutil.FileExists(p) {
+		files = append(files, p)
+		if cfg, err := seal.Load(baseDir); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		} else if err := cfg.Validate(); err != nil {
+			problems = append(problems, Problem{File: p, Message: err.Error()})
+		}
+	}
+	sort.Strings(files)
+	return files, problems, nil
+}
+
+// LintFile parses the renewal config at path strictly, so misspelt or
+// misplaced keys are reported instead of silently ignored, then checks it
+// with Lint.
+func LintFile(path string) []Problem {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []Problem{{File: path, Message: err.Error()}}
+	}
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		lines := strings.Split(strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n"), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		return []Problem{{File: path, Message: "invalid YAML: " + strings.Join(lines, "; ")}}
+	}
+	if c.BaseDir == "" {
+		c.BaseDir = store.DefaultBaseDir()
+	}
+	problems := Lint(c)
+	if c.Domain != "" && filepath.Base(path) != store.LineageName(c.Lineage())+".yaml" {
+		problems = append(problems, Problem{Field: "domain", Message: fmt.Sprintf("file should be named %s.yaml; renew --domain %s will not find it", store.LineageName(c.Lineage()), c.Lineage()), Warning: true})
+	}
+	for i := range problems {
+		problems[i].File = path
+	}
+	return problems
+}
+
+// Lint checks that everything c refers to is in place: web roots, hooks,
+// DNS credentials, accounts, key sinks and web server targets.
+func Lint(c Config) []Problem {
+	var out []Problem
+	add := func(field, format string, args ...interface{}) {
+		out = append(out, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...interface{}) {
+		out = append(out, Problem{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+	}
+
+	if c.Domain == "" {
+		add("domain", "is required")
+	}
+	if len(c.Domains) > 0 && c.Domains[0] != c.Domain {
+		add("domains", "must start with %s", c.Domain)
+	}
+	if c.CertName != "" {
+		if err := store.ValidCertName(c.CertName); err != nil {
+			add("cert_name", "%v", err)
+		}
+	}
+	if !osutil.DirExists(c.BaseDir) {
+		add("base_dir", "%s does not exist", c.BaseDir)
+	} else if err := ownStore(c); err != nil {
+		add("base_dir", "is another store than %s, which keeps this config; renew refuses it", store.DefaultBaseDir())
+	} else if c.Domain != "" {
+		if cert, _, _, _ := store.LoadCertPaths(c.BaseDir, c.Lineage()); !osutil.FileExists(cert) {
+			warn("domain", "no certificate in the store yet; the next renew run will issue one")
+		} else if l, err := store.LoadLineage(c.BaseDir, c.Lineage()); err == nil {
+			lifetime := l.NotAfter.Sub(l.NotBefore)
+			if window, from := RenewWindow(c); window >= lifetime && (from == "renew_before_days" || from == "config.yaml") {
+				warn("renew_before_days", "%d days (from %s) is not shorter than the certificate's %d-day lifetime: every renew run will reissue it", int(window/(24*time.Hour)), from, int(lifetime.Round(24*time.Hour)/(24*time.Hour)))
+			}
+		}
+	}
+	if c.RenewBeforeDays < 0 {
+		add("renew_before_days", "must not be negative")
+	}
+	keyType := c.KeyType
+	if keyType == "" {
+		keyType = issuer.DefaultKeyType(IssuerName(c))
+	}
+	if err := acme.CheckKey(keyType, c.KeySize); err != nil {
+		field := "key_size"
+		if keyType != acme.KeyRSA && keyType != acme.KeyECDSA {
+			field = "key_type"
+		}
+		add(field, "%v", err)
+	}
+
+	switch IssuerName(c) {
+	case "", "letsencrypt":
+		out = append(out, lintACME(c)...)
+	case "step-ca":
+		if c.Server == "" {
+			add("server", "is required for step-ca: the ACME directory URL of its provisioner")
+		}
+		out = append(out, lintACME(c)...)
+	case "digicert-acme":
+		if _, err := issuer.DigiCertACMEAccount(c.BaseDir, c.Email); err != nil {
+			add("email", "no usable DigiCert ACME account for %q: %v", c.Email, err)
+		}
+		// Configs from before setup recorded a webroot answer on port 80
+		if c.Method != "digicert" {
+			out = append(out, lintACME(c)...)
+		}
+	case "digicert":
+		if _, err := issuer.DigiCertAccount(c.BaseDir, c.Email); err != nil {
+			add("email", "no usable DigiCert account for %q: %v", c.Email, err)
+		}
+		if c.DNSPlugin != "" {
+			creds, err := dnsprovider.LoadCredentials(c.DNSCredentials)
+			if err == nil {
+				_, err = dnsprovider.NewTXT(c.DNSPlugin, creds)
+			}
+			if err != nil {
+				add("dns_plugin", "%v", err)
+			}
+		} else if c.Webroot != "" && !osutil.DirExists(c.Webroot) {
+			add("webroot", "%s does not exist", c.Webroot)
+		}
+		if c.Validation != "" {
+			if _, ok := acme.DigiCertValidations[c.Validation]; !ok {
+				add("validation", "must be ov or ev, not %q", c.Validation)
+			}
+			if c.OrganizationID == "" {
+				add("organization_id", "is required for %s certificates", strings.ToUpper(c.Validation))
+			}
+		}
+		if c.Validation == "ev" && c.ContactID == "" {
+			add("contact_id", "is required for EV certificates")
+		}
+	case "internal":
+		if lt, err := time.ParseDuration(c.Lifetime); err != nil || lt <= 0 {
+			add("lifetime", "must be a duration such as 24h, not %q", c.Lifetime)
+		}
+		if c.CA != "" && c.CA != ca.EdgeCA && c.CA != ca.DevCA {
+			add("ca", "must be %s or %s, not %q", ca.EdgeCA, ca.DevCA, c.CA)
+		}
+	case "external":
+		warn("provider", "imported certificate: trusttls watches its expiry but cannot renew it; import its replacement with 'trusttls import cert'")
+	case "vault":
+		if !strings.Contains(c.Server, "/issue/") {
+			add("server", "must be the Vault PKI role's issue path, e.g. pki/issue/web, not %q", c.Server)
+		}
+		if c.Lifetime != "" {
+			if lt, err := time.ParseDuration(c.Lifetime); err != nil || lt <= 0 {
+				add("lifetime", "must be a duration such as 720h, not %q", c.Lifetime)
+			}
+		}
+		if !osutil.CommandExists("vault") {
+			warn("provider", "the vault CLI is not installed on this host")
+		}
+	default:
+		if _, ok := acme.LookupPreset(c.Provider); ok {
+			out = append(out, lintACME(c)...)
+		} else {
+			add("provider", "must be one of %s, not %q", strings.Join(issuer.Names(), ", "), c.Provider)
+		}
+	}
+
+	if c.KeySink != "" {
+		if _, err := keysink.Parse(c.KeySink); err != nil {
+			add("key_sink", "%v", err)
+		}
+	}
+	if c.ReuseKey {
+		switch {
+		case c.KeySink != "":
+			add("reuse_key", "cannot be used with key_sink: the key is not kept in the store")
+		case c.CSR != "":
+			add("reuse_key", "cannot be used with csr, which keeps the same key anyway")
+		case IssuerName(c) == "digicert":
+			add("reuse_key", "DigiCert CertCentral orders always generate a new key")
+		}
+	}
+	if c.MustStaple {
+		switch {
+		case c.CSR != "":
+			add("must_staple", "cannot be used with csr: the CSR decides its own extensions")
+		case IssuerName(c) == "vault":
+			add("must_staple", "Vault PKI roles cannot add the OCSP Must-Staple extension")
+		}
+	}
+	for _, t := range c.Targets {
+		bins, ok := targetBinaries[t]
+		if !ok {
+			add("targets", "unknown web server %q (apache or nginx)", t)
+			continue
+		}
+		found := false
+		for _, b := range bins {
+			found = found || osutil.CommandExists(b)
+		}
+		if !found {
+			add("targets", "%s is not installed on this host", t)
+		}
+	}
+	if msg := lintHook(c.DeployHook); msg != "" {
+		add("deploy_hook", "%s", msg)
+	}
+	if msg := lintHook(c.PostHook); msg != "" {
+		add("post_hook", "%s", msg)
+	}
+	if _, _, err := PlaceholderWindow(c); err != nil {
+		add("placeholder", "%s", strings.TrimPrefix(err.Error(), "placeholder "))
+	} else if c.Placeholder != "" && (c.KeySink != "" || c.CSR != "") {
+		add("placeholder", "cannot be used with key_sink or csr: the web server's key is not in the store")
+	}
+	if c.Soak != "" {
+		if d, err := time.ParseDuration(c.Soak); err != nil || d < 0 {
+			add("soak", "must be a duration such as 72h, not %q", c.Soak)
+		}
+	}
+	for _, r := range c.Resolvers {
+		if net.ParseIP(r) == nil {
+			if _, _, err := net.SplitHostPort(r); err != nil {
+				add("resolvers", "%q is not an IP address or host:port", r)
+			}
+		}
+	}
+	if h := c.HAProxy; h != nil {
+		if h.CertFile == "" {
+			add("haproxy.cert_file", "is required")
+		} else if !osutil.FileExists(h.CertFile) {
+			warn("haproxy.cert_file", "%s does not exist; HAProxy must already load it from a crt line", h.CertFile)
+		}
+		if h.Socket == "" {
+			add("haproxy.socket", "is required")
+		} else if err := haproxy.Ping(h.Socket); err != nil {
+			warn("haproxy.socket", "%v", err)
+		}
+		if c.KeySink != "" {
+			add("haproxy", "cannot be used with key_sink: HAProxy needs the private key")
+		}
+		if c.CSR != "" {
+			add("haproxy", "cannot be used with csr: HAProxy needs the private key")
+		}
+	}
+	if c.CertOwner != "" {
+		if _, err := lookupOwner(c.CertOwner); err != nil {
+			add("cert_owner", "%v", err)
+		}
+	}
+	if c.CertGroup != "" {
+		if _, err := lookupGroup(c.CertGroup); err != nil {
+			add("cert_group", "%v", err)
+		}
+	}
+	if c.KeyMode != "" {
+		if m, err := ParseKeyMode(c.KeyMode); err != nil {
+			add("key_mode", "%v", err)
+		} else if m&0004 != 0 {
+			warn("key_mode", "%s lets every user read the private key; give the web server's group access instead (cert_group, 0640)", c.KeyMode)
+		}
+	}
+	for i, e := range c.Exports {
+		field := fmt.Sprintf("exports[%d]", i)
+		if err := export.CheckFormat(e.Format); err != nil {
+			add(field+".format", "%v", err)
+		}
+		if e.Path == "" {
+			add(field+".path", "is required")
+		} else if !filepath.IsAbs(e.Path) {
+			add(field+".path", "must be an absolute path, not %s", e.Path)
+		} else if !osutil.DirExists(filepath.Dir(e.Path)) {
+			add(field+".path", "%s does not exist", filepath.Dir(e.Path))
+		}
+		switch {
+		case !export.NeedsPassword(e.Format):
+			if e.PasswordFile != "" {
+				warn(field+".password_file", "is ignored: %s exports are not encrypted", e.Format)
+			}
+		case e.PasswordFile == "":
+			add(field+".password_file", "is required")
+		default:
+			if _, err := ReadExportPassword(e.PasswordFile); err != nil {
+				add(field+".password_file", "%v", err)
+			}
+		}
+		if export.NeedsKey(e.Format) && (c.KeySink != "" || c.CSR != "") {
+			add(field, "%s needs the private key, which key_sink or csr keeps out of the store", e.Format)
+		}
+	}
+	for i, d := range c.Deploy {
+		field := fmt.Sprintf("deploy[%d]", i)
+		for _, p := range checkCopy(d) {
+			add(field+"."+p.field, "%v", p.err)
+		}
+		if d.holdsKey() && (c.KeySink != "" || c.CSR != "") {
+			add(field+".src", "%s needs the private key, which key_sink or csr keeps out of the store", d.Src)
+		}
+		if filepath.IsAbs(d.Dst) && !osutil.DirExists(filepath.Dir(d.Dst)) {
+			add(field+".dst", "%s does not exist", filepath.Dir(d.Dst))
+		}
+		if msg := lintHook(d.Reload); msg != "" {
+			add(field+".reload", "%s", msg)
+		}
+	}
+	if m := c.MTASTS; m != nil {
+		if !osutil.DirExists(m.Webroot) {
+			add("mta_sts.webroot", "%s does not exist", m.Webroot)
+		}
+		switch m.Mode {
+		case mtasts.ModeEnforce, mtasts.ModeTesting, mtasts.ModeNone:
+		default:
+			add("mta_sts.mode", "must be enforce, testing or none, not %q", m.Mode)
+		}
+	}
+	return out
+}
+
+func lintACME(c Config) []Problem {
+	var out []Problem
+	add := func(field, format string, args ...interface{}) {
+		out = append(out, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...interface{}) {
+		out = append(out, Problem{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+	}
+	if c.Email == "" {
+		add("email", "is required")
+	}
+	if c.Server != "" {
+		if u, err := url.Parse(c.Server); err != nil || u.Scheme != "https" || u.Host == "" {
+			add("server", "%q is not an https:// directory URL", c.Server)
+		}
+	}
+	if c.CABundle != "" {
+		if _, err := acme.LoadCABundle(c.CABundle); err != nil {
+			add("ca_bundle", "%v", err)
+		}
+	}
+	if c.CSR != "" {
+		if csr, err := acme.LoadCSR(c.CSR); err != nil {
+			add("csr", "%v", err)
+		} else if !acme.SameNames(acme.CSRNames(csr), c.Names()) {
+			add("csr", "asks for %s, not %s", strings.Join(acme.CSRNames(csr), ", "), strings.Join(c.Names(), ", "))
+		}
+		if c.KeySink != "" {
+			add("key_sink", "cannot be used with csr: the key is not trusttls's to deliver")
+		}
+	}
+	switch c.Method {
+	case "http-01":
+		if c.Standalone != "" {
+			if _, _, err := net.SplitHostPort(c.Standalone); err != nil {
+				add("standalone", "must be a listen address such as :80, not %q", c.Standalone)
+			}
+		} else if c.RemoteWebroot != "" {
+			u, err := url.Parse(c.RemoteWebroot)
+			if err != nil || (u.Scheme != "ftp" && u.Scheme != "ftps" && u.Scheme != "sftp") {
+				add("remote_webroot", "must be an ftp://, ftps:// or sftp:// URL")
+			} else if remotewebroot.Password(c.RemoteWebroot) != "" {
+				warn("remote_webroot", "holds a password in plain text; move it to remote_password_file or %s", remotewebroot.PasswordEnv)
+			}
+			if c.RemotePasswordFile != "" && !osutil.FileExists(c.RemotePasswordFile) {
+				add("remote_password_file", "%s does not exist", c.RemotePasswordFile)
+			}
+		} else if c.Webroot == "" {
+			add("webroot", "is required for http-01")
+		} else if !osutil.DirExists(c.Webroot) {
+			add("webroot", "%s does not exist", c.Webroot)
+		}
+	case "dns-01":
+		if !knownDNSProvider(c.DNSPlugin) {
+			add("dns_plugin", "unknown DNS provider %q (available: %s)", c.DNSPlugin, strings.Join(dnsprovider.Names(), ", "))
+			break
+		}
+		if c.DNSCredentials == "" {
+			if _, err := dnsprovider.New(c.DNSPlugin, nil); err != nil {
+				add("dns_credentials", "%v", err)
+			}
+			break
+		}
+		for _, p := range lintCredentials(c.DNSCredentials, c.DNSPlugin) {
+			p.Field = "dns_credentials"
+			out = append(out, p)
+		}
+	default:
+		add("method", "must be http-01 or dns-01, not %q", c.Method)
+	}
+	if err := CheckChallenges(c.Challenges); err != nil {
+		add("challenges", "%v", err)
+	} else {
+		for _, ch := range c.Challenges {
+			if _, ok := WithChallenge(c, ch); !ok && ch == ChallengeDNS {
+				add("challenges", "lists dns-01, but no dns_plugin is set")
+			} else if !ok {
+				add("challenges", "lists http-01, but no webroot, remote_webroot or standalone is set")
+			}
+		}
+	}
+	if c.Method == "http-01" || c.Method == "dns-01" {
+		provider := c.Provider
+		if provider == "digicert-acme" {
+			provider = "digicert"
+		}
+		caps := acme.CapabilitiesFor(provider, c.Server)
+		if err := caps.Check(c.Names(), c.Method); err != nil {
+			add("domains", "%v", err)
+		}
+		if err := caps.CheckKey(c.KeyType, c.KeySize); err != nil && acme.CheckKey(c.KeyType, c.KeySize) == nil {
+			add("key_size", "%v", err)
+		}
+	}
+	if c.PropagationTimeout != "" {
+		if d, err := time.ParseDuration(c.PropagationTimeout); err != nil || d <= 0 {
+			add("propagation_timeout", "must be a duration such as 10m, not %q", c.PropagationTimeout)
+		}
+	}
+	switch c.PropagationCheck {
+	case "", "authoritative", "all":
+	default:
+		add("propagation_check", "must be authoritative or all, not %q", c.PropagationCheck)
+	}
+	return out
+}
+
+func knownDNSProvider(name string) bool {
+	for _, n := range dnsprovider.Names() {
+		if n == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintCredentials checks that the credentials file at path is private and
+// gives the named provider what it needs.
+func lintCredentials(path, provider string) []Problem {
+	st, err := os.Stat(path)
+	if err != nil {
+		return []Problem{{File: path, Message: err.Error()}}
+	}
+	var out []Problem
+	if st.Mode().Perm()&0077 != 0 {
+		out = append(out, Problem{File: path, Message: fmt.Sprintf("readable by other users (mode %04o); chmod 600 it", st.Mode().Perm()), Warning: true})
+	}
+	creds, err := dnsprovider.LoadCredentials(path)
+	if err != nil {
+		return append(out, Problem{File: path, Message: err.Error()})
+	}
+	if _, err := dnsprovider.New(provider, creds); err != nil {
+		out = append(out, Problem{File: path, Message: err.Error()})
+	}
+	return out
+}
+
+func lintAccount(path string) []Problem {
+	b, err := seal.ReadFile(path)
+	if err != nil {
+		return []Problem{{File: path, Message: err.Error()}}
+	}
+	var creds store.AccountCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return []Problem{{File: path, Message: "invalid JSON: " + err.Error()}}
+	}
+	if creds.OrderTimeout != "" {
+		if _, err := time.ParseDuration(creds.OrderTimeout); err != nil {
+			return []Problem{{File: path, Field: "order_timeout", Message: fmt.Sprintf("must be a duration such as 72h, not %q", creds.OrderTimeout)}}
+		}
+	}
+	return nil
+}
+
+// lintHook checks that the program a hook starts with can be found. Hooks are
+// shell commands, so anything that is not a plain program (builtins,
+// variable assignments, subshells) is accepted as is.
+func lintHook(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	prog := fields[0]
+	if strings.ContainsAny(prog, "=$(){}`;|&<>") {
+		return ""
+	}
+	if strings.Contains(prog, "/") {
+		st, err := os.Stat(prog)
+		if err != nil {
+			return fmt.Sprintf("%s does not exist", prog)
+		}
+		if st.Mode()&0111 == 0 {
+			return fmt.Sprintf("%s is not executable", prog)
+		}
+		return ""
+	}
+	if _, err := exec.LookPath(prog); err != nil && !shellBuiltin(prog) {
+		return fmt.Sprintf("%s not found in PATH", prog)
+	}
+	return ""
+}
+
+func shellBuiltin(name string) bool {
+	switch name {
+	case "cd", "echo", "exit", "export", "set", "test", "[", "true", "false", "exec", ".", ":", "command", "if", "for", "while":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package renewal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/mtasts"
+	"github.com/trustctl/trusttls/internal/resolver"
+)
+
+// MTASTSConfig keeps an MTA-STS policy published next to the certificate for
+// mta-sts.<domain>.
+type MTASTSConfig struct {
+	Domain   string   `yaml:"domain"`       // mail domain the policy covers
+	Webroot  string   `yaml:"webroot"`      // web root serving mta-sts.<domain>
+	Mode     string   `yaml:"mode"`         // enforce|testing|none
+	MX       []string `yaml:"mx,omitempty"` // empty: follow the domain's MX records
+	MaxAge   int      `yaml:"max_age"`
+	PolicyID string   `yaml:"policy_id"`
+}
+
+// Policy returns the policy for m, looking up the domain's MX records when
+// none are pinned.
+func (m MTASTSConfig) Policy(r *resolver.Resolver) (mtasts.Policy, error) {
+	p := mtasts.Policy{Mode: m.Mode, MX: m.MX, MaxAge: m.MaxAge}
+	if len(p.MX) == 0 && p.Mode != mtasts.ModeNone {
+		mx, err := mtasts.LookupMX(context.Background(), r, m.Domain)
+		if err != nil {
+			return p, err
+		}
+		p.MX = mx
+	}
+	return p, p.Validate()
+}
+
+// PublishMTASTS writes the policy for c.MTASTS into its web root. When the
+// policy changed, or has never been published, it gets a new id. The
+// returned config carries the id to save.
+func PublishMTASTS(c Config) (Config, bool, error) {
+	m := *c.MTASTS
+	r, err := publicResolver(c)
+	if err != nil {
+		return c, false, err
+	}
+	p, err := m.Policy(r)
+	if err != nil {
+		return c, false, err
+	}
+	changed, err := mtasts.WritePolicy(m.Webroot, p)
+	if err != nil {
+		return c, false, fmt.Errorf("write MTA-STS policy: %w", err)
+	}
+	if changed || m.PolicyID == "" {
+		m.PolicyID = mtasts.NewID(time.Now())
+		changed = true
+	}
+	c.MTASTS = &m
+	return c, changed, nil
+}
+
+// CheckMTASTSRecord compares the published _mta-sts TXT record with the id in
+// c and returns a hint when they differ.
+func CheckMTASTSRecord(c Config) string {
+	m := c.MTASTS
+	r, err := publicResolver(c)
+	if err != nil {
+		return ""
+	}
+	published, err := mtasts.PublishedID(context.Background(), r, m.Domain)
+	if err != nil || published == m.PolicyID {
+		return ""
+	}
+	want := fmt.Sprintf("%s TXT %q", mtasts.TXTName(m.Domain), mtasts.TXTValue(m.PolicyID))
+	if published == "" {
+		return "MTA-STS is not announced yet; publish: " + want
+	}
+	return fmt.Sprintf("MTA-STS policy changed (published id %s); update: %s", published, want)
+}
+
+func publicResolver(c Config) (*resolver.Resolver, error) {
+	if len(c.Resolvers) > 0 {
+		return resolver.New(c.Resolvers)
+	}
+	return resolver.New(resolver.Public)
+}
+
+// refreshMTASTS runs after a successful renewal so the policy keeps tracking
+// the domain's MX records. A failure leaves the previous policy in place.
+func refreshMTASTS(c Config, verbose bool) {
+	updated, changed, err := PublishMTASTS(c)
+	if err != nil {
+		fmt.Printf("⚠️  MTA-STS policy for %s not refreshed: %v\n", c.MTASTS.Domain, err)
+		return
+	}
+	if changed {
+		if err := Save(updated); err != nil {
+			fmt.Printf("⚠️  save MTA-STS policy id for %s: %v\n", c.MTASTS.Domain, err)
+		}
+		if verbose {
+			fmt.Printf("MTA-STS policy for %s updated (id %s)\n", c.MTASTS.Domain, updated.MTASTS.PolicyID)
+		}
+	}
+	if hint := CheckMTASTSRecord(updated); hint != "" {
+		fmt.Printf("⚠️  %s\n", hint)
+	}
+}
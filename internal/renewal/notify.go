@@ -0,0 +1,37 @@
+package renewal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/notify"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// recordOutcome reports a renewal of c to the notification channels and
+// notes a failure in the store's index. A channel that cannot be reached
+// does not fail the renewal.
+func recordOutcome(c Config, err error) {
+	e := notify.Event{Kind: notify.Renewed, Domain: c.Lineage()}
+	if err != nil {
+		e.Kind, e.Message = notify.Failed, err.Error()
+	}
+	if nerr := notify.Record(c.BaseDir, e); nerr != nil {
+		fmt.Printf("⚠️  notification for %s not sent: %v\n", c.Lineage(), nerr)
+	}
+	if err != nil {
+		// Successes are noted when the certificate is stored
+		store.NoteRenewal(c.BaseDir, c.Lineage(), IssuerName(c), err)
+	}
+}
+
+// sendDigest sends the notification digest at the end of a renewal run
+// once its period has passed.
+func sendDigest(verbose bool) {
+	sent, err := notify.SendDigest(store.DefaultBaseDir(), time.Now(), false)
+	if err != nil {
+		fmt.Printf("⚠️  notification digest not sent: %v\n", err)
+	} else if sent && verbose {
+		fmt.Println("sent the notification digest")
+	}
+}
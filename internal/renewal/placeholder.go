@@ -0,0 +1,258 @@
+package renewal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/notify"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// PlaceholderExpired is the placeholder setting that waits until the
+// certificate has expired before switching.
+const PlaceholderExpired = "expired"
+
+// placeholderLifetime is how long placeholder certificates are valid. They
+// are untrusted anyway; this just keeps them from expiring while in use.
+const placeholderLifetime = 365 * 24 * time.Hour
+
+// Placeholder records that the web server configs of a certificate point at
+// a self-signed placeholder, because renewing it kept failing while it ran
+// out.
+type Placeholder struct {
+	Domain string    `json:"domain"`
+	Since  time.Time `json:"since"`
+	Expiry time.Time `json:"expiry"` // of the certificate the placeholder stands in for
+	Reason string    `json:"reason"` // the renewal error that led to the switch
+}
+
+func placeholderPath(baseDir, domain string) string {
+	return filepath.Join(baseDir, "placeholder", store.LineageName(domain)+".json")
+}
+
+// PlaceholderWindow returns how long before expiry a certificate of c that
+// fails to renew is replaced by a placeholder, and whether c asks for that
+// at all.
+func PlaceholderWindow(c Config) (time.Duration, bool, error) {
+	switch c.Placeholder {
+	case "":
+		return 0, false, nil
+	case PlaceholderExpired:
+		return 0, true, nil
+	}
+	d, err := time.ParseDuration(c.Placeholder)
+	if err != nil || d < 0 {
+		return 0, false, fmt.Errorf("placeholder must be %q or a duration before expiry such as 24h, not %q", PlaceholderExpired, c.Placeholder)
+	}
+	return d, true, nil
+}
+
+// LoadPlaceholder returns the placeholder state of domain, or nil when its
+// certificate is served as usual.
+func LoadPlaceholder(baseDir, domain string) (*Placeholder, error) {
+	b, err := os.ReadFile(placeholderPath(baseDir, domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Placeholder
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", placeholderPath(baseDir, domain), err)
+	}
+	return &p, nil
+}
+
+func savePlaceholder(baseDir string, p *Placeholder) error {
+	path := placeholderPath(baseDir, p.Domain)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(path, b, 0600)
+}
+
+// ListPlaceholders returns every certificate served by a placeholder, sorted
+// by domain.
+func ListPlaceholders(baseDir string) ([]*Placeholder, error) {
+	entries, err := os.ReadDir(filepath.Join(baseDir, "placeholder"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []*Placeholder
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		p, err := LoadPlaceholder(baseDir, store.LineageDomain(strings.TrimSuffix(e.Name(), ".json")))
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out, nil
+}
+
+// applyPlaceholder switches the web server configs of c to a self-signed
+// placeholder when renewal failed with renewErr and the certificate expires
+// within c's placeholder window, and alerts every notification channel.
+// Visitors then get the browser's certificate warning, which they can click
+// through, instead of a site that breaks outright.
+func applyPlaceholder(c Config, renewErr error) {
+	window, on, err := PlaceholderWindow(c)
+	if !on || err != nil {
+		return
+	}
+	name := c.Lineage()
+	if p, err := LoadPlaceholder(c.BaseDir, name); err != nil || p != nil {
+		if p != nil {
+			fmt.Printf("🚨 %s is still served by a self-signed placeholder since %s\n", name, p.Since.Format(time.RFC1123))
+		}
+		return
+	}
+	if c.KeySink != "" || c.CSR != "" {
+		fmt.Printf("⚠️  %s: no placeholder, the web server's key is not kept in the store\n", name)
+		return
+	}
+	if r, err := LoadRollover(c.BaseDir, name); err == nil && r != nil && r.Reverted {
+		fmt.Printf("⚠️  %s: no placeholder while the previous certificate is in use; run 'trusttls rollover resume --domain %s' first\n", name, name)
+		return
+	}
+	certPath, _, _, _ := store.LoadCertPaths(c.BaseDir, name)
+	b, err := os.ReadFile(certPath)
+	if err != nil {
+		return
+	}
+	expiry, err := store.ParseCertExpiry(b)
+	if err != nil || time.Until(expiry) > window {
+		return
+	}
+	certPEM, keyPEM, err := placeholderCertificate(c.Names())
+	if err == nil {
+		err = store.SavePlaceholder(c.BaseDir, name, certPEM, keyPEM)
+	}
+	if err == nil {
+		err = ApplyAccess(c)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  %s: could not make a placeholder certificate: %v\n", name, err)
+		return
+	}
+	dir := store.PlaceholderDir(c.BaseDir, name)
+	if !SwitchCertificatePaths(filepath.Dir(dir), dir) {
+		fmt.Printf("⚠️  No Apache or Nginx config uses %s; point other services at %s\n", filepath.Dir(dir), dir)
+	}
+	p := &Placeholder{Domain: name, Since: time.Now().UTC(), Expiry: expiry, Reason: renewErr.Error()}
+	if err := savePlaceholder(c.BaseDir, p); err != nil {
+		fmt.Printf("⚠️  could not record the placeholder of %s: %v\n", name, err)
+	}
+	when := "expires " + expiry.Format(time.RFC1123)
+	if time.Now().After(expiry) {
+		when = "expired " + expiry.Format(time.RFC1123)
+	}
+	fmt.Printf("🚨 %s %s and could not be renewed: serving a self-signed placeholder until renewal succeeds\n", name, when)
+	subject := fmt.Sprintf("trusttls: URGENT: %s now serves a self-signed placeholder", name)
+	body := fmt.Sprintf("The certificate for %s %s and renewing it failed:\n\n%s\n\n"+
+		"Web servers now serve a self-signed placeholder from %s, so visitors get a certificate warning. "+
+		"Fix the renewal, then run:\n\n  trusttls renew --cert-name %s\n\n"+
+		"The new certificate is switched back in as soon as it has been issued.\n", name, when, renewErr, dir, name)
+	if err := notify.Alert(c.BaseDir, subject, body); err != nil {
+		fmt.Printf("⚠️  placeholder alert for %s not sent: %v\n", name, err)
+	}
+}
+
+// restoreCertificate points the web server configs of c back at live/ after
+// a renewal that succeeded while a placeholder was served.
+func restoreCertificate(c Config) {
+	name := c.Lineage()
+	p, err := LoadPlaceholder(c.BaseDir, name)
+	if err != nil || p == nil {
+		return
+	}
+	dir := store.PlaceholderDir(c.BaseDir, name)
+	SwitchCertificatePaths(dir, filepath.Dir(dir))
+	if err := store.DropPlaceholder(c.BaseDir, name); err != nil {
+		fmt.Printf("⚠️  could not remove the placeholder of %s: %v\n", name, err)
+	}
+	if err := os.Remove(placeholderPath(c.BaseDir, name)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("⚠️  could not clear the placeholder state of %s: %v\n", name, err)
+	}
+	fmt.Printf("✅ %s renewed: the placeholder served since %s is replaced by the new certificate\n", name, p.Since.Format(time.RFC1123))
+	subject := fmt.Sprintf("trusttls: %s serves a valid certificate again", name)
+	body := fmt.Sprintf("%s was renewed and web servers use the new certificate again, instead of the placeholder served since %s.\n", name, p.Since.Format(time.RFC3339))
+	if err := notify.Alert(c.BaseDir, subject, body); err != nil {
+		fmt.Printf("⚠️  notification for %s not sent: %v\n", name, err)
+	}
+}
+
+// placeholderCertificate returns a self-signed certificate for names and its
+// key, both PEM encoded. Its organization says why it is there, for anyone
+// looking at the certificate behind the browser's warning.
+func placeholderCertificate(names []string) ([]byte, []byte, error) {
+	key, err := acme.GenerateKey(acme.KeyECDSA, 256)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	cn := names[0]
+	if len(cn) > 64 {
+		cn = ""
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         cn,
+			Organization:       []string{"TrustTLS placeholder"},
+			OrganizationalUnit: []string{"Certificate renewal failed; contact the site administrator"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(placeholderLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	signer := key.(crypto.Signer)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := acme.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
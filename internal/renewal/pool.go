@@ -0,0 +1,135 @@
+package renewal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// poolLimits bounds how hard one provider is driven during a renewal run.
+type poolLimits struct {
+	Workers  int           // concurrent orders
+	Interval time.Duration // minimum gap between starting two orders
+}
+
+// Each provider gets its own pool so slow DigiCert polling cannot hold up
+// Let's Encrypt renewals, and vice versa.
+var providerLimits = map[string]poolLimits{
+	"letsencrypt": {Workers: 2, Interval: 2 * time.Second},
+	"digicert":    {Workers: 1, Interval: 10 * time.Second},
+	"internal":    {Workers: 4},
+}
+
+var fallbackLimits = poolLimits{Workers: 1, Interval: 5 * time.Second}
+
+// Workers caps how many orders run at once across all pools; 0 leaves only
+// the pools' own caps. Large fleets raise CAWorkers and use this to bound
+// the load on the host.
+var Workers int
+
+// CAWorkers overrides the concurrent orders of a provider's pool, by
+// provider name (letsencrypt, digicert, internal, ...).
+var CAWorkers map[string]int
+
+// limitsFor returns the pool limits of the provider key, with CAWorkers
+// applied.
+func limitsFor(key string) poolLimits {
+	limits, ok := providerLimits[key]
+	if !ok {
+		limits = fallbackLimits
+	}
+	if n := CAWorkers[key]; n > 0 {
+		limits.Workers = n
+	}
+	return limits
+}
+
+func providerKey(c Config) string {
+	if c.Provider == "" {
+		return "letsencrypt"
+	}
+	return c.Provider
+}
+
+// limiter spaces out order starts within one pool.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *limiter) wait() {
+	if l.interval <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// runPools renews cfgs with one worker pool per provider, all pools running
+// side by side with at most Workers orders in total, and returns the
+// failures.
+func runPools(cfgs []Config, verbose bool) []string {
+	groups := map[string][]Config{}
+	for _, c := range cfgs {
+		k := providerKey(c)
+		groups[k] = append(groups[k], c)
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []string
+		wg   sync.WaitGroup
+		// slots holds a token per order running when Workers caps the total
+		slots chan struct{}
+	)
+	if Workers > 0 {
+		slots = make(chan struct{}, Workers)
+	}
+	for key, group := range groups {
+		limits := limitsFor(key)
+		if limits.Workers > len(group) {
+			limits.Workers = len(group)
+		}
+		lim := &limiter{interval: limits.Interval}
+		work := make(chan Config)
+		for i := 0; i < limits.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := range work {
+					if slots != nil {
+						slots <- struct{}{}
+					}
+					lim.wait()
+					err := Renew(c, verbose)
+					if slots != nil {
+						<-slots
+					}
+					if err != nil {
+						mu.Lock()
+						errs = append(errs, fmt.Sprintf("%s: %v", c.Lineage(), err))
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		go func(group []Config) {
+			for _, c := range group {
+				work <- c
+			}
+			close(work)
+		}(group)
+	}
+	wg.Wait()
+	sort.Strings(errs)
+	return errs
+}
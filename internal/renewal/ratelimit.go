@@ -0,0 +1,101 @@
+package renewal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// IgnoreRateLimits makes renewals order even when the issuance log says the
+// CA's rate limits would refuse them, and retry certificates the CA told to
+// wait before their time.
+var IgnoreRateLimits bool
+
+// Hold records that the CA rate limited the renewal of a certificate and
+// when it accepts another order, so renewal runs wait until then instead of
+// failing again and again.
+type Hold struct {
+	Domain string    `json:"domain"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+func holdPath(baseDir, domain string) string {
+	return filepath.Join(baseDir, "hold", store.LineageName(domain)+".json")
+}
+
+// LoadHold returns the rate limit hold on renewing domain, or nil when
+// there is none or it has passed.
+func LoadHold(baseDir, domain string) (*Hold, error) {
+	b, err := os.ReadFile(holdPath(baseDir, domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h Hold
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("%s: %w", holdPath(baseDir, domain), err)
+	}
+	if !time.Now().Before(h.Until) {
+		return nil, nil
+	}
+	return &h, nil
+}
+
+// holdRenewal puts renewals of c on hold until the CA accepts orders again
+// when renewErr is a rate limit that says when, and lifts the hold after a
+// renewal that succeeded.
+func holdRenewal(c Config, renewErr error) {
+	path := holdPath(c.BaseDir, c.Lineage())
+	if renewErr == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("⚠️  could not clear the rate limit hold of %s: %v\n", c.Lineage(), err)
+		}
+		return
+	}
+	until := acme.RetryAfter(renewErr)
+	if until.IsZero() || !until.After(time.Now()) {
+		return
+	}
+	h := Hold{Domain: c.Lineage(), Until: until.UTC(), Reason: renewErr.Error()}
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		err = store.WriteFileAtomic(path, b, 0600)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  could not record the rate limit hold of %s: %v\n", c.Lineage(), err)
+		return
+	}
+	fmt.Printf("⏳ %s: rate limited by the CA; renewal waits until %s\n", c.Lineage(), until.Local().Format(time.RFC1123))
+}
+
+// held returns the rate limit hold renewal of c waits for, or nil when
+// there is none or rate limits are ignored. It only looks.
+func held(c Config) *Hold {
+	if IgnoreRateLimits {
+		return nil
+	}
+	h, err := LoadHold(c.BaseDir, c.Lineage())
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+// skipHeld says that renewal of c is skipped until the hold h passes. A
+// certificate that runs into its placeholder window meanwhile is switched
+// to the placeholder as if the renewal had failed.
+func skipHeld(c Config, h *Hold) {
+	fmt.Printf("⏳ %s: waiting for the CA's rate limit until %s\n", c.Lineage(), h.Until.Local().Format(time.RFC1123))
+	applyPlaceholder(c, fmt.Errorf("rate limited until %s: %s", h.Until.Format(time.RFC3339), h.Reason))
+}
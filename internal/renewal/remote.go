@@ -0,0 +1,50 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/acme/remotewebroot"
+	"github.com/trustctl/trusttls/internal/seal"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// RemotePasswordFile returns where the password of c's remote webroot is
+// kept when it was given in the URL.
+func RemotePasswordFile(c Config) string {
+	return filepath.Join(c.BaseDir, "remote", store.LineageName(c.Lineage())+".password")
+}
+
+// SaveRemotePassword moves the password in c's remote webroot URL into the
+// store, sealed when encryption is on, so the renewal config only keeps the
+// URL without it. A URL without a password is left alone; its password
+// comes from TRUSTTLS_REMOTE_PASSWORD.
+func SaveRemotePassword(c *Config) error {
+	pw := remotewebroot.Password(c.RemoteWebroot)
+	if pw == "" {
+		return nil
+	}
+	p := RemotePasswordFile(*c)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := seal.WriteFile(p, []byte(pw), 0600); err != nil {
+		return err
+	}
+	c.RemoteWebroot, c.RemotePasswordFile = remotewebroot.Redacted(c.RemoteWebroot), p
+	return nil
+}
+
+// remoteWebroot returns the URL to upload c's challenge files to, with the
+// password kept in remote_password_file put back.
+func remoteWebroot(c Config) (string, error) {
+	if c.RemotePasswordFile == "" {
+		return c.RemoteWebroot, nil
+	}
+	pw, err := ReadExportPassword(c.RemotePasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("load remote webroot password: %w", err)
+	}
+	return remotewebroot.WithPassword(c.RemoteWebroot, pw), nil
+}
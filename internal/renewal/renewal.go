@@ -1,6 +1,8 @@
 package renewal
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -11,26 +13,84 @@ import (
 
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/standalone"
+	"github.com/trustctl/trusttls/internal/confdir"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/keysink"
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/readonly"
 	"github.com/trustctl/trusttls/internal/store"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Domain    string   `yaml:"domain"`
+	Domains   []string `yaml:"domains,omitempty"` // every name on the certificate, Domain first; empty means Domain only
+	CertName  string   `yaml:"cert_name,omitempty"` // lineage name under live/ when it is not Domain (--cert-name)
 	Email     string   `yaml:"email"`
 	Server    string   `yaml:"server"`
+	CABundle  string   `yaml:"ca_bundle,omitempty"` // extra trusted roots for a private ACME server
+	CSR       string   `yaml:"csr,omitempty"`       // submit this CSR instead of generating a key; the key never reaches the store
+	ReuseKey  bool     `yaml:"reuse_key,omitempty"` // renew with the key in privkey.pem instead of a new one (key pinning, DANE)
+	MustStaple bool    `yaml:"must_staple,omitempty"` // request the OCSP Must-Staple (TLS Feature) extension
 	Method    string   `yaml:"method"`   // http-01|dns-01|digicert
+	Challenges []string `yaml:"challenges,omitempty"` // challenge types to try in order, e.g. [dns-01, http-01]; empty means config.yaml's preference, then method
 	Webroot   string   `yaml:"webroot"`  // for http-01
+	RemoteWebroot string `yaml:"remote_webroot,omitempty"` // ftp://, ftps:// or sftp:// webroot for http-01, without its password
+	RemotePasswordFile string `yaml:"remote_password_file,omitempty"` // file holding remote_webroot's password; may be sealed. Without it TRUSTTLS_REMOTE_PASSWORD is used
+	Standalone string `yaml:"standalone,omitempty"` // listen address of the built-in http-01 server, e.g. ":80"
 	DNSPlugin string   `yaml:"dns_plugin"`
+	DNSCredentials string `yaml:"dns_credentials,omitempty"` // credentials file for dns_plugin
+	DNSWait   bool     `yaml:"dns_wait,omitempty"` // manual dns_plugin: wait until public resolvers see each TXT record
 	KeyType   string   `yaml:"key_type"`
 	KeySize   int      `yaml:"key_size"`
 	Targets   []string `yaml:"targets"` // apache|nginx
 	BaseDir   string   `yaml:"base_dir"`
-	Provider  string   `yaml:"provider"`  // letsencrypt|digicert
+	Provider  string   `yaml:"provider"`  // letsencrypt|digicert|internal
+	Lifetime  string   `yaml:"lifetime,omitempty"` // internal CA only, e.g. "24h"
+	RenewBeforeDays int `yaml:"renew_before_days,omitempty"` // renew this many days before expiry; 0 means config.yaml's, else 30
+	CA        string   `yaml:"ca,omitempty"`       // internal CA only: edge (default) or dev
+	KeySink   string   `yaml:"key_sink,omitempty"` // file:|k8s:|vault: destination; key is never kept in live/
+	CertOwner string   `yaml:"cert_owner,omitempty"` // user given the certificate files, e.g. www-data
+	CertGroup string   `yaml:"cert_group,omitempty"` // group given the certificate files, e.g. nginx
+	KeyMode   string   `yaml:"key_mode,omitempty"`   // octal mode of privkey.pem, e.g. "0640"; default 0600
+	DeployHook string  `yaml:"deploy_hook,omitempty"` // shell command run after each successful renewal
+	PostHook   string  `yaml:"post_hook,omitempty"`   // shell command run after every renewal attempt
+	Resolvers  []string `yaml:"resolvers,omitempty"`  // DNS resolvers for CAA and DNS-01 pre-checks
+	PropagationTimeout string `yaml:"propagation_timeout,omitempty"` // max wait for DNS-01 records, e.g. "10m"
+	PropagationCheck   string `yaml:"propagation_check,omitempty"`   // authoritative (default) | all
+	MTASTS     *MTASTSConfig `yaml:"mta_sts,omitempty"` // policy served from this mta-sts.<domain> certificate's host
+	Soak       string `yaml:"soak,omitempty"` // keep the previous certificate in live/<name>/previous/ until the new one has been served this long, e.g. "72h"
+	Placeholder string `yaml:"placeholder,omitempty"` // when renewal fails this close to expiry ("48h", or "expired"), serve a self-signed placeholder until it succeeds
+	HAProxy    *HAProxyConfig `yaml:"haproxy,omitempty"` // swap renewed certificates in over HAProxy's runtime API
+	Exports    []ExportConfig `yaml:"exports,omitempty"` // write the certificate in these formats after each renewal, e.g. p12
+	Deploy     []DeployCopy   `yaml:"deploy,omitempty"`  // copy the certificate's files to where daemons read them after each issuance and renewal
+	Validation     string `yaml:"validation,omitempty"`      // DigiCert only: ov|ev
+	OrganizationID string `yaml:"organization_id,omitempty"` // DigiCert only: organization named on OV/EV certificates
+	ContactID      string `yaml:"contact_id,omitempty"`      // DigiCert only: verified contact approving EV orders
+}
+
+// Lineage returns the name c's certificate is kept under in the store and
+// its renewal config is named after: the --cert-name it was issued with, or
+// the primary domain.
+func (c Config) Lineage() string {
+	if c.CertName != "" {
+		return c.CertName
+	}
+	return c.Domain
+}
+
+// Names returns every name the certificate for c covers, primary first.
+func (c Config) Names() []string {
+	if len(c.Domains) == 0 {
+		return []string{c.Domain}
+	}
+	return c.Domains
 }
 
 func dir() string {
-	return filepath.Join(store.DefaultBaseDir(), "renewal")
+	return confdir.Path(store.DefaultBaseDir(), "renewal")
 }
 
 func ensureDir() error {
@@ -38,16 +98,39 @@ func ensureDir() error {
 }
 
 func configPath(domain string) string {
-	return filepath.Join(dir(), domain+".yaml")
+	return filepath.Join(dir(), store.LineageName(domain)+".yaml")
 }
 
 func Save(cfg Config) error {
 	if cfg.Domain == "" { return errors.New("domain required") }
+	if err := readonly.Check("save renewal config"); err != nil { return err }
 	if cfg.BaseDir == "" { cfg.BaseDir = store.DefaultBaseDir() }
 	if err := ensureDir(); err != nil { return err }
 	b, err := yaml.Marshal(&cfg)
 	if err != nil { return err }
-	return os.WriteFile(configPath(cfg.Domain), b, 0600)
+	return store.WriteFileAtomic(configPath(cfg.Lineage()), b, 0600)
+}
+
+// ownStore refuses a config whose base_dir is not the store it is kept in,
+// such as one copied from another instance: renewing it would write into
+// that instance's store.
+func ownStore(c Config) error {
+	base := store.DefaultBaseDir()
+	if sameDir(c.BaseDir, base) {
+		return nil
+	}
+	return fmt.Errorf("the renewal config of %s is kept in %s but its base_dir is %s; move stores with 'trusttls migrate-store' or correct base_dir", c.Lineage(), base, c.BaseDir)
+}
+
+// sameDir reports whether a and b are the same directory, following links.
+func sameDir(a, b string) bool {
+	if ra, err := filepath.EvalSymlinks(a); err == nil {
+		a = ra
+	}
+	if rb, err := filepath.EvalSymlinks(b); err == nil {
+		b = rb
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
 }
 
 func load(path string) (Config, error) {
@@ -59,90 +142,447 @@ func load(path string) (Config, error) {
 	return c, nil
 }
 
-func due(domain string) bool {
-	certPath, _, _, _ := store.LoadCertPaths(store.DefaultBaseDir(), domain)
-	b, err := os.ReadFile(certPath)
-	if err != nil { return true }
-	exp, err := store.ParseCertExpiry(b)
+// Load reads the renewal configuration for domain.
+func Load(domain string) (Config, error) {
+	return load(configPath(domain))
+}
+
+// Find reads the renewal configuration of the certificate named name, or of
+// the only certificate whose primary domain is name, so certificates kept
+// under a --cert-name can be found by their domain too.
+func Find(name string) (Config, error) {
+	c, err := Load(name)
+	if err == nil || !os.IsNotExist(err) {
+		return c, err
+	}
+	entries, _ := os.ReadDir(dir())
+	var found []Config
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".yaml") {
+			continue
+		}
+		if other, lerr := load(filepath.Join(dir(), e.Name())); lerr == nil && strings.EqualFold(other.Domain, name) {
+			found = append(found, other)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return c, err
+	case 1:
+		return found[0], nil
+	}
+	var names []string
+	for _, f := range found {
+		names = append(names, f.Lineage())
+	}
+	return Config{}, fmt.Errorf("%s is the primary domain of several certificates (%s); name one of them", name, strings.Join(names, ", "))
+}
+
+// StoreCertificate saves cert into the store for c, with the intermediates
+// its chain lacks. When c.KeySink is set the private key is delivered to the
+// sink and left out of the local store.
+func StoreCertificate(c Config, cert *certificate.Resource) (string, error) {
+	CompleteChain(c, cert)
+	path, err := storeCertificate(c, cert)
+	if err == nil {
+		RecordIssuance(c, cert)
+		applyAccess(c)
+		store.NoteRenewal(c.BaseDir, c.Lineage(), IssuerName(c), nil)
+	}
+	return path, err
+}
+
+func storeCertificate(c Config, cert *certificate.Resource) (string, error) {
+	b := store.Bundle{Cert: cert.Certificate, Chain: cert.IssuerCertificate, OrderURL: acme.OrderURL(cert)}
+	if c.CSR != "" {
+		return store.SaveBundle(c.BaseDir, c.Lineage(), b)
+	}
+	if c.KeySink == "" {
+		b.Key = cert.PrivateKey
+		return store.SaveBundle(c.BaseDir, c.Lineage(), b)
+	}
+	sink, err := keysink.Parse(c.KeySink)
+	if err != nil { return "", err }
+	err = sink.Write(c.Lineage(), cert)
+	noteDeployment(c, Deployment{Kind: "key_sink", Target: c.KeySink, Detail: "private key"}, err)
+	if err != nil {
+		return "", fmt.Errorf("deliver private key to %s: %w", sink, err)
+	}
+	return store.SaveBundle(c.BaseDir, c.Lineage(), b)
+}
+
+// ForceRenewal makes every certificate due, whatever its expiry: after a
+// key compromise or a chain change, or to test deploy hooks end to end.
+var ForceRenewal bool
+
+func due(c Config) bool {
+	if ForceRenewal { return true }
+	l, err := store.LoadLineage(store.DefaultBaseDir(), c.Lineage())
 	if err != nil { return true }
-	return time.Until(exp) < 30*24*time.Hour
+	return !time.Now().Before(RenewAt(c, l.NotAfter))
 }
 
-func renewOne(c Config, verbose bool) error {
-	accountManager := store.NewAccountManager(c.BaseDir)
-	
-	switch c.Provider {
-	case "digicert":
-		digiCertConfig, err := accountManager.GetDigiCertConfig(c.Email)
-		if err != nil {
-			return fmt.Errorf("failed to load DigiCert credentials: %w", err)
+// RenewAt returns when a certificate for c expiring at expiry becomes due,
+// RenewWindow before expiry.
+func RenewAt(c Config, expiry time.Time) time.Time {
+	window, _ := RenewWindow(c)
+	return expiry.Add(-window)
+}
+
+// DigiCertOrderFile is where a submitted DigiCert order for c is kept until
+// it is issued.
+func DigiCertOrderFile(c Config) string {
+	return filepath.Join(c.BaseDir, "orders", "digicert", store.LineageName(c.Lineage())+".json")
+}
+
+// DigiCertConfig returns the CertCentral settings for ordering c: the
+// account of c.Email with c's validation level and domain control
+// validation settings.
+func DigiCertConfig(c Config) (*acme.DigiCertConfig, error) {
+	s, err := settings(c)
+	if err != nil {
+		return nil, err
+	}
+	req, err := request(c, false)
+	if err != nil {
+		return nil, err
+	}
+	return issuer.DigiCertConfig(s, req)
+}
+
+// IssuerName is the issuer c renews with. Configs written before providers
+// were recorded name DigiCert ACME only by their method.
+func IssuerName(c Config) string {
+	if c.Provider == "" && c.Method == "digicert" {
+		return "digicert-acme"
+	}
+	return c.Provider
+}
+
+// Issuer returns the issuer c renews with, set up from c.
+func Issuer(c Config) (issuer.Issuer, error) {
+	s, err := settings(c)
+	if err != nil {
+		return nil, err
+	}
+	return issuer.New(IssuerName(c), s)
+}
+
+// settings translates c into issuer settings.
+func settings(c Config) (issuer.Settings, error) {
+	s := issuer.Settings{
+		BaseDir:          c.BaseDir,
+		Email:            c.Email,
+		Server:           c.Server,
+		CABundle:         c.CABundle,
+		KeyType:          c.KeyType,
+		KeySize:          c.KeySize,
+		Resolvers:        c.Resolvers,
+		PropagationCheck: c.PropagationCheck,
+		CA:               c.CA,
+		Validation:       c.Validation,
+		OrganizationID:   c.OrganizationID,
+		ContactID:        c.ContactID,
+		StateFile:        DigiCertOrderFile(c),
+		MustStaple:       c.MustStaple,
+		IgnoreRateLimits: IgnoreRateLimits,
+	}
+	var err error
+	if c.PropagationTimeout != "" {
+		if s.PropagationTimeout, err = time.ParseDuration(c.PropagationTimeout); err != nil {
+			return s, fmt.Errorf("invalid propagation_timeout %q: %w", c.PropagationTimeout, err)
 		}
-		
-		providerInterface, err := acme.NewDigiCertProvider(*digiCertConfig)
-		if err != nil {
-			return fmt.Errorf("failed to create DigiCert provider: %w", err)
+	}
+	if c.Lifetime != "" {
+		if s.Lifetime, err = time.ParseDuration(c.Lifetime); err != nil {
+			return s, fmt.Errorf("invalid lifetime %q: %w", c.Lifetime, err)
+		}
+	}
+	if c.CSR != "" {
+		if s.CSR, err = acme.LoadCSR(c.CSR); err != nil {
+			return s, fmt.Errorf("load CSR: %w", err)
 		}
-		
-		provider, ok := providerInterface.(interface{ ObtainCertificate([]string) (*certificate.Resource, error) })
-		if !ok {
-			return fmt.Errorf("DigiCert provider interface not available")
+	}
+	return s, nil
+}
+
+// request returns what to order for c and how its names are validated.
+func request(c Config, verbose bool) (issuer.Request, error) {
+	req := issuer.Request{
+		Domains:       c.Names(),
+		Webroot:       c.Webroot,
+		RemoteWebroot: c.RemoteWebroot,
+		DNSPlugin:     c.DNSPlugin,
+	}
+	if c.RemoteWebroot != "" {
+		var err error
+		if req.RemoteWebroot, err = remoteWebroot(c); err != nil {
+			return req, err
 		}
-		
-		cert, err := provider.ObtainCertificate([]string{c.Domain})
+	}
+	if c.DNSPlugin != "" {
+		creds, err := dnsprovider.LoadCredentials(c.DNSCredentials)
 		if err != nil {
-			return err
+			return req, fmt.Errorf("load DNS credentials: %w", err)
 		}
-		if _, err := store.SaveCertificate(c.BaseDir, c.Domain, cert); err != nil {
-			return err
+		// Settings given on the command line rather than in the file
+		if c.DNSWait {
+			creds["poll"] = "true"
 		}
+		if len(c.Resolvers) > 0 && creds["resolvers"] == "" {
+			creds["resolvers"] = strings.Join(c.Resolvers, ",")
+		}
+		req.DNSCredentials = creds
+	}
+	if c.Standalone != "" {
+		req.Standalone = standalone.New(c.Standalone)
 		if verbose {
-			fmt.Printf("renewed %s via DigiCert\n", c.Domain)
-		}
-		
-	case "letsencrypt", "":
-		if c.Method != "http-01" {
-			return fmt.Errorf("unsupported method: %s", c.Method)
-		}
-		m, err := acme.NewManager(acme.Options{
-			Email:   c.Email,
-			Server:  c.Server,
-			KeyType: c.KeyType,
-			KeySize: c.KeySize,
-			BaseDir: c.BaseDir,
-		})
-		if err != nil {
-			return err
+			req.Standalone.OnRequest = func(r standalone.Request) { fmt.Printf("http-01 request for %s: %s\n", c.Domain, r) }
 		}
-		cert, err := m.ObtainHTTP01([]string{c.Domain}, c.Webroot)
-		if err != nil {
+	} else if IssuerName(c) == "digicert-acme" && c.Webroot == "" && c.DNSPlugin == "" && c.RemoteWebroot == "" {
+		// setup used to answer DigiCert's challenges on port 80 itself
+		req.Standalone = standalone.New(":80")
+	}
+	return req, nil
+}
+
+// usesWebroot reports whether c's names are validated through a local
+// webroot.
+func usesWebroot(c Config, req issuer.Request) bool {
+	return c.Method == "http-01" && req.DNSPlugin == "" && req.RemoteWebroot == "" && req.Standalone == nil
+}
+
+// ReusedKey returns the private key of c's current certificate and a CSR
+// for c's names signed with it, or nils before the first certificate.
+func ReusedKey(c Config) (*x509.CertificateRequest, []byte, error) {
+	b, err := store.Open(c.BaseDir).Load(c.Lineage())
+	if errors.Is(err, fs.ErrNotExist) || err == nil && len(b.Key) == 0 {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := acme.CSRForKey(b.Key, c.Names(), c.MustStaple)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reuse key of %s: %w", c.Lineage(), err)
+	}
+	return csr, b.Key, nil
+}
+
+// renewOne orders a new certificate for c, trying its challenge types in
+// turn, and hands it to keep.
+func renewOne(c Config, verbose bool, keep keepFunc) error {
+	s, err := settings(c)
+	if err != nil {
+		return err
+	}
+	var keyPEM []byte
+	if c.ReuseKey {
+		if err := store.VerifyCurrent(c.BaseDir, c.Lineage()); err != nil {
 			return err
 		}
-		if _, err := store.SaveCertificate(c.BaseDir, c.Domain, cert); err != nil {
+		if s.CSR, keyPEM, err = ReusedKey(c); err != nil {
 			return err
 		}
-		if verbose {
-			fmt.Printf("renewed %s via Let's Encrypt\n", c.Domain)
+	}
+	iss, err := issuer.New(IssuerName(c), s)
+	if err != nil {
+		return err
+	}
+	challenges, err := Challenges(c)
+	if err != nil {
+		return err
+	}
+	if len(challenges) == 1 {
+		if cc, ok := WithChallenge(c, challenges[0]); ok {
+			c = cc
 		}
-		
-	default:
-		return fmt.Errorf("unsupported provider: %s", c.Provider)
 	}
-	
+	if len(challenges) < 2 {
+		return renewWith(c, iss, keyPEM, verbose, keep)
+	}
+	var failed []string
+	for i, ch := range challenges {
+		cc, _ := WithChallenge(c, ch)
+		err = renewWith(cc, iss, keyPEM, verbose, keep)
+		if err == nil || !FallBack(err) {
+			break
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", ch, err))
+		if i+1 < len(challenges) {
+			fmt.Printf("⚠️  %s: %s validation failed, trying %s: %v\n", c.Lineage(), ch, challenges[i+1], err)
+		}
+	}
+	if err != nil && len(failed) > 1 {
+		return fmt.Errorf("%w (every challenge type failed: %s)", err, strings.Join(failed[:len(failed)-1], "; "))
+	}
+	return err
+}
+
+// keepFunc is what becomes of a certificate renewWith obtained for c.
+type keepFunc func(c Config, cert *certificate.Resource) error
+
+// storeRenewed stores a renewed certificate as the current version of its
+// lineage.
+func storeRenewed(c Config, cert *certificate.Resource) error {
+	_, err := StoreCertificate(c, cert)
+	return err
+}
+
+// renewWith renews c with iss, validating the way c says, and hands the
+// certificate to keep.
+func renewWith(c Config, iss issuer.Issuer, keyPEM []byte, verbose bool, keep keepFunc) error {
+	req, err := request(c, verbose)
+	if err != nil {
+		return err
+	}
+	local := usesWebroot(c, req)
+	if local && !osutil.DirExists(c.Webroot) {
+		return &WebrootError{Domain: c.Domain, Webroot: c.Webroot, Err: errors.New("directory no longer exists")}
+	}
+	cert, err := iss.Renew(context.Background(), req)
+	if err != nil {
+		if local && challengeUnreachable(err) {
+			return &WebrootError{Domain: c.Domain, Webroot: c.Webroot, Err: err}
+		}
+		return err
+	}
+	if keyPEM != nil {
+		cert.PrivateKey = keyPEM
+	}
+	if err := keep(c, cert); err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("renewed %s via %s (%s)\n", c.Lineage(), iss.Capabilities().CA, req.Method())
+	}
 	return nil
 }
 
-func RunAll(verbose bool) error {
-	if err := ensureDir(); err != nil { return err }
+// Renew reissues the certificate described by c regardless of its expiry,
+// keeps the previous one for the soak period, serves a placeholder when it
+// fails close to expiry and c asks for one, holds off later runs when the CA
+// rate limits it, copies it to replication peers, swaps it into HAProxy,
+// writes its exports, runs its deploy hook (on success) and post hook and
+// reports the outcome to the notification channels.
+func Renew(c Config, verbose bool) error {
+	if err := ownStore(c); err != nil {
+		return err
+	}
+	previous := 0
+	if c.Soak != "" {
+		previous, _ = store.CurrentVersion(c.BaseDir, c.Lineage())
+	}
+	err := renewOne(c, verbose, storeRenewed)
+	var werr *WebrootError
+	if errors.As(err, &werr) {
+		if fixed, ok := repairWebroot(c, verbose); ok {
+			c = fixed
+			err = renewOne(c, verbose, storeRenewed)
+		}
+	}
+	if err == nil {
+		// Only what was just stored, unaltered, is delivered
+		err = store.VerifyCurrent(c.BaseDir, c.Lineage())
+	}
+	if err == nil {
+		restoreCertificate(c)
+	} else {
+		applyPlaceholder(c, err)
+	}
+	holdRenewal(c, err)
+	if err == nil && previous > 0 {
+		startRollover(c, previous, verbose)
+	}
+	if err == nil && c.MTASTS != nil {
+		refreshMTASTS(c, verbose)
+	}
+	if err == nil {
+		replicateLineage(c, verbose)
+	}
+	if err == nil && c.HAProxy != nil {
+		if err = DeployHAProxy(c); err == nil && verbose {
+			fmt.Printf("loaded %s into HAProxy without a reload\n", c.HAProxy.CertFile)
+		}
+	}
+	if err == nil && len(c.Exports) > 0 {
+		err = exportAll(c, verbose)
+	}
+	if err == nil {
+		err = DeployCopies(c, verbose)
+	}
+	if err == nil {
+		err = runHook("deploy", c.DeployHook, c, verbose)
+	}
+	if herr := runHook("post", c.PostHook, c, verbose); herr != nil && err == nil {
+		err = herr
+	}
+	recordOutcome(c, err)
+	return err
+}
+
+// DueConfigs returns every renewal config whose certificate is due and not
+// on hold for a rate limit. It changes nothing.
+func DueConfigs() ([]Config, error) {
+	cfgs, _, err := scan()
+	var out []Config
+	for _, c := range cfgs {
+		if held(c) == nil {
+			out = append(out, c)
+		}
+	}
+	return out, err
+}
+
+// scan loads all renewal configs that are due, collecting unreadable files
+// as separate errors so one broken config does not stop the others.
+func scan() ([]Config, []string, error) {
+	return walk(due)
+}
+
+// Configs returns every renewal config, due or not, and the files that
+// could not be read as separate errors.
+func Configs() ([]Config, []string, error) {
+	return walk(func(Config) bool { return true })
+}
+
+// walk loads the renewal configs that want returns true for.
+func walk(want func(Config) bool) ([]Config, []string, error) {
+	if err := ensureDir(); err != nil { return nil, nil, err }
+	var out []Config
 	var errs []string
-	_ = filepath.WalkDir(dir(), func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(dir(), func(path string, d fs.DirEntry, err error) error {
 		if err != nil { return nil }
 		if d.IsDir() || !strings.HasSuffix(d.Name(), ".yaml") { return nil }
 		cfg, e := load(path)
 		if e != nil { errs = append(errs, fmt.Sprintf("%s: %v", d.Name(), e)); return nil }
-		if !due(cfg.Domain) { return nil }
-		if e := renewOne(cfg, verbose); e != nil { errs = append(errs, fmt.Sprintf("%s: %v", cfg.Domain, e)) }
+		if want(cfg) { out = append(out, cfg) }
 		return nil
 	})
+	return out, errs, err
+}
+
+func RunAll(verbose bool) error {
+	// Finish switching certificates a crash interrupted before looking at
+	// expiry dates
+	fixed, err := store.Repair(store.DefaultBaseDir())
+	if err != nil { return err }
+	for _, d := range fixed {
+		fmt.Printf("🔧 %s: certificate links pointed at different versions after an interrupted update; repaired\n", d)
+	}
+	cfgs, errs, err := scan()
+	if err != nil { return err }
+	var run []Config
+	for _, c := range cfgs {
+		if h := held(c); h != nil {
+			skipHeld(c, h)
+			continue
+		}
+		run = append(run, c)
+	}
+	errs = append(errs, runPools(run, verbose)...)
+	checkRollovers(verbose)
+	sendDigest(verbose)
 	if len(errs) > 0 { return fmt.Errorf("some renewals failed: %s", strings.Join(errs, "; ")) }
 	return nil
 }
@@ -0,0 +1,27 @@
+package renewal
+
+import (
+	"fmt"
+
+	"github.com/trustctl/trusttls/internal/replicate"
+)
+
+// replicateLineage pushes a renewed certificate to the peers configured in
+// replication.yaml. Failures are reported but do not fail the renewal: the
+// primary already has a valid certificate, and `trusttls replicate push`
+// can retry.
+func replicateLineage(c Config, verbose bool) {
+	results, err := replicate.Push(c.BaseDir, c.Lineage())
+	if err != nil {
+		fmt.Printf("⚠️  replication for %s skipped: %v\n", c.Lineage(), err)
+		return
+	}
+	for _, r := range results {
+		noteDeployment(c, Deployment{Kind: "replica", Target: r.Peer}, r.Err)
+		if r.Err != nil {
+			fmt.Printf("⚠️  %s not copied to %s: %v\n", c.Lineage(), r.Peer, r.Err)
+		} else if verbose {
+			fmt.Printf("copied %s to %s\n", c.Lineage(), r.Peer)
+		}
+	}
+}
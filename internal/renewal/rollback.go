@@ -0,0 +1,50 @@
+package renewal
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Rollback points live/ of c's lineage at the archived version n and
+// delivers it the way a renewal would: Apache and Nginx are reloaded when
+// their configs load the certificate, HAProxy gets it over its runtime API
+// and the deploy hook runs.
+func Rollback(c Config, n int, verbose bool) error {
+	r, err := LoadRollover(c.BaseDir, c.Lineage())
+	if err != nil {
+		return err
+	}
+	if r != nil && r.Reverted {
+		// The web servers use previous/, which live/ does not affect
+		return fmt.Errorf("%s is reverted to its previous certificate; run 'trusttls rollover resume --domain %s' first", c.Lineage(), c.Lineage())
+	}
+	if err := store.VerifyVersion(c.BaseDir, c.Lineage(), n); err != nil {
+		return err
+	}
+	if err := store.Activate(c.BaseDir, c.Lineage(), n); err != nil {
+		return err
+	}
+	lineageDir := filepath.Join(c.BaseDir, "live", store.LineageName(c.Lineage()))
+	if configs := referringConfigs(apache.ConfigDirs(), lineageDir); len(configs) > 0 {
+		if verbose {
+			fmt.Printf("reloading Apache for %v\n", configs)
+		}
+		apache.Reload()
+	}
+	if configs := referringConfigs(nginx.ConfigDirs(), lineageDir); len(configs) > 0 {
+		if verbose {
+			fmt.Printf("reloading Nginx for %v\n", configs)
+		}
+		nginx.Reload()
+	}
+	if c.HAProxy != nil {
+		if err := DeployHAProxy(c); err != nil {
+			return err
+		}
+	}
+	return runHook("deploy", c.DeployHook, c, verbose)
+}
@@ -0,0 +1,230 @@
+package renewal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Rollover tracks a renewed certificate whose predecessor is kept installed
+// under live/<name>/previous/ until the new one has been served for the
+// soak period of its renewal config.
+type Rollover struct {
+	Domain      string    `json:"domain"`
+	Previous    int       `json:"previous"` // archive version kept in previous/
+	Current     int       `json:"current"`
+	Started     time.Time `json:"started"`
+	ServedSince time.Time `json:"served_since,omitempty"` // zero until the new certificate is seen on port 443
+	Reverted    bool      `json:"reverted,omitempty"`     // web server configs point at previous/
+}
+
+func rolloverPath(baseDir, domain string) string {
+	return filepath.Join(baseDir, "rollover", store.LineageName(domain)+".json")
+}
+
+// LoadRollover returns the rollover state of domain, or nil when none is in
+// progress.
+func LoadRollover(baseDir, domain string) (*Rollover, error) {
+	b, err := os.ReadFile(rolloverPath(baseDir, domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Rollover
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", rolloverPath(baseDir, domain), err)
+	}
+	return &r, nil
+}
+
+// SaveRollover records r.
+func SaveRollover(baseDir string, r *Rollover) error {
+	p := rolloverPath(baseDir, r.Domain)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(p, b, 0600)
+}
+
+// FinishRollover drops the previous certificate links and the state of
+// domain's rollover.
+func FinishRollover(baseDir, domain string) error {
+	if err := store.DropPrevious(baseDir, domain); err != nil {
+		return err
+	}
+	if err := os.Remove(rolloverPath(baseDir, domain)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListRollovers returns every rollover in progress, sorted by domain.
+func ListRollovers(baseDir string) ([]*Rollover, error) {
+	entries, err := os.ReadDir(filepath.Join(baseDir, "rollover"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []*Rollover
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		r, err := LoadRollover(baseDir, store.LineageDomain(strings.TrimSuffix(e.Name(), ".json")))
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out, nil
+}
+
+// startRollover keeps version previous installed next to the certificate
+// just issued for c. A rollover that was reverted is left alone: the web
+// server still uses previous/, and replacing it would undo the revert.
+func startRollover(c Config, previous int, verbose bool) {
+	r, err := LoadRollover(c.BaseDir, c.Lineage())
+	if err != nil {
+		fmt.Printf("⚠️  rollover state for %s unreadable: %v\n", c.Lineage(), err)
+		return
+	}
+	if r != nil && r.Reverted {
+		fmt.Printf("⚠️  %s is still reverted to its previous certificate; run 'trusttls rollover resume --domain %s' to use the new one\n", c.Lineage(), c.Lineage())
+		return
+	}
+	current, err := store.CurrentVersion(c.BaseDir, c.Lineage())
+	if err != nil || current == previous {
+		return
+	}
+	if err := store.KeepPrevious(c.BaseDir, c.Lineage(), previous); err != nil {
+		fmt.Printf("⚠️  could not keep the previous certificate of %s: %v\n", c.Lineage(), err)
+		return
+	}
+	applyAccess(c)
+	r = &Rollover{Domain: c.Lineage(), Previous: previous, Current: current, Started: time.Now()}
+	if err := SaveRollover(c.BaseDir, r); err != nil {
+		fmt.Printf("⚠️  could not record rollover of %s: %v\n", c.Lineage(), err)
+		return
+	}
+	if verbose {
+		fmt.Printf("kept version %d of %s in %s for %s\n", previous, c.Lineage(), store.PreviousDir(c.BaseDir, c.Lineage()), c.Soak)
+	}
+}
+
+// CheckRollover compares the certificate served for c on port 443 with the
+// current one and prunes the previous certificate once the current one has
+// been served for the whole soak period. It reports whether it pruned.
+func CheckRollover(c Config, r *Rollover, verbose bool) (bool, error) {
+	if r.Reverted {
+		return false, nil
+	}
+	soak, err := time.ParseDuration(c.Soak)
+	if err != nil && c.Soak != "" {
+		return false, fmt.Errorf("invalid soak %q: %w", c.Soak, err)
+	}
+	host := ""
+	for _, n := range c.Names() {
+		if !strings.HasPrefix(n, "*.") {
+			host = n
+			break
+		}
+	}
+	if host == "" {
+		return false, fmt.Errorf("no name to connect to on a wildcard-only certificate; run 'trusttls rollover prune --domain %s' once it is served", c.Lineage())
+	}
+	lineage, err := store.LoadLineage(c.BaseDir, c.Lineage())
+	if err != nil {
+		return false, err
+	}
+	chain, err := ServedChain(host, "443", 10*time.Second)
+	if err != nil {
+		return false, fmt.Errorf("connect to %s: %w", host, err)
+	}
+	now := time.Now()
+	if fmt.Sprintf("%x", chain[0].SerialNumber) != lineage.Serial {
+		if !r.ServedSince.IsZero() {
+			r.ServedSince = time.Time{}
+			return false, SaveRollover(c.BaseDir, r)
+		}
+		if verbose {
+			fmt.Printf("%s does not serve the new certificate of %s yet\n", host, c.Lineage())
+		}
+		return false, nil
+	}
+	if r.ServedSince.IsZero() {
+		r.ServedSince = now
+		if err := SaveRollover(c.BaseDir, r); err != nil {
+			return false, err
+		}
+	}
+	if now.Sub(r.ServedSince) < soak {
+		if verbose {
+			fmt.Printf("%s served since %s; previous certificate kept until %s\n", c.Lineage(), r.ServedSince.Format(time.RFC3339), r.ServedSince.Add(soak).Format(time.RFC3339))
+		}
+		return false, nil
+	}
+	return true, FinishRollover(c.BaseDir, c.Lineage())
+}
+
+// checkRollovers runs CheckRollover for every rollover in progress. Problems
+// are reported but never fail the run: the current certificate is installed
+// either way.
+func checkRollovers(verbose bool) {
+	rollovers, err := ListRollovers(store.DefaultBaseDir())
+	if err != nil {
+		fmt.Printf("⚠️  rollover check skipped: %v\n", err)
+		return
+	}
+	for _, r := range rollovers {
+		c, err := Load(r.Domain)
+		if err != nil {
+			fmt.Printf("⚠️  rollover of %s: %v\n", r.Domain, err)
+			continue
+		}
+		pruned, err := CheckRollover(c, r, verbose)
+		if err != nil {
+			fmt.Printf("⚠️  rollover of %s: %v\n", r.Domain, err)
+		} else if pruned {
+			fmt.Printf("🧹 %s: new certificate served for %s, previous certificate removed\n", r.Domain, c.Soak)
+		}
+	}
+}
+
+// ServedChain returns the certificates the server presents for host, without
+// verifying them.
+func ServedChain(host, port string, timeout time.Duration) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true, // the caller verifies, so untrusted certificates can still be shown
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	return certs, nil
+}
@@ -0,0 +1,64 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/readonly"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRenewBeforeDays is how many days before expiry a certificate is
+// renewed when neither its renewal config nor config.yaml says otherwise.
+const DefaultRenewBeforeDays = 30
+
+// hostRenewal is the host-wide renewal window kept under
+// "renew_before_days" in config.yaml, next to read_only.
+type hostRenewal struct {
+	RenewBeforeDays int `yaml:"renew_before_days,omitempty"`
+}
+
+// LoadRenewBeforeDays returns the renewal window in days set in baseDir's
+// config.yaml, or 0 when it sets none. A missing file means none.
+func LoadRenewBeforeDays(baseDir string) (int, error) {
+	b, err := os.ReadFile(readonly.ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var h hostRenewal
+	if err := yaml.Unmarshal(b, &h); err != nil {
+		return 0, fmt.Errorf("%s: %w", readonly.ConfigPath(baseDir), err)
+	}
+	if h.RenewBeforeDays < 0 {
+		return 0, fmt.Errorf("%s: renew_before_days must not be negative", readonly.ConfigPath(baseDir))
+	}
+	return h.RenewBeforeDays, nil
+}
+
+// RenewWindow returns how long before expiry c's certificate is renewed,
+// and where that comes from: c's renew_before_days, else a third of the
+// lifetime of a short-lived internal certificate, else config.yaml's, else
+// DefaultRenewBeforeDays. The host-wide setting does not override the
+// lifetime rule: a window of days would reissue a certificate that lives
+// for hours on every run. A config.yaml that cannot be read counts as
+// setting nothing; lint reports it.
+func RenewWindow(c Config) (time.Duration, string) {
+	if c.RenewBeforeDays > 0 {
+		return days(c.RenewBeforeDays), "renew_before_days"
+	}
+	if lt, err := time.ParseDuration(c.Lifetime); err == nil && lt > 0 {
+		return lt / 3, "lifetime"
+	}
+	if n, err := LoadRenewBeforeDays(c.BaseDir); err == nil && n > 0 {
+		return days(n), "config.yaml"
+	}
+	return days(DefaultRenewBeforeDays), "default"
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,81 @@
+package renewal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/osutil"
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+)
+
+// ConfirmWebrootRepair decides whether a newly detected webroot may replace
+// the one in a renewal config after HTTP-01 validation failed. When nil (the
+// default) failing configs are left untouched and the error is returned.
+var ConfirmWebrootRepair func(domain, oldRoot, newRoot string) bool
+
+// WebrootError reports that HTTP-01 validation failed in a way that points at
+// the configured webroot: it no longer exists, or the CA could not fetch the
+// challenge file from it (typically because the vhost moved).
+type WebrootError struct {
+	Domain  string
+	Webroot string
+	Err     error
+}
+
+func (e *WebrootError) Error() string {
+	return fmt.Sprintf("webroot %s for %s: %v", e.Webroot, e.Domain, e.Err)
+}
+
+func (e *WebrootError) Unwrap() error { return e.Err }
+
+// DetectWebroot looks up the document root serving domain in the Apache and
+// Nginx vhosts, falling back to the default macOS web roots.
+func DetectWebroot(domain string) string {
+	if p := apache.DetectWebroot(domain); p != "" {
+		return p
+	}
+	if p := nginx.DetectWebroot(domain); p != "" {
+		return p
+	}
+	if osutil.IsMac() {
+		for _, p := range []string{"/Library/WebServer/Documents", "/usr/local/var/www"} {
+			if osutil.DirExists(p) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// challengeUnreachable reports whether an ACME error means the CA could not
+// read the challenge file, as opposed to e.g. rate limits or network errors.
+func challengeUnreachable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "urn:ietf:params:acme:error:unauthorized") ||
+		strings.Contains(msg, "Invalid response from")
+}
+
+// repairWebroot re-runs webroot detection for c and, when a different webroot
+// is found and ConfirmWebrootRepair approves it, saves the updated config.
+func repairWebroot(c Config, verbose bool) (Config, bool) {
+	if ConfirmWebrootRepair == nil {
+		return c, false
+	}
+	found := DetectWebroot(c.Domain)
+	if found == "" || found == c.Webroot {
+		return c, false
+	}
+	if !ConfirmWebrootRepair(c.Domain, c.Webroot, found) {
+		return c, false
+	}
+	old := c.Webroot
+	c.Webroot = found
+	if err := Save(c); err != nil {
+		return c, false
+	}
+	if verbose {
+		fmt.Printf("updated webroot for %s: %s -> %s\n", c.Domain, old, found)
+	}
+	return c, true
+}
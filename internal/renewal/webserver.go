@@ -0,0 +1,55 @@
+package renewal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// SwitchCertificatePaths points Apache and Nginx configs that use the files
+// in from at to instead, reloads the servers whose configs changed and
+// reports whether any did.
+func SwitchCertificatePaths(from, to string) bool {
+	var apacheChanged, nginxChanged bool
+	for _, dir := range apache.ConfigDirs() {
+		apacheChanged = rewriteConfigs(dir, from, to) || apacheChanged
+	}
+	for _, dir := range nginx.ConfigDirs() {
+		nginxChanged = rewriteConfigs(dir, from, to) || nginxChanged
+	}
+	if apacheChanged {
+		apache.Reload()
+	}
+	if nginxChanged {
+		nginx.Reload()
+	}
+	return apacheChanged || nginxChanged
+}
+
+// rewriteConfigs updates web server configs in dir that reference from and
+// reports whether any changed. Symlinks are skipped; the files they point to
+// are found in the sibling sites-available directory.
+func rewriteConfigs(dir, from, to string) bool {
+	entries, _ := os.ReadDir(dir)
+	changed := false
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		ok, err := store.RewriteFile(p, from, to)
+		if err != nil {
+			fmt.Printf("⚠️  Could not update %s: %v\n", p, err)
+			continue
+		}
+		if ok {
+			fmt.Printf("✏️  Updated paths in %s\n", p)
+			changed = true
+		}
+	}
+	return changed
+}
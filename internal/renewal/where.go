@@ -0,0 +1,254 @@
+package renewal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/plugins/apache"
+	"github.com/trustctl/trusttls/internal/plugins/nginx"
+	"github.com/trustctl/trusttls/internal/replicate"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Where a deployment was learned from.
+const (
+	SourceInstall = "install" // written by trusttls setup
+	SourceRenewal = "renewal" // delivered by issuance or renewal: HAProxy, key sink, replica, deploy hook
+	SourceHook    = "hook"    // reported by a hook or script through 'trusttls where record'
+	SourceConfig  = "config"  // configured, but nothing has been delivered there yet
+	SourceScan    = "scan"    // a web server config found referring to the certificate's files
+)
+
+// Deployment is one place a certificate is in use: what breaks when the
+// certificate is bad.
+type Deployment struct {
+	Kind     string    `json:"kind"`                // apache|nginx|haproxy|key_sink|replica|hook, or whatever a hook reports
+	Target   string    `json:"target"`              // config file, cert file, peer, key sink or command
+	Detail   string    `json:"detail,omitempty"`    // e.g. the HAProxy socket or the service to restart
+	Source   string    `json:"source"`              // install|renewal|hook|config|scan
+	LastSeen time.Time `json:"last_seen,omitempty"` // last successful delivery; zero when never recorded
+	Error    string    `json:"error,omitempty"`     // the latest delivery failed
+}
+
+func (d Deployment) key() string { return d.Kind + "\x00" + d.Target }
+
+func deploymentsPath(baseDir, domain string) string {
+	return filepath.Join(baseDir, "deployments", store.LineageName(domain)+".json")
+}
+
+// deploymentsMu serializes updates from renewal workers and hooks running
+// in this process.
+var deploymentsMu sync.Mutex
+
+// LoadDeployments returns the deployments recorded for domain.
+func LoadDeployments(baseDir, domain string) ([]Deployment, error) {
+	b, err := os.ReadFile(deploymentsPath(baseDir, domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Deployment
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", deploymentsPath(baseDir, domain), err)
+	}
+	return out, nil
+}
+
+func saveDeployments(baseDir, domain string, ds []Deployment) error {
+	p := deploymentsPath(baseDir, domain)
+	if len(ds) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(ds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(p, b, 0600)
+}
+
+// RecordDeployment notes that the certificate of domain was delivered to
+// d.Target, or failed to be when d.Error is set. An earlier record of the
+// same kind and target is replaced; a failure keeps the time of the last
+// success.
+func RecordDeployment(baseDir, domain string, d Deployment) error {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	ds, err := LoadDeployments(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	if d.Error == "" {
+		d.LastSeen = time.Now().UTC()
+	}
+	for i := range ds {
+		if ds[i].key() == d.key() {
+			if d.Error != "" {
+				d.LastSeen = ds[i].LastSeen
+			}
+			ds[i] = d
+			return saveDeployments(baseDir, domain, ds)
+		}
+	}
+	return saveDeployments(baseDir, domain, append(ds, d))
+}
+
+// ForgetDeployment drops the record of domain's certificate at target, of
+// any kind when kind is empty. It reports whether there was one.
+func ForgetDeployment(baseDir, domain, kind, target string) (bool, error) {
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	ds, err := LoadDeployments(baseDir, domain)
+	if err != nil {
+		return false, err
+	}
+	kept := ds[:0]
+	for _, d := range ds {
+		if d.Target != target || (kind != "" && d.Kind != kind) {
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) == len(ds) {
+		return false, nil
+	}
+	return true, saveDeployments(baseDir, domain, kept)
+}
+
+// noteDeployment records a delivery of c's certificate made during issuance
+// or renewal. Bookkeeping failures are reported but never fail the renewal.
+func noteDeployment(c Config, d Deployment, err error) {
+	d.Source = SourceRenewal
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if rerr := RecordDeployment(c.BaseDir, c.Lineage(), d); rerr != nil {
+		fmt.Printf("⚠️  deployment of %s to %s not recorded: %v\n", c.Lineage(), d.Target, rerr)
+	}
+}
+
+// Where returns every place the certificate of c is known to be used: the
+// deployments recorded by setup, renewals and hooks, the destinations its
+// renewal config and the replication peers name, and Apache or Nginx
+// configs referring to its files. Recorded entries come first, newest
+// first.
+func Where(c Config) ([]Deployment, error) {
+	out, err := LoadDeployments(c.BaseDir, c.Lineage())
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	seen := map[string]bool{}
+	for _, d := range out {
+		seen[d.key()] = true
+		seen[canonicalTarget(d.Target)] = true
+	}
+	add := func(d Deployment) {
+		if seen[d.key()] || seen[canonicalTarget(d.Target)] {
+			return
+		}
+		seen[d.key()], seen[canonicalTarget(d.Target)] = true, true
+		out = append(out, d)
+	}
+
+	for _, t := range c.Targets {
+		switch t {
+		case "apache":
+			add(Deployment{Kind: t, Target: apache.ConfigFile(c.Lineage()), Detail: "SSL site", Source: SourceConfig})
+		case "nginx":
+			add(Deployment{Kind: t, Target: nginx.ConfigFile(c.Lineage()), Detail: "SSL site", Source: SourceConfig})
+		}
+	}
+	if c.HAProxy != nil {
+		add(haproxyDeployment(c))
+	}
+	for _, e := range c.Exports {
+		d := exportDeployment(e)
+		d.Source = SourceConfig
+		add(d)
+	}
+	for _, d := range c.Deploy {
+		dep := copyDeployment(d)
+		dep.Source = SourceConfig
+		add(dep)
+	}
+	if c.KeySink != "" {
+		add(Deployment{Kind: "key_sink", Target: c.KeySink, Detail: "private key", Source: SourceConfig})
+	}
+	if strings.TrimSpace(c.DeployHook) != "" {
+		add(Deployment{Kind: "hook", Target: c.DeployHook, Detail: "deploy hook", Source: SourceConfig})
+	}
+	if rc, err := replicate.Load(c.BaseDir); err == nil {
+		for _, p := range rc.Peers {
+			if p.Wants(c.Lineage()) {
+				add(Deployment{Kind: "replica", Target: p.Name, Detail: p.Dir, Source: SourceConfig})
+			}
+		}
+	}
+	lineageDir := filepath.Join(c.BaseDir, "live", store.LineageName(c.Lineage()))
+	for _, f := range referringConfigs(apache.ConfigDirs(), lineageDir) {
+		add(Deployment{Kind: "apache", Target: f, Source: SourceScan})
+	}
+	for _, f := range referringConfigs(nginx.ConfigDirs(), lineageDir) {
+		add(Deployment{Kind: "nginx", Target: f, Source: SourceScan})
+	}
+	return out, nil
+}
+
+func haproxyDeployment(c Config) Deployment {
+	return Deployment{Kind: "haproxy", Target: c.HAProxy.CertFile, Detail: "runtime API " + c.HAProxy.Socket, Source: SourceConfig}
+}
+
+func hookDeployment(c Config) Deployment {
+	return Deployment{Kind: "hook", Target: c.DeployHook, Detail: "deploy hook"}
+}
+
+// canonicalTarget resolves links such as sites-enabled/ entries so a file
+// found twice is listed once.
+func canonicalTarget(target string) string {
+	if !filepath.IsAbs(target) {
+		return target
+	}
+	if p, err := filepath.EvalSymlinks(target); err == nil {
+		return p
+	}
+	return target
+}
+
+// referringConfigs returns the files directly in dirs that mention
+// lineageDir, i.e. web server configs loading the certificate's files.
+func referringConfigs(dirs []string, lineageDir string) []string {
+	needle := []byte(lineageDir + string(filepath.Separator))
+	var out []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			p := filepath.Join(dir, e.Name())
+			info, err := os.Stat(p)
+			if err != nil || !info.Mode().IsRegular() || info.Size() > 1<<20 {
+				continue
+			}
+			b, err := os.ReadFile(p)
+			if err == nil && bytes.Contains(b, needle) {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+// Package replicate pushes certificates issued on a primary host to peer
+// hosts over SSH, so every member of a load-balanced cluster serves the same
+// certificate shortly after it is renewed.
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"github.com/trustctl/trusttls/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Peer is a host that receives copies of issued certificates.
+type Peer struct {
+	Name    string   `yaml:"name"`
+	URL     string   `yaml:"url"`               // ssh://user@host[:port][?key=...&known_hosts=...]
+	Dir     string   `yaml:"dir"`               // files go to <dir>/<lineage>/{cert,chain,fullchain,privkey}.pem
+	Target  string   `yaml:"target,omitempty"`  // apache|nginx: picks a default reload command
+	Reload  string   `yaml:"reload,omitempty"`  // shell command run on the peer after an update
+	Domains []string `yaml:"domains,omitempty"` // lineages to send; empty means all
+	NoKey   bool     `yaml:"no_key,omitempty"`  // keep the private key off this peer
+}
+
+// Config lists the peers of this host.
+type Config struct {
+	Peers []Peer `yaml:"peers"`
+}
+
+var defaultReload = map[string]string{
+	"apache": "(apachectl configtest || apache2ctl configtest) && (systemctl reload apache2 || systemctl reload httpd)",
+	"nginx":  "nginx -t && systemctl reload nginx",
+}
+
+// ConfigPath returns where the peer list of baseDir is kept.
+func ConfigPath(baseDir string) string {
+	return confdir.Path(baseDir, "replication.yaml")
+}
+
+// Load reads the peer list. A missing file means no peers.
+func Load(baseDir string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	return c, nil
+}
+
+// Save writes the peer list with 0600 permissions.
+func Save(baseDir string, c Config) error {
+	b, err := yaml.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(confdir.Of(baseDir), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(ConfigPath(baseDir), b, 0600)
+}
+
+// Validate checks that p can be used.
+func (p Peer) Validate() error {
+	if p.Name == "" {
+		return errors.New("peer name is required")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("peer %s: invalid url: %w", p.Name, err)
+	}
+	if u.Scheme != "ssh" {
+		return fmt.Errorf("peer %s: unsupported scheme %q (use ssh://)", p.Name, u.Scheme)
+	}
+	if u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return fmt.Errorf("peer %s: url must be ssh://user@host", p.Name)
+	}
+	if !path.IsAbs(p.Dir) {
+		return fmt.Errorf("peer %s: dir must be an absolute path", p.Name)
+	}
+	if p.Target != "" {
+		if _, ok := defaultReload[p.Target]; !ok {
+			return fmt.Errorf("peer %s: target must be apache or nginx", p.Name)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the lineage for domain is sent to p.
+func (p Peer) Wants(domain string) bool {
+	if len(p.Domains) == 0 {
+		return true
+	}
+	for _, d := range p.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadCommand returns the command run on p after an update.
+func (p Peer) ReloadCommand() string {
+	if p.Reload != "" {
+		return p.Reload
+	}
+	return defaultReload[p.Target]
+}
+
+// Result is the outcome of pushing one lineage to one peer.
+type Result struct {
+	Peer string
+	Err  error
+}
+
+// Push sends the current certificate for domain to every peer that wants
+// it. Peers are independent: one failing does not stop the others.
+func Push(baseDir, domain string) ([]Result, error) {
+	cfg, err := Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Result
+	for _, p := range cfg.Peers {
+		if !p.Wants(domain) {
+			continue
+		}
+		out = append(out, Result{Peer: p.Name, Err: PushTo(p, baseDir, domain)})
+	}
+	return out, nil
+}
+
+// PushTo copies the live files of domain's lineage to p and runs its reload
+// command. Each file is written to a temporary name and renamed, so the peer
+// never serves a half-written certificate.
+func PushTo(p Peer, baseDir, domain string) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	files, err := lineageFiles(baseDir, domain, !p.NoKey)
+	if err != nil {
+		return err
+	}
+	conn, err := dial(p.URL)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", p.Name, err)
+	}
+	defer conn.Close()
+
+	dir := path.Join(p.Dir, store.LineageName(domain))
+	for _, f := range files {
+		dst := path.Join(dir, f.name)
+		tmp := path.Join(dir, "."+f.name+".tmp")
+		script := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s && mv -f %s %s",
+			shellQuote(dir), shellQuote(tmp), shellQuote(tmp), shellQuote(dst))
+		if err := conn.run(script, f.data); err != nil {
+			return fmt.Errorf("%s: write %s: %w", p.Name, dst, err)
+		}
+	}
+	if cmd := p.ReloadCommand(); cmd != "" {
+		if err := conn.run(cmd, nil); err != nil {
+			return fmt.Errorf("%s: reload: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+type lineageFile struct {
+	name string
+	data []byte
+}
+
+func lineageFiles(baseDir, domain string, withKey bool) ([]lineageFile, error) {
+	cert, key, chain, fullchain := store.LoadCertPaths(baseDir, domain)
+	paths := []string{cert, chain, fullchain}
+	if withKey {
+		paths = append(paths, key)
+	}
+	var out []lineageFile
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if os.IsNotExist(err) && p != cert {
+			continue // no key when it is delivered to a key sink
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lineageFile{name: filepath.Base(p), data: b})
+	}
+	return out, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,127 @@
+package replicate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// PasswordEnv can hold the SSH password or key passphrase for peers so it
+// does not have to be stored in replication.yaml.
+const PasswordEnv = "TRUSTTLS_REPLICA_PASSWORD"
+
+type sshConn struct {
+	client *ssh.Client
+	agent  net.Conn
+}
+
+// dial connects to an ssh:// peer URL. The "key" and "known_hosts" query
+// parameters select the private key and known hosts file, as for sftp
+// webroots; without a key the running ssh-agent is used. The host key is
+// always verified.
+func dial(rawURL string) (*sshConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	home, _ := os.UserHomeDir()
+	password, _ := u.User.Password()
+	if password == "" {
+		password = os.Getenv(PasswordEnv)
+	}
+
+	khPath := q.Get("known_hosts")
+	if khPath == "" {
+		khPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(khPath)
+	if err != nil {
+		return nil, fmt.Errorf("load known hosts %s: %w", khPath, err)
+	}
+
+	c := &sshConn{}
+	var auth []ssh.AuthMethod
+	if keyPath := q.Get("key"); keyPath != "" {
+		pemBytes, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(password))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if c.agent, err = net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(c.agent).Signers))
+		}
+	}
+	if password != "" && q.Get("key") == "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no ssh key, ssh-agent or password available")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	c.client, err = ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		if c.agent != nil {
+			c.agent.Close()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// run executes script on the peer with stdin as its input and returns the
+// output as part of the error when it fails.
+func (c *sshConn) run(script string, stdin []byte) error {
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	if stdin != nil {
+		sess.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := sess.CombinedOutput(script)
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *sshConn) Close() error {
+	if c.agent != nil {
+		c.agent.Close()
+	}
+	return c.client.Close()
+}
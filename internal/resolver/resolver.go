@@ -0,0 +1,389 @@
+// Package resolver sends DNS queries for trusttls' own pre-checks (DNS-01
+// propagation, CAA) to resolvers chosen by the user instead of the system
+// resolver. On split-horizon networks the system resolver answers from the
+// internal view, which says nothing about what the CA will see.
+//
+// Resolvers are given as strings:
+//
+//	system                       resolvers from /etc/resolv.conf
+//	8.8.8.8, [2606:4700::1111]   plain DNS over UDP (TCP on truncation), port 53
+//	tcp://9.9.9.9                plain DNS over TCP
+//	tls://1.1.1.1, tls://dns.google:853   DNS over TLS (RFC 7858)
+//	https://cloudflare-dns.com/dns-query   DNS over HTTPS (RFC 8484)
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// EnvVar lists resolvers, comma separated, when none are configured
+// explicitly.
+const EnvVar = "TRUSTTLS_RESOLVERS"
+
+// Public is the default set of public resolvers used to check that a record
+// is visible from the outside.
+var Public = []string{"8.8.8.8", "1.1.1.1", "9.9.9.9"}
+
+const queryTimeout = 5 * time.Second
+
+// Upstream is one configured resolver.
+type Upstream interface {
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// Resolver queries its upstreams in order and returns the first answer.
+type Resolver struct {
+	upstreams []Upstream
+}
+
+// New builds a Resolver from specs. An empty list means the system resolver.
+func New(specs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, s := range specs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		u, err := parseUpstream(s)
+		if err != nil {
+			return nil, err
+		}
+		r.upstreams = append(r.upstreams, u...)
+	}
+	if len(r.upstreams) == 0 {
+		sys, err := systemUpstreams()
+		if err != nil {
+			return nil, err
+		}
+		r.upstreams = sys
+	}
+	return r, nil
+}
+
+// Split turns a comma separated list, as used in TRUSTTLS_RESOLVERS and
+// config files, into specs for New.
+func Split(list string) []string {
+	var out []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Validate reports the first spec New would reject.
+func Validate(specs []string) error {
+	for _, s := range specs {
+		if _, err := parseUpstream(strings.TrimSpace(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upstreams returns the configured resolvers so callers can query each one
+// separately.
+func (r *Resolver) Upstreams() []Upstream { return r.upstreams }
+
+func (r *Resolver) String() string {
+	var names []string
+	for _, u := range r.upstreams {
+		names = append(names, u.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Query asks each upstream in turn until one answers with NOERROR or
+// NXDOMAIN.
+func (r *Resolver) Query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	var errs []error
+	for _, u := range r.upstreams {
+		resp, err := Ask(ctx, u, name, qtype)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Ask sends a single recursive query to u.
+func Ask(ctx context.Context, u Upstream, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(4096, false)
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	resp, err := u.Exchange(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s: %s for %s", u, dns.RcodeToString[resp.Rcode], name)
+	}
+	return resp, nil
+}
+
+// LookupTXT returns the TXT strings published at name, each record's
+// character strings joined.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	resp, err := r.Query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	return TXT(resp), nil
+}
+
+// TXT extracts the TXT values from a response.
+func TXT(resp *dns.Msg) []string {
+	var out []string
+	for _, rr := range resp.Answer {
+		if t, ok := rr.(*dns.TXT); ok {
+			out = append(out, strings.Join(t.Txt, ""))
+		}
+	}
+	return out
+}
+
+// LookupCAA returns the CAA records at name itself (no tree climbing).
+func (r *Resolver) LookupCAA(ctx context.Context, name string) ([]*dns.CAA, error) {
+	resp, err := r.Query(ctx, name, dns.TypeCAA)
+	if err != nil {
+		return nil, err
+	}
+	var out []*dns.CAA
+	for _, rr := range resp.Answer {
+		if c, ok := rr.(*dns.CAA); ok {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func parseUpstream(s string) ([]Upstream, error) {
+	switch {
+	case s == "system":
+		return systemUpstreams()
+	case strings.HasPrefix(s, "https://"):
+		if _, err := url.Parse(s); err != nil {
+			return nil, fmt.Errorf("resolver %q: %w", s, err)
+		}
+		return []Upstream{&dohUpstream{url: s, client: &http.Client{Timeout: queryTimeout}}}, nil
+	case strings.HasPrefix(s, "tls://"):
+		addr, host, err := hostPort(strings.TrimPrefix(s, "tls://"), "853")
+		if err != nil {
+			return nil, fmt.Errorf("resolver %q: %w", s, err)
+		}
+		c := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		return []Upstream{&dnsUpstream{name: s, addr: addr, client: c}}, nil
+	case strings.HasPrefix(s, "tcp://"):
+		addr, _, err := hostPort(strings.TrimPrefix(s, "tcp://"), "53")
+		if err != nil {
+			return nil, fmt.Errorf("resolver %q: %w", s, err)
+		}
+		return []Upstream{&dnsUpstream{name: s, addr: addr, client: &dns.Client{Net: "tcp"}}}, nil
+	case strings.Contains(s, "://"):
+		if !strings.HasPrefix(s, "udp://") {
+			return nil, fmt.Errorf("resolver %q: unsupported scheme (use udp://, tcp://, tls:// or https://)", s)
+		}
+		s = strings.TrimPrefix(s, "udp://")
+	}
+	addr, _, err := hostPort(s, "53")
+	if err != nil {
+		return nil, fmt.Errorf("resolver %q: %w", s, err)
+	}
+	return []Upstream{&dnsUpstream{name: addr, addr: addr, client: &dns.Client{Net: "udp"}, tcpFallback: true}}, nil
+}
+
+// hostPort accepts host, host:port, a bare IPv6 address or [v6]:port.
+func hostPort(s, defPort string) (addr, host string, err error) {
+	if s == "" {
+		return "", "", errors.New("empty address")
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return net.JoinHostPort(s, defPort), s, nil
+	}
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		return net.JoinHostPort(h, p), h, nil
+	}
+	if strings.ContainsAny(s, "/[]") {
+		return "", "", errors.New("invalid address")
+	}
+	return net.JoinHostPort(s, defPort), s, nil
+}
+
+func systemUpstreams() ([]Upstream, error) {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(cfg.Servers) == 0 {
+		return nil, fmt.Errorf("no system resolvers found in /etc/resolv.conf; configure resolvers explicitly")
+	}
+	var out []Upstream
+	for _, s := range cfg.Servers {
+		addr := net.JoinHostPort(s, cfg.Port)
+		out = append(out, &dnsUpstream{name: "system " + addr, addr: addr, client: &dns.Client{Net: "udp"}, tcpFallback: true})
+	}
+	return out, nil
+}
+
+type dnsUpstream struct {
+	name        string
+	addr        string
+	client      *dns.Client
+	tcpFallback bool
+	norecurse   bool
+}
+
+func (u *dnsUpstream) String() string { return u.name }
+
+func (u *dnsUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if u.norecurse {
+		m = m.Copy()
+		m.RecursionDesired = false
+	}
+	resp, _, err := u.client.ExchangeContext(ctx, m, u.addr)
+	if err == nil && resp.Truncated && u.tcpFallback {
+		tcp := &dns.Client{Net: "tcp"}
+		resp, _, err = tcp.ExchangeContext(ctx, m, u.addr)
+	}
+	return resp, err
+}
+
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) String() string { return u.url }
+
+func (u *dohUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	q := m.Copy()
+	q.Id = 0 // RFC 8484 4.1: lets HTTP caches share answers
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	out := new(dns.Msg)
+	if err := out.Unpack(body); err != nil {
+		return nil, err
+	}
+	out.Id = m.Id
+	return out, nil
+}
+
+// FindZone returns the apex of the zone that contains name, found by walking
+// up the labels until a SOA record owned by the name itself is returned.
+func (r *Resolver) FindZone(ctx context.Context, name string) (string, error) {
+	for n := dns.Fqdn(name); n != "."; {
+		resp, err := r.Query(ctx, n, dns.TypeSOA)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range resp.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, n) {
+				return n, nil
+			}
+		}
+		i, end := dns.NextLabel(n, 0)
+		if end {
+			break
+		}
+		n = n[i:]
+	}
+	return "", fmt.Errorf("no zone found for %s", name)
+}
+
+// Authoritative returns upstreams that query the nameservers of zone
+// directly, one per address, without asking for recursion so no cache is
+// involved. Nameserver names are resolved through r.
+func (r *Resolver) Authoritative(ctx context.Context, zone string) ([]Upstream, error) {
+	resp, err := r.Query(ctx, zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var out []Upstream
+	for _, rr := range resp.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			addrs, err := r.Query(ctx, ns.Ns, qtype)
+			if err != nil {
+				continue
+			}
+			for _, a := range addrs.Answer {
+				var ip net.IP
+				switch v := a.(type) {
+				case *dns.A:
+					ip = v.A
+				case *dns.AAAA:
+					ip = v.AAAA
+				default:
+					continue
+				}
+				addr := net.JoinHostPort(ip.String(), "53")
+				out = append(out, &dnsUpstream{name: strings.TrimSuffix(ns.Ns, ".") + " (" + ip.String() + ")", addr: addr, client: &dns.Client{Net: "udp"}, tcpFallback: true, norecurse: true})
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no reachable nameservers found for %s", zone)
+	}
+	return out, nil
+}
+
+// FollowCNAME returns the name a lookup of name ends up at after following
+// any CNAME chain, so records delegated to another zone are checked where
+// they actually live.
+func (r *Resolver) FollowCNAME(ctx context.Context, name string) string {
+	name = dns.Fqdn(name)
+	for i := 0; i < 8; i++ {
+		resp, err := r.Query(ctx, name, dns.TypeCNAME)
+		if err != nil {
+			return name
+		}
+		next := ""
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+				next = c.Target
+			}
+		}
+		if next == "" {
+			return name
+		}
+		name = next
+	}
+	return name
+}
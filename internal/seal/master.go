@@ -0,0 +1,513 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/trustctl/trusttls/internal/osutil"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Environment variables the passphrase master key is read from.
+const (
+	PassphraseEnv     = "TRUSTTLS_PASSPHRASE"
+	PassphraseFileEnv = "TRUSTTLS_PASSPHRASE_FILE"
+	// NewPassphraseEnv holds the new passphrase while rotating from one
+	// passphrase to another.
+	NewPassphraseEnv = "TRUSTTLS_NEW_PASSPHRASE"
+)
+
+// Names of the systemd credentials (LoadCredential= or SetCredential= in
+// the unit) the passphrase and key file are read from, in the directory
+// systemd passes in $CREDENTIALS_DIRECTORY.
+const (
+	PassphraseCredential = "trusttls-passphrase"
+	KeyfileCredential    = "trusttls-keyfile"
+)
+
+// Master wraps and unwraps data keys. Its key never leaves the keyring or
+// KMS it comes from, except for the passphrase, which is only stretched.
+type Master interface {
+	// String returns the spec of the master key, which is recorded in each
+	// sealed file so it can be opened without encryption.yaml.
+	String() string
+	Wrap(dek []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// ParseMaster returns the master key named by spec:
+//
+//	passphrase                  scrypt of $TRUSTTLS_PASSPHRASE, the file $TRUSTTLS_PASSPHRASE_FILE,
+//	                            the systemd credential trusttls-passphrase, or one typed at the terminal
+//	keyfile[:path]              a random key in a file kept off the store, or the systemd
+//	                            credential trusttls-keyfile without a path
+//	keyring[:name]              a random key kept in the OS keyring (Secret Service or macOS Keychain)
+//	aws-kms:<key-id>            an AWS KMS key, through the aws CLI
+//	vault-transit:[mount/]<key> a Vault transit key, through the vault CLI
+//
+// Nothing is fetched until a data key is wrapped or unwrapped.
+func ParseMaster(spec string) (Master, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "passphrase":
+		if arg != "" {
+			return nil, fmt.Errorf("master %q: the passphrase comes from %s or %s, not the spec", spec, PassphraseEnv, PassphraseFileEnv)
+		}
+		return &passphraseMaster{pass: envPassphrase}, nil
+	case "keyfile":
+		if arg != "" && !filepath.IsAbs(arg) {
+			return nil, fmt.Errorf("master %q: the key file path must be absolute", spec)
+		}
+		return &keyfileMaster{path: arg}, nil
+	case "keyring":
+		if arg == "" {
+			arg = "default"
+		}
+		return &keyringMaster{name: arg}, nil
+	case "aws-kms":
+		if arg == "" {
+			return nil, fmt.Errorf("master %q: name the key, e.g. aws-kms:alias/trusttls", spec)
+		}
+		return &kmsMaster{keyID: arg}, nil
+	case "vault-transit":
+		mount, key, ok := strings.Cut(arg, "/")
+		if !ok {
+			mount, key = "transit", arg
+		}
+		if mount == "" || key == "" || strings.Contains(key, "/") {
+			return nil, fmt.Errorf("master %q: name the key as vault-transit:<key> or vault-transit:<mount>/<key>", spec)
+		}
+		return &transitMaster{mount: mount, key: key}, nil
+	}
+	return nil, fmt.Errorf("master %q: want passphrase, keyfile[:path], keyring[:name], aws-kms:<key-id> or vault-transit:[mount/]<key>", spec)
+}
+
+// Passphrase returns a passphrase master key for pass, for rotating from one
+// passphrase to another.
+func Passphrase(pass string) Master {
+	return &passphraseMaster{pass: func() ([]byte, error) { return []byte(pass), nil }}
+}
+
+// PassphraseSet reports whether the passphrase can be read without asking
+// for it: from the environment or a systemd credential.
+func PassphraseSet() bool {
+	return os.Getenv(PassphraseEnv) != "" || os.Getenv(PassphraseFileEnv) != "" || credential(PassphraseCredential) != ""
+}
+
+// envPassphrase reads the passphrase from $TRUSTTLS_PASSPHRASE, the file
+// named by $TRUSTTLS_PASSPHRASE_FILE or the systemd credential, in that
+// order, and asks for it when none is set and there is a terminal.
+func envPassphrase() ([]byte, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if f := os.Getenv(PassphraseFileEnv); f != "" {
+		return readPassphraseFile(PassphraseFileEnv, f)
+	}
+	if f := credential(PassphraseCredential); f != "" {
+		return readPassphraseFile("credential "+PassphraseCredential, f)
+	}
+	if CanPrompt() {
+		p, err := ReadPassphrase("Passphrase for the TrustTLS store: ")
+		return []byte(p), err
+	}
+	return nil, fmt.Errorf("the passphrase master key needs %s, %s or the systemd credential %s", PassphraseEnv, PassphraseFileEnv, PassphraseCredential)
+}
+
+func readPassphraseFile(source, f string) ([]byte, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	if p := strings.TrimRight(string(b), "\r\n"); p != "" {
+		return []byte(p), nil
+	}
+	return nil, fmt.Errorf("%s: %s is empty", source, f)
+}
+
+// credential returns the path of the systemd credential name, or "" when
+// the process was not given it.
+func credential(name string) string {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+const saltSize = 16
+
+// passphraseMaster derives a key from a passphrase with scrypt. A wrapped
+// data key is salt | nonce | ciphertext. The salt is picked once per
+// process, so sealing many files stretches the passphrase once. The
+// passphrase is read once too, so it is asked for at most once per run.
+type passphraseMaster struct {
+	pass func() ([]byte, error)
+
+	mu         sync.Mutex
+	passphrase []byte
+	salt       []byte
+	keks       map[string][]byte // derived keys by salt
+}
+
+func (p *passphraseMaster) String() string { return "passphrase" }
+
+func (p *passphraseMaster) kek(salt []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keks[string(salt)]; ok {
+		return k, nil
+	}
+	if p.passphrase == nil {
+		pass, err := p.pass()
+		if err != nil {
+			return nil, err
+		}
+		p.passphrase = pass
+	}
+	k, err := scrypt.Key(p.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	if p.keks == nil {
+		p.keks = map[string][]byte{}
+	}
+	p.keks[string(salt)] = k
+	return k, nil
+}
+
+func (p *passphraseMaster) Wrap(dek []byte) ([]byte, error) {
+	p.mu.Lock()
+	if p.salt == nil {
+		p.salt = make([]byte, saltSize)
+		if _, err := rand.Read(p.salt); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+	}
+	salt := p.salt
+	p.mu.Unlock()
+	k, err := p.kek(salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := gcmSeal(k, dek)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, salt...), sealed...), nil
+}
+
+func (p *passphraseMaster) Unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < saltSize {
+		return nil, errors.New("wrapped key too short")
+	}
+	k, err := p.kek(wrapped[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	dek, err := gcmOpen(k, wrapped[saltSize:])
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return dek, nil
+}
+
+// keyfileMaster keeps a random key in a file, created by CreateKeyfile when
+// encryption is turned on with it. The file belongs off the store (removable media, a
+// separate mount, a systemd credential), so a copy of the store alone opens
+// nothing. A wrapped data key is nonce | ciphertext.
+type keyfileMaster struct {
+	path string // empty for the systemd credential
+
+	mu  sync.Mutex
+	key []byte
+}
+
+// keyfileSize is the size of a key file created by keyfileMaster. Files
+// made by other tools may be larger; they are hashed down to a key.
+const keyfileSize = 32
+
+func (k *keyfileMaster) String() string {
+	if k.path == "" {
+		return "keyfile"
+	}
+	return "keyfile:" + k.path
+}
+
+// Path returns the key file, or "" for a systemd credential that was not
+// passed to the process.
+func (k *keyfileMaster) Path() string {
+	if k.path == "" {
+		return credential(KeyfileCredential)
+	}
+	return k.path
+}
+
+func (k *keyfileMaster) load() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.key != nil {
+		return k.key, nil
+	}
+	path := k.Path()
+	if path == "" {
+		return nil, fmt.Errorf("master key %s: the systemd credential %s was not passed to the process", k, KeyfileCredential)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Not wrapped: a missing key file must not read as a missing
+		// sealed file, which callers would create afresh
+		return nil, fmt.Errorf("master key %s: %v", k, err)
+	}
+	if len(b) < keyfileSize {
+		return nil, fmt.Errorf("key file %s has %d bytes; it needs at least %d random ones", path, len(b), keyfileSize)
+	}
+	sum := sha256.Sum256(b)
+	k.key = sum[:]
+	return k.key, nil
+}
+
+func (k *keyfileMaster) Wrap(dek []byte) ([]byte, error) {
+	key, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	return gcmSeal(key, dek)
+}
+
+func (k *keyfileMaster) Unwrap(wrapped []byte) ([]byte, error) {
+	key, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	dek, err := gcmOpen(key, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("wrong key file %s", k.Path())
+	}
+	return dek, nil
+}
+
+// CreateKeyfile writes a new random key to the key file of m when it has a
+// path and the file does not exist yet, and reports whether it did. A
+// missing key file is never replaced otherwise: files sealed with the old
+// key would not open with the new one.
+func CreateKeyfile(m Master) (bool, error) {
+	k, ok := m.(*keyfileMaster)
+	if !ok || k.path == "" {
+		return false, nil
+	}
+	if _, err := os.Stat(k.path); !os.IsNotExist(err) {
+		return false, err
+	}
+	b := make([]byte, keyfileSize)
+	if _, err := rand.Read(b); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return false, err
+	}
+	if err := writeAtomic(k.path, b, 0400); err != nil {
+		return false, fmt.Errorf("create key file: %w", err)
+	}
+	return true, nil
+}
+
+// KeyfilePath returns the file the key of m is read from, or "" when m is
+// not a key file master.
+func KeyfilePath(m Master) string {
+	if k, ok := m.(*keyfileMaster); ok {
+		return k.Path()
+	}
+	return ""
+}
+
+// keyringMaster keeps a random key in the OS keyring, created the first
+// time a data key is wrapped. A wrapped data key is nonce | ciphertext.
+type keyringMaster struct {
+	name string
+
+	mu  sync.Mutex
+	key []byte
+}
+
+// keyringService is the service the keyring entries are filed under.
+const keyringService = "trusttls"
+
+func (k *keyringMaster) String() string {
+	if k.name == "default" {
+		return "keyring"
+	}
+	return "keyring:" + k.name
+}
+
+func (k *keyringMaster) load(create bool) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.key != nil {
+		return k.key, nil
+	}
+	secret, err := keyringLookup(k.name)
+	if errors.Is(err, errNoEntry) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		secret = base64.StdEncoding.EncodeToString(key)
+		if err := keyringStore(k.name, secret); err != nil {
+			return nil, fmt.Errorf("store master key in the keyring: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("master key %s not found in the keyring: %w", k, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(secret))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("keyring entry %s/%s is not a trusttls master key", keyringService, k.name)
+	}
+	k.key = key
+	return key, nil
+}
+
+func (k *keyringMaster) Wrap(dek []byte) ([]byte, error) {
+	key, err := k.load(true)
+	if err != nil {
+		return nil, err
+	}
+	return gcmSeal(key, dek)
+}
+
+func (k *keyringMaster) Unwrap(wrapped []byte) ([]byte, error) {
+	key, err := k.load(false)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(key, wrapped)
+}
+
+// errNoEntry is returned by keyringLookup when the keyring answered and has
+// no key for the name. Only then is a new key created: a locked or
+// unreachable keyring must not lead to the existing key being replaced.
+var errNoEntry = errors.New("no such entry")
+
+// keyringLookup returns the secret kept for account name.
+func keyringLookup(name string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+	case "linux", "freebsd", "openbsd":
+		if !osutil.CommandExists("secret-tool") {
+			return "", errors.New("secret-tool not found on PATH (install libsecret-tools)")
+		}
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", name)
+	default:
+		return "", fmt.Errorf("no keyring support on %s; use a passphrase or KMS master key", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	msg := strings.TrimSpace(stderr.String())
+	if err != nil {
+		// secret-tool fails silently when there is no match; security says so
+		if msg == "" || strings.Contains(msg, "could not be found") {
+			return "", errNoEntry
+		}
+		return "", fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+	}
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return "", errNoEntry
+	}
+	return string(out), nil
+}
+
+// keyringStore saves secret for account name.
+func keyringStore(name, secret string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return osutil.Run("security", "add-generic-password", "-s", keyringService, "-a", name, "-l", "TrustTLS master key", "-w", secret)
+	case "linux", "freebsd", "openbsd":
+		return osutil.RunWithInput([]byte(secret), "secret-tool", "store", "--label=TrustTLS master key", "service", keyringService, "account", name)
+	}
+	return fmt.Errorf("no keyring support on %s; use a passphrase or KMS master key", runtime.GOOS)
+}
+
+// kmsMaster wraps data keys with AWS KMS through the aws CLI, which finds
+// credentials and the region as usual.
+type kmsMaster struct {
+	keyID string
+}
+
+func (k *kmsMaster) String() string { return "aws-kms:" + k.keyID }
+
+func (k *kmsMaster) Wrap(dek []byte) ([]byte, error) {
+	return k.run(dek, "encrypt", "--plaintext", "fileb:///dev/stdin", "--query", "CiphertextBlob")
+}
+
+func (k *kmsMaster) Unwrap(wrapped []byte) ([]byte, error) {
+	return k.run(wrapped, "decrypt", "--ciphertext-blob", "fileb:///dev/stdin", "--query", "Plaintext")
+}
+
+func (k *kmsMaster) run(input []byte, op string, args ...string) ([]byte, error) {
+	if !osutil.CommandExists("aws") {
+		return nil, errors.New("aws CLI not found on PATH")
+	}
+	args = append([]string{"kms", op, "--key-id", k.keyID}, args...)
+	out, err := osutil.OutputWithInput(input, "aws", append(args, "--output", "text")...)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// transitMaster wraps data keys with a Vault transit key through the vault
+// CLI, which finds VAULT_ADDR and the token as usual. A wrapped data key is
+// Vault's ciphertext, e.g. vault:v1:...
+type transitMaster struct {
+	mount, key string
+}
+
+func (t *transitMaster) String() string {
+	if t.mount == "transit" {
+		return "vault-transit:" + t.key
+	}
+	return "vault-transit:" + t.mount + "/" + t.key
+}
+
+func (t *transitMaster) Wrap(dek []byte) ([]byte, error) {
+	// plaintext=- reads the value from stdin, keeping it out of ps
+	b64 := base64.StdEncoding.EncodeToString(dek)
+	out, err := t.write("encrypt", "ciphertext", []byte(b64), "plaintext=-")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(string(out))), nil
+}
+
+func (t *transitMaster) Unwrap(wrapped []byte) ([]byte, error) {
+	out, err := t.write("decrypt", "plaintext", nil, "ciphertext="+string(wrapped))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (t *transitMaster) write(op, field string, input []byte, arg string) ([]byte, error) {
+	if !osutil.CommandExists("vault") {
+		return nil, errors.New("vault CLI not found on PATH")
+	}
+	return osutil.OutputWithInput(input, "vault", "write", "-field="+field, t.mount+"/"+op+"/"+t.key, arg)
+}
@@ -0,0 +1,79 @@
+package seal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// stdin is shared by every prompt, so a line typed ahead is not lost in the
+// buffer of an earlier one.
+var (
+	stdinOnce sync.Once
+	stdin     *bufio.Reader
+)
+
+// CanPrompt reports whether a passphrase can be asked for on the terminal.
+// /dev/null is a character device too, which stty tells apart where it is
+// available.
+func CanPrompt() bool {
+	st, err := os.Stdin.Stat()
+	if err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	err = stty("-g")
+	var notFound *exec.Error
+	return err == nil || errors.As(err, &notFound)
+}
+
+// ReadPassphrase asks for a passphrase on the terminal without echoing it.
+// Where stty is missing (Windows) the passphrase is echoed.
+func ReadPassphrase(prompt string) (string, error) {
+	if !CanPrompt() {
+		return "", errors.New("no terminal to ask for the passphrase on")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	stdinOnce.Do(func() { stdin = bufio.NewReader(os.Stdin) })
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return pass, nil
+}
+
+// ReadNewPassphrase asks for a new passphrase twice, so a typo does not seal
+// the store with a passphrase nobody knows.
+func ReadNewPassphrase() (string, error) {
+	pass, err := ReadPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := ReadPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != again {
+		return "", errors.New("the passphrases do not match")
+	}
+	return pass, nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
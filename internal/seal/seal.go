@@ -0,0 +1,405 @@
+// Package seal encrypts the secrets kept in the store: account keys, CA
+// keys, DNS credentials and pending orders.
+//
+// Files are sealed with envelope encryption. Each file is encrypted with
+// AES-256-GCM under a data key of its own, and that data key is kept in the
+// file wrapped by a master key from the OS keyring, a KMS or a passphrase.
+// Rotating the master key rewraps the data keys only: the files' contents are
+// never re-encrypted.
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+	"gopkg.in/yaml.v3"
+)
+
+// blockType is the PEM type of a sealed file.
+const blockType = "TRUSTTLS SEALED FILE"
+
+// Config is the encryption setting of a store, kept in encryption.yaml.
+type Config struct {
+	Master string `yaml:"master"` // spec of the master key, see ParseMaster
+}
+
+// ConfigPath returns where the encryption setting of baseDir is kept.
+func ConfigPath(baseDir string) string {
+	return confdir.Path(baseDir, "encryption.yaml")
+}
+
+// Load reads the encryption setting. A missing file means secrets are
+// written in plain text.
+func Load(baseDir string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(ConfigPath(baseDir))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	return c, nil
+}
+
+// Save writes c as the encryption setting of baseDir.
+func Save(baseDir string, c Config) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(confdir.Of(baseDir), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(ConfigPath(baseDir), b, 0600)
+}
+
+// Validate checks the master key spec without fetching any key.
+func (c Config) Validate() error {
+	if c.Master == "" {
+		return errors.New("master: missing; remove the file to write secrets in plain text")
+	}
+	_, err := ParseMaster(c.Master)
+	return err
+}
+
+var (
+	mu     sync.Mutex
+	active Master                // master new files are sealed with; nil writes plain text
+	known  = map[string]Master{} // masters by spec, so their keys are fetched once
+	deks   = map[string][]byte{} // unwrapped data keys by wrapped form
+)
+
+// Use seals files written from now on with the master key configured for
+// baseDir, or writes them in plain text when there is none. Keys are only
+// fetched when a file is first sealed or opened.
+func Use(baseDir string) error {
+	c, err := Load(baseDir)
+	if err != nil {
+		return err
+	}
+	if c.Master == "" {
+		SetMaster(nil)
+		return nil
+	}
+	m, err := MasterFor(c.Master)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ConfigPath(baseDir), err)
+	}
+	SetMaster(m)
+	return nil
+}
+
+// SetMaster seals files written from now on with m; nil turns sealing off.
+func SetMaster(m Master) {
+	mu.Lock()
+	defer mu.Unlock()
+	active = m
+	if m != nil {
+		known[m.String()] = m
+	}
+}
+
+// Active returns the master new files are sealed with, or nil.
+func Active() Master {
+	mu.Lock()
+	defer mu.Unlock()
+	return active
+}
+
+// MasterFor returns the master key named by spec, the same one each time.
+func MasterFor(spec string) (Master, error) {
+	mu.Lock()
+	m, ok := known[spec]
+	mu.Unlock()
+	if ok {
+		return m, nil
+	}
+	m, err := ParseMaster(spec)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	known[spec] = m
+	mu.Unlock()
+	return m, nil
+}
+
+// isKnown reports whether m is the master files naming its spec are opened
+// with. A new passphrase is not, though its spec is the same as the old one.
+func isKnown(m Master) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return known[m.String()] == m
+}
+
+// Check wraps and unwraps a throwaway data key with m, so a master key that
+// cannot be reached is found before any file depends on it.
+func Check(m Master) error {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	wrapped, err := m.Wrap(dek)
+	if err != nil {
+		return fmt.Errorf("wrap data key with %s: %w", m, err)
+	}
+	got, err := m.Unwrap(wrapped)
+	if err != nil {
+		return fmt.Errorf("unwrap data key with %s: %w", m, err)
+	}
+	if !bytes.Equal(got, dek) {
+		return fmt.Errorf("%s returned a different data key than it wrapped", m)
+	}
+	return nil
+}
+
+// IsSealed reports whether data is a sealed file.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "+blockType+"-----"))
+}
+
+// Seal encrypts data under a new data key wrapped by the active master, or
+// returns it unchanged when sealing is off.
+func Seal(data []byte) ([]byte, error) {
+	m := Active()
+	if m == nil {
+		return data, nil
+	}
+	return SealWith(m, data)
+}
+
+// SealWith encrypts data under a new data key wrapped by m.
+func SealWith(m Master, data []byte) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := m.Wrap(dek)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key with %s: %w", m, err)
+	}
+	body, err := gcmSeal(dek, data)
+	if err != nil {
+		return nil, err
+	}
+	return encode(m.String(), wrapped, body), nil
+}
+
+// Open decrypts a sealed file with the master key named in it, so files
+// sealed before a rotation or with encryption since turned off can still be
+// read. Plain data is returned unchanged.
+func Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	block, dek, err := unwrap(data)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dek, block.Bytes)
+}
+
+// Rewrap returns the sealed file data with its data key wrapped by to
+// instead. The encrypted contents are kept as they are.
+func Rewrap(data []byte, to Master) ([]byte, error) {
+	block, dek, err := unwrap(data)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := to.Wrap(dek)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key with %s: %w", to, err)
+	}
+	return encode(to.String(), wrapped, block.Bytes), nil
+}
+
+// MasterOf returns the spec of the master key data is sealed with, or ""
+// when it is not sealed.
+func MasterOf(data []byte) string {
+	if !IsSealed(data) {
+		return ""
+	}
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil {
+		return ""
+	}
+	return block.Headers["Master"]
+}
+
+// ReadFile reads the file at path and opens it when it is sealed. Errors
+// from the file system are returned as they are, so os.ErrNotExist can be
+// checked as usual.
+func ReadFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Open(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// WriteFile seals data when encryption is on and writes it to path, through
+// a temporary file so a failed write never leaves half a key behind.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	sealed, err := Seal(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return writeAtomic(path, sealed, perm)
+}
+
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Convert rewrites the file at path in place: sealed with to, rewrapped for
+// to when it is already sealed, or opened when to is nil. It reports whether
+// the file changed.
+func Convert(path string, to Master) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	var out []byte
+	switch {
+	case to == nil && !IsSealed(b):
+		return false, nil
+	case to == nil:
+		out, err = Open(b)
+	case !IsSealed(b):
+		out, err = SealWith(to, b)
+	case MasterOf(b) == to.String() && isKnown(to):
+		return false, nil
+	default:
+		out, err = Rewrap(b, to)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	return true, writeAtomic(path, out, st.Mode().Perm())
+}
+
+// unwrap decodes a sealed file and returns its data key.
+func unwrap(data []byte) (*pem.Block, []byte, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil || block.Type != blockType {
+		return nil, nil, errors.New("not a sealed file")
+	}
+	spec, wrappedB64 := block.Headers["Master"], block.Headers["Data-Key"]
+	if spec == "" || wrappedB64 == "" {
+		return nil, nil, errors.New("sealed file without Master or Data-Key")
+	}
+	mu.Lock()
+	dek, ok := deks[spec+" "+wrappedB64]
+	mu.Unlock()
+	if ok {
+		return block, dek, nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedB64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Data-Key: %w", err)
+	}
+	m, err := MasterFor(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dek, err = m.Unwrap(wrapped); err != nil {
+		return nil, nil, fmt.Errorf("unwrap data key with %s: %w", spec, err)
+	}
+	if len(dek) != 32 {
+		return nil, nil, fmt.Errorf("data key unwrapped with %s has %d bytes, not 32", spec, len(dek))
+	}
+	mu.Lock()
+	deks[spec+" "+wrappedB64] = dek
+	mu.Unlock()
+	return block, dek, nil
+}
+
+func encode(spec string, wrapped, body []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: blockType,
+		Headers: map[string]string{
+			"Master":   spec,
+			"Data-Key": base64.StdEncoding.EncodeToString(wrapped),
+		},
+		Bytes: body,
+	})
+}
+
+// gcmSeal encrypts plain with key and returns nonce | ciphertext.
+func gcmSeal(key, plain []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// gcmOpen decrypts nonce | ciphertext made by gcmSeal.
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("decryption failed: wrong key or damaged file")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
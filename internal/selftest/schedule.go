@@ -0,0 +1,67 @@
+package selftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retryFailed is how soon a scheduled self-test that failed runs again,
+// when its interval is longer: a broken pipeline is checked again the next
+// day without asking the CA for certificates on every run.
+const retryFailed = 24 * time.Hour
+
+// State is what scheduled self-tests remember between runs.
+type State struct {
+	LastRun     time.Time  `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"` // of the last run, when it failed
+}
+
+// StatePath returns where the self-test state of the store in baseDir is
+// kept.
+func StatePath(baseDir string) string {
+	return filepath.Join(baseDir, "selftest.json")
+}
+
+// LoadState reads the self-test state; before the first run it is empty.
+func LoadState(baseDir string) (*State, error) {
+	st := &State{}
+	b, err := os.ReadFile(StatePath(baseDir))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %w", StatePath(baseDir), err)
+	}
+	return st, nil
+}
+
+// SaveState records st for the next run.
+func SaveState(baseDir string, st *State) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return err
+	}
+	path := StatePath(baseDir)
+	if err := os.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Next returns when a self-test run every interval is due again.
+func (st *State) Next(every time.Duration) time.Time {
+	if st.Error != "" && every > retryFailed {
+		return st.LastRun.Add(retryFailed)
+	}
+	return st.LastRun.Add(every)
+}
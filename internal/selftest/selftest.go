@@ -0,0 +1,194 @@
+// Package selftest runs the path a certificate takes through trusttls —
+// order, challenge, store, install, renew and revoke — end to end against
+// an ACME CA, in a store of its own. Nothing in the real store or on the
+// web servers is touched, so it can run on a production host to show the
+// pipeline works before any real certificate depends on it.
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/acme/dnsprovider"
+	"github.com/trustctl/trusttls/internal/acme/standalone"
+	"github.com/trustctl/trusttls/internal/issuer"
+	"github.com/trustctl/trusttls/internal/store"
+)
+
+// Options configure a self-test.
+type Options struct {
+	Server   string // ACME directory URL
+	CABundle string // extra roots to trust for Server
+	Email    string
+	// Domain is the name ordered. Unless the CA skips validation it must
+	// be validated through one of the fields below.
+	Domain string
+	// Webroot or DNSPlugin prove control of Domain; without either the
+	// built-in http-01 server listens on Standalone, e.g. ":80".
+	Webroot        string
+	DNSPlugin      string
+	DNSCredentials dnsprovider.Credentials
+	Standalone     string
+	// Roots are PEM roots the certificates must chain to; nil only checks
+	// their names and key.
+	Roots []byte
+	// Dir holds the test's store and install target. The caller removes
+	// it afterwards.
+	Dir string
+}
+
+// Result describes a passed self-test.
+type Result struct {
+	Serials  []string      // of the issued and the renewed certificate
+	Took     time.Duration // for the whole run
+	NotAfter time.Time     // of the renewed certificate
+}
+
+// Run orders a certificate for opts.Domain, stores and installs it, renews
+// and installs it again, then revokes both. Each step is reported as it
+// passes; the first that fails ends the run with an error naming it.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	start := time.Now()
+	baseDir := filepath.Join(opts.Dir, "store")
+	target := filepath.Join(opts.Dir, "target")
+
+	iss, err := issuer.New("letsencrypt", issuer.Settings{
+		BaseDir:  baseDir,
+		Email:    opts.Email,
+		Server:   opts.Server,
+		CABundle: opts.CABundle,
+		KeyType:  "ecdsa",
+		KeySize:  256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("account: %w", err)
+	}
+	fmt.Printf("✅ Account registered with %s\n", opts.Server)
+	req := issuer.Request{
+		Domains:        []string{opts.Domain},
+		Webroot:        opts.Webroot,
+		DNSPlugin:      opts.DNSPlugin,
+		DNSCredentials: opts.DNSCredentials,
+	}
+	if opts.Webroot == "" && opts.DNSPlugin == "" {
+		req.Standalone = standalone.New(opts.Standalone)
+	}
+	if err := iss.Capabilities().Check(req.Domains, req.Method()); err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	var issued [][]byte
+	for _, step := range []struct {
+		name  string
+		order func(context.Context, issuer.Request) (*certificate.Resource, error)
+	}{
+		{"issue", iss.Order},
+		{"renew", iss.Renew},
+	} {
+		cert, err := step.order(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", step.name, err)
+		}
+		if _, err := store.SaveCertificate(baseDir, opts.Domain, cert.Certificate, cert.IssuerCertificate, cert.PrivateKey); err != nil {
+			return nil, fmt.Errorf("%s: store: %w", step.name, err)
+		}
+		leaf, err := install(baseDir, opts.Domain, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: install: %w", step.name, err)
+		}
+		if err := verify(leaf, target, opts.Domain, opts.Roots); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.name, err)
+		}
+		serial := fmt.Sprintf("%x", leaf.SerialNumber)
+		for _, s := range res.Serials {
+			if s == serial {
+				return nil, fmt.Errorf("%s: the installed certificate is still the previous one", step.name)
+			}
+		}
+		res.Serials = append(res.Serials, serial)
+		res.NotAfter = leaf.NotAfter
+		issued = append(issued, cert.Certificate)
+		fmt.Printf("✅ %s: certificate %s stored, installed and verified\n", step.name, serial)
+	}
+
+	// Test certificates should not outlive the test
+	for _, certPEM := range issued {
+		if err := iss.Revoke(ctx, certPEM); err != nil {
+			return nil, fmt.Errorf("revoke: %w", err)
+		}
+	}
+	fmt.Printf("✅ revoke: both certificates revoked\n")
+	res.Took = time.Since(start)
+	return res, nil
+}
+
+// install copies the live certificate of name in baseDir to dir, as a
+// deploy to a web server would, and returns the leaf certificate copied.
+func install(baseDir, name, dir string) (*x509.Certificate, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	certPath, keyPath, _, fullchainPath := store.LoadCertPaths(baseDir, name)
+	for _, from := range []string{certPath, keyPath, fullchainPath} {
+		b, err := os.ReadFile(from)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(from)), b, 0600); err != nil {
+			return nil, err
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("cert.pem holds no certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// verify checks that the files installed in dir form a usable TLS
+// certificate for domain, chaining to roots when given.
+func verify(leaf *x509.Certificate, dir, domain string, roots []byte) error {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem"))
+	if err != nil {
+		return fmt.Errorf("installed certificate and key do not belong together: %w", err)
+	}
+	if !bytes.Equal(pair.Certificate[0], leaf.Raw) {
+		return errors.New("fullchain.pem does not start with the certificate in cert.pem")
+	}
+	if err := leaf.VerifyHostname(domain); err != nil {
+		return err
+	}
+	if roots == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		return errors.New("no PEM roots to verify against")
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: domain, Roots: pool, Intermediates: intermediates}); err != nil {
+		return fmt.Errorf("chain: %w", err)
+	}
+	return nil
+}
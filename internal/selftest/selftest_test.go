@@ -0,0 +1,58 @@
+package selftest_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/pebble"
+	"github.com/trustctl/trusttls/internal/selftest"
+)
+
+// TestRunAgainstPebble runs the whole self-test, issue to revoke, against a
+// throwaway Pebble, as trusttls self-test --pebble does. It is skipped
+// where Pebble is not installed.
+func TestRunAgainstPebble(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts Pebble")
+	}
+	if _, err := exec.LookPath(pebble.Binary); err != nil {
+		t.Skipf("%s not in PATH: go install github.com/letsencrypt/pebble/v2/cmd/pebble@latest", pebble.Binary)
+	}
+	dir := t.TempDir()
+	srv, err := pebble.Start("", filepath.Join(dir, "pebble"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	roots, err := srv.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := selftest.Run(ctx, selftest.Options{
+		Server:   srv.Directory,
+		CABundle: srv.CACert,
+		Email:    "selftest@trusttls.test",
+		Domain:   "selftest.trusttls.test",
+		// Pebble accepts challenges without checking them
+		Standalone: "127.0.0.1:0",
+		Roots:      roots,
+		Dir:        filepath.Join(dir, "run"),
+	})
+	if err != nil {
+		log, _ := os.ReadFile(srv.Log)
+		t.Fatalf("%v\nPebble's log:\n%s", err, log)
+	}
+	if len(res.Serials) != 2 || res.Serials[0] == res.Serials[1] {
+		t.Fatalf("want two different serials, issued and renewed, got %v", res.Serials)
+	}
+	if !res.NotAfter.After(time.Now()) {
+		t.Fatalf("renewed certificate expired at %s", res.NotAfter)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// Access lets users other than the one running trusttls read a lineage's
+// files, for web servers whose worker processes load the key themselves.
+type Access struct {
+	UID, GID int         // owner and group given to the files; -1 keeps them
+	KeyMode  os.FileMode // mode of the private keys; 0 keeps 0600
+}
+
+// Zero reports whether a changes nothing.
+func (a Access) Zero() bool {
+	return a.UID < 0 && a.GID < 0 && a.KeyMode == 0
+}
+
+// SetAccess gives every file of domain's lineage, in live/ and archive/,
+// a's owner and group. Private keys get a.KeyMode and certificates 0644;
+// the lineage's directories can be entered by whoever may read the key,
+// and the store, live/ and archive/ by anyone, without listing them.
+func SetAccess(baseDir, domain string, a Access) error {
+	if a.Zero() {
+		return nil
+	}
+	if err := readonly.Check("change the permissions of " + domain); err != nil {
+		return err
+	}
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keyMode := a.KeyMode
+	if keyMode == 0 {
+		keyMode = 0600
+	}
+	dirMode := os.FileMode(0700)
+	if a.GID >= 0 || keyMode&0040 != 0 {
+		dirMode |= 0050
+	}
+	if keyMode&0004 != 0 {
+		dirMode |= 0005
+	}
+	for _, dir := range []string{baseDir, filepath.Join(baseDir, "live"), filepath.Join(baseDir, "archive")} {
+		st, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if st.Mode().Perm()&0011 != 0011 {
+			if err := os.Chmod(dir, st.Mode().Perm()|0011); err != nil {
+				return err
+			}
+		}
+	}
+	name := LineageName(domain)
+	for _, root := range []string{filepath.Join(baseDir, "live", name), filepath.Join(baseDir, "archive", name)} {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			mode := dirMode
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				return nil
+			case d.IsDir():
+			case strings.HasPrefix(d.Name(), "privkey"):
+				mode = keyMode
+			case strings.HasPrefix(d.Name(), "."):
+				return nil // half-made link or file of a running save
+			default:
+				mode = 0644
+			}
+			if err := os.Chown(p, a.UID, a.GID); err != nil {
+				return err
+			}
+			return os.Chmod(p, mode)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureLineageDir creates a directory of a lineage's files. One that
+// exists keeps its mode, as SetAccess may have opened it to a web server,
+// unless others could write to it.
+func ensureLineageDir(p string) error {
+	if st, err := os.Stat(p); err == nil && st.IsDir() && st.Mode().Perm()&0022 == 0 {
+		return nil
+	}
+	return ensureDir(p, 0700)
+}
@@ -2,11 +2,10 @@ package store
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
 
-	"github.com/trustctl/trusttls/internal/acme"
+	"github.com/trustctl/trusttls/internal/seal"
 )
 
 type AccountCredentials struct {
@@ -20,6 +19,9 @@ type AccountCredentials struct {
 	AccountID       string            `json:"account_id,omitempty"`
 	OrganizationID  string            `json:"organization_id,omitempty"`
 	Provider        string            `json:"provider"` // "letsencrypt" or "digicert"
+	OrderTimeout    string            `json:"order_timeout,omitempty"`  // DigiCert: overall deadline, e.g. "72h"
+	WebhookListen   string            `json:"webhook_listen,omitempty"` // DigiCert: address for order notifications, e.g. ":8444"
+	WebhookPath     string            `json:"webhook_path,omitempty"`
 }
 
 type AccountManager struct {
@@ -42,13 +44,13 @@ func (am *AccountManager) SaveAccount(email string, creds AccountCredentials) er
 		return err
 	}
 
-	return os.WriteFile(credsFile, data, 0600)
+	return seal.WriteFile(credsFile, data, 0600)
 }
 
 func (am *AccountManager) LoadAccount(email, provider string) (*AccountCredentials, error) {
 	credsFile := filepath.Join(am.baseDir, "accounts", provider, email, "credentials.json")
 	
-	data, err := os.ReadFile(credsFile)
+	data, err := seal.ReadFile(credsFile)
 	if err != nil {
 		return nil, err
 	}
@@ -81,25 +83,6 @@ func (am *AccountManager) ListAccounts(provider string) ([]string, error) {
 	return emails, nil
 }
 
-func (am *AccountManager) GetDigiCertACMEConfig(email string) (*acme.DigiCertEABConfig, error) {
-	creds, err := am.LoadAccount(email, "digicert")
-	if err != nil {
-		return nil, err
-	}
-
-	if creds.Provider != "digicert" {
-		return nil, fmt.Errorf("account is not a DigiCert account")
-	}
-
-	return &acme.DigiCertEABConfig{
-		ServerURL:   creds.Server,
-		EABKID:      creds.EABKID,
-		EABHMACKey:  creds.EABHMACKey,
-		Email:       creds.Email,
-		BaseDir:     am.baseDir,
-	}, nil
-}
-
 func (am *AccountManager) SaveDigiCertACMEAccount(email, server, eabKID, eabHMACKey, accountID, organizationID string) error {
 	creds := AccountCredentials{
 		Email:          email,
@@ -114,26 +97,6 @@ func (am *AccountManager) SaveDigiCertACMEAccount(email, server, eabKID, eabHMAC
 	return am.SaveAccount(email, creds)
 }
 
-func (am *AccountManager) GetDigiCertConfig(email string) (*acme.DigiCertConfig, error) {
-	creds, err := am.LoadAccount(email, "digicert")
-	if err != nil {
-		return nil, err
-	}
-
-	if creds.Provider != "digicert" {
-		return nil, fmt.Errorf("account is not a DigiCert account")
-	}
-
-	return &acme.DigiCertConfig{
-		ServerURL:       creds.Server,
-		HMACID:          creds.HMACID,
-		HMACKey:         creds.HMACKey,
-		APIKey:          creds.APIKey,
-		AccountID:       creds.AccountID,
-		OrganizationID:  creds.OrganizationID,
-	}, nil
-}
-
 func (am *AccountManager) SaveDigiCertAccount(email, server, hmacID, hmacKey, apiKey, accountID, organizationID string) error {
 	creds := AccountCredentials{
 		Email:          email,
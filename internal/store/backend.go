@@ -0,0 +1,143 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Bundle is one version of a lineage: its PEM certificate, issuer chain and
+// private key. Key is empty when the key is kept out of the store (--csr,
+// key_sink).
+type Bundle struct {
+	Cert  []byte
+	Chain []byte
+	Key   []byte
+	// OrderURL is the ACME order the certificate was issued for, when
+	// known; it is recorded with the version, not returned by Load
+	OrderURL string
+}
+
+// Fullchain returns the certificate followed by its chain.
+func (b Bundle) Fullchain() []byte {
+	return append(append([]byte{}, b.Cert...), b.Chain...)
+}
+
+// Store keeps the certificates of lineages, by primary domain. Saving,
+// loading and listing them go through it, so a backend other than the
+// directory layout (object storage, a secrets manager, a database, or
+// Memory in tests) can be plugged in with Open.
+type Store interface {
+	// Save stores b as a new version of domain's lineage, makes it the
+	// current one and returns its number.
+	Save(domain string, b Bundle) (int, error)
+	// Load returns the current version of domain's lineage. The error
+	// wraps fs.ErrNotExist when there is none.
+	Load(domain string) (Bundle, error)
+	// List returns the domains of every lineage, sorted.
+	List() ([]string, error)
+	// Archive returns every version of domain's lineage, oldest first.
+	Archive(domain string) ([]Version, error)
+}
+
+// Indexed is a Store that keeps an index of its lineages, so listing and
+// looking them up does not parse every certificate. ListLineages and
+// LoadLineage use it when the Store has one.
+type Indexed interface {
+	Store
+	// Lineages returns every lineage whose certificate can be parsed,
+	// sorted by name.
+	Lineages() ([]Lineage, error)
+	// Lineage returns the named lineage.
+	Lineage(name string) (Lineage, error)
+}
+
+// Open returns the Store kept at baseDir. It is the directory layout of Dir
+// unless replaced with another backend.
+var Open = func(baseDir string) Store { return Dir(baseDir) }
+
+// Dir is the Store in a directory: numbered versions in archive/<name>/ and
+// the current one linked from live/<name>/, as certbot lays them out, which
+// is what web servers and hooks read.
+type Dir string
+
+func (d Dir) Save(domain string, b Bundle) (int, error) {
+	files := map[string][]byte{
+		"cert":      b.Cert,
+		"chain":     b.Chain,
+		"fullchain": b.Fullchain(),
+	}
+	if len(b.Key) > 0 {
+		files["privkey"] = b.Key
+	}
+	meta, err := newMeta(domain, b)
+	if err != nil {
+		return 0, err
+	}
+	files[metaKind] = meta
+	return saveVersion(string(d), LineageName(domain), files)
+}
+
+func (d Dir) Load(domain string) (Bundle, error) {
+	f, err := CurrentFiles(string(d), domain)
+	if err != nil {
+		return Bundle{}, err
+	}
+	read := func(path string) ([]byte, error) {
+		if path == "" {
+			return nil, nil
+		}
+		return os.ReadFile(path)
+	}
+	var b Bundle
+	if b.Cert, err = read(f.Cert); err != nil {
+		return Bundle{}, err
+	}
+	if b.Chain, err = read(f.Chain); err != nil {
+		return Bundle{}, err
+	}
+	if b.Key, err = read(f.PrivKey); err != nil {
+		return Bundle{}, err
+	}
+	return b, nil
+}
+
+func (d Dir) List() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), "live"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, LineageDomain(e.Name()))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (d Dir) Archive(domain string) ([]Version, error) {
+	ns, err := Versions(string(d), domain)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := CurrentVersion(string(d), domain)
+	archive := filepath.Join(string(d), "archive", LineageName(domain))
+	var out []Version
+	for _, n := range ns {
+		if m, err := archivedMeta(string(d), domain, n); err == nil {
+			out = append(out, Version{N: n, Serial: m.Serial, NotBefore: m.NotBefore, NotAfter: m.NotAfter, HasKey: m.HasKey, Current: n == current})
+			continue
+		}
+		certs, err := readCerts(filepath.Join(archive, versionFile("cert", n)))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, versionOf(n, certs[0], fileExists(filepath.Join(archive, versionFile("privkey", n))), n == current))
+	}
+	return out, nil
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archive/<name>/SHA256SUMS lists the SHA-256 of every archived file of the
+// lineage in sha256sum's format, so 'sha256sum -c SHA256SUMS' checks them
+// too. saveVersion adds each version's files as it writes them; anything
+// that changes them afterwards (tampering, a truncated copy, an editor) is
+// found by VerifyLineage.
+
+// ChecksumFile is the name of a lineage's checksum manifest in archive/.
+const ChecksumFile = "SHA256SUMS"
+
+// IntegrityProblem is a file of a lineage that does not match what was
+// recorded for it.
+type IntegrityProblem struct {
+	Path    string // relative to the store
+	Problem string
+	// Unrecorded is set for files without a checksum, kept from before
+	// checksums were recorded: they cannot be checked, but nothing says
+	// they changed.
+	Unrecorded bool
+}
+
+// readChecksums returns the recorded checksums of the archive directory dir
+// by file name. A missing manifest is an empty one.
+func readChecksums(dir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(dir, ChecksumFile))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := map[string]string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		sum, file, ok := strings.Cut(s.Text(), "  ")
+		if !ok {
+			continue
+		}
+		out[file] = sum
+	}
+	return out, s.Err()
+}
+
+// addChecksums records the checksums of files (contents by file name) in
+// the manifest of the archive directory dir. The caller holds the lineage
+// lock; the manifest is replaced atomically.
+func addChecksums(dir string, files map[string][]byte) error {
+	sums, err := readChecksums(dir)
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		sums[name] = checksum(data)
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", sums[name], name)
+	}
+	path := filepath.Join(dir, ChecksumFile)
+	tmp := path + ".tmp"
+	_ = FileSystem.Remove(tmp)
+	if err := writeNew(tmp, b.Bytes()); err != nil {
+		return err
+	}
+	if err := FileSystem.Rename(tmp, path); err != nil {
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	return os.Chmod(path, 0644)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	return hex.EncodeToString(h.Sum(nil)), n, err
+}
+
+// VerifyLineage checks the archived files of domain's lineage against its
+// manifest, and that live/ links to archived files rather than holding
+// files of its own.
+func VerifyLineage(baseDir, domain string) ([]IntegrityProblem, error) {
+	name := LineageName(domain)
+	archive := filepath.Join(baseDir, "archive", name)
+	sums, err := readChecksums(archive)
+	if err != nil {
+		return nil, err
+	}
+	rel := func(file string) string { return filepath.Join("archive", name, file) }
+	var out []IntegrityProblem
+	entries, err := os.ReadDir(archive)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	onDisk := map[string]bool{}
+	for _, e := range entries {
+		if _, ok := archivedVersion(e.Name()); e.IsDir() || !ok {
+			continue
+		}
+		onDisk[e.Name()] = true
+		want, ok := sums[e.Name()]
+		if !ok {
+			out = append(out, IntegrityProblem{Path: rel(e.Name()), Problem: "no checksum recorded", Unrecorded: true})
+			continue
+		}
+		got, size, err := fileChecksum(filepath.Join(archive, e.Name()))
+		if err != nil {
+			out = append(out, IntegrityProblem{Path: rel(e.Name()), Problem: err.Error()})
+			continue
+		}
+		if got != want {
+			problem := "changed since it was stored"
+			if size == 0 {
+				problem = "emptied since it was stored"
+			}
+			out = append(out, IntegrityProblem{Path: rel(e.Name()), Problem: problem})
+		}
+	}
+	var missing []string
+	for file := range sums {
+		if !onDisk[file] {
+			missing = append(missing, file)
+		}
+	}
+	sort.Strings(missing)
+	for _, file := range missing {
+		out = append(out, IntegrityProblem{Path: rel(file), Problem: "missing"})
+	}
+	for _, kind := range liveKinds {
+		path := filepath.Join("live", name, liveFile(kind))
+		st, err := os.Lstat(filepath.Join(baseDir, path))
+		if err != nil {
+			continue
+		}
+		if st.Mode()&os.ModeSymlink == 0 {
+			out = append(out, IntegrityProblem{Path: path, Problem: "replaced by a plain file; it should link to archive/"})
+			continue
+		}
+		target, _ := liveTarget(filepath.Join(baseDir, "live", name), liveFile(kind))
+		if _, ok := archivedVersion(filepath.Base(target)); !ok || filepath.Base(filepath.Dir(target)) != name {
+			out = append(out, IntegrityProblem{Path: path, Problem: "links to " + target + " instead of a file in archive/" + name})
+		}
+	}
+	return out, nil
+}
+
+// VerifyCurrent checks the files of the version domain's live/ links point
+// at, before they are delivered or reused. Files without a recorded
+// checksum pass.
+func VerifyCurrent(baseDir, domain string) error {
+	n, err := CurrentVersion(baseDir, domain)
+	if err != nil || n == 0 {
+		return err
+	}
+	return VerifyVersion(baseDir, domain, n)
+}
+
+// VerifyVersion checks the archived files of version n of domain's lineage
+// and the links in live/, like VerifyCurrent.
+func VerifyVersion(baseDir, domain string, n int) error {
+	problems, err := VerifyLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	var bad []string
+	for _, p := range problems {
+		if p.Unrecorded {
+			continue
+		}
+		if v, ok := archivedVersion(filepath.Base(p.Path)); ok && v != n && strings.HasPrefix(p.Path, "archive") {
+			continue
+		}
+		bad = append(bad, p.Path+": "+p.Problem)
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s failed its integrity check (%s); run 'trusttls verify-store' and restore or reissue it", domain, strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// RecordChecksums adds the files of domain's lineage that have no checksum
+// yet to its manifest, taking them as they are now, and returns how many
+// were added. Files whose checksum is recorded are left alone.
+func RecordChecksums(baseDir, domain string) (int, error) {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	archive := filepath.Join(baseDir, "archive", LineageName(domain))
+	sums, err := readChecksums(archive)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(archive)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	files := map[string][]byte{}
+	for _, e := range entries {
+		if _, ok := archivedVersion(e.Name()); e.IsDir() || !ok {
+			continue
+		}
+		if _, ok := sums[e.Name()]; ok {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(archive, e.Name()))
+		if err != nil {
+			return 0, err
+		}
+		files[e.Name()] = b
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+	return len(files), addChecksums(archive, files)
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Format is the version of the store layout this build reads and writes.
+// Raise it, with a step in formatUpgrades, whenever state changes in a way
+// an older build would misread or damage.
+const Format = 1
+
+// formatUpgrades[n] brings a store from format n to n+1.
+var formatUpgrades = []func(baseDir string) error{
+	// 0: stores from before formats were recorded, possibly with wildcard
+	// lineages under "*." names and timestamped archive folders
+	func(baseDir string) error {
+		_, err := UpgradeLayout(baseDir)
+		return err
+	},
+}
+
+// FormatInfo is what format.json in the store records.
+type FormatInfo struct {
+	Format  int       `json:"format"`
+	Updated time.Time `json:"updated"`
+}
+
+// FormatError is returned for a store written by a newer build, which this
+// one must not change.
+type FormatError struct {
+	Dir   string
+	Found int
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s was written by a newer trusttls (store format %d, this build knows up to %d); upgrade trusttls instead of running an older one against it", e.Dir, e.Found, Format)
+}
+
+// FormatPath returns where the format of the store in baseDir is recorded.
+func FormatPath(baseDir string) string {
+	return filepath.Join(baseDir, "format.json")
+}
+
+// StoredFormat returns the format of the store in baseDir and whether it is
+// recorded. A store without format.json holding certificates, configs or
+// accounts is format 0; one that is empty or missing counts as the current
+// format.
+func StoredFormat(baseDir string) (int, bool, error) {
+	b, err := os.ReadFile(FormatPath(baseDir))
+	if os.IsNotExist(err) {
+		for _, sub := range []string{"live", "archive", "renewal", "accounts"} {
+			if dirExists(filepath.Join(baseDir, sub)) {
+				return 0, false, nil
+			}
+		}
+		return Format, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	var info FormatInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return 0, false, fmt.Errorf("%s: %w", FormatPath(baseDir), err)
+	}
+	return info.Format, true, nil
+}
+
+// FormatUpgrade describes an upgrade OpenFormat made.
+type FormatUpgrade struct {
+	From, To int
+	Backup   string // copy of the store as it was before
+}
+
+// OpenFormat makes sure this build can work with the store in baseDir
+// before anything reads or writes it: a store of a newer format is refused
+// with a *FormatError, an older one is copied next to baseDir and upgraded,
+// and a new one gets the current format recorded. It returns the upgrade
+// made, if any.
+func OpenFormat(baseDir string) (*FormatUpgrade, error) {
+	found, recorded, err := StoredFormat(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case found > Format:
+		return nil, &FormatError{Dir: baseDir, Found: found}
+	case found == Format:
+		if recorded {
+			return nil, nil
+		}
+		if !dirExists(baseDir) {
+			// Recording the format now keeps a store this build starts from
+			// being taken for one from before formats were recorded. With no
+			// store yet there is nothing to protect if that fails.
+			if err := os.MkdirAll(baseDir, 0700); err != nil {
+				return nil, nil
+			}
+		}
+		return nil, writeFormat(baseDir)
+	}
+	up := &FormatUpgrade{From: found, To: Format}
+	up.Backup = fmt.Sprintf("%s.format%d-%s", filepath.Clean(baseDir), found, time.Now().Format("20060102-150405"))
+	if err := copyTree(baseDir, up.Backup); err != nil {
+		_ = os.RemoveAll(up.Backup)
+		return nil, fmt.Errorf("back up %s before upgrading it: %w", baseDir, err)
+	}
+	for n := found; n < Format; n++ {
+		if err := formatUpgrades[n](baseDir); err != nil {
+			return up, fmt.Errorf("upgrade %s from store format %d: %w; the store as it was is in %s", baseDir, n, err, up.Backup)
+		}
+	}
+	return up, writeFormat(baseDir)
+}
+
+func writeFormat(baseDir string) error {
+	b, err := json.MarshalIndent(FormatInfo{Format: Format, Updated: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := FormatPath(baseDir)
+	return WriteFileAtomic(path, b, 0600)
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// FS is the file system certificates are written through. Every write is
+// flushed with Sync before the next step relies on it, so replacing
+// FileSystem with an implementation that fails after a given number of
+// operations simulates a crash at that point.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Remove(name string) error
+	MkdirAll(path string, perm os.FileMode) error
+	// SyncDir flushes dir's entries, making renames, links and new files
+	// in it durable.
+	SyncDir(dir string) error
+}
+
+// File is an open file of an FS.
+type File interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// FileSystem is the FS the store writes to.
+var FileSystem FS = osFS{}
+
+type osFS struct{}
+
+// osFS refuses every change in read-only mode.
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := readonly.Check("write " + name); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(name, flag, perm)
+}
+func (osFS) Rename(oldpath, newpath string) error {
+	if err := readonly.Check("rename " + oldpath); err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
+func (osFS) Symlink(oldname, newname string) error {
+	if err := readonly.Check("link " + newname); err != nil {
+		return err
+	}
+	return os.Symlink(oldname, newname)
+}
+func (osFS) Remove(name string) error {
+	if err := readonly.Check("remove " + name); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := readonly.Check("create " + path); err != nil {
+		return err
+	}
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// WriteFileAtomic replaces path with data through FileSystem: the data goes
+// to a temporary file next to it, is flushed, and is renamed over path, so
+// readers and a crash see the old file or the new one, never part of it.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	defer interrupt.Hold()()
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	_ = FileSystem.Remove(tmp)
+	f, err := FileSystem.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	if err := FileSystem.Rename(tmp, path); err != nil {
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	return FileSystem.SyncDir(filepath.Dir(path))
+}
+
+// saveMus serialise saves per lineage, so two renewals of one lineage in
+// this process cannot pick the same version number or interleave their
+// links. They are taken after the lineage's file lock, so a save waiting on
+// another process holds up only that lineage.
+var (
+	saveMusMu sync.Mutex
+	saveMus   = map[string]*sync.Mutex{}
+)
+
+// lockSave takes the save mutex of the lineage name and returns the
+// function releasing it.
+func lockSave(name string) func() {
+	saveMusMu.Lock()
+	mu, ok := saveMus[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		saveMus[name] = mu
+	}
+	saveMusMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// syncParents flushes dir and its parents up to and including baseDir, so a
+// directory created for a new lineage survives a crash along with its files.
+func syncParents(baseDir, dir string) error {
+	for {
+		if err := FileSystem.SyncDir(dir); err != nil {
+			return err
+		}
+		if dir == baseDir || filepath.Dir(dir) == dir {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
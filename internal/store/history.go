@@ -0,0 +1,116 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+)
+
+// archive/<name>/history.jsonl records how each version of the lineage was
+// obtained, one JSON line per version, for audits: the CA and account, the
+// ACME order, how and when each name was validated, and the serial that
+// resulted. It is only ever appended to and holds nothing secret: no keys,
+// challenge tokens or credentials.
+
+// HistoryFile is the name of a lineage's issuance record in archive/.
+const HistoryFile = "history.jsonl"
+
+// Issuance is how one version of a lineage was obtained.
+type Issuance struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`               // when it was stored
+	Provider string    `json:"provider,omitempty"` // issuer, e.g. letsencrypt, digicert, external
+	CA       string    `json:"ca,omitempty"`       // ACME directory URL
+	Account  string    `json:"account,omitempty"`  // ACME account URL
+	OrderURL string    `json:"order_url,omitempty"`
+	// Source is the file or tool an imported certificate came from
+	Source      string       `json:"source,omitempty"`
+	Validations []Validation `json:"validations,omitempty"`
+	Serial      string       `json:"serial"`
+	NotAfter    time.Time    `json:"not_after"`
+}
+
+// Validation is how one name of an ACME order was validated.
+type Validation struct {
+	Name      string `json:"name"`
+	Challenge string `json:"challenge,omitempty"` // e.g. http-01, dns-01
+	// Validated is when the CA validated the name, when it said
+	Validated *time.Time `json:"validated,omitempty"`
+	// Reused is set when the CA relied on an earlier validation of the
+	// name and no challenge was solved
+	Reused bool `json:"reused,omitempty"`
+}
+
+// RecordIssuance appends i to the history of domain's lineage as the
+// version live/ points at now, the one just saved, taking its serial and
+// expiry from that version's certificate.
+func RecordIssuance(baseDir, domain string, i Issuance) error {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer interrupt.Hold()()
+	if i.Version, err = CurrentVersion(baseDir, domain); err != nil {
+		return err
+	}
+	if m, err := ReadMeta(baseDir, domain); err == nil {
+		i.Serial, i.NotAfter = m.Serial, m.NotAfter
+	} else if certs, err := readCerts(filepath.Join(baseDir, "live", LineageName(domain), "cert.pem")); err == nil {
+		i.Serial, i.NotAfter = fmt.Sprintf("%x", certs[0].SerialNumber), certs[0].NotAfter
+	}
+	if i.Time.IsZero() {
+		i.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	archive := filepath.Join(baseDir, "archive", LineageName(domain))
+	if err := ensureLineageDir(archive); err != nil {
+		return err
+	}
+	f, err := FileSystem.OpenFile(filepath.Join(archive, HistoryFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// History returns the recorded issuances of domain's lineage, oldest
+// first. Lineages from before it was recorded have none.
+func History(baseDir, domain string) ([]Issuance, error) {
+	f, err := os.Open(filepath.Join(baseDir, "archive", LineageName(domain), HistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Issuance
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	for s.Scan() {
+		var i Issuance
+		if err := json.Unmarshal(s.Bytes(), &i); err != nil {
+			// A line cut short by a crash; the others still count
+			continue
+		}
+		out = append(out, i)
+	}
+	return out, s.Err()
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// index.json caches what listing needs from each lineage's certificate, so
+// listing the store, the API and finding due renewals stat cert.pem instead
+// of parsing every PEM. An entry is only used while cert.pem still links to
+// the same file with the same size and modification time; anything else is
+// parsed again and the entry rebuilt. The index is never the source of
+// truth and may be deleted at any time.
+
+// RenewalResult is the outcome of the latest renewal of a lineage.
+type RenewalResult struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"` // empty when it succeeded
+}
+
+type indexEntry struct {
+	// Stamp of cert.pem when the entry was built
+	Target  string    `json:"target,omitempty"` // symlink target; empty for plain files
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	Names     []string  `json:"names"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Serial    string    `json:"serial"`
+
+	Provider    string         `json:"provider,omitempty"`
+	LastRenewal *RenewalResult `json:"last_renewal,omitempty"`
+}
+
+// indexMu serializes updates of index.json from renewal workers in this
+// process. Other processes may overwrite an update; the stamp check makes
+// that cost a parse, never a wrong answer.
+var indexMu sync.Mutex
+
+// IndexPath returns where the lineage index of baseDir is kept.
+func IndexPath(baseDir string) string {
+	return filepath.Join(baseDir, "index.json")
+}
+
+// loadIndex returns the index of baseDir by lineage directory name. A
+// missing or damaged index is an empty one.
+func loadIndex(baseDir string) map[string]indexEntry {
+	idx := map[string]indexEntry{}
+	b, err := os.ReadFile(IndexPath(baseDir))
+	if err != nil {
+		return idx
+	}
+	if json.Unmarshal(b, &idx) != nil {
+		return map[string]indexEntry{}
+	}
+	return idx
+}
+
+// saveIndex writes idx. Failing to is not an error for the caller: the
+// next run parses what it cannot find, so read-only mode and read-only
+// stores simply go without.
+func saveIndex(baseDir string, idx map[string]indexEntry) {
+	if readonly.Enabled() {
+		return
+	}
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return
+	}
+	p := IndexPath(baseDir)
+	tmp, err := os.CreateTemp(baseDir, ".index-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, werr := tmp.Write(b)
+	if werr == nil {
+		werr = tmp.Sync()
+	}
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), p)
+}
+
+// stamp fills the cert.pem stamp of e for the lineage directory dir.
+func stamp(dir string, e *indexEntry) error {
+	cert := filepath.Join(dir, "cert.pem")
+	st, err := os.Stat(cert)
+	if err != nil {
+		return err
+	}
+	e.Target, _ = liveTarget(dir, "cert.pem")
+	e.Size, e.ModTime = st.Size(), st.ModTime()
+	return nil
+}
+
+// fresh reports whether e still describes the certificate in dir.
+func (e indexEntry) fresh(dir string) bool {
+	var now indexEntry
+	if stamp(dir, &now) != nil {
+		return false
+	}
+	return now.Target == e.Target && now.Size == e.Size && now.ModTime.Equal(e.ModTime)
+}
+
+func (e indexEntry) lineage(name, dir string) Lineage {
+	return Lineage{
+		Name: name, Dir: dir, Names: e.Names, NotBefore: e.NotBefore, NotAfter: e.NotAfter, Serial: e.Serial,
+		Provider: e.Provider, LastRenewal: e.LastRenewal,
+	}
+}
+
+// indexedLineage returns the lineage name from idx, or parses its
+// certificate and updates idx. It reports whether idx changed.
+func indexedLineage(baseDir, name string, idx map[string]indexEntry) (Lineage, bool, error) {
+	key := LineageName(name)
+	dir := filepath.Join(baseDir, "live", key)
+	e, ok := idx[key]
+	if ok && e.fresh(dir) {
+		return e.lineage(name, dir), false, nil
+	}
+	var fresh indexEntry
+	if err := stamp(dir, &fresh); err != nil {
+		return Lineage{}, false, err
+	}
+	l, err := parseLineage(baseDir, name)
+	if err != nil {
+		return Lineage{}, false, err
+	}
+	fresh.Names, fresh.NotBefore, fresh.NotAfter, fresh.Serial = l.Names, l.NotBefore, l.NotAfter, l.Serial
+	fresh.Provider, fresh.LastRenewal = e.Provider, e.LastRenewal
+	idx[key] = fresh
+	return fresh.lineage(name, dir), true, nil
+}
+
+// Lineages lists the lineages of d from index.json; certificates unchanged
+// since they were indexed are not parsed again.
+func (d Dir) Lineages() ([]Lineage, error) {
+	baseDir := string(d)
+	names, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	idx := loadIndex(baseDir)
+	dirty := false
+	var out []Lineage
+	for _, name := range names {
+		l, changed, err := indexedLineage(baseDir, name, idx)
+		if err != nil {
+			continue
+		}
+		dirty = dirty || changed
+		out = append(out, l)
+	}
+	if dirty {
+		saveIndex(baseDir, idx)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Lineage reads the named lineage of d from index.json while its entry is
+// up to date.
+func (d Dir) Lineage(name string) (Lineage, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	idx := loadIndex(string(d))
+	l, changed, err := indexedLineage(string(d), name, idx)
+	if changed {
+		saveIndex(string(d), idx)
+	}
+	return l, err
+}
+
+// reindex refreshes the index entry of name after its certificate changed.
+func reindex(baseDir, name string) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	idx := loadIndex(baseDir)
+	if _, changed, err := indexedLineage(baseDir, LineageDomain(name), idx); err == nil && changed {
+		saveIndex(baseDir, idx)
+	}
+}
+
+// NoteRenewal records in the index which provider last renewed the lineage
+// name and how that went, for listings.
+func NoteRenewal(baseDir, name, provider string, renewErr error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	idx := loadIndex(baseDir)
+	if _, _, err := indexedLineage(baseDir, name, idx); err != nil {
+		return
+	}
+	e := idx[LineageName(name)]
+	e.Provider = provider
+	e.LastRenewal = &RenewalResult{Time: time.Now().UTC()}
+	if renewErr != nil {
+		e.LastRenewal.Error = renewErr.Error()
+	}
+	idx[LineageName(name)] = e
+	saveIndex(baseDir, idx)
+}
+
+// DropIndex removes the index entry of a deleted lineage.
+func DropIndex(baseDir, name string) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	idx := loadIndex(baseDir)
+	if _, ok := idx[LineageName(name)]; ok {
+		delete(idx, LineageName(name))
+		saveIndex(baseDir, idx)
+	}
+}
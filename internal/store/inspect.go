@@ -0,0 +1,211 @@
+package store
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// oidTLSFeature is the TLS Feature extension that carries OCSP Must-Staple.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// Details describe the live certificate of a lineage and the files it is
+// kept in, for inspect.
+type Details struct {
+	Lineage
+	Version            int       `json:"version,omitempty"` // archive version live/ points at; 0 for stores without versions
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SHA256             string    `json:"sha256_fingerprint"`
+	SHA1               string    `json:"sha1_fingerprint"`
+	KeyAlgorithm       string    `json:"key_algorithm"` // e.g. "RSA 2048" or "ECDSA P-256"
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	MustStaple         bool      `json:"must_staple,omitempty"`
+	OCSP               []string  `json:"ocsp,omitempty"`
+	CRL                []string  `json:"crl,omitempty"`
+	CAIssuers          []string  `json:"ca_issuers,omitempty"`
+	OrderURL           string    `json:"order_url,omitempty"` // ACME order, from meta.json
+	Chain              []Subject `json:"chain"` // chain.pem, in order
+	Files              Files     `json:"files"`
+	// KeyMatches tells whether privkey.pem belongs to the certificate; nil
+	// when the store has no key for it (--csr, --key-sink) or it cannot be
+	// read, which KeyError then says.
+	KeyMatches *bool  `json:"key_matches"`
+	KeyError   string `json:"key_error,omitempty"`
+}
+
+// Subject is a certificate of a chain.
+type Subject struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// Files are the paths of a lineage's PEM files; a file the lineage does not
+// have is left empty.
+type Files struct {
+	Cert      string `json:"cert"`
+	Chain     string `json:"chain,omitempty"`
+	Fullchain string `json:"fullchain,omitempty"`
+	PrivKey   string `json:"privkey,omitempty"`
+}
+
+// Inspect reads the live certificate of the lineage name in baseDir with
+// its chain, and checks its private key against it.
+func Inspect(baseDir, name string) (*Details, error) {
+	l, err := LoadLineage(baseDir, name)
+	if err != nil {
+		return nil, err
+	}
+	certPath, keyPath, chainPath, fullchainPath := LoadCertPaths(baseDir, name)
+	certs, err := readCerts(certPath)
+	if err != nil {
+		return nil, err
+	}
+	c := certs[0]
+	sum256, sum1 := sha256.Sum256(c.Raw), sha1.Sum(c.Raw)
+	d := &Details{
+		Lineage:            l,
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		SHA256:             fingerprint(sum256[:]),
+		SHA1:               fingerprint(sum1[:]),
+		KeyAlgorithm:       keyAlgorithm(c.PublicKey),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		OCSP:               c.OCSPServer,
+		CRL:                c.CRLDistributionPoints,
+		CAIssuers:          c.IssuingCertificateURL,
+		Chain:              []Subject{},
+		Files:              Files{Cert: certPath},
+	}
+	d.Version, _ = CurrentVersion(baseDir, name)
+	if m, err := ReadMeta(baseDir, name); err == nil {
+		d.OrderURL = m.OrderURL
+	}
+	for _, ext := range c.Extensions {
+		if ext.Id.Equal(oidTLSFeature) {
+			d.MustStaple = true
+		}
+	}
+	if fileExists(chainPath) {
+		d.Files.Chain = chainPath
+		chain, err := readCerts(chainPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, ic := range chain {
+			d.Chain = append(d.Chain, Subject{Subject: ic.Subject.String(), Issuer: ic.Issuer.String(), NotAfter: ic.NotAfter})
+		}
+	}
+	if fileExists(fullchainPath) {
+		d.Files.Fullchain = fullchainPath
+	}
+	if fileExists(keyPath) {
+		d.Files.PrivKey = keyPath
+		// tls checks that the key is the certificate's, for every key type
+		_, kerr := tls.LoadX509KeyPair(certPath, keyPath)
+		var keyErr error
+		if kerr != nil {
+			// Only a key that parses but belongs elsewhere is a mismatch
+			keyErr = checkKeyFile(keyPath)
+		}
+		if keyErr != nil {
+			d.KeyError = keyErr.Error()
+		} else {
+			matches := kerr == nil
+			d.KeyMatches = &matches
+		}
+	}
+	return d, nil
+}
+
+// readCerts returns the certificates in the PEM file at path.
+func readCerts(path string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCerts(b, path)
+}
+
+// parseCerts returns the certificates in PEM data b, read from source.
+func parseCerts(b []byte, source string) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s: no certificate", source)
+	}
+	return out, nil
+}
+
+// checkKeyFile returns why the PEM private key at path cannot be used at
+// all, or nil when it parses.
+func checkKeyFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM private key", filepath.Base(path))
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	return errors.New(filepath.Base(path) + ": unreadable private key")
+}
+
+// fingerprint formats a digest the way openssl x509 -fingerprint does.
+func fingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// keyAlgorithm names the type and size of pub.
+func keyAlgorithm(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", pub)
+}
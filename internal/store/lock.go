@@ -0,0 +1,96 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/readonly"
+)
+
+// Changes to a lineage are made under an exclusive lock on
+// locks/<name>.lock, so a scheduled renew and a manual run, or two agents
+// sharing a store, cannot interleave their writes. The lock is released by
+// the kernel when the process dies; a stale lock file is harmless.
+
+// LockTimeout is how long LockLineage waits for another run to release a
+// lineage.
+var LockTimeout = 10 * time.Minute
+
+// ErrLocked is returned by tryLock and TryLockFile when another file handle
+// holds the lock.
+var ErrLocked = errors.New("locked")
+
+// LockPath returns the lock file of the lineage name.
+func LockPath(baseDir, name string) string {
+	return filepath.Join(baseDir, "locks", LineageName(name)+".lock")
+}
+
+// LockLineage takes the lock of the lineage name and returns the function
+// releasing it. Handles are not shared: locking a lineage twice in one
+// process waits for the first lock like any other run.
+func LockLineage(baseDir, name string) (func(), error) {
+	if err := readonly.Check("lock " + name); err != nil {
+		return nil, err
+	}
+	p := LockPath(baseDir, name)
+	if err := ensureDir(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(LockTimeout)
+	waiting := false
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLocked) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", p, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s is still locked by another trusttls run after %s (%s)", name, LockTimeout, p)
+		}
+		if !waiting {
+			fmt.Printf("⏳ %s: waiting for another trusttls run to finish with it\n", name)
+			waiting = true
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return func() {
+		_ = unlock(f)
+		f.Close()
+	}, nil
+}
+
+// TryLockFile takes an exclusive lock on the file p, creating it, without
+// waiting, and returns the function releasing it. While another handle
+// holds the lock it fails with ErrLocked. Like lineage locks, the lock is
+// released by the kernel when the process dies.
+func TryLockFile(p string) (func(), error) {
+	if err := ensureDir(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("lock %s: %w", p, err)
+	}
+	return func() {
+		_ = unlock(f)
+		f.Close()
+	}, nil
+}
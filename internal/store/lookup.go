@@ -0,0 +1,332 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Match says how a lineage covers a hostname. Higher is better.
+type Match int
+
+const (
+	NoMatch Match = iota
+	// MatchSAN: another name on the certificate covers the host.
+	MatchSAN
+	// MatchWildcard: the lineage's primary name is a wildcard covering the host.
+	MatchWildcard
+	// MatchExact: the lineage is named after the host.
+	MatchExact
+)
+
+func (m Match) String() string {
+	switch m {
+	case MatchExact:
+		return "exact"
+	case MatchWildcard:
+		return "wildcard"
+	case MatchSAN:
+		return "san"
+	}
+	return "none"
+}
+
+// Lineage is one certificate kept under live/.
+type Lineage struct {
+	Name      string    `json:"name"` // primary domain; see LineageName for the directory
+	Dir       string    `json:"dir"`
+	Names     []string  `json:"names"` // DNS names and IP addresses on the certificate
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Serial    string    `json:"serial"`
+	// From the index: the provider and outcome of the latest renewal run
+	// by this host, when there was one
+	Provider    string         `json:"provider,omitempty"`
+	LastRenewal *RenewalResult `json:"last_renewal,omitempty"`
+}
+
+// Fullchain and PrivateKey return the lineage's file paths.
+func (l Lineage) Fullchain() string  { return filepath.Join(l.Dir, "fullchain.pem") }
+func (l Lineage) PrivateKey() string { return filepath.Join(l.Dir, "privkey.pem") }
+
+// Covers reports how well the lineage matches host.
+func (l Lineage) Covers(host string) Match {
+	host = normalizeHost(host)
+	if host == "" {
+		return NoMatch
+	}
+	primary := strings.ToLower(l.Name)
+	if primary == host {
+		return MatchExact
+	}
+	if strings.HasPrefix(primary, "*.") && wildcardMatches(primary, host) {
+		return MatchWildcard
+	}
+	for _, n := range l.Names {
+		n = strings.ToLower(n)
+		if n == host || wildcardMatches(n, host) {
+			return MatchSAN
+		}
+	}
+	return NoMatch
+}
+
+// ListLineages returns every lineage of the Store at baseDir whose
+// certificate can be parsed, sorted by name. A Store that is Indexed
+// answers from its index.
+func ListLineages(baseDir string) ([]Lineage, error) {
+	s := Open(baseDir)
+	if ix, ok := s.(Indexed); ok {
+		return ix.Lineages()
+	}
+	names, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var out []Lineage
+	for _, name := range names {
+		if l, err := storedLineage(s, name); err == nil {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// LoadLineage reads the current certificate of the named lineage from the
+// Store at baseDir, or from its index when it is Indexed.
+func LoadLineage(baseDir, name string) (Lineage, error) {
+	s := Open(baseDir)
+	if ix, ok := s.(Indexed); ok {
+		return ix.Lineage(name)
+	}
+	return storedLineage(s, name)
+}
+
+// storedLineage reads the named lineage from a Store without an index by
+// parsing its current certificate. Lineages of a Store other than the
+// directory layout have no files, so Dir is empty.
+func storedLineage(s Store, name string) (Lineage, error) {
+	b, err := s.Load(name)
+	if err != nil {
+		return Lineage{}, err
+	}
+	certs, err := parseCerts(b.Cert, name)
+	if err != nil {
+		return Lineage{}, err
+	}
+	c := certs[0]
+	return Lineage{Name: name, Names: certNames(c), NotBefore: c.NotBefore, NotAfter: c.NotAfter, Serial: fmt.Sprintf("%x", c.SerialNumber)}, nil
+}
+
+// parseLineage reads the named lineage from its meta.json, or from its
+// cert.pem when the version has none.
+func parseLineage(baseDir, name string) (Lineage, error) {
+	dir := filepath.Join(baseDir, "live", LineageName(name))
+	if m, err := ReadMeta(baseDir, name); err == nil {
+		return Lineage{Name: name, Dir: dir, Names: m.Names, NotBefore: m.NotBefore, NotAfter: m.NotAfter, Serial: m.Serial}, nil
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		return Lineage{}, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return Lineage{}, fmt.Errorf("%s: no pem block", name)
+	}
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return Lineage{}, err
+	}
+	return Lineage{Name: name, Dir: dir, Names: certNames(c), NotBefore: c.NotBefore, NotAfter: c.NotAfter, Serial: fmt.Sprintf("%x", c.SerialNumber)}, nil
+}
+
+// certNames returns the DNS names and IP addresses c is for, or its common
+// name when it has neither.
+func certNames(c *x509.Certificate) []string {
+	var names []string
+	names = append(names, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && c.Subject.CommonName != "" {
+		names = []string{c.Subject.CommonName}
+	}
+	return names
+}
+
+// FindLineage returns the lineage that best serves host: an exact name match
+// beats a wildcard lineage, which beats a certificate listing the host among
+// its other names. Among equal matches an unexpired certificate wins, then
+// the one that expires last.
+func FindLineage(baseDir, host string) (Lineage, Match, error) {
+	all, err := ListLineages(baseDir)
+	if err != nil {
+		return Lineage{}, NoMatch, err
+	}
+	return BestLineage(all, host)
+}
+
+// BestLineage picks the lineage among all that best serves host, by the
+// rules of FindLineage.
+func BestLineage(all []Lineage, host string) (Lineage, Match, error) {
+	var best Lineage
+	bestMatch := NoMatch
+	now := time.Now()
+	for _, l := range all {
+		m := l.Covers(host)
+		if m == NoMatch {
+			continue
+		}
+		if bestMatch == NoMatch || better(l, m, best, bestMatch, now) {
+			best, bestMatch = l, m
+		}
+	}
+	if bestMatch == NoMatch {
+		return Lineage{}, NoMatch, fmt.Errorf("no managed certificate covers %s", host)
+	}
+	return best, bestMatch, nil
+}
+
+func better(l Lineage, m Match, cur Lineage, curMatch Match, now time.Time) bool {
+	if m != curMatch {
+		return m > curMatch
+	}
+	if valid, curValid := now.Before(l.NotAfter), now.Before(cur.NotAfter); valid != curValid {
+		return valid
+	}
+	return l.NotAfter.After(cur.NotAfter)
+}
+
+// Overlapping returns the lineages among all that carry one of names, so a
+// new certificate for names would cover some of the same names twice.
+func Overlapping(all []Lineage, names []string) []Lineage {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[normalizeHost(n)] = true
+	}
+	var out []Lineage
+	for _, l := range all {
+		for _, n := range append([]string{l.Name}, l.Names...) {
+			if want[normalizeHost(n)] {
+				out = append(out, l)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// wildcardMatches reports whether pattern "*.example.com" covers host. The
+// wildcard stands for exactly one label, as in RFC 6125.
+func wildcardMatches(pattern, host string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	i := strings.IndexByte(host, '.')
+	return i > 0 && host[i+1:] == pattern[2:]
+}
+
+// ServesName reports whether a name from a web server config (ServerName,
+// ServerAlias, server_name) belongs to the site of a certificate for domain:
+// the same name or, for a wildcard domain, a name the wildcard covers.
+func ServesName(domain, name string) bool {
+	domain, name = normalizeHost(domain), normalizeHost(name)
+	return name == domain || wildcardMatches(domain, name)
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// GetCertificateFunc returns a tls.Config.GetCertificate callback that
+// serves, for each SNI name, the best matching lineage in baseDir. Key pairs
+// are cached and reloaded when the lineage's files change, so renewals are
+// picked up without a restart. Clients that send no SNI get fallback, when
+// set.
+//
+// The lineage picked for each name is cached too, so a handshake does not
+// list the store. The picks are dropped whenever index.json changes, which
+// every save, rollback and delete rewrites, and a pick is made again once
+// its certificate expires, when another lineage may serve the name better.
+func GetCertificateFunc(baseDir, fallback string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	type entry struct {
+		path string
+		mod  time.Time
+		cert *tls.Certificate
+	}
+	type pick struct {
+		name  string
+		until time.Time // zero when the pick cannot go stale by expiring
+	}
+	var mu sync.Mutex
+	cache := map[string]entry{}
+	picks := map[string]pick{}
+	var indexMod time.Time
+	lineageFor := func(host string) (string, error) {
+		var mod time.Time
+		if st, err := os.Stat(IndexPath(baseDir)); err == nil {
+			mod = st.ModTime()
+		}
+		now := time.Now()
+		mu.Lock()
+		if !mod.Equal(indexMod) {
+			picks, indexMod = map[string]pick{}, mod
+		}
+		p, ok := picks[host]
+		mu.Unlock()
+		if ok && (p.until.IsZero() || now.Before(p.until)) {
+			return p.name, nil
+		}
+		l, _, err := FindLineage(baseDir, host)
+		if err != nil {
+			return "", err
+		}
+		p = pick{name: l.Name}
+		if now.Before(l.NotAfter) {
+			p.until = l.NotAfter
+		}
+		mu.Lock()
+		if mod.Equal(indexMod) {
+			picks[host] = p
+		}
+		mu.Unlock()
+		return p.name, nil
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		host := hello.ServerName
+		if host == "" {
+			host = fallback
+		}
+		name, err := lineageFor(normalizeHost(host))
+		if err != nil {
+			return nil, err
+		}
+		// The archived files, so a renewal switching the links cannot
+		// pair a certificate with the key of another version
+		f, err := CurrentFiles(baseDir, name)
+		if err != nil {
+			return nil, err
+		}
+		st, err := os.Stat(f.Fullchain)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if e, ok := cache[name]; ok && e.path == f.Fullchain && e.mod.Equal(st.ModTime()) {
+			return e.cert, nil
+		}
+		kp, err := tls.LoadX509KeyPair(f.Fullchain, f.PrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", name, err)
+		}
+		cache[name] = entry{path: f.Fullchain, mod: st.ModTime(), cert: &kp}
+		return &kp, nil
+	}
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// Memory is a Store that keeps lineages in memory, for tests and for
+// callers that only need certificates for the life of the process.
+type Memory struct {
+	mu       sync.Mutex
+	lineages map[string]*memoryLineage
+}
+
+type memoryLineage struct {
+	versions []Bundle
+	current  int // index into versions
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{lineages: map[string]*memoryLineage{}}
+}
+
+func (m *Memory) Save(domain string, b Bundle) (int, error) {
+	if _, err := parseCerts(b.Cert, domain); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lineages[domain]
+	if l == nil {
+		l = &memoryLineage{}
+		m.lineages[domain] = l
+	}
+	b = Bundle{Cert: clone(b.Cert), Chain: clone(b.Chain), Key: clone(b.Key)}
+	l.versions = append(l.versions, b)
+	l.current = len(l.versions) - 1
+	return len(l.versions), nil
+}
+
+func (m *Memory) Load(domain string) (Bundle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lineages[domain]
+	if l == nil {
+		return Bundle{}, fmt.Errorf("no certificate stored for %s: %w", domain, fs.ErrNotExist)
+	}
+	b := l.versions[l.current]
+	return Bundle{Cert: clone(b.Cert), Chain: clone(b.Chain), Key: clone(b.Key)}, nil
+}
+
+func (m *Memory) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, 0, len(m.lineages))
+	for domain := range m.lineages {
+		out = append(out, domain)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (m *Memory) Archive(domain string) ([]Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lineages[domain]
+	if l == nil {
+		return nil, nil
+	}
+	var out []Version
+	for i, b := range l.versions {
+		certs, err := parseCerts(b.Cert, domain)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, versionOf(i+1, certs[0], len(b.Key) > 0, i == l.current))
+	}
+	return out, nil
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
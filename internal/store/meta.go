@@ -0,0 +1,118 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Each version is archived with meta<N>.json, what listings and renewal
+// need from its certificate, written when it is saved and linked from
+// live/<name>/meta.json like the PEM files, so reading a lineage does not
+// mean parsing its PEMs. Versions saved before it existed have none and are
+// parsed as before.
+
+// MetaFile is the name of the metadata link in live/<name>/.
+const MetaFile = "meta.json"
+
+const metaKind = "meta"
+
+// liveKinds are the files linked from live/<name>/.
+var liveKinds = append(append([]string{}, pemKinds...), metaKind)
+
+var metaFileRe = regexp.MustCompile(`^meta(\d+)\.json$`)
+
+// Meta describes the certificate of a version.
+type Meta struct {
+	Names     []string  `json:"names"` // DNS names and IP addresses
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Issuer    string    `json:"issuer"`
+	KeyType   string    `json:"key_type"` // e.g. "RSA 2048" or "ECDSA P-256"
+	HasKey    bool      `json:"has_key"`
+	// OrderURL is the ACME order the certificate was issued for; empty for
+	// other issuers and imports
+	OrderURL string    `json:"order_url,omitempty"`
+	Saved    time.Time `json:"saved"`
+
+	// Version is the archive version the metadata belongs to, from its
+	// file name
+	Version int `json:"-"`
+}
+
+// newMeta describes the certificate in b.
+func newMeta(domain string, b Bundle) ([]byte, error) {
+	certs, err := parseCerts(b.Cert, domain)
+	if err != nil {
+		return nil, err
+	}
+	c := certs[0]
+	m := Meta{
+		Names:     certNames(c),
+		Serial:    fmt.Sprintf("%x", c.SerialNumber),
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		Issuer:    c.Issuer.String(),
+		KeyType:   keyAlgorithm(c.PublicKey),
+		HasKey:    len(b.Key) > 0,
+		OrderURL:  b.OrderURL,
+		Saved:     time.Now().UTC(),
+	}
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// ReadMeta returns the metadata of the version live/ of domain's lineage
+// points at. It fails with an error satisfying os.IsNotExist when that
+// version has none, or when the links are between versions.
+func ReadMeta(baseDir, domain string) (*Meta, error) {
+	live := filepath.Join(baseDir, "live", LineageName(domain))
+	target, err := liveTarget(live, MetaFile)
+	if err != nil {
+		return nil, err
+	}
+	m := metaFileRe.FindStringSubmatch(filepath.Base(target))
+	if m == nil {
+		return nil, fmt.Errorf("%s: unexpected link target %s", domain, target)
+	}
+	n, _ := strconv.Atoi(m[1])
+	if current, err := CurrentVersion(baseDir, domain); err != nil || current != n {
+		return nil, os.ErrNotExist
+	}
+	return archivedMeta(baseDir, domain, n)
+}
+
+// archivedMeta returns the metadata of version n of domain's lineage.
+func archivedMeta(baseDir, domain string, n int) (*Meta, error) {
+	return readMetaFile(filepath.Join(baseDir, "archive", LineageName(domain), versionFile(metaKind, n)), n)
+}
+
+func readMetaFile(path string, n int) (*Meta, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Meta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.Version = n
+	return &m, nil
+}
+
+// archivedVersion returns the version an archived file belongs to.
+func archivedVersion(file string) (int, bool) {
+	if m := versionFileRe.FindStringSubmatch(file); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return n, true
+	}
+	if m := metaFileRe.FindStringSubmatch(file); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/trustctl/trusttls/internal/confdir"
+)
+
+// MigrateResult lists what Migrate changed.
+type MigrateResult struct {
+	Moved     bool     // the tree was renamed rather than copied
+	Rewritten []string // files whose paths pointed into the old base dir
+	Renamed   []string // entries renamed by layout upgrades
+}
+
+// Migrate moves the store from one base directory to another: the tree is
+// renamed (or copied and removed when on another file system), absolute
+// paths into from inside configs are rewritten to to, older layouts are
+// upgraded, and permissions are reset to 0700 for directories and 0600 for
+// files. to must not exist or be empty. When from holds only the state of a
+// store whose settings are elsewhere (see confdir), the settings stay and
+// their paths into from are rewritten too.
+func Migrate(from, to string) (MigrateResult, error) {
+	var res MigrateResult
+	from, to, err := migrationPaths(from, to)
+	if err != nil {
+		return res, err
+	}
+	if st, err := os.Lstat(to); err == nil {
+		if !st.IsDir() {
+			return res, fmt.Errorf("%s exists and is not a directory", to)
+		}
+		if err := os.Remove(to); err != nil {
+			return res, fmt.Errorf("%s is not empty", to)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return res, err
+	}
+	if err := os.Rename(from, to); err == nil {
+		res.Moved = true
+	} else {
+		if err := copyTree(from, to); err != nil {
+			_ = os.RemoveAll(to)
+			return res, fmt.Errorf("copy %s to %s: %w", from, to, err)
+		}
+		if err := os.RemoveAll(from); err != nil {
+			return res, fmt.Errorf("copied to %s but could not remove %s: %w", to, from, err)
+		}
+	}
+	if res.Renamed, err = UpgradeLayout(to); err != nil {
+		return res, err
+	}
+	err = filepath.WalkDir(to, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if moved := RewritePath(target, from, to); moved != target {
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+				return os.Symlink(moved, p)
+			}
+			return nil
+		case d.IsDir():
+			return os.Chmod(p, 0700)
+		}
+		if err := os.Chmod(p, 0600); err != nil {
+			return err
+		}
+		if !rewritable(p) {
+			return nil
+		}
+		changed, err := RewriteFile(p, from, to)
+		if changed {
+			res.Rewritten = append(res.Rewritten, p)
+		}
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	settings := confdir.Of(from)
+	if settings == from {
+		return res, nil
+	}
+	// The settings of a split store stay where they are, but their
+	// state_dir and base_dir name the directory that moved
+	err = filepath.WalkDir(settings, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (p == from || p == to) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || !rewritable(p) {
+			return nil
+		}
+		changed, err := RewriteFile(p, from, to)
+		if changed {
+			res.Rewritten = append(res.Rewritten, p)
+		}
+		return err
+	})
+	return res, err
+}
+
+func migrationPaths(from, to string) (string, string, error) {
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return "", "", err
+	}
+	to, err = filepath.Abs(to)
+	if err != nil {
+		return "", "", err
+	}
+	if !dirExists(from) {
+		return "", "", fmt.Errorf("%s does not exist", from)
+	}
+	if from == to {
+		return "", "", errors.New("source and destination are the same")
+	}
+	if strings.HasPrefix(to, from+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%s is inside %s", to, from)
+	}
+	return from, to, nil
+}
+
+// rewritable reports whether p is a config file that may hold store paths.
+// Certificates and keys are left alone.
+func rewritable(p string) bool {
+	switch filepath.Ext(p) {
+	case ".yaml", ".yml", ".json", ".ini", ".conf":
+		return true
+	}
+	return false
+}
+
+// RewritePath replaces the from prefix of p with to; other paths are
+// returned unchanged.
+func RewritePath(p, from, to string) string {
+	if p == from {
+		return to
+	}
+	if strings.HasPrefix(p, from+string(filepath.Separator)) {
+		return to + p[len(from):]
+	}
+	return p
+}
+
+// RewriteFile replaces every path under from in the file at p with the same
+// path under to, keeping the file's mode, and reports whether it changed.
+func RewriteFile(p, from, to string) (bool, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	out := rewritePaths(b, from, to)
+	if bytes.Equal(out, b) {
+		return false, nil
+	}
+	st, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, out, st.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// rewritePaths replaces from wherever it appears as a whole path or path
+// prefix, so /home/a/.trusttls-old is not mistaken for /home/a/.trusttls.
+func rewritePaths(b []byte, from, to string) []byte {
+	re := regexp.MustCompile(regexp.QuoteMeta(from) + `([/"'\s;]|$)`)
+	return re.ReplaceAllFunc(b, func(m []byte) []byte {
+		return append([]byte(to), m[len(from):]...)
+	})
+}
+
+// UpgradeLayout brings a store written by an older version up to date and
+// returns the entries it changed: wildcard lineages kept under a literal
+// "*." name become "_wildcard." (see LineageName), and lineages with plain
+// files in live/ and timestamped archive folders get numbered versions.
+func UpgradeLayout(baseDir string) ([]string, error) {
+	var renamed []string
+	for _, sub := range []string{"live", "archive", "renewal"} {
+		entries, err := os.ReadDir(filepath.Join(baseDir, sub))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return renamed, err
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), "*.") {
+				continue
+			}
+			old := filepath.Join(baseDir, sub, e.Name())
+			nu := filepath.Join(baseDir, sub, LineageName(e.Name()))
+			if _, err := os.Lstat(nu); err == nil {
+				return renamed, fmt.Errorf("cannot rename %s: %s already exists", old, nu)
+			}
+			if err := os.Rename(old, nu); err != nil {
+				return renamed, err
+			}
+			renamed = append(renamed, nu)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(baseDir, "live"))
+	if err != nil && !os.IsNotExist(err) {
+		return renamed, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		before, _ := os.Lstat(filepath.Join(baseDir, "live", e.Name(), "cert.pem"))
+		if err := upgradeLineage(baseDir, e.Name()); err != nil {
+			return renamed, fmt.Errorf("upgrade %s: %w", e.Name(), err)
+		}
+		if before != nil && before.Mode()&os.ModeSymlink == 0 {
+			renamed = append(renamed, filepath.Join(baseDir, "live", e.Name()))
+		}
+	}
+	return renamed, nil
+}
+
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(to, rel)
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case d.IsDir():
+			return os.MkdirAll(dst, 0700)
+		}
+		return copyFile(p, dst)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func dirExists(p string) bool {
+	st, err := os.Stat(p)
+	return err == nil && st.IsDir()
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// CheckOwner refuses a store that belongs to another user or that every
+// user may write to. Hosting providers keep one store per customer and run
+// trusttls as that customer, so a run must not act on another customer's
+// certificates, nor root be led by links a customer planted into writing
+// outside the store. A store that does not exist yet passes.
+func CheckOwner(baseDir string) error {
+	st, err := os.Stat(baseDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid := os.Geteuid(); int(sys.Uid) != uid {
+		owner := userName(int(sys.Uid))
+		return fmt.Errorf("%s belongs to %s, not to %s running trusttls; run it as its owner (sudo -u %s trusttls ...) or give it --config-dir of your own", baseDir, owner, userName(uid), owner)
+	}
+	if st.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("%s can be written by every user; run 'chmod o-w %s' first", baseDir, baseDir)
+	}
+	return nil
+}
+
+// userName returns the login name of uid, or the number when it has none.
+func userName(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(uid)
+}
+
+// copyOwner gives dst the owner and group of src.
+func copyOwner(src, dst string) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if dt, err := os.Stat(dst); err == nil {
+		if d, ok := dt.Sys().(*syscall.Stat_t); ok && d.Uid == sys.Uid && d.Gid == sys.Gid {
+			return nil
+		}
+	}
+	return os.Chown(dst, int(sys.Uid), int(sys.Gid))
+}
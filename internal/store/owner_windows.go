@@ -0,0 +1,15 @@
+//go:build windows
+
+package store
+
+// CheckOwner does nothing on Windows, where the store is protected by the
+// ACL of the user's profile.
+func CheckOwner(baseDir string) error {
+	return nil
+}
+
+// copyOwner does nothing on Windows, where files inherit the ACL of their
+// directory.
+func copyOwner(src, dst string) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// PlaceholderDir returns where the self-signed placeholder served in place
+// of an expiring certificate is kept.
+func PlaceholderDir(baseDir, domain string) string {
+	return filepath.Join(baseDir, "live", LineageName(domain), "placeholder")
+}
+
+// SavePlaceholder writes the placeholder certificate and key of domain as
+// cert.pem, chain.pem, fullchain.pem and privkey.pem, so web server configs
+// pointing at live/<name>/ work unchanged when pointed at it. chain.pem
+// repeats the certificate: servers refuse an empty chain file.
+func SavePlaceholder(baseDir, domain string, certPEM, keyPEM []byte) error {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	dir := PlaceholderDir(baseDir, domain)
+	if err := ensureLineageDir(dir); err != nil {
+		return err
+	}
+	files := map[string][]byte{"cert": certPEM, "chain": certPEM, "fullchain": certPEM, "privkey": keyPEM}
+	for _, kind := range pemKinds {
+		path := filepath.Join(dir, kind+".pem")
+		tmp := path + ".tmp"
+		_ = FileSystem.Remove(tmp)
+		if err := writeNew(tmp, files[kind]); err != nil {
+			return err
+		}
+		if err := FileSystem.Rename(tmp, path); err != nil {
+			_ = FileSystem.Remove(tmp)
+			return err
+		}
+	}
+	return FileSystem.SyncDir(dir)
+}
+
+// DropPlaceholder removes the placeholder of domain.
+func DropPlaceholder(baseDir, domain string) error {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.RemoveAll(PlaceholderDir(baseDir, domain))
+}
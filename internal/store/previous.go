@@ -0,0 +1,59 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// PreviousDir returns where KeepPrevious links the certificate that was
+// current before the latest renewal.
+func PreviousDir(baseDir, domain string) string {
+	return filepath.Join(baseDir, "live", LineageName(domain), "previous")
+}
+
+// KeepPrevious points live/<name>/previous/*.pem at version n, so web server
+// configs can be switched back to the previous certificate without touching
+// the current one.
+func KeepPrevious(baseDir, domain string, n int) error {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	name := LineageName(domain)
+	dir := PreviousDir(baseDir, domain)
+	if err := ensureLineageDir(dir); err != nil {
+		return err
+	}
+	for _, kind := range pemKinds {
+		link := filepath.Join(dir, kind+".pem")
+		file := versionFile(kind, n)
+		if !fileExists(filepath.Join(baseDir, "archive", name, file)) {
+			if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		tmp := filepath.Join(dir, "."+kind+".pem.tmp")
+		_ = os.Remove(tmp)
+		if err := os.Symlink(filepath.Join("..", "..", "..", "archive", name, file), tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, link); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+// DropPrevious removes the links made by KeepPrevious. The archived files
+// stay.
+func DropPrevious(baseDir, domain string) error {
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.RemoveAll(PreviousDir(baseDir, domain))
+}
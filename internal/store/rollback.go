@@ -0,0 +1,80 @@
+package store
+
+import (
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+)
+
+// Version describes an archived version of a lineage.
+type Version struct {
+	N         int       `json:"version"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	HasKey    bool      `json:"has_key"`
+	Current   bool      `json:"current"` // live/ points at it
+}
+
+// ListVersions returns the archived versions of domain's lineage, oldest
+// first, with the certificate each holds.
+func ListVersions(baseDir, domain string) ([]Version, error) {
+	return Open(baseDir).Archive(domain)
+}
+
+// versionOf describes version n, holding cert.
+func versionOf(n int, cert *x509.Certificate, hasKey, current bool) Version {
+	return Version{
+		N:         n,
+		Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		HasKey:    hasKey,
+		Current:   current,
+	}
+}
+
+// VersionAt returns the newest version of domain's lineage issued at or
+// before t, the way the timestamped archive folders of older versions were
+// picked.
+func VersionAt(baseDir, domain string, t time.Time) (int, error) {
+	versions, err := ListVersions(baseDir, domain)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, v := range versions {
+		if !v.NotBefore.After(t) && v.N > n {
+			n = v.N
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%s: no certificate issued before %s", domain, t.Format(time.RFC3339))
+	}
+	return n, nil
+}
+
+// Activate points live/ of domain's lineage at the archived version n, for
+// rolling back to an earlier certificate or forward again. Versions are
+// never removed, so the one replaced stays available.
+func Activate(baseDir, domain string, n int) error {
+	name := LineageName(domain)
+	if !fileExists(filepath.Join(baseDir, "archive", name, versionFile("cert", n))) {
+		return fmt.Errorf("%s has no version %d", domain, n)
+	}
+	unlock, err := LockLineage(baseDir, domain)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer lockSave(name)()
+	defer interrupt.Hold()()
+	if err := activate(baseDir, name, n); err != nil {
+		return err
+	}
+	reindex(baseDir, name)
+	return nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SecretFiles lists the files under baseDir that store encryption seals:
+// ACME account keys and credentials, pending orders with their keys, the
+// keys of internal CAs, stored DNS provider credentials and remote webroot
+// passwords. Certificates and keys under live/ and archive/ are not
+// included, since web servers read them as they are.
+func SecretFiles(baseDir string) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(filepath.Join(baseDir, "accounts"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == filepath.Join(baseDir, "accounts") && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch {
+		case d.Name() == "account.key", d.Name() == "credentials.json":
+			out = append(out, p)
+		case filepath.Base(filepath.Dir(p)) == "orders" && strings.HasSuffix(p, ".json"):
+			out = append(out, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range []string{
+		filepath.Join(baseDir, "orders", "digicert", "*.json"),
+		filepath.Join(baseDir, "ca", "*", "ca-key.pem"),
+		filepath.Join(baseDir, "dns", "*.ini"),
+		filepath.Join(baseDir, "remote", "*.password"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, matches...)
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -6,42 +6,106 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/go-acme/lego/v4/certificate"
+	"github.com/trustctl/trusttls/internal/confdir"
 )
 
+// baseDir replaces the default store when set by SetBaseDir.
+var baseDir string
+
+// SetBaseDir makes DefaultBaseDir use the store configured in dir, for
+// --config-dir.
+func SetBaseDir(dir string) { baseDir = dir }
+
+// DefaultBaseDir returns the store certificates, accounts and keys are kept
+// in: state_dir from the config.yaml of ConfigDir, else ConfigDir itself.
+// Renewal configs and other settings stay in ConfigDir (see confdir).
 func DefaultBaseDir() string {
+	return confdir.StateDir(ConfigDir())
+}
+
+// ConfigDir returns the directory the store's settings are kept in: the
+// directory given to SetBaseDir, else TRUSTTLS_DIR, else ~/.trusttls.
+func ConfigDir() string {
+	if baseDir != "" { return baseDir }
+	if dir := os.Getenv("TRUSTTLS_DIR"); dir != "" {
+		// Renewal configs record the store, so it must not depend on
+		// where the command ran
+		if abs, err := filepath.Abs(dir); err == nil { return abs }
+		return dir
+	}
 	home, err := os.UserHomeDir()
 	if err != nil { return "/var/lib/trusttls" }
 	return filepath.Join(home, ".trusttls")
 }
 
 func ensureDir(p string, perm os.FileMode) error {
-	if err := os.MkdirAll(p, perm); err != nil { return err }
+	if err := FileSystem.MkdirAll(p, perm); err != nil { return err }
 	return os.Chmod(p, perm)
 }
 
-func SaveCertificate(baseDir, domain string, cert *certificate.Resource) (string, error) {
-	dir := filepath.Join(baseDir, "live", domain)
-	if err := ensureDir(dir, 0700); err != nil { return "", err }
-	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), cert.Certificate, 0600); err != nil { return "", err }
-	if err := os.WriteFile(filepath.Join(dir, "chain.pem"), cert.IssuerCertificate, 0600); err != nil { return "", err }
-	if err := os.WriteFile(filepath.Join(dir, "fullchain.pem"), append(cert.Certificate, cert.IssuerCertificate...), 0600); err != nil { return "", err }
-	if len(cert.PrivateKey) > 0 {
-		if err := os.WriteFile(filepath.Join(dir, "privkey.pem"), cert.PrivateKey, 0600); err != nil { return "", err }
+// SaveCertificate stores the PEM certificate, issuer chain and private key
+// of domain as a new version and points live/ at it.
+func SaveCertificate(baseDir, domain string, certPEM, chainPEM, keyPEM []byte) (string, error) {
+	return SaveBundle(baseDir, domain, Bundle{Cert: certPEM, Chain: chainPEM, Key: keyPEM})
+}
+
+// wildcardPrefix replaces "*." in lineage names. "*" would be expanded by
+// shells and by Include globs in web server configs, and "_" cannot occur in
+// a host name, so the name stays unambiguous.
+const wildcardPrefix = "_wildcard."
+
+// LineageName returns the file name used for domain under live/, archive/
+// and renewal/: "*.example.com" is kept as "_wildcard.example.com".
+func LineageName(domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return wildcardPrefix + domain[2:]
+	}
+	return domain
+}
+
+// LineageDomain reverses LineageName.
+func LineageDomain(name string) string {
+	if strings.HasPrefix(name, wildcardPrefix) {
+		return "*." + name[len(wildcardPrefix):]
+	}
+	return name
+}
+
+// ValidCertName checks a lineage name chosen with --cert-name: it becomes a
+// directory and file name and is typed on command lines, so only letters,
+// digits, dots, dashes and underscores are allowed.
+func ValidCertName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid certificate name %q", name)
 	}
-	latest := filepath.Join(baseDir, "archive", domain, time.Now().Format("20060102-150405"))
-	if err := ensureDir(latest, 0700); err != nil { return "", err }
-	_ = os.WriteFile(filepath.Join(latest, "cert.pem"), cert.Certificate, 0600)
-	_ = os.WriteFile(filepath.Join(latest, "chain.pem"), cert.IssuerCertificate, 0600)
-	_ = os.WriteFile(filepath.Join(latest, "fullchain.pem"), append(cert.Certificate, cert.IssuerCertificate...), 0600)
-	_ = os.WriteFile(filepath.Join(latest, "privkey.pem"), cert.PrivateKey, 0600)
-	return dir, nil
+	if strings.HasPrefix(name, wildcardPrefix) {
+		return fmt.Errorf("certificate name %q: the %s prefix is kept for wildcard certificates", name, wildcardPrefix)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return fmt.Errorf("certificate name %q: use only letters, digits, '.', '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// SaveBundle stores b as a new version of domain, with the ACME order it
+// came from, and returns the lineage's live/ directory.
+func SaveBundle(baseDir, domain string, b Bundle) (string, error) {
+	if _, err := Open(baseDir).Save(domain, b); err != nil { return "", err }
+	return filepath.Join(baseDir, "live", LineageName(domain)), nil
 }
 
+// LoadCertPaths returns where web servers find domain's files in live/.
+// Reading the certificate itself goes through Open, which also covers
+// other backends.
 func LoadCertPaths(baseDir, domain string) (cert, key, chain, fullchain string) {
-	dir := filepath.Join(baseDir, "live", domain)
+	dir := filepath.Join(baseDir, "live", LineageName(domain))
 	return filepath.Join(dir, "cert.pem"), filepath.Join(dir, "privkey.pem"), filepath.Join(dir, "chain.pem"), filepath.Join(dir, "fullchain.pem")
 }
 
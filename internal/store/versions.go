@@ -0,0 +1,567 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/trustctl/trusttls/internal/interrupt"
+)
+
+// Every issued certificate is kept in archive/<name>/ as numbered files
+// (cert1.pem, chain1.pem, fullchain1.pem, privkey1.pem, cert2.pem, ...), the
+// way certbot does it. live/<name>/.v<N>/ holds relative symlinks to the
+// files of version N, live/<name>/current links to the directory of the
+// version in use, and live/<name>/*.pem link through it (cert.pem ->
+// current/cert.pem). Switching versions is renaming one link over current,
+// so live/ never shows a mix of two versions or a half-written file.
+// Rolling back is pointing current at an older version; readers that need
+// the certificate and key of one version while it may be switched use
+// CurrentFiles. Lineages written before current existed link live/*.pem
+// straight into archive/ until their next switch.
+
+var pemKinds = []string{"cert", "chain", "fullchain", "privkey"}
+
+var (
+	versionFileRe = regexp.MustCompile(`^(cert|chain|fullchain|privkey)(\d+)\.pem$`)
+	versionDirRe  = regexp.MustCompile(`^\.v(\d+)$`)
+	legacyDirRe   = regexp.MustCompile(`^\d{8}-\d{6}$`)
+)
+
+// currentLink is the link in live/<name>/ to the links of the version in use.
+const currentLink = "current"
+
+// Versions returns the archived versions of domain's lineage, oldest first.
+func Versions(baseDir, domain string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(baseDir, "archive", LineageName(domain)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	var out []int
+	for _, e := range entries {
+		m := versionFileRe.FindStringSubmatch(e.Name())
+		if m == nil || m[1] != "cert" {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// CurrentVersion returns the version live/ points at, or 0 when the lineage
+// has no versioned certificate.
+func CurrentVersion(baseDir, domain string) (int, error) {
+	target, err := liveTarget(filepath.Join(baseDir, "live", LineageName(domain)), "cert.pem")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	m := versionFileRe.FindStringSubmatch(filepath.Base(target))
+	if m == nil {
+		return 0, fmt.Errorf("%s: unexpected link target %s", domain, target)
+	}
+	return strconv.Atoi(m[2])
+}
+
+// CurrentFiles returns the archived files of the version live/ of domain's
+// lineage points at, leaving out kinds that version lacks. A certificate and
+// key opened one after the other through live/ can belong to different
+// versions when current is switched in between; archived files never
+// change, so reading them cannot mix two. Lineages without versions are read
+// from live/.
+func CurrentFiles(baseDir, domain string) (Files, error) {
+	n, err := CurrentVersion(baseDir, domain)
+	if err != nil {
+		return Files{}, err
+	}
+	dir := filepath.Join(baseDir, "archive", LineageName(domain))
+	path := func(kind string) string {
+		p := filepath.Join(dir, versionFile(kind, n))
+		if n == 0 {
+			p = filepath.Join(baseDir, "live", LineageName(domain), kind+".pem")
+		}
+		if !fileExists(p) {
+			return ""
+		}
+		return p
+	}
+	f := Files{Cert: path("cert"), Chain: path("chain"), Fullchain: path("fullchain"), PrivKey: path("privkey")}
+	if f.Cert == "" {
+		return Files{}, fmt.Errorf("no certificate stored for %s: %w", domain, os.ErrNotExist)
+	}
+	return f, nil
+}
+
+// saveVersion archives files (keyed by kind) as the next version of name's
+// lineage and points live/ at it.
+func saveVersion(baseDir, name string, files map[string][]byte) (int, error) {
+	unlock, err := LockLineage(baseDir, LineageDomain(name))
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	defer lockSave(name)()
+	// An interrupt waits for the version to be complete and live/ updated
+	defer interrupt.Hold()()
+	if _, err := repairLive(baseDir, name); err != nil {
+		return 0, err
+	}
+	if err := upgradeLineage(baseDir, name); err != nil {
+		return 0, err
+	}
+	archive := filepath.Join(baseDir, "archive", name)
+	if err := ensureLineageDir(archive); err != nil {
+		return 0, err
+	}
+	versions, err := Versions(baseDir, LineageDomain(name))
+	if err != nil {
+		return 0, err
+	}
+	n := 1
+	if len(versions) > 0 {
+		n = versions[len(versions)-1] + 1
+	}
+	written := map[string][]byte{}
+	for _, kind := range pemKinds {
+		data, ok := files[kind]
+		if !ok {
+			continue
+		}
+		if err := writeNew(filepath.Join(archive, versionFile(kind, n)), data); err != nil {
+			return 0, err
+		}
+		written[versionFile(kind, n)] = data
+	}
+	if data, ok := files[metaKind]; ok {
+		if err := writeNew(filepath.Join(archive, versionFile(metaKind, n)), data); err != nil {
+			return 0, err
+		}
+		written[versionFile(metaKind, n)] = data
+	}
+	if err := addChecksums(archive, written); err != nil {
+		return 0, err
+	}
+	// Every file of the version must be on disk before any link points at
+	// it; repairLive relies on this after a crash.
+	if err := syncParents(baseDir, archive); err != nil {
+		return 0, err
+	}
+	err = activate(baseDir, name, n)
+	if err == nil {
+		reindex(baseDir, name)
+	}
+	return n, err
+}
+
+// activate points live/<name>/ at version n. The links to the files of
+// version n are made in live/<name>/.v<n>/ and flushed first; the switch is
+// then the rename of one link over live/<name>/current. live/<name>/*.pem
+// and meta.json link through current/ and only change when a kind appears
+// or disappears (the key when it was delivered elsewhere), or when a
+// lineage still linking straight into archive/ is converted. The links of
+// the version replaced are kept, for readers still going through them; older
+// ones are removed.
+func activate(baseDir, name string, n int) error {
+	live := filepath.Join(baseDir, "live", name)
+	if err := ensureLineageDir(live); err != nil {
+		return err
+	}
+	dir := filepath.Join(live, versionDir(n))
+	if err := ensureVersionDir(live, dir); err != nil {
+		return err
+	}
+	var have, missing []string
+	for _, kind := range liveKinds {
+		link := filepath.Join(dir, liveFile(kind))
+		file := versionFile(kind, n)
+		if _, err := os.Stat(filepath.Join(baseDir, "archive", name, file)); err != nil {
+			if err := FileSystem.Remove(link); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			missing = append(missing, kind)
+			continue
+		}
+		if err := replaceLink(filepath.Join("..", "..", "..", "archive", name, file), link); err != nil {
+			return err
+		}
+		have = append(have, kind)
+	}
+	// The links of the version must be on disk before current points at
+	// them
+	if err := syncParents(baseDir, dir); err != nil {
+		return err
+	}
+	previous, _ := os.Readlink(filepath.Join(live, currentLink))
+	if err := replaceLink(versionDir(n), filepath.Join(live, currentLink)); err != nil {
+		return err
+	}
+	for _, kind := range have {
+		link := filepath.Join(live, liveFile(kind))
+		target := filepath.Join(currentLink, liveFile(kind))
+		if t, err := os.Readlink(link); err == nil && t == target {
+			continue
+		}
+		if err := replaceLink(target, link); err != nil {
+			return err
+		}
+	}
+	for _, kind := range missing {
+		if err := FileSystem.Remove(filepath.Join(live, liveFile(kind))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := FileSystem.SyncDir(live); err != nil {
+		return err
+	}
+	return dropVersionDirs(live, versionDir(n), previous)
+}
+
+// replaceLink points link at target through a temporary link renamed over
+// it, so link is never missing.
+func replaceLink(target, link string) error {
+	tmp := filepath.Join(filepath.Dir(link), "."+filepath.Base(link)+".tmp")
+	_ = FileSystem.Remove(tmp)
+	if err := FileSystem.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := FileSystem.Rename(tmp, link); err != nil {
+		_ = FileSystem.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// dropVersionDirs removes the version link directories of live other than
+// current and previous, along with the links in them. Failing to is
+// harmless: they are only links, and the next switch tries again.
+func dropVersionDirs(live, current, previous string) error {
+	entries, err := os.ReadDir(live)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !versionDirRe.MatchString(e.Name()) || e.Name() == current || e.Name() == previous {
+			continue
+		}
+		dir := filepath.Join(live, e.Name())
+		links, _ := os.ReadDir(dir)
+		for _, l := range links {
+			_ = FileSystem.Remove(filepath.Join(dir, l.Name()))
+		}
+		_ = FileSystem.Remove(dir)
+	}
+	return nil
+}
+
+// ensureVersionDir creates the link directory dir of a version with the
+// mode and owner of live, which SetAccess may have opened to a web server,
+// so rolling back to a version whose links were dropped does not shut the
+// server out of its key.
+func ensureVersionDir(live, dir string) error {
+	if _, err := os.Lstat(dir); err == nil {
+		return ensureLineageDir(dir)
+	}
+	st, err := os.Stat(live)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(dir, st.Mode().Perm()); err != nil {
+		return err
+	}
+	return copyOwner(live, dir)
+}
+
+func versionDir(n int) string {
+	return ".v" + strconv.Itoa(n)
+}
+
+// liveTarget returns the archive file live/<name>/file links to, following
+// current/ and the version's link directory, relative to that directory as
+// the link holds it (../../../archive/<name>/cert3.pem). Lineages linking
+// straight into archive/ return their link as it is.
+func liveTarget(live, file string) (string, error) {
+	target, err := os.Readlink(filepath.Join(live, file))
+	if err != nil {
+		return "", err
+	}
+	if target != filepath.Join(currentLink, file) {
+		return target, nil
+	}
+	dir, err := os.Readlink(filepath.Join(live, currentLink))
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(filepath.Join(live, dir, file))
+}
+
+// linkedVersions returns the version each live/<name>/*.pem link points at.
+func linkedVersions(baseDir, name string) map[string]int {
+	out := map[string]int{}
+	for _, kind := range pemKinds {
+		target, err := liveTarget(filepath.Join(baseDir, "live", name), kind+".pem")
+		if err != nil {
+			continue
+		}
+		if m := versionFileRe.FindStringSubmatch(filepath.Base(target)); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			out[kind] = n
+		}
+	}
+	return out
+}
+
+// repairLive finishes a conversion to current/ interrupted by a crash, the
+// one time links can point at different versions now that a switch is a
+// single rename: they are all pointed at the newest one, whose files were
+// complete before its activation began. It reports whether anything was
+// changed.
+func repairLive(baseDir, name string) (bool, error) {
+	newest, mixed := mixedLinks(baseDir, name)
+	if !mixed {
+		return false, nil
+	}
+	return true, activate(baseDir, name, newest)
+}
+
+// mixedLinks reports whether the links of name point at different versions,
+// and the newest of them.
+func mixedLinks(baseDir, name string) (int, bool) {
+	newest, mixed := 0, false
+	for _, n := range linkedVersions(baseDir, name) {
+		if newest != 0 && n != newest {
+			mixed = true
+		}
+		if n > newest {
+			newest = n
+		}
+	}
+	return newest, mixed
+}
+
+// Repair runs repairLive for every lineage and returns the names of those
+// whose links were fixed.
+func Repair(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(baseDir, "live"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fixed []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		// Links may also be mixed because another run is switching them
+		// right now; once it has the lock, they are checked again
+		if _, mixed := mixedLinks(baseDir, e.Name()); !mixed {
+			continue
+		}
+		unlock, err := LockLineage(baseDir, LineageDomain(e.Name()))
+		if err != nil {
+			return fixed, err
+		}
+		unlockSave := lockSave(e.Name())
+		changed, err := repairLive(baseDir, e.Name())
+		unlockSave()
+		unlock()
+		if err != nil {
+			return fixed, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if changed {
+			fixed = append(fixed, LineageDomain(e.Name()))
+		}
+	}
+	return fixed, nil
+}
+
+// upgradeLineage converts a lineage written by older versions, with
+// timestamped archive folders and plain files in live/, to numbered
+// versions and symlinks. Lineages already converted are left alone. A
+// version's certificate is put in place last: until then Versions does not
+// list it, so a conversion a crash cut short gives it the same number when
+// it runs again.
+func upgradeLineage(baseDir, name string) error {
+	archive := filepath.Join(baseDir, "archive", name)
+	entries, err := os.ReadDir(archive)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var legacy []string
+	for _, e := range entries {
+		if e.IsDir() && legacyDirRe.MatchString(e.Name()) {
+			legacy = append(legacy, e.Name())
+		}
+	}
+	sort.Strings(legacy) // timestamps sort chronologically
+	for _, dir := range legacy {
+		n, err := nextVersion(baseDir, name)
+		if err != nil {
+			return err
+		}
+		for _, kind := range certLast {
+			src := filepath.Join(archive, dir, kind+".pem")
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			if err := FileSystem.Rename(src, filepath.Join(archive, versionFile(kind, n))); err != nil {
+				return err
+			}
+		}
+		if err := FileSystem.SyncDir(archive); err != nil {
+			return err
+		}
+		if err := removeTree(filepath.Join(archive, dir)); err != nil {
+			return err
+		}
+	}
+
+	live := filepath.Join(baseDir, "live", name)
+	st, err := os.Lstat(filepath.Join(live, "cert.pem"))
+	if err != nil || st.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	// live/ still holds plain files: link them to the newest version when it
+	// is the same certificate, otherwise archive them as a new version.
+	current := map[string][]byte{}
+	for _, kind := range pemKinds {
+		if b, err := os.ReadFile(filepath.Join(live, kind+".pem")); err == nil {
+			current[kind] = b
+		}
+	}
+	n, err := nextVersion(baseDir, name)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		if b, err := os.ReadFile(filepath.Join(archive, versionFile("cert", n-1))); err == nil && bytes.Equal(b, current["cert"]) {
+			// Keep files that only exist in live/, such as the key
+			for kind, data := range current {
+				if p := filepath.Join(archive, versionFile(kind, n-1)); !fileExists(p) {
+					if err := writeReplace(p, data); err != nil {
+						return err
+					}
+				}
+			}
+			return activate(baseDir, name, n-1)
+		}
+	}
+	if err := ensureLineageDir(archive); err != nil {
+		return err
+	}
+	for _, kind := range certLast {
+		if data, ok := current[kind]; ok {
+			if err := writeReplace(filepath.Join(archive, versionFile(kind, n)), data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := FileSystem.SyncDir(archive); err != nil {
+		return err
+	}
+	return activate(baseDir, name, n)
+}
+
+// certLast lists pemKinds with the certificate, by which Versions finds a
+// version, at the end.
+var certLast = []string{"chain", "fullchain", "privkey", "cert"}
+
+// nextVersion returns the number the next version of name gets.
+func nextVersion(baseDir, name string) (int, error) {
+	versions, err := Versions(baseDir, LineageDomain(name))
+	if err != nil || len(versions) == 0 {
+		return 1, err
+	}
+	return versions[len(versions)-1] + 1, nil
+}
+
+// removeTree removes dir and everything in it through FileSystem.
+func removeTree(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			err = removeTree(p)
+		} else {
+			err = FileSystem.Remove(p)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := FileSystem.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeReplace writes data to path through a temporary file renamed over
+// it, so path never holds part of data.
+func writeReplace(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	_ = FileSystem.Remove(tmp)
+	if err := writeNew(tmp, data); err != nil {
+		return err
+	}
+	return FileSystem.Rename(tmp, path)
+}
+
+func versionFile(kind string, n int) string {
+	if kind == metaKind {
+		return kind + strconv.Itoa(n) + ".json"
+	}
+	return kind + strconv.Itoa(n) + ".pem"
+}
+
+// liveFile returns the name of the link to kind in live/<name>/.
+func liveFile(kind string) string {
+	if kind == metaKind {
+		return MetaFile
+	}
+	return kind + ".pem"
+}
+
+// writeNew writes a file that must not exist yet, so an archived version is
+// never overwritten, and flushes it to disk.
+func writeNew(path string, data []byte) error {
+	f, err := FileSystem.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
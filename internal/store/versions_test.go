@@ -0,0 +1,337 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// crashFS is the real file system until ok operations have been made, and
+// then fails every one, like a machine that lost power at that point.
+type crashFS struct {
+	ok, ops int
+}
+
+func (c *crashFS) step() error {
+	c.ops++
+	if c.ops > c.ok {
+		return errCrash
+	}
+	return nil
+}
+
+func (c *crashFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := c.step(); err != nil {
+		return nil, err
+	}
+	f, err := osFS{}.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &crashFile{File: f, fs: c}, nil
+}
+
+func (c *crashFS) Rename(oldpath, newpath string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return osFS{}.Rename(oldpath, newpath)
+}
+
+func (c *crashFS) Symlink(oldname, newname string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return osFS{}.Symlink(oldname, newname)
+}
+
+func (c *crashFS) Remove(name string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return osFS{}.Remove(name)
+}
+
+func (c *crashFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return osFS{}.MkdirAll(path, perm)
+}
+
+func (c *crashFS) SyncDir(dir string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return osFS{}.SyncDir(dir)
+}
+
+type crashFile struct {
+	File
+	fs *crashFS
+}
+
+func (f *crashFile) Write(p []byte) (int, error) {
+	if err := f.fs.step(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *crashFile) Sync() error {
+	if err := f.fs.step(); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+// version returns the files of a version whose content names it.
+func version(tag string, kinds ...string) map[string][]byte {
+	files := map[string][]byte{}
+	for _, kind := range kinds {
+		files[kind] = []byte(kind + " " + tag + "\n")
+	}
+	return files
+}
+
+// linkDirectly writes files as version 1 of name in the layout of older
+// releases, with live/*.pem linking straight into archive/.
+func linkDirectly(t *testing.T, baseDir, name string, files map[string][]byte) {
+	archive := filepath.Join(baseDir, "archive", name)
+	live := filepath.Join(baseDir, "live", name)
+	for _, dir := range []string{archive, live} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for kind, data := range files {
+		if err := os.WriteFile(filepath.Join(archive, versionFile(kind, 1)), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", "..", "archive", name, versionFile(kind, 1)), filepath.Join(live, liveFile(kind))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// keepPlain writes files as name's lineage in the layout of the oldest
+// releases: a timestamped folder in archive/ for each of archived, and
+// plain copies of files in live/.
+func keepPlain(t *testing.T, baseDir, name string, files map[string][]byte, archived ...map[string][]byte) {
+	for i, version := range archived {
+		dir := filepath.Join(baseDir, "archive", name, fmt.Sprintf("2024%02d01-000000", i+1))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		for kind, data := range version {
+			if err := os.WriteFile(filepath.Join(dir, kind+".pem"), data, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	live := filepath.Join(baseDir, "live", name)
+	if err := os.MkdirAll(live, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for kind, data := range files {
+		if err := os.WriteFile(filepath.Join(live, kind+".pem"), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkLive fails unless every file readable through live/<name>/ belongs
+// to the one version live/ is on, and that version is complete in
+// archive/. saved holds the files each version was saved with.
+func checkLive(t *testing.T, baseDir, name string, saved map[int]map[string][]byte) {
+	t.Helper()
+	if st, err := os.Lstat(filepath.Join(baseDir, "live", name, "cert.pem")); err == nil && st.Mode().IsRegular() {
+		// Not converted yet: the plain files are left as they were
+		return
+	}
+	versions := map[int]bool{}
+	for _, n := range linkedVersions(baseDir, name) {
+		versions[n] = true
+	}
+	if len(versions) > 1 {
+		t.Fatalf("live/ links point at versions %v", versions)
+	}
+	n, err := CurrentVersion(baseDir, LineageDomain(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		return
+	}
+	files, ok := saved[n]
+	if !ok {
+		t.Fatalf("live/ is on version %d, which was never saved completely", n)
+	}
+	for kind, data := range files {
+		b, err := os.ReadFile(filepath.Join(baseDir, "archive", name, versionFile(kind, n)))
+		if err != nil || !bytes.Equal(b, data) {
+			t.Fatalf("archived %s of version %d is incomplete: %q, %v", kind, n, b, err)
+		}
+	}
+	for _, kind := range pemKinds {
+		b, err := os.ReadFile(filepath.Join(baseDir, "live", name, liveFile(kind)))
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(b, files[kind]) {
+			t.Fatalf("live/%s is %q on version %d", liveFile(kind), b, n)
+		}
+	}
+}
+
+// TestSaveVersionCrash crashes saveVersion after every number of file
+// system operations it makes and checks that, once Repair has run, live/
+// is on one complete version and the next save succeeds.
+func TestSaveVersionCrash(t *testing.T) {
+	all := []string{"cert", "chain", "fullchain", "privkey"}
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, baseDir, name string) map[int]map[string][]byte
+		next  map[string][]byte
+	}{
+		{
+			name:  "first version",
+			setup: func(*testing.T, string, string) map[int]map[string][]byte { return map[int]map[string][]byte{} },
+			next:  version("v1", all...),
+		},
+		{
+			name: "next version",
+			setup: func(t *testing.T, baseDir, name string) map[int]map[string][]byte {
+				v1 := version("v1", all...)
+				if _, err := saveVersion(baseDir, name, v1); err != nil {
+					t.Fatal(err)
+				}
+				return map[int]map[string][]byte{1: v1}
+			},
+			next: version("v2", all...),
+		},
+		{
+			name: "next version without its key",
+			setup: func(t *testing.T, baseDir, name string) map[int]map[string][]byte {
+				v1 := version("v1", all...)
+				if _, err := saveVersion(baseDir, name, v1); err != nil {
+					t.Fatal(err)
+				}
+				return map[int]map[string][]byte{1: v1}
+			},
+			next: version("v2", "cert", "chain", "fullchain"),
+		},
+		{
+			name: "next version after direct links",
+			setup: func(t *testing.T, baseDir, name string) map[int]map[string][]byte {
+				v1 := version("v1", all...)
+				linkDirectly(t, baseDir, name, v1)
+				return map[int]map[string][]byte{1: v1}
+			},
+			next: version("v2", all...),
+		},
+		{
+			name: "next version after timestamped folders",
+			setup: func(t *testing.T, baseDir, name string) map[int]map[string][]byte {
+				v1, v2 := version("v1", all...), version("v2", all...)
+				keepPlain(t, baseDir, name, v2, v1, v2)
+				return map[int]map[string][]byte{1: v1, 2: v2}
+			},
+			next: version("v3", all...),
+		},
+		{
+			name: "next version after plain files",
+			setup: func(t *testing.T, baseDir, name string) map[int]map[string][]byte {
+				v1 := version("v1", all...)
+				keepPlain(t, baseDir, name, v1)
+				return map[int]map[string][]byte{1: v1}
+			},
+			next: version("v2", all...),
+		},
+	}
+	defer func(fs FS) { FileSystem = fs }(FileSystem)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for ok := 0; ; ok++ {
+				baseDir := t.TempDir()
+				name := "example.com"
+				FileSystem = osFS{}
+				saved := tt.setup(t, baseDir, name)
+				// Live once its files are all archived, wherever the
+				// save stopped
+				saved[len(saved)+1] = tt.next
+
+				FileSystem = &crashFS{ok: ok}
+				_, err := saveVersion(baseDir, name, tt.next)
+				FileSystem = osFS{}
+				if err != nil && !errors.Is(err, errCrash) {
+					t.Fatalf("after %d operations: %v", ok, err)
+				}
+				if _, err := Repair(baseDir); err != nil {
+					t.Fatalf("after %d operations: repair: %v", ok, err)
+				}
+				checkLive(t, baseDir, name, saved)
+
+				recovered := version("recovered", all...)
+				n, serr := saveVersion(baseDir, name, recovered)
+				if serr != nil {
+					t.Fatalf("after %d operations: next save: %v", ok, serr)
+				}
+				saved[n] = recovered
+				checkLive(t, baseDir, name, saved)
+				if cur, _ := CurrentVersion(baseDir, LineageDomain(name)); cur != n {
+					t.Fatalf("after %d operations: next save left live/ on version %d, not %d", ok, cur, n)
+				}
+				if err == nil {
+					return
+				}
+			}
+		})
+	}
+}
+
+// TestActivateKeepsAccess rolls back to a version whose link directory was
+// dropped and checks that the directory comes back as open to the web
+// server's group as live/ is.
+func TestActivateKeepsAccess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes")
+	}
+	baseDir := t.TempDir()
+	name := "example.com"
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		if _, err := saveVersion(baseDir, name, version(tag, "cert", "chain", "fullchain", "privkey")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetAccess(baseDir, name, Access{UID: -1, GID: os.Getgid(), KeyMode: 0640}); err != nil {
+		t.Fatal(err)
+	}
+	live := filepath.Join(baseDir, "live", name)
+	if _, err := os.Stat(filepath.Join(live, versionDir(1))); !os.IsNotExist(err) {
+		t.Fatalf("the links of version 1 were not dropped: %v", err)
+	}
+	if err := Activate(baseDir, name, 1); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Stat(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.Stat(filepath.Join(live, versionDir(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode().Perm() != want.Mode().Perm() {
+		t.Fatalf("%s is %v, live/ is %v", versionDir(1), got.Mode().Perm(), want.Mode().Perm())
+	}
+	if got.Mode().Perm()&0050 != 0050 {
+		t.Fatalf("%s is %v, closed to the group", versionDir(1), got.Mode().Perm())
+	}
+}